@@ -6,7 +6,13 @@
 // create a runtime configuration file.
 package api
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // MaxRqsts is a hard-coded upper limit on how many total requests can
 // be made in a single test run. This limit is enforced regardless of
@@ -20,10 +26,94 @@ var MaxRqsts = 1000000
 // the total run duration.
 var MaxRunDuration = time.Duration(time.Hour * 3)
 
+// DefaultEarlyFailThreshold is the Endpoint.EarlyFailThreshold used when one isn't
+// specified
+const DefaultEarlyFailThreshold = 20
+
+// DefaultBodySizeClasses are the request body size class boundaries, in bytes,
+// used when LoadTestConfig.BodySizeClasses isn't specified. They result in
+// the classes <1KB, 1KB-10KB, 10KB-100KB, and >=100KB.
+var DefaultBodySizeClasses = []int64{1 << 10, 10 << 10, 100 << 10}
+
+// DefaultCacheHitHeaders are the response headers used to identify responses served
+// from a cache when LoadTestConfig.CacheHitHeaders isn't specified
+var DefaultCacheHitHeaders = []CacheHitHeader{
+	{Name: "Age"},
+	{Name: "X-Cache", Value: "HIT"},
+}
+
+// Rate is a number of requests per second. In a config it's either a number of
+// requests per second, e.g., 500, or a string of a number of requests per second,
+// minute, or hour, e.g., "500/s", "10/m", or "30/h", which is more natural for the
+// low rates of, e.g., a soak test.
+type Rate float64
+
+// rateUnits are the units of a Rate by their suffix
+var rateUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// ParseRate parses 's', a number of requests per second or a number of requests per
+// unit of time, e.g., "30/h", see Rate
+func ParseRate(s string) (Rate, error) {
+	n, unit := s, "s"
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		n, unit = s[:i], s[i+1:]
+	}
+	per, ok := rateUnits[strings.TrimSpace(unit)]
+	if !ok {
+		return 0, fmt.Errorf("the rate %q has an unknown unit, %q, it must be 's', 'm', or 'h'", s, unit)
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+	if err != nil {
+		return 0, fmt.Errorf("the rate %q isn't a number of requests, e.g., '30/h': %w", s, err)
+	}
+	return Rate(rate / per.Seconds()), nil
+}
+
+// UnmarshalJSON sets 'r' from either a number of requests per second or a string
+// parsed by ParseRate
+func (r *Rate) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var rate float64
+		if err := json.Unmarshal(b, &rate); err != nil {
+			return fmt.Errorf("a rate must be a number of requests per second or a string like '30/h', not %s", b)
+		}
+		*r = Rate(rate)
+		return nil
+	}
+	rate, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = rate
+	return nil
+}
+
 // Endpoint contains the information needed to send a request,
 // in the desired proportion to total requests, to a given
 // HTTP endpoint (e.g., someplace.com).
 type Endpoint struct {
+	// Name, if set, identifies the endpoint in LoadTestConfig.Phases and in the
+	// -only and -skip flags. It must be unique among the Endpoints.
+	Name string
+	// Tags, if set, are used to select groups of endpoints with the -only and
+	// -skip flags
+	Tags []string
+	// Description, if set, describes the endpoint. It's carried to the endpoint's
+	// EndpointDetail in the report.
+	Description string `json:",omitempty"`
+	// Disabled excludes the endpoint from the run, as if it weren't configured. It's
+	// listed in the RunSummary's DisabledEndpoints. At least one endpoint must be
+	// enabled.
+	Disabled bool `json:",omitempty"`
+	// Extends, if set, is the name of the LoadTestConfig.EndpointTemplates entry the
+	// endpoint inherits its fields from. Each field set on the endpoint replaces the
+	// inherited one, e.g., its Headers replace all of the template's Headers.
+	Extends string `json:",omitempty"`
 	// URL is the endpoint address
 	URL string
 	// Method is the HTTP Method
@@ -49,13 +139,319 @@ type Endpoint struct {
 	CertFile string
 	// Headers is an array of name-value pairs representing headers to send to the endpoint
 	Headers map[string]string
+	// UnixSocket, if set, is the path of a Unix domain socket that requests to the
+	// endpoint are sent to instead of the host in the URL. The URL's path and query
+	// are still used, e.g., a URL of http://localhost/users with a UnixSocket of
+	// /var/run/users.sock sends 'GET /users' to the server listening on the socket.
+	UnixSocket string
+	// PipelineDepth, when greater than 1, enables the experimental HTTP/1.1
+	// pipelining mode. Up to PipelineDepth requests are written to a single
+	// connection before any responses are read. Only idempotent methods
+	// without a request body (GET and HEAD) may be pipelined.
+	PipelineDepth int
+	// ProtocolVersion, if set, is the HTTP protocol version the endpoint's requests
+	// are sent with, ProtocolVersionHTTP10 or ProtocolVersionHTTP11, the default.
+	// HTTP/1.0 requests, e.g., for legacy backends, don't use keep-alive, so each
+	// request uses a new connection, and their bodies aren't chunked. HTTP/1.0 can't
+	// be used with PipelineDepth or with the -http-version 3 flag.
+	ProtocolVersion string `json:",omitempty"`
+	// Mode, if set, is how the endpoint's responses are read, EndpointModeSSE for a
+	// Server-Sent Events stream. The default reads each response in full.
+	Mode string `json:",omitempty"`
+	// SSE configures how the endpoint's event streams are read, it's required if
+	// the endpoint's Mode is EndpointModeSSE
+	SSE *SSEConfig `json:",omitempty"`
+	// KeepAliveProbe, if set, makes the endpoint a keep-alive probe rather than part
+	// of the load. Instead of making requests at its RqstPercent it measures how
+	// long idle connections to it survive, e.g., to verify a load balancer's idle
+	// timeout. Its RqstPercent must be 0.
+	KeepAliveProbe *KeepAliveProbe
+	// RateLimit, if greater than 0, is the most requests per second made to the
+	// endpoint, independently of LoadTestConfig.RqstRate and the other endpoints'
+	// limits. It can be given per minute or hour, see Rate. It can't be used with
+	// PipelineDepth.
+	RateLimit Rate
+	// Retry, if set, overrides LoadTestConfig.Retry for this endpoint
+	Retry *RetryPolicy
+	// Variants, if specified, are variations of the endpoint, e.g., the same path
+	// over HTTP and HTTPS, that requests rotate through. The results of each
+	// variant are reported separately as 'URL#Name'.
+	Variants []EndpointVariant
+	// LockGroup, if set, names a group of endpoints, e.g., those mutating the same
+	// test fixture, whose requests are limited to the group's width of in-flight
+	// requests at a time, 1 unless configured in LoadTestConfig.LockGroups. Time
+	// spent waiting for the group is reported as queue wait rather than as part of
+	// the request duration. It can't be used with PipelineDepth.
+	LockGroup string
+	// SuccessJSONPath, if set, is the '.' separated path of a field in the JSON
+	// response body, e.g., 'result.status', whose value must be SuccessJSONValue
+	// for the request to succeed. It's for APIs that always return a 200 but encode
+	// failures in the body, e.g., {"status": "error"}. Array elements are selected
+	// by index, e.g., 'results.0.status'. It can't be used with PipelineDepth.
+	SuccessJSONPath string
+	// SuccessJSONValue is the expected value of the field at SuccessJSONPath.
+	// Strings are compared without quotes, other values as JSON, e.g., 'true' or '0'.
+	SuccessJSONValue string
+	// SuccessExpr, if set, is an expression that must be true for a response to be
+	// successful, e.g., 'status == 200 && durationMs < 300 && contains(body, "ready")'.
+	// It can use the variables status, durationMs, bytes, and body, the functions
+	// contains(s, substr) and matches(s, regexp), comparisons, and &&, ||, and !.
+	// It can't be used with PipelineDepth.
+	SuccessExpr string
+	// ExpectedSHA256, if set, is the hex encoded SHA-256 digest the entire response
+	// body must match, e.g., for verifying static content served by a CDN. The body
+	// is hashed as it's read so it isn't retained. Responses whose body doesn't match,
+	// including truncated bodies and bodies cut short by MaxResponseBodyBytes, are
+	// counted as ChecksumMismatch errors. It can't be used with PipelineDepth.
+	ExpectedSHA256 string
+	// ExpectedContentType, if set, is the media type, e.g., 'application/json', of
+	// the endpoint's successful responses. Its parameters, e.g., 'charset', aren't
+	// compared. Successful responses with a body of any other type, e.g., an HTML
+	// error page returned with a 200, are counted as UnexpectedContentType errors. It
+	// can't be used with PipelineDepth.
+	ExpectedContentType string `json:",omitempty"`
+	// ExpectedOutcome, if set, makes the endpoint a negative test, e.g., of a WAF or
+	// rate limiter, whose requests are expected to fail in a particular way.
+	// Responses with the expected outcome are counted as successful. Anything else,
+	// including a 200, is counted as an UnexpectedOutcome error. It can't be used
+	// with SuccessJSONPath, SuccessExpr, ExpectedSHA256, or PipelineDepth.
+	ExpectedOutcome *ExpectedOutcome `json:",omitempty"`
+	// EarlyFailThreshold is the number of responses at the start of the run that,
+	// if they're all errors, cause requests to the endpoint to stop. This catches
+	// misconfigurations, like a bad auth header, without waiting for the whole run.
+	// DefaultEarlyFailThreshold is used if it isn't specified. 0 disables it.
+	EarlyFailThreshold *int
+	// CircuitBreaker, if set, pauses requests to the endpoint while its recent
+	// responses are mostly errors. It can't be used with PipelineDepth, a
+	// KeepAliveProbe, or the EndpointModeSSE Mode.
+	CircuitBreaker *CircuitBreaker `json:",omitempty"`
+	// GroupByHeader, if set, is the name of a response header, e.g., X-Served-By,
+	// whose values the endpoint's stats are broken down by, e.g., to find out if
+	// one of the server instances behind a load balancer is slower than the others
+	GroupByHeader string `json:",omitempty"`
+	// Crawl, if set, makes the endpoint's URL the seed of a crawl. The URLs linked
+	// from its responses are discovered and requested too, up to the Crawl's Depth.
+	// It can't be used with PipelineDepth, a KeepAliveProbe, a Mode, Variants, or a
+	// templated URL.
+	Crawl *Crawl `json:",omitempty"`
+	// OptimisticUpdate, if set, makes each of the endpoint's requests an optimistic
+	// concurrency update. The resource is read to capture its ETag, then the endpoint's
+	// request, e.g., a PATCH, is sent with an If-Match of the ETag. If the update
+	// conflicts, with a 412, it's retried with a fresh ETag. It can't be used with
+	// PipelineDepth, a KeepAliveProbe, a Mode, Variants, or a Crawl.
+	OptimisticUpdate *OptimisticUpdate `json:",omitempty"`
+	// RotatingHeaders, if set, are request headers, keyed by name, whose value rotates
+	// from request to request, e.g., an 'X-Experiment' header of 'control',
+	// 'variantA', and 'variantB'. They replace the endpoint's Headers of the same
+	// name. The endpoint's stats are broken down by the value each request was sent
+	// with. They can't be used with PipelineDepth, a KeepAliveProbe, a Mode, or an
+	// OptimisticUpdate.
+	RotatingHeaders map[string]RotatingHeader `json:",omitempty"`
+	// SummaryLabel, if set, is appended to the endpoint's URL in the reports, as
+	// 'URL#SummaryLabel', so its results are kept apart from those of the other
+	// endpoints with the same Method and URL. It's set to the Name of each of the
+	// duplicates allowed by LoadTestConfig.AllowDuplicateEndpoints.
+	SummaryLabel string `json:"-"`
+}
+
+// RotatingHeader orders, see RotatingHeader.Order
+const (
+	RotationRoundRobin = "RoundRobin"
+	RotationRandom     = "Random"
+)
+
+// RotatingHeaderPresetUserAgents is the RotatingHeader Preset of realistic browser,
+// mobile, and command line client User-Agent strings
+const RotatingHeaderPresetUserAgents = "UserAgents"
+
+// RotatingHeader configures the values an Endpoint's RotatingHeaders header rotates
+// through. Its values are its Values, followed by those of its File and its Preset.
+type RotatingHeader struct {
+	// Values are values of the header
+	Values []string `json:",omitempty"`
+	// File, if set, is the name of a file with values of the header, one per line.
+	// Blank lines are ignored.
+	File string `json:",omitempty"`
+	// Preset, if set, is a bundled list of values, RotatingHeaderPresetUserAgents
+	Preset string `json:",omitempty"`
+	// Order is how each request's value is chosen, RotationRoundRobin, the default,
+	// or RotationRandom
+	Order string `json:",omitempty"`
+	// Seed seeds the RotationRandom choices so they're repeatable from run to run.
+	// The time the run starts is used if it's 0.
+	Seed int64 `json:",omitempty"`
+}
+
+// DefaultOptimisticUpdateMaxConflictRetries is the number of times a conflicting
+// OptimisticUpdate is retried if its MaxConflictRetries isn't specified
+const DefaultOptimisticUpdateMaxConflictRetries = 3
+
+// OptimisticUpdate configures an endpoint's GET, capture the ETag, update with
+// If-Match loop. Each update is reported as a single response, whose duration
+// includes all of its GETs and attempts, and the conflicts are reported in the
+// EndpointDetail's OptimisticUpdates rather than as errors. An update that still
+// conflicts after MaxConflictRetries fails as a ConflictRetriesExhausted error.
+type OptimisticUpdate struct {
+	// GetURL, if set, is the URL of the resource read for its ETag, the endpoint's
+	// URL if it isn't set. It's requested with the endpoint's Headers and may use the
+	// same templates as the endpoint's URL.
+	GetURL string `json:",omitempty"`
+	// MaxConflictRetries is the most times a conflicting update is retried,
+	// DefaultOptimisticUpdateMaxConflictRetries if it isn't specified
+	MaxConflictRetries int `json:",omitempty"`
+}
+
+// DefaultCrawlMaxURLs is the most URLs a Crawl requests if its MaxURLs isn't specified
+const DefaultCrawlMaxURLs = 100
+
+// Crawl discovers the URLs linked from an endpoint's responses. The endpoint's
+// requests cycle through the URLs discovered so far, starting with the endpoint's URL,
+// the seed, and each successful response's links are added as they're discovered.
+// The discovered URLs are requested with the endpoint's Method, Headers, and RqstBody
+// and are reported as endpoints of their own.
+type Crawl struct {
+	// Depth is the most links followed from the seed, at least 1. E.g., at a Depth
+	// of 2 the URLs linked from the seed, and the URLs linked from them, are
+	// requested.
+	Depth int
+	// LinkHeader follows the URLs of each response's Link headers, e.g.,
+	// '<https://api.example.com/items?page=2>; rel="next"'
+	LinkHeader bool `json:",omitempty"`
+	// LinkJSONPath, if set, is the path of a field, as for SuccessJSONPath, in each
+	// JSON response body whose value is a URL, or an array of URLs, to follow, e.g.,
+	// 'links.next'. At least one of LinkHeader or LinkJSONPath is required.
+	LinkJSONPath string `json:",omitempty"`
+	// MaxURLs is the most URLs, including the seed, that are requested,
+	// DefaultCrawlMaxURLs if it isn't specified. Links discovered once it's reached
+	// aren't followed.
+	MaxURLs int `json:",omitempty"`
+	// AllowOtherHosts follows links to hosts other than the seed's. By default
+	// they aren't followed.
+	AllowOtherHosts bool `json:",omitempty"`
+}
+
+// The defaults of the CircuitBreaker fields that aren't specified
+const (
+	DefaultCircuitBreakerWindow   = 20
+	DefaultCircuitBreakerCooldown = "5s"
+)
+
+// CircuitBreaker stops requests to an endpoint whose recent responses are mostly
+// errors, e.g., because its service is down, so it isn't hammered while the other
+// endpoints continue to be tested. The endpoint's circuit opens when at least
+// ErrorRate of its Window most recent responses failed. While it's open no requests
+// are sent to the endpoint. After Cooldown the circuit closes and the endpoint's
+// error rate is measured afresh.
+type CircuitBreaker struct {
+	// ErrorRate is the fraction, greater than 0 and at most 1, e.g., 0.5, of the
+	// recent responses that must have failed to open the circuit
+	ErrorRate float64
+	// Window is the number of most recent responses the error rate is measured
+	// over, DefaultCircuitBreakerWindow if it isn't specified
+	Window int `json:",omitempty"`
+	// Cooldown is how long the circuit stays open, e.g., '10s',
+	// DefaultCircuitBreakerCooldown if it isn't specified
+	Cooldown string `json:",omitempty"`
+}
+
+// The supported Endpoint.Modes
+const (
+	// EndpointModeSSE reads each response as a Server-Sent Events stream, see
+	// SSEConfig
+	EndpointModeSSE = "sse"
+)
+
+// The supported SSEConfig.Reports
+const (
+	// SSEReportEvent reports each event as a response whose duration is the time
+	// to the stream's first event or, for the events after it, the time since the
+	// previous event
+	SSEReportEvent = "event"
+	// SSEReportConnection reports each stream as a response whose duration is the
+	// time to its first event
+	SSEReportConnection = "connection"
+)
+
+// SSEConfig configures how an Endpoint's Server-Sent Events streams are read. Each
+// of the endpoint's requests opens a stream and reads its events, the 'data:'
+// fields, until either Events have been read or Duration has passed, at least one of
+// which is required. The time to each stream's first event and the time between its
+// events are reported in the EndpointDetail's SSE.
+type SSEConfig struct {
+	// Events, if set, is the number of events read from each stream
+	Events int `json:",omitempty"`
+	// Duration, if set, is how long each stream is read, e.g., '30s', including
+	// any reconnects. It's expressed the same way as LoadTestConfig.RunDuration.
+	Duration string `json:",omitempty"`
+	// FirstEventTimeout is how long to wait for a stream's first event, e.g.,
+	// '10s', the default. A stream that times out fails with the
+	// ErrCategoryFirstEventTimeout category.
+	FirstEventTimeout string `json:",omitempty"`
+	// Report is how the events are reported, SSEReportEvent, the default, or
+	// SSEReportConnection
+	Report string `json:",omitempty"`
+	// MaxReconnects is the number of times a stream that's disconnected, before
+	// its Events or Duration, is reconnected. The reconnect request has a
+	// Last-Event-ID header with the ID of the last event received, if any. The
+	// default, 0, doesn't reconnect.
+	MaxReconnects int `json:",omitempty"`
+	// ReconnectDelay, if set, is how long to wait before reconnecting, e.g., '1s'.
+	// A 'retry:' field sent by the server overrides it.
+	ReconnectDelay string `json:",omitempty"`
+}
+
+// The supported Endpoint.ProtocolVersions
+const (
+	ProtocolVersionHTTP10 = "HTTP/1.0"
+	ProtocolVersionHTTP11 = "HTTP/1.1"
+)
+
+// EndpointVariant overrides the scheme, host, port, or TLS options of an Endpoint.
+// Everything else is inherited from the Endpoint.
+type EndpointVariant struct {
+	// Name identifies the variant. It must be unique among the Endpoint's variants.
+	Name string
+	// Scheme, if set, overrides the scheme of the Endpoint's URL, e.g., 'https'
+	Scheme string
+	// Host, if set, overrides the host name of the Endpoint's URL
+	Host string
+	// Port, if set, overrides the port of the Endpoint's URL
+	Port string
+	// KeyFile, if set, overrides the KeyFile used by the Endpoint. See
+	// Endpoint.KeyFile.
+	KeyFile string
+	// CertFile, if set, overrides the CertFile used by the Endpoint. See
+	// Endpoint.CertFile.
+	CertFile string
+}
+
+// RetryPolicy describes when a failed request is retried. Each of the conditions
+// that trigger a retry can be enabled independently. Only idempotent requests are
+// retried unless RetryNonIdempotent is set, since retrying a request like a POST
+// that timed out may result in it being processed twice.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of times a request is retried. A value
+	// of 0, the default, disables retries.
+	MaxRetries int
+	// OnConnError retries requests that failed due to a connection error,
+	// e.g., the connection was refused or reset
+	OnConnError bool
+	// OnTimeout retries requests that timed out. Requests abandoned at the
+	// SoftDeadline are never retried.
+	OnTimeout bool
+	// OnStatus is the list of HTTP statuses (e.g., 502, 503) that are retried
+	OnStatus []int
+	// RetryNonIdempotent allows requests using non-idempotent methods, like
+	// POST and PATCH, to be retried
+	RetryNonIdempotent bool
 }
 
 // LoadTestConfig contains all the information needed to configure
 // and execute a load test run
 type LoadTestConfig struct {
-	// RqstRate is the desired overall requests per second
-	RqstRate int
+	// RqstRate is the desired overall requests per second. It can be given per minute
+	// or hour, see Rate.
+	RqstRate Rate
 	// MaxConcurrentRqsts is the overall number of simulataneously
 	// running requests
 	MaxConcurrentRqsts int
@@ -77,6 +473,507 @@ type LoadTestConfig struct {
 	// certificate. It will only be used if it has a non-empty value. It can be
 	// overridden, along with the KeyFile, at the Endpoint level.
 	CertFile string
+	// SoftDeadline is the longest a request is allowed to take before the client
+	// gives up on it. It's expressed the same way as RunDuration. Requests
+	// exceeding it are cancelled and counted as 'AbandonedSlow' rather than as
+	// timeouts. An empty value or "0s" disables the soft deadline.
+	SoftDeadline string
+	// ResponseHeaderTimeout is the longest the client waits for the response
+	// headers after sending a request. It's expressed the same way as RunDuration.
+	// Requests exceeding it are counted as 'ResponseHeaderTimeout' errors. An empty
+	// value or "0s" disables it.
+	ResponseHeaderTimeout string
+	// ReadIdleTimeout is the longest the client waits to receive data while reading
+	// a response, e.g., from a server that stalls part way through sending the
+	// response body. It's expressed the same way as RunDuration. Requests exceeding
+	// it are counted as 'ReadIdleTimeout' errors. Connections idle for longer than
+	// ReadIdleTimeout are closed. An empty value or "0s" disables it.
+	ReadIdleTimeout string
+	// MaxResponseBodyBytes, if greater than 0, is the most of each response body
+	// that's read. The rest of a larger body is discarded without being read, and
+	// the response is counted as body limited. Closing the response early prevents
+	// its connection being reused. It isn't applied to pipelined endpoints.
+	MaxResponseBodyBytes int64
+	// FailOnMalformedURL ends the run when a request's URL, after rendering any
+	// templates, is malformed. By default the request is skipped, counted as a
+	// MalformedURL error, and the run continues.
+	FailOnMalformedURL bool
+	// Retry is the policy for retrying failed requests. It can be overridden at
+	// the Endpoint level. By default requests aren't retried.
+	Retry RetryPolicy
+	// OutlierPolicy, if set, excludes outlier request latencies from a second,
+	// parallel, set of latency stats. The raw stats are always reported.
+	OutlierPolicy *OutlierPolicy
+	// ExpectedStatusDistribution, if specified, are the bounds on the fractions of
+	// responses with given HTTP statuses, e.g., at least 0.99 of the responses are
+	// 200s and none are 5xxs. The run fails if any of them aren't met.
+	ExpectedStatusDistribution []StatusExpectation
+	// MaxP99, if set, is the highest acceptable run-wide P99 latency of the
+	// successful requests, e.g., '250ms'. It's expressed the same way as
+	// RunDuration. The run fails if the P99 latency is greater.
+	MaxP99 string
+	// ApdexTarget, if set, is the latency, T, requests are expected to complete
+	// within, e.g., '300ms', to compute the run's, and each endpoint's, Apdex score.
+	// Requests completing within T are satisfied, within 4T are tolerating, and
+	// the rest, and failed requests, are frustrated. It's expressed the same way
+	// as RunDuration.
+	ApdexTarget string `json:",omitempty"`
+	// SimulateClientCache, if true, has each worker cache the responses to its GET
+	// requests the way a browser does, so the target sees the load real clients
+	// would generate. A cached response is used, without sending the request,
+	// until its 'Cache-Control: max-age' expires. It's then revalidated with a
+	// conditional request using its ETag or Last-Modified header. Responses with
+	// 'Cache-Control: no-store' aren't cached.
+	SimulateClientCache bool `json:",omitempty"`
+	// ConditionalRequests, if true, has each worker remember the ETag of the last
+	// response to each of its GET and HEAD requests' URLs and send it as the
+	// If-None-Match header of its next request to the URL. Unlike
+	// SimulateClientCache every request is sent. The 304 Not Modified responses
+	// are counted in each endpoint's NotModifiedCount.
+	ConditionalRequests bool `json:",omitempty"`
+	// SLOs, if set, are latency objectives the run's successful requests must
+	// meet, either over the whole run or over every window of the run, e.g., P95
+	// within 300ms over every 1 minute window. The run fails if any of them aren't
+	// met.
+	SLOs []SLORule `json:",omitempty"`
+	// RollingSummaryInterval, if set, is how often a summary of the run is written
+	// while the run is in progress, e.g., '5m'. It's expressed the same way as
+	// RunDuration. It's intended for long running tests.
+	RollingSummaryInterval string
+	// RollingSummaryMode is either 'cumulative', the default, to summarize the run
+	// from its start, or 'interval' to summarize only the most recent interval
+	RollingSummaryMode string
+	// SummaryKey controls how responses are grouped into endpoints in the summary.
+	// It's 'full', the default, to group them by their entire URL, 'path' to
+	// ignore the query, or 'pathAndQueryKeys' to ignore the values, but not the
+	// names, of the query parameters.
+	SummaryKey string
+	// URLPathTemplates, if specified, are path templates, e.g., '/users/{id}', that
+	// responses whose URL's path matches are grouped under, before applying the
+	// SummaryKey. Each '{name}' segment matches any single path segment, e.g., both
+	// '/users/123' and '/users/456' are grouped under '/users/{id}'. The first
+	// matching template is used.
+	URLPathTemplates []string `json:",omitempty"`
+	// BodySizeClasses are the ascending boundaries, in bytes, of the request body
+	// size classes used to report latency by request size. Each boundary is the
+	// exclusive upper bound of one class and the inclusive lower bound of the
+	// next. DefaultBodySizeClasses is used if none are specified.
+	BodySizeClasses []int64
+	// CacheHitHeaders are the response headers identifying responses served from a
+	// cache. A response matching any of them is a cache hit. DefaultCacheHitHeaders
+	// is used if none are specified.
+	CacheHitHeaders []CacheHitHeader
+	// InstanceHeader, if set, is the name of a response header, e.g., Server or
+	// X-Served-By, identifying the server instance, e.g., behind a load balancer, that
+	// served the response. The number of responses served by each instance is
+	// reported in RunSummary.InstanceDist.
+	InstanceHeader string `json:",omitempty"`
+	// LockGroups is the maximum number of in-flight requests of each lock group,
+	// see Endpoint.LockGroup, keyed by name. Lock groups that aren't specified
+	// allow a single in-flight request.
+	LockGroups map[string]int
+	// RecordResponseHeaders are the names of the response headers, e.g., X-Request-ID,
+	// recorded with each response in the results log. Other headers aren't recorded.
+	RecordResponseHeaders []string
+	// TraceIDHeader, if set, is the name of the header, e.g., X-Request-ID, with each
+	// request's trace ID. The response header is used, or the request header if the
+	// response doesn't have it. The reported latency percentiles are linked to
+	// sample requests, exemplars, by their trace IDs.
+	TraceIDHeader string
+	// RequestIDHeader, if set, is the name of a header, e.g., X-Request-ID, set to a
+	// unique ID on each request. A proxy in front of the server, or the server, is
+	// expected to echo it back in the response, successful responses that don't are
+	// counted by endpoint. It's also the TraceIDHeader if that isn't set. It isn't
+	// applied to pipelined or SSE endpoints.
+	RequestIDHeader string
+	// TracePropagation injects a W3C traceparent header, starting a new trace, into
+	// each request so the backend's tracing can correlate its traces with the load
+	// test's requests. Requests that already have a traceparent header keep it. The
+	// trace ID is used as the request's trace ID if TraceIDHeader isn't set. It
+	// isn't applied to pipelined endpoints.
+	TracePropagation bool
+	// HeaderStats measures the number and size of the headers of each request and
+	// its response, e.g., to quantify header bloat. Their averages are reported in
+	// the RunSummary's HeaderStats. It isn't applied to pipelined or SSE endpoints.
+	HeaderStats bool
+	// Phases, if specified, run sequentially, each making requests to its own
+	// subset of the Endpoints for its own duration or number of requests. Phases
+	// replace the RunDuration and NumRequests of the run, either can still be
+	// specified to bound the entire run.
+	Phases []Phase
+	// PhasePause is how long to pause between Phases, expressed the same way as
+	// RunDuration
+	PhasePause string
+	// ReplayLog, if set, is the path of a replay log, a JSON encoded ReplayEntry per
+	// line. The requests in the log are sent instead of those described by Endpoints,
+	// with the same gaps between them as when they were recorded. The run ends when
+	// the log is exhausted or RunDuration expires, whichever comes first.
+	ReplayLog string
+	// ReplaySpeed scales the recorded gaps between the requests in ReplayLog, e.g.,
+	// 2 replays the log twice as fast as it was recorded. The default is 1.
+	ReplaySpeed float64
 	// Endpoints is the set of endpoints (Endpoint) to make requests to
 	Endpoints []Endpoint
+	// EndpointTemplates are partial Endpoints, keyed by name, that Endpoints, or
+	// other templates, inherit from using Extends. They're resolved when the config
+	// is loaded, after which they're cleared and only the resolved Endpoints are used.
+	EndpointTemplates map[string]Endpoint `json:",omitempty"`
+	// AllowDuplicateEndpoints allows more than one of the Endpoints to have the same
+	// Method and URL, e.g., with different bodies. Each of them without a Name is
+	// given one, 'endpoints[i]' where 'i' is its index in Endpoints, and their results
+	// are reported separately, under 'URL#Name'. By default duplicates are an error.
+	AllowDuplicateEndpoints bool `json:",omitempty"`
+	// UniqueIntRanges configures the named counters used by the 'uniqueInt'
+	// template function, keyed by counter name. Counters referenced by a
+	// template but not configured here start at 0 and are unbounded.
+	UniqueIntRanges map[string]UniqueIntRange
+	// DataFile, if set, is a CSV file whose rows populate the endpoint templates,
+	// e.g., with a username and password. Its first row names the columns, e.g.,
+	// 'username', which are used in the templates as '{{ .username }}'. Each
+	// request uses a row chosen according to DataFileOrder.
+	DataFile string
+	// DataFileOrder is how the rows of the DataFile are chosen, either
+	// DataFileSequential, the default, or DataFileRandom
+	DataFileOrder string
+	// ClockSkew, if set, is the most each simulated client's clock is offset from
+	// the actual time, e.g., '30s'. It's expressed the same way as RunDuration. Each
+	// client's offset is chosen at random, from -ClockSkew to ClockSkew, and applied
+	// to the time template functions, 'now', 'unixTime', and 'httpDate', e.g., for a
+	// Date header or a signed timestamp.
+	ClockSkew string
+	// WarmupDuration, if set, is how long at the start of the run, e.g., '30s', the
+	// responses are discarded rather than summarized, e.g., while the target's caches
+	// warm up. It's expressed the same way as RunDuration. The discarded responses are
+	// counted in the RunSummary's WarmupDiscarded, and the run's other totals, its
+	// duration, and its rate don't include them.
+	WarmupDuration string `json:",omitempty"`
+	// WarmupConnections is the number of connections opened to each host of the
+	// Endpoints before the run starts, so that the run's requests don't include
+	// setting them up. Each is opened by a HEAD request to the host's root, '/',
+	// that isn't reported. Endpoints with their own CertFile, UnixSocket, or
+	// Variants use their own connections and aren't warmed up. HTTP/2 and HTTP/3
+	// requests share a connection so only one is opened per host.
+	WarmupConnections int
+	// PrewarmConnections warms up MaxConcurrentRqsts connections to each host, as
+	// if WarmupConnections was MaxConcurrentRqsts, so that none of the run's
+	// requests include setting up a connection. It can't be used with
+	// WarmupConnections.
+	PrewarmConnections bool
+	// MaxConnsPerHost, if set, limits the connections to each host. Requests wait
+	// for a connection while they're all in use, and those waits are reported in
+	// the RunSummary's PoolSaturation. The default, 0, doesn't limit them. It
+	// doesn't apply to HTTP/3.
+	MaxConnsPerHost int `json:",omitempty"`
+	// WarmupMethod is the method of the requests that warm up the connections,
+	// HEAD, the default, or OPTIONS, e.g., for servers that don't support HEAD
+	// requests to '/'
+	WarmupMethod string
+	// StopOnFirstFailure ends the run as soon as any request fails, e.g., while
+	// debugging a flaky endpoint. The failed request and its response, including
+	// the first 64KiB of its body, are reported in the RunSummary's FirstFailure.
+	StopOnFirstFailure bool
+	// CanaryBaseURLs, if set, compares two builds of the service under test, e.g.,
+	// behind different host names, by splitting each Endpoint's requests between
+	// them during the same run. The builds' results are compared in the report's
+	// Canary.
+	CanaryBaseURLs *CanaryBaseURLs `json:",omitempty"`
+	// Budget, if set, caps the total requests or bytes of the run, e.g., against
+	// metered cloud endpoints. No more requests are sent once it's reached.
+	Budget *Budget `json:",omitempty"`
+	// ResponseHook, if set, is an external command that validates a sample of the
+	// successful responses, for validation too custom for a SuccessExpr, e.g.,
+	// verifying a signed JWT in the response body
+	ResponseHook *ResponseHook `json:",omitempty"`
+}
+
+// Budget caps the cost of a run. Requests are counted, across all endpoints, as their
+// responses are received, or fail, and bytes as the bodies of the requests and their
+// responses are transferred. Once either MaxTotalRequests or MaxTotalBytes is
+// reached, whichever comes first, no more requests are sent. The requests already in
+// flight still complete, so the run overshoots its budget by at most one request per
+// concurrent request, or per pipelined batch. The overshoot is reported in the
+// RunSummary's BudgetExceeded.
+type Budget struct {
+	// MaxTotalRequests, if greater than 0, is the most requests the run may make
+	MaxTotalRequests int64
+	// MaxTotalBytes, if greater than 0, is the most bytes the run may transfer
+	MaxTotalBytes int64
+	// PerWorker splits MaxTotalRequests equally between the workers, the concurrent
+	// requestors, rather than letting them share it. Otherwise a fast worker can
+	// make most of the requests, biasing which requests complete. It can't be used
+	// with Phases or a ReplayLog.
+	PerWorker bool
+}
+
+// The limits of a Budget, as reported by BudgetExceeded.Limit
+const (
+	BudgetMaxTotalRequests = "MaxTotalRequests"
+	BudgetMaxTotalBytes    = "MaxTotalBytes"
+)
+
+// The defaults of the ResponseHook fields that aren't specified
+const (
+	DefaultResponseHookSampleRate    = 1.0
+	DefaultResponseHookTimeout       = "1s"
+	DefaultResponseHookMaxConcurrent = 4
+)
+
+// ResponseHook is an external command run for a sample of the successful responses
+// with a HookResponse, as JSON, on its stdin. If the command exits with a non-zero
+// status the response fails validation and is counted as a HookRejected error, the
+// command's output is the error message. A command that can't be run, or exceeds
+// Timeout, doesn't affect the response, it's counted as a failure in the RunSummary's
+// ResponseHookStats. The hook is run by the requestor while it waits to send its next
+// request, so a slow hook lowers the request rate. It isn't applied to pipelined,
+// SSE, or negative test endpoints.
+type ResponseHook struct {
+	// Command is the command and its arguments, e.g., ["./verify-jwt", "key.pem"]
+	Command []string
+	// SampleRate is the fraction, greater than 0 and at most 1, of the successful
+	// responses that are validated, DefaultResponseHookSampleRate if it isn't
+	// specified
+	SampleRate float64 `json:",omitempty"`
+	// Timeout is the longest the command may run, e.g., '500ms',
+	// DefaultResponseHookTimeout if it isn't specified
+	Timeout string `json:",omitempty"`
+	// MaxConcurrent is the most commands that may run at once,
+	// DefaultResponseHookMaxConcurrent if it isn't specified. Sampled responses
+	// received while MaxConcurrent commands are running aren't validated, they're
+	// counted as skipped.
+	MaxConcurrent int `json:",omitempty"`
 }
+
+// HookResponse is the description of a response a ResponseHook's command receives
+type HookResponse struct {
+	URL    string
+	Method string
+	Status int
+	// Header are the response's headers
+	Header map[string][]string
+	// Body is the response body, up to its first MiB. Bodies that aren't valid
+	// UTF-8 are mangled by their JSON encoding.
+	Body string
+	// DurationNanos is how long the request took, including reading the response
+	DurationNanos time.Duration
+}
+
+// CanaryBaseURLs are the base URLs, e.g., 'https://canary.example.com', of the two
+// builds compared by a canary run. The scheme, host, and port of each request's URL
+// are replaced by those of A or B, the rest of the URL is unchanged. Endpoints with
+// Variants, a UnixSocket, a PipelineDepth, or a KeepAliveProbe can't be compared.
+type CanaryBaseURLs struct {
+	A string
+	B string
+	// BPercent is the percentage of each Endpoint's requests sent to B, from 1 to
+	// 99. The default, 0, splits the requests evenly, alternating between A and B.
+	BPercent int `json:",omitempty"`
+}
+
+// The builds, or variants, of a canary run, see CanaryBaseURLs
+const (
+	CanaryA = "A"
+	CanaryB = "B"
+)
+
+// Orders of LoadTestConfig.DataFileOrder
+const (
+	// DataFileSequential uses the rows in order, starting over after the last row
+	DataFileSequential = "sequential"
+	// DataFileRandom uses a random row for each request
+	DataFileRandom = "random"
+)
+
+// Modes of LoadTestConfig.RollingSummaryMode
+const (
+	// RollingSummaryCumulative summarizes the run from its start
+	RollingSummaryCumulative = "cumulative"
+	// RollingSummaryInterval summarizes the most recent RollingSummaryInterval
+	RollingSummaryInterval = "interval"
+)
+
+// Modes of LoadTestConfig.SummaryKey
+const (
+	// SummaryKeyFull groups responses by their entire URL
+	SummaryKeyFull = "full"
+	// SummaryKeyPath groups responses by their URL without its query, e.g.,
+	// '/search?q=a' and '/search?q=b' are both '/search'
+	SummaryKeyPath = "path"
+	// SummaryKeyPathAndQueryKeys groups responses by their URL with the values of
+	// its query parameters replaced by '*', e.g., '/search?q=a' and
+	// '/search?q=b' are both '/search?q=*'
+	SummaryKeyPathAndQueryKeys = "pathAndQueryKeys"
+)
+
+// Methods used by an OutlierPolicy to identify outliers
+const (
+	// OutlierIQR identifies latencies more than Multiplier times the
+	// interquartile range above the 75th percentile as outliers
+	OutlierIQR = "iqr"
+	// OutlierCutoff identifies latencies greater than Cutoff as outliers
+	OutlierCutoff = "cutoff"
+)
+
+// DefaultOutlierIQRMultiplier is the OutlierPolicy.Multiplier used when one isn't
+// specified
+const DefaultOutlierIQRMultiplier = 1.5
+
+// Phase is a period of a run during which requests are made to a subset of the
+// Endpoints, e.g., a phase loading a database via POSTs followed by a phase
+// measuring read latency via GETs
+type Phase struct {
+	// Name identifies the phase in the report. It must be unique among the phases.
+	Name string
+	// Endpoints are the Endpoints requests are made to during the phase
+	Endpoints []PhaseEndpoint
+	// RunDuration is how long the phase runs, expressed the same way as
+	// LoadTestConfig.RunDuration. Only one of RunDuration or NumRequests can be
+	// specified.
+	RunDuration string
+	// NumRequests is the total number of requests made during the phase
+	NumRequests int
+}
+
+// KeepAliveProbe configures an endpoint's keep-alive probe. For each of the IdleGaps,
+// Connections connections are each sent a request, left idle for the gap, and sent a
+// second request to see whether the connection was reused.
+type KeepAliveProbe struct {
+	// Connections is the number of connections probed for each of the IdleGaps
+	Connections int
+	// IdleGaps are how long connections are left idle, e.g., ["10s", "30s", "60s"].
+	// They're expressed the same way as LoadTestConfig.RunDuration. All the gaps
+	// are probed at the same time so the probe takes as long as the longest gap.
+	IdleGaps []string
+}
+
+// PhaseEndpoint identifies an Endpoint active during a Phase
+type PhaseEndpoint struct {
+	// Name is the Endpoint's Name
+	Name string
+	// RqstPercent, if set, overrides the Endpoint's RqstPercent during the phase.
+	// The RqstPercent of all of a phase's endpoints must add to 100.
+	RqstPercent int
+}
+
+// ReplayEntry is a single request recorded in a replay log
+type ReplayEntry struct {
+	// Timestamp is when the request was originally sent
+	Timestamp time.Time
+	// Method is the request's HTTP method
+	Method string
+	// URL is the request's URL
+	URL string
+	// RqstBody is the request's body, if any
+	RqstBody string
+	// Headers are the request's headers
+	Headers map[string]string
+}
+
+// CacheHitHeader describes a response header indicating the response was served from
+// a cache, e.g., by a CDN
+type CacheHitHeader struct {
+	// Name is the name of the header, e.g., 'X-Cache'
+	Name string
+	// Value, if set, must be contained in the header's value, ignoring case, for the
+	// response to be a cache hit, e.g., 'HIT' matches 'X-Cache: TCP_HIT from edge'.
+	// If it isn't set the presence of the header indicates a cache hit.
+	Value string
+}
+
+// OutlierPolicy describes how outlier request latencies, e.g., those caused by long
+// GC pauses on the target, are identified
+type OutlierPolicy struct {
+	// Method is either 'iqr' or 'cutoff'
+	Method string
+	// Multiplier is the number of interquartile ranges above the 75th percentile
+	// beyond which a latency is an outlier. It's only used by the 'iqr' method and
+	// defaults to DefaultOutlierIQRMultiplier.
+	Multiplier float64 `json:",omitempty"`
+	// Cutoff is the latency beyond which a latency is an outlier, e.g., '2s'. It's
+	// only used, and is required, by the 'cutoff' method.
+	Cutoff string `json:",omitempty"`
+}
+
+// StatusExpectation bounds the fraction of all the responses, including those that
+// failed without a status, that have an HTTP status or class of statuses
+type StatusExpectation struct {
+	// Status is either an HTTP status, e.g., '200', or a class of statuses, e.g., '5xx'
+	Status string
+	// MinFraction is the smallest fraction of the responses, from 0 to 1, that
+	// must have Status
+	MinFraction float64 `json:",omitempty"`
+	// MaxFraction, if set, is the largest fraction of the responses, from 0 to 1,
+	// that may have Status, e.g., 0 for none
+	MaxFraction *float64 `json:",omitempty"`
+}
+
+// SLORule is a latency objective, a percentile of the successful requests'
+// latencies that must be within MaxLatency
+type SLORule struct {
+	// Name, if set, identifies the rule in the results. It defaults to a
+	// description of the rule, e.g., 'P95 <= 300ms over every 1m0s window'.
+	Name string `json:",omitempty"`
+	// Percentile is the percentile, from 1 to 100, of the latencies checked, e.g.,
+	// 95 for the P95 latency
+	Percentile int
+	// MaxLatency is the highest acceptable latency at Percentile, e.g., '300ms'.
+	// It's expressed the same way as RunDuration.
+	MaxLatency string
+	// Window, if set, is the length of the windows, e.g., '1m', the run is divided
+	// into, starting from the start of the run. Each window with requests is
+	// checked separately, and one whose requests all failed violates the rule. The
+	// partial window the run ended in isn't checked unless it's the only one.
+	// Without a Window the whole run is checked at once. It's expressed the same
+	// way as RunDuration.
+	Window string `json:",omitempty"`
+	// MaxViolationFraction is the largest fraction of the windows, from 0 to 1,
+	// that may violate the rule without failing it. By default no window may.
+	MaxViolationFraction float64 `json:",omitempty"`
+}
+
+// Policies applied when a UniqueIntRange is exhausted
+const (
+	// UniqueIntWrap restarts the range at Start. IDs are no longer unique
+	// once the range wraps.
+	UniqueIntWrap = "wrap"
+	// UniqueIntStop stops making requests to any endpoint that needs an ID
+	// from the exhausted range. This is the default.
+	UniqueIntStop = "stop"
+	// UniqueIntFail ends the whole test run.
+	UniqueIntFail = "fail"
+)
+
+// UniqueIntRange describes the contiguous range of integers handed out by a
+// named 'uniqueInt' counter. Every value is handed out exactly once across all
+// requests in the run, regardless of concurrency.
+type UniqueIntRange struct {
+	// Start is the first value in the range
+	Start int64
+	// End, if set, is the last value in the range, e.g., 0 for a range of just
+	// 0 if Start is also 0. The range is unbounded if it isn't set.
+	End *int64 `json:",omitempty"`
+	// OnExhausted is the policy applied once every value in the range has
+	// been used. It's one of 'wrap', 'stop', or 'fail'. 'stop' is the default.
+	OnExhausted string
+}
+
+// ExpectedOutcome is the outcome expected of every request to a negative test
+// endpoint. Exactly one of Status or NetworkError is set.
+type ExpectedOutcome struct {
+	// Status is the expected HTTP status, e.g., 403
+	Status int `json:",omitempty"`
+	// NetworkError is the expected network error, one of the NetworkError...
+	// constants, e.g., 'connection_reset'
+	NetworkError string `json:",omitempty"`
+}
+
+// Network errors of an ExpectedOutcome
+const (
+	// NetworkErrorConnectionReset is a connection reset by the server
+	NetworkErrorConnectionReset = "connection_reset"
+	// NetworkErrorConnectionRefused is a connection refused by the server
+	NetworkErrorConnectionRefused = "connection_refused"
+	// NetworkErrorEOF is a connection closed by the server without a response
+	NetworkErrorEOF = "eof"
+	// NetworkErrorTimeout is a request that timed out
+	NetworkErrorTimeout = "timeout"
+)
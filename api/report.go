@@ -4,7 +4,90 @@
 
 package api
 
-import "time"
+import (
+	"net/http"
+	"time"
+)
+
+// Error categories used to classify failed requests in RunSummary.ErrorCategories
+// and EndpointDetail.ErrorCategories
+const (
+	// ErrCategoryMalformedURL indicates the request's URL, after rendering any
+	// templates, wasn't a valid absolute URL so the request wasn't sent
+	ErrCategoryMalformedURL = "MalformedURL"
+	// ErrCategoryConnection indicates the request couldn't be sent or its response
+	// couldn't be received, e.g., the connection was refused or reset
+	ErrCategoryConnection = "ConnectionError"
+	// ErrCategoryResponseHeaderTimeout indicates the server didn't send the
+	// response headers within LoadTestConfig.ResponseHeaderTimeout
+	ErrCategoryResponseHeaderTimeout = "ResponseHeaderTimeout"
+	// ErrCategoryReadIdleTimeout indicates the server stopped sending data, e.g.,
+	// part way through the response body, for longer than
+	// LoadTestConfig.ReadIdleTimeout
+	ErrCategoryReadIdleTimeout = "ReadIdleTimeout"
+	// ErrCategoryMalformedResponse indicates the server's response couldn't be
+	// parsed, e.g., its status line or headers were malformed
+	ErrCategoryMalformedResponse = "MalformedResponse"
+	// ErrCategoryUnexpectedEOF indicates the connection was closed part way
+	// through the response headers
+	ErrCategoryUnexpectedEOF = "UnexpectedEOF"
+	// ErrCategoryTruncatedResponse indicates the connection was closed after the
+	// response headers were received but before the entire body was, e.g., the
+	// body was shorter than its Content-Length
+	ErrCategoryTruncatedResponse = "TruncatedResponse"
+	// ErrCategoryTooManyRedirects indicates the request was redirected more times
+	// than the client allows
+	ErrCategoryTooManyRedirects = "TooManyRedirects"
+	// ErrCategoryCrossHostRedirect indicates the request was redirected to another
+	// host and cross-host redirects are forbidden
+	ErrCategoryCrossHostRedirect = "CrossHostRedirect"
+	// ErrCategoryRequestCanceled indicates the request was cancelled before it
+	// completed for a reason other than the run ending
+	ErrCategoryRequestCanceled = "RequestCanceled"
+	// ErrCategoryUnsuccessfulJSON indicates the value at the endpoint's
+	// SuccessJSONPath in the response body wasn't its SuccessJSONValue
+	ErrCategoryUnsuccessfulJSON = "UnsuccessfulJSON"
+	// ErrCategoryUnsuccessfulExpr indicates the endpoint's SuccessExpr was false
+	ErrCategoryUnsuccessfulExpr = "UnsuccessfulExpr"
+	// ErrCategorySuccessExprError indicates the endpoint's SuccessExpr couldn't be
+	// evaluated, e.g., because it compares a number to a string
+	ErrCategorySuccessExprError = "SuccessExprError"
+	// ErrCategoryChecksumMismatch indicates the response body's SHA-256 digest
+	// wasn't the endpoint's ExpectedSHA256
+	ErrCategoryChecksumMismatch = "ChecksumMismatch"
+	// ErrCategoryUnexpectedContentType indicates the media type of a successful
+	// response wasn't the endpoint's ExpectedContentType
+	ErrCategoryUnexpectedContentType = "UnexpectedContentType"
+	// ErrCategoryUnexpectedOutcome indicates a request to a negative test endpoint
+	// didn't have the endpoint's ExpectedOutcome, e.g., it succeeded
+	ErrCategoryUnexpectedOutcome = "UnexpectedOutcome"
+	// ErrCategoryHookRejected indicates the LoadTestConfig's ResponseHook rejected the
+	// response
+	ErrCategoryHookRejected = "HookRejected"
+	// ErrCategoryFirstEventTimeout indicates a Server-Sent Events stream's first
+	// event wasn't received within its SSEConfig.FirstEventTimeout
+	ErrCategoryFirstEventTimeout = "FirstEventTimeout"
+	// ErrCategoryHTTP2StreamReset indicates an HTTP/2 stream was reset. The
+	// category is reported with the stream's error code appended, e.g.,
+	// 'HTTP2StreamReset:REFUSED_STREAM'.
+	ErrCategoryHTTP2StreamReset = "HTTP2StreamReset"
+	// ErrCategoryHTTP2GoAway indicates the server closed an HTTP/2 connection
+	// with a GOAWAY frame. The category is reported with the frame's error code
+	// appended, e.g., 'HTTP2GoAway:ENHANCE_YOUR_CALM'.
+	ErrCategoryHTTP2GoAway = "HTTP2GoAway"
+	// ErrCategoryMissingETag indicates the resource read by an OptimisticUpdate
+	// didn't have an ETag to update it with
+	ErrCategoryMissingETag = "MissingETag"
+	// ErrCategoryInjectedFailure indicates the request was failed, before it was
+	// sent, by the -inject-failures debug flag
+	ErrCategoryInjectedFailure = "InjectedFailure"
+	// ErrCategoryConflictRetriesExhausted indicates an OptimisticUpdate still
+	// conflicted after its MaxConflictRetries
+	ErrCategoryConflictRetriesExhausted = "ConflictRetriesExhausted"
+	// ErrCategoryResourceExhausted indicates the request couldn't be sent because
+	// heyyall ran out of a local resource, e.g., file descriptors or ephemeral ports
+	ErrCategoryResourceExhausted = "ResourceExhausted"
+)
 
 // RqstStats contains a set of common runtime stats reported at both the
 // Summary and Endpoint level
@@ -24,6 +107,21 @@ type RqstStats struct {
 	MinRqstDurationNanos time.Duration
 	// AvgRqstDurationNanos is the average duration of a request for an endpoint
 	AvgRqstDurationNanos time.Duration
+	// Exemplars link the reported latency percentiles to sample requests, see
+	// LoadTestConfig.TraceIDHeader. They're ordered by percentile.
+	Exemplars []Exemplar `json:",omitempty"`
+}
+
+// Exemplar is a sample request representative of a latency percentile, e.g., a
+// request taking as long as the 99th percentile, identified by its trace ID so it
+// can be found in a tracing system
+type Exemplar struct {
+	// Percentile is the latency percentile, 0 is the minimum latency
+	Percentile int
+	// DurationNanos is the duration of the sample request
+	DurationNanos time.Duration
+	// TraceID is the sample request's trace ID
+	TraceID string
 }
 
 // EndpointDetail is used to report an overview of the results of
@@ -36,14 +134,501 @@ type EndpointDetail struct {
 	// it is a map keyed by HTTP method containing a map keyed by HTTP status
 	// referencing the number of times that status was returned.
 	HTTPMethodStatusDist map[string]map[int]int
+	// NotModifiedCount is the number of successful requests to this endpoint whose
+	// response was a 304 Not Modified, e.g., to the conditional requests sent with
+	// LoadTestConfig.ConditionalRequests
+	NotModifiedCount int64 `json:",omitempty"`
 	// HTTPMethodRqstStats provides summary request statistics by HTTP Method. It is
 	// map of RqstStats keyed by HTTP method.
 	HTTPMethodRqstStats map[string]*RqstStats
+	// AbandonedSlow is the number of requests to this endpoint cancelled by the
+	// client after exceeding the soft deadline. They aren't included in
+	// HTTPMethodRqstStats.
+	AbandonedSlow int64 `json:",omitempty"`
+	// LatencyBySizeClass breaks down request latency by the size of the request
+	// body, ordered from the smallest size class to the largest. Only requests
+	// with a body are included and empty size classes are omitted.
+	LatencyBySizeClass []*SizeClassStats `json:",omitempty"`
+	// ErrorCategories is the number of failed requests to this endpoint keyed by
+	// error category (e.g., MalformedURL). Failed requests aren't included in
+	// HTTPMethodRqstStats.
+	ErrorCategories map[string]int64 `json:",omitempty"`
+	// EarlyFailed is true if requests to the endpoint were stopped because its
+	// first EarlyFailThreshold responses were all errors
+	EarlyFailed bool `json:",omitempty"`
+	// CircuitOpens is the number of times the endpoint's circuit breaker opened,
+	// see Endpoint.CircuitBreaker
+	CircuitOpens int64 `json:",omitempty"`
+	// CircuitOpenNanos is how long the endpoint's circuit breaker was open, during
+	// which no requests were sent to it
+	CircuitOpenNanos time.Duration `json:",omitempty"`
+	// Crawl, if the endpoint has a Crawl, describes the URLs it discovered. They're
+	// reported as endpoints of their own.
+	Crawl *CrawlStats `json:",omitempty"`
+	// ClientCache, if the run was configured with SimulateClientCache, describes
+	// how the endpoint's GET requests were served by the workers' caches
+	ClientCache *ClientCacheStats `json:",omitempty"`
+	// OptimisticUpdates, if the endpoint has an OptimisticUpdate, summarizes the
+	// conflicts of its updates
+	OptimisticUpdates *OptimisticUpdateStats `json:",omitempty"`
+	// MinBytes is the smallest response body, in bytes, of the successful
+	// requests to this endpoint
+	MinBytes int64
+	// MaxBytes is the largest response body, in bytes, of the successful requests
+	// to this endpoint
+	MaxBytes int64
+	// AvgBytes is the average response body size, in bytes, of the successful
+	// requests to this endpoint
+	AvgBytes int64
+	// TotalBytes is the total size, in bytes, of the response bodies of the
+	// successful requests to this endpoint
+	TotalBytes int64
+	// CacheHits is the number of successful requests to this endpoint whose
+	// response was served from a cache, as identified by LoadTestConfig.CacheHitHeaders
+	CacheHits int64 `json:",omitempty"`
+	// CacheHitRatio is the fraction of successful requests to this endpoint whose
+	// response was served from a cache
+	CacheHitRatio float64 `json:",omitempty"`
+	// TotalQueueWaitNanos is the total time requests to this endpoint waited for
+	// its lock group. It isn't included in HTTPMethodRqstStats.
+	TotalQueueWaitNanos time.Duration `json:",omitempty"`
+	// TruncatedResponseBytes is the total number of body bytes received in this
+	// endpoint's truncated responses, see ErrCategoryTruncatedResponse
+	TruncatedResponseBytes int64 `json:",omitempty"`
+	// BodyLimitedResponses is the number of successful responses from this endpoint
+	// whose body was larger than LoadTestConfig.MaxResponseBodyBytes
+	BodyLimitedResponses int64 `json:",omitempty"`
+	// RedirectedRqsts is the number of successful requests to this endpoint that
+	// were redirected
+	RedirectedRqsts int64 `json:",omitempty"`
+	// FinalHosts is the number of successful requests to this endpoint keyed by the
+	// host they finally reached after following any redirects. It's only reported
+	// if some of the requests were redirected.
+	FinalHosts map[string]int64 `json:",omitempty"`
+	// CrossHostRedirects is the number of successful requests to this endpoint that
+	// were redirected to a different host than the one requested
+	CrossHostRedirects int64 `json:",omitempty"`
+	// MalformedURLSamples contains the first few rendered URLs that were found to
+	// be malformed, to help debug the templates or data that produced them
+	MalformedURLSamples []string `json:",omitempty"`
+	// TemplatedURLs, if the endpoint's URL is a LoadTestConfig.URLPathTemplate, is
+	// the number of distinct URLs grouped under it
+	TemplatedURLs int64 `json:",omitempty"`
+	// TemplatedURLSamples are the first few, sorted, of the distinct URLs grouped
+	// under the endpoint's URLPathTemplate
+	TemplatedURLSamples []string `json:",omitempty"`
+	// ChecksumMismatchSamples are the first few distinct response bodies, by digest,
+	// that didn't match the endpoint's ExpectedSHA256
+	ChecksumMismatchSamples []*ChecksumMismatchSample `json:",omitempty"`
+	// ContentTypes is the number of the endpoint's responses keyed by the media type
+	// of their Content-Type header, without its parameters. Responses without the
+	// header are counted under GroupByHeaderNone, and those whose header couldn't be
+	// parsed under ContentTypeInvalid. Only the first MaxGroupByHeaderValues media
+	// types are counted separately, any others are counted under GroupByHeaderOther.
+	ContentTypes map[string]int64 `json:",omitempty"`
+	// ContentTypeMismatches is the number of the endpoint's successful responses
+	// whose media type wasn't its ExpectedContentType
+	ContentTypeMismatches int64 `json:",omitempty"`
+	// EffectiveParallelism is the average parallelism of the endpoint's successful
+	// requests, i.e., the sum of their durations divided by the run's duration
+	EffectiveParallelism float64 `json:",omitempty"`
+	// Description is the endpoint's configured Description
+	Description string `json:",omitempty"`
+	// NegativeTest, if set, describes the ExpectedOutcome of a negative test
+	// endpoint, e.g., 'status 403'. Its responses with that outcome are counted as
+	// successful.
+	NegativeTest string `json:",omitempty"`
+	// UnexpectedOutcomes is the number of requests to a negative test endpoint
+	// keyed by the outcome they had instead of the expected one, e.g., 'status 200'
+	UnexpectedOutcomes map[string]int64 `json:",omitempty"`
+	// KeepAliveProbeResults are the results of the endpoint's keep-alive probe, if
+	// it has one, ordered by idle gap. A probe's requests aren't included in the
+	// other stats.
+	KeepAliveProbeResults []*KeepAliveProbeResult `json:",omitempty"`
+	// SSE, if the endpoint's Mode is EndpointModeSSE, summarizes its event streams
+	SSE *SSEResults `json:",omitempty"`
+	// ServerTiming are the phases, keyed by metric name, e.g., 'db', reported in the
+	// Server-Timing headers of the endpoint's successful responses
+	ServerTiming map[string]*ServerTimingPhase `json:",omitempty"`
+	// RequestIDsNotEchoed is the number of successful responses to this endpoint that
+	// didn't echo back their request's ID, see LoadTestConfig.RequestIDHeader
+	RequestIDsNotEchoed int64 `json:",omitempty"`
+	// ByteTiming separates the time to first byte of the endpoint's successful
+	// requests from their time to last byte
+	ByteTiming *ByteTiming `json:",omitempty"`
+	// ServerProcessingStats are the stats of the time from the last byte of each
+	// successful request being written to the first byte of its response, a proxy
+	// for the server's processing time
+	ServerProcessingStats *RqstStats `json:",omitempty"`
+	// Upload describes how long the endpoint's successful requests with a body took
+	// to write it, and the throughput they were uploaded at
+	Upload *UploadStats `json:",omitempty"`
+	// Apdex, if the run was configured with an ApdexTarget, is the Apdex score of
+	// the endpoint's requests
+	Apdex *Apdex `json:",omitempty"`
+	// GroupByHeader is the response header the endpoint's ByHeaderValue breakdown is
+	// keyed by, see Endpoint.GroupByHeader
+	GroupByHeader string `json:",omitempty"`
+	// ByHeaderValue breaks down the endpoint's requests by the value of their
+	// GroupByHeader response header. Requests whose response didn't have the header,
+	// including those that failed without a response, are grouped under
+	// GroupByHeaderNone. Only the first MaxGroupByHeaderValues values seen are broken
+	// down, the requests with any other value are grouped under GroupByHeaderOther.
+	ByHeaderValue map[string]*HeaderValueStats `json:",omitempty"`
+	// ByRotatingHeader breaks down the endpoint's requests by the value of each of its
+	// RotatingHeaders they were sent with, keyed by header name and then by value.
+	// Only the first MaxGroupByHeaderValues values of each header are broken down,
+	// the requests with any other value are grouped under GroupByHeaderOther.
+	ByRotatingHeader map[string]map[string]*HeaderValueStats `json:",omitempty"`
+	// StatusTimeSeries counts the endpoint's responses by status class during each
+	// interval of the run's time series in which it had responses
+	StatusTimeSeries []StatusClassSample `json:",omitempty"`
+	// StatusFlaps is the number of times the endpoint's majority status class, e.g.,
+	// '2xx', changed between consecutive intervals of StatusTimeSeries
+	StatusFlaps int64 `json:",omitempty"`
+	// StatusFlapEvents are when the first StatusFlaps, up to 100, occurred
+	StatusFlapEvents []StatusFlap `json:",omitempty"`
+}
+
+// ClientCacheStats describes how requests were served by the workers' simulated
+// client caches, see LoadTestConfig.SimulateClientCache
+type ClientCacheStats struct {
+	// Hits is the number of requests served from a cache without being sent. They
+	// aren't included in the request stats.
+	Hits int64
+	// Revalidations is the number of conditional requests sent to revalidate a
+	// stale cached response
+	Revalidations int64
+	// NotModified is the number of Revalidations whose response was a 304 Not
+	// Modified, i.e., the cached response was still valid
+	NotModified int64
+	// FullFetches is the number of unconditional requests sent because there
+	// wasn't a cached response
+	FullFetches int64
+	// HitRatio is the fraction of the requests, Hits, Revalidations, and
+	// FullFetches, that were Hits
+	HitRatio float64
+}
+
+// CrawlStats describes the URLs discovered by an endpoint's Crawl
+type CrawlStats struct {
+	// URLs is the number of URLs, including the seed, the endpoint's requests
+	// cycled through
+	URLs int64
+	// MaxDepth is the most links followed from the seed to a requested URL
+	MaxDepth int
+	// NotFollowed is the number of distinct links that weren't followed because
+	// the Crawl's MaxURLs was reached
+	NotFollowed int64
+	// OtherHosts is the number of distinct links to other hosts that weren't
+	// followed, see Crawl.AllowOtherHosts
+	OtherHosts int64 `json:",omitempty"`
+}
+
+// OptimisticUpdateStats summarizes the conflicts of an endpoint's OptimisticUpdates
+type OptimisticUpdateStats struct {
+	// Updates is the number of updates, including those that failed
+	Updates int64
+	// Attempts is the number of update requests sent, including retries
+	Attempts int64
+	// Conflicts is the number of attempts that conflicted, with a 412
+	Conflicts int64
+	// ConflictRate is the fraction of the Attempts that conflicted
+	ConflictRate float64
+	// AvgRetries is the average number of times an update was retried
+	AvgRetries float64
+	// Exhausted is the number of updates that still conflicted after their
+	// MaxConflictRetries
+	Exhausted int64
+}
+
+// ChecksumMismatchSample describes response bodies that didn't match an endpoint's
+// ExpectedSHA256
+type ChecksumMismatchSample struct {
+	// SHA256 is the hex encoded SHA-256 digest of the bodies
+	SHA256 string
+	// Bytes is the length of the bodies. A body shorter than expected was likely
+	// truncated.
+	Bytes int64
+	// Count is the number of responses with this body
+	Count int64
+}
+
+// StatusClassSample counts an endpoint's responses by status class during one
+// interval of the run
+type StatusClassSample struct {
+	// OffsetNanos is the start of the interval relative to the start of the run
+	OffsetNanos time.Duration
+	// StatusClasses is the number of responses keyed by status class, e.g., '2xx'.
+	// Failed requests without a status are counted as 'error'.
+	StatusClasses map[string]int64
+	// MajorityClass is the status class of most of the responses
+	MajorityClass string
+}
+
+// StatusFlap records an endpoint's majority status class changing
+type StatusFlap struct {
+	// OffsetNanos is the start of the interval in which the new majority status
+	// class was observed, relative to the start of the run
+	OffsetNanos time.Duration
+	// From is the majority status class of the previous interval
+	From string
+	// To is the majority status class of the interval at OffsetNanos
+	To string
+}
+
+// ServerTimingPhase summarizes one phase, a Server-Timing metric with a duration,
+// of an endpoint's responses
+type ServerTimingPhase struct {
+	// Count is the number of responses reporting the phase
+	Count int64
+	// TotalNanos is the total duration of the phase
+	TotalNanos time.Duration
+	// AvgNanos is the average duration of the phase
+	AvgNanos time.Duration
+	// TotalRqstNanos is the total latency, as measured by heyyall, of the requests
+	// reporting the phase
+	TotalRqstNanos time.Duration
+	// RqstPercent is the phase's share of TotalRqstNanos. The rest of the requests'
+	// latency was spent outside the phase, e.g., in the network or a proxy.
+	RqstPercent float64
+}
+
+// ByteTiming compares how long an endpoint's requests took to receive the first byte
+// of their responses, the headers, with how long they took to receive the last byte
+// of their bodies. The difference is the time spent transferring the body, e.g., of
+// a streaming response.
+type ByteTiming struct {
+	// Rqsts is the number of requests whose time to first byte was measured
+	Rqsts int64
+	// TotalTTFBNanos is the total time to first byte of the requests
+	TotalTTFBNanos time.Duration
+	// TotalTTLBNanos is the total time to last byte of the requests, their duration
+	TotalTTLBNanos time.Duration
+	// AvgTTFBNanos is the average time to first byte
+	AvgTTFBNanos time.Duration
+	// AvgTTLBNanos is the average time to last byte
+	AvgTTLBNanos time.Duration
+}
+
+// UploadStats summarizes the upload phase of an endpoint's requests with a body, the
+// time from their connection being obtained to the last byte of their body being
+// written. Along with the server processing time and the ByteTiming it completes the
+// requests' waterfall.
+type UploadStats struct {
+	// Rqsts is the number of requests whose upload was measured
+	Rqsts int64
+	// TotalBytes is the total number of request body bytes uploaded
+	TotalBytes int64
+	// TotalWriteNanos is the total time spent writing the requests
+	TotalWriteNanos time.Duration
+	// AvgWriteNanos and MaxWriteNanos are the average and longest time spent writing
+	// a request
+	AvgWriteNanos time.Duration
+	MaxWriteNanos time.Duration
+	// MinMBPerSec, AvgMBPerSec, and MaxMBPerSec are the slowest, overall, and fastest
+	// upload throughput in megabytes, 10^6 bytes, per second. AvgMBPerSec is
+	// TotalBytes over TotalWriteNanos.
+	MinMBPerSec float64
+	AvgMBPerSec float64
+	MaxMBPerSec float64
+}
+
+// Apdex is the Apdex score of a run's, or an endpoint's, requests, a measure of user
+// satisfaction with their latency between 0, every request frustrated, and 1, every
+// request satisfied. The Score is (Satisfied + Tolerating/2) / Rqsts.
+type Apdex struct {
+	// TargetNanos is the target latency, T, see LoadTestConfig.ApdexTarget
+	TargetNanos time.Duration
+	// Rqsts is the number of requests scored
+	Rqsts int64
+	// Satisfied is the number of requests that completed within TargetNanos
+	Satisfied int64
+	// Tolerating is the number of requests that completed within 4 times
+	// TargetNanos, but not within TargetNanos
+	Tolerating int64
+	// Frustrated is the number of requests that took longer than 4 times
+	// TargetNanos, or failed
+	Frustrated int64
+	// Score is the Apdex score
+	Score float64
+}
+
+// SLOResult is the result of checking a LoadTestConfig.SLOs rule
+type SLOResult struct {
+	// Name is the rule's Name, or a description of the rule if it doesn't have one
+	Name string
+	// Percentile is the percentile of the latencies checked
+	Percentile int
+	// MaxLatencyNanos is the highest acceptable latency at Percentile
+	MaxLatencyNanos time.Duration
+	// WindowNanos is the length of the windows checked, 0 if the whole run was
+	// checked at once
+	WindowNanos time.Duration `json:",omitempty"`
+	// MaxViolationFraction is the largest fraction of the windows that may
+	// violate the rule
+	MaxViolationFraction float64 `json:",omitempty"`
+	// Passed is true if the rule was met
+	Passed bool
+	// Violation describes why the rule wasn't met
+	Violation string `json:",omitempty"`
+	// LatencyNanos is the latency at Percentile over the whole run
+	LatencyNanos time.Duration
+	// Windows are the windows with requests, in order. They're only set for rules
+	// with a Window.
+	Windows []SLOWindow `json:",omitempty"`
+	// CheckedWindows is the number of Windows checked, all of them except a
+	// trailing Partial one
+	CheckedWindows int `json:",omitempty"`
+	// ViolatedWindows is the number of Windows that violated the rule
+	ViolatedWindows int `json:",omitempty"`
+	// ViolationFraction is the fraction of the CheckedWindows that violated the
+	// rule
+	ViolationFraction float64 `json:",omitempty"`
+	// WorstWindow is the checked window with the highest latency at Percentile
+	WorstWindow *SLOWindow `json:",omitempty"`
+}
+
+// SLOWindow is a window of a run checked against a LoadTestConfig.SLOs rule
+type SLOWindow struct {
+	// StartNanos is when the window started, relative to the start of the run
+	StartNanos time.Duration
+	// Rqsts is the number of successful requests that completed in the window
+	Rqsts int64
+	// Failed is the number of requests that failed, or were abandoned, in the
+	// window
+	Failed int64 `json:",omitempty"`
+	// LatencyNanos is the latency at the rule's Percentile of the window's
+	// successful requests
+	LatencyNanos time.Duration
+	// Violated is true if LatencyNanos was greater than the rule's MaxLatency, or
+	// the window didn't have any successful requests
+	Violated bool `json:",omitempty"`
+	// Partial is true if the run ended during the window. A partial window isn't
+	// checked unless it's the run's only window.
+	Partial bool `json:",omitempty"`
+}
+
+// SSEResults summarizes an endpoint's Server-Sent Events streams
+type SSEResults struct {
+	// Streams is the number of streams opened, not including reconnects
+	Streams int64
+	// FailedStreams is the number of streams that failed before their first
+	// event, e.g., because it timed out
+	FailedStreams int64
+	// Events is the number of events received
+	Events int64
+	// Disconnects is the number of times a stream was disconnected before its
+	// configured Events or Duration
+	Disconnects int64
+	// Reconnects is the number of times a disconnected stream was reconnected
+	Reconnects int64
+	// TimeToFirstEventNanos are the percentiles, keyed 'Min', 'Median', 'P75', ...,
+	// of the time from opening each stream to its first event
+	TimeToFirstEventNanos map[string]time.Duration `json:",omitempty"`
+	// InterEventNanos are the percentiles of the time between consecutive events of
+	// a stream, including any reconnect between them
+	InterEventNanos map[string]time.Duration `json:",omitempty"`
+}
+
+// KeepAliveProbeResult is the result of probing connections that were left idle for
+// a single idle gap
+type KeepAliveProbeResult struct {
+	// IdleGapNanos is how long the connections were left idle
+	IdleGapNanos time.Duration
+	// Connections is the number of connections probed
+	Connections int64
+	// Reused is the number of connections that were reused after the gap
+	Reused int64
+	// TornDown is the number of connections that were closed during the gap, so the
+	// request after the gap was sent on a new connection
+	TornDown int64
+	// Failed is the number of probes whose requests failed, either before or after
+	// the gap. They're broken down by category in ErrorCategories.
+	Failed int64
+	// ReuseRate is the fraction of the probes whose connection was reused
+	ReuseRate float64
+	// ErrorCategories is the number of failed probes keyed by error category
+	ErrorCategories map[string]int64 `json:",omitempty"`
+}
+
+// The groups of ByHeaderValue that aren't header values
+const (
+	GroupByHeaderNone  = "(none)"
+	GroupByHeaderOther = "(other)"
+)
+
+// MaxGroupByHeaderValues is the maximum number of header values an endpoint's
+// ByHeaderValue is broken down by, not including GroupByHeaderNone and
+// GroupByHeaderOther, so a header with a unique value per response, like a request
+// ID, doesn't blow up the report
+const MaxGroupByHeaderValues = 20
+
+// ContentTypeInvalid is the group of EndpointDetail.ContentTypes counting the
+// responses whose Content-Type header couldn't be parsed
+const ContentTypeInvalid = "(invalid)"
+
+// HeaderValueStats contains the stats of an endpoint's requests whose response had a
+// given GroupByHeader value. Its RqstStats only include the successful requests.
+type HeaderValueStats struct {
+	// Errors is the number of requests that failed, e.g., because they timed out
+	Errors int64
+	// ServerErrors is the number of successful requests with a 5xx status
+	ServerErrors int64
+	RqstStats
+}
+
+// SizeClassStats contains the request stats for requests whose body size falls
+// within a given size class
+type SizeClassStats struct {
+	// SizeClass describes the range of body sizes in the class, e.g., 1KB-10KB
+	SizeClass string
+	// MinBytes is the inclusive lower bound of body sizes in the class
+	MinBytes int64
+	// MaxBytes is the exclusive upper bound of body sizes in the class. It's 0
+	// for the largest size class which has no upper bound.
+	MaxBytes int64
+	RqstStats
+}
+
+// Meta describes how a run was configured where that affects how its results
+// should be interpreted
+type Meta struct {
+	// OutlierPolicy is the policy used to identify the outliers excluded from
+	// RunSummary.OutlierExcluded
+	OutlierPolicy *OutlierPolicy `json:",omitempty"`
+	// SummaryKey is how responses were grouped into endpoints, one of the
+	// SummaryKey... constants
+	SummaryKey string
+	// EndpointFilter, if set, describes how the configured endpoints were filtered
+	EndpointFilter *EndpointFilter `json:",omitempty"`
+	// ScheduledStart, if set, is the time the run was scheduled to start by the
+	// -start-at flag
+	ScheduledStart *time.Time `json:",omitempty"`
+	// StartedAt is when the run started
+	StartedAt time.Time
+	// Config, if the -echo-config flag is set, is the run's config after it was
+	// resolved, e.g., its EndpointTemplates applied and its endpoints filtered, with
+	// its secrets redacted
+	Config *LoadTestConfig `json:",omitempty"`
+}
+
+// EndpointFilter describes the endpoints selected by the -only and -skip flags
+type EndpointFilter struct {
+	// Only are the -only flag values, only endpoints matching one of them were run
+	Only []string `json:",omitempty"`
+	// Skip are the -skip flag values, endpoints matching any of them weren't run
+	Skip []string `json:",omitempty"`
+	// Endpoints are the endpoints that were run, identified by their Name or by
+	// their Method and URL
+	Endpoints []string
 }
 
 // RunResults is used to report an overview of the results of a
 // load test run
 type RunResults struct {
+	// Meta describes the configuration of the run
+	Meta Meta
 	// RunSummary is a roll-up of the detailed run results
 	RunSummary RunSummary
 	// EndpointSummary describes how often each endpoint was called.
@@ -53,6 +638,290 @@ type RunResults struct {
 	EndpointSummary map[string]map[string]int
 	// EndpointDetails is the per endpoint summary of results keyed by URL
 	EndpointDetails map[string]*EndpointDetail `json:",omitempty"`
+	// Phases summarizes each of the run's phases, in the order they ran, if
+	// LoadTestConfig.Phases is specified
+	Phases []PhaseSummary `json:",omitempty"`
+	// GeneratorStats describes the performance of heyyall itself during the run
+	GeneratorStats *GeneratorStats `json:",omitempty"`
+	// Canary, if LoadTestConfig.CanaryBaseURLs is specified, compares the results of
+	// the two builds
+	Canary *CanaryComparison `json:",omitempty"`
+	// Degradation, if the concurrency was reduced because heyyall ran out of local
+	// resources, describes the reductions and summarizes the run before and after
+	// the first of them
+	Degradation *ConcurrencyDegradation `json:",omitempty"`
+}
+
+// ConcurrencyDegradation describes how the run's concurrency was adapted to
+// sustained ErrCategoryResourceExhausted errors. Only the Clean portion of the run
+// had the configured concurrency.
+type ConcurrencyDegradation struct {
+	// Adjustments are the changes to the concurrency, in the order they were made
+	Adjustments []ConcurrencyAdjustment
+	// Clean summarizes the responses received before the concurrency was first
+	// reduced
+	Clean PhaseSummary
+	// Degraded summarizes the responses received after the concurrency was first
+	// reduced, whether or not it was later restored
+	Degraded PhaseSummary
+}
+
+// ConcurrencyAdjustment is a change to the number of requests allowed in flight
+type ConcurrencyAdjustment struct {
+	// OffsetNanos is when the change was made relative to the start of the run
+	OffsetNanos time.Duration
+	// From and To are the number of requests allowed in flight before and after the
+	// change
+	From int
+	To   int
+	// Reason describes why the change was made
+	Reason string
+}
+
+// The CanaryEndpoint.Winner of an endpoint whose builds can't be told apart, other
+// than CanaryA or CanaryB
+const (
+	// CanaryNoDifference indicates neither build's error rate or mean latency was
+	// significantly better than the other's
+	CanaryNoDifference = "none"
+	// CanaryInconclusive indicates one of the builds had too few requests to compare
+	CanaryInconclusive = "inconclusive"
+)
+
+// CanaryComparison compares the results of the two builds of a canary run, see
+// LoadTestConfig.CanaryBaseURLs
+type CanaryComparison struct {
+	// A and B are the builds' base URLs
+	A string
+	B string
+	// Endpoints compares the builds for each endpoint and method, ordered by URL
+	// and then method
+	Endpoints []CanaryEndpoint
+}
+
+// CanaryEndpoint compares the results of the two builds of a canary run for an
+// endpoint and method. The deltas are B's value less A's, so a negative latency or
+// error rate delta means B did better.
+type CanaryEndpoint struct {
+	URL    string
+	Method string
+	A      CanaryStats
+	B      CanaryStats
+	// MeanDeltaNanos, P50DeltaNanos, P95DeltaNanos, and P99DeltaNanos are the
+	// differences between the builds' latencies
+	MeanDeltaNanos time.Duration
+	P50DeltaNanos  time.Duration
+	P95DeltaNanos  time.Duration
+	P99DeltaNanos  time.Duration
+	// ErrorRateDelta is the difference between the builds' error rates
+	ErrorRateDelta float64
+	// Winner is the build, CanaryA or CanaryB, with a significantly lower error rate
+	// or, if their error rates aren't significantly different, a significantly lower
+	// mean latency. It's CanaryNoDifference if neither is significantly better and
+	// CanaryInconclusive if either build had too few requests to compare.
+	Winner string
+	// Reason explains the Winner, e.g., 'lower error rate'
+	Reason string `json:",omitempty"`
+}
+
+// CanaryStats summarizes the requests to an endpoint and method sent to one of the
+// builds of a canary run. The latencies are those of the successful requests.
+type CanaryStats struct {
+	Rqsts     int64
+	Errors    int64
+	ErrorRate float64
+	MeanNanos time.Duration
+	P50Nanos  time.Duration
+	P95Nanos  time.Duration
+	P99Nanos  time.Duration
+}
+
+// GeneratorStats describes the performance of heyyall itself. It helps identify runs
+// whose results were affected by heyyall not keeping up with the requests.
+type GeneratorStats struct {
+	// HandlerLag describes how well the response handler kept up with the responses
+	HandlerLag HandlerLag
+	// GC describes heyyall's garbage collections during the run
+	GC GCStats
+	// WorkerTime, if set, accounts for where the requestors spent their time
+	WorkerTime *WorkerTime `json:",omitempty"`
+}
+
+// WorkerTime accounts for where the requestor goroutines, the workers, spent their
+// time, from when each started until it finished, to explain why a run achieved
+// less than its configured rate. Each share is a fraction of TotalNanos:
+//
+// - A dominant InFlight share means the workers were waiting for responses: the
+// target's latency is too high to reach the rate with the configured concurrency.
+// - A large Pacing share means the workers were ahead of the rate and slept, the
+// rate, not the target or heyyall, limited the run.
+// - A large RateLimitWait or LockGroupWait share means an Endpoint's RateLimit or
+// LockGroup throttled the run.
+// - A large Idle share means the workers were busy with something else, e.g.,
+// rendering templates or waiting to be scheduled on a CPU or for the response
+// handler, heyyall itself may be CPU-bound, see HandlerLag.
+type WorkerTime struct {
+	// Workers is the number of workers accounted for
+	Workers int
+	// TotalNanos is the sum of the workers' running times
+	TotalNanos time.Duration
+	// InFlight is the time spent sending requests and waiting for their responses,
+	// including retries
+	InFlight TimeShare
+	// Pacing is the time spent sleeping between requests to keep to the request rate
+	Pacing TimeShare
+	// RateLimitWait is the time spent waiting for an Endpoint's RateLimit
+	RateLimitWait TimeShare
+	// LockGroupWait is the time spent waiting for a slot in an Endpoint's LockGroup
+	LockGroupWait TimeShare
+	// Idle is the rest of the time
+	Idle TimeShare
+}
+
+// TimeShare is an amount of time and its share, as a percentage, of a total
+type TimeShare struct {
+	Nanos   time.Duration
+	Percent float64
+}
+
+// GCStats describes heyyall's garbage collections during a run. Long GC pauses delay
+// the processing of responses and so can add to the measured latencies.
+type GCStats struct {
+	// NumGC is the number of garbage collections
+	NumGC int64
+	// TotalPauseNanos is the total time heyyall was paused by the garbage collections
+	TotalPauseNanos time.Duration
+	// MaxPauseNanos is the longest garbage collection pause
+	MaxPauseNanos time.Duration
+	// GCPercent, if set, is the GOGC set by the -gc-percent flag
+	GCPercent int `json:",omitempty"`
+	// BallastBytes, if set, is the size of the heap ballast set by the -gc-ballast flag
+	BallastBytes int64 `json:",omitempty"`
+}
+
+// ResponseChannelBackpressure describes how long the requestors were blocked sending
+// their responses to the response handler. Blocked requestors don't send requests, so
+// significant backpressure means heyyall's summarizing, rather than the service under
+// test, limited the request rate.
+type ResponseChannelBackpressure struct {
+	// BlockedSends is the number of responses whose requestor was blocked sending it
+	BlockedSends int64
+	// TotalBlockedNanos is the total time the requestors were blocked
+	TotalBlockedNanos time.Duration
+	// MaxBlockedNanos is the longest time a requestor was blocked sending a response
+	MaxBlockedNanos time.Duration
+}
+
+// PoolSaturation describes the requests that waited for a connection because the
+// connections to their host, limited by LoadTestConfig.MaxConnsPerHost, were all in
+// use. Frequent or long waits mean the pool is undersized for the concurrency.
+type PoolSaturation struct {
+	// WaitedRqsts is the number of requests that waited for a connection
+	WaitedRqsts int64
+	// TotalWaitNanos is the total time the requests waited
+	TotalWaitNanos time.Duration
+	// MaxWaitNanos is the longest time a request waited
+	MaxWaitNanos time.Duration
+}
+
+// HeaderStats describes the headers of a run's requests and their responses. A
+// header's size is that of its line in an HTTP/1.1 message, e.g., 'Accept: */*\r\n',
+// regardless of the protocol, so it's the size before any HTTP/2 or HTTP/3 header
+// compression. Each value of a header with several is counted as a header.
+type HeaderStats struct {
+	// Rqsts is the number of requests whose headers were measured
+	Rqsts int64
+	// TotalSentCount and TotalSentBytes are the number and size of the headers
+	// sent, including those added by the HTTP client, e.g., User-Agent, and those of
+	// redirected requests
+	TotalSentCount int64
+	TotalSentBytes int64
+	// TotalReceivedCount and TotalReceivedBytes are the number and size of the
+	// headers of the final responses
+	TotalReceivedCount int64
+	TotalReceivedBytes int64
+	// AvgSentCount and AvgSentBytes are the average number and size of the headers
+	// sent with each request
+	AvgSentCount float64
+	AvgSentBytes float64
+	// AvgReceivedCount and AvgReceivedBytes are the average number and size of the
+	// headers received with each response
+	AvgReceivedCount float64
+	AvgReceivedBytes float64
+}
+
+// ResponseHookStats describes the invocations of a run's ResponseHook
+type ResponseHookStats struct {
+	// Invocations is the number of times the hook's command was run
+	Invocations int64
+	// Rejected is the number of responses the hook rejected, see
+	// ErrCategoryHookRejected
+	Rejected int64
+	// Failures is the number of invocations that couldn't be run or timed out. The
+	// responses weren't validated.
+	Failures int64
+	// Skipped is the number of sampled responses that weren't validated because
+	// ResponseHook.MaxConcurrent commands were already running
+	Skipped int64
+	// TotalNanos, AvgNanos, and MaxNanos are the total, average, and longest time
+	// spent running the hook's command
+	TotalNanos time.Duration
+	AvgNanos   time.Duration
+	MaxNanos   time.Duration
+}
+
+// HandlerLag describes how well the response handler kept up with the responses.
+// The queue depths and processing times are sampled.
+type HandlerLag struct {
+	// QueueCapacity is the number of responses that can be queued for the handler
+	// before the requestors are blocked
+	QueueCapacity int
+	// MaxQueueDepth is the largest number of responses seen waiting for the handler
+	MaxQueueDepth int
+	// AvgQueueDepth is the average number of responses seen waiting for the handler
+	AvgQueueDepth float64
+	// LongestSaturatedNanos is the longest period during which the queue was
+	// almost full
+	LongestSaturatedNanos time.Duration
+	// AvgProcessingNanos is the average time the handler spent processing a response
+	AvgProcessingNanos time.Duration
+	// MaxProcessingNanos is the longest time the handler spent processing a response
+	MaxProcessingNanos time.Duration
+	// SummarizeNanos is how long the handler took to summarize the responses once
+	// the last of them was received
+	SummarizeNanos time.Duration
+}
+
+// PhaseSummary summarizes the requests made during a single phase of a run
+type PhaseSummary struct {
+	// Name is the phase's name
+	Name string
+	// StartOffsetNanos is when the phase started relative to the start of the run
+	StartOffsetNanos time.Duration
+	// EndOffsetNanos is when the phase ended relative to the start of the run
+	EndOffsetNanos time.Duration
+	// RunSummary is a roll-up of the phase's results
+	RunSummary RunSummary
+	// EndpointDetails is the per endpoint summary of the phase's results keyed by URL
+	EndpointDetails map[string]*EndpointDetail `json:",omitempty"`
+}
+
+// SuiteResults are the results of a suite of runs, each run using its own config, or
+// scenario, one after the other
+type SuiteResults struct {
+	// Scenarios are the results of each of the suite's scenarios, in the order they ran
+	Scenarios []ScenarioResults
+	// Aggregate summarizes the responses of all of the suite's scenarios together. Its
+	// duration is the duration of the whole suite.
+	Aggregate RunResults
+}
+
+// ScenarioResults are the results of one of a suite's scenarios
+type ScenarioResults struct {
+	// Name identifies the scenario, e.g., the name of its config file
+	Name string
+	// Results are the results of the scenario's run
+	Results RunResults
 }
 
 // RunSummary is a roll-up of the detailed run results
@@ -60,8 +929,16 @@ type RunSummary struct {
 	// RqstRatePerSec is the overall request rate per second
 	// rounded to the nearest integer
 	RqstRatePerSec float64
-	// RunDurationNanos is the wall clock duration of the test
+	// RunDurationNanos is the wall clock duration of the test, not including its
+	// WarmupDuration
 	RunDurationNanos time.Duration
+	// WarmupDiscarded is the number of responses received during the run's
+	// WarmupDuration that were discarded. They aren't included in RqstStats or any
+	// of the run's other totals.
+	WarmupDiscarded int64 `json:",omitempty"`
+	// DisabledEndpoints identifies the configured endpoints that were skipped
+	// because they're Disabled
+	DisabledEndpoints []string `json:",omitempty"`
 
 	// MaxRqstRatePerSec is the maximum request rate per second
 	// over 1/10th of the run duration or number of requests
@@ -72,6 +949,140 @@ type RunSummary struct {
 
 	// RqstStats is a summary of runtime statistics
 	RqstStats RqstStats
+	// OutlierExcluded, if an OutlierPolicy was configured, contains the request
+	// stats with outlier latencies excluded. RqstStats always includes them.
+	OutlierExcluded *OutlierStats `json:",omitempty"`
+	// Warnings describes conditions detected during the run that may make its
+	// results misleading, e.g., an endpoint whose requests were stopped early
+	Warnings []string `json:",omitempty"`
+	// FirstFailure, if the run was configured with StopOnFirstFailure, is the
+	// request whose failure ended the run
+	FirstFailure *FirstFailure `json:",omitempty"`
+	// BudgetExceeded, if the run was configured with a Budget and reached it,
+	// describes which of its limits stopped the run
+	BudgetExceeded *BudgetExceeded `json:",omitempty"`
+	// AbandonedSlow is the number of requests cancelled by the client after
+	// exceeding the soft deadline. This is a deliberate client-side choice, not
+	// a server timeout, and these requests aren't included in RqstStats.
+	AbandonedSlow int64
+	// Retries is the total number of times requests were retried. Only the final
+	// attempt of a retried request is included in RqstStats.
+	Retries int64
+	// ErrorCategories is the number of failed requests keyed by error category.
+	// Failed requests aren't included in RqstStats.
+	ErrorCategories map[string]int64 `json:",omitempty"`
+	// TotalQueueWaitNanos is the total time requests waited for their endpoint's
+	// lock group. It isn't included in RqstStats.
+	TotalQueueWaitNanos time.Duration `json:",omitempty"`
+	// ResponseChannelBackpressure, if set, describes how long the requestors were
+	// blocked sending their responses to the response handler because its queue was
+	// full. It's only reported if they were.
+	ResponseChannelBackpressure *ResponseChannelBackpressure `json:",omitempty"`
+	// PoolSaturation, if set, describes the requests that waited for a connection
+	// because their host's MaxConnsPerHost connections were all in use. It's only
+	// reported if any did.
+	PoolSaturation *PoolSaturation `json:",omitempty"`
+	// HeaderStats, if the run was configured with HeaderStats, are the averages of
+	// the headers sent and received by the successful requests
+	HeaderStats *HeaderStats `json:",omitempty"`
+	// ResponseHookStats, if the run was configured with a ResponseHook, describes the
+	// hook's invocations
+	ResponseHookStats *ResponseHookStats `json:",omitempty"`
+	// TruncatedResponseBytes is the total number of body bytes received in
+	// truncated responses, see ErrCategoryTruncatedResponse
+	TruncatedResponseBytes int64 `json:",omitempty"`
+	// BodyLimitedResponses is the number of successful responses whose body was
+	// larger than LoadTestConfig.MaxResponseBodyBytes, so only part of it was read
+	BodyLimitedResponses int64 `json:",omitempty"`
+	// CrossHostRedirects is the number of successful requests that were redirected
+	// to a different host than the one requested, e.g., from a staging host to a
+	// production one
+	CrossHostRedirects int64 `json:",omitempty"`
+	// StatusDistFailed is true if the distribution of response statuses didn't
+	// meet LoadTestConfig.ExpectedStatusDistribution
+	StatusDistFailed bool `json:",omitempty"`
+	// StatusDistViolations describe each of the expectations in
+	// LoadTestConfig.ExpectedStatusDistribution that weren't met
+	StatusDistViolations []string `json:",omitempty"`
+	// MaxP99Failed is true if the run-wide P99 latency was greater than
+	// LoadTestConfig.MaxP99
+	MaxP99Failed bool `json:",omitempty"`
+	// MaxP99Violation describes why the run failed LoadTestConfig.MaxP99
+	MaxP99Violation string `json:",omitempty"`
+	// ClientCache, if the run was configured with SimulateClientCache, describes
+	// how the GET requests of all the endpoints were served by the workers' caches
+	ClientCache *ClientCacheStats `json:",omitempty"`
+	// SLOFailed is true if any of LoadTestConfig.SLOs weren't met
+	SLOFailed bool `json:",omitempty"`
+	// SLOResults are the results of checking each of LoadTestConfig.SLOs, in the
+	// order they're configured
+	SLOResults []SLOResult `json:",omitempty"`
+	// Apdex, if the run was configured with an ApdexTarget, is the Apdex score of
+	// the run's requests
+	Apdex *Apdex `json:",omitempty"`
+	// TopErrorMessages are the most frequent error messages of failed requests,
+	// most frequent first. Variable parts of the messages, like addresses and
+	// ports, are replaced by placeholders such as '<addr>' so that messages
+	// describing the same failure are counted together.
+	TopErrorMessages []ErrorMessageCount `json:",omitempty"`
+	// ServerClosedConnections is the number of responses after which the server
+	// closed the connection, preventing it from being reused (e.g., the server
+	// returned 'Connection: close')
+	ServerClosedConnections int64
+	// ServerClosedConnectionRatio is ServerClosedConnections as a fraction of
+	// RqstStats.TotalRqsts. High values indicate server-side connection churn
+	// that adds connection setup time to client requests.
+	ServerClosedConnectionRatio float64
+	// Protocols is the number of requests keyed by the protocol negotiated with
+	// the server, e.g., HTTP/1.1, HTTP/2.0, or HTTP/3.0
+	Protocols map[string]int64 `json:",omitempty"`
+	// RqstProtocols is the number of requests keyed by the protocol version they
+	// were sent with, for the endpoints with a ProtocolVersion
+	RqstProtocols map[string]int64 `json:",omitempty"`
+	// TLSVersionDist is the number of requests keyed by the TLS version negotiated
+	// with the server, e.g., TLS 1.2 or TLS 1.3, for the requests that used TLS
+	TLSVersionDist map[string]int64 `json:",omitempty"`
+	// CipherSuiteDist is the number of requests keyed by the TLS cipher suite
+	// negotiated with the server, e.g., TLS_AES_128_GCM_SHA256
+	CipherSuiteDist map[string]int64 `json:",omitempty"`
+	// InstanceDist, if the run was configured with an InstanceHeader, is the number
+	// of responses keyed by the value of their InstanceHeader, i.e., the server
+	// instance that served them. Responses without the header are counted under
+	// GroupByHeaderNone. Only the first MaxGroupByHeaderValues instances are counted,
+	// the responses from any others are counted under GroupByHeaderOther.
+	InstanceDist map[string]int64 `json:",omitempty"`
+	// NewConnections is the number of requests that required a new connection,
+	// TCP or QUIC depending on the protocol, to be established
+	NewConnections int64
+	// EffectiveConcurrency is the average number of requests in flight during the
+	// run, i.e., the total time the requests were in flight divided by the run's
+	// duration
+	EffectiveConcurrency float64
+	// ConcurrencyEfficiency is EffectiveConcurrency as a fraction of the configured
+	// MaxConcurrentRqsts. Values well below 1 mean the requestors spent much of the
+	// run idle or blocked, e.g., waiting for a rate limit, rather than waiting for
+	// responses.
+	ConcurrencyEfficiency float64
+	// EffectiveParallelism is the average parallelism of the run's successful
+	// requests, i.e., RqstStats.TotalRequestDurationNanos divided by the run's
+	// duration. It's 0 if the run had no duration.
+	EffectiveParallelism float64
+	// ReusedConnections is the number of requests sent on a previously
+	// established connection
+	ReusedConnections int64
+	// WarmupConnections is the number of connections opened before the run
+	// started, see LoadTestConfig.WarmupConnections. They aren't included in
+	// NewConnections.
+	WarmupConnections int64 `json:",omitempty"`
+	// WarmupReusableConnections is the number of WarmupConnections that were left
+	// open for the run, i.e., whose warmup response didn't close the connection
+	WarmupReusableConnections int64 `json:",omitempty"`
+	// WarmConnectionsUsed is the number of WarmupReusableConnections the run's
+	// requests were sent on
+	WarmConnectionsUsed int64 `json:",omitempty"`
+	// WarmConnectionRqsts is the number of the run's requests that were sent on
+	// one of the WarmupReusableConnections
+	WarmConnectionRqsts int64 `json:",omitempty"`
 	// DNSLookupNanos records how long it took to resolve the hostname to an IP Address
 	DNSLookupNanos []time.Duration
 	// TCPConnSetupNanos records how long it took to setup the TCP connection
@@ -82,4 +1093,238 @@ type RunSummary struct {
 	// TLSHandshakeNanos records the time it took to complete the TLS negotiation with
 	// the server. It's only meaningful for HTTPS connections
 	TLSHandshakeNanos []time.Duration
+	// ConnSetupNanos records how long it took to set up each new connection, from
+	// starting the TCP connection through completing the TLS handshake, if any. Only
+	// requests that opened a new connection are included.
+	ConnSetupNanos []time.Duration `json:",omitempty"`
+	// ConnSetupDist buckets ConnSetupNanos, e.g., to understand the cost of warming
+	// up the connection pool, ordered from the shortest setup times to the longest
+	ConnSetupDist []ConnSetupBucket `json:",omitempty"`
+	// TimeSeriesIntervalNanos is the length of each interval in TimeSeries
+	TimeSeriesIntervalNanos time.Duration `json:",omitempty"`
+	// TimeSeries summarizes the requests completed during each interval of the run,
+	// in order from the start of the run
+	TimeSeries []TimeSeriesSample `json:",omitempty"`
+	// DNSChanges records, in the order they occurred, when the IPs that a host's
+	// responses came from changed during the run, e.g., due to a DNS based failover
+	DNSChanges []DNSChange `json:",omitempty"`
+	// WeightedEndpointP95 breaks down the run's P95 latency, which is calculated from
+	// the latencies of all of the run's requests, by endpoint, ordered from the
+	// endpoint with the most requests slower than the run's P95 to the least. It's
+	// only reported if there's more than 1 endpoint.
+	WeightedEndpointP95 []EndpointP95 `json:",omitempty"`
+	// UniqueIntRanges reports, by counter name, how much of each 'uniqueInt'
+	// range was consumed during the run
+	UniqueIntRanges map[string]UniqueIntRangeUsage `json:",omitempty"`
+	// UniqueIntFailed is true if a 'uniqueInt' range whose OnExhausted is 'fail'
+	// was exhausted, ending the run. UniqueIntFailure describes which one.
+	UniqueIntFailed  bool   `json:",omitempty"`
+	UniqueIntFailure string `json:",omitempty"`
+}
+
+// RollingSummary is a summary of part of a run written while the run is in progress
+type RollingSummary struct {
+	// Mode is either 'cumulative' or 'interval'
+	Mode string
+	// FromNanos is the start of the period summarized, relative to the start of
+	// the run. It's 0 for cumulative summaries.
+	FromNanos time.Duration
+	// ToNanos is the end of the period summarized, relative to the start of the run
+	ToNanos time.Duration
+	// RunSummary summarizes the requests completed during the period
+	RunSummary RunSummary
+}
+
+// OutlierStats are request stats with outlier latencies excluded
+type OutlierStats struct {
+	// CutoffNanos is the latency beyond which requests were excluded
+	CutoffNanos time.Duration
+	// ExcludedRqsts is the number of requests excluded
+	ExcludedRqsts int64
+	// ExcludedDurationNanos is the total duration of the excluded requests
+	ExcludedDurationNanos time.Duration
+	// RqstStats are the stats of the requests that weren't excluded. Their
+	// TimingResultsNanos aren't kept, see LatencyNanos.
+	RqstStats RqstStats
+	// LatencyNanos are estimates, within about 2%, of the latency percentiles of
+	// the requests that weren't excluded keyed by percentile, i.e., 'Min',
+	// 'Median', 'P75', 'P90', 'P95', and 'P99'
+	LatencyNanos map[string]time.Duration
+}
+
+// ErrorMessageCount is the number of failed requests with a given error message
+type ErrorMessageCount struct {
+	// Message is the normalized error message
+	Message string
+	// Count is the number of failed requests with the error message. Counts of
+	// infrequent messages may be overstated when there are a very large number
+	// of distinct messages.
+	Count int64
+}
+
+// TimeSeriesSample summarizes the requests completed during one interval of a run
+type TimeSeriesSample struct {
+	// OffsetNanos is the start of the interval relative to the start of the run
+	OffsetNanos time.Duration
+	// TotalRqsts is the number of requests completed during the interval
+	TotalRqsts int64
+	// TotalRequestDurationNanos is the sum of the durations of the requests
+	// completed during the interval
+	TotalRequestDurationNanos time.Duration
+	// AvgRqstDurationNanos is the average duration of the requests completed
+	// during the interval
+	AvgRqstDurationNanos time.Duration
+	// RqstRatePerSec is the rate at which requests were completed during the interval
+	RqstRatePerSec float64
+	// PhaseStarted is the name of the phase, if any, that started during the interval
+	PhaseStarted string `json:",omitempty"`
+	// DNSChanged indicates the IPs of at least one host changed during the interval.
+	// See RunSummary.DNSChanges.
+	DNSChanged bool `json:",omitempty"`
+}
+
+// DNSChange records a change in the IPs that a host's responses came from
+type DNSChange struct {
+	// Host is the host whose IPs changed
+	Host string
+	// OffsetNanos is the start of the time series interval in which the change was
+	// observed, relative to the start of the run
+	OffsetNanos time.Duration
+	// OldIPs are the IPs observed before the change
+	OldIPs []string
+	// NewIPs are the IPs observed after the change
+	NewIPs []string
+}
+
+// UniqueIntRangeUsage describes how much of a 'uniqueInt' range was consumed
+type UniqueIntRangeUsage struct {
+	// Start is the first value in the range
+	Start int64
+	// End is the last value in the range, nil if the range is unbounded
+	End *int64 `json:",omitempty"`
+	// Consumed is the number of values handed out, including any handed out
+	// after the range wrapped
+	Consumed int64
+	// Exhausted is true if every value in the range was used
+	Exhausted bool
+}
+
+// BaselineVersion is the version of the Baseline format. It's incremented whenever
+// the format changes in a way that affects comparisons with older baselines.
+const BaselineVersion = 1
+
+// Baseline is a stable summary of a successful run, saved so later runs can be
+// compared with it. Latency is summarized by percentiles rather than by the
+// duration of every request.
+type Baseline struct {
+	// Version is the BaselineVersion of the format the baseline was saved in
+	Version int
+	// CapturedAt is when the baseline was saved
+	CapturedAt time.Time
+	// Meta describes the configuration of the run
+	Meta Meta
+	// RunSummary summarizes the entire run
+	RunSummary BaselineSummary
+	// Endpoints summarizes each endpoint, keyed by URL
+	Endpoints map[string]BaselineEndpoint
+}
+
+// BaselineLatency summarizes the latency of a set of successful requests
+type BaselineLatency struct {
+	// TotalRqsts is the number of successful requests
+	TotalRqsts int64
+	// LatencyNanos are the latency percentiles of the requests keyed by
+	// percentile, i.e., 'Min', 'Median', 'P75', 'P90', 'P95', and 'P99'
+	LatencyNanos map[string]time.Duration
+}
+
+// BaselineSummary summarizes a run in a Baseline
+type BaselineSummary struct {
+	BaselineLatency
+	// RunDurationNanos is the wall clock duration of the run
+	RunDurationNanos time.Duration
+	// RqstRatePerSec is the overall request rate per second
+	RqstRatePerSec float64
+	// ErrorCategories is the number of failed requests keyed by error category
+	ErrorCategories map[string]int64 `json:",omitempty"`
+}
+
+// BaselineEndpoint summarizes an endpoint in a Baseline
+type BaselineEndpoint struct {
+	// Methods summarizes the latency of the endpoint's requests keyed by HTTP method
+	Methods map[string]BaselineLatency
+	// ErrorCategories is the number of failed requests to the endpoint keyed by
+	// error category
+	ErrorCategories map[string]int64 `json:",omitempty"`
+}
+
+// ConnSetupBucket counts the new connections whose setup time was greater than the
+// previous bucket's UpperBoundNanos and no greater than its own
+type ConnSetupBucket struct {
+	// UpperBoundNanos is the longest setup time counted in the bucket. It's 0 for
+	// the last bucket, which counts every setup time longer than the previous bucket's.
+	UpperBoundNanos time.Duration
+	// Count is the number of new connections counted in the bucket
+	Count int64
+}
+
+// EndpointP95 is an endpoint's contribution to a run's P95 latency
+type EndpointP95 struct {
+	// URL is the endpoint URL
+	URL string
+	// RqstShare is the endpoint's fraction of the run's successful requests
+	RqstShare float64
+	// P95Nanos is the P95 latency of the endpoint's requests
+	P95Nanos time.Duration
+	// TailRqsts is the number of the endpoint's requests slower than the run's P95
+	TailRqsts int64
+	// TailShare is the endpoint's fraction of all the requests slower than the
+	// run's P95
+	TailShare float64
+}
+
+// BudgetExceeded describes the limit of a run's Budget that stopped the run
+type BudgetExceeded struct {
+	// Limit is the limit that was reached, BudgetMaxTotalRequests or
+	// BudgetMaxTotalBytes
+	Limit string
+	// Max is the limit's configured value
+	Max int64
+	// ReachedAt is when the limit was reached
+	ReachedAt time.Time
+	// AfterNanos is how long after the start of the run the limit was reached
+	AfterNanos time.Duration
+	// TotalRequests is the number of requests counted against the budget, including
+	// those in flight when it was reached
+	TotalRequests int64
+	// TotalBytes is the number of bytes counted against the budget, including those
+	// of the requests in flight when it was reached
+	TotalBytes int64
+	// Overshoot is how far the run went beyond Max, in requests or bytes depending
+	// on the Limit
+	Overshoot int64
+}
+
+// FirstFailure describes the failed request that ended a run configured with
+// StopOnFirstFailure, and its response, if it got one
+type FirstFailure struct {
+	// URL and Method are those of the request as it was sent, after its templates
+	// were rendered
+	URL    string
+	Method string
+	// EndpointURL is the URL of the configured endpoint the request was made to
+	EndpointURL    string            `json:",omitempty"`
+	RequestHeaders map[string]string `json:",omitempty"`
+	RequestBody    string            `json:",omitempty"`
+	// HTTPStatus is 0 if the request didn't get a response, see Error
+	HTTPStatus      int         `json:",omitempty"`
+	ResponseHeaders http.Header `json:",omitempty"`
+	// ResponseBody is the start of the response body, up to 64KiB
+	ResponseBody string `json:",omitempty"`
+	// ErrCategory and Error describe why the request failed, ErrCategory is empty
+	// if it failed only because of its HTTPStatus
+	ErrCategory          string `json:",omitempty"`
+	Error                string `json:",omitempty"`
+	RequestDurationNanos time.Duration
+	Retries              int
 }
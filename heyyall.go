@@ -14,8 +14,11 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"runtime/debug"
 	"runtime/pprof"
+	"strings"
 	"syscall"
 	"time"
 
@@ -31,10 +34,23 @@ import (
 func main() {
 	usage := `
 Usage: heyyall -config <ConfigFileLocation> [flags...]
+       heyyall -suite <ConfigFileLocation> -suite <ConfigFileLocation> ... [-out text|json]
+       heyyall replay-failures -from <ResultsFile> -config <ConfigFileLocation>
+
+The replay-failures command sends the failed requests recorded in a -results file again, one
+at a time, and prints each request and its response, e.g., to debug the failures of a run. The
+requests are identical to those originally sent, including their rendered templates. -config
+is the run's config, used to send each request with its endpoint's settings, e.g., its CertFile.
 
 Options:
   -loglevel  Logging level. Default is 'WARN' (2). 0 is DEBUG, 1 INFO, up to 4 FATAL
-  -out       Type of output report, 'text' or 'json'. Default is 'text'
+  -out       Type of output report, 'text', 'json', 'html', 'oneline', or 'kv'. Default is 'text'.
+             'html' produces a self-contained page, including latency and throughput charts, for
+             sharing. 'oneline' and 'kv' produce a single line summary, the latter as key=value
+             pairs, for scripting
+  -duration-unit  Unit of the durations in JSON reports, 'ns', 'us', 'ms', or 's'. Default is
+             'ns'. Each duration field is renamed for the unit, e.g., 'RunDurationNanos' is
+             written as 'RunDurationMillis' with a value of milliseconds as a float
   -nf        Normalization factor used to compress the output histogram by eliminating long tails. 
              Lower values provide a finer grained view of the data at the expense of dropping data
              associated with the tail of the latency distribution. The latter is partly mitigated by 
@@ -48,14 +64,118 @@ Options:
              the issue.
   -cpus      Specifies how many CPUs to use for the test run. The default is 0 which specifies that
 			 all CPUs should be used.
+  -http-version  The HTTP version to use, '1.1', '2', or '3'. The default is '1.1'. '2' is negotiated
+             with the server and falls back to HTTP/1.1 if the server doesn't support it. '3' is only
+             available if heyyall was built with HTTP/3 support, e.g., using 'make build-http3'.
+  -http3-0rtt  Send GET requests using 0-RTT when resuming HTTP/3 connections. Only used with
+             '-http-version 3'.
+  -early-fail-aborts-run  End the whole run, rather than only stopping requests to the endpoint,
+             when an endpoint's first responses are all errors. See Endpoint.EarlyFailThreshold.
+  -stop-on-first-failure  End the run as soon as any request fails and report the failed request
+             and its response in detail. The same as the StopOnFirstFailure config setting.
+  -results   Path of a file to record each response in, one JSON object per line (NDJSON). The first
+             line is a header describing the log.
+  -results-sample  The fraction of successful responses recorded in the -results file, e.g., 0.01
+             for 1%. Failed responses are always recorded. The default is 1, every response.
+  -results-seed  Seed for choosing the sampled responses, so the sampling is reproducible. The
+             default is 1.
+  -results-append  Append the run's records to the -results file, after those of earlier runs,
+             rather than replacing it, e.g., for a soak test split across restarts. Each run's
+             records follow their own header line.
+  -results-dir  Directory to write the run's artifacts to. Each run creates its own directory in
+             it, named after the time the run was set up and the -label, e.g.,
+             '2020-06-01T12-03-05_users', containing the summary as JSON (summary.json), the HTML
+             report (report.html), the config with its secrets redacted (config.json), the
+             -results log (results.ndjson), and a description of the heyyall build and host
+             (meta.txt). The results log is always recorded when this is set, at the -results
+             path if one is given. The run's directory is printed to stderr when the run ends.
+  -label     Label appended to the name of the run's -results-dir directory. The default is the
+             name of the config file without its extension.
+  -echo-config  Include the run's config in the JSON summary, as Meta.Config, so the results
+             describe how they were produced. It's the config after it's been resolved, e.g., its
+             EndpointTemplates applied and its endpoints filtered by -only and -skip, with the values
+             of sensitive request headers, e.g., Authorization, and the passwords in URLs redacted.
+  -har-out   Path of a file to write a sample of the run's requests and responses to, with their
+             headers, timings, and bodies, as a HAR 1.2 document, e.g., to analyze them in browser
+             devtools. Bodies are truncated to their first 64KiB. Pipelined, SSE, optimistic update,
+             and keep-alive probe requests aren't included.
+  -har-sample  The most successful requests of each endpoint sampled into the -har-out file. Up to 5
+             failed requests of each endpoint are included in addition. The default is 10.
+  -gc-percent  Sets heyyall's GOGC, the heap growth, as a percentage, that triggers a garbage
+             collection, e.g., 400 to collect less often at the cost of more memory. -1 disables
+             garbage collection. The default is 0, which leaves the GOGC environment variable, or
+             Go's default of 100, in effect.
+  -gc-ballast  Size, in MiB, of a heap ballast, memory allocated but never used, that makes the
+             garbage collections less frequent and more consistent across machines at high request
+             rates. The default is 0, no ballast.
+  -tui       Show a full-screen dashboard of the run, updated every second, instead of the progress
+             bar. Press 'q' to end the run early, the run's summary is written as usual, 'p' to pause
+             and resume the requests, and '+' or '-' to raise or lower the concurrency. It's ignored,
+             and the progress bar is shown, if stdout isn't a terminal.
+  -start-at  An RFC 3339 time, e.g., '2020-06-01T15:04:05.000Z', to start sending requests at. The
+             run's setup is completed first. It's used to start runs on several machines at the same
+             time. It's an error if the time has already passed.
+  -save-baseline  Path of a file to save a summary of the run's results to, as a baseline for later
+             comparison, replacing any baseline already there. It's only saved if the run succeeds.
+  -forbid-cross-host-redirects  Fail requests redirected to a different host, counting them as
+             CrossHostRedirect errors, rather than following the redirect.
+  -no-adaptive-concurrency  Don't reduce the concurrency when requests fail because heyyall ran
+             out of file descriptors or ports (ResourceExhausted errors). By default sustained
+             ResourceExhausted errors halve the requests allowed in flight, and the concurrency is
+             restored in steps once they stop. Each change is reported, along with separate summaries
+             of the run before and after the first reduction. Use this to see the raw failures.
+  -only      Only run the endpoints with this Name or Tag, or, for endpoints without a Name, whose
+             URL contains it. It can be repeated to run the endpoints matching any of them.
+  -skip      Don't run the endpoints matching this, matched the same way as -only. It can be
+             repeated. The RqstPercents of the endpoints that are run are scaled to add up to 100.
+  -suite     Run this config as a scenario of a suite, instead of running -config. It can be
+             repeated, the scenarios are run one after the other in the order they're given. The
+             report includes the summary of each scenario followed by an aggregate summary of all
+             of them. Scenarios can't use Phases or a ReplayLog. Only the 'text' and 'json' -out
+             types are supported, 'json' is used for the others.
+  -dry-run  Print the endpoints that would be run, as JSON, with their EndpointTemplates resolved
+             and any -only and -skip flags applied, and exit without sending any requests.
   -help     This usage message
 `
 
+	if len(os.Args) > 1 && os.Args[1] == "replay-failures" {
+		replayFailures(os.Args[2:], usage)
+		return
+	}
+
 	configFile := flag.String("config", "", "path and filename containing the runtime configuration")
 	logLevel := flag.Int("loglevel", int(zerolog.WarnLevel), "log level, 0 for debug, 1 info, 2 warn, ...")
-	outputType := flag.String("out", "text", "what type of report is desired, 'text' or 'json'")
+	outputType := flag.String("out", "text", "what type of report is desired, 'text', 'json', 'html', 'oneline', or 'kv'")
+	durationUnitFlag := flag.String("duration-unit", "ns", "unit of the durations in JSON reports, 'ns', 'us', 'ms', or 's'")
 	normalizationFactor := flag.Int("nf", 0, "normalization factor used to compress the output histogram by eliminating long tails. If provided, the value must be at least 10. The default is 0 which signifies no normalization will be done")
 	cpus := flag.Int("cpus", 0, "number of CPUs to use for the test run. Default is 0 which specifies all CPUs are to be used.")
+	httpVersion := flag.String("http-version", "1.1", "HTTP version to use, '1.1', '2', or '3'")
+	http3ZeroRTT := flag.Bool("http3-0rtt", false, "send GET requests using 0-RTT when resuming HTTP/3 connections")
+	earlyFailAbortsRun := flag.Bool("early-fail-aborts-run", false, "end the run if an endpoint's first responses are all errors")
+	stopOnFirstFailure := flag.Bool("stop-on-first-failure", false, "end the run as soon as any request fails")
+	resultsFile := flag.String("results", "", "path of a file to record each response in as NDJSON")
+	resultsSample := flag.Float64("results-sample", 1, "fraction of successful responses recorded in the -results file")
+	resultsAppend := flag.Bool("results-append", false, "append to the -results file, if it exists, rather than replacing it")
+	resultsSeed := flag.Int64("results-seed", 1, "seed for choosing the responses sampled into the -results file")
+	var only, skip stringsFlag
+	flag.Var(&only, "only", "only run the endpoints with this name or tag, or whose URL contains it, can be repeated")
+	flag.Var(&skip, "skip", "don't run the endpoints with this name or tag, or whose URL contains it, can be repeated")
+	resultsDir := flag.String("results-dir", "", "directory to create a timestamped directory of the run's artifacts in")
+	label := flag.String("label", "", "label of the run's -results-dir directory, the config file's name by default")
+	echoConfig := flag.Bool("echo-config", false, "include the resolved config, with its secrets redacted, in the JSON summary")
+	harOut := flag.String("har-out", "", "path of a file to write a sample of the requests and responses to as a HAR")
+	harSample := flag.Int("har-sample", 10, "most successful requests of each endpoint sampled into the -har-out file")
+	var suite stringsFlag
+	flag.Var(&suite, "suite", "run this config as a scenario of a suite, can be repeated")
+	gcPercent := flag.Int("gc-percent", 0, "GOGC to use, -1 disables garbage collection, 0 leaves it unchanged")
+	gcBallast := flag.Int("gc-ballast", 0, "size in MiB of a heap ballast that makes garbage collections less frequent")
+	tui := flag.Bool("tui", false, "show a full-screen dashboard of the run while it's in progress")
+	startAtFlag := flag.String("start-at", "", "RFC 3339 time at which to start sending requests, after completing the run's setup")
+	saveBaseline := flag.String("save-baseline", "", "path of a file to save the run's results to as a baseline if the run succeeds")
+	noAdaptiveConcurrency := flag.Bool("no-adaptive-concurrency", false, "don't reduce the concurrency when the run exhausts heyyall's file descriptors or ports")
+	forbidCrossHostRedirects := flag.Bool("forbid-cross-host-redirects", false, "fail requests redirected to a different host rather than following the redirect")
+	injectFailures := flag.Float64("inject-failures", 0, "debug: fraction of requests to fail before they're sent, to test heyyall's error handling")
+	dryRun := flag.Bool("dry-run", false, "print the resolved endpoints and exit without sending any requests")
 	help := flag.Bool("help", false, "help will emit detailed usage instructions and exit")
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
 
@@ -75,7 +195,7 @@ Options:
 		return
 	}
 
-	if *configFile == "" {
+	if *configFile == "" && len(suite) == 0 {
 		fmt.Println("Config file location not provided")
 		fmt.Println(usage)
 		os.Exit(1)
@@ -87,12 +207,58 @@ Options:
 
 	zerolog.SetGlobalLevel(zerolog.Level(*logLevel))
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.StampMilli})
+	if *gcBallast < 0 {
+		log.Fatal().Msgf("-gc-ballast %d is invalid, it must be at least 0", *gcBallast)
+	}
+	if *gcPercent != 0 {
+		debug.SetGCPercent(*gcPercent)
+	}
+	// The ballast is never touched, so it's never paged in, but it's counted in the
+	// size of the heap and so delays the next garbage collection
+	ballast := make([]byte, int64(*gcBallast)<<20)
+	defer runtime.KeepAlive(ballast)
+
+	durationUnit, err := internal.ParseDurationUnit(*durationUnitFlag)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid -duration-unit")
+	}
+	if len(suite) > 0 {
+		runSuite(suite, *outputType, durationUnit)
+		return
+	}
 	log.Info().Msgf("heyyall started with config from %s", *configFile)
 
 	config, err := getConfig(*configFile)
 	if err != nil {
 		log.Fatal().Err(err).Msg("error loading configuration")
 	}
+	startAt, err := internal.ParseStartAt(*startAtFlag, time.Now())
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid -start-at")
+	}
+
+	config, disabledEndpoints, err := internal.RemoveDisabledEndpoints(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+	if len(disabledEndpoints) > 0 {
+		fmt.Fprintf(os.Stderr, "heyyall: skipping disabled endpoints %s\n", strings.Join(disabledEndpoints, ", "))
+	}
+	config, endpointFilter, err := internal.FilterEndpoints(config, only, skip)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+	if endpointFilter != nil {
+		fmt.Fprintf(os.Stderr, "heyyall: running endpoints %s\n", strings.Join(endpointFilter.Endpoints, ", "))
+	}
+	if *dryRun {
+		b, err := json.MarshalIndent(config.Endpoints, "", "  ")
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to encode the endpoints")
+		}
+		fmt.Println(string(b))
+		return
+	}
 
 	availCPUs := runtime.NumCPU()
 	if *cpus > availCPUs {
@@ -107,19 +273,191 @@ Options:
 	doneC := make(chan interface{})
 	progressC := make(chan interface{})
 
+	uniqueInts, err := internal.NewUniqueIntCounters(config.UniqueIntRanges)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+	var dataSet *internal.DataSet
+	if config.DataFile != "" {
+		if dataSet, err = internal.LoadDataSet(config.DataFile, config.DataFileOrder); err != nil {
+			log.Fatal().Err(err).Msg("error loading configuration")
+		}
+	}
+
+	if err = internal.ValidateBodySizeClasses(config.BodySizeClasses); err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+
+	if err = internal.ValidateOutlierPolicy(config.OutlierPolicy); err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+
+	if err = internal.ValidateStatusExpectations(config.ExpectedStatusDistribution); err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+
+	if err = internal.ValidateSLOs(config.SLOs); err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+
+	if err = internal.ValidateCanaryBaseURLs(config.CanaryBaseURLs, config.Endpoints); err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+
+	switch config.RollingSummaryMode {
+	case "", api.RollingSummaryCumulative, api.RollingSummaryInterval:
+	default:
+		log.Fatal().Msgf("RollingSummaryMode must be %q or %q, got %q", api.RollingSummaryCumulative,
+			api.RollingSummaryInterval, config.RollingSummaryMode)
+	}
+
+	switch config.SummaryKey {
+	case "", api.SummaryKeyFull, api.SummaryKeyPath, api.SummaryKeyPathAndQueryKeys:
+	default:
+		log.Fatal().Msgf("SummaryKey must be %q, %q, or %q, got %q", api.SummaryKeyFull, api.SummaryKeyPath,
+			api.SummaryKeyPathAndQueryKeys, config.SummaryKey)
+	}
+	if err := internal.ValidateURLPathTemplates(config.URLPathTemplates); err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+
+	earlyFail := internal.NewEarlyFailTracker(*earlyFailAbortsRun)
+	var har *internal.HARRecorder
+	if *harOut != "" {
+		if har, err = internal.NewHARRecorder(*harSample, time.Now().UnixNano()); err != nil {
+			log.Fatal().Err(err).Msg("invalid -har-sample")
+		}
+	}
+	var firstFailure *internal.FirstFailureTracker
+	if config.StopOnFirstFailure || *stopOnFirstFailure {
+		firstFailure = internal.NewFirstFailureTracker()
+	}
+	lockGroups, err := internal.NewLockGroups(config.LockGroups, config.Endpoints)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+	rateLimits, err := internal.NewRateLimits(config.Endpoints)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+	circuitBreakers, err := internal.NewCircuitBreakers(config.Endpoints)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+	budget, err := internal.NewBudgetTracker(config.Budget)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+	responseHook, err := internal.NewResponseHookRunner(config.ResponseHook)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+	crawlers, err := internal.NewCrawlers(config.Endpoints)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+	headerRotators, err := internal.NewHeaderRotators(config.Endpoints, time.Now().UnixNano())
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+	clientCaches := internal.NewClientCaches(config.SimulateClientCache)
+	if config.Budget != nil && config.Budget.PerWorker && (config.ReplayLog != "" || len(config.Phases) > 0) {
+		log.Fatal().Msg("a Budget split PerWorker can't be used with Phases or a ReplayLog")
+	}
+
+	var runDir string
+	if *resultsDir != "" {
+		runDir = newRunDir(*resultsDir, *label, *configFile, config)
+		if *resultsFile == "" {
+			*resultsFile = filepath.Join(runDir, internal.ResultsDirResultsLog)
+		}
+	}
+
+	var resultsLog *internal.ResultsLog
+	if *resultsFile != "" {
+		f, err := internal.OpenResultsFile(*resultsFile, *resultsAppend)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to open the results file")
+		}
+		defer f.Close()
+		resultsLog, err = internal.NewResultsLog(f, *resultsSample, *resultsSeed)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to create the results log")
+		}
+	}
+
+	var phases *internal.PhaseTracker
+	if len(config.Phases) > 0 {
+		phases = internal.NewPhaseTracker()
+	}
+
 	var reportDetail internal.OutputType = internal.JSON
-	if *outputType == "text" {
+	switch *outputType {
+	case "text":
 		reportDetail = internal.Text
+	case "html":
+		reportDetail = internal.HTML
+	case "oneline":
+		reportDetail = internal.OneLine
+	case "kv":
+		reportDetail = internal.KV
+	}
+	workerTime := internal.NewWorkerTimeAccounting()
+	backpressure := internal.NewBackpressureTracker()
+	showDashboard := *tui && internal.IsTerminal(os.Stdout)
+	var adaptiveConcurrency *internal.AdaptiveConcurrency
+	if !*noAdaptiveConcurrency {
+		adaptiveConcurrency = internal.NewAdaptiveConcurrency()
+	} else if showDashboard {
+		// The dashboard still pauses, and changes, the concurrency
+		adaptiveConcurrency = internal.NewManualConcurrency()
 	}
 	responseHandler := &internal.ResponseHandler{
-		OutputType: reportDetail,
-		ResponseC:  responseC,
-		ProgressC:  progressC,
-		DoneC:      doneC,
-		NumRqsts:   config.NumRequests,
-		NormFactor: *normalizationFactor,
+		OutputType:             reportDetail,
+		DurationUnit:           durationUnit,
+		ResponseC:              responseC,
+		ProgressC:              progressC,
+		DoneC:                  doneC,
+		NumRqsts:               config.NumRequests,
+		Concurrency:            config.MaxConcurrentRqsts,
+		NormFactor:             *normalizationFactor,
+		UniqueInts:             uniqueInts,
+		EarlyFail:              earlyFail,
+		CircuitBreakers:        circuitBreakers,
+		FirstFailure:           firstFailure,
+		Budget:                 budget,
+		Canary:                 config.CanaryBaseURLs,
+		OutlierPolicy:          config.OutlierPolicy,
+		ExpectedStatusDist:     config.ExpectedStatusDistribution,
+		MaxP99:                 parseOptionalDuration("MaxP99", config.MaxP99),
+		ApdexTarget:            parseOptionalDuration("ApdexTarget", config.ApdexTarget),
+		SLOs:                   config.SLOs,
+		WarmupDuration:         parseOptionalDuration("WarmupDuration", config.WarmupDuration),
+		SizeClasses:            config.BodySizeClasses,
+		CacheHitHeaders:        config.CacheHitHeaders,
+		InstanceHeader:         config.InstanceHeader,
+		Phases:                 phases,
+		AdaptiveConcurrency:    adaptiveConcurrency,
+		ResultsLog:             resultsLog,
+		RollingSummaryInterval: parseOptionalDuration("RollingSummaryInterval", config.RollingSummaryInterval),
+		RollingSummaryMode:     config.RollingSummaryMode,
+		SummaryKey:             config.SummaryKey,
+		URLPathTemplates:       config.URLPathTemplates,
+		EndpointFilter:         endpointFilter,
+		DisabledEndpoints:      disabledEndpoints,
+		ScheduledStart:         startAt,
+		ResultsDir:             runDir,
+		GCPercent:              *gcPercent,
+		GCBallastBytes:         int64(*gcBallast) << 20,
+		WorkerTime:             workerTime,
+		Backpressure:           backpressure,
+		ResponseHook:           responseHook,
+		Crawlers:               crawlers,
+		ClientCaches:           clientCaches,
+	}
+	if *echoConfig {
+		responseHandler.EchoConfig = &config
 	}
-	go responseHandler.Start()
 
 	var cert tls.Certificate
 	if config.CertFile != "" && config.KeyFile != "" {
@@ -129,14 +467,49 @@ Options:
 		}
 	}
 
+	softDeadline := parseOptionalDuration("SoftDeadline", config.SoftDeadline)
+	responseHeaderTimeout := parseOptionalDuration("ResponseHeaderTimeout", config.ResponseHeaderTimeout)
+	readIdleTimeout := parseOptionalDuration("ReadIdleTimeout", config.ReadIdleTimeout)
+
+	warmupConns := config.WarmupConnections
+	if config.PrewarmConnections {
+		if warmupConns > 0 {
+			log.Fatal().Msg("PrewarmConnections can't be used with WarmupConnections")
+		}
+		warmupConns = config.MaxConcurrentRqsts
+	}
+	if config.MaxConnsPerHost < 0 {
+		log.Fatal().Msgf("MaxConnsPerHost %d is invalid, it must be at least 0", config.MaxConnsPerHost)
+	}
+
 	// TODO: Make Transport configurable, including timeout that's currently on the client below
-	t := &http.Transport{
-		MaxIdleConnsPerHost: config.MaxConcurrentRqsts,
-		DisableCompression:  false,
-		DisableKeepAlives:   false,
-		TLSClientConfig: &tls.Config{
-			Certificates: []tls.Certificate{cert},
-		},
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	var t http.RoundTripper
+	switch *httpVersion {
+	case "1.1", "2":
+		maxIdleConns := config.MaxConcurrentRqsts
+		if warmupConns > maxIdleConns {
+			maxIdleConns = warmupConns
+		}
+		t = &http.Transport{
+			MaxIdleConnsPerHost:   maxIdleConns,
+			MaxConnsPerHost:       config.MaxConnsPerHost,
+			DisableCompression:    false,
+			DisableKeepAlives:     false,
+			ForceAttemptHTTP2:     *httpVersion == "2",
+			TLSClientConfig:       tlsConfig,
+			ResponseHeaderTimeout: responseHeaderTimeout,
+			DialContext:           internal.WithReadIdleTimeout(nil, readIdleTimeout),
+		}
+	case "3":
+		t, err = internal.NewHTTP3Transport(tlsConfig, *http3ZeroRTT)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to use HTTP/3")
+		}
+	default:
+		log.Fatal().Msgf("-http-version %s is invalid, it must be one of '1.1', '2', or '3'", *httpVersion)
 	}
 	dur, err := time.ParseDuration(config.RunDuration)
 	if err != nil {
@@ -151,29 +524,125 @@ Options:
 	)
 
 	if int64(dur) > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), dur)
 		client = http.Client{Transport: t, Timeout: dur}
 	} else {
-		ctx, cancel = context.WithCancel(context.Background())
 		// TODO: Make Client.Timeout configurable?
 		client = http.Client{Transport: t, Timeout: 15 * time.Second}
 	}
+
+	// The connections are warmed up before the run's duration starts
+	var warmPool *internal.WarmPool
+	if warmupConns > 0 {
+		warmPool, err = internal.WarmUp(context.Background(), client, config.Endpoints, warmupConns, config.WarmupMethod)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error loading configuration")
+		}
+		responseHandler.WarmPool = warmPool
+		log.Info().Msgf("heyyall: opened %d warmup connections", warmPool.Opened())
+	}
+
+	// The run's duration is measured from its scheduled start, if it has one, rather
+	// than from the end of its setup
+	runStart := time.Now()
+	if !startAt.IsZero() {
+		runStart = startAt
+	}
+	if int64(dur) > 0 {
+		ctx, cancel = context.WithDeadline(context.Background(), runStart.Add(dur))
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
 	defer cancel()
 
 	rqstr := internal.Requestor{
-		Ctx:       ctx,
-		ResponseC: responseC,
-		Client:    client,
+		Ctx:                      ctx,
+		ResponseC:                responseC,
+		Client:                   client,
+		Cancel:                   cancel,
+		UniqueInts:               uniqueInts,
+		Data:                     dataSet,
+		SoftDeadline:             softDeadline,
+		Retry:                    config.Retry,
+		FailOnMalformedURL:       config.FailOnMalformedURL,
+		ReadIdleTimeout:          readIdleTimeout,
+		EarlyFail:                earlyFail,
+		FirstFailure:             firstFailure,
+		Canary:                   config.CanaryBaseURLs,
+		LockGroups:               lockGroups,
+		RateLimits:               rateLimits,
+		AdaptiveConcurrency:      adaptiveConcurrency,
+		CircuitBreakers:          circuitBreakers,
+		Budget:                   budget,
+		RecordResponseHeaders:    config.RecordResponseHeaders,
+		MaxResponseBodyBytes:     config.MaxResponseBodyBytes,
+		TraceIDHeader:            config.TraceIDHeader,
+		RequestIDHeader:          config.RequestIDHeader,
+		HeaderStats:              config.HeaderStats,
+		ResponseHook:             responseHook,
+		Crawlers:                 crawlers,
+		HeaderRotators:           headerRotators,
+		ClientCaches:             clientCaches,
+		ConditionalRequests:      config.ConditionalRequests,
+		WarmPool:                 warmPool,
+		ClockSkew:                parseOptionalDuration("ClockSkew", config.ClockSkew),
+		ForbidCrossHostRedirects: *forbidCrossHostRedirects,
+		WorkerTime:               workerTime,
+		Backpressure:             backpressure,
+		HAR:                      har,
+	}
+	if rqstr.FailureInjector, err = internal.NewFailureInjector(*injectFailures, time.Now().UnixNano()); err != nil {
+		log.Fatal().Err(err).Msg("invalid -inject-failures")
+	}
+	if config.TracePropagation {
+		rqstr.Tracer = internal.W3CTracer{}
 	}
 
-	scheduler, err := internal.NewScheduler(config.MaxConcurrentRqsts, config.RqstRate, dur,
-		config.NumRequests, config.Endpoints, rqstr)
+	var scheduler interface{ Start() error }
+	switch {
+	case config.ReplayLog != "":
+		scheduler, err = newReplayScheduler(ctx, config, rqstr)
+	case len(config.Phases) > 0:
+		newRqstr := func(ctx context.Context, responseC chan internal.Response) internal.IRequestor {
+			phaseRqstr := rqstr
+			phaseRqstr.Ctx = ctx
+			phaseRqstr.ResponseC = responseC
+			return phaseRqstr
+		}
+		scheduler, err = internal.NewPhaseScheduler(ctx, config, responseC, newRqstr, phases)
+	default:
+		var s *internal.Scheduler
+		s, err = internal.NewScheduler(config.MaxConcurrentRqsts, float64(config.RqstRate), dur,
+			config.NumRequests, config.Endpoints, rqstr)
+		if err == nil {
+			s.ShareBudget(config.Budget)
+		}
+		scheduler = s
+	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("Unexpected error configuring new Requestor")
 		return
 	}
 
-	go startProgressBar(progressC, doneC, dur, config.NumRequests)
+	if !startAt.IsZero() {
+		log.Info().Msgf("heyyall: waiting until %s to start", startAt.Format(time.RFC3339Nano))
+		started, err := internal.WaitForStart(startAt)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to start the run at the scheduled time")
+		}
+		log.Info().Msgf("heyyall: started at %s", started.Format(time.RFC3339Nano))
+	}
+
+	if showDashboard {
+		responseHandler.Dashboard = internal.NewDashboard(os.Stdout, os.Stdin, cancel, adaptiveConcurrency)
+		responseHandler.ProgressC = nil
+	} else if *tui {
+		log.Warn().Msg("heyyall: stdout isn't a terminal, showing the progress bar instead of the dashboard")
+	}
+
+	go responseHandler.Start()
+	if responseHandler.Dashboard == nil {
+		go startProgressBar(progressC, doneC, dur, config.NumRequests)
+	}
 
 	go scheduler.Start()
 
@@ -189,6 +658,198 @@ Options:
 	}
 
 	log.Info().Msg("heyyall: DONE")
+	if runDir != "" {
+		fmt.Fprintf(os.Stderr, "heyyall: results written to %s\n", runDir)
+	}
+	if har != nil {
+		if err := har.WriteFile(*harOut); err != nil {
+			log.Error().Err(err).Msg("heyyall: unable to write the HAR")
+		}
+	}
+
+	if responseHandler.Results == nil {
+		return
+	}
+	if *saveBaseline != "" {
+		if err := internal.SaveBaseline(*saveBaseline, *responseHandler.Results); err != nil {
+			log.Error().Err(err).Msgf("heyyall: unable to save the baseline to %s", *saveBaseline)
+		}
+	}
+	rs := responseHandler.Results.RunSummary
+	if rs.StatusDistFailed {
+		log.Error().Msgf("heyyall: the response statuses didn't meet ExpectedStatusDistribution: %s",
+			strings.Join(rs.StatusDistViolations, "; "))
+	}
+	if rs.MaxP99Failed {
+		log.Error().Msgf("heyyall: %s", rs.MaxP99Violation)
+	}
+	for _, result := range rs.SLOResults {
+		if !result.Passed {
+			log.Error().Msgf("heyyall: SLO %s wasn't met: %s", result.Name, result.Violation)
+		}
+	}
+	if rs.UniqueIntFailed {
+		log.Error().Msgf("heyyall: the run was ended by %s", rs.UniqueIntFailure)
+	}
+	if rs.StatusDistFailed || rs.MaxP99Failed || rs.SLOFailed || rs.UniqueIntFailed {
+		// os.Exit doesn't run the deferred calls, a no-op if profiling wasn't enabled
+		pprof.StopCPUProfile()
+		os.Exit(1)
+	}
+}
+
+// stringsFlag is a flag that can be repeated, collecting each of its values
+type stringsFlag []string
+
+// String implements flag.Value
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set implements flag.Value
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// runSuite runs the configs in 'configFiles', one after the other, as the scenarios of
+// a suite and writes the suite's report to stdout. It exits with a non-zero status if
+// any of the scenarios didn't meet its ExpectedStatusDistribution.
+func runSuite(configFiles []string, outputType string, durationUnit internal.DurationUnit) {
+	log.Info().Msgf("heyyall started with suite %s", strings.Join(configFiles, ", "))
+	var scenarios []internal.Scenario
+	maxConcurrency := 0
+	for _, configFile := range configFiles {
+		config, err := getConfig(configFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error loading configuration")
+		}
+		name := strings.TrimSuffix(filepath.Base(configFile), filepath.Ext(configFile))
+		scenarios = append(scenarios, internal.Scenario{Name: name, Config: config})
+		if config.MaxConcurrentRqsts > maxConcurrency {
+			maxConcurrency = config.MaxConcurrentRqsts
+		}
+	}
+
+	// TODO: Make Client.Timeout configurable?
+	client := http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: maxConcurrency}, Timeout: 15 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Debug().Msg("heyyall: SIGTERM caught, ending the suite")
+		cancel()
+	}()
+
+	results, err := internal.RunSuite(ctx, client, scenarios)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to run the suite")
+	}
+	reportDetail := internal.JSON
+	if outputType == "text" {
+		reportDetail = internal.Text
+	}
+	if err = internal.WriteSuiteReport(os.Stdout, reportDetail, durationUnit, results); err != nil {
+		log.Fatal().Err(err).Msg("unable to write the suite's report")
+	}
+
+	failed := false
+	for _, s := range results.Scenarios {
+		if rs := s.Results.RunSummary; rs.StatusDistFailed {
+			log.Error().Msgf("heyyall: scenario %s's response statuses didn't meet ExpectedStatusDistribution: %s",
+				s.Name, strings.Join(rs.StatusDistViolations, "; "))
+			failed = true
+		}
+	}
+	if failed {
+		// os.Exit doesn't run the deferred calls
+		cancel()
+		os.Exit(1)
+	}
+}
+
+// replayFailures implements the replay-failures command, replaying the failed
+// requests recorded in a results file. 'args' are the command's arguments.
+func replayFailures(args []string, usage string) {
+	flags := flag.NewFlagSet("replay-failures", flag.ExitOnError)
+	from := flags.String("from", "", "path of the -results file recording the failed requests")
+	configFile := flags.String("config", "", "path and filename containing the run's configuration")
+	logLevel := flags.Int("loglevel", int(zerolog.WarnLevel), "log level, 0 for debug, 1 info, 2 warn, ...")
+	flags.Parse(args)
+
+	zerolog.SetGlobalLevel(zerolog.Level(*logLevel))
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.StampMilli})
+	if *from == "" || *configFile == "" {
+		fmt.Println("replay-failures requires both -from and -config")
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+
+	config, err := getConfig(*configFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading configuration")
+	}
+	f, err := os.Open(*from)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to open the results file")
+	}
+	defer f.Close()
+
+	tlsConfig := &tls.Config{}
+	if config.CertFile != "" && config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error creating x509 keypair")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: 15 * time.Second}
+
+	n, err := internal.ReplayFailures(context.Background(), client, config, f, os.Stdout)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to replay the failed requests")
+	}
+	if n == 0 {
+		fmt.Fprintf(os.Stderr, "heyyall: %s doesn't record any failed requests\n", *from)
+	}
+}
+
+// newRunDir creates the run's directory in 'resultsDir' and writes the artifacts
+// known before the run starts to it, returning its path. The directory is labeled
+// 'label', or, if it's empty, the name of 'configFile'.
+func newRunDir(resultsDir, label, configFile string, config api.LoadTestConfig) string {
+	if label == "" {
+		label = strings.TrimSuffix(filepath.Base(configFile), filepath.Ext(configFile))
+	}
+	now := time.Now()
+	runDir, err := internal.NewResultsDir(resultsDir, label, now)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to create the run's results directory")
+	}
+	if err = internal.WriteResultsConfig(runDir, config); err != nil {
+		log.Fatal().Err(err).Msg("unable to write the config to the run's results directory")
+	}
+	if err = internal.WriteResultsMeta(runDir, os.Args, now); err != nil {
+		log.Fatal().Err(err).Msg("unable to write meta.txt to the run's results directory")
+	}
+	return runDir
+}
+
+// newReplayScheduler returns a scheduler replaying the requests in 'config.ReplayLog'
+func newReplayScheduler(ctx context.Context, config api.LoadTestConfig, rqstr internal.Requestor) (*internal.ReplayScheduler, error) {
+	f, err := os.Open(config.ReplayLog)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open replay log %s: %w", config.ReplayLog, err)
+	}
+	defer f.Close()
+
+	entries, err := internal.ReadReplayLog(f)
+	if err != nil {
+		return nil, err
+	}
+	return internal.NewReplayScheduler(ctx, config.MaxConcurrentRqsts, config.ReplaySpeed, entries, rqstr)
 }
 
 func getConfig(fileName string) (api.LoadTestConfig, error) {
@@ -203,9 +864,29 @@ func getConfig(fileName string) (api.LoadTestConfig, error) {
 	if err = json.Unmarshal(contents, &config); err != nil {
 		return api.LoadTestConfig{}, fmt.Errorf("error unmarshaling test config bytes: %s", string(contents))
 	}
+	if err = internal.ResolveEndpointTemplates(contents, &config); err != nil {
+		return api.LoadTestConfig{}, fmt.Errorf("invalid config file %s: %w", fileName, err)
+	}
+	if err = internal.ResolveDuplicateEndpoints(&config); err != nil {
+		return api.LoadTestConfig{}, fmt.Errorf("invalid config file %s: %w", fileName, err)
+	}
 	return config, nil
 }
 
+// parseOptionalDuration parses the duration 'value' of the config field 'name'. An
+// empty value is 0.
+func parseOptionalDuration(name, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("%s: %s, must be of the form 'xs' or xm where 'x' is an integer and 's' indicates seconds and 'm' indicates minutes",
+			name, value)
+	}
+	return d
+}
+
 func startProgressBar(progressC chan interface{}, doneC chan interface{}, dur time.Duration, numRqsts int) {
 	progress := mpb.New(mpb.WithWidth(64))
 	var total int64
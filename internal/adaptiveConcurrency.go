@@ -0,0 +1,332 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+const (
+	// exhaustionThreshold is the number of api.ErrCategoryResourceExhausted errors
+	// within exhaustionWindow that reduces the concurrency
+	exhaustionThreshold = 5
+	// exhaustionWindow is the window exhaustionThreshold errors must occur in. It's
+	// also the least time between reductions so the errors of the requests already
+	// in flight when the concurrency is reduced don't reduce it again.
+	exhaustionWindow = time.Second
+	// recoveryPeriod is how long there must be no api.ErrCategoryResourceExhausted
+	// errors before the concurrency is raised, a step at a time. It's much
+	// longer than exhaustionWindow so the concurrency doesn't flap.
+	recoveryPeriod = 5 * time.Second
+	// recoverySteps is the number of steps the concurrency is restored in
+	recoverySteps = 4
+	// manualSteps is the number of steps the concurrency is changed in from the
+	// Dashboard
+	manualSteps = 10
+	// manualReason is the reason recorded for changes made from the Dashboard
+	manualReason = "changed from the dashboard"
+)
+
+// isResourceExhausted returns true if 'err' means heyyall ran out of a local resource,
+// file descriptors or ephemeral ports, rather than that the request failed
+func isResourceExhausted(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) || errors.Is(err, syscall.EADDRNOTAVAIL) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "too many open files") || strings.Contains(msg, "cannot assign requested address")
+}
+
+// concurrencyAdjustment is a change to the AdaptiveConcurrency's limit
+type concurrencyAdjustment struct {
+	at       time.Time
+	from, to int
+	reason   string
+}
+
+// AdaptiveConcurrency reduces the number of requests in flight when sustained
+// api.ErrCategoryResourceExhausted errors show the run needs more file descriptors
+// or ports than are available, and restores it once they stop. The Dashboard also
+// uses it to pause the requests and to change the concurrency. It's shared by all
+// requestors.
+type AdaptiveConcurrency struct {
+	// automatic is true if the concurrency is reduced on resource exhaustion errors,
+	// otherwise it's only changed from the Dashboard
+	automatic bool
+
+	mux      sync.Mutex
+	inFlight int
+	// peak is the most requests that have been in flight
+	peak int
+	// limit is the most requests allowed in flight, 0 if they aren't limited
+	limit int
+	// ceiling is the number of requests in flight, the peak, when the concurrency was
+	// first reduced. The limit is removed once it's restored to the ceiling.
+	ceiling int
+	// changed is closed, and replaced, when a request ends, the limit is raised, or
+	// the requests are resumed
+	changed chan struct{}
+	// paused is true if no more requests are allowed in flight until they're resumed
+	paused bool
+	// manual is true if the limit was last changed from the Dashboard, in which case
+	// it isn't restored automatically
+	manual bool
+
+	// windowStart is the start of the exhaustionWindow the 'exhausted' errors were
+	// received in
+	windowStart   time.Time
+	exhausted     int
+	lastExhausted time.Time
+	lastAdjusted  time.Time
+	adjustments   []concurrencyAdjustment
+}
+
+// NewAdaptiveConcurrency returns an AdaptiveConcurrency that doesn't limit the
+// requests in flight until they exhaust a local resource
+func NewAdaptiveConcurrency() *AdaptiveConcurrency {
+	return &AdaptiveConcurrency{automatic: true, changed: make(chan struct{})}
+}
+
+// NewManualConcurrency returns an AdaptiveConcurrency that's only paused, or changed,
+// from the Dashboard, e.g., when the concurrency isn't to be reduced automatically
+func NewManualConcurrency() *AdaptiveConcurrency {
+	return &AdaptiveConcurrency{changed: make(chan struct{})}
+}
+
+// acquire waits until another request is allowed in flight. It returns false if
+// 'ctx' was done before it was. 'a' may be nil.
+func (a *AdaptiveConcurrency) acquire(ctx context.Context) bool {
+	if a == nil {
+		return true
+	}
+	for {
+		a.mux.Lock()
+		if !a.paused && (a.limit == 0 || a.inFlight < a.limit) {
+			a.inFlight++
+			if a.inFlight > a.peak {
+				a.peak = a.inFlight
+			}
+			a.mux.Unlock()
+			return true
+		}
+		changed := a.changed
+		a.mux.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// release ends a request allowed in flight by acquire. 'a' may be nil.
+func (a *AdaptiveConcurrency) release() {
+	if a == nil {
+		return
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.inFlight--
+	a.notify()
+}
+
+// notify wakes the requestors waiting in acquire. It must be called with a.mux held.
+func (a *AdaptiveConcurrency) notify() {
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// record adjusts the concurrency for 'resp'. 'a' may be nil.
+func (a *AdaptiveConcurrency) record(resp Response) {
+	if a == nil || !a.automatic {
+		return
+	}
+	a.recordAt(resp.ErrCategory == api.ErrCategoryResourceExhausted, time.Now())
+}
+
+// recordAt reduces the concurrency if 'exhausted', a resource exhaustion error at
+// 'now', is the exhaustionThreshold error within the exhaustionWindow, or raises it
+// if there haven't been any errors for the recoveryPeriod
+func (a *AdaptiveConcurrency) recordAt(exhausted bool, now time.Time) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if !exhausted {
+		if a.limit > 0 && !a.manual && now.Sub(a.lastExhausted) >= recoveryPeriod && now.Sub(a.lastAdjusted) >= recoveryPeriod {
+			step := a.ceiling / recoverySteps
+			if step < 1 {
+				step = 1
+			}
+			to := a.limit + step
+			if to > a.ceiling {
+				to = a.ceiling
+			}
+			a.adjust(now, to, fmt.Sprintf("no %s errors for %s", api.ErrCategoryResourceExhausted, recoveryPeriod))
+			if to == a.ceiling {
+				a.limit = 0
+			}
+			a.notify()
+		}
+		return
+	}
+
+	a.lastExhausted = now
+	if now.Sub(a.windowStart) > exhaustionWindow {
+		a.windowStart, a.exhausted = now, 0
+	}
+	a.exhausted++
+	if a.exhausted < exhaustionThreshold || now.Sub(a.lastAdjusted) < exhaustionWindow {
+		return
+	}
+	from := a.limit
+	if from == 0 {
+		from = a.peak
+		a.ceiling = a.peak
+	}
+	to := from / 2
+	if to < 1 {
+		to = 1
+	}
+	if to == from {
+		return
+	}
+	a.adjust(now, to, fmt.Sprintf("%d %s errors within %s", a.exhausted, api.ErrCategoryResourceExhausted, exhaustionWindow))
+	a.windowStart, a.exhausted, a.manual = now, 0, false
+}
+
+// setPaused pauses, or resumes, the requests. While they're paused no more requests
+// are allowed in flight.
+func (a *AdaptiveConcurrency) setPaused(paused bool) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.paused = paused
+	if !paused {
+		a.notify()
+	}
+}
+
+// changeLimit raises, or lowers, the limit by a manualSteps step of the concurrency
+// at 'now'. The limit isn't raised past the most requests that have been in flight,
+// at which point it's removed, or lowered below 1. It isn't restored automatically.
+func (a *AdaptiveConcurrency) changeLimit(now time.Time, raise bool) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	from := a.limit
+	if from == 0 {
+		if raise || a.peak == 0 {
+			return
+		}
+		a.ceiling = a.peak
+		from = a.peak
+	}
+	step := a.ceiling / manualSteps
+	if step < 1 {
+		step = 1
+	}
+	to := from - step
+	if raise {
+		to = from + step
+	}
+	if to < 1 {
+		to = 1
+	}
+	if to > a.ceiling {
+		to = a.ceiling
+	}
+	if to == from {
+		return
+	}
+	a.adjust(now, to, manualReason)
+	a.manual = true
+	if to == a.ceiling {
+		a.limit = 0
+	}
+	a.notify()
+}
+
+// state returns the limit, 0 if the requests in flight aren't limited, and whether
+// the requests are paused
+func (a *AdaptiveConcurrency) state() (int, bool) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.limit, a.paused
+}
+
+// adjust sets the limit to 'to' and records the adjustment. It must be called with
+// a.mux held.
+func (a *AdaptiveConcurrency) adjust(now time.Time, to int, reason string) {
+	from := a.limit
+	if from == 0 {
+		from = a.ceiling
+	}
+	log.Warn().Msgf("heyyall: changing the concurrency from %d to %d, %s", from, to, reason)
+	a.adjustments = append(a.adjustments, concurrencyAdjustment{at: now, from: from, to: to, reason: reason})
+	a.limit = to
+	a.lastAdjusted = now
+}
+
+// degradation returns the adjustments of the concurrency, with offsets relative to
+// 'start', and when it was first reduced, or a zero time if it never was. 'a' may be
+// nil.
+func (a *AdaptiveConcurrency) degradation(start time.Time) ([]api.ConcurrencyAdjustment, time.Time) {
+	if a == nil {
+		return nil, time.Time{}
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if len(a.adjustments) == 0 {
+		return nil, time.Time{}
+	}
+	adjustments := make([]api.ConcurrencyAdjustment, 0, len(a.adjustments))
+	for _, adj := range a.adjustments {
+		adjustments = append(adjustments, api.ConcurrencyAdjustment{
+			OffsetNanos: adj.at.Sub(start),
+			From:        adj.from,
+			To:          adj.to,
+			Reason:      adj.reason,
+		})
+	}
+	return adjustments, a.adjustments[0].at
+}
+
+// degradedAt returns when the concurrency was first reduced, or the zero time if it
+// hasn't been
+func (a *AdaptiveConcurrency) degradedAt() time.Time {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if len(a.adjustments) == 0 {
+		return time.Time{}
+	}
+	return a.adjustments[0].at
+}
+
+// printDegradation writes the adjustments of the concurrency described by 'd' followed
+// by the summaries of the run before and after it was first reduced
+func printDegradation(w io.Writer, d api.ConcurrencyDegradation) {
+	fmt.Fprintf(w, "\nConcurrency adjustments (%s errors):\n", api.ErrCategoryResourceExhausted)
+	for _, adj := range d.Adjustments {
+		fmt.Fprintf(w, "  %s: %d -> %d, %s\n", adj.OffsetNanos.Round(time.Second), adj.From, adj.To, adj.Reason)
+	}
+	for _, span := range []api.PhaseSummary{d.Clean, d.Degraded} {
+		fmt.Fprintf(w, "\nRun %s (%s - %s):\n", span.Name, span.StartOffsetNanos.Round(time.Second),
+			span.EndOffsetNanos.Round(time.Second))
+		printRunSummary(w, span.RunSummary)
+		printRqstLatency(w, span.RunSummary.RqstStats)
+	}
+}
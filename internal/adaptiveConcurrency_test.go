@@ -0,0 +1,231 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestResourceExhaustedCategory(t *testing.T) {
+	dialErr := func(errno syscall.Errno) error {
+		return &url.Error{Op: "Get", URL: "http://someurl/items",
+			Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("socket", errno)}}
+	}
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{name: "EMFILE", err: dialErr(syscall.EMFILE), expected: api.ErrCategoryResourceExhausted},
+		{name: "EADDRNOTAVAIL", err: dialErr(syscall.EADDRNOTAVAIL), expected: api.ErrCategoryResourceExhausted},
+		{name: "message", err: errors.New("dial tcp: lookup someurl: too many open files"), expected: api.ErrCategoryResourceExhausted},
+		{name: "refused", err: dialErr(syscall.ECONNREFUSED)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if category := errCategory(rqstAttempt{err: tc.err}, false); category != tc.expected {
+				t.Errorf("expected the category %q, got %q", tc.expected, category)
+			}
+		})
+	}
+}
+
+// TestAdaptiveConcurrency verifies the concurrency is halved by sustained resource
+// exhaustion errors, no more than once per exhaustionWindow, and restored in steps
+// once they've stopped for the recoveryPeriod
+func TestAdaptiveConcurrency(t *testing.T) {
+	a := NewAdaptiveConcurrency()
+	for i := 0; i < 8; i++ {
+		a.acquire(context.Background())
+	}
+	for i := 0; i < 8; i++ {
+		a.release()
+	}
+
+	now := time.Now()
+	for i := 0; i < exhaustionThreshold-1; i++ {
+		a.recordAt(true, now)
+	}
+	if a.limit != 0 {
+		t.Fatalf("expected the concurrency not to be reduced by %d errors, got a limit of %d", exhaustionThreshold-1, a.limit)
+	}
+	a.recordAt(true, now)
+	if a.limit != 4 {
+		t.Fatalf("expected the concurrency to be halved to 4, got %d", a.limit)
+	}
+
+	// The reduced limit is enforced
+	for i := 0; i < 4; i++ {
+		if !a.acquire(context.Background()) {
+			t.Fatalf("expected request %d to be allowed in flight", i)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if a.acquire(ctx) {
+		t.Errorf("expected a fifth request to wait for the limit")
+	}
+	released := make(chan bool)
+	go func() { released <- a.acquire(context.Background()) }()
+	a.release()
+	if !<-released {
+		t.Errorf("expected a waiting request to be allowed in flight once another ended")
+	}
+
+	// The errors of the requests in flight when it was reduced don't reduce it again
+	for i := 0; i < exhaustionThreshold; i++ {
+		a.recordAt(true, now.Add(exhaustionWindow/2))
+	}
+	if a.limit != 4 {
+		t.Errorf("expected the concurrency not to be reduced again within %s, got %d", exhaustionWindow, a.limit)
+	}
+	now = now.Add(2 * exhaustionWindow)
+	for i := 0; i < exhaustionThreshold; i++ {
+		a.recordAt(true, now)
+	}
+	if a.limit != 2 {
+		t.Errorf("expected the concurrency to be halved again to 2, got %d", a.limit)
+	}
+
+	a.recordAt(false, now.Add(recoveryPeriod/2))
+	if a.limit != 2 {
+		t.Errorf("expected the concurrency not to be raised before the %s recovery period, got %d", recoveryPeriod, a.limit)
+	}
+	for _, expected := range []int{4, 6, 0} {
+		now = now.Add(recoveryPeriod)
+		a.recordAt(false, now)
+		a.recordAt(false, now)
+		if a.limit != expected {
+			t.Errorf("expected a limit of %d, got %d", expected, a.limit)
+		}
+	}
+
+	adjustments, degradedAt := a.degradation(now)
+	if len(adjustments) != 5 || !degradedAt.Equal(now.Add(-3*recoveryPeriod-2*exhaustionWindow)) {
+		t.Fatalf("expected 5 adjustments, the first 2 reductions, got %+v at %s", adjustments, degradedAt)
+	}
+	expected := []struct{ from, to int }{{8, 4}, {4, 2}, {2, 4}, {4, 6}, {6, 8}}
+	for i, adj := range adjustments {
+		if adj.From != expected[i].from || adj.To != expected[i].to || adj.Reason == "" {
+			t.Errorf("expected adjustment %d to be from %d to %d with a reason, got %+v", i, expected[i].from, expected[i].to, adj)
+		}
+	}
+
+	var none *AdaptiveConcurrency
+	if !none.acquire(context.Background()) {
+		t.Errorf("expected a nil AdaptiveConcurrency not to limit the requests")
+	}
+	none.release()
+	none.record(Response{ErrCategory: api.ErrCategoryResourceExhausted})
+}
+
+// TestDegradationSummary verifies the responses received before and after the
+// concurrency was first reduced are summarized separately
+// TestManualConcurrency verifies the requests can be paused and resumed, and that the
+// concurrency can be lowered and raised, in tenths of the peak, without being restored
+// automatically, as the Dashboard does
+func TestManualConcurrency(t *testing.T) {
+	a := NewManualConcurrency()
+	now := time.Now()
+	a.changeLimit(now, false)
+	if limit, _ := a.state(); limit != 0 {
+		t.Errorf("expected the concurrency not to be lowered before any requests were sent, got %d", limit)
+	}
+	for i := 0; i < 20; i++ {
+		a.acquire(context.Background())
+	}
+	for i := 0; i < 20; i++ {
+		a.release()
+	}
+
+	a.setPaused(true)
+	acquired := make(chan bool)
+	go func() { acquired <- a.acquire(context.Background()) }()
+	select {
+	case <-acquired:
+		t.Fatalf("expected a request not to be allowed in flight while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	a.setPaused(false)
+	if !<-acquired {
+		t.Fatalf("expected a request to be allowed in flight once resumed")
+	}
+	a.release()
+
+	a.changeLimit(now, false)
+	a.changeLimit(now, false)
+	if limit, _ := a.state(); limit != 16 {
+		t.Errorf("expected the concurrency to be lowered twice by 2 to 16, got %d", limit)
+	}
+	// Neither resource exhaustion errors nor their absence change it
+	for i := 0; i < 2*exhaustionThreshold; i++ {
+		a.record(Response{ErrCategory: api.ErrCategoryResourceExhausted})
+	}
+	a.recordAt(false, now.Add(2*recoveryPeriod))
+	if limit, _ := a.state(); limit != 16 {
+		t.Errorf("expected the concurrency to stay at 16, got %d", limit)
+	}
+	a.changeLimit(now, true)
+	a.changeLimit(now, true)
+	if limit, _ := a.state(); limit != 0 {
+		t.Errorf("expected the limit to be removed once raised back to 20, got %d", limit)
+	}
+	if adjustments, _ := a.degradation(now); len(adjustments) != 4 || adjustments[0].To != 18 || adjustments[0].Reason != manualReason {
+		t.Errorf("expected the 4 changes to be reported, got %+v", adjustments)
+	}
+}
+
+func TestDegradationSummary(t *testing.T) {
+	start := time.Now()
+	a := NewAdaptiveConcurrency()
+	a.peak = 10
+	degradedAt := start.Add(100 * time.Millisecond)
+	for i := 0; i < exhaustionThreshold; i++ {
+		a.recordAt(true, degradedAt)
+	}
+
+	ep := api.Endpoint{URL: "http://someurl/items", Method: http.MethodGet}
+	var responses []Response
+	for i := 0; i < 6; i++ {
+		responses = append(responses, Response{Endpoint: ep, HTTPStatus: http.StatusOK, RequestDuration: time.Millisecond,
+			Completed: start.Add(time.Duration(i+1) * 10 * time.Millisecond)})
+	}
+	for i := 0; i < 4; i++ {
+		responses = append(responses, Response{Endpoint: ep, ErrCategory: api.ErrCategoryResourceExhausted,
+			Err: errors.New("too many open files"), Completed: degradedAt.Add(time.Duration(i) * time.Millisecond)})
+	}
+
+	rh := &ResponseHandler{start: start, AdaptiveConcurrency: a}
+	runResults, err := rh.summarize(responses, start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	d := runResults.Degradation
+	if d == nil || len(d.Adjustments) != 1 || d.Adjustments[0].From != 10 || d.Adjustments[0].To != 5 {
+		t.Fatalf("expected a reduction from 10 to 5, got %+v", d)
+	}
+	if d.Clean.RunSummary.RqstStats.TotalRqsts != 6 || len(d.Clean.EndpointDetails[ep.URL].ErrorCategories) != 0 ||
+		d.Clean.EndOffsetNanos != 100*time.Millisecond {
+		t.Errorf("expected 6 clean requests without errors in the first 100ms, got %+v", d.Clean)
+	}
+	if d.Degraded.EndpointDetails[ep.URL].ErrorCategories[api.ErrCategoryResourceExhausted] != 4 ||
+		d.Degraded.StartOffsetNanos != 100*time.Millisecond {
+		t.Errorf("expected 4 degraded requests from 100ms, got %+v", d.Degraded)
+	}
+
+	if runResults, _ = (&ResponseHandler{start: start, AdaptiveConcurrency: NewAdaptiveConcurrency()}).summarize(responses, start); runResults.Degradation != nil {
+		t.Errorf("expected no Degradation if the concurrency wasn't reduced, got %+v", runResults.Degradation)
+	}
+}
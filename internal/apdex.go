@@ -0,0 +1,53 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// apdexToleratingFactor is the multiple of the target latency requests completing
+// within are tolerating rather than frustrated
+const apdexToleratingFactor = 4
+
+// addApdex classifies a request that took 'd', or failed, against 'apdex's target
+func addApdex(apdex *api.Apdex, d time.Duration, failed bool) {
+	apdex.Rqsts++
+	switch {
+	case failed || d > apdexToleratingFactor*apdex.TargetNanos:
+		apdex.Frustrated++
+	case d > apdex.TargetNanos:
+		apdex.Tolerating++
+	default:
+		apdex.Satisfied++
+	}
+}
+
+// accumulateApdex classifies 'resp' against rh.ApdexTarget, if it's set, counting it
+// in the Apdex of 'rs' and of 'epDetail'. Failed and abandoned requests are frustrated.
+func (rh *ResponseHandler) accumulateApdex(resp Response, rs *api.RunSummary, epDetail *api.EndpointDetail) {
+	if rh.ApdexTarget <= 0 {
+		return
+	}
+	if rs.Apdex == nil {
+		rs.Apdex = &api.Apdex{TargetNanos: rh.ApdexTarget}
+	}
+	if epDetail.Apdex == nil {
+		epDetail.Apdex = &api.Apdex{TargetNanos: rh.ApdexTarget}
+	}
+	failed := resp.failed()
+	addApdex(rs.Apdex, resp.RequestDuration, failed)
+	addApdex(epDetail.Apdex, resp.RequestDuration, failed)
+}
+
+// finishApdex calculates 'apdex's Score, if it's set
+func finishApdex(apdex *api.Apdex) {
+	if apdex == nil || apdex.Rqsts == 0 {
+		return
+	}
+	apdex.Score = (float64(apdex.Satisfied) + float64(apdex.Tolerating)/2) / float64(apdex.Rqsts)
+}
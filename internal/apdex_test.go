@@ -0,0 +1,88 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestApdex verifies requests are classified against the ApdexTarget and that the
+// run's and each endpoint's scores match the Apdex formula
+func TestApdex(t *testing.T) {
+	const target = 100 * time.Millisecond
+	users := api.Endpoint{URL: "http://someservice.test/users", Method: "GET"}
+	orders := api.Endpoint{URL: "http://someservice.test/orders", Method: "GET"}
+	var responses []Response
+	add := func(ep api.Endpoint, n int, d time.Duration, status int) {
+		for i := 0; i < n; i++ {
+			responses = append(responses, Response{HTTPStatus: status, RequestDuration: d, Endpoint: ep})
+		}
+	}
+	// users: 6 satisfied, including exactly T, 3 tolerating, including exactly 4T,
+	// and 1 frustrated
+	add(users, 5, 50*time.Millisecond, 200)
+	add(users, 1, target, 200)
+	add(users, 2, 200*time.Millisecond, 200)
+	add(users, 1, 4*target, 200)
+	add(users, 1, 4*target+time.Millisecond, 200)
+	// orders: 2 satisfied and 2 frustrated, one by failing however quickly
+	add(orders, 2, 10*time.Millisecond, 200)
+	add(orders, 1, time.Second, 200)
+	add(orders, 1, time.Millisecond, 500)
+
+	rh := ResponseHandler{ApdexTarget: target, start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		apdex    *api.Apdex
+		expected api.Apdex
+	}{
+		{name: "run", apdex: runResults.RunSummary.Apdex,
+			expected: api.Apdex{TargetNanos: target, Rqsts: 14, Satisfied: 8, Tolerating: 3, Frustrated: 3}},
+		{name: users.URL, apdex: runResults.EndpointDetails[users.URL].Apdex,
+			expected: api.Apdex{TargetNanos: target, Rqsts: 10, Satisfied: 6, Tolerating: 3, Frustrated: 1}},
+		{name: orders.URL, apdex: runResults.EndpointDetails[orders.URL].Apdex,
+			expected: api.Apdex{TargetNanos: target, Rqsts: 4, Satisfied: 2, Frustrated: 2}},
+	}
+	for _, tc := range tests {
+		if tc.apdex == nil {
+			t.Errorf("expected an Apdex for the %s, got none", tc.name)
+			continue
+		}
+		score := (float64(tc.expected.Satisfied) + float64(tc.expected.Tolerating)/2) / float64(tc.expected.Rqsts)
+		if math.Abs(tc.apdex.Score-score) > 1e-9 {
+			t.Errorf("expected the %s's Apdex score to be %f, got %f", tc.name, score, tc.apdex.Score)
+		}
+		tc.apdex.Score = 0
+		if *tc.apdex != tc.expected {
+			t.Errorf("expected the %s's Apdex to be %+v, got %+v", tc.name, tc.expected, *tc.apdex)
+		}
+	}
+
+	var b bytes.Buffer
+	runResults.RunSummary.Apdex.Score = 11.0 / 14
+	printRunSummary(&b, runResults.RunSummary)
+	if expected := "Apdex: 0.79 (T 0.1000s: 8 satisfied, 3 tolerating, 3 frustrated)"; !strings.Contains(b.String(), expected) {
+		t.Errorf("expected the run summary to contain %q, got %s", expected, b.String())
+	}
+
+	rh = ResponseHandler{start: time.Now()}
+	if runResults, err = rh.summarize(responses, rh.start); err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	if runResults.RunSummary.Apdex != nil || runResults.EndpointDetails[users.URL].Apdex != nil {
+		t.Errorf("expected no Apdex without an ApdexTarget, got %+v", runResults.RunSummary.Apdex)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// BackpressureTracker measures how long the requestors were blocked sending their
+// responses because the ResponseHandler's queue, ResponseC, was full. It's shared by
+// all requestors.
+type BackpressureTracker struct {
+	mu           sync.Mutex
+	blockedSends int64
+	totalBlocked time.Duration
+	maxBlocked   time.Duration
+}
+
+// NewBackpressureTracker returns a BackpressureTracker that hasn't recorded any
+// blocked sends
+func NewBackpressureTracker() *BackpressureTracker {
+	return &BackpressureTracker{}
+}
+
+// record records that a requestor was blocked sending a response for 'd'. 't' may be
+// nil.
+func (t *BackpressureTracker) record(d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.blockedSends++
+	t.totalBlocked += d
+	if d > t.maxBlocked {
+		t.maxBlocked = d
+	}
+}
+
+// summary returns the backpressure recorded, or nil if no sends were blocked
+func (t *BackpressureTracker) summary() *api.ResponseChannelBackpressure {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.blockedSends == 0 {
+		return nil
+	}
+	return &api.ResponseChannelBackpressure{
+		BlockedSends:      t.blockedSends,
+		TotalBlockedNanos: t.totalBlocked,
+		MaxBlockedNanos:   t.maxBlocked,
+	}
+}
+
+// sendResponse sends 'response' to the ResponseHandler, after counting it against the
+// Requestor's Budget. It returns false, and the response isn't sent, if the run ended
+// before it could be sent. If ResponseC is full the time spent waiting for room is
+// recorded by the Requestor's Backpressure.
+func (r Requestor) sendResponse(response Response) bool {
+	if r.Ctx.Err() != nil {
+		return false
+	}
+	r.spend(response)
+	select {
+	case r.ResponseC <- response:
+		return true
+	default:
+	}
+
+	start := time.Now()
+	defer func() { r.Backpressure.record(time.Since(start)) }()
+	select {
+	case <-r.Ctx.Done():
+		return false
+	case r.ResponseC <- response:
+		return true
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestBackpressure verifies the time requestors spend blocked sending responses to a
+// slow response handler is recorded, and that none is recorded if the handler keeps up
+func TestBackpressure(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name string
+		// handlerDelay is how long the handler takes to process each response
+		handlerDelay time.Duration
+		blocked      bool
+	}{
+		{name: "slow handler", handlerDelay: 20 * time.Millisecond, blocked: true},
+		{name: "fast handler"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			numRqsts := 10
+			backpressure := NewBackpressureTracker()
+			// A large enough queue for all the responses unless the handler is slow
+			capacity := numRqsts
+			if tc.blocked {
+				capacity = 1
+			}
+			respC := make(chan Response, capacity)
+			rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}, Backpressure: backpressure}
+
+			handled := make(chan int)
+			go func() {
+				n := 0
+				for range respC {
+					time.Sleep(tc.handlerDelay)
+					n++
+				}
+				handled <- n
+			}()
+			rqstr.ProcessRqst(api.Endpoint{URL: testSrv.URL, Method: http.MethodGet}, numRqsts, 0)
+			close(respC)
+			if n := <-handled; n != numRqsts {
+				t.Fatalf("expected %d responses, got %d", numRqsts, n)
+			}
+
+			summary := backpressure.summary()
+			if !tc.blocked {
+				if summary != nil {
+					t.Errorf("expected no backpressure, got %+v", *summary)
+				}
+				return
+			}
+			if summary == nil {
+				t.Fatal("expected backpressure to be recorded")
+			}
+			// All but the first couple of responses wait for the handler
+			if summary.BlockedSends < int64(numRqsts-3) || summary.TotalBlockedNanos < time.Duration(numRqsts-3)*tc.handlerDelay/2 ||
+				summary.MaxBlockedNanos > summary.TotalBlockedNanos {
+				t.Errorf("expected at least %d blocked sends blocked for at least %s, got %+v",
+					numRqsts-3, time.Duration(numRqsts-3)*tc.handlerDelay/2, *summary)
+			}
+		})
+	}
+}
+
+// TestNoResponsesAfterCancel verifies a requestor doesn't send responses to the
+// ResponseHandler once the run has been cancelled, even if ResponseC has room
+func TestNoResponsesAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	respC := make(chan Response, 1)
+	rqstr := Requestor{Ctx: ctx, ResponseC: respC, Cancel: cancel}
+	resp := Response{Endpoint: api.Endpoint{URL: "http://someurl", Method: http.MethodGet}, HTTPStatus: http.StatusOK}
+
+	if !rqstr.sendResponse(resp) {
+		t.Fatalf("expected the response to be sent before the run was cancelled")
+	}
+	<-respC
+	rqstr.Cancel()
+	for _, errResp := range []Response{resp, {Endpoint: resp.Endpoint, ErrCategory: api.ErrCategoryConnection}} {
+		if rqstr.sendResponse(errResp) {
+			t.Errorf("expected the %q response not to be sent after the run was cancelled", errResp.ErrCategory)
+		}
+	}
+	if len(respC) != 0 {
+		t.Errorf("expected no responses to be delivered after the run was cancelled, got %d", len(respC))
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// baselineLatency summarizes 'stats' for a Baseline
+func baselineLatency(stats api.RqstStats) api.BaselineLatency {
+	latency := api.BaselineLatency{TotalRqsts: stats.TotalRqsts, LatencyNanos: make(map[string]time.Duration)}
+	for _, p := range reportedPercentiles {
+		latency.LatencyNanos[formatExemplarPercentile(p)] = calcPercentiles(p, stats.TimingResultsNanos)
+	}
+	return latency
+}
+
+// NewBaseline returns the Baseline of 'runResults', captured at 'capturedAt'
+func NewBaseline(runResults api.RunResults, capturedAt time.Time) api.Baseline {
+	rs := runResults.RunSummary
+	baseline := api.Baseline{
+		Version:    api.BaselineVersion,
+		CapturedAt: capturedAt,
+		Meta:       runResults.Meta,
+		RunSummary: api.BaselineSummary{
+			BaselineLatency:  baselineLatency(rs.RqstStats),
+			RunDurationNanos: rs.RunDurationNanos,
+			RqstRatePerSec:   rs.RqstRatePerSec,
+			ErrorCategories:  rs.ErrorCategories,
+		},
+		Endpoints: make(map[string]api.BaselineEndpoint, len(runResults.EndpointDetails)),
+	}
+	for url, epDetail := range runResults.EndpointDetails {
+		ep := api.BaselineEndpoint{
+			Methods:         make(map[string]api.BaselineLatency, len(epDetail.HTTPMethodRqstStats)),
+			ErrorCategories: epDetail.ErrorCategories,
+		}
+		for method, stats := range epDetail.HTTPMethodRqstStats {
+			ep.Methods[method] = baselineLatency(*stats)
+		}
+		baseline.Endpoints[url] = ep
+	}
+	return baseline
+}
+
+// runFailure returns why the run summarized by 'rs' failed, or an empty string if
+// it succeeded
+func runFailure(rs api.RunSummary) string {
+	switch {
+	case rs.RqstStats.TotalRqsts == 0:
+		return "there were no successful requests"
+	case rs.StatusDistFailed:
+		return "the response statuses didn't meet ExpectedStatusDistribution"
+	case rs.MaxP99Failed:
+		return "the P99 latency was greater than MaxP99"
+	case rs.SLOFailed:
+		return "the run didn't meet its SLOs"
+	case rs.UniqueIntFailed:
+		return "it was ended by " + rs.UniqueIntFailure
+	}
+	return ""
+}
+
+// SaveBaseline writes the Baseline of 'runResults' to the file 'path', replacing any
+// baseline already there. The baseline isn't saved, and an error is returned, if the
+// run failed. The file is replaced atomically so a failure part way through writing
+// it doesn't lose the previous baseline.
+func SaveBaseline(path string, runResults api.RunResults) error {
+	if failure := runFailure(runResults.RunSummary); failure != "" {
+		return fmt.Errorf("the baseline wasn't saved because the run failed, %s", failure)
+	}
+
+	b, err := json.MarshalIndent(NewBaseline(runResults, time.Now()), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding the baseline: %w", err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("error creating the baseline file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	// TempFile creates the file readable only by its owner, a baseline is typically
+	// shared, e.g., checked in alongside the config
+	if err = f.Chmod(0644); err == nil {
+		_, err = f.Write(append(b, '\n'))
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error writing the baseline file: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("error writing the baseline file: %w", err)
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error replacing the baseline file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestSaveBaseline verifies a successful run's baseline is written with the expected
+// schema and that a failed run doesn't replace the existing baseline
+func TestSaveBaseline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baseline")
+	if err != nil {
+		t.Fatalf("unexpected error creating a temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "baseline.json")
+
+	url := "http://someservice.test/"
+	var responses []Response
+	for i := 1; i <= 100; i++ {
+		responses = append(responses, Response{HTTPStatus: 200, RequestDuration: time.Duration(i) * time.Millisecond,
+			Endpoint: api.Endpoint{URL: url, Method: "GET"}})
+	}
+	responses = append(responses, Response{ErrCategory: api.ErrCategoryConnection, Endpoint: api.Endpoint{URL: url, Method: "GET"}})
+
+	rh := ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	if err = SaveBaseline(path, runResults); err != nil {
+		t.Fatalf("unexpected error saving the baseline: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading the baseline: %s", err)
+	}
+	// The schema is checked using the raw JSON, rather than api.Baseline, so renamed
+	// fields are caught
+	var raw map[string]interface{}
+	if err = json.Unmarshal(b, &raw); err != nil {
+		t.Fatalf("unexpected error decoding the baseline: %s", err)
+	}
+	var keys []string
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if expected := []string{"CapturedAt", "Endpoints", "Meta", "RunSummary", "Version"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected the baseline to have the fields %v, got %v", expected, keys)
+	}
+
+	var baseline api.Baseline
+	if err = json.Unmarshal(b, &baseline); err != nil {
+		t.Fatalf("unexpected error decoding the baseline: %s", err)
+	}
+	if baseline.Version != api.BaselineVersion {
+		t.Errorf("expected version %d, got %d", api.BaselineVersion, baseline.Version)
+	}
+	expectedLatency := map[string]time.Duration{
+		"Min": time.Millisecond, "Median": 50500 * time.Microsecond, "P75": 76 * time.Millisecond,
+		"P90": 91 * time.Millisecond, "P95": 96 * time.Millisecond, "P99": 100 * time.Millisecond,
+	}
+	rs := baseline.RunSummary
+	if rs.TotalRqsts != 100 || rs.ErrorCategories[api.ErrCategoryConnection] != 1 {
+		t.Errorf("expected 100 requests and 1 connection error, got %d and %v", rs.TotalRqsts, rs.ErrorCategories)
+	}
+	if !reflect.DeepEqual(rs.LatencyNanos, expectedLatency) {
+		t.Errorf("expected run latency %v, got %v", expectedLatency, rs.LatencyNanos)
+	}
+	get := baseline.Endpoints[url].Methods["GET"]
+	if get.TotalRqsts != 100 || !reflect.DeepEqual(get.LatencyNanos, expectedLatency) {
+		t.Errorf("expected 100 GETs with latency %v, got %+v", expectedLatency, get)
+	}
+
+	// A failed run doesn't replace the baseline
+	runResults.RunSummary.MaxP99Failed = true
+	if err = SaveBaseline(path, runResults); err == nil {
+		t.Errorf("expected an error saving the baseline of a failed run")
+	}
+	after, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading the baseline: %s", err)
+	}
+	if string(after) != string(b) {
+		t.Errorf("expected the baseline not to be replaced by a failed run")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected only the baseline file to be left, got %d files", len(files))
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// BudgetTracker counts a run's requests and bytes against its api.Budget. It's shared
+// by all requestors, once the budget is reached they stop sending requests.
+type BudgetTracker struct {
+	budget api.Budget
+	mux    sync.Mutex
+	rqsts  int64
+	bytes  int64
+	// limit is the limit that was reached, and reachedAt when it was, or empty if
+	// the budget hasn't been reached
+	limit     string
+	reachedAt time.Time
+}
+
+// NewBudgetTracker returns the BudgetTracker of 'budget', or nil if it's nil
+func NewBudgetTracker(budget *api.Budget) (*BudgetTracker, error) {
+	if budget == nil {
+		return nil, nil
+	}
+	if budget.MaxTotalRequests < 0 || budget.MaxTotalBytes < 0 {
+		return nil, fmt.Errorf("Budget MaxTotalRequests, %d, and MaxTotalBytes, %d, must not be negative",
+			budget.MaxTotalRequests, budget.MaxTotalBytes)
+	}
+	if budget.MaxTotalRequests == 0 && budget.MaxTotalBytes == 0 {
+		return nil, errors.New("Budget must have a MaxTotalRequests or a MaxTotalBytes")
+	}
+	if budget.PerWorker && budget.MaxTotalRequests == 0 {
+		return nil, errors.New("Budget must have a MaxTotalRequests to split it PerWorker")
+	}
+	return &BudgetTracker{budget: *budget}, nil
+}
+
+// record counts 'resp', and the bytes of its request and response bodies, against the
+// budget. It returns true if this reached the budget. 't' may be nil.
+func (t *BudgetTracker) record(resp Response) bool {
+	if t == nil {
+		return false
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.rqsts++
+	t.bytes += resp.BytesSent + resp.BytesReceived
+	if t.limit != "" {
+		return false
+	}
+	switch {
+	case t.budget.MaxTotalRequests > 0 && t.rqsts >= t.budget.MaxTotalRequests:
+		t.limit = api.BudgetMaxTotalRequests
+	case t.budget.MaxTotalBytes > 0 && t.bytes >= t.budget.MaxTotalBytes:
+		t.limit = api.BudgetMaxTotalBytes
+	default:
+		return false
+	}
+	t.reachedAt = time.Now()
+	return true
+}
+
+// spent returns true if the budget has been reached. 't' may be nil.
+func (t *BudgetTracker) spent() bool {
+	if t == nil {
+		return false
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.limit != ""
+}
+
+// exceeded describes the limit that was reached, or returns nil if the budget wasn't
+// reached. 'start' is when the run started.
+func (t *BudgetTracker) exceeded(start time.Time) *api.BudgetExceeded {
+	if t == nil {
+		return nil
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.limit == "" {
+		return nil
+	}
+
+	exceeded := api.BudgetExceeded{
+		Limit:         t.limit,
+		ReachedAt:     t.reachedAt,
+		AfterNanos:    t.reachedAt.Sub(start),
+		TotalRequests: t.rqsts,
+		TotalBytes:    t.bytes,
+	}
+	switch t.limit {
+	case api.BudgetMaxTotalRequests:
+		exceeded.Max = t.budget.MaxTotalRequests
+		exceeded.Overshoot = t.rqsts - exceeded.Max
+	case api.BudgetMaxTotalBytes:
+		exceeded.Max = t.budget.MaxTotalBytes
+		exceeded.Overshoot = t.bytes - exceeded.Max
+	}
+	return &exceeded
+}
+
+// budgetWarning describes the budget limit that stopped the run
+func budgetWarning(exceeded *api.BudgetExceeded) string {
+	return fmt.Sprintf("the run was stopped after %s by its Budget's %s of %d, it was overshot by %d, see BudgetExceeded",
+		exceeded.AfterNanos.Round(time.Millisecond), exceeded.Limit, exceeded.Max, exceeded.Overshoot)
+}
+
+// spend counts 'response' against the Requestor's Budget, if it has one
+func (r Requestor) spend(response Response) {
+	if r.Budget.record(response) {
+		log.Warn().Msgf("Requestor: the run's Budget was reached, no more requests will be sent")
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestNewBudgetTracker(t *testing.T) {
+	tests := []struct {
+		name      string
+		budget    *api.Budget
+		expectNil bool
+		expectErr bool
+	}{
+		{name: "none", expectNil: true},
+		{name: "requests", budget: &api.Budget{MaxTotalRequests: 10}},
+		{name: "bytes", budget: &api.Budget{MaxTotalBytes: 1 << 20}},
+		{name: "both", budget: &api.Budget{MaxTotalRequests: 10, MaxTotalBytes: 1 << 20}},
+		{name: "empty", budget: &api.Budget{}, expectErr: true},
+		{name: "negative", budget: &api.Budget{MaxTotalRequests: -1, MaxTotalBytes: 100}, expectErr: true},
+		{name: "per worker", budget: &api.Budget{MaxTotalRequests: 10, PerWorker: true}},
+		{name: "per worker bytes", budget: &api.Budget{MaxTotalBytes: 100, PerWorker: true}, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker, err := NewBudgetTracker(tc.budget)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			if !tc.expectErr && (tracker == nil) != tc.expectNil {
+				t.Errorf("expected a nil tracker %t, got %+v", tc.expectNil, tracker)
+			}
+		})
+	}
+}
+
+// TestBudgetRecord verifies the first limit reached is the one reported, and that the
+// responses after it count towards the overshoot
+func TestBudgetRecord(t *testing.T) {
+	tracker, err := NewBudgetTracker(&api.Budget{MaxTotalRequests: 10, MaxTotalBytes: 250})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	start := time.Now()
+	resp := Response{BytesSent: 20, BytesReceived: 80}
+	for i := 0; i < 2; i++ {
+		if tracker.record(resp) || tracker.spent() {
+			t.Fatalf("expected the budget not to be reached after %d responses", i+1)
+		}
+	}
+	if !tracker.record(resp) || !tracker.spent() {
+		t.Fatal("expected the bytes budget to be reached")
+	}
+	if tracker.record(resp) {
+		t.Error("expected the budget to only be reached once")
+	}
+
+	exceeded := tracker.exceeded(start)
+	expected := api.BudgetExceeded{Limit: api.BudgetMaxTotalBytes, Max: 250, TotalRequests: 4, TotalBytes: 400, Overshoot: 150}
+	if exceeded == nil || exceeded.Limit != expected.Limit || exceeded.Max != expected.Max || exceeded.TotalRequests != expected.TotalRequests ||
+		exceeded.TotalBytes != expected.TotalBytes || exceeded.Overshoot != expected.Overshoot || exceeded.AfterNanos < 0 {
+		t.Errorf("expected %+v, got %+v", expected, exceeded)
+	}
+
+	var none *BudgetTracker
+	if none.record(resp) || none.spent() || none.exceeded(start) != nil {
+		t.Error("expected a nil tracker to never be spent")
+	}
+}
+
+// TestBudget verifies concurrent requestors stop once a run's budget is reached and
+// overshoot it by at most a request each
+func TestBudget(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		w.Write([]byte(body))
+	}))
+	defer testSrv.Close()
+
+	concurrency := 8
+	tests := []struct {
+		name          string
+		budget        api.Budget
+		expectedLimit string
+		maxOvershoot  int64
+	}{
+		{
+			name:          "requests",
+			budget:        api.Budget{MaxTotalRequests: 50},
+			expectedLimit: api.BudgetMaxTotalRequests,
+			maxOvershoot:  int64(concurrency),
+		},
+		{
+			name:          "bytes",
+			budget:        api.Budget{MaxTotalBytes: 5000},
+			expectedLimit: api.BudgetMaxTotalBytes,
+			maxOvershoot:  int64(concurrency * len(body)),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			budget, err := NewBudgetTracker(&tc.budget)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: make(chan Response, concurrency),
+				Client:    http.Client{},
+				Budget:    budget,
+			}
+			ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet, RqstPercent: 100}
+			scheduler, err := NewScheduler(concurrency, 0, 0, 100000, []api.Endpoint{ep}, rqstr)
+			if err != nil {
+				t.Fatalf("unexpected error creating scheduler: %s", err)
+			}
+			rh := &ResponseHandler{
+				OutputType: JSON,
+				ResponseC:  rqstr.ResponseC,
+				DoneC:      make(chan interface{}),
+				Budget:     budget,
+				Output:     &bytes.Buffer{},
+			}
+			go rh.Start()
+			go scheduler.Start()
+
+			select {
+			case <-rh.DoneC:
+			case <-time.After(10 * time.Second):
+				t.Fatal("expected the run to stop once its budget was reached")
+			}
+
+			exceeded := rh.Results.RunSummary.BudgetExceeded
+			if exceeded == nil || exceeded.Limit != tc.expectedLimit {
+				t.Fatalf("expected the %s limit to be reached, got %+v", tc.expectedLimit, exceeded)
+			}
+			if exceeded.Overshoot < 0 || exceeded.Overshoot > tc.maxOvershoot {
+				t.Errorf("expected an overshoot of at most %d, got %+v", tc.maxOvershoot, exceeded)
+			}
+			if total := rh.Results.RunSummary.RqstStats.TotalRqsts; total != exceeded.TotalRequests {
+				t.Errorf("expected all %d requests counted against the budget to be reported, got %d", exceeded.TotalRequests, total)
+			}
+			if len(rh.Results.RunSummary.Warnings) == 0 {
+				t.Error("expected a warning that the budget stopped the run")
+			}
+		})
+	}
+}
+
+// TestBudgetPerWorker verifies a Budget split PerWorker is spent equally by fast and
+// slow workers
+func TestBudgetPerWorker(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(5 * time.Millisecond)
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer testSrv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	budgetCfg := api.Budget{MaxTotalRequests: 40, PerWorker: true}
+	budget, err := NewBudgetTracker(&budgetCfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	concurrency := 4
+	rqstr := Requestor{
+		Ctx:       ctx,
+		ResponseC: make(chan Response, concurrency),
+		Client:    http.Client{},
+		Budget:    budget,
+	}
+	eps := []api.Endpoint{
+		{URL: testSrv.URL + "/fast", Method: http.MethodGet, RqstPercent: 50},
+		{URL: testSrv.URL + "/slow", Method: http.MethodGet, RqstPercent: 50},
+	}
+	scheduler, err := NewScheduler(concurrency, 0, 10*time.Second, 0, eps, rqstr)
+	if err != nil {
+		t.Fatalf("unexpected error creating scheduler: %s", err)
+	}
+	scheduler.ShareBudget(&budgetCfg)
+	rh := &ResponseHandler{
+		OutputType: JSON,
+		ResponseC:  rqstr.ResponseC,
+		DoneC:      make(chan interface{}),
+		Budget:     budget,
+		Output:     &bytes.Buffer{},
+	}
+	go rh.Start()
+	go scheduler.Start()
+
+	select {
+	case <-rh.DoneC:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the run to stop once each worker made its share of the budget")
+	}
+
+	for _, ep := range eps {
+		if n := rh.Results.EndpointSummary[ep.URL][http.MethodGet]; n != 20 {
+			t.Errorf("expected the workers of %s to make their share, 20 requests, got %d", ep.URL, n)
+		}
+	}
+	if exceeded := rh.Results.RunSummary.BudgetExceeded; exceeded == nil || exceeded.Overshoot != 0 {
+		t.Errorf("expected the budget to be reached without overshooting it, got %+v", exceeded)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// accumulateByteTiming adds the time to first and last byte of 'resp', a successful
+// response, to 'epDetail' if its time to first byte was measured
+func accumulateByteTiming(resp Response, epDetail *api.EndpointDetail) {
+	if resp.TTFB <= 0 {
+		return
+	}
+	if epDetail.ByteTiming == nil {
+		epDetail.ByteTiming = &api.ByteTiming{}
+	}
+	epDetail.ByteTiming.Rqsts++
+	epDetail.ByteTiming.TotalTTFBNanos += resp.TTFB
+	epDetail.ByteTiming.TotalTTLBNanos += resp.RequestDuration
+}
+
+// finishByteTiming calculates the averages of 'epDetail's ByteTiming
+func finishByteTiming(epDetail *api.EndpointDetail) {
+	bt := epDetail.ByteTiming
+	if bt == nil {
+		return
+	}
+	bt.AvgTTFBNanos = bt.TotalTTFBNanos / time.Duration(bt.Rqsts)
+	bt.AvgTTLBNanos = bt.TotalTTLBNanos / time.Duration(bt.Rqsts)
+}
+
+// serverProcessing returns the time from 'wroteRqst', when the last byte of a request
+// was written, to 'gotResp', when the first byte of its response was received, or 0
+// if either wasn't recorded. The response may start before the request is completely
+// written, e.g., an early error response, which is also reported as 0.
+func serverProcessing(wroteRqst, gotResp time.Time) time.Duration {
+	if wroteRqst.IsZero() || gotResp.Before(wroteRqst) {
+		return 0
+	}
+	return gotResp.Sub(wroteRqst)
+}
+
+// accumulateServerProcessing adds the server processing time of 'resp', a successful
+// response, to 'epDetail' if it was measured
+func accumulateServerProcessing(resp Response, epDetail *api.EndpointDetail) {
+	if resp.ServerProcessingDuration <= 0 {
+		return
+	}
+	if epDetail.ServerProcessingStats == nil {
+		epDetail.ServerProcessingStats = &api.RqstStats{}
+	}
+	updateRqstStats(epDetail.ServerProcessingStats, resp.ServerProcessingDuration)
+}
+
+// finishServerProcessing calculates the average of 'epDetail's ServerProcessingStats
+func finishServerProcessing(epDetail *api.EndpointDetail) {
+	stats := epDetail.ServerProcessingStats
+	if stats == nil {
+		return
+	}
+	stats.AvgRqstDurationNanos = stats.TotalRequestDurationNanos / time.Duration(stats.TotalRqsts)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestByteTiming verifies the time to first byte of a response that trickles its body
+// is separated from its time to last byte
+func TestByteTiming(t *testing.T) {
+	chunks := 4
+	chunkDelay := 25 * time.Millisecond
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < chunks; i++ {
+			w.Write([]byte("chunk\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(chunkDelay)
+		}
+	}))
+	defer testSrv.Close()
+
+	numRqsts := 3
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}}
+	ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet}
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	bt := runResults.EndpointDetails[ep.URL].ByteTiming
+	if bt == nil || bt.Rqsts != int64(numRqsts) {
+		t.Fatalf("expected the byte timing of %d requests, got %+v", numRqsts, bt)
+	}
+	// The headers are sent with the first chunk, the last byte arrives after the
+	// remaining chunks are trickled out
+	if bt.AvgTTFBNanos <= 0 || bt.AvgTTLBNanos <= bt.AvgTTFBNanos {
+		t.Errorf("expected the time to last byte to exceed the time to first byte, got %+v", bt)
+	}
+	if trickle := time.Duration(chunks-1) * chunkDelay; bt.AvgTTLBNanos-bt.AvgTTFBNanos < trickle {
+		t.Errorf("expected at least %s between the first and last bytes, got %+v", trickle, bt)
+	}
+}
+
+// TestServerProcessing verifies the time from writing each request to its response's
+// first byte is reported, for requests with and without a body
+func TestServerProcessing(t *testing.T) {
+	think := 20 * time.Millisecond
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		time.Sleep(think)
+		w.Write([]byte("OK"))
+	}))
+	defer testSrv.Close()
+
+	for _, ep := range []api.Endpoint{
+		{URL: testSrv.URL + "/get", Method: http.MethodGet},
+		{URL: testSrv.URL + "/post", Method: http.MethodPost, RqstBody: strings.Repeat("x", 64*1024)},
+	} {
+		t.Run(ep.Method, func(t *testing.T) {
+			numRqsts := 3
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}}
+			rqstr.ProcessRqst(ep, numRqsts, 0)
+			close(respC)
+
+			var responses []Response
+			for resp := range respC {
+				if resp.ServerProcessingDuration < think || resp.ServerProcessingDuration > resp.TTFB {
+					t.Errorf("expected a server processing time between %s and the TTFB, got %+v", think, resp)
+				}
+				responses = append(responses, resp)
+			}
+			rh := &ResponseHandler{start: time.Now()}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+
+			stats := runResults.EndpointDetails[ep.URL].ServerProcessingStats
+			if stats == nil || stats.TotalRqsts != int64(numRqsts) {
+				t.Fatalf("expected the server processing time of %d requests, got %+v", numRqsts, stats)
+			}
+			if stats.MinRqstDurationNanos < think || stats.AvgRqstDurationNanos < stats.MinRqstDurationNanos ||
+				stats.MaxRqstDurationNanos < stats.AvgRqstDurationNanos {
+				t.Errorf("expected min, avg, and max server processing times of at least %s, got %+v", think, stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+const (
+	// canaryMinRqsts is the fewest requests to an endpoint each build of a canary
+	// run must have for the builds to be compared
+	canaryMinRqsts = 30
+	// canaryZ is the z-score beyond which the difference between the builds' error
+	// rates, or mean latencies, is significant, about 95% confidence
+	canaryZ = 1.96
+)
+
+// ValidateCanaryBaseURLs verifies that 'c', if it's set, has two distinct base URLs,
+// a valid BPercent, and that none of 'eps' use features that send their requests
+// somewhere other than their URL
+func ValidateCanaryBaseURLs(c *api.CanaryBaseURLs, eps []api.Endpoint) error {
+	if c == nil {
+		return nil
+	}
+	for _, base := range []string{c.A, c.B} {
+		if _, err := parseCanaryBaseURL(base); err != nil {
+			return err
+		}
+	}
+	if c.A == c.B {
+		return fmt.Errorf("CanaryBaseURLs A and B are the same, %s", c.A)
+	}
+	if c.BPercent < 0 || c.BPercent > 99 {
+		return fmt.Errorf("CanaryBaseURLs BPercent is %d, it must be from 1 to 99, or 0 for 50", c.BPercent)
+	}
+	for _, ep := range eps {
+		if len(ep.Variants) > 0 || ep.UnixSocket != "" || ep.PipelineDepth > 0 || ep.KeepAliveProbe != nil {
+			return fmt.Errorf("endpoint %s %s can't be used with CanaryBaseURLs, it has Variants, a UnixSocket, a PipelineDepth, or a KeepAliveProbe",
+				ep.Method, ep.URL)
+		}
+	}
+	return nil
+}
+
+// parseCanaryBaseURL parses 'base', a CanaryBaseURLs base URL, which must have only
+// a scheme, host, and optionally a port
+func parseCanaryBaseURL(base string) (*url.URL, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("CanaryBaseURLs base URL %q is invalid: %w", base, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") ||
+		u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("CanaryBaseURLs base URL %q must be only a scheme, http or https, host, and optional port", base)
+	}
+	return u, nil
+}
+
+// canaryBuild returns the build, api.CanaryA or api.CanaryB, that an endpoint's 'i'th
+// request is sent to. Requests are sent to B at an even interval, e.g., every other
+// request for a BPercent of 50.
+func canaryBuild(c api.CanaryBaseURLs, i int) string {
+	pct := c.BPercent
+	if pct == 0 {
+		pct = 50
+	}
+	if (i+1)*pct/100 > i*pct/100 {
+		return api.CanaryB
+	}
+	return api.CanaryA
+}
+
+// canaryRqst returns the build, api.CanaryA or api.CanaryB, that an endpoint's 'i'th
+// request, to 'rqstURL', is sent to and the URL it's sent to. The URL is unchanged,
+// and the build is empty, if 'c' isn't set.
+func canaryRqst(c *api.CanaryBaseURLs, i int, rqstURL string) (string, string, error) {
+	if c == nil {
+		return "", rqstURL, nil
+	}
+	build := canaryBuild(*c, i)
+	base := c.A
+	if build == api.CanaryB {
+		base = c.B
+	}
+	b, err := parseCanaryBaseURL(base)
+	if err != nil {
+		return "", "", err
+	}
+	u, err := url.Parse(rqstURL)
+	if err != nil {
+		return "", "", err
+	}
+	u.Scheme, u.Host = b.Scheme, b.Host
+	return build, u.String(), nil
+}
+
+// canarySamples are the responses to an endpoint and method from one build
+type canarySamples struct {
+	rqsts  int64
+	errors int64
+	// durations are the latencies of the successful responses
+	durations []time.Duration
+}
+
+// stats summarizes the samples
+func (s canarySamples) stats() api.CanaryStats {
+	stats := api.CanaryStats{Rqsts: s.rqsts, Errors: s.errors}
+	if s.rqsts > 0 {
+		stats.ErrorRate = float64(s.errors) / float64(s.rqsts)
+	}
+	if len(s.durations) == 0 {
+		return stats
+	}
+	var total time.Duration
+	for _, d := range s.durations {
+		total += d
+	}
+	stats.MeanNanos = total / time.Duration(len(s.durations))
+	stats.P50Nanos = calcPercentiles(50, s.durations)
+	stats.P95Nanos = calcPercentiles(95, s.durations)
+	stats.P99Nanos = calcPercentiles(99, s.durations)
+	return stats
+}
+
+// variance returns the sample variance of the latencies, in seconds squared
+func (s canarySamples) variance(mean time.Duration) float64 {
+	if len(s.durations) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, d := range s.durations {
+		diff := (d - mean).Seconds()
+		sum += diff * diff
+	}
+	return sum / float64(len(s.durations)-1)
+}
+
+// compareCanary compares the responses of each build of the canary run 'c' for each
+// endpoint, keyed by 'keyer', and method
+func compareCanary(c api.CanaryBaseURLs, responses []Response, keyer summaryKeyer) *api.CanaryComparison {
+	type key struct{ url, method string }
+	samples := make(map[key]map[string]*canarySamples)
+	for _, resp := range responses {
+		if resp.Canary == "" {
+			continue
+		}
+		k := key{keyer.key(resp.Endpoint.URL), resp.Endpoint.Method}
+		if samples[k] == nil {
+			samples[k] = map[string]*canarySamples{api.CanaryA: {}, api.CanaryB: {}}
+		}
+		s := samples[k][resp.Canary]
+		s.rqsts++
+		if resp.failed() {
+			s.errors++
+			continue
+		}
+		s.durations = append(s.durations, resp.RequestDuration)
+	}
+
+	comparison := &api.CanaryComparison{A: c.A, B: c.B}
+	for k, builds := range samples {
+		comparison.Endpoints = append(comparison.Endpoints, compareCanaryEndpoint(k.url, k.method, *builds[api.CanaryA], *builds[api.CanaryB]))
+	}
+	sort.Slice(comparison.Endpoints, func(i, j int) bool {
+		epI, epJ := comparison.Endpoints[i], comparison.Endpoints[j]
+		if epI.URL != epJ.URL {
+			return epI.URL < epJ.URL
+		}
+		return epI.Method < epJ.Method
+	})
+	return comparison
+}
+
+// compareCanaryEndpoint compares 'a' and 'b', the samples of each build for the
+// endpoint 'url' and 'method'. A build wins if its error rate is significantly lower,
+// using a two-proportion z-test, or, if neither's is, if its mean latency is
+// significantly lower, using Welch's z-test.
+func compareCanaryEndpoint(url, method string, a, b canarySamples) api.CanaryEndpoint {
+	ep := api.CanaryEndpoint{URL: url, Method: method, A: a.stats(), B: b.stats()}
+	ep.MeanDeltaNanos = ep.B.MeanNanos - ep.A.MeanNanos
+	ep.P50DeltaNanos = ep.B.P50Nanos - ep.A.P50Nanos
+	ep.P95DeltaNanos = ep.B.P95Nanos - ep.A.P95Nanos
+	ep.P99DeltaNanos = ep.B.P99Nanos - ep.A.P99Nanos
+	ep.ErrorRateDelta = ep.B.ErrorRate - ep.A.ErrorRate
+
+	if a.rqsts < canaryMinRqsts || b.rqsts < canaryMinRqsts {
+		ep.Winner = api.CanaryInconclusive
+		ep.Reason = fmt.Sprintf("fewer than %d requests to a build", canaryMinRqsts)
+		return ep
+	}
+
+	pooled := float64(a.errors+b.errors) / float64(a.rqsts+b.rqsts)
+	if se := math.Sqrt(pooled * (1 - pooled) * (1/float64(a.rqsts) + 1/float64(b.rqsts))); se > 0 &&
+		math.Abs(ep.ErrorRateDelta)/se >= canaryZ {
+		ep.Winner, ep.Reason = canaryWinner(ep.ErrorRateDelta), "lower error rate"
+		return ep
+	}
+
+	ep.Winner = api.CanaryNoDifference
+	if len(a.durations) < canaryMinRqsts || len(b.durations) < canaryMinRqsts {
+		return ep
+	}
+	se := math.Sqrt(a.variance(ep.A.MeanNanos)/float64(len(a.durations)) + b.variance(ep.B.MeanNanos)/float64(len(b.durations)))
+	delta := ep.MeanDeltaNanos.Seconds()
+	if (se > 0 && math.Abs(delta)/se >= canaryZ) || (se == 0 && delta != 0) {
+		ep.Winner, ep.Reason = canaryWinner(delta), "lower mean latency"
+	}
+	return ep
+}
+
+// canaryWinner returns the build that did better given 'delta', B's value less A's,
+// of a measure where lower is better
+func canaryWinner(delta float64) string {
+	if delta < 0 {
+		return api.CanaryB
+	}
+	return api.CanaryA
+}
+
+// formatDeltaSeconds formats 'd', a difference between latencies, with its sign
+func formatDeltaSeconds(d time.Duration) string {
+	return fmt.Sprintf("%+.4f", d.Seconds())
+}
+
+// formatDeltaPercent formats 'f', a difference between ratios, as a percentage with
+// its sign
+func formatDeltaPercent(f float64) string {
+	return fmt.Sprintf("%+.2f%%", f*100)
+}
+
+var canaryTmplt = `Canary Comparison (A: {{ .A }}, B: {{ .B }}):{{ range .Endpoints }}
+  {{ .Method }} {{ .URL }}:
+	              Rqsts   Errors      Mean       P50       P95       P99
+	    A    {{ printf "%10d" .A.Rqsts }}{{ printf "%9s" (formatPercent .A.ErrorRate) }}{{ printf "%10s" (formatSeconds .A.MeanNanos) }}{{ printf "%10s" (formatSeconds .A.P50Nanos) }}{{ printf "%10s" (formatSeconds .A.P95Nanos) }}{{ printf "%10s" (formatSeconds .A.P99Nanos) }}
+	    B    {{ printf "%10d" .B.Rqsts }}{{ printf "%9s" (formatPercent .B.ErrorRate) }}{{ printf "%10s" (formatSeconds .B.MeanNanos) }}{{ printf "%10s" (formatSeconds .B.P50Nanos) }}{{ printf "%10s" (formatSeconds .B.P95Nanos) }}{{ printf "%10s" (formatSeconds .B.P99Nanos) }}
+	    B-A            {{ printf "%9s" (formatDeltaPercent .ErrorRateDelta) }}{{ printf "%10s" (formatDeltaSeconds .MeanDeltaNanos) }}{{ printf "%10s" (formatDeltaSeconds .P50DeltaNanos) }}{{ printf "%10s" (formatDeltaSeconds .P95DeltaNanos) }}{{ printf "%10s" (formatDeltaSeconds .P99DeltaNanos) }}
+	    Winner: {{ .Winner }}{{ if .Reason }} ({{ .Reason }}){{ end }}{{ end }}
+`
+
+// printCanaryComparison writes 'comparison' as a table per endpoint
+func printCanaryComparison(w io.Writer, comparison api.CanaryComparison) {
+	tmplt, err := template.New("canary").Funcs(tmpltFuncs).Parse(canaryTmplt)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing canary template")
+	}
+
+	err = tmplt.Execute(w, comparison)
+	if err != nil {
+		log.Error().Err(err).Msg("error executing canary template")
+	}
+}
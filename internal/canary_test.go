@@ -0,0 +1,184 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestCanary verifies the requests of a canary run are split between the builds and
+// that the better build wins, on error rate for an endpoint that fails on one build
+// and on latency for an endpoint that's slower on the other
+func TestCanary(t *testing.T) {
+	var flakyRqsts int64
+	srvA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/flaky" && atomic.AddInt64(&flakyRqsts, 1)%3 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("A"))
+	}))
+	defer srvA.Close()
+	srvB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(5 * time.Millisecond)
+		}
+		w.Write([]byte("B"))
+	}))
+	defer srvB.Close()
+
+	canary := api.CanaryBaseURLs{A: srvA.URL, B: srvB.URL}
+	eps := []api.Endpoint{
+		{URL: "http://service.test/slow", Method: http.MethodGet},
+		{URL: "http://service.test/flaky", Method: http.MethodGet},
+	}
+	if err := ValidateCanaryBaseURLs(&canary, eps); err != nil {
+		t.Fatalf("unexpected error validating the canary: %s", err)
+	}
+
+	concurrency, numRqsts := 2, 40
+	respC := make(chan Response, concurrency*numRqsts*len(eps))
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    http.Client{},
+		Canary:    &canary,
+	}
+	var wg sync.WaitGroup
+	for _, ep := range eps {
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func(ep api.Endpoint) {
+				defer wg.Done()
+				rqstr.ProcessRqst(ep, numRqsts, 0)
+			}(ep)
+		}
+	}
+	wg.Wait()
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	rh := &ResponseHandler{start: time.Now(), Canary: &canary}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	comparison := runResults.Canary
+	if comparison == nil || len(comparison.Endpoints) != len(eps) {
+		t.Fatalf("expected a comparison of %d endpoints, got %+v", len(eps), comparison)
+	}
+	// The results of both builds are reported for the configured endpoint
+	if _, ok := runResults.EndpointDetails["http://service.test/slow"]; !ok {
+		t.Errorf("expected the endpoint details to be reported by the configured URL, got %v", runResults.EndpointSummary)
+	}
+	expected := map[string]struct{ winner, reason string }{
+		"http://service.test/flaky": {winner: api.CanaryB, reason: "lower error rate"},
+		"http://service.test/slow":  {winner: api.CanaryA, reason: "lower mean latency"},
+	}
+	for _, ep := range comparison.Endpoints {
+		if ep.A.Rqsts != int64(concurrency*numRqsts/2) || ep.B.Rqsts != int64(concurrency*numRqsts/2) {
+			t.Errorf("expected the requests to %s to be split evenly, got A %d and B %d", ep.URL, ep.A.Rqsts, ep.B.Rqsts)
+		}
+		if want := expected[ep.URL]; ep.Winner != want.winner || ep.Reason != want.reason {
+			t.Errorf("expected %s to be won by %s, %s, got %s, %s: %+v", ep.URL, want.winner, want.reason, ep.Winner, ep.Reason, ep)
+		}
+	}
+
+	var out bytes.Buffer
+	printCanaryComparison(&out, *comparison)
+	if !strings.Contains(out.String(), "Winner: B (lower error rate)") {
+		t.Errorf("expected the text report to show the winner, got:\n%s", out.String())
+	}
+}
+
+func TestCanaryBuild(t *testing.T) {
+	tests := []struct {
+		bPercent int
+		expected string
+	}{
+		{bPercent: 0, expected: "ABABABABAB"},
+		{bPercent: 20, expected: "AAAABAAAAB"},
+		{bPercent: 90, expected: "ABBBBBBBBB"},
+	}
+	for _, tc := range tests {
+		var builds string
+		for i := 0; i < len(tc.expected); i++ {
+			builds += canaryBuild(api.CanaryBaseURLs{BPercent: tc.bPercent}, i)
+		}
+		if builds != tc.expected {
+			t.Errorf("expected BPercent %d to send requests to %s, got %s", tc.bPercent, tc.expected, builds)
+		}
+	}
+}
+
+func TestCanaryCompareEndpoint(t *testing.T) {
+	samples := func(rqsts, errors int64, d time.Duration) canarySamples {
+		s := canarySamples{rqsts: rqsts, errors: errors}
+		for i := int64(0); i < rqsts-errors; i++ {
+			// Some spread so the latencies have a variance
+			s.durations = append(s.durations, d+time.Duration(i%5)*time.Millisecond)
+		}
+		return s
+	}
+	tests := []struct {
+		name     string
+		a        canarySamples
+		b        canarySamples
+		expected string
+	}{
+		{name: "too few requests", a: samples(10, 5, time.Millisecond), b: samples(10, 0, time.Millisecond), expected: api.CanaryInconclusive},
+		{name: "same", a: samples(100, 1, 10*time.Millisecond), b: samples(100, 2, 10*time.Millisecond), expected: api.CanaryNoDifference},
+		{name: "B fewer errors", a: samples(100, 20, 10*time.Millisecond), b: samples(100, 2, 50*time.Millisecond), expected: api.CanaryB},
+		{name: "A faster", a: samples(100, 0, 10*time.Millisecond), b: samples(100, 0, 20*time.Millisecond), expected: api.CanaryA},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ep := compareCanaryEndpoint("http://someurl", http.MethodGet, tc.a, tc.b)
+			if ep.Winner != tc.expected {
+				t.Errorf("expected the winner to be %s, got %s: %+v", tc.expected, ep.Winner, ep)
+			}
+		})
+	}
+}
+
+func TestValidateCanaryBaseURLs(t *testing.T) {
+	ep := api.Endpoint{URL: "http://service.test/", Method: http.MethodGet}
+	tests := []struct {
+		name   string
+		canary api.CanaryBaseURLs
+		ep     api.Endpoint
+		valid  bool
+	}{
+		{name: "valid", canary: api.CanaryBaseURLs{A: "http://a.test", B: "https://b.test:8443/", BPercent: 10}, ep: ep, valid: true},
+		{name: "path", canary: api.CanaryBaseURLs{A: "http://a.test/v1", B: "http://b.test"}, ep: ep},
+		{name: "same", canary: api.CanaryBaseURLs{A: "http://a.test", B: "http://a.test"}, ep: ep},
+		{name: "missing", canary: api.CanaryBaseURLs{A: "http://a.test"}, ep: ep},
+		{name: "BPercent", canary: api.CanaryBaseURLs{A: "http://a.test", B: "http://b.test", BPercent: 100}, ep: ep},
+		{name: "variants", canary: api.CanaryBaseURLs{A: "http://a.test", B: "http://b.test"},
+			ep: api.Endpoint{URL: ep.URL, Method: ep.Method, Variants: []api.EndpointVariant{{Name: "tls", Scheme: "https"}}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateCanaryBaseURLs(&tc.canary, []api.Endpoint{tc.ep})
+			if (err == nil) != tc.valid {
+				t.Errorf("expected valid to be %t, got error %v", tc.valid, err)
+			}
+		})
+	}
+}
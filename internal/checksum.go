@@ -0,0 +1,58 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// maxChecksumMismatchSamples is the number of distinct mismatched response bodies
+// reported per endpoint
+const maxChecksumMismatchSamples = 5
+
+// validateExpectedSHA256 verifies that 'ep's ExpectedSHA256, if it has one, is a hex
+// encoded SHA-256 digest
+func validateExpectedSHA256(ep api.Endpoint) error {
+	if ep.ExpectedSHA256 == "" {
+		return nil
+	}
+	if b, err := hex.DecodeString(ep.ExpectedSHA256); err != nil || len(b) != 32 {
+		return fmt.Errorf("endpoint %s %s has an ExpectedSHA256, %q, it must be 64 hex digits", ep.Method, ep.URL, ep.ExpectedSHA256)
+	}
+	return nil
+}
+
+// checkSHA256 returns an error if the body of the response to 'attempt' doesn't
+// match 'ep's ExpectedSHA256. A body that was only partly read, because it was
+// larger than Requestor.MaxResponseBodyBytes, can't match.
+func checkSHA256(ep api.Endpoint, attempt rqstAttempt) error {
+	if attempt.bodyLimited {
+		return fmt.Errorf("response body SHA-256 can't be verified, only %d bytes were read", attempt.bodyBytes)
+	}
+	if !strings.EqualFold(attempt.bodySHA256, ep.ExpectedSHA256) {
+		return fmt.Errorf("response body SHA-256 %s, of %d bytes, doesn't match ExpectedSHA256 %s",
+			attempt.bodySHA256, attempt.bodyBytes, ep.ExpectedSHA256)
+	}
+	return nil
+}
+
+// sampleChecksumMismatch records the digest and length of the body of 'resp', a
+// ChecksumMismatch response, in 'epDetail's ChecksumMismatchSamples
+func sampleChecksumMismatch(resp Response, epDetail *api.EndpointDetail) {
+	for _, sample := range epDetail.ChecksumMismatchSamples {
+		if sample.SHA256 == resp.BodySHA256 && sample.Bytes == resp.BytesReceived {
+			sample.Count++
+			return
+		}
+	}
+	if len(epDetail.ChecksumMismatchSamples) < maxChecksumMismatchSamples {
+		epDetail.ChecksumMismatchSamples = append(epDetail.ChecksumMismatchSamples,
+			&api.ChecksumMismatchSample{SHA256: resp.BodySHA256, Bytes: resp.BytesReceived, Count: 1})
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestExpectedSHA256 verifies response bodies are checked against an endpoint's
+// ExpectedSHA256, that mismatches, including truncated bodies, are counted and
+// sampled, and that bodies aren't hashed without an ExpectedSHA256
+func TestExpectedSHA256(t *testing.T) {
+	asset := strings.Repeat("static asset content ", 1000)
+	digest := sha256.Sum256([]byte(asset))
+	expectedSHA256 := hex.EncodeToString(digest[:])
+
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/short":
+			// A 200 with only part of the asset
+			w.Write([]byte(asset[:len(asset)/2]))
+		default:
+			w.Write([]byte(asset))
+		}
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name                 string
+		path                 string
+		expectedSHA256       string
+		maxBodyBytes         int64
+		expectedMismatches   int64
+		expectedSampledBytes int64
+	}{
+		{name: "Match", path: "/asset", expectedSHA256: expectedSHA256},
+		{name: "MatchUppercase", path: "/asset", expectedSHA256: strings.ToUpper(expectedSHA256)},
+		{name: "Short", path: "/short", expectedSHA256: expectedSHA256, expectedMismatches: 3,
+			expectedSampledBytes: int64(len(asset) / 2)},
+		{name: "BodyLimited", path: "/asset", expectedSHA256: expectedSHA256, maxBodyBytes: 100,
+			expectedMismatches: 3, expectedSampledBytes: 100},
+		{name: "NotChecked", path: "/short"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ep := api.Endpoint{URL: testSrv.URL + tc.path, Method: http.MethodGet, ExpectedSHA256: tc.expectedSHA256}
+			if err := validateExpectedSHA256(ep); err != nil {
+				t.Fatalf("unexpected error validating ExpectedSHA256: %s", err)
+			}
+
+			numRqsts := 3
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:                  context.Background(),
+				ResponseC:            respC,
+				Client:               http.Client{},
+				MaxResponseBodyBytes: tc.maxBodyBytes,
+			}
+			rqstr.ProcessRqst(ep, numRqsts, 0)
+			close(respC)
+
+			var responses []Response
+			for resp := range respC {
+				if tc.expectedSHA256 == "" && resp.BodySHA256 != "" {
+					t.Errorf("expected the body not to be hashed without an ExpectedSHA256, got %s", resp.BodySHA256)
+				}
+				responses = append(responses, resp)
+			}
+
+			rh := ResponseHandler{start: time.Now()}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			epDetail := runResults.EndpointDetails[ep.URL]
+			if mismatches := epDetail.ErrorCategories[api.ErrCategoryChecksumMismatch]; mismatches != tc.expectedMismatches {
+				t.Errorf("expected %d checksum mismatches, got %d", tc.expectedMismatches, mismatches)
+			}
+			if tc.expectedMismatches == 0 {
+				if len(epDetail.ChecksumMismatchSamples) != 0 {
+					t.Errorf("expected no checksum mismatch samples, got %+v", epDetail.ChecksumMismatchSamples)
+				}
+				return
+			}
+			if len(epDetail.ChecksumMismatchSamples) != 1 {
+				t.Fatalf("expected 1 checksum mismatch sample, got %+v", epDetail.ChecksumMismatchSamples)
+			}
+			sample := epDetail.ChecksumMismatchSamples[0]
+			if sample.Count != tc.expectedMismatches || sample.Bytes != tc.expectedSampledBytes || len(sample.SHA256) != 64 {
+				t.Errorf("expected a sample of %d bodies of %d bytes with a digest, got %+v", tc.expectedMismatches,
+					tc.expectedSampledBytes, sample)
+			}
+		})
+	}
+}
+
+// TestValidateExpectedSHA256 verifies malformed digests are rejected
+func TestValidateExpectedSHA256(t *testing.T) {
+	for _, digest := range []string{"abc", strings.Repeat("z", 64), strings.Repeat("a", 62)} {
+		ep := api.Endpoint{URL: "http://someservice.test/", Method: http.MethodGet, ExpectedSHA256: digest}
+		if err := validateExpectedSHA256(ep); err == nil {
+			t.Errorf("expected an error validating ExpectedSHA256 %q", digest)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// circuitBreaker is the state of a single endpoint's circuit breaker
+type circuitBreaker struct {
+	method string
+	// url is the URL the endpoint's results are reported under
+	url       string
+	errorRate float64
+	cooldown  time.Duration
+	// outcomes are the most recent outcomes, true if the response failed, used as a
+	// ring buffer. 'next' is the index of the oldest outcome once it's full.
+	outcomes []bool
+	next     int
+	recorded int
+	failures int
+	// openedAt is when the circuit last opened and openUntil is when it closes
+	// again. openUntil is zero if the circuit isn't open.
+	openedAt  time.Time
+	openUntil time.Time
+	opens     int64
+	// openTotal is how long the circuit was open, not including the current opening
+	openTotal time.Duration
+}
+
+// closeIfCooled closes the circuit if it's open and its cooldown ended before 'now'
+func (b *circuitBreaker) closeIfCooled(now time.Time) {
+	if b.openUntil.IsZero() || now.Before(b.openUntil) {
+		return
+	}
+	b.openTotal += b.openUntil.Sub(b.openedAt)
+	b.openUntil = time.Time{}
+	b.next, b.recorded, b.failures = 0, 0, 0
+}
+
+// openDuration returns how long the circuit has been open as of 'now'
+func (b *circuitBreaker) openDuration(now time.Time) time.Duration {
+	open := b.openTotal
+	if !b.openUntil.IsZero() {
+		until := b.openUntil
+		if now.Before(until) {
+			until = now
+		}
+		open += until.Sub(b.openedAt)
+	}
+	return open
+}
+
+// CircuitBreakers pauses requests to each endpoint with an Endpoint.CircuitBreaker
+// while its recent responses are mostly errors, independently of the other endpoints.
+// It's shared by all requestors.
+type CircuitBreakers struct {
+	mux sync.Mutex
+	// breakers is the circuit breaker of each endpoint with one keyed by
+	// earlyFailKey. The map is only modified by NewCircuitBreakers.
+	breakers map[string]*circuitBreaker
+}
+
+// NewCircuitBreakers returns the CircuitBreakers of 'eps', or nil if none of them have
+// a circuit breaker
+func NewCircuitBreakers(eps []api.Endpoint) (*CircuitBreakers, error) {
+	c := CircuitBreakers{breakers: make(map[string]*circuitBreaker)}
+	for _, ep := range eps {
+		cb := ep.CircuitBreaker
+		if cb == nil {
+			continue
+		}
+		if ep.PipelineDepth > 1 || ep.KeepAliveProbe != nil || ep.Mode == api.EndpointModeSSE {
+			return nil, fmt.Errorf("endpoint %s %s has a CircuitBreaker, it can't also have a PipelineDepth, a KeepAliveProbe, or the %q Mode",
+				ep.Method, ep.URL, api.EndpointModeSSE)
+		}
+		if cb.ErrorRate <= 0 || cb.ErrorRate > 1 {
+			return nil, fmt.Errorf("endpoint %s %s has a CircuitBreaker ErrorRate of %g, it must be greater than 0 and at most 1",
+				ep.Method, ep.URL, cb.ErrorRate)
+		}
+		window := cb.Window
+		if window == 0 {
+			window = api.DefaultCircuitBreakerWindow
+		}
+		if window < 0 {
+			return nil, fmt.Errorf("endpoint %s %s has a CircuitBreaker Window of %d, it must not be negative", ep.Method, ep.URL, cb.Window)
+		}
+		cooldown := cb.Cooldown
+		if cooldown == "" {
+			cooldown = api.DefaultCircuitBreakerCooldown
+		}
+		d, err := time.ParseDuration(cooldown)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("endpoint %s %s has a CircuitBreaker Cooldown of %q, it must be a positive duration, e.g., '10s'",
+				ep.Method, ep.URL, cb.Cooldown)
+		}
+		c.breakers[earlyFailKey(ep)] = &circuitBreaker{
+			method:    ep.Method,
+			url:       summaryURL(ep, ep.URL),
+			errorRate: cb.ErrorRate,
+			cooldown:  d,
+			outcomes:  make([]bool, window),
+		}
+	}
+	if len(c.breakers) == 0 {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+// record records the outcome of a response from 'ep', opening its circuit if enough of
+// its recent responses failed. Responses received while the circuit is open, to
+// requests sent before it opened, are ignored. 'c' may be nil.
+func (c *CircuitBreakers) record(ep api.Endpoint, success bool) {
+	if c == nil {
+		return
+	}
+	b, ok := c.breakers[earlyFailKey(ep)]
+	if !ok {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	now := time.Now()
+	b.closeIfCooled(now)
+	if !b.openUntil.IsZero() {
+		return
+	}
+	if b.recorded == len(b.outcomes) && b.outcomes[b.next] {
+		b.failures--
+	}
+	b.outcomes[b.next] = !success
+	if !success {
+		b.failures++
+	}
+	b.next = (b.next + 1) % len(b.outcomes)
+	if b.recorded < len(b.outcomes) {
+		b.recorded++
+	}
+
+	if b.recorded == len(b.outcomes) && float64(b.failures) >= b.errorRate*float64(len(b.outcomes)) {
+		b.openedAt, b.openUntil = now, now.Add(b.cooldown)
+		b.opens++
+		log.Debug().Msgf("Requestor: %d of the last %d responses from endpoint %s %s were errors, pausing requests to it for %s",
+			b.failures, len(b.outcomes), ep.Method, ep.URL, b.cooldown)
+	}
+}
+
+// wait waits until 'ep's circuit is closed. It returns false if 'ctx' was done before
+// it was. 'c' may be nil.
+func (c *CircuitBreakers) wait(ctx context.Context, ep api.Endpoint) bool {
+	if c == nil {
+		return true
+	}
+	b, ok := c.breakers[earlyFailKey(ep)]
+	if !ok {
+		return true
+	}
+	c.mux.Lock()
+	until := b.openUntil
+	c.mux.Unlock()
+
+	wait := time.Until(until)
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// circuitResult is how often, and for how long, an endpoint's circuit was open
+type circuitResult struct {
+	method string
+	url    string
+	opens  int64
+	open   time.Duration
+}
+
+// results returns, as of 'now', the results of the endpoints whose circuits opened,
+// sorted by endpoint
+func (c *CircuitBreakers) results(now time.Time) []circuitResult {
+	if c == nil {
+		return nil
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	var results []circuitResult
+	for _, b := range c.breakers {
+		if b.opens > 0 {
+			results = append(results, circuitResult{method: b.method, url: b.url, opens: b.opens, open: b.openDuration(now)})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].url != results[j].url {
+			return results[i].url < results[j].url
+		}
+		return results[i].method < results[j].method
+	})
+	return results
+}
+
+// warning describes the circuit breaker opening for 'r's endpoint
+func (r circuitResult) warning() string {
+	return fmt.Sprintf("%s %s: its circuit breaker opened %d times, no requests were sent to it for %s",
+		r.method, r.url, r.opens, r.open.Round(time.Millisecond))
+}
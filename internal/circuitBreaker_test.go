@@ -0,0 +1,188 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestCircuitBreaker verifies an endpoint's circuit opens when its error rate is high,
+// pausing requests to it, while the requests to the other endpoints continue
+func TestCircuitBreaker(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		downRqsts []time.Time
+		upRqsts   int
+	)
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/down" {
+			downRqsts = append(downRqsts, time.Now())
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		upRqsts++
+	}))
+	defer testSrv.Close()
+
+	cooldown := 100 * time.Millisecond
+	down := api.Endpoint{URL: testSrv.URL + "/down", Method: http.MethodGet,
+		CircuitBreaker: &api.CircuitBreaker{ErrorRate: 0.5, Window: 5, Cooldown: cooldown.String()}}
+	up := api.Endpoint{URL: testSrv.URL + "/up", Method: http.MethodGet}
+	circuitBreakers, err := NewCircuitBreakers([]api.Endpoint{down, up})
+	if err != nil {
+		t.Fatalf("unexpected error creating the circuit breakers: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 350*time.Millisecond)
+	defer cancel()
+	respC := make(chan Response, 100)
+	rqstr := Requestor{Ctx: ctx, ResponseC: respC, Client: http.Client{}, CircuitBreakers: circuitBreakers}
+	var responses []Response
+	handled := make(chan struct{})
+	go func() {
+		for resp := range respC {
+			responses = append(responses, resp)
+		}
+		close(handled)
+	}()
+	var wg sync.WaitGroup
+	for _, ep := range []api.Endpoint{down, up} {
+		wg.Add(1)
+		go func(ep api.Endpoint) {
+			defer wg.Done()
+			rqstr.ProcessRqst(ep, 100000, 0)
+		}(ep)
+	}
+	wg.Wait()
+	close(respC)
+	<-handled
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Each time the circuit closes it takes 5 more failures to open it again
+	if len(downRqsts) < 10 || len(downRqsts) > 25 {
+		t.Errorf("expected requests to the down endpoint to pause while its circuit was open, got %d requests", len(downRqsts))
+	}
+	if upRqsts < 10*len(downRqsts) {
+		t.Errorf("expected requests to the up endpoint to continue, got %d requests to it and %d to the down endpoint",
+			upRqsts, len(downRqsts))
+	}
+	var longestGap time.Duration
+	for i := 1; i < len(downRqsts); i++ {
+		if gap := downRqsts[i].Sub(downRqsts[i-1]); gap > longestGap {
+			longestGap = gap
+		}
+	}
+	if longestGap < cooldown*9/10 {
+		t.Errorf("expected a pause of about %s in the requests to the down endpoint, the longest was %s", cooldown, longestGap)
+	}
+
+	rh := &ResponseHandler{start: time.Now(), CircuitBreakers: circuitBreakers}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	downDetail, upDetail := runResults.EndpointDetails[down.URL], runResults.EndpointDetails[up.URL]
+	if downDetail.CircuitOpens < 2 || downDetail.CircuitOpenNanos < 2*cooldown*9/10 {
+		t.Errorf("expected the circuit to open at least twice for at least %s, got %d times for %s",
+			2*cooldown*9/10, downDetail.CircuitOpens, downDetail.CircuitOpenNanos)
+	}
+	if upDetail.CircuitOpens != 0 || upDetail.CircuitOpenNanos != 0 {
+		t.Errorf("expected the up endpoint's circuit to stay closed, got %+v", upDetail)
+	}
+	if warnings := runResults.RunSummary.Warnings; len(warnings) != 1 || !strings.Contains(warnings[0], down.URL) {
+		t.Errorf("expected a warning that the down endpoint's circuit opened, got %q", warnings)
+	}
+}
+
+// TestCircuitBreakerWindow verifies the circuit only opens once the window is full and
+// at least ErrorRate of it failed, and that it closes after the cooldown
+func TestCircuitBreakerWindow(t *testing.T) {
+	ep := api.Endpoint{URL: "http://someurl", Method: http.MethodGet,
+		CircuitBreaker: &api.CircuitBreaker{ErrorRate: 0.6, Window: 5, Cooldown: "50ms"}}
+	c, err := NewCircuitBreakers([]api.Endpoint{ep})
+	if err != nil {
+		t.Fatalf("unexpected error creating the circuit breakers: %s", err)
+	}
+	isOpen := func() bool {
+		return c.breakers[earlyFailKey(ep)].openUntil.After(time.Now())
+	}
+
+	// 3 of the 5 most recent responses must fail, the older ones are forgotten
+	for i, success := range []bool{false, false, true, true, true, false, true, false} {
+		c.record(ep, success)
+		if isOpen() {
+			t.Fatalf("expected the circuit to stay closed after outcome %d", i)
+		}
+	}
+	c.record(ep, false)
+	if !isOpen() {
+		t.Fatal("expected the circuit to open when 3 of the last 5 responses failed")
+	}
+	// Responses to requests sent before the circuit opened are ignored
+	c.record(ep, false)
+	if opens := c.breakers[earlyFailKey(ep)].opens; opens != 1 {
+		t.Errorf("expected the circuit to open once, got %d", opens)
+	}
+
+	start := time.Now()
+	if !c.wait(context.Background(), ep) {
+		t.Fatal("expected to wait for the circuit to close")
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("expected to wait about 50ms for the circuit to close, waited %s", waited)
+	}
+	// The error rate is measured afresh once the circuit closes
+	for i := 0; i < 4; i++ {
+		c.record(ep, false)
+	}
+	if isOpen() {
+		t.Error("expected the circuit to stay closed until its window is full again")
+	}
+}
+
+func TestNewCircuitBreakers(t *testing.T) {
+	tests := []struct {
+		name    string
+		cb      *api.CircuitBreaker
+		mode    string
+		pipe    int
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "none", wantNil: true},
+		{name: "defaults", cb: &api.CircuitBreaker{ErrorRate: 0.5}},
+		{name: "all errors", cb: &api.CircuitBreaker{ErrorRate: 1, Window: 100, Cooldown: "1m"}},
+		{name: "no error rate", cb: &api.CircuitBreaker{}, wantErr: true},
+		{name: "error rate too high", cb: &api.CircuitBreaker{ErrorRate: 50}, wantErr: true},
+		{name: "negative window", cb: &api.CircuitBreaker{ErrorRate: 0.5, Window: -1}, wantErr: true},
+		{name: "bad cooldown", cb: &api.CircuitBreaker{ErrorRate: 0.5, Cooldown: "soon"}, wantErr: true},
+		{name: "zero cooldown", cb: &api.CircuitBreaker{ErrorRate: 0.5, Cooldown: "0s"}, wantErr: true},
+		{name: "pipelined", cb: &api.CircuitBreaker{ErrorRate: 0.5}, pipe: 2, wantErr: true},
+		{name: "SSE", cb: &api.CircuitBreaker{ErrorRate: 0.5}, mode: api.EndpointModeSSE, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ep := api.Endpoint{URL: "http://someurl", Method: http.MethodGet, CircuitBreaker: tc.cb, Mode: tc.mode, PipelineDepth: tc.pipe}
+			c, err := NewCircuitBreakers([]api.Endpoint{ep})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected an error to be %t, got %v", tc.wantErr, err)
+			}
+			if !tc.wantErr && (c == nil) != tc.wantNil {
+				t.Errorf("expected nil circuit breakers to be %t, got %+v", tc.wantNil, c)
+			}
+		})
+	}
+}
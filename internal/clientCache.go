@@ -0,0 +1,247 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// clientCacheSize is the most responses each worker's cache holds, the oldest is
+// evicted to make room for another
+const clientCacheSize = 256
+
+// clientCacheEntry is a cached response's freshness and validators
+type clientCacheEntry struct {
+	// expires is when the response becomes stale
+	expires      time.Time
+	etag         string
+	lastModified string
+}
+
+// clientCache is a single worker's simulated browser cache, keyed by URL. It isn't
+// safe for concurrent use.
+type clientCache struct {
+	entries map[string]*clientCacheEntry
+	// order are the URLs of the entries in the order they were cached
+	order []string
+}
+
+func newClientCache() *clientCache {
+	return &clientCache{entries: make(map[string]*clientCacheEntry)}
+}
+
+// lookup returns the cached response for 'url', if there is one, and whether it's
+// still fresh at 'now'
+func (c *clientCache) lookup(url string, now time.Time) (*clientCacheEntry, bool) {
+	entry, ok := c.entries[url]
+	if !ok {
+		return nil, false
+	}
+	return entry, now.Before(entry.expires)
+}
+
+// store caches, or refreshes, the response for 'url' with the headers 'header'
+// received at 'now'. Responses that can't be reused, because they're marked
+// 'no-store' or are neither fresh nor have a validator, are removed instead.
+func (c *clientCache) store(url string, header http.Header, now time.Time) {
+	maxAge, noStore := cacheControl(header)
+	prev := c.entries[url]
+	entry := clientCacheEntry{
+		expires:      now.Add(maxAge - headerAge(header)),
+		etag:         header.Get("ETag"),
+		lastModified: header.Get("Last-Modified"),
+	}
+	// A 304 needn't repeat the validators of the response it revalidated
+	if prev != nil && entry.etag == "" && entry.lastModified == "" {
+		entry.etag, entry.lastModified = prev.etag, prev.lastModified
+	}
+	if noStore || (!now.Before(entry.expires) && entry.etag == "" && entry.lastModified == "") {
+		c.remove(url)
+		return
+	}
+	if prev != nil {
+		*prev = entry
+		return
+	}
+	if len(c.order) >= clientCacheSize {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.entries[url] = &entry
+	c.order = append(c.order, url)
+}
+
+// remove removes the cached response for 'url', if there is one
+func (c *clientCache) remove(url string) {
+	if _, ok := c.entries[url]; !ok {
+		return
+	}
+	delete(c.entries, url)
+	for i, u := range c.order {
+		if u == url {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// conditionalHeaders returns a copy of 'headers' with the headers revalidating
+// 'entry' added
+func (entry *clientCacheEntry) conditionalHeaders(headers map[string]string) map[string]string {
+	conditional := make(map[string]string, len(headers)+2)
+	for name, value := range headers {
+		conditional[name] = value
+	}
+	if entry.etag != "" {
+		conditional["If-None-Match"] = entry.etag
+	}
+	if entry.lastModified != "" {
+		conditional["If-Modified-Since"] = entry.lastModified
+	}
+	return conditional
+}
+
+// cacheControl returns how long a response with the headers 'header' is fresh for
+// according to its Cache-Control header, and whether it may be stored at all.
+// Responses without a max-age, or that must be revalidated, aren't fresh at all.
+func cacheControl(header http.Header) (maxAge time.Duration, noStore bool) {
+	noCache := false
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, arg = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "no-store":
+				noStore = true
+			case "no-cache":
+				noCache = true
+			case "max-age":
+				if secs, err := strconv.ParseInt(arg, 10, 64); err == nil && secs > 0 {
+					maxAge = time.Duration(secs) * time.Second
+				}
+			}
+		}
+	}
+	if noCache {
+		maxAge = 0
+	}
+	return maxAge, noStore
+}
+
+// headerAge returns the response's age, from its Age header, when it was received
+func headerAge(header http.Header) time.Duration {
+	secs, err := strconv.ParseInt(header.Get("Age"), 10, 64)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// The ways a request is served by a worker's cache
+const (
+	clientCacheHit = iota
+	clientCacheRevalidated
+	clientCacheNotModified
+	clientCacheFullFetch
+)
+
+// ClientCaches counts how each endpoint's requests were served by the workers'
+// simulated client caches, see api.LoadTestConfig.SimulateClientCache. It's shared
+// by all requestors, each worker has its own cache.
+type ClientCaches struct {
+	mux sync.Mutex
+	// endpoints are the counts of each endpoint, keyed by the URL its responses
+	// are reported under
+	endpoints map[string]*api.ClientCacheStats
+}
+
+// NewClientCaches returns the ClientCaches of a run, or nil if 'simulate' is false
+func NewClientCaches(simulate bool) *ClientCaches {
+	if !simulate {
+		return nil
+	}
+	return &ClientCaches{endpoints: make(map[string]*api.ClientCacheStats)}
+}
+
+// newCache returns a worker's cache, nil if 'c' is nil or 'method' isn't cacheable
+func (c *ClientCaches) newCache(method string) *clientCache {
+	if c == nil || !strings.EqualFold(method, http.MethodGet) {
+		return nil
+	}
+	return newClientCache()
+}
+
+// record counts a request to the endpoint reported as 'url' served the way 'served',
+// one of the clientCache... constants, describes
+func (c *ClientCaches) record(url string, served int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	stats, ok := c.endpoints[url]
+	if !ok {
+		stats = &api.ClientCacheStats{}
+		c.endpoints[url] = stats
+	}
+	switch served {
+	case clientCacheHit:
+		stats.Hits++
+	case clientCacheRevalidated:
+		stats.Revalidations++
+	case clientCacheNotModified:
+		stats.Revalidations++
+		stats.NotModified++
+	case clientCacheFullFetch:
+		stats.FullFetches++
+	}
+}
+
+// clientCacheResult is the counts of a single endpoint
+type clientCacheResult struct {
+	url   string
+	stats api.ClientCacheStats
+}
+
+// results returns the counts of each endpoint, ordered by URL. 'c' may be nil.
+func (c *ClientCaches) results() []clientCacheResult {
+	if c == nil {
+		return nil
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	results := make([]clientCacheResult, 0, len(c.endpoints))
+	for url, stats := range c.endpoints {
+		results = append(results, clientCacheResult{url: url, stats: *stats})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].url < results[j].url })
+	return results
+}
+
+// addClientCacheStats adds the counts of 'from' to 'to'
+func addClientCacheStats(to *api.ClientCacheStats, from api.ClientCacheStats) {
+	to.Hits += from.Hits
+	to.Revalidations += from.Revalidations
+	to.NotModified += from.NotModified
+	to.FullFetches += from.FullFetches
+}
+
+// finishClientCacheStats calculates the HitRatio of 'stats', if it's set
+func finishClientCacheStats(stats *api.ClientCacheStats) {
+	if stats == nil {
+		return
+	}
+	if total := stats.Hits + stats.Revalidations + stats.FullFetches; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestSimulateClientCache verifies fresh responses are served from the worker's
+// cache without being sent, that stale responses are revalidated with conditional
+// requests, and that only the requests sent are included in the request stats
+func TestSimulateClientCache(t *testing.T) {
+	var sent int64
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&sent, 1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/revalidated":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/lastModified":
+			w.Header().Set("Cache-Control", "max-age=0")
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			if r.Header.Get("If-Modified-Since") != "" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/noStore":
+			w.Header().Set("Cache-Control", "no-store, max-age=60")
+			w.Header().Set("ETag", `"v1"`)
+		}
+		w.Write([]byte("asset"))
+	}))
+	defer testSrv.Close()
+
+	const numRqsts = 10
+	caches := NewClientCaches(true)
+	respC := make(chan Response, 5*numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}, ClientCaches: caches}
+	eps := []api.Endpoint{
+		{URL: testSrv.URL + "/fresh", Method: http.MethodGet},
+		{URL: testSrv.URL + "/revalidated", Method: http.MethodGet},
+		{URL: testSrv.URL + "/lastModified", Method: http.MethodGet},
+		{URL: testSrv.URL + "/noStore", Method: http.MethodGet},
+		// Only GETs are cached
+		{URL: testSrv.URL + "/fresh", Method: http.MethodPost},
+	}
+	for _, ep := range eps {
+		rqstr.ProcessRqst(ep, numRqsts, 0)
+	}
+	close(respC)
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	if int64(len(responses)) != atomic.LoadInt64(&sent) {
+		t.Errorf("expected a response for each of the %d requests sent, got %d", sent, len(responses))
+	}
+
+	rh := &ResponseHandler{ClientCaches: caches, start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	tests := []struct {
+		url      string
+		expected api.ClientCacheStats
+	}{
+		{url: eps[0].URL, expected: api.ClientCacheStats{Hits: 9, FullFetches: 1, HitRatio: 0.9}},
+		{url: eps[1].URL, expected: api.ClientCacheStats{Revalidations: 9, NotModified: 9, FullFetches: 1}},
+		{url: eps[2].URL, expected: api.ClientCacheStats{Revalidations: 9, NotModified: 9, FullFetches: 1}},
+		{url: eps[3].URL, expected: api.ClientCacheStats{FullFetches: 10}},
+	}
+	for _, tc := range tests {
+		epDetail := runResults.EndpointDetails[tc.url]
+		if epDetail == nil || epDetail.ClientCache == nil {
+			t.Errorf("expected the client cache stats of %s, got none", tc.url)
+			continue
+		}
+		if *epDetail.ClientCache != tc.expected {
+			t.Errorf("expected the client cache stats of %s to be %+v, got %+v", tc.url, tc.expected, *epDetail.ClientCache)
+		}
+	}
+	// The POSTs are reported with the GETs of the same URL, but aren't cached
+	fresh := runResults.EndpointDetails[eps[0].URL]
+	if rqsts := fresh.HTTPMethodRqstStats[http.MethodGet].TotalRqsts; rqsts != 1 {
+		t.Errorf("expected the cache hits not to be included in the request stats, got %d GETs", rqsts)
+	}
+	if rqsts := fresh.HTTPMethodRqstStats[http.MethodPost].TotalRqsts; rqsts != numRqsts {
+		t.Errorf("expected all %d POSTs to be sent, got %d", numRqsts, rqsts)
+	}
+
+	expected := api.ClientCacheStats{Hits: 9, Revalidations: 18, NotModified: 18, FullFetches: 13, HitRatio: 9.0 / 40}
+	if rs := runResults.RunSummary.ClientCache; rs == nil || *rs != expected {
+		t.Errorf("expected the run's client cache stats to be %+v, got %+v", expected, rs)
+	}
+}
+
+func TestClientCacheStore(t *testing.T) {
+	now := time.Now()
+	header := func(kv ...string) http.Header {
+		h := make(http.Header)
+		for i := 0; i < len(kv); i += 2 {
+			h.Add(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	c := newClientCache()
+	c.store("aged", header("Cache-Control", "max-age=60", "Age", "50"), now)
+	if _, fresh := c.lookup("aged", now.Add(5*time.Second)); !fresh {
+		t.Errorf("expected a response 50s old with a max-age of 60s to be fresh for 10s")
+	}
+	if _, fresh := c.lookup("aged", now.Add(10*time.Second)); fresh {
+		t.Errorf("expected a response 50s old with a max-age of 60s to be stale after 10s")
+	}
+
+	c.store("unvalidated", header("Cache-Control", "no-cache"), now)
+	if entry, _ := c.lookup("unvalidated", now); entry != nil {
+		t.Errorf("expected a response that's never fresh and can't be revalidated not to be cached")
+	}
+
+	// A 304 refreshes the response it revalidated, keeping its validators
+	c.store("etag", header("Cache-Control", "max-age=0", "ETag", `"v1"`), now)
+	c.store("etag", header("Cache-Control", "max-age=30"), now)
+	entry, fresh := c.lookup("etag", now)
+	if !fresh || entry.etag != `"v1"` {
+		t.Errorf("expected the refreshed response to be fresh with its ETag, got %+v, fresh %t", entry, fresh)
+	}
+	conditional := entry.conditionalHeaders(map[string]string{"Accept": "text/html"})
+	if conditional["If-None-Match"] != `"v1"` || conditional["Accept"] != "text/html" {
+		t.Errorf("expected If-None-Match to be added to the request's headers, got %v", conditional)
+	}
+
+	c = newClientCache()
+	for i := 0; i <= clientCacheSize; i++ {
+		c.store(fmt.Sprintf("/asset/%d", i), header("Cache-Control", "max-age=60"), now)
+	}
+	if len(c.entries) != clientCacheSize || len(c.order) != clientCacheSize {
+		t.Errorf("expected the cache to hold at most %d responses, got %d", clientCacheSize, len(c.entries))
+	}
+	if entry, _ := c.lookup("/asset/0", now); entry != nil {
+		t.Errorf("expected the oldest response to be evicted")
+	}
+}
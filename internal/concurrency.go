@@ -0,0 +1,56 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// concurrencyEfficiency records the run's effective concurrency, the average number of
+// requests in flight, and its ratio to 'configured', the configured concurrency, in
+// 'rs'. 'inFlight' is the total time the run's requests, successful or not, were in
+// flight. Time a requestor spends waiting, e.g., for a rate limit or a lock group, or
+// after it's stopped, e.g., because an endpoint failed early, isn't in flight.
+func concurrencyEfficiency(configured int, inFlight time.Duration, rs *api.RunSummary) {
+	if rs.RunDurationNanos <= 0 {
+		return
+	}
+	rs.EffectiveConcurrency = float64(inFlight) / float64(rs.RunDurationNanos)
+	if configured > 0 {
+		rs.ConcurrencyEfficiency = rs.EffectiveConcurrency / float64(configured)
+	}
+}
+
+// lowParallelismWarnRatio is the fraction of the configured concurrency below which
+// the run's EffectiveParallelism is warned of
+const lowParallelismWarnRatio = 0.25
+
+// effectiveParallelism records the average parallelism of the successful requests,
+// the sum of their durations divided by the run's duration, of the run in 'rs' and of
+// each endpoint in 'epDetails'. It returns a warning if the run's parallelism was
+// far below 'configured', the configured concurrency, or an empty string if it
+// wasn't or the run had no successful requests.
+func effectiveParallelism(configured int, rs *api.RunSummary, epDetails map[string]*api.EndpointDetail) string {
+	if rs.RunDurationNanos <= 0 {
+		return ""
+	}
+	rs.EffectiveParallelism = float64(rs.RqstStats.TotalRequestDurationNanos) / float64(rs.RunDurationNanos)
+	for _, epDetail := range epDetails {
+		var total time.Duration
+		for _, methodStats := range epDetail.HTTPMethodRqstStats {
+			total += methodStats.TotalRequestDurationNanos
+		}
+		epDetail.EffectiveParallelism = float64(total) / float64(rs.RunDurationNanos)
+	}
+
+	if configured <= 0 || rs.RqstStats.TotalRqsts == 0 || rs.EffectiveParallelism >= lowParallelismWarnRatio*float64(configured) {
+		return ""
+	}
+	return fmt.Sprintf("the effective parallelism, %.1f, was far below the configured concurrency, %d, the run was latency bound or heyyall couldn't generate the configured load",
+		rs.EffectiveParallelism, configured)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestConcurrencyEfficiency verifies the run's effective concurrency is close to the
+// configured concurrency when the requestors are always waiting for responses, and
+// well below it when they're throttled
+func TestConcurrencyEfficiency(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte("OK"))
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name string
+		// rqstRate is each requestor's requests per second, 0 is unthrottled
+		rqstRate      float64
+		minEfficiency float64
+		maxEfficiency float64
+	}{
+		{name: "fast server", rqstRate: 0, minEfficiency: 0.6, maxEfficiency: 1.05},
+		{name: "throttled", rqstRate: 20, minEfficiency: 0, maxEfficiency: 0.5},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			concurrency := 4
+			numRqsts := 5
+			respC := make(chan Response, concurrency*numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: concurrency}},
+			}
+			ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet}
+
+			rh := ResponseHandler{start: time.Now(), Concurrency: concurrency}
+			var wg sync.WaitGroup
+			for i := 0; i < concurrency; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rqstr.ProcessRqst(ep, numRqsts, tc.rqstRate)
+				}()
+			}
+			wg.Wait()
+			close(respC)
+
+			var responses []Response
+			for resp := range respC {
+				responses = append(responses, resp)
+			}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			rs := runResults.RunSummary
+			if rs.ConcurrencyEfficiency < tc.minEfficiency || rs.ConcurrencyEfficiency > tc.maxEfficiency {
+				t.Errorf("expected a concurrency efficiency between %.2f and %.2f, got %.2f", tc.minEfficiency,
+					tc.maxEfficiency, rs.ConcurrencyEfficiency)
+			}
+			if want := rs.ConcurrencyEfficiency * float64(concurrency); rs.EffectiveConcurrency != want {
+				t.Errorf("expected an effective concurrency of %.2f, got %.2f", want, rs.EffectiveConcurrency)
+			}
+		})
+	}
+}
+
+func TestEffectiveParallelism(t *testing.T) {
+	tests := []struct {
+		name          string
+		runDuration   time.Duration
+		totalRqsts    int64
+		configured    int
+		expected      float64
+		expectedEP    float64
+		expectWarning bool
+	}{
+		{name: "parallel", runDuration: time.Second, totalRqsts: 40, configured: 4, expected: 4, expectedEP: 3},
+		{name: "latency bound", runDuration: 4 * time.Second, totalRqsts: 40, configured: 5, expected: 1, expectedEP: 0.75, expectWarning: true},
+		{name: "not configured", runDuration: 4 * time.Second, totalRqsts: 40, expected: 1, expectedEP: 0.75},
+		{name: "no requests", runDuration: time.Second, configured: 4},
+		{name: "no duration", totalRqsts: 40, configured: 4},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rs := api.RunSummary{RunDurationNanos: tc.runDuration}
+			rs.RqstStats.TotalRqsts = tc.totalRqsts
+			epDetail := &api.EndpointDetail{HTTPMethodRqstStats: make(map[string]*api.RqstStats)}
+			if tc.totalRqsts > 0 {
+				rs.RqstStats.TotalRequestDurationNanos = 4 * time.Second
+				epDetail.HTTPMethodRqstStats[http.MethodGet] = &api.RqstStats{TotalRequestDurationNanos: 2 * time.Second}
+				epDetail.HTTPMethodRqstStats[http.MethodPut] = &api.RqstStats{TotalRequestDurationNanos: time.Second}
+			}
+
+			warning := effectiveParallelism(tc.configured, &rs, map[string]*api.EndpointDetail{"http://someurl": epDetail})
+			if rs.EffectiveParallelism != tc.expected || epDetail.EffectiveParallelism != tc.expectedEP {
+				t.Errorf("expected an effective parallelism of %.2f, %.2f for the endpoint, got %.2f, %.2f",
+					tc.expected, tc.expectedEP, rs.EffectiveParallelism, epDetail.EffectiveParallelism)
+			}
+			if (warning != "") != tc.expectWarning {
+				t.Errorf("expected a warning to be %t, got %q", tc.expectWarning, warning)
+			}
+		})
+	}
+}
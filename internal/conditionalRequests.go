@@ -0,0 +1,57 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"net/http"
+	"strings"
+)
+
+// etagCache is a single worker's ETags, keyed by URL, for sending conditional
+// requests, see api.LoadTestConfig.ConditionalRequests. Like a clientCache it holds
+// at most clientCacheSize ETags. It isn't safe for concurrent use.
+type etagCache struct {
+	etags map[string]string
+	// order are the URLs of the ETags in the order they were first captured
+	order []string
+}
+
+// newETagCache returns a worker's etagCache, or nil if 'conditional' is false or
+// 'method' doesn't have conditional requests
+func newETagCache(conditional bool, method string) *etagCache {
+	if !conditional || (!strings.EqualFold(method, http.MethodGet) && !strings.EqualFold(method, http.MethodHead)) {
+		return nil
+	}
+	return &etagCache{etags: make(map[string]string)}
+}
+
+// conditionalHeaders returns 'headers' with an If-None-Match header of the ETag
+// captured for 'url', if there is one. 'headers' is copied rather than modified.
+func (c *etagCache) conditionalHeaders(url string, headers map[string]string) map[string]string {
+	etag, ok := c.etags[url]
+	if !ok {
+		return headers
+	}
+	entry := clientCacheEntry{etag: etag}
+	return entry.conditionalHeaders(headers)
+}
+
+// capture remembers the ETag of a successful response from 'url' with the headers
+// 'header'. A response without one, e.g., a 304 that doesn't repeat it, doesn't
+// replace the ETag already captured.
+func (c *etagCache) capture(url string, status int, header http.Header) {
+	etag := header.Get("ETag")
+	if etag == "" || status >= http.StatusBadRequest {
+		return
+	}
+	if _, ok := c.etags[url]; !ok {
+		if len(c.order) >= clientCacheSize {
+			delete(c.etags, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.order = append(c.order, url)
+	}
+	c.etags[url] = etag
+}
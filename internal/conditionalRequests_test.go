@@ -0,0 +1,75 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestConditionalRequests verifies each worker sends the ETag it captured as the
+// If-None-Match header of its next request, and that the 304s are counted
+func TestConditionalRequests(t *testing.T) {
+	var version, unconditionalPosts int64
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if r.Header.Get("If-None-Match") == "" {
+				atomic.AddInt64(&unconditionalPosts, 1)
+			}
+			return
+		}
+		// The resource changes after its 5th request
+		etag := `"v1"`
+		if atomic.AddInt64(&version, 1) > 5 {
+			etag = `"v2"`
+		}
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("resource"))
+	}))
+	defer testSrv.Close()
+
+	const numRqsts = 10
+	respC := make(chan Response, 2*numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}, ConditionalRequests: true}
+	get := api.Endpoint{URL: testSrv.URL + "/resource", Method: http.MethodGet}
+	post := api.Endpoint{URL: testSrv.URL + "/resource", Method: http.MethodPost}
+	rqstr.ProcessRqst(get, numRqsts, 0)
+	rqstr.ProcessRqst(post, numRqsts, 0)
+	close(respC)
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	epDetail := runResults.EndpointDetails[get.URL]
+	if epDetail == nil {
+		t.Fatalf("expected the details of %s, got none", get.URL)
+	}
+	// The 1st and 6th requests get the resource, the rest are 304s
+	if epDetail.NotModifiedCount != 8 || epDetail.HTTPMethodStatusDist[http.MethodGet][http.StatusNotModified] != 8 {
+		t.Errorf("expected 8 304s, got %d, statuses %v", epDetail.NotModifiedCount, epDetail.HTTPMethodStatusDist)
+	}
+	if epDetail.HTTPMethodStatusDist[http.MethodGet][http.StatusOK] != 2 {
+		t.Errorf("expected 2 200s, got statuses %v", epDetail.HTTPMethodStatusDist)
+	}
+	if n := atomic.LoadInt64(&unconditionalPosts); n != numRqsts {
+		t.Errorf("expected the POSTs not to be conditional, got %d of %d unconditional", n, numRqsts)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// connSetupBounds are the upper bounds of the buckets of ConnSetupDist. Connection
+// setup times vary by orders of magnitude, e.g., between a local and a remote server,
+// so the buckets grow roughly exponentially. Times longer than the last bound are
+// counted in a final, unbounded, bucket.
+var connSetupBounds = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second,
+}
+
+// connSetupDist buckets the connection setup times 'samples' by connSetupBounds. The
+// empty buckets before the first and after the last non-empty bucket are omitted.
+// It returns nil if there aren't any samples.
+func connSetupDist(samples []time.Duration) []api.ConnSetupBucket {
+	if len(samples) == 0 {
+		return nil
+	}
+	dist := make([]api.ConnSetupBucket, len(connSetupBounds)+1)
+	for i, bound := range connSetupBounds {
+		dist[i].UpperBoundNanos = bound
+	}
+	for _, d := range samples {
+		i := 0
+		for i < len(connSetupBounds) && d > connSetupBounds[i] {
+			i++
+		}
+		dist[i].Count++
+	}
+
+	first, last := 0, len(dist)-1
+	for dist[first].Count == 0 {
+		first++
+	}
+	for dist[last].Count == 0 {
+		last--
+	}
+	return dist[first : last+1]
+}
+
+// formatConnSetupBucket labels 'b', a bucket of ConnSetupDist, by its upper bound,
+// e.g., '<= 5ms', or, for the final bucket, by the largest bound, e.g., '> 5s'
+func formatConnSetupBucket(b api.ConnSetupBucket) string {
+	if b.UpperBoundNanos == 0 {
+		return "> " + connSetupBounds[len(connSetupBounds)-1].String()
+	}
+	return "<= " + b.UpperBoundNanos.String()
+}
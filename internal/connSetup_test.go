@@ -0,0 +1,118 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestConnSetupDist verifies that, with keep-alives disabled, requests record the
+// setup time of their new connections, that the setup times are bucketed into
+// ConnSetupDist, and that reused connections aren't recorded
+func TestConnSetupDist(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name              string
+		disableKeepAlives bool
+		minSamples        int
+		maxSamples        int
+	}{
+		// Every request opens a new connection, but the test only relies on most
+		// of them being recorded
+		{name: "KeepAlivesDisabled", disableKeepAlives: true, minSamples: 15, maxSamples: 20},
+		{name: "KeepAlives", minSamples: 1, maxSamples: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			numRqsts := 20
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    http.Client{Transport: &http.Transport{DisableKeepAlives: tc.disableKeepAlives}},
+			}
+			rqstr.ProcessRqst(api.Endpoint{URL: testSrv.URL, Method: http.MethodGet}, numRqsts, 0)
+			close(respC)
+
+			var responses []Response
+			for resp := range respC {
+				responses = append(responses, resp)
+			}
+
+			rh := ResponseHandler{start: time.Now()}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			rs := runResults.RunSummary
+			if n := len(rs.ConnSetupNanos); n < tc.minSamples || n > tc.maxSamples {
+				t.Fatalf("expected %d to %d connection setup samples, got %d", tc.minSamples, tc.maxSamples, n)
+			}
+			var bucketed int64
+			for _, b := range rs.ConnSetupDist {
+				bucketed += b.Count
+			}
+			if bucketed != int64(len(rs.ConnSetupNanos)) {
+				t.Errorf("expected the %d samples to be bucketed, got %+v", len(rs.ConnSetupNanos), rs.ConnSetupDist)
+			}
+
+			var b bytes.Buffer
+			printNetworkDetails(&b, rs)
+			if !strings.Contains(b.String(), "New Connection Setup (TCP+TLS):") {
+				t.Errorf("expected the connection setup distribution to be reported, got %s", b.String())
+			}
+		})
+	}
+}
+
+// TestConnSetupDistBuckets verifies setup times are counted in the bucket with the
+// smallest bound that isn't shorter than them, and that the empty buckets at either
+// end are omitted
+func TestConnSetupDistBuckets(t *testing.T) {
+	if dist := connSetupDist(nil); dist != nil {
+		t.Errorf("expected no distribution without samples, got %+v", dist)
+	}
+
+	dist := connSetupDist([]time.Duration{
+		3 * time.Millisecond, 5 * time.Millisecond, 15 * time.Millisecond, 40 * time.Millisecond, 10 * time.Second,
+	})
+	expected := []api.ConnSetupBucket{
+		{UpperBoundNanos: 5 * time.Millisecond, Count: 2},
+		{UpperBoundNanos: 10 * time.Millisecond},
+		{UpperBoundNanos: 20 * time.Millisecond, Count: 1},
+		{UpperBoundNanos: 50 * time.Millisecond, Count: 1},
+		{UpperBoundNanos: 100 * time.Millisecond},
+		{UpperBoundNanos: 200 * time.Millisecond},
+		{UpperBoundNanos: 500 * time.Millisecond},
+		{UpperBoundNanos: time.Second},
+		{UpperBoundNanos: 2 * time.Second},
+		{UpperBoundNanos: 5 * time.Second},
+		{Count: 1},
+	}
+	if len(dist) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, dist)
+	}
+	for i := range expected {
+		if dist[i] != expected[i] {
+			t.Errorf("expected bucket %d to be %+v, got %+v", i, expected[i], dist[i])
+		}
+	}
+	if label := formatConnSetupBucket(dist[len(dist)-1]); label != "> 5s" {
+		t.Errorf("expected the last bucket to be labeled '> 5s', got %q", label)
+	}
+}
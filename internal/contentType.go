@@ -0,0 +1,129 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// contentTypeMismatchWarnRate is the fraction of an endpoint's responses that, if
+// more than it didn't have its ExpectedContentType, is warned of
+const contentTypeMismatchWarnRate = 0.01
+
+// validateExpectedContentType verifies that 'ep's ExpectedContentType, if it has one,
+// is a media type
+func validateExpectedContentType(ep api.Endpoint) error {
+	if ep.ExpectedContentType == "" {
+		return nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(ep.ExpectedContentType); err != nil || !strings.Contains(mediaType, "/") {
+		return fmt.Errorf("endpoint %s %s has an ExpectedContentType, %q, it must be a media type, e.g., 'application/json'",
+			ep.Method, ep.URL, ep.ExpectedContentType)
+	}
+	return nil
+}
+
+// responseContentType returns the media type, without its parameters, of the
+// Content-Type header in 'header'. It returns api.GroupByHeaderNone if there's no
+// header, or api.ContentTypeInvalid if it can't be parsed.
+func responseContentType(header http.Header) string {
+	value := header.Get("Content-Type")
+	if value == "" {
+		return api.GroupByHeaderNone
+	}
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil || !strings.Contains(mediaType, "/") {
+		return api.ContentTypeInvalid
+	}
+	return mediaType
+}
+
+// checkContentType returns an error if the media type 'contentType' of a successful
+// response isn't 'ep's ExpectedContentType. Responses without a body, e.g., a 204,
+// aren't checked.
+func checkContentType(ep api.Endpoint, contentType string, bodyBytes int64) error {
+	if contentType == api.GroupByHeaderNone && bodyBytes == 0 {
+		return nil
+	}
+	expected, _, _ := mime.ParseMediaType(ep.ExpectedContentType)
+	if contentType != expected {
+		return fmt.Errorf("response Content-Type %s isn't the ExpectedContentType %s", contentType, expected)
+	}
+	return nil
+}
+
+// accumulateContentType tallies the media type of 'resp' in 'epDetail's ContentTypes,
+// if it had a response
+func accumulateContentType(resp Response, epDetail *api.EndpointDetail) {
+	contentType := resp.ContentType
+	if contentType == "" {
+		return
+	}
+	if epDetail.ContentTypes == nil {
+		epDetail.ContentTypes = make(map[string]int64)
+	}
+	if _, ok := epDetail.ContentTypes[contentType]; !ok && contentType != api.GroupByHeaderNone &&
+		contentType != api.ContentTypeInvalid && contentTypeCount(epDetail.ContentTypes) >= api.MaxGroupByHeaderValues {
+		contentType = api.GroupByHeaderOther
+	}
+	epDetail.ContentTypes[contentType]++
+	if resp.ErrCategory == api.ErrCategoryUnexpectedContentType {
+		epDetail.ContentTypeMismatches++
+	}
+}
+
+// contentTypeCount returns the number of media types in 'dist', not including its
+// GroupByHeaderNone, GroupByHeaderOther, and ContentTypeInvalid groups
+func contentTypeCount(dist map[string]int64) int {
+	n := instanceCount(dist)
+	if _, ok := dist[api.ContentTypeInvalid]; ok {
+		n--
+	}
+	return n
+}
+
+// contentTypeWarnings returns a warning for each endpoint more than
+// contentTypeMismatchWarnRate of whose responses didn't have its
+// ExpectedContentType, sorted by endpoint
+func contentTypeWarnings(epDetails map[string]*api.EndpointDetail) []string {
+	var warnings []string
+	for url, epDetail := range epDetails {
+		if epDetail.ContentTypeMismatches == 0 {
+			continue
+		}
+		var total int64
+		for _, n := range epDetail.ContentTypes {
+			total += n
+		}
+		rate := float64(epDetail.ContentTypeMismatches) / float64(total)
+		if rate <= contentTypeMismatchWarnRate {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s: %d responses, %s, didn't have the ExpectedContentType, the content types were %s",
+			url, epDetail.ContentTypeMismatches, formatPercent(rate), formatContentTypes(epDetail.ContentTypes)))
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+// formatContentTypes returns 'dist', e.g., 'application/json: 98, text/html: 2',
+// ordered by media type
+func formatContentTypes(dist map[string]int64) string {
+	types := make([]string, 0, len(dist))
+	for contentType := range dist {
+		types = append(types, contentType)
+	}
+	sort.Strings(types)
+	for i, contentType := range types {
+		types[i] = fmt.Sprintf("%s: %d", contentType, dist[contentType])
+	}
+	return strings.Join(types, ", ")
+}
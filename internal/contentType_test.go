@@ -0,0 +1,118 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestResponseContentType(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: "application/json", expected: "application/json"},
+		{value: "Text/HTML; charset=UTF-8", expected: "text/html"},
+		{value: "", expected: api.GroupByHeaderNone},
+		{value: "json", expected: api.ContentTypeInvalid},
+		{value: "text/html; charset", expected: api.ContentTypeInvalid},
+	}
+	for _, tc := range tests {
+		header := http.Header{}
+		if tc.value != "" {
+			header.Set("Content-Type", tc.value)
+		}
+		if contentType := responseContentType(header); contentType != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.value, tc.expected, contentType)
+		}
+	}
+}
+
+func TestValidateExpectedContentType(t *testing.T) {
+	for contentType, wantErr := range map[string]bool{"": false, "application/json": false, "text/html; charset=utf-8": false, "json": true} {
+		ep := api.Endpoint{URL: "http://someurl", Method: http.MethodGet, ExpectedContentType: contentType}
+		if err := validateExpectedContentType(ep); (err != nil) != wantErr {
+			t.Errorf("%q: expected an error to be %t, got %v", contentType, wantErr, err)
+		}
+	}
+}
+
+// TestContentTypeMismatch verifies successful responses without the endpoint's
+// ExpectedContentType are failed, and warned of, and the content types of all of the
+// responses are reported
+func TestContentTypeMismatch(t *testing.T) {
+	var n int64
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt64(&n, 1) % 10 {
+		case 0:
+			// An overloaded gateway's error page
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>Service Unavailable</html>"))
+		case 1:
+			w.WriteHeader(http.StatusNoContent)
+		case 2:
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write([]byte(`{"status": "ok"}`))
+		}
+	}))
+	defer testSrv.Close()
+
+	numRqsts := 100
+	ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet, ExpectedContentType: "application/json"}
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}}
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	epDetail := runResults.EndpointDetails[ep.URL]
+	expected := map[string]int64{"application/json": 70, "text/html": 20, api.GroupByHeaderNone: 10}
+	if !reflect.DeepEqual(epDetail.ContentTypes, expected) {
+		t.Errorf("expected the content types %v, got %v", expected, epDetail.ContentTypes)
+	}
+	// The 204s don't have a body, and the 503s have already failed
+	if epDetail.ContentTypeMismatches != 10 || epDetail.ErrorCategories[api.ErrCategoryUnexpectedContentType] != 10 {
+		t.Errorf("expected 10 unexpected content types, got %d, %v", epDetail.ContentTypeMismatches, epDetail.ErrorCategories)
+	}
+	warnings := strings.Join(runResults.RunSummary.Warnings, "\n")
+	if !strings.Contains(warnings, "10 responses, 10.00%, didn't have the ExpectedContentType") {
+		t.Errorf("expected a warning of the unexpected content types, got %q", warnings)
+	}
+}
+
+// TestContentTypeWarningThreshold verifies occasional unexpected content types aren't
+// warned of
+func TestContentTypeWarningThreshold(t *testing.T) {
+	epDetails := map[string]*api.EndpointDetail{
+		"http://a": {ContentTypes: map[string]int64{"application/json": 199, "text/html": 1}, ContentTypeMismatches: 1},
+		"http://b": {ContentTypes: map[string]int64{"application/json": 98, api.ContentTypeInvalid: 2}, ContentTypeMismatches: 2},
+	}
+	warnings := contentTypeWarnings(epDetails)
+	expected := []string{"http://b: 2 responses, 2.00%, didn't have the ExpectedContentType, the content types were (invalid): 2, application/json: 98"}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("expected %q, got %q", expected, warnings)
+	}
+}
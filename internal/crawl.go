@@ -0,0 +1,257 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// crawlURL is a URL discovered by a crawl
+type crawlURL struct {
+	url string
+	// depth is the number of links followed from the seed to the URL
+	depth int
+}
+
+// crawl is the state of a single endpoint's Crawl
+type crawl struct {
+	seed string
+	// reportURL is the URL the crawl's results are reported under
+	reportURL string
+	seedHost  string
+	config    api.Crawl
+	// urls are the URLs to request, in the order they were discovered, starting
+	// with the seed. 'next' is the index of the next one to request.
+	urls []crawlURL
+	next int
+	// seen are the distinct links discovered, whether or not they were followed
+	seen        map[string]bool
+	maxDepth    int
+	notFollowed int64
+	otherHosts  int64
+}
+
+// Crawlers discovers the URLs linked from the responses of each endpoint with an
+// Endpoint.Crawl. It's shared by all requestors, so the URLs discovered by one are
+// requested by all of the endpoint's requestors.
+type Crawlers struct {
+	mux sync.Mutex
+	// crawls is the crawl of each endpoint with one keyed by earlyFailKey. The map
+	// is only modified by NewCrawlers.
+	crawls map[string]*crawl
+}
+
+// NewCrawlers returns the Crawlers of 'eps', or nil if none of them have a Crawl
+func NewCrawlers(eps []api.Endpoint) (*Crawlers, error) {
+	c := Crawlers{crawls: make(map[string]*crawl)}
+	for _, ep := range eps {
+		cfg := ep.Crawl
+		if cfg == nil {
+			continue
+		}
+		if ep.PipelineDepth > 1 || ep.KeepAliveProbe != nil || ep.Mode != "" || len(ep.Variants) > 0 {
+			return nil, fmt.Errorf("endpoint %s %s has a Crawl, it can't also have a PipelineDepth, a KeepAliveProbe, a Mode, or Variants",
+				ep.Method, ep.URL)
+		}
+		seed, err := url.Parse(ep.URL)
+		if err != nil || strings.Contains(ep.URL, "{{") || (seed.Scheme != "http" && seed.Scheme != "https") || seed.Host == "" {
+			return nil, fmt.Errorf("endpoint %s %s has a Crawl, its URL must be an absolute http or https URL without templates",
+				ep.Method, ep.URL)
+		}
+		if cfg.Depth < 1 {
+			return nil, fmt.Errorf("endpoint %s %s has a Crawl Depth of %d, it must be at least 1", ep.Method, ep.URL, cfg.Depth)
+		}
+		if !cfg.LinkHeader && cfg.LinkJSONPath == "" {
+			return nil, fmt.Errorf("endpoint %s %s has a Crawl, it must follow its LinkHeader, its LinkJSONPath, or both",
+				ep.Method, ep.URL)
+		}
+		config := *cfg
+		if config.MaxURLs == 0 {
+			config.MaxURLs = api.DefaultCrawlMaxURLs
+		}
+		if config.MaxURLs < 0 {
+			return nil, fmt.Errorf("endpoint %s %s has a Crawl MaxURLs of %d, it must not be negative", ep.Method, ep.URL, cfg.MaxURLs)
+		}
+		c.crawls[earlyFailKey(ep)] = &crawl{
+			seed:      ep.URL,
+			reportURL: summaryURL(ep, ep.URL),
+			seedHost:  seed.Host,
+			config:    config,
+			urls:      []crawlURL{{url: ep.URL}},
+			seen:      map[string]bool{ep.URL: true},
+		}
+	}
+	if len(c.crawls) == 0 {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+// nextURL returns the next URL to request for 'ep', and its depth. The URLs discovered
+// so far are requested in turn. If 'ep' doesn't have a crawl its URL is returned.
+// 'c' may be nil.
+func (c *Crawlers) nextURL(ep api.Endpoint) crawlURL {
+	if c == nil {
+		return crawlURL{url: ep.URL}
+	}
+	cr, ok := c.crawls[earlyFailKey(ep)]
+	if !ok {
+		return crawlURL{url: ep.URL}
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	next := cr.urls[cr.next%len(cr.urls)]
+	cr.next++
+	return next
+}
+
+// discover adds the links of a successful response to a request for 'from', a URL of
+// 'ep's crawl, to the URLs the crawl requests. 'header' and 'body' are the response's
+// headers and body. 'c' may be nil.
+func (c *Crawlers) discover(ep api.Endpoint, from crawlURL, header http.Header, body []byte) {
+	if c == nil {
+		return
+	}
+	cr, ok := c.crawls[earlyFailKey(ep)]
+	if !ok || from.depth >= cr.config.Depth {
+		return
+	}
+	links := crawlLinks(cr.config, from.url, header, body)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for _, link := range links {
+		if cr.seen[link.String()] {
+			continue
+		}
+		cr.seen[link.String()] = true
+		switch {
+		case link.Host != cr.seedHost && !cr.config.AllowOtherHosts:
+			cr.otherHosts++
+		case len(cr.urls) >= cr.config.MaxURLs:
+			cr.notFollowed++
+		default:
+			cr.urls = append(cr.urls, crawlURL{url: link.String(), depth: from.depth + 1})
+			if from.depth+1 > cr.maxDepth {
+				cr.maxDepth = from.depth + 1
+			}
+		}
+	}
+}
+
+// crawlsJSON returns true if 'ep' has a Crawl that follows the links in its JSON
+// response bodies
+func crawlsJSON(ep api.Endpoint) bool {
+	return ep.Crawl != nil && ep.Crawl.LinkJSONPath != ""
+}
+
+// crawlLinks returns the absolute http and https URLs of the links, as configured by
+// 'config', of the response to a request for 'from'. Relative links are resolved
+// against 'from', and fragments are removed.
+func crawlLinks(config api.Crawl, from string, header http.Header, body []byte) []*url.URL {
+	var refs []string
+	if config.LinkHeader {
+		refs = append(refs, linkHeaderURLs(header)...)
+	}
+	if config.LinkJSONPath != "" {
+		value, err := lookupJSONPath(body, config.LinkJSONPath)
+		if err != nil {
+			log.Debug().Err(err).Msgf("Requestor: no links in the response from %s", from)
+		}
+		switch v := value.(type) {
+		case string:
+			refs = append(refs, v)
+		case []interface{}:
+			for _, elem := range v {
+				if s, ok := elem.(string); ok {
+					refs = append(refs, s)
+				}
+			}
+		}
+	}
+
+	base, err := url.Parse(from)
+	if err != nil {
+		return nil
+	}
+	var links []*url.URL
+	for _, ref := range refs {
+		u, err := url.Parse(strings.TrimSpace(ref))
+		if err != nil {
+			continue
+		}
+		link := base.ResolveReference(u)
+		link.Fragment = ""
+		if link.Scheme == "http" || link.Scheme == "https" {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// linkHeaderURLs returns the URLs of the links in 'header's Link headers, e.g.,
+// '<https://api.example.com/items?page=2>; rel="next", </items?page=9>; rel="last"'
+func linkHeaderURLs(header http.Header) []string {
+	var urls []string
+	for _, value := range header.Values("Link") {
+		for {
+			start := strings.IndexByte(value, '<')
+			if start < 0 {
+				break
+			}
+			end := strings.IndexByte(value[start:], '>')
+			if end < 0 {
+				break
+			}
+			urls = append(urls, value[start+1:start+end])
+			value = value[start+end+1:]
+		}
+	}
+	return urls
+}
+
+// crawlResult is the outcome of a single endpoint's crawl
+type crawlResult struct {
+	url   string
+	stats api.CrawlStats
+}
+
+// results returns the outcome of each endpoint's crawl, ordered by method and URL.
+// 'c' may be nil.
+func (c *Crawlers) results() []crawlResult {
+	if c == nil {
+		return nil
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	keys := make([]string, 0, len(c.crawls))
+	for key := range c.crawls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	results := make([]crawlResult, 0, len(keys))
+	for _, key := range keys {
+		cr := c.crawls[key]
+		results = append(results, crawlResult{
+			url: cr.reportURL,
+			stats: api.CrawlStats{
+				URLs:        int64(len(cr.urls)),
+				MaxDepth:    cr.maxDepth,
+				NotFollowed: cr.notFollowed,
+				OtherHosts:  cr.otherHosts,
+			},
+		})
+	}
+	return results
+}
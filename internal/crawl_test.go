@@ -0,0 +1,145 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestNewCrawlers(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		crawl   *api.Crawl
+		pipe    int
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "none", wantNil: true},
+		{name: "link header", crawl: &api.Crawl{Depth: 1, LinkHeader: true}},
+		{name: "JSON", crawl: &api.Crawl{Depth: 3, LinkJSONPath: "links", MaxURLs: 10, AllowOtherHosts: true}},
+		{name: "no depth", crawl: &api.Crawl{LinkHeader: true}, wantErr: true},
+		{name: "no links", crawl: &api.Crawl{Depth: 1}, wantErr: true},
+		{name: "negative max URLs", crawl: &api.Crawl{Depth: 1, LinkHeader: true, MaxURLs: -1}, wantErr: true},
+		{name: "templated", url: "http://someurl/{{ .ID }}", crawl: &api.Crawl{Depth: 1, LinkHeader: true}, wantErr: true},
+		{name: "relative", url: "/items", crawl: &api.Crawl{Depth: 1, LinkHeader: true}, wantErr: true},
+		{name: "pipelined", crawl: &api.Crawl{Depth: 1, LinkHeader: true}, pipe: 2, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			url := tc.url
+			if url == "" {
+				url = "http://someurl/items"
+			}
+			ep := api.Endpoint{URL: url, Method: http.MethodGet, Crawl: tc.crawl, PipelineDepth: tc.pipe}
+			c, err := NewCrawlers([]api.Endpoint{ep})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected an error to be %t, got %v", tc.wantErr, err)
+			}
+			if !tc.wantErr && (c == nil) != tc.wantNil {
+				t.Errorf("expected nil crawlers to be %t, got %+v", tc.wantNil, c)
+			}
+		})
+	}
+}
+
+// TestCrawlLinks verifies links are found in Link headers and JSON bodies, and are
+// resolved against the URL they were found in
+func TestCrawlLinks(t *testing.T) {
+	header := http.Header{"Link": {`<https://api.example.com/items?page=2>; rel="next", </items?page=9>; rel="last"`}}
+	body := []byte(`{"data": {"links": ["detail/1", "mailto:ops@example.com", 7, "/items/2#top"]}}`)
+	config := api.Crawl{LinkHeader: true, LinkJSONPath: "data.links"}
+
+	var links []string
+	for _, link := range crawlLinks(config, "https://api.example.com/v1/items", header, body) {
+		links = append(links, link.String())
+	}
+	expected := []string{
+		"https://api.example.com/items?page=2",
+		"https://api.example.com/items?page=9",
+		"https://api.example.com/v1/detail/1",
+		"https://api.example.com/items/2",
+	}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected %v, got %v", expected, links)
+	}
+}
+
+// TestCrawl verifies the URLs discovered from the seed's responses are subsequently
+// requested, up to the crawl's Depth and MaxURLs
+func TestCrawl(t *testing.T) {
+	var mux sync.Mutex
+	requested := make(map[string]int)
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		requested[r.URL.Path]++
+		mux.Unlock()
+		switch r.URL.Path {
+		case "/":
+			w.Header().Add("Link", `</a>; rel="next", <http://other.example.com/x>; rel="external"`)
+			w.Write([]byte(`{"links": ["/b", "/c#section"]}`))
+		case "/a":
+			w.Header().Add("Link", `</d>; rel="next"`)
+		case "/b":
+			w.Write([]byte(`{"links": ["/f"]}`))
+		case "/d":
+			w.Header().Add("Link", `</e>; rel="next"`)
+		}
+	}))
+	defer testSrv.Close()
+
+	ep := api.Endpoint{
+		URL:    testSrv.URL + "/",
+		Method: http.MethodGet,
+		Crawl:  &api.Crawl{Depth: 2, LinkHeader: true, LinkJSONPath: "links", MaxURLs: 5},
+	}
+	crawlers, err := NewCrawlers([]api.Endpoint{ep})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	numRqsts := 10
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}, Crawlers: crawlers}
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	// The seed's links, /a, /b, and /c, are requested, then /a's link, /d. /d is at
+	// the crawl's Depth so its link, /e, isn't followed, and the crawl reached its
+	// MaxURLs before /b's link, /f, was discovered.
+	expected := map[string]int{"/": 2, "/a": 2, "/b": 2, "/c": 2, "/d": 2}
+	mux.Lock()
+	if !reflect.DeepEqual(requested, expected) {
+		t.Errorf("expected the requests %v, got %v", expected, requested)
+	}
+	mux.Unlock()
+
+	rh := &ResponseHandler{start: time.Now(), Crawlers: crawlers}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	for path := range expected {
+		if n := runResults.EndpointSummary[testSrv.URL+path][http.MethodGet]; n != 2 {
+			t.Errorf("expected 2 requests to %s to be reported, got %d", path, n)
+		}
+	}
+	stats := runResults.EndpointDetails[ep.URL].Crawl
+	expectedStats := api.CrawlStats{URLs: 5, MaxDepth: 2, NotFollowed: 1, OtherHosts: 1}
+	if stats == nil || *stats != expectedStats {
+		t.Errorf("expected %+v, got %+v", expectedStats, stats)
+	}
+}
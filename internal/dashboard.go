@@ -0,0 +1,242 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+	"golang.org/x/term"
+)
+
+const (
+	// dashboardInterval is how often the dashboard is redrawn
+	dashboardInterval = time.Second
+	// dashboardWindow is the period, ending when the dashboard is drawn, whose
+	// responses are summarized
+	dashboardWindow = 10 * time.Second
+	// dashboardSparklineLen is the number of P95 latencies, one per redraw, shown
+	// in the sparkline
+	dashboardSparklineLen = 60
+)
+
+// ANSI escape sequences used to draw the dashboard full-screen
+const (
+	ansiAltScreen     = "\x1b[?1049h"
+	ansiMainScreen    = "\x1b[?1049l"
+	ansiHideCursor    = "\x1b[?25l"
+	ansiShowCursor    = "\x1b[?25h"
+	ansiClearAndHome  = "\x1b[H\x1b[2J"
+	sparklineSymbols  = "▁▂▃▄▅▆▇█"
+	dashboardQuitHelp = "q: quit with summary"
+	dashboardKeysHelp = "p: pause/resume  +/-: concurrency  " + dashboardQuitHelp
+	// ctrlC is the key read, instead of an interrupt, when the terminal is in raw mode
+	ctrlC = 0x03
+)
+
+// IsTerminal reports whether 'f' is a terminal, e.g., whether the dashboard can be
+// drawn on it
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Dashboard is a full-screen terminal display of the run while it's in progress. It
+// shows the request rate, error rate, and latency percentiles of the most recent
+// responses, overall and by endpoint, and a sparkline of the P95 latency over time.
+// It's redrawn by the ResponseHandler using the same summary as the rolling summaries.
+type Dashboard struct {
+	out  io.Writer
+	quit func()
+	// concurrency, if set, is paused and changed by the keys read
+	concurrency *AdaptiveConcurrency
+	// p95s are the most recent P95 latencies, oldest first, drawn as a sparkline
+	p95s []time.Duration
+	// mu protects 'open' and 'restore'
+	mu   sync.Mutex
+	open bool
+	// restore, if set, restores the terminal 'in' was read from to the mode it was
+	// in before it was put in raw mode
+	restore func()
+}
+
+// NewDashboard returns a Dashboard drawn on 'out'. If 'in' isn't nil it's read for
+// keys, a terminal is put in raw mode so they're read as they're pressed: 'q', or
+// Ctrl-C, calls 'quit', which is expected to end the run, after which the run's
+// summary is reported as usual. If 'concurrency' is set 'p' pauses, and resumes, the
+// requests, and '+' and '-' raise and lower the concurrency.
+func NewDashboard(out io.Writer, in io.Reader, quit func(), concurrency *AdaptiveConcurrency) *Dashboard {
+	d := &Dashboard{out: out, quit: quit, concurrency: concurrency}
+	if in == nil {
+		return d
+	}
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		state, err := term.MakeRaw(int(f.Fd()))
+		if err != nil {
+			log.Warn().Err(err).Msg("heyyall: unable to read the dashboard's keys as they're pressed, enter them followed by Enter")
+		} else {
+			d.restore = func() { term.Restore(int(f.Fd()), state) }
+		}
+	}
+	go d.readKeys(in)
+	return d
+}
+
+// readKeys reads keys from 'in' until it's closed or the run is quit
+func (d *Dashboard) readKeys(in io.Reader) {
+	r := bufio.NewReader(in)
+	for {
+		key, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch key {
+		case 'q', ctrlC:
+			if d.quit != nil {
+				d.quit()
+			}
+			return
+		case 'p':
+			if d.concurrency != nil {
+				_, paused := d.concurrency.state()
+				d.concurrency.setPaused(!paused)
+			}
+		case '+', '=':
+			if d.concurrency != nil {
+				d.concurrency.changeLimit(time.Now(), true)
+			}
+		case '-':
+			if d.concurrency != nil {
+				d.concurrency.changeLimit(time.Now(), false)
+			}
+		}
+	}
+}
+
+// update redraws the dashboard with 'window', the summary of the responses received
+// during the most recent dashboardWindow. 'elapsed' is how long the run has been
+// running and 'totalRqsts' is the number of responses received so far.
+func (d *Dashboard) update(elapsed time.Duration, totalRqsts int, window api.RunResults) {
+	d.p95s = append(d.p95s, calcPercentiles(95, window.RunSummary.RqstStats.TimingResultsNanos))
+	if len(d.p95s) > dashboardSparklineLen {
+		d.p95s = d.p95s[len(d.p95s)-dashboardSparklineLen:]
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.open {
+		fmt.Fprint(d.out, ansiAltScreen+ansiHideCursor)
+		d.open = true
+	}
+	// A terminal in raw mode doesn't return the cursor to the start of the line at
+	// a newline
+	fmt.Fprint(d.out, ansiClearAndHome+strings.ReplaceAll(d.frame(elapsed, totalRqsts, window), "\n", "\r\n"))
+}
+
+// Close restores the terminal so the run's summary can be written to it
+func (d *Dashboard) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.open {
+		fmt.Fprint(d.out, ansiShowCursor+ansiMainScreen)
+		d.open = false
+	}
+	if d.restore != nil {
+		d.restore()
+		d.restore = nil
+	}
+}
+
+// frame returns the text of the dashboard
+func (d *Dashboard) frame(elapsed time.Duration, totalRqsts int, window api.RunResults) string {
+	var b strings.Builder
+	stats := newSummaryLineStats(window.RunSummary)
+	rs := window.RunSummary.RqstStats.TimingResultsNanos
+
+	help := dashboardQuitHelp
+	if d.concurrency != nil {
+		help = dashboardKeysHelp
+	}
+	fmt.Fprintf(&b, "heyyall  elapsed %s  responses %d    %s\n", elapsed.Round(time.Second), totalRqsts, help)
+	if d.concurrency != nil {
+		limit, paused := d.concurrency.state()
+		concurrency := "as configured"
+		if limit > 0 {
+			concurrency = fmt.Sprintf("limited to %d", limit)
+		}
+		state := "running"
+		if paused {
+			state = "PAUSED"
+		}
+		fmt.Fprintf(&b, "Requests %s, concurrency %s\n", state, concurrency)
+	}
+	fmt.Fprintln(&b)
+	fmt.Fprintf(&b, "Last %s:  %.1f rps  errors %s\n", dashboardWindow, stats.rate, formatPercent(stats.errorRatio))
+	fmt.Fprintf(&b, "Latency (secs):  P50 %s  P95 %s  P99 %s\n", formatPercentile(50, rs), formatPercentile(95, rs),
+		formatPercentile(99, rs))
+	fmt.Fprintf(&b, "P95 trend:  %s\n\n", sparkline(d.p95s))
+
+	fmt.Fprintf(&b, "%-48s %9s %9s %8s %8s %8s %8s\n", "Endpoint", "Rqsts", "Rps", "Errors", "P50", "P95", "P99")
+	urls := make([]string, 0, len(window.EndpointDetails))
+	for url := range window.EndpointDetails {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		epDetail := window.EndpointDetails[url]
+		var durations []time.Duration
+		for _, stats := range epDetail.HTTPMethodRqstStats {
+			durations = append(durations, stats.TimingResultsNanos...)
+		}
+		var errs int64
+		for _, count := range epDetail.ErrorCategories {
+			errs += count
+		}
+		var errRatio float64
+		if total := int64(len(durations)) + errs; total > 0 {
+			errRatio = float64(errs) / float64(total)
+		}
+		fmt.Fprintf(&b, "%-48s %9d %9.1f %8s %8s %8s %8s\n", truncateLabel(url, 48), len(durations),
+			float64(len(durations))/window.RunSummary.RunDurationNanos.Seconds(), formatPercent(errRatio),
+			formatPercentile(50, durations), formatPercentile(95, durations), formatPercentile(99, durations))
+	}
+	return b.String()
+}
+
+// sparkline draws 'values' using block characters scaled to the largest value
+func sparkline(values []time.Duration) string {
+	var max time.Duration
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	symbols := []rune(sparklineSymbols)
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if max > 0 {
+			i = int(int64(len(symbols)-1) * int64(v) / int64(max))
+		}
+		b.WriteRune(symbols[i])
+	}
+	return b.String()
+}
+
+// truncateLabel shortens 'label', if it's longer than 'n' characters, keeping its end
+func truncateLabel(label string, n int) string {
+	if len(label) <= n {
+		return label
+	}
+	return "..." + label[len(label)-(n-3):]
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestDashboard verifies the dashboard summarizes the most recent responses, overall
+// and by endpoint, that it's drawn full-screen and the terminal is restored when
+// it's closed, that its keys pause and change the concurrency, and that the run's
+// summary is still written after it's closed
+func TestDashboard(t *testing.T) {
+	var out bytes.Buffer
+	quit := make(chan struct{})
+	concurrency := NewManualConcurrency()
+	concurrency.peak = 10
+	dashboard := NewDashboard(&out, strings.NewReader("xp--+q"), func() { close(quit) }, concurrency)
+	select {
+	case <-quit:
+	case <-time.After(time.Second):
+		t.Fatalf("expected pressing 'q' to quit")
+	}
+	if limit, paused := concurrency.state(); limit != 9 || !paused {
+		t.Errorf("expected the requests to be paused with a concurrency of 9, got %d and %t", limit, paused)
+	}
+
+	responseC := make(chan Response, 10)
+	doneC := make(chan interface{})
+	rh := &ResponseHandler{
+		ResponseC:  responseC,
+		DoneC:      doneC,
+		OutputType: OneLine,
+		Output:     &out,
+		Dashboard:  dashboard,
+	}
+	go rh.Start()
+
+	for i := 0; i < 4; i++ {
+		responseC <- Response{HTTPStatus: http.StatusOK, RequestDuration: time.Duration(i+1) * 10 * time.Millisecond,
+			Endpoint: api.Endpoint{URL: "http://accountd.kube/users", Method: http.MethodGet}}
+	}
+	responseC <- Response{ErrCategory: api.ErrCategoryConnection,
+		Endpoint: api.Endpoint{URL: "http://accountd.kube/reports", Method: http.MethodGet}}
+	// Wait for the dashboard to be drawn
+	time.Sleep(dashboardInterval + 200*time.Millisecond)
+	close(responseC)
+	<-doneC
+
+	got := out.String()
+	for _, expected := range []string{
+		ansiAltScreen,
+		"responses 5    " + dashboardKeysHelp + "\r\n",
+		"Requests PAUSED, concurrency limited to 9",
+		"errors 20.00%",
+		"P50 0.0250  P95 0.0400  P99 0.0400",
+		"http://accountd.kube/users",
+		"http://accountd.kube/reports",
+		ansiMainScreen,
+		"heyyall: 4 rqsts",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("expected the output to contain %q, got %q", expected, got)
+		}
+	}
+	if strings.Index(got, ansiMainScreen) > strings.Index(got, "heyyall: 4 rqsts") {
+		t.Errorf("expected the summary to be written after the dashboard was closed, got %q", got)
+	}
+}
+
+// TestSparkline verifies values are drawn scaled to the largest value
+func TestSparkline(t *testing.T) {
+	got := sparkline([]time.Duration{0, 10 * time.Millisecond, 35 * time.Millisecond, 70 * time.Millisecond})
+	if expected := "▁▂▄█"; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if got := sparkline(nil); got != "" {
+		t.Errorf("expected an empty sparkline, got %q", got)
+	}
+}
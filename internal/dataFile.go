@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// DataSet is the rows of a CSV data file used to populate the endpoint templates.
+// Each request uses the next row, or a random row, depending on the DataFileOrder.
+// A single instance is shared by all requestor goroutines so that successive
+// requests use successive rows regardless of concurrency.
+type DataSet struct {
+	rows   []map[string]string
+	random bool
+	// next is the index of the next row used, modulo the number of rows. It's only
+	// accessed atomically.
+	next int64
+}
+
+// LoadDataSet reads the CSV file 'path'. Its first row names the columns, which are
+// the template variables, and each of the remaining rows is the data for a request.
+// 'order' is one of the api.DataFileOrder... constants, api.DataFileSequential if
+// it's empty.
+func LoadDataSet(path, order string) (*DataSet, error) {
+	d := DataSet{}
+	switch order {
+	case "", api.DataFileSequential:
+	case api.DataFileRandom:
+		d.random = true
+	default:
+		return nil, fmt.Errorf("DataFileOrder must be '%s' or '%s', not '%s'", api.DataFileSequential, api.DataFileRandom, order)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the DataFile: %w", err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the DataFile %s: %w", path, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("the DataFile %s must have a header row naming its columns and at least 1 row of data", path)
+	}
+
+	header := records[0]
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			return nil, fmt.Errorf("the DataFile %s column names must be unique and not empty, column %d is %q", path, i+1, name)
+		}
+		seen[name] = true
+		header[i] = name
+	}
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			row[name] = record[i]
+		}
+		d.rows = append(d.rows, row)
+	}
+	return &d, nil
+}
+
+// Next returns the row used for the next request, keyed by column name. Sequential
+// data sets cycle through their rows, starting over after the last one.
+func (d *DataSet) Next() map[string]string {
+	_, row := d.nextRow()
+	return row
+}
+
+// nextRow returns the index, in the DataFile's data rows, and the values of the row used
+// for the next request
+func (d *DataSet) nextRow() (int, map[string]string) {
+	i := 0
+	if d.random {
+		i = rand.Intn(len(d.rows))
+	} else {
+		i = int((atomic.AddInt64(&d.next, 1) - 1) % int64(len(d.rows)))
+	}
+	return i, d.rows[i]
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// writeDataFile writes 'contents' to a data file in 'dir' and returns its path
+func writeDataFile(t *testing.T, dir, contents string) string {
+	path := filepath.Join(dir, "users.csv")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("unable to write the data file: %s", err)
+	}
+	return path
+}
+
+// TestDataFile verifies successive requests are populated with successive rows of
+// the data file, starting over after the last row
+func TestDataFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dataFile")
+	if err != nil {
+		t.Fatalf("unable to create a temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var got []string
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		got = append(got, r.URL.Path+" "+r.Header.Get("X-User")+" "+string(body))
+	}))
+	defer testSrv.Close()
+
+	path := writeDataFile(t, dir, "id,username\n1,alice\n2,bob\n3,\"carol, jr\"\n")
+	data, err := LoadDataSet(path, "")
+	if err != nil {
+		t.Fatalf("unexpected error loading the data file: %s", err)
+	}
+
+	ep := api.Endpoint{
+		URL:      testSrv.URL + "/users/{{ .id }}",
+		Method:   http.MethodPut,
+		RqstBody: `{"username": "{{ .username }}"}`,
+		Headers:  map[string]string{"X-User": "{{ .username }}"},
+	}
+	respC := make(chan Response, 4)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    http.Client{},
+		Data:      data,
+	}
+	rqstr.ProcessRqst(ep, 4, 0)
+	close(respC)
+
+	expected := []string{
+		`/users/1 alice {"username": "alice"}`,
+		`/users/2 bob {"username": "bob"}`,
+		`/users/3 carol, jr {"username": "carol, jr"}`,
+		`/users/1 alice {"username": "alice"}`,
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected requests %q, got %q", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected request %d to be %q, got %q", i+1, expected[i], got[i])
+		}
+	}
+}
+
+// TestDataFileRandom verifies random rows are chosen from the data file
+func TestDataFileRandom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dataFile")
+	if err != nil {
+		t.Fatalf("unable to create a temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := LoadDataSet(writeDataFile(t, dir, "id\n1\n2\n3\n"), api.DataFileRandom)
+	if err != nil {
+		t.Fatalf("unexpected error loading the data file: %s", err)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		seen[data.Next()["id"]] = true
+	}
+	if len(seen) != 3 || !seen["1"] || !seen["2"] || !seen["3"] {
+		t.Errorf("expected rows 1, 2, and 3 to be chosen, got %v", seen)
+	}
+}
+
+// TestLoadDataSetErrors verifies malformed data files are rejected
+func TestLoadDataSetErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dataFile")
+	if err != nil {
+		t.Fatalf("unable to create a temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		contents string
+		order    string
+	}{
+		{name: "Empty", contents: ""},
+		{name: "HeaderOnly", contents: "id,username\n"},
+		{name: "DuplicateColumn", contents: "id,id\n1,2\n"},
+		{name: "EmptyColumn", contents: "id,\n1,2\n"},
+		{name: "RaggedRow", contents: "id,username\n1\n"},
+		{name: "InvalidOrder", contents: "id\n1\n", order: "shuffled"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := LoadDataSet(writeDataFile(t, dir, tc.contents), tc.order); err == nil {
+				t.Errorf("expected an error loading %q", tc.contents)
+			}
+		})
+	}
+	if _, err := LoadDataSet(filepath.Join(dir, "missing.csv"), ""); err == nil {
+		t.Errorf("expected an error loading a missing data file")
+	}
+}
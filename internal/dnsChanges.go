@@ -0,0 +1,138 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+const (
+	// maxDNSTrackedHosts bounds the number of hosts whose IPs are tracked
+	maxDNSTrackedHosts = 100
+	// maxDNSHostIPs bounds the number of IPs tracked per host in a single interval
+	maxDNSHostIPs = 16
+	// maxDNSChanges bounds the number of changes reported in RunSummary.DNSChanges
+	maxDNSChanges = 100
+)
+
+// remoteIP returns the IP address of 'addr', the remote address of a connection. It
+// returns an empty string if 'addr' isn't an IP address, e.g., for a Unix domain socket.
+func remoteIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	return ""
+}
+
+// urlHost returns the host of 'rawURL' without its port
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// hostIPs are the IPs observed for a single host
+type hostIPs struct {
+	// prev are the sorted IPs observed during the most recent interval, before
+	// 'interval', in which the host had responses
+	prev []string
+	// cur are the IPs observed during 'interval'
+	cur map[string]struct{}
+	// interval is the index of the time series interval 'cur' was observed in
+	interval int
+}
+
+// dnsChangeTracker detects when the set of IPs a host's responses came from changes
+// during a run, e.g., because of a DNS based failover. The IPs observed for a host
+// during each time series interval are compared to those observed during the
+// previous interval with responses from that host. Only the IPs of the latest two
+// intervals are retained for each host.
+type dnsChangeTracker struct {
+	hosts map[string]*hostIPs
+	// intervalLen is the length of the time series intervals
+	intervalLen time.Duration
+}
+
+// add records that a response from 'host' completed during the time series interval
+// 'interval', of length 'intervalLen', on a connection to 'ip'. Responses must be
+// added in the order they completed.
+func (t *dnsChangeTracker) add(host, ip string, interval int, intervalLen time.Duration, runSummary *api.RunSummary) {
+	if t.hosts == nil {
+		t.hosts = make(map[string]*hostIPs)
+	}
+	t.intervalLen = intervalLen
+	state, ok := t.hosts[host]
+	if !ok {
+		if len(t.hosts) >= maxDNSTrackedHosts {
+			return
+		}
+		state = &hostIPs{cur: make(map[string]struct{}), interval: interval}
+		t.hosts[host] = state
+	}
+	if interval != state.interval {
+		t.endInterval(host, state, runSummary)
+		state.interval = interval
+	}
+	if len(state.cur) < maxDNSHostIPs {
+		state.cur[ip] = struct{}{}
+	}
+}
+
+// endInterval compares the IPs observed for 'host' during its current interval to
+// those of its previous interval, recording a change in 'runSummary' if they differ
+func (t *dnsChangeTracker) endInterval(host string, state *hostIPs, runSummary *api.RunSummary) {
+	if len(state.cur) == 0 {
+		return
+	}
+	ips := make([]string, 0, len(state.cur))
+	for ip := range state.cur {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	if state.prev != nil && strings.Join(ips, ",") != strings.Join(state.prev, ",") &&
+		len(runSummary.DNSChanges) < maxDNSChanges {
+		runSummary.DNSChanges = append(runSummary.DNSChanges, api.DNSChange{
+			Host:        host,
+			OffsetNanos: time.Duration(state.interval) * t.intervalLen,
+			OldIPs:      state.prev,
+			NewIPs:      ips,
+		})
+	}
+	state.prev = ips
+	state.cur = make(map[string]struct{})
+}
+
+// finish ends the current interval of every host, sorts the changes recorded in
+// 'runSummary' by when they occurred, and marks the time series intervals they
+// occurred in
+func (t *dnsChangeTracker) finish(runSummary *api.RunSummary) {
+	for host, state := range t.hosts {
+		t.endInterval(host, state, runSummary)
+	}
+	for _, change := range runSummary.DNSChanges {
+		if i := int(change.OffsetNanos / t.intervalLen); i < len(runSummary.TimeSeries) {
+			runSummary.TimeSeries[i].DNSChanged = true
+		}
+	}
+	sort.Slice(runSummary.DNSChanges, func(i, j int) bool {
+		ci, cj := runSummary.DNSChanges[i], runSummary.DNSChanges[j]
+		if ci.OffsetNanos != cj.OffsetNanos {
+			return ci.OffsetNanos < cj.OffsetNanos
+		}
+		return ci.Host < cj.Host
+	})
+}
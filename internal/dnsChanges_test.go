@@ -0,0 +1,120 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestDNSChanges verifies that a change in the IPs a host's responses came from is
+// reported, and the time series interval in which it occurred is marked, when the
+// dialer switches from one IP to another mid-run as it would during a DNS failover.
+func TestDNSChanges(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	primary := httptest.NewServer(handler)
+	defer primary.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("unable to listen on a second loopback IP: %s", err)
+	}
+	failover := httptest.NewUnstartedServer(handler)
+	failover.Listener.Close()
+	failover.Listener = l
+	failover.Start()
+	defer failover.Close()
+
+	// The first 'numPrimary' connections go to the primary server, the rest fail
+	// over to the second server
+	numRqsts, numPrimary := 6, int32(3)
+	var dials int32
+	dialer := &net.Dialer{}
+	client := http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			if atomic.AddInt32(&dials, 1) <= numPrimary {
+				return dialer.DialContext(ctx, network, primary.Listener.Addr().String())
+			}
+			return dialer.DialContext(ctx, network, failover.Listener.Addr().String())
+		},
+	}}
+
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    client,
+	}
+	rqstr.ProcessRqst(api.Endpoint{URL: "http://someservice.test/", Method: http.MethodGet, RqstPercent: 100}, numRqsts, 0)
+	close(respC)
+
+	// Each response completes in its own time series interval
+	rh := ResponseHandler{start: time.Now()}
+	var responses []Response
+	for resp := range respC {
+		if resp.Host != "someservice.test" {
+			t.Errorf("expected host someservice.test, got %s", resp.Host)
+		}
+		resp.Completed = rh.start.Add(time.Duration(len(responses)) * time.Second)
+		responses = append(responses, resp)
+	}
+	if len(responses) != numRqsts {
+		t.Fatalf("expected %d responses, got %d", numRqsts, len(responses))
+	}
+
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	expected := []api.DNSChange{{
+		Host:        "someservice.test",
+		OffsetNanos: time.Duration(numPrimary) * time.Second,
+		OldIPs:      []string{"127.0.0.1"},
+		NewIPs:      []string{"127.0.0.2"},
+	}}
+	if !reflect.DeepEqual(runResults.RunSummary.DNSChanges, expected) {
+		t.Errorf("expected DNS changes %+v, got %+v", expected, runResults.RunSummary.DNSChanges)
+	}
+	for i, sample := range runResults.RunSummary.TimeSeries {
+		if sample.DNSChanged != (i == int(numPrimary)) {
+			t.Errorf("expected DNSChanged to be %t for interval %d, got %t", i == int(numPrimary), i, sample.DNSChanged)
+		}
+	}
+}
+
+// TestDNSChangeTrackerBounds verifies that the number of hosts tracked and changes
+// reported are bounded.
+func TestDNSChangeTrackerBounds(t *testing.T) {
+	tracker := dnsChangeTracker{}
+	runSummary := api.RunSummary{TimeSeriesIntervalNanos: time.Second}
+
+	for i := 0; i < maxDNSTrackedHosts+10; i++ {
+		tracker.add(fmt.Sprintf("host%d", i), "10.0.0.1", 0, time.Second, &runSummary)
+	}
+	if len(tracker.hosts) != maxDNSTrackedHosts {
+		t.Errorf("expected %d hosts to be tracked, got %d", maxDNSTrackedHosts, len(tracker.hosts))
+	}
+
+	// The IPs of 'host0' alternate every interval
+	for i := 1; i < 2*maxDNSChanges; i++ {
+		tracker.add("host0", fmt.Sprintf("10.0.0.%d", i%2+1), i, time.Second, &runSummary)
+	}
+	tracker.finish(&runSummary)
+	if len(runSummary.DNSChanges) != maxDNSChanges {
+		t.Errorf("expected %d DNS changes, got %d", maxDNSChanges, len(runSummary.DNSChanges))
+	}
+}
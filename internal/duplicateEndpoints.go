@@ -0,0 +1,100 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// DuplicateEndpoints are the indexes, in a config's Endpoints, of the endpoints with
+// the same Method and URL
+type DuplicateEndpoints struct {
+	Method  string
+	URL     string
+	Indexes []int
+}
+
+// DuplicateEndpointsError is returned by ResolveDuplicateEndpoints for a config whose
+// Endpoints have duplicates that aren't allowed
+type DuplicateEndpointsError struct {
+	Duplicates []DuplicateEndpoints
+}
+
+func (e *DuplicateEndpointsError) Error() string {
+	dups := make([]string, 0, len(e.Duplicates))
+	for _, d := range e.Duplicates {
+		indexes := make([]string, 0, len(d.Indexes))
+		for _, i := range d.Indexes {
+			indexes = append(indexes, fmt.Sprint(i))
+		}
+		dups = append(dups, fmt.Sprintf("endpoints %s are all %s %s", strings.Join(indexes, ", "), d.Method, d.URL))
+	}
+	return fmt.Sprintf("the config has duplicate endpoints, %s. Remove the duplicates, or set AllowDuplicateEndpoints to report each of them separately",
+		strings.Join(dups, "; "))
+}
+
+// findDuplicateEndpoints returns the endpoints of 'eps' with the same Method and URL,
+// in the order of their first occurrence. Keep-alive probes aren't part of the load
+// and aren't considered.
+func findDuplicateEndpoints(eps []api.Endpoint) []DuplicateEndpoints {
+	type key struct{ method, url string }
+	indexes := make(map[key][]int)
+	var order []key
+	for i, ep := range eps {
+		if ep.KeepAliveProbe != nil {
+			continue
+		}
+		k := key{method: strings.ToUpper(ep.Method), url: ep.URL}
+		if _, ok := indexes[k]; !ok {
+			order = append(order, k)
+		}
+		indexes[k] = append(indexes[k], i)
+	}
+
+	var dups []DuplicateEndpoints
+	for _, k := range order {
+		if len(indexes[k]) > 1 {
+			dups = append(dups, DuplicateEndpoints{Method: k.method, URL: k.url, Indexes: indexes[k]})
+		}
+	}
+	return dups
+}
+
+// ResolveDuplicateEndpoints returns a *DuplicateEndpointsError if more than one of
+// 'config's Endpoints have the same Method and URL, unless the config's
+// AllowDuplicateEndpoints is set. If it is, each of the duplicates without a Name is
+// given one, 'endpoints[i]', and is labelled with its Name so its results are reported
+// separately.
+func ResolveDuplicateEndpoints(config *api.LoadTestConfig) error {
+	dups := findDuplicateEndpoints(config.Endpoints)
+	if len(dups) == 0 {
+		return nil
+	}
+	if !config.AllowDuplicateEndpoints {
+		return &DuplicateEndpointsError{Duplicates: dups}
+	}
+	for _, d := range dups {
+		for _, i := range d.Indexes {
+			ep := &config.Endpoints[i]
+			if ep.Name == "" {
+				ep.Name = fmt.Sprintf("endpoints[%d]", i)
+			}
+			ep.SummaryLabel = ep.Name
+		}
+	}
+	return nil
+}
+
+// summaryURL returns the URL the results of 'ep's request to 'rqstURL' are reported
+// under, 'rqstURL#SummaryLabel' if 'ep' has a SummaryLabel
+func summaryURL(ep api.Endpoint, rqstURL string) string {
+	if ep.SummaryLabel == "" {
+		return rqstURL
+	}
+	return rqstURL + "#" + ep.SummaryLabel
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestResolveDuplicateEndpoints verifies endpoints with the same Method and URL are an
+// error listing their indexes unless they're allowed, in which case each is named and
+// labelled so their results are reported separately
+func TestResolveDuplicateEndpoints(t *testing.T) {
+	eps := []api.Endpoint{
+		{URL: "http://someservice.test/users", Method: "POST", RqstBody: `{"name": "a"}`},
+		{URL: "http://someservice.test/users", Method: "GET"},
+		{Name: "createB", URL: "http://someservice.test/users", Method: "post", RqstBody: `{"name": "b"}`},
+		{URL: "http://someservice.test/orders", Method: "GET"},
+		{URL: "http://someservice.test/orders", Method: "GET"},
+		{URL: "http://someservice.test/users", Method: "GET", KeepAliveProbe: &api.KeepAliveProbe{}},
+	}
+
+	config := api.LoadTestConfig{Endpoints: append([]api.Endpoint(nil), eps...)}
+	err := ResolveDuplicateEndpoints(&config)
+	var dupErr *DuplicateEndpointsError
+	if !errors.As(err, &dupErr) {
+		t.Fatalf("expected a DuplicateEndpointsError, got %v", err)
+	}
+	expected := []DuplicateEndpoints{
+		{Method: "POST", URL: "http://someservice.test/users", Indexes: []int{0, 2}},
+		{Method: "GET", URL: "http://someservice.test/orders", Indexes: []int{3, 4}},
+	}
+	if !reflect.DeepEqual(dupErr.Duplicates, expected) {
+		t.Errorf("expected the duplicates %+v, got %+v", expected, dupErr.Duplicates)
+	}
+	expectedMsg := "the config has duplicate endpoints, endpoints 0, 2 are all POST http://someservice.test/users; " +
+		"endpoints 3, 4 are all GET http://someservice.test/orders. " +
+		"Remove the duplicates, or set AllowDuplicateEndpoints to report each of them separately"
+	if err.Error() != expectedMsg {
+		t.Errorf("expected the error %q, got %q", expectedMsg, err.Error())
+	}
+
+	config = api.LoadTestConfig{Endpoints: append([]api.Endpoint(nil), eps...), AllowDuplicateEndpoints: true}
+	if err = ResolveDuplicateEndpoints(&config); err != nil {
+		t.Fatalf("unexpected error resolving allowed duplicates: %s", err)
+	}
+	expectedNames := []string{"endpoints[0]", "", "createB", "endpoints[3]", "endpoints[4]", ""}
+	for i, ep := range config.Endpoints {
+		if ep.Name != expectedNames[i] || ep.SummaryLabel != expectedNames[i] {
+			t.Errorf("expected endpoint %d to be named and labelled %q, got %q and %q", i, expectedNames[i], ep.Name, ep.SummaryLabel)
+		}
+	}
+	if label := newRqstVariants(config.Endpoints[0], http.Client{})[0].label(eps[0].URL); label != "http://someservice.test/users#endpoints[0]" {
+		t.Errorf("expected the results of endpoint 0 to be reported as http://someservice.test/users#endpoints[0], got %s", label)
+	}
+	if label := newRqstVariants(config.Endpoints[1], http.Client{})[0].label(eps[1].URL); label != eps[1].URL {
+		t.Errorf("expected the results of endpoint 1 to be reported as %s, got %s", eps[1].URL, label)
+	}
+
+	config = api.LoadTestConfig{Endpoints: eps[1:4]}
+	if err = ResolveDuplicateEndpoints(&config); err != nil {
+		t.Errorf("unexpected error resolving a config without duplicates: %s", err)
+	}
+}
+
+// TestDuplicateEndpointsState verifies that each of the duplicates allowed by
+// AllowDuplicateEndpoints has its own rate limit and early fail state, rather than
+// sharing the state, and configuration, of the last of them
+func TestDuplicateEndpointsState(t *testing.T) {
+	threshold := 2
+	config := api.LoadTestConfig{
+		AllowDuplicateEndpoints: true,
+		Endpoints: []api.Endpoint{
+			{URL: "http://someservice.test/users", Method: "GET", RateLimit: 10, EarlyFailThreshold: &threshold},
+			{URL: "http://someservice.test/users", Method: "GET", RateLimit: 100},
+		},
+	}
+	if err := ResolveDuplicateEndpoints(&config); err != nil {
+		t.Fatalf("unexpected error resolving allowed duplicates: %s", err)
+	}
+	a, b := config.Endpoints[0], config.Endpoints[1]
+
+	rateLimits, err := NewRateLimits(config.Endpoints)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(rateLimits.buckets) != 2 {
+		t.Fatalf("expected a rate limit for each of the duplicates, got %d", len(rateLimits.buckets))
+	}
+	if interval := rateLimits.buckets[earlyFailKey(a)].interval; interval != 100*time.Millisecond {
+		t.Errorf("expected the first duplicate to allow a request every 100ms, got %s", interval)
+	}
+
+	tracker := NewEarlyFailTracker(false)
+	tracker.Record(a, false)
+	tracker.Record(b, false)
+	tracker.Record(a, false)
+	if !tracker.Failed(a) || tracker.Failed(b) {
+		t.Errorf("expected only the first duplicate to fail, got %t and %t", tracker.Failed(a), tracker.Failed(b))
+	}
+	if urls := tracker.FailedURLs(); len(urls) != 1 || urls[0] != "http://someservice.test/users#endpoints[0]" {
+		t.Errorf("expected the first duplicate to be reported as failed, got %v", urls)
+	}
+}
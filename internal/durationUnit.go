@@ -0,0 +1,153 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nanosSuffix is the suffix of the names of the report's duration fields, which are
+// time.Durations and so are encoded in nanoseconds
+const nanosSuffix = "Nanos"
+
+// DurationUnit is the unit the durations of the JSON reports are written in. Its
+// zero value leaves them in nanoseconds.
+type DurationUnit struct {
+	unit time.Duration
+	// suffix replaces nanosSuffix in the names of the duration fields
+	suffix string
+}
+
+// durationUnits are the DurationUnits by the name used to choose them
+var durationUnits = map[string]DurationUnit{
+	"ns": {},
+	"us": {unit: time.Microsecond, suffix: "Micros"},
+	"ms": {unit: time.Millisecond, suffix: "Millis"},
+	"s":  {unit: time.Second, suffix: "Secs"},
+}
+
+// ParseDurationUnit returns the DurationUnit named 'name', one of 'ns', the default,
+// 'us', 'ms', or 's'
+func ParseDurationUnit(name string) (DurationUnit, error) {
+	if name == "" {
+		return DurationUnit{}, nil
+	}
+	u, ok := durationUnits[name]
+	if !ok {
+		return DurationUnit{}, fmt.Errorf("the duration unit, %q, must be one of 'ns', 'us', 'ms', or 's'", name)
+	}
+	return u, nil
+}
+
+// marshal is json.Marshal writing the durations of 'v' in 'u'. Each duration field,
+// e.g., RunDurationNanos, is renamed for the unit, e.g., RunDurationMillis, and its
+// values are written as floats in the unit. Everything else, including the order of
+// the fields, is unchanged.
+func (u DurationUnit) marshal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil || u.unit == 0 {
+		return b, err
+	}
+	return u.convert(b, false)
+}
+
+// marshalIndent is json.MarshalIndent writing the durations of 'v' in 'u', see marshal
+func (u DurationUnit) marshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
+	b, err := u.marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err = json.Indent(&out, b, prefix, indent); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// convert converts the durations in the JSON value 'raw' to 'u'. 'nanos' indicates
+// 'raw' is itself a duration, or a map or array of them.
+func (u DurationUnit) convert(raw json.RawMessage, nanos bool) (json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return raw, nil
+	}
+	switch raw[0] {
+	case '{':
+		return u.convertObject(raw, nanos)
+	case '[':
+		var elems []json.RawMessage
+		if err := json.Unmarshal(raw, &elems); err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		out.WriteByte('[')
+		for i, elem := range elems {
+			converted, err := u.convert(elem, nanos)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			out.Write(converted)
+		}
+		out.WriteByte(']')
+		return out.Bytes(), nil
+	}
+	if !nanos || raw[0] == 'n' {
+		return raw, nil
+	}
+	d, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("the duration %s isn't an integer: %w", raw, err)
+	}
+	return []byte(strconv.FormatFloat(float64(d)/float64(u.unit), 'f', -1, 64)), nil
+}
+
+// convertObject converts the durations of the JSON object 'raw', keeping the order of
+// its fields
+func (u DurationUnit) convertObject(raw json.RawMessage, nanos bool) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for i := 0; dec.More(); i++ {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := t.(string)
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		fieldNanos := nanos
+		if strings.HasSuffix(key, nanosSuffix) {
+			key, fieldNanos = strings.TrimSuffix(key, nanosSuffix)+u.suffix, true
+		}
+		if value, err = u.convert(value, fieldNanos); err != nil {
+			return nil, err
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		out.Write(k)
+		out.WriteByte(':')
+		out.Write(value)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
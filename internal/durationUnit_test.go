@@ -0,0 +1,163 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestDurationFieldNames verifies every duration field of the report is named with
+// the nanosSuffix, and only they are, since that's how DurationUnit finds them
+func TestDurationFieldNames(t *testing.T) {
+	durationType := reflect.TypeOf(time.Duration(0))
+	seen := make(map[reflect.Type]bool)
+	var check func(typ reflect.Type, path string)
+	check = func(typ reflect.Type, path string) {
+		for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct || seen[typ] {
+			return
+		}
+		seen[typ] = true
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if f.Anonymous {
+				check(f.Type, path)
+				continue
+			}
+			elem := f.Type
+			for elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Slice || elem.Kind() == reflect.Map {
+				elem = elem.Elem()
+			}
+			if isDuration := elem == durationType; isDuration != strings.HasSuffix(f.Name, nanosSuffix) {
+				t.Errorf("%s.%s: expected a duration field to be named with the %s suffix, and only they are", path, f.Name, nanosSuffix)
+			}
+			check(f.Type, path+"."+f.Name)
+		}
+	}
+	check(reflect.TypeOf(api.SuiteResults{}), "SuiteResults")
+	check(reflect.TypeOf(api.RollingSummary{}), "RollingSummary")
+}
+
+// TestDurationUnit verifies every duration field of a run's results is written in
+// the configured unit and renamed for it
+func TestDurationUnit(t *testing.T) {
+	if _, err := ParseDurationUnit("minutes"); err == nil {
+		t.Errorf("expected an error for an unknown duration unit")
+	}
+
+	var responses []Response
+	start := time.Now()
+	for i := 1; i <= 10; i++ {
+		responses = append(responses, Response{
+			Endpoint:        api.Endpoint{URL: "http://someurl/items", Method: http.MethodGet},
+			HTTPStatus:      http.StatusOK,
+			RequestDuration: time.Duration(i) * 1500 * time.Microsecond,
+			TTFB:            time.Duration(i) * time.Millisecond,
+			Completed:       start.Add(time.Duration(i) * 10 * time.Millisecond),
+		})
+	}
+	rh := &ResponseHandler{start: start}
+	runResults, err := rh.summarize(responses, start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	for _, tc := range []struct {
+		unit   string
+		suffix string
+		scale  float64
+	}{
+		{unit: "us", suffix: "Micros", scale: float64(time.Microsecond)},
+		{unit: "ms", suffix: "Millis", scale: float64(time.Millisecond)},
+		{unit: "s", suffix: "Secs", scale: float64(time.Second)},
+	} {
+		t.Run(tc.unit, func(t *testing.T) {
+			u, err := ParseDurationUnit(tc.unit)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			b, err := u.marshalIndent(runResults, "", "  ")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			nanos, err := DurationUnit{}.marshal(runResults)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var converted, original interface{}
+			if err = json.Unmarshal(b, &converted); err != nil {
+				t.Fatalf("unable to decode %s: %s", b, err)
+			}
+			json.Unmarshal(nanos, &original)
+
+			n := compareDurations(t, "RunResults", original, converted, tc.suffix, tc.scale, false)
+			if n == 0 {
+				t.Errorf("expected the results to have durations")
+			}
+		})
+	}
+}
+
+// compareDurations verifies the 'converted' JSON value is 'original' with its
+// durations divided by 'scale' and renamed with 'suffix'. It returns the number of
+// durations compared.
+func compareDurations(t *testing.T, path string, original, converted interface{}, suffix string, scale float64, nanos bool) int {
+	switch o := original.(type) {
+	case map[string]interface{}:
+		c, ok := converted.(map[string]interface{})
+		if !ok || len(c) != len(o) {
+			t.Errorf("%s: expected an object with %d fields, got %v", path, len(o), converted)
+			return 0
+		}
+		n := 0
+		for k, v := range o {
+			ck, fieldNanos := k, nanos
+			if strings.HasSuffix(k, nanosSuffix) {
+				ck, fieldNanos = strings.TrimSuffix(k, nanosSuffix)+suffix, true
+			}
+			if strings.HasSuffix(ck, nanosSuffix) {
+				t.Errorf("%s.%s: expected the field to be renamed", path, ck)
+			}
+			n += compareDurations(t, path+"."+ck, v, c[ck], suffix, scale, fieldNanos)
+		}
+		return n
+	case []interface{}:
+		c, ok := converted.([]interface{})
+		if !ok || len(c) != len(o) {
+			t.Errorf("%s: expected an array of %d elements, got %v", path, len(o), converted)
+			return 0
+		}
+		n := 0
+		for i := range o {
+			n += compareDurations(t, path, o[i], c[i], suffix, scale, nanos)
+		}
+		return n
+	case float64:
+		if !nanos {
+			if converted != original {
+				t.Errorf("%s: expected %v to be unchanged, got %v", path, original, converted)
+			}
+			return 0
+		}
+		if c, ok := converted.(float64); !ok || math.Abs(c-o/scale) > 1e-9*math.Abs(o/scale) {
+			t.Errorf("%s: expected %v, got %v", path, o/scale, converted)
+		}
+		return 1
+	}
+	if !reflect.DeepEqual(original, converted) {
+		t.Errorf("%s: expected %v to be unchanged, got %v", path, original, converted)
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// earlyFailState tracks the first responses for a single endpoint
+type earlyFailState struct {
+	// url is the URL the endpoint's results are reported under
+	url    string
+	method string
+	// threshold is the number of responses that must all fail for the endpoint
+	// to be failed
+	threshold int
+	// rqsts is the number of responses recorded so far, up to 'threshold'
+	rqsts int
+	// decided is true once the endpoint has either failed or has had a
+	// successful response within its first 'threshold' responses
+	decided bool
+	// failed is true if the first 'threshold' responses all failed
+	failed bool
+}
+
+// EarlyFailTracker detects endpoints whose first responses are all failures, e.g.,
+// due to a bad auth header causing every request to return a 401, so requests to
+// them can stop at the start of a run rather than continuing for the entire run.
+// It's shared by all requestors.
+type EarlyFailTracker struct {
+	// AbortRun ends the entire run, rather than only stopping requests to the
+	// failed endpoint, when an endpoint fails
+	AbortRun bool
+	mux      sync.Mutex
+	// eps is the state of each endpoint keyed by earlyFailKey
+	eps map[string]*earlyFailState
+}
+
+// NewEarlyFailTracker returns an EarlyFailTracker. If 'abortRun' is true the entire
+// run is ended when an endpoint fails.
+func NewEarlyFailTracker(abortRun bool) *EarlyFailTracker {
+	return &EarlyFailTracker{AbortRun: abortRun, eps: make(map[string]*earlyFailState)}
+}
+
+// earlyFailKey identifies 'ep' in EarlyFailTracker.eps. The duplicates allowed by
+// LoadTestConfig.AllowDuplicateEndpoints are told apart by their SummaryLabel, so
+// each has its own state and configuration.
+func earlyFailKey(ep api.Endpoint) string {
+	return ep.Method + " " + summaryURL(ep, ep.URL)
+}
+
+// earlyFailThreshold returns the EarlyFailThreshold configured for 'ep'
+func earlyFailThreshold(ep api.Endpoint) int {
+	if ep.EarlyFailThreshold == nil {
+		return api.DefaultEarlyFailThreshold
+	}
+	return *ep.EarlyFailThreshold
+}
+
+// Record records the outcome of a response from 'ep'. It returns true if the endpoint
+// has failed, i.e., its first EarlyFailThreshold responses were all failures, and no
+// more requests should be made to it.
+func (t *EarlyFailTracker) Record(ep api.Endpoint, success bool) bool {
+	threshold := earlyFailThreshold(ep)
+	if threshold == 0 {
+		return false
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	key := earlyFailKey(ep)
+	state, ok := t.eps[key]
+	if !ok {
+		state = &earlyFailState{url: summaryURL(ep, ep.URL), method: ep.Method, threshold: threshold}
+		t.eps[key] = state
+	}
+	if state.decided {
+		return state.failed
+	}
+
+	state.rqsts++
+	switch {
+	case success:
+		state.decided = true
+	case state.rqsts >= state.threshold:
+		state.decided = true
+		state.failed = true
+	}
+	return state.failed
+}
+
+// Failed reports whether 'ep' has failed
+func (t *EarlyFailTracker) Failed(ep api.Endpoint) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	state, ok := t.eps[earlyFailKey(ep)]
+	return ok && state.failed
+}
+
+// Warnings returns a warning for each endpoint that failed, sorted by endpoint
+func (t *EarlyFailTracker) Warnings() []string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	var warnings []string
+	for _, state := range t.eps {
+		if state.failed {
+			warnings = append(warnings, fmt.Sprintf("%s %s: the first %d responses were all errors, no more requests were sent to it",
+				state.method, state.url, state.threshold))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+// FailedURLs returns the URLs of the endpoints that failed
+func (t *EarlyFailTracker) FailedURLs() []string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	var urls []string
+	for _, state := range t.eps {
+		if state.failed {
+			urls = append(urls, state.url)
+		}
+	}
+	sort.Strings(urls)
+	return urls
+}
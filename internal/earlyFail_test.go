@@ -0,0 +1,133 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+	"github.com/youngkin/heyyall/internal/stats"
+)
+
+// TestEarlyFail verifies that requests to an endpoint whose first responses are all
+// errors are stopped, or the entire run is ended, while requests to healthy endpoints
+// continue.
+func TestEarlyFail(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/unauthorized" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testSrv.Close()
+
+	disabled := 0
+	tests := []struct {
+		name              string
+		threshold         *int
+		abortRun          bool
+		expectedFailed    bool
+		expectedCancelled bool
+	}{
+		{name: "DefaultThreshold", expectedFailed: true},
+		{name: "AbortRun", abortRun: true, expectedFailed: true, expectedCancelled: true},
+		{name: "Disabled", threshold: &disabled},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			earlyFail := NewEarlyFailTracker(tc.abortRun)
+			rqstr := Requestor{
+				Ctx:       ctx,
+				ResponseC: make(chan Response, 10),
+				Client:    http.Client{},
+				Cancel:    cancel,
+				EarlyFail: earlyFail,
+			}
+			badEP := api.Endpoint{URL: testSrv.URL + "/unauthorized", Method: http.MethodGet, RqstPercent: 50,
+				EarlyFailThreshold: tc.threshold}
+			goodEP := api.Endpoint{URL: testSrv.URL + "/ok", Method: http.MethodGet, RqstPercent: 50}
+
+			concurrency, numRqsts := 4, 400
+			scheduler, err := NewScheduler(concurrency, 0, 0, numRqsts, []api.Endpoint{badEP, goodEP}, rqstr)
+			if err != nil {
+				t.Fatalf("unexpected error creating scheduler: %s", err)
+			}
+			go scheduler.Start()
+
+			numResps := make(map[string]int)
+			for resp := range rqstr.ResponseC {
+				numResps[resp.Endpoint.URL]++
+			}
+
+			if earlyFail.Failed(badEP) != tc.expectedFailed {
+				t.Errorf("expected endpoint failed to be %t, got %t", tc.expectedFailed, earlyFail.Failed(badEP))
+			}
+			if (ctx.Err() != nil) != tc.expectedCancelled {
+				t.Errorf("expected run cancelled to be %t, got %t", tc.expectedCancelled, ctx.Err() != nil)
+			}
+			// Each of the endpoint's requestors may have a request in flight when it fails
+			maxBadRqsts := api.DefaultEarlyFailThreshold + concurrency/2
+			if !tc.expectedFailed {
+				maxBadRqsts = numRqsts / 2
+			}
+			if numResps[badEP.URL] > maxBadRqsts || (!tc.expectedFailed && numResps[badEP.URL] != maxBadRqsts) {
+				t.Errorf("expected at most %d requests to the failing endpoint, got %d", maxBadRqsts, numResps[badEP.URL])
+			}
+			if !tc.expectedCancelled && numResps[goodEP.URL] != numRqsts/2 {
+				t.Errorf("expected %d requests to the healthy endpoint, got %d", numRqsts/2, numResps[goodEP.URL])
+			}
+			if !tc.expectedFailed && earlyFail.Failed(goodEP) {
+				t.Error("expected the healthy endpoint not to fail")
+			}
+		})
+	}
+}
+
+func TestEarlyFailRecord(t *testing.T) {
+	threshold := 3
+	ep := api.Endpoint{URL: "http://someurl/1", Method: http.MethodGet, EarlyFailThreshold: &threshold}
+
+	tracker := NewEarlyFailTracker(false)
+	if tracker.Record(ep, false) || tracker.Record(ep, true) || tracker.Record(ep, false) || tracker.Record(ep, false) {
+		t.Error("expected an endpoint with a successful response in its first responses not to fail")
+	}
+
+	tracker = NewEarlyFailTracker(false)
+	for i := 0; i < threshold-1; i++ {
+		if tracker.Record(ep, false) {
+			t.Fatalf("expected the endpoint not to fail after %d errors", i+1)
+		}
+	}
+	if !tracker.Record(ep, false) || !tracker.Record(ep, true) {
+		t.Error("expected the endpoint to fail, and remain failed, after its first 3 responses were errors")
+	}
+	warnings := tracker.Warnings()
+	if len(warnings) != 1 || warnings[0] != "GET http://someurl/1: the first 3 responses were all errors, no more requests were sent to it" {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+
+	rh := ResponseHandler{OutputType: JSON, EarlyFail: tracker}
+	runResults := api.RunResults{EndpointSummary: make(map[string]map[string]int)}
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	acc := stats.NewAccumulator(stats.Config{Start: time.Now()})
+	if err := rh.finalizeResponseStats(acc, &runResults, epRunSummary); err != nil {
+		t.Fatalf("unexpected error finalizing response stats: %s", err)
+	}
+	if !runResults.EndpointDetails[ep.URL].EarlyFailed {
+		t.Errorf("expected endpoint %s to be reported as failed early", ep.URL)
+	}
+	if !reflect.DeepEqual(runResults.RunSummary.Warnings, warnings) {
+		t.Errorf("expected warnings %v, got %v", warnings, runResults.RunSummary.Warnings)
+	}
+}
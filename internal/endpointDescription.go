@@ -0,0 +1,15 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import "github.com/youngkin/heyyall/api"
+
+// accumulateDescription carries the Description of the endpoint 'resp' was sent to,
+// if it has one, to 'epDetail'
+func accumulateDescription(resp Response, epDetail *api.EndpointDetail) {
+	if resp.Endpoint.Description != "" {
+		epDetail.Description = resp.Endpoint.Description
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestEndpointDescription verifies an endpoint's Description is carried to its
+// EndpointDetail and the text report, along with the run's disabled endpoints
+func TestEndpointDescription(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer testSrv.Close()
+
+	eps := []api.Endpoint{
+		{URL: testSrv.URL + "/items", Method: http.MethodGet, Description: "browses the catalog"},
+		{URL: testSrv.URL + "/fail", Method: http.MethodGet, Description: "always fails"},
+		{URL: testSrv.URL + "/health", Method: http.MethodGet},
+	}
+	respC := make(chan Response, 3*len(eps))
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}}
+	for _, ep := range eps {
+		rqstr.ProcessRqst(ep, 3, 0)
+	}
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	rh := &ResponseHandler{start: time.Now(), DisabledEndpoints: []string{"login", "GET http://example.com/admin"}}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	for _, ep := range eps {
+		if epDetail := runResults.EndpointDetails[ep.URL]; epDetail == nil || epDetail.Description != ep.Description {
+			t.Errorf("expected %s to have the description %q, got %+v", ep.URL, ep.Description, epDetail)
+		}
+	}
+
+	var out bytes.Buffer
+	printRunSummary(&out, runResults.RunSummary)
+	printEndpointDetails(&out, runResults.EndpointDetails)
+	for _, expected := range []string{"Skipped (disabled): login, GET http://example.com/admin", "browses the catalog", "always fails"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected the report to contain %q, got\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// matchesEndpoint reports whether the filter value 'v' matches 'ep'. It matches an
+// endpoint with that Name or Tag. An endpoint without a Name is also matched if its
+// URL contains 'v'.
+func matchesEndpoint(v string, ep api.Endpoint) bool {
+	if ep.Name == v {
+		return true
+	}
+	for _, tag := range ep.Tags {
+		if tag == v {
+			return true
+		}
+	}
+	return ep.Name == "" && strings.Contains(ep.URL, v)
+}
+
+// matchesAny reports whether any of the filter values 'vs' match 'ep'
+func matchesAny(vs []string, ep api.Endpoint) bool {
+	for _, v := range vs {
+		if matchesEndpoint(v, ep) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterEndpoints removes the Endpoints of 'config' that don't match any of 'only',
+// if any are specified, or that match any of 'skip'. The RqstPercents of the
+// remaining Endpoints, and of the endpoints of each phase, are scaled to add up to
+// 100 so the run's total volume of requests is unchanged. Phases left without any
+// endpoints are removed. The filtered config is returned along with a description
+// of the filter for the report's Meta. It's an error if every Endpoint is removed.
+func FilterEndpoints(config api.LoadTestConfig, only, skip []string) (api.LoadTestConfig, *api.EndpointFilter, error) {
+	if len(only) == 0 && len(skip) == 0 {
+		return config, nil, nil
+	}
+	for _, v := range append(append([]string(nil), only...), skip...) {
+		matched := false
+		for _, ep := range config.Endpoints {
+			matched = matched || matchesEndpoint(v, ep)
+		}
+		if !matched {
+			log.Warn().Msgf("endpoint filter %q doesn't match any endpoints", v)
+		}
+	}
+
+	by := fmt.Sprintf("the endpoint filters, -only %v and -skip %v,", only, skip)
+	config, labels, err := removeEndpoints(config, func(ep api.Endpoint) bool {
+		return (len(only) > 0 && !matchesAny(only, ep)) || matchesAny(skip, ep)
+	}, by)
+	if err != nil {
+		return config, nil, err
+	}
+	return config, &api.EndpointFilter{Only: only, Skip: skip, Endpoints: labels}, nil
+}
+
+// RemoveDisabledEndpoints removes the Disabled Endpoints of 'config', scaling the
+// RqstPercents of the remaining ones as FilterEndpoints does. The config is returned
+// along with the labels of the removed endpoints, which are reported as skipped in
+// the RunSummary. It's an error if every Endpoint is Disabled.
+func RemoveDisabledEndpoints(config api.LoadTestConfig) (api.LoadTestConfig, []string, error) {
+	var disabled []string
+	for _, ep := range config.Endpoints {
+		if ep.Disabled {
+			disabled = append(disabled, endpointLabel(ep))
+		}
+	}
+	if len(disabled) == 0 {
+		return config, nil, nil
+	}
+	if len(disabled) == len(config.Endpoints) {
+		return config, nil, fmt.Errorf("every endpoint is Disabled, there's nothing to run")
+	}
+	config, _, err := removeEndpoints(config, func(ep api.Endpoint) bool { return ep.Disabled }, "disabling endpoints")
+	if err != nil {
+		return config, nil, err
+	}
+	return config, disabled, nil
+}
+
+// removeEndpoints removes the Endpoints of 'config' for which 'remove' returns true.
+// The RqstPercents of the remaining Endpoints, and of the endpoints of each phase, are
+// scaled to add up to 100 and phases left without any endpoints are removed. The
+// config is returned along with the labels of the remaining Endpoints. 'by' describes
+// what removed the endpoints in errors and warnings.
+func removeEndpoints(config api.LoadTestConfig, remove func(ep api.Endpoint) bool, by string) (api.LoadTestConfig, []string, error) {
+	// pcts are the configured RqstPercents of the remaining endpoints, by Name, used
+	// for phase endpoints that don't override them
+	pcts := make(map[string]int)
+	var eps []api.Endpoint
+	var labels []string
+	for _, ep := range config.Endpoints {
+		if remove(ep) {
+			continue
+		}
+		if ep.Name != "" {
+			pcts[ep.Name] = ep.RqstPercent
+		}
+		eps = append(eps, ep)
+		labels = append(labels, endpointLabel(ep))
+	}
+	if len(eps) == 0 {
+		return config, nil, fmt.Errorf("%s removed every endpoint", by)
+	}
+	if !normalizeRqstPercents(len(eps), func(i int) *int { return &eps[i].RqstPercent }) {
+		return config, nil, fmt.Errorf("%s removed every endpoint with a RqstPercent", by)
+	}
+
+	var phases []api.Phase
+	for _, p := range config.Phases {
+		var phaseEPs []api.PhaseEndpoint
+		for _, phaseEP := range p.Endpoints {
+			pct, ok := pcts[phaseEP.Name]
+			if !ok {
+				continue
+			}
+			if phaseEP.RqstPercent == 0 {
+				phaseEP.RqstPercent = pct
+			}
+			phaseEPs = append(phaseEPs, phaseEP)
+		}
+		if len(phaseEPs) == 0 || !normalizeRqstPercents(len(phaseEPs), func(i int) *int { return &phaseEPs[i].RqstPercent }) {
+			log.Warn().Msgf("%s removed every endpoint of phase %s, it won't be run", by, p.Name)
+			continue
+		}
+		p.Endpoints = phaseEPs
+		phases = append(phases, p)
+	}
+	if len(config.Phases) > 0 && len(phases) == 0 {
+		return config, nil, fmt.Errorf("%s removed the endpoints of every phase", by)
+	}
+
+	config.Endpoints = eps
+	config.Phases = phases
+	return config, labels, nil
+}
+
+// endpointLabel identifies 'ep' by its Name, if it has one, otherwise by its Method and URL
+func endpointLabel(ep api.Endpoint) string {
+	if ep.Name != "" {
+		return ep.Name
+	}
+	return ep.Method + " " + ep.URL
+}
+
+// normalizeRqstPercents scales the 'n' RqstPercents returned by 'pct' to add up to
+// 100. The percents lost to rounding down go to the largest remainders so the total
+// is exact. It returns false if the RqstPercents add up to 0 and can't be scaled.
+func normalizeRqstPercents(n int, pct func(i int) *int) bool {
+	total := 0
+	for i := 0; i < n; i++ {
+		total += *pct(i)
+	}
+	if total == 0 {
+		return false
+	}
+	if total == 100 {
+		return true
+	}
+
+	remainders := make([]int, n)
+	order := make([]int, 0, n)
+	assigned := 0
+	for i := 0; i < n; i++ {
+		p := pct(i)
+		scaled := *p * 100
+		*p, remainders[i] = scaled/total, scaled%total
+		assigned += *p
+		order = append(order, i)
+	}
+	// Ties go to the endpoint configured first
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for i := 0; assigned < 100; i++ {
+		*pct(order[i])++
+		assigned++
+	}
+	return true
+}
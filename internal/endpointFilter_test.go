@@ -0,0 +1,181 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestFilterEndpoints verifies endpoints are selected by name, tag, and URL, that the
+// remaining RqstPercents are rescaled to add up to 100, and that removing every
+// endpoint is an error
+func TestFilterEndpoints(t *testing.T) {
+	config := api.LoadTestConfig{
+		Endpoints: []api.Endpoint{
+			{Name: "login", URL: "http://example.com/login", Method: "POST", RqstPercent: 10, Tags: []string{"auth"}},
+			{Name: "search", URL: "http://example.com/search", Method: "GET", RqstPercent: 30, Tags: []string{"read"}},
+			{Name: "browse", URL: "http://example.com/items", Method: "GET", RqstPercent: 50, Tags: []string{"read"}},
+			{URL: "http://example.com/health", Method: "GET", RqstPercent: 10},
+		},
+		Phases: []api.Phase{
+			{Name: "warmup", Endpoints: []api.PhaseEndpoint{{Name: "login"}}},
+			{Name: "steady", Endpoints: []api.PhaseEndpoint{
+				{Name: "login", RqstPercent: 20},
+				{Name: "search", RqstPercent: 40},
+				{Name: "browse", RqstPercent: 40},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name              string
+		only, skip        []string
+		expectedEPs       []string
+		expectedPcts      []int
+		expectedPhases    []string
+		expectedPhasePcts [][]int
+		expectErr         bool
+	}{
+		{
+			name:              "NoFilters",
+			expectedEPs:       []string{"login", "search", "browse", "GET http://example.com/health"},
+			expectedPcts:      []int{10, 30, 50, 10},
+			expectedPhases:    []string{"warmup", "steady"},
+			expectedPhasePcts: [][]int{{0}, {20, 40, 40}},
+		},
+		{
+			name:              "OnlyTag",
+			only:              []string{"read"},
+			expectedEPs:       []string{"search", "browse"},
+			expectedPcts:      []int{38, 62},
+			expectedPhases:    []string{"steady"},
+			expectedPhasePcts: [][]int{{50, 50}},
+		},
+		{
+			name:              "OnlyNameAndURL",
+			only:              []string{"login", "/health"},
+			expectedEPs:       []string{"login", "GET http://example.com/health"},
+			expectedPcts:      []int{50, 50},
+			expectedPhases:    []string{"warmup", "steady"},
+			expectedPhasePcts: [][]int{{100}, {100}},
+		},
+		{
+			// URL matching only applies to endpoints without a Name
+			name:              "SkipURLOnlyMatchesUnnamed",
+			skip:              []string{"example.com"},
+			expectedEPs:       []string{"login", "search", "browse"},
+			expectedPcts:      []int{11, 33, 56},
+			expectedPhases:    []string{"warmup", "steady"},
+			expectedPhasePcts: [][]int{{100}, {20, 40, 40}},
+		},
+		{
+			name:      "EveryEndpointRemoved",
+			only:      []string{"read"},
+			skip:      []string{"search", "browse"},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			filtered, filter, err := FilterEndpoints(config, tc.only, tc.skip)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error, got endpoints %v", filter.Endpoints)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var eps []string
+			var pcts []int
+			for _, ep := range filtered.Endpoints {
+				eps = append(eps, endpointLabel(ep))
+				pcts = append(pcts, ep.RqstPercent)
+			}
+			if !reflect.DeepEqual(eps, tc.expectedEPs) {
+				t.Errorf("expected endpoints %v, got %v", tc.expectedEPs, eps)
+			}
+			if !reflect.DeepEqual(pcts, tc.expectedPcts) {
+				t.Errorf("expected RqstPercents %v, got %v", tc.expectedPcts, pcts)
+			}
+			if len(tc.only) == 0 && len(tc.skip) == 0 {
+				if filter != nil {
+					t.Errorf("expected no filter to be reported, got %+v", filter)
+				}
+			} else if filter == nil || !reflect.DeepEqual(filter.Endpoints, tc.expectedEPs) {
+				t.Errorf("expected the filter to report endpoints %v, got %+v", tc.expectedEPs, filter)
+			}
+
+			var phases []string
+			var phasePcts [][]int
+			for _, p := range filtered.Phases {
+				phases = append(phases, p.Name)
+				var pcts []int
+				for _, ep := range p.Endpoints {
+					pcts = append(pcts, ep.RqstPercent)
+				}
+				phasePcts = append(phasePcts, pcts)
+			}
+			if !reflect.DeepEqual(phases, tc.expectedPhases) {
+				t.Errorf("expected phases %v, got %v", tc.expectedPhases, phases)
+			}
+			if !reflect.DeepEqual(phasePcts, tc.expectedPhasePcts) {
+				t.Errorf("expected phase RqstPercents %v, got %v", tc.expectedPhasePcts, phasePcts)
+			}
+		})
+	}
+
+	if config.Endpoints[0].RqstPercent != 10 || len(config.Endpoints) != 4 {
+		t.Errorf("expected the original config to be unchanged, got %+v", config.Endpoints)
+	}
+}
+
+// TestRemoveDisabledEndpoints verifies Disabled endpoints are removed and reported,
+// the remaining RqstPercents are rescaled, and disabling every endpoint is an error
+func TestRemoveDisabledEndpoints(t *testing.T) {
+	config := api.LoadTestConfig{
+		Endpoints: []api.Endpoint{
+			{Name: "login", URL: "http://example.com/login", Method: "POST", RqstPercent: 20, Disabled: true},
+			{Name: "search", URL: "http://example.com/search", Method: "GET", RqstPercent: 40},
+			{URL: "http://example.com/items", Method: "GET", RqstPercent: 40, Disabled: true},
+		},
+		Phases: []api.Phase{
+			{Name: "warmup", Endpoints: []api.PhaseEndpoint{{Name: "login"}}},
+			{Name: "steady", Endpoints: []api.PhaseEndpoint{{Name: "login", RqstPercent: 50}, {Name: "search", RqstPercent: 50}}},
+		},
+	}
+
+	enabled, disabled, err := RemoveDisabledEndpoints(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := []string{"login", "GET http://example.com/items"}; !reflect.DeepEqual(disabled, expected) {
+		t.Errorf("expected disabled endpoints %v, got %v", expected, disabled)
+	}
+	if len(enabled.Endpoints) != 1 || enabled.Endpoints[0].Name != "search" || enabled.Endpoints[0].RqstPercent != 100 {
+		t.Errorf("expected only search to remain, with a RqstPercent of 100, got %+v", enabled.Endpoints)
+	}
+	if len(enabled.Phases) != 1 || enabled.Phases[0].Name != "steady" || enabled.Phases[0].Endpoints[0].RqstPercent != 100 {
+		t.Errorf("expected only the steady phase to remain, with search at 100%%, got %+v", enabled.Phases)
+	}
+
+	unchanged, disabled, err := RemoveDisabledEndpoints(api.LoadTestConfig{Endpoints: config.Endpoints[1:2]})
+	if err != nil || disabled != nil || len(unchanged.Endpoints) != 1 || unchanged.Endpoints[0].RqstPercent != 40 {
+		t.Errorf("expected a config without Disabled endpoints to be unchanged, got %+v, %v, %v", unchanged.Endpoints, disabled, err)
+	}
+
+	for i := range config.Endpoints {
+		config.Endpoints[i].Disabled = true
+	}
+	if _, _, err := RemoveDisabledEndpoints(config); err == nil {
+		t.Errorf("expected an error when every endpoint is Disabled")
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"sort"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// weightedEndpointP95 breaks down the run's P95 latency by endpoint, recording each
+// endpoint's share of the successful requests, its own P95, and its share of the
+// requests slower than the run's P95, in rs.WeightedEndpointP95. The run's P95 is
+// calculated from the latencies of all the requests, it isn't an average of the
+// endpoints' P95s. The breakdown is only recorded if there's more than 1 endpoint.
+func weightedEndpointP95(rs *api.RunSummary, epRunSummary map[string]*api.EndpointDetail) {
+	if len(epRunSummary) < 2 || rs.RqstStats.TotalRqsts == 0 {
+		return
+	}
+	runP95 := calcPercentiles(95, rs.RqstStats.TimingResultsNanos)
+
+	var tailRqsts int64
+	var breakdown []api.EndpointP95
+	for url, epDetail := range epRunSummary {
+		var durations []time.Duration
+		for _, stats := range epDetail.HTTPMethodRqstStats {
+			durations = append(durations, stats.TimingResultsNanos...)
+		}
+		if len(durations) == 0 {
+			continue
+		}
+		ep := api.EndpointP95{
+			URL:       url,
+			RqstShare: float64(len(durations)) / float64(rs.RqstStats.TotalRqsts),
+			P95Nanos:  calcPercentiles(95, durations),
+		}
+		for _, d := range durations {
+			if d > runP95 {
+				ep.TailRqsts++
+			}
+		}
+		tailRqsts += ep.TailRqsts
+		breakdown = append(breakdown, ep)
+	}
+	for i := range breakdown {
+		if tailRqsts > 0 {
+			breakdown[i].TailShare = float64(breakdown[i].TailRqsts) / float64(tailRqsts)
+		}
+	}
+
+	// The endpoints dominating the tail first
+	sort.Slice(breakdown, func(i, j int) bool {
+		if breakdown[i].TailRqsts != breakdown[j].TailRqsts {
+			return breakdown[i].TailRqsts > breakdown[j].TailRqsts
+		}
+		return breakdown[i].URL < breakdown[j].URL
+	})
+	rs.WeightedEndpointP95 = breakdown
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestWeightedEndpointP95 verifies the run's percentiles are calculated from the
+// merged latencies of endpoints with very different distributions, rather than
+// from an average of their percentiles, and that the endpoint dominating the tail
+// is identified
+func TestWeightedEndpointP95(t *testing.T) {
+	fastURL, slowURL := "http://accountd.kube/users", "http://accountd.kube/reports"
+	var responses []Response
+	// 900 fast requests of 10ms and 100 slow requests from 500ms to 1490ms
+	for i := 0; i < 900; i++ {
+		responses = append(responses, Response{HTTPStatus: http.StatusOK, RequestDuration: 10 * time.Millisecond,
+			Endpoint: api.Endpoint{URL: fastURL, Method: http.MethodGet}})
+	}
+	for i := 0; i < 100; i++ {
+		responses = append(responses, Response{HTTPStatus: http.StatusOK,
+			RequestDuration: 500*time.Millisecond + time.Duration(i)*10*time.Millisecond,
+			Endpoint:        api.Endpoint{URL: slowURL, Method: http.MethodGet}})
+	}
+
+	rh := ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	rs := runResults.RunSummary
+
+	fastP99 := calcPercentiles(99, runResults.EndpointDetails[fastURL].HTTPMethodRqstStats[http.MethodGet].TimingResultsNanos)
+	slowP99 := calcPercentiles(99, runResults.EndpointDetails[slowURL].HTTPMethodRqstStats[http.MethodGet].TimingResultsNanos)
+	runP99 := calcPercentiles(99, rs.RqstStats.TimingResultsNanos)
+	// The 990th of the 1000 merged latencies is the 90th slow request
+	if expected := 1400 * time.Millisecond; runP99 != expected {
+		t.Errorf("expected the run's P99 to be %s, got %s", expected, runP99)
+	}
+	average := (fastP99 + slowP99) / 2
+	weightedAverage := time.Duration(0.9*float64(fastP99) + 0.1*float64(slowP99))
+	if runP99 == average || runP99 == weightedAverage {
+		t.Errorf("expected the run's P99, %s, not to be an average of the endpoints' P99s, %s and %s", runP99, fastP99, slowP99)
+	}
+
+	expected := []api.EndpointP95{
+		{URL: slowURL, RqstShare: 0.1, P95Nanos: 1450 * time.Millisecond, TailRqsts: 49, TailShare: 1},
+		{URL: fastURL, RqstShare: 0.9, P95Nanos: 10 * time.Millisecond},
+	}
+	if len(rs.WeightedEndpointP95) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, rs.WeightedEndpointP95)
+	}
+	for i := range expected {
+		if rs.WeightedEndpointP95[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], rs.WeightedEndpointP95[i])
+		}
+	}
+
+	var b bytes.Buffer
+	printRunSummary(&b, rs)
+	if !strings.Contains(b.String(), slowURL+": 10.00% of rqsts, P95 1.4500s, 100.00% of tail") {
+		t.Errorf("expected the P95 breakdown to be reported, got %s", b.String())
+	}
+}
+
+// TestWeightedEndpointP95SingleEndpoint verifies there's no breakdown of a single endpoint
+func TestWeightedEndpointP95SingleEndpoint(t *testing.T) {
+	responses := []Response{{HTTPStatus: http.StatusOK, RequestDuration: time.Millisecond,
+		Endpoint: api.Endpoint{URL: "http://accountd.kube/users", Method: http.MethodGet}}}
+	rh := ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	if runResults.RunSummary.WeightedEndpointP95 != nil {
+		t.Errorf("expected no breakdown, got %+v", runResults.RunSummary.WeightedEndpointP95)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// rawEndpoints are the Endpoints and EndpointTemplates of a config as they appear in
+// the config file, so the fields that are set can be told from those that aren't
+type rawEndpoints struct {
+	Endpoints         []json.RawMessage
+	EndpointTemplates map[string]json.RawMessage
+}
+
+// ResolveEndpointTemplates replaces each of 'config's Endpoints that Extends a template
+// with the endpoint resolved from its chain of templates. 'contents' is the config
+// file 'config' was read from. The fields of each template, starting with the one
+// furthest up the chain, and then of the endpoint itself, replace those inherited.
+// It's an error if a chain, of an Endpoint or any template, references an unknown
+// template or is a cycle. 'config's EndpointTemplates are cleared once resolved.
+func ResolveEndpointTemplates(contents []byte, config *api.LoadTestConfig) error {
+	var raw rawEndpoints
+	if err := json.Unmarshal(contents, &raw); err != nil {
+		return fmt.Errorf("error reading the config's endpoints: %w", err)
+	}
+
+	names := make([]string, 0, len(raw.EndpointTemplates))
+	for name := range raw.EndpointTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := templateChain(raw.EndpointTemplates, name); err != nil {
+			return fmt.Errorf("endpoint template %s: %w", name, err)
+		}
+	}
+
+	for i, ep := range config.Endpoints {
+		if ep.Extends == "" {
+			continue
+		}
+		chain, err := templateChain(raw.EndpointTemplates, ep.Extends)
+		if err != nil {
+			return fmt.Errorf("endpoint %d, %s, extends %s: %w", i, endpointLabel(ep), ep.Extends, err)
+		}
+		objs := make([]json.RawMessage, 0, len(chain)+1)
+		for j := len(chain) - 1; j >= 0; j-- {
+			objs = append(objs, raw.EndpointTemplates[chain[j]])
+		}
+		if config.Endpoints[i], err = mergeEndpoint(append(objs, raw.Endpoints[i])); err != nil {
+			return fmt.Errorf("endpoint %d, %s, error resolving its templates: %w", i, endpointLabel(ep), err)
+		}
+	}
+	config.EndpointTemplates = nil
+	return nil
+}
+
+// templateChain returns the names of the templates inherited from by extending the
+// template 'name': 'name', the template it extends, if any, and so on
+func templateChain(templates map[string]json.RawMessage, name string) ([]string, error) {
+	var chain []string
+	seen := make(map[string]bool)
+	for name != "" {
+		chain = append(chain, name)
+		if seen[name] {
+			return nil, fmt.Errorf("templates extend each other in a cycle, %s", strings.Join(chain, " -> "))
+		}
+		seen[name] = true
+		tmplt, ok := templates[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown template %s in the chain %s", name, strings.Join(chain, " -> "))
+		}
+		var extends struct{ Extends string }
+		if err := json.Unmarshal(tmplt, &extends); err != nil {
+			return nil, fmt.Errorf("error reading template %s: %w", name, err)
+		}
+		name = extends.Extends
+	}
+	return chain, nil
+}
+
+// mergeEndpoint returns the Endpoint described by 'objs', JSON objects whose fields
+// replace those of the objects before them
+func mergeEndpoint(objs []json.RawMessage) (api.Endpoint, error) {
+	// Field names are matched case-insensitively when they're unmarshaled, so they're
+	// also merged case-insensitively
+	merged := make(map[string]json.RawMessage)
+	for _, obj := range objs {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(obj, &fields); err != nil {
+			return api.Endpoint{}, err
+		}
+		for name, value := range fields {
+			merged[strings.ToLower(name)] = value
+		}
+	}
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return api.Endpoint{}, err
+	}
+	var ep api.Endpoint
+	err = json.Unmarshal(b, &ep)
+	return ep, err
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestResolveEndpointTemplates verifies endpoints inherit the fields of their chain of
+// templates, overriding them selectively, and that unknown templates and cycles are
+// errors describing the chain
+func TestResolveEndpointTemplates(t *testing.T) {
+	templates := `"EndpointTemplates": {
+		"base": {"URL": "http://example.com/", "Method": "GET", "Headers": {"Authorization": "Bearer token"},
+			"Retry": {"MaxRetries": 2}},
+		"users": {"Extends": "base", "Tags": ["users"], "headers": {"Accept": "application/json"}}
+	}`
+
+	tests := []struct {
+		name        string
+		config      string
+		expectedEPs []api.Endpoint
+		// expectedErr, if set, is part of the expected error
+		expectedErr string
+	}{
+		{
+			name:        "no templates",
+			config:      `{"Endpoints": [{"URL": "http://example.com/health", "Method": "GET", "RqstPercent": 100}]}`,
+			expectedEPs: []api.Endpoint{{URL: "http://example.com/health", Method: "GET", RqstPercent: 100}},
+		},
+		{
+			name: "extends",
+			config: `{` + templates + `, "Endpoints": [
+				{"Name": "health", "Extends": "base", "URL": "http://example.com/health", "RqstPercent": 50},
+				{"Name": "user", "Extends": "users", "url": "http://example.com/users/1", "RqstPercent": 50, "Retry": null},
+				{"Name": "plain", "URL": "http://example.com/", "Method": "DELETE"}
+			]}`,
+			expectedEPs: []api.Endpoint{
+				{Name: "health", Extends: "base", URL: "http://example.com/health", Method: "GET", RqstPercent: 50,
+					Headers: map[string]string{"Authorization": "Bearer token"}, Retry: &api.RetryPolicy{MaxRetries: 2}},
+				// The template's Headers replace those of the template it extends
+				{Name: "user", Extends: "users", Tags: []string{"users"}, URL: "http://example.com/users/1", Method: "GET",
+					RqstPercent: 50, Headers: map[string]string{"Accept": "application/json"}},
+				{Name: "plain", URL: "http://example.com/", Method: "DELETE"},
+			},
+		},
+		{
+			name:        "unknown template",
+			config:      `{` + templates + `, "Endpoints": [{"Name": "user", "Extends": "user"}]}`,
+			expectedErr: "endpoint 0, user, extends user: unknown template user in the chain user",
+		},
+		{
+			name: "unknown template in chain",
+			config: `{"EndpointTemplates": {"a": {"Extends": "b"}, "b": {"Extends": "c"}},
+				"Endpoints": [{"Name": "user", "Extends": "a"}]}`,
+			expectedErr: "endpoint template a: unknown template c in the chain a -> b -> c",
+		},
+		{
+			name: "cycle",
+			config: `{"EndpointTemplates": {"a": {"Extends": "b"}, "b": {"Extends": "c"}, "c": {"Extends": "a"}},
+				"Endpoints": [{"Name": "user", "Extends": "b"}]}`,
+			expectedErr: "endpoint template a: templates extend each other in a cycle, a -> b -> c -> a",
+		},
+		{
+			name:        "self reference",
+			config:      `{"EndpointTemplates": {"a": {"Extends": "a"}}}`,
+			expectedErr: "endpoint template a: templates extend each other in a cycle, a -> a",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var config api.LoadTestConfig
+			if err := json.Unmarshal([]byte(tc.config), &config); err != nil {
+				t.Fatalf("unexpected error unmarshaling the config: %s", err)
+			}
+			err := ResolveEndpointTemplates([]byte(tc.config), &config)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Errorf("expected an error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(config.Endpoints, tc.expectedEPs) {
+				t.Errorf("expected endpoints %+v, got %+v", tc.expectedEPs, config.Endpoints)
+			}
+			if config.EndpointTemplates != nil {
+				t.Errorf("expected the templates to be cleared, got %+v", config.EndpointTemplates)
+			}
+		})
+	}
+}
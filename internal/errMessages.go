@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+const (
+	// maxTrackedErrMsgs bounds the number of distinct error messages counted
+	maxTrackedErrMsgs = 100
+	// numTopErrMsgs is the number of error messages reported in RunSummary.TopErrorMessages
+	numTopErrMsgs = 10
+)
+
+// errMsgNormalizers replace the variable parts of error messages, like addresses and
+// ports, so that messages describing the same failure are counted together. They're
+// applied in order.
+var errMsgNormalizers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Request URLs, e.g., Get "http://somehost/users/123": ...
+	{re: regexp.MustCompile(`"[a-zA-Z][a-zA-Z0-9+.-]*://[^"]*"`), repl: `"<url>"`},
+	// IPv6 addresses with an optional port, e.g., [::1]:8080
+	{re: regexp.MustCompile(`\[[0-9a-fA-F:.%]+\](:\d+)?`), repl: "<addr>"},
+	// IPv4 addresses with an optional port, e.g., 127.0.0.1:54321
+	{re: regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), repl: "<addr>"},
+	// Ports following a host name, e.g., localhost:8080
+	{re: regexp.MustCompile(`([a-zA-Z0-9-]):\d{1,5}\b`), repl: "$1:<port>"},
+	// Memory addresses and other hex values, e.g., 0xc000123456
+	{re: regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`), repl: "<hex>"},
+}
+
+// normalizeErrMsg returns 'msg' with its variable parts replaced by placeholders
+func normalizeErrMsg(msg string) string {
+	for _, n := range errMsgNormalizers {
+		msg = n.re.ReplaceAllString(msg, n.repl)
+	}
+	return msg
+}
+
+// errMsgCounter counts distinct error messages using a bounded amount of memory. It
+// implements the Space-Saving algorithm: once maxTrackedErrMsgs messages are being
+// counted, a new message replaces the least frequent one and inherits its count. The
+// most frequent messages are always retained, though their counts may be overstated
+// by at most the count of the message they replaced.
+type errMsgCounter struct {
+	counts map[string]int64
+}
+
+// add counts an occurrence of the error message 'msg'
+func (c *errMsgCounter) add(msg string) {
+	if c.counts == nil {
+		c.counts = make(map[string]int64)
+	}
+	if _, ok := c.counts[msg]; ok || len(c.counts) < maxTrackedErrMsgs {
+		c.counts[msg]++
+		return
+	}
+
+	minMsg, minCount := "", int64(-1)
+	for m, cnt := range c.counts {
+		if minCount == -1 || cnt < minCount || (cnt == minCount && m < minMsg) {
+			minMsg, minCount = m, cnt
+		}
+	}
+	delete(c.counts, minMsg)
+	c.counts[msg] = minCount + 1
+}
+
+// top returns up to 'k' of the most frequent error messages ordered by count, most
+// frequent first
+func (c *errMsgCounter) top(k int) []api.ErrorMessageCount {
+	msgs := make([]api.ErrorMessageCount, 0, len(c.counts))
+	for m, cnt := range c.counts {
+		msgs = append(msgs, api.ErrorMessageCount{Message: m, Count: cnt})
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		if msgs[i].Count != msgs[j].Count {
+			return msgs[i].Count > msgs[j].Count
+		}
+		return msgs[i].Message < msgs[j].Message
+	})
+	if len(msgs) > k {
+		msgs = msgs[:k]
+	}
+	return msgs
+}
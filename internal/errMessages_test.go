@@ -0,0 +1,138 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+	"github.com/youngkin/heyyall/internal/stats"
+)
+
+func TestNormalizeErrMsg(t *testing.T) {
+	tests := []struct {
+		msg      string
+		expected string
+	}{
+		{
+			msg:      `Get "http://127.0.0.1:8080/users/1": dial tcp 127.0.0.1:8080: connect: connection refused`,
+			expected: `Get "<url>": dial tcp <addr>: connect: connection refused`,
+		},
+		{
+			msg:      `Post "https://accountd.kube/users": read tcp 10.0.0.7:54321->10.0.0.9:443: read: connection reset by peer`,
+			expected: `Post "<url>": read tcp <addr>-><addr>: read: connection reset by peer`,
+		},
+		{
+			msg:      `dial tcp [::1]:8443: connect: connection refused`,
+			expected: `dial tcp <addr>: connect: connection refused`,
+		},
+		{
+			msg:      `dial tcp: lookup accountd.kube:443 on resolver: no such host`,
+			expected: `dial tcp: lookup accountd.kube:<port> on resolver: no such host`,
+		},
+		{
+			msg:      `net/http: request canceled while waiting for connection (Client 0xc000123abc)`,
+			expected: `net/http: request canceled while waiting for connection (Client <hex>)`,
+		},
+	}
+
+	for _, tc := range tests {
+		if actual := normalizeErrMsg(tc.msg); actual != tc.expected {
+			t.Errorf("expected %q to be normalized to %q, got %q", tc.msg, tc.expected, actual)
+		}
+	}
+}
+
+// TestTopErrorMessages verifies that error messages are counted in their normalized
+// form and that the most frequent are reported, even when there are more distinct
+// messages than can be tracked
+func TestTopErrorMessages(t *testing.T) {
+	url1 := "http://someurl/1"
+	runResults := api.RunResults{
+		RunSummary:      api.RunSummary{RqstStats: api.RqstStats{MinRqstDurationNanos: math.MaxInt64}},
+		EndpointSummary: make(map[string]map[string]int),
+	}
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	rh := ResponseHandler{OutputType: JSON}
+	acc := stats.NewAccumulator(stats.Config{Start: time.Now()})
+
+	errs := make([]error, 0)
+	for i := 0; i < 30; i++ {
+		errs = append(errs, fmt.Errorf(`Get "http://10.0.0.%d:8080/users/%d": dial tcp 10.0.0.%d:8080: connect: connection refused`, i%4, i, i%4))
+	}
+	for i := 0; i < 20; i++ {
+		errs = append(errs, fmt.Errorf("read tcp 127.0.0.1:%d->127.0.0.1:8080: read: connection reset by peer", 50000+i))
+	}
+	// More distinct messages than can be tracked, each occurring once
+	for i := 0; i < maxTrackedErrMsgs*2; i++ {
+		errs = append(errs, fmt.Errorf("unexpected failure %d", i))
+	}
+	errs = append(errs, errors.New("EOF"), errors.New("EOF"))
+
+	for _, err := range errs {
+		rh.accumulateResponseStats(Response{
+			Endpoint:    api.Endpoint{URL: url1, Method: http.MethodGet},
+			ErrCategory: api.ErrCategoryConnection,
+			Err:         err,
+		}, acc, &runResults, epRunSummary)
+	}
+	if err := rh.finalizeResponseStats(acc, &runResults, epRunSummary); err != nil {
+		t.Fatalf("unexpected error finalizing response stats: %s", err)
+	}
+
+	top := runResults.RunSummary.TopErrorMessages
+	if len(top) != numTopErrMsgs {
+		t.Fatalf("expected %d top error messages, got %d: %+v", numTopErrMsgs, len(top), top)
+	}
+	expected := []api.ErrorMessageCount{
+		{Message: `Get "<url>": dial tcp <addr>: connect: connection refused`, Count: 30},
+		{Message: "read tcp <addr>-><addr>: read: connection reset by peer", Count: 20},
+	}
+	if !reflect.DeepEqual(top[:2], expected) {
+		t.Errorf("expected the top error messages to be %+v, got %+v", expected, top[:2])
+	}
+	if len(rh.errMsgs.counts) > maxTrackedErrMsgs {
+		t.Errorf("expected at most %d error messages to be tracked, got %d", maxTrackedErrMsgs, len(rh.errMsgs.counts))
+	}
+	if runResults.RunSummary.ErrorCategories[api.ErrCategoryConnection] != int64(len(errs)) {
+		t.Errorf("expected %d connection errors, got %d", len(errs), runResults.RunSummary.ErrorCategories[api.ErrCategoryConnection])
+	}
+}
+
+// TestConnectionError verifies that a request that fails to connect is reported as
+// a ConnectionError along with its error
+func TestConnectionError(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srvURL := testSrv.URL
+	testSrv.Close()
+
+	respC := make(chan Response, 1)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    http.Client{},
+	}
+	rqstr.ProcessRqst(api.Endpoint{URL: srvURL, Method: http.MethodGet, RqstPercent: 100}, 3, 0)
+	close(respC)
+
+	numResps := 0
+	for resp := range respC {
+		numResps++
+		if resp.ErrCategory != api.ErrCategoryConnection || resp.Err == nil {
+			t.Errorf("expected a %s with an error, got %+v", api.ErrCategoryConnection, resp)
+		}
+	}
+	if numResps != 1 {
+		t.Errorf("expected 1 response before the requestor stopped, got %d", numResps)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// reportedPercentiles are the latency percentiles included in the reports, 0 is the
+// minimum latency
+var reportedPercentiles = []int{0, 50, 75, 90, 95, 99}
+
+// exemplarSample is the latency and trace ID of a single successful request
+type exemplarSample struct {
+	duration time.Duration
+	traceID  string
+}
+
+// traceID returns the value of the 'header' response header, or of the request
+// header of the same name in 'rqstHeaders' if the response doesn't have it
+func traceID(header string, respHeader http.Header, rqstHeaders map[string]string) string {
+	if header == "" {
+		return ""
+	}
+	if id := respHeader.Get(header); id != "" {
+		return id
+	}
+	for name, value := range rqstHeaders {
+		if strings.EqualFold(name, header) {
+			return value
+		}
+	}
+	return ""
+}
+
+// exemplars returns an exemplar for each of the reportedPercentiles. A percentile's
+// exemplar is the slowest request with a trace ID in its band, i.e., no slower than
+// the percentile and slower than the percentile below it. Percentiles whose band has
+// no requests with a trace ID don't have an exemplar.
+func exemplars(samples []exemplarSample) []api.Exemplar {
+	if len(samples) == 0 {
+		return nil
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].duration < samples[j].duration })
+	durations := make([]time.Duration, len(samples))
+	for i, s := range samples {
+		durations[i] = s.duration
+	}
+
+	var result []api.Exemplar
+	// lower is the index of the fastest request in the current band
+	lower := 0
+	for _, p := range reportedPercentiles {
+		value := calcPercentiles(p, durations)
+		upper := sort.Search(len(samples), func(i int) bool { return samples[i].duration > value }) - 1
+		for i := upper; i >= lower; i-- {
+			if samples[i].traceID != "" {
+				result = append(result, api.Exemplar{Percentile: p, DurationNanos: samples[i].duration, TraceID: samples[i].traceID})
+				break
+			}
+		}
+		if upper+1 > lower {
+			lower = upper + 1
+		}
+	}
+	return result
+}
+
+// exemplarSamples are the samples of the requests whose latencies are summarized by
+// a single RqstStats
+type exemplarSamples struct {
+	samples []exemplarSample
+	// traced is true if any of the samples has a trace ID
+	traced bool
+}
+
+func (s *exemplarSamples) add(sample exemplarSample) {
+	s.samples = append(s.samples, sample)
+	s.traced = s.traced || sample.traceID != ""
+}
+
+// attachExemplars sets the Exemplars of the run's RqstStats, and of each endpoint's
+// RqstStats, from the trace IDs of the successful 'responses'. Requests without a
+// trace ID still determine the percentiles. Responses are grouped into endpoints using
+// 'keyer'.
+func attachExemplars(responses []Response, keyer summaryKeyer, runResults *api.RunResults) {
+	var runSamples exemplarSamples
+	epSamples := make(map[string]map[string]*exemplarSamples)
+	for _, r := range responses {
+		if r.KeepAliveProbe != nil || r.AbandonedSlow || r.ErrCategory != "" {
+			continue
+		}
+		sample := exemplarSample{duration: r.RequestDuration, traceID: r.TraceID}
+		runSamples.add(sample)
+
+		key := keyer.key(r.Endpoint.URL)
+		if epSamples[key] == nil {
+			epSamples[key] = make(map[string]*exemplarSamples)
+		}
+		if epSamples[key][r.Endpoint.Method] == nil {
+			epSamples[key][r.Endpoint.Method] = &exemplarSamples{}
+		}
+		epSamples[key][r.Endpoint.Method].add(sample)
+	}
+	if !runSamples.traced {
+		return
+	}
+
+	runResults.RunSummary.RqstStats.Exemplars = exemplars(runSamples.samples)
+	for key, methods := range epSamples {
+		epDetail, ok := runResults.EndpointDetails[key]
+		if !ok {
+			continue
+		}
+		for method, samples := range methods {
+			if stats, ok := epDetail.HTTPMethodRqstStats[method]; ok && samples.traced {
+				stats.Exemplars = exemplars(samples.samples)
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestExemplars verifies the reported latency percentiles are linked to sample requests
+// by their trace IDs
+func TestExemplars(t *testing.T) {
+	ep := api.Endpoint{URL: "http://someservice.test/users", Method: http.MethodGet}
+	var responses []Response
+	// Requests take from 1ms to 100ms, only those taking an even number of
+	// milliseconds have a trace ID
+	for i := 1; i <= 100; i++ {
+		resp := Response{HTTPStatus: http.StatusOK, Endpoint: ep, RequestDuration: time.Duration(i) * time.Millisecond}
+		if i%2 == 0 {
+			resp.TraceID = fmt.Sprintf("trace-%d", i)
+		}
+		responses = append(responses, resp)
+	}
+	responses = append(responses, Response{Endpoint: ep, RequestDuration: time.Second, TraceID: "failed",
+		ErrCategory: api.ErrCategoryConnection})
+
+	rh := ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	// The minimum, 1ms, has no trace ID. The 90th percentile, 91ms, doesn't have
+	// one either so the next slowest request in its band is used.
+	expected := []api.Exemplar{
+		{Percentile: 50, DurationNanos: 50 * time.Millisecond, TraceID: "trace-50"},
+		{Percentile: 75, DurationNanos: 76 * time.Millisecond, TraceID: "trace-76"},
+		{Percentile: 90, DurationNanos: 90 * time.Millisecond, TraceID: "trace-90"},
+		{Percentile: 95, DurationNanos: 96 * time.Millisecond, TraceID: "trace-96"},
+		{Percentile: 99, DurationNanos: 100 * time.Millisecond, TraceID: "trace-100"},
+	}
+	if actual := runResults.RunSummary.RqstStats.Exemplars; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected run exemplars %+v, got %+v", expected, actual)
+	}
+	if actual := runResults.EndpointDetails[ep.URL].HTTPMethodRqstStats[ep.Method].Exemplars; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected endpoint exemplars %+v, got %+v", expected, actual)
+	}
+}
+
+// TestTraceID verifies the trace ID is taken from the response, or failing that the request
+func TestTraceID(t *testing.T) {
+	respHeader := http.Header{"X-Request-Id": {"from-response"}}
+	rqstHeaders := map[string]string{"x-request-id": "from-request", "Traceparent": "00-abc-def-01"}
+
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{header: "X-Request-ID", expected: "from-response"},
+		{header: "traceparent", expected: "00-abc-def-01"},
+		{header: "X-Missing", expected: ""},
+		{header: "", expected: ""},
+	}
+	for _, tc := range tests {
+		if actual := traceID(tc.header, respHeader, rqstHeaders); actual != tc.expected {
+			t.Errorf("expected trace ID %q for header %q, got %q", tc.expected, tc.header, actual)
+		}
+	}
+}
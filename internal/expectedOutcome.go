@@ -0,0 +1,128 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// networkErrorOther describes a network error that isn't one of the api.NetworkError...
+// kinds
+const networkErrorOther = "other"
+
+// validateExpectedOutcome verifies that 'ep's ExpectedOutcome, if it has one, expects
+// exactly one known outcome and isn't combined with other checks of the response
+func validateExpectedOutcome(ep api.Endpoint) error {
+	o := ep.ExpectedOutcome
+	if o == nil {
+		return nil
+	}
+	if (o.Status == 0) == (o.NetworkError == "") {
+		return fmt.Errorf("endpoint %s %s has an ExpectedOutcome, it must have either a Status or a NetworkError", ep.Method, ep.URL)
+	}
+	if o.Status != 0 && (o.Status < 100 || o.Status > 599) {
+		return fmt.Errorf("endpoint %s %s has an ExpectedOutcome Status, %d, that isn't an HTTP status", ep.Method, ep.URL, o.Status)
+	}
+	switch o.NetworkError {
+	case "", api.NetworkErrorConnectionReset, api.NetworkErrorConnectionRefused, api.NetworkErrorEOF, api.NetworkErrorTimeout:
+	default:
+		return fmt.Errorf("endpoint %s %s has an ExpectedOutcome NetworkError, %q, it must be one of %s, %s, %s, or %s",
+			ep.Method, ep.URL, o.NetworkError, api.NetworkErrorConnectionReset, api.NetworkErrorConnectionRefused,
+			api.NetworkErrorEOF, api.NetworkErrorTimeout)
+	}
+	if ep.SuccessJSONPath != "" || ep.SuccessExpr != "" || ep.ExpectedSHA256 != "" {
+		return fmt.Errorf("endpoint %s %s has an ExpectedOutcome, it can't also have a SuccessJSONPath, SuccessExpr, or ExpectedSHA256",
+			ep.Method, ep.URL)
+	}
+	return nil
+}
+
+// expectedOutcomeLabel describes 'o', e.g., 'status 403' or 'network error connection_reset'
+func expectedOutcomeLabel(o api.ExpectedOutcome) string {
+	if o.NetworkError != "" {
+		return "network error " + o.NetworkError
+	}
+	return fmt.Sprintf("status %d", o.Status)
+}
+
+// networkErrorKind classifies 'err' as one of the api.NetworkError... kinds, or
+// networkErrorOther if it's none of them
+func networkErrorKind(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNRESET):
+		return api.NetworkErrorConnectionReset
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return api.NetworkErrorConnectionRefused
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return api.NetworkErrorEOF
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return api.NetworkErrorTimeout
+	}
+	return networkErrorOther
+}
+
+// attemptOutcome returns the outcome of 'attempt'. A failure while reading the
+// response body is a network error even though the status was received.
+func attemptOutcome(attempt rqstAttempt) api.ExpectedOutcome {
+	err := attempt.err
+	if err == nil {
+		err = attempt.bodyErr
+	}
+	if err != nil || attempt.resp == nil {
+		return api.ExpectedOutcome{NetworkError: networkErrorKind(err)}
+	}
+	return api.ExpectedOutcome{Status: attempt.resp.StatusCode}
+}
+
+// negativeTestResponse returns the Response to 'attempt', a request to 'ep', a
+// negative test endpoint. The response is failed, with the UnexpectedOutcome
+// category, unless the attempt had 'ep's ExpectedOutcome.
+func negativeTestResponse(ep api.Endpoint, url string, attempt rqstAttempt, retries int) Response {
+	response := Response{
+		Endpoint:        api.Endpoint{URL: url, Method: ep.Method, ExpectedOutcome: ep.ExpectedOutcome},
+		RequestDuration: attempt.duration,
+		TTFB:            attempt.ttfb,
+		Retries:         retries,
+		BytesReceived:   attempt.bodyBytes,
+	}
+	if attempt.resp != nil {
+		response.HTTPStatus = attempt.resp.StatusCode
+		response.Header = attempt.resp.Header
+		response.Proto = attempt.resp.Proto
+		response.RqstProto = ep.ProtocolVersion
+	}
+
+	outcome := attemptOutcome(attempt)
+	if outcome == *ep.ExpectedOutcome {
+		return response
+	}
+	response.ErrCategory = api.ErrCategoryUnexpectedOutcome
+	response.Outcome = expectedOutcomeLabel(outcome)
+	response.Err = fmt.Errorf("expected %s, got %s", expectedOutcomeLabel(*ep.ExpectedOutcome), response.Outcome)
+	return response
+}
+
+// accumulateNegativeTest labels 'epDetail' as a negative test, if 'resp' is a
+// response to one, and counts the response's outcome if it was unexpected
+func accumulateNegativeTest(resp Response, epDetail *api.EndpointDetail) {
+	if resp.Endpoint.ExpectedOutcome == nil {
+		return
+	}
+	epDetail.NegativeTest = expectedOutcomeLabel(*resp.Endpoint.ExpectedOutcome)
+	if resp.ErrCategory != api.ErrCategoryUnexpectedOutcome {
+		return
+	}
+	if epDetail.UnexpectedOutcomes == nil {
+		epDetail.UnexpectedOutcomes = make(map[string]int64)
+	}
+	epDetail.UnexpectedOutcomes[resp.Outcome]++
+}
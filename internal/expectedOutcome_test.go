@@ -0,0 +1,140 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestExpectedOutcome verifies that responses to a negative test endpoint are only
+// successful if they have its ExpectedOutcome, whether that's a status or a network
+// error, and that unexpected outcomes, including a 200, are counted by outcome
+func TestExpectedOutcome(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		case "/reset", "/close":
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("unable to hijack the connection: %s", err)
+				return
+			}
+			if r.URL.Path == "/reset" {
+				// Discarding unsent data on close resets the connection
+				conn.(*net.TCPConn).SetLinger(0)
+			}
+			conn.Close()
+		default:
+			w.Write([]byte("OK"))
+		}
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name               string
+		path               string
+		expectedOutcome    api.ExpectedOutcome
+		expectedNegTest    string
+		expectedUnexpected map[string]int64
+	}{
+		{name: "StatusMet", path: "/forbidden", expectedOutcome: api.ExpectedOutcome{Status: http.StatusForbidden},
+			expectedNegTest: "status 403"},
+		{name: "StatusNotMet", path: "/ok", expectedOutcome: api.ExpectedOutcome{Status: http.StatusForbidden},
+			expectedNegTest: "status 403", expectedUnexpected: map[string]int64{"status 200": 3}},
+		{name: "NetworkErrorMet", path: "/reset", expectedOutcome: api.ExpectedOutcome{NetworkError: api.NetworkErrorConnectionReset},
+			expectedNegTest: "network error connection_reset"},
+		{name: "NetworkErrorNotMetByStatus", path: "/forbidden",
+			expectedOutcome: api.ExpectedOutcome{NetworkError: api.NetworkErrorConnectionReset},
+			expectedNegTest: "network error connection_reset", expectedUnexpected: map[string]int64{"status 403": 3}},
+		{name: "NetworkErrorNotMetByOtherError", path: "/close",
+			expectedOutcome: api.ExpectedOutcome{NetworkError: api.NetworkErrorConnectionReset},
+			expectedNegTest: "network error connection_reset", expectedUnexpected: map[string]int64{"network error eof": 3}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			expectedOutcome := tc.expectedOutcome
+			ep := api.Endpoint{URL: testSrv.URL + tc.path, Method: http.MethodGet, ExpectedOutcome: &expectedOutcome}
+			if err := validateExpectedOutcome(ep); err != nil {
+				t.Fatalf("unexpected error validating ExpectedOutcome: %s", err)
+			}
+
+			numRqsts := 3
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    http.Client{},
+			}
+			rqstr.ProcessRqst(ep, numRqsts, 0)
+			close(respC)
+
+			var responses []Response
+			for resp := range respC {
+				responses = append(responses, resp)
+			}
+			if len(responses) != numRqsts {
+				t.Fatalf("expected %d responses, got %d", numRqsts, len(responses))
+			}
+
+			rh := ResponseHandler{start: time.Now()}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			epDetail := runResults.EndpointDetails[ep.URL]
+			if epDetail.NegativeTest != tc.expectedNegTest {
+				t.Errorf("expected NegativeTest %q, got %q", tc.expectedNegTest, epDetail.NegativeTest)
+			}
+			unexpected := epDetail.ErrorCategories[api.ErrCategoryUnexpectedOutcome]
+			if want := int64(numRqsts) - runResults.RunSummary.RqstStats.TotalRqsts; unexpected != want {
+				t.Errorf("expected %d UnexpectedOutcome errors, got %d", want, unexpected)
+			}
+			if len(epDetail.UnexpectedOutcomes) != len(tc.expectedUnexpected) {
+				t.Fatalf("expected unexpected outcomes %v, got %v", tc.expectedUnexpected, epDetail.UnexpectedOutcomes)
+			}
+			for outcome, count := range tc.expectedUnexpected {
+				if epDetail.UnexpectedOutcomes[outcome] != count {
+					t.Errorf("expected unexpected outcomes %v, got %v", tc.expectedUnexpected, epDetail.UnexpectedOutcomes)
+				}
+			}
+			if tc.expectedUnexpected == nil && len(epDetail.ErrorCategories) != 0 {
+				t.Errorf("expected no errors, got %v", epDetail.ErrorCategories)
+			}
+		})
+	}
+}
+
+// TestValidateExpectedOutcome verifies ExpectedOutcomes without exactly one known
+// outcome, or combined with other response checks, are rejected
+func TestValidateExpectedOutcome(t *testing.T) {
+	tests := []struct {
+		name string
+		ep   api.Endpoint
+	}{
+		{name: "Empty", ep: api.Endpoint{ExpectedOutcome: &api.ExpectedOutcome{}}},
+		{name: "Both", ep: api.Endpoint{ExpectedOutcome: &api.ExpectedOutcome{Status: 403, NetworkError: api.NetworkErrorEOF}}},
+		{name: "BadStatus", ep: api.Endpoint{ExpectedOutcome: &api.ExpectedOutcome{Status: 42}}},
+		{name: "UnknownNetworkError", ep: api.Endpoint{ExpectedOutcome: &api.ExpectedOutcome{NetworkError: "dns"}}},
+		{name: "WithSuccessExpr", ep: api.Endpoint{ExpectedOutcome: &api.ExpectedOutcome{Status: 429}, SuccessExpr: "status == 429"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.ep.URL, tc.ep.Method = "http://someservice.test/", http.MethodGet
+			if err := validateExpectedOutcome(tc.ep); err == nil {
+				t.Errorf("expected an error validating ExpectedOutcome %+v", *tc.ep.ExpectedOutcome)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// errInjectedFailure is the error of a request failed by a FailureInjector
+var errInjectedFailure = errors.New("failure injected before the request was sent")
+
+// FailureInjector fails a random fraction of the requests before they're sent, to
+// test heyyall's own handling and reporting of failed requests. It's shared by all
+// requestors.
+type FailureInjector struct {
+	rate float64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewFailureInjector returns a FailureInjector failing a uniform random 'rate'
+// fraction of the requests, or nil if 'rate' is 0. 'seed' seeds the choice of the
+// requests failed so it's reproducible.
+func NewFailureInjector(rate float64, seed int64) (*FailureInjector, error) {
+	if rate == 0 {
+		return nil, nil
+	}
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("the failure injection rate, %v, must be greater than 0 and no more than 1", rate)
+	}
+	return &FailureInjector{rate: rate, rnd: rand.New(rand.NewSource(seed))}, nil
+}
+
+// fail returns true if the next request is to be failed. 'f' may be nil.
+func (f *FailureInjector) fail() bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rnd.Float64() < f.rate
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestNewFailureInjector(t *testing.T) {
+	tests := []struct {
+		rate    float64
+		wantNil bool
+		wantErr bool
+	}{
+		{rate: 0, wantNil: true},
+		{rate: 0.5},
+		{rate: 1},
+		{rate: -0.1, wantErr: true},
+		{rate: 1.5, wantErr: true},
+	}
+	for _, tc := range tests {
+		f, err := NewFailureInjector(tc.rate, 1)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("rate %v: expected an error to be %t, got %v", tc.rate, tc.wantErr, err)
+		}
+		if !tc.wantErr && (f == nil) != tc.wantNil {
+			t.Errorf("rate %v: expected a nil injector to be %t, got %+v", tc.rate, tc.wantNil, f)
+		}
+	}
+}
+
+// TestFailureInjection verifies the injected failures aren't sent and are reported as
+// failed requests
+func TestFailureInjection(t *testing.T) {
+	var received int64
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&received, 1)
+	}))
+	defer testSrv.Close()
+
+	injector, err := NewFailureInjector(0.5, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	numRqsts := 1000
+	ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet}
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}, FailureInjector: injector}
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	if len(responses) != numRqsts {
+		t.Fatalf("expected %d responses, got %d", numRqsts, len(responses))
+	}
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	injected := runResults.EndpointDetails[ep.URL].ErrorCategories[api.ErrCategoryInjectedFailure]
+	if injected < 450 || injected > 550 {
+		t.Errorf("expected about half of the %d requests to fail, got %d", numRqsts, injected)
+	}
+	if runResults.RunSummary.ErrorCategories[api.ErrCategoryInjectedFailure] != injected {
+		t.Errorf("expected the run's %d injected failures to be reported, got %v", injected, runResults.RunSummary.ErrorCategories)
+	}
+	if sent := atomic.LoadInt64(&received); sent+injected != int64(numRqsts) {
+		t.Errorf("expected the %d requests that weren't failed to be sent, %d were", int64(numRqsts)-injected, sent)
+	}
+	if ok := runResults.EndpointSummary[ep.URL][http.MethodGet]; ok != numRqsts-int(injected) {
+		t.Errorf("expected %d successful requests, got %d", numRqsts-int(injected), ok)
+	}
+}
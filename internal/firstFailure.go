@@ -0,0 +1,103 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// FirstFailureTracker records the first failed request of a run configured with
+// api.LoadTestConfig.StopOnFirstFailure. It's shared by all requestors, the first
+// to record a failure ends the run.
+type FirstFailureTracker struct {
+	mux     sync.Mutex
+	failure *api.FirstFailure
+}
+
+// NewFirstFailureTracker returns a FirstFailureTracker
+func NewFirstFailureTracker() *FirstFailureTracker {
+	return &FirstFailureTracker{}
+}
+
+// record records 'resp', a failed response, and 'body', the start of its response
+// body, if no other failure has been recorded. It returns true if it was recorded.
+func (t *FirstFailureTracker) record(resp Response, body []byte) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.failure != nil {
+		return false
+	}
+
+	failure := api.FirstFailure{
+		URL:                  resp.Endpoint.URL,
+		Method:               resp.Endpoint.Method,
+		HTTPStatus:           resp.HTTPStatus,
+		ResponseHeaders:      resp.Header,
+		ErrCategory:          resp.ErrCategory,
+		RequestDurationNanos: resp.RequestDuration,
+		Retries:              resp.Retries,
+	}
+	if resp.Rqst != nil {
+		failure.URL = resp.Rqst.URL
+		failure.EndpointURL = resp.Rqst.EndpointURL
+		failure.RequestHeaders = resp.Rqst.Headers
+		failure.RequestBody = resp.Rqst.Body
+	}
+	if len(body) > replayBodyLimit {
+		body = body[:replayBodyLimit]
+	}
+	failure.ResponseBody = string(body)
+	if resp.Err != nil {
+		failure.Error = resp.Err.Error()
+	}
+	t.failure = &failure
+	return true
+}
+
+// Failure returns the failure recorded, or nil if there wasn't one
+func (t *FirstFailureTracker) Failure() *api.FirstFailure {
+	if t == nil {
+		return nil
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.failure
+}
+
+// stopped returns true if a failure has been recorded, in which case no more requests
+// are sent. 't' may be nil.
+func (t *FirstFailureTracker) stopped() bool {
+	return t.Failure() != nil
+}
+
+// warning describes the failure that ended the run, if there was one
+func (t *FirstFailureTracker) warning() string {
+	failure := t.Failure()
+	if failure == nil {
+		return ""
+	}
+	return fmt.Sprintf("the run was stopped by the first failure, %s %s, see FirstFailure", failure.Method, failure.URL)
+}
+
+// stopOnFailure ends the run, if the Requestor is configured to stop on the first
+// failure and 'response' failed. 'body' is the start of the response's body, if it
+// was retained. It returns true if the run was ended and no more requests should be
+// made.
+func (r Requestor) stopOnFailure(response Response, body []byte) bool {
+	if r.FirstFailure == nil || !response.failed() {
+		return false
+	}
+	if r.FirstFailure.record(response, body) {
+		log.Error().Msgf("Requestor: request %s %s failed, stopping the run", response.Endpoint.Method, response.Endpoint.URL)
+	}
+	if r.Cancel != nil {
+		r.Cancel()
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestStopOnFirstFailure verifies the run ends promptly when a request fails, that no
+// more requests are sent once the failure is recorded, and that the failed request
+// and its response are reported
+func TestStopOnFirstFailure(t *testing.T) {
+	failOn := int64(10)
+	firstFailure := NewFirstFailureTracker()
+	var rqsts, rqstsAfterStop int64
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if firstFailure.stopped() {
+			atomic.AddInt64(&rqstsAfterStop, 1)
+		}
+		if atomic.AddInt64(&rqsts, 1) == failOn {
+			w.Header().Set("X-Debug", "broken")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("something broke"))
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer testSrv.Close()
+
+	concurrency := 20
+	ep := api.Endpoint{URL: testSrv.URL + "/{{ print \"failing\" }}", Method: http.MethodPost, RqstPercent: 100,
+		RqstBody: "payload", Headers: map[string]string{"X-Test": "first-failure"}}
+	rh := runUntilFirstFailure(t, firstFailure, ep, concurrency)
+	// Each of the other requestors may have checked the failure hadn't been recorded
+	// just before it was, and so send one more request
+	if after := atomic.LoadInt64(&rqstsAfterStop); after > int64(concurrency-1) {
+		t.Errorf("expected at most %d requests after the failure was recorded, got %d", concurrency-1, after)
+	}
+
+	// The requests cancelled when the run was stopped didn't fail
+	if categories := rh.Results.RunSummary.ErrorCategories; len(categories) != 0 {
+		t.Errorf("expected only the failed response to be reported as failed, got %v", categories)
+	}
+
+	failure := rh.Results.RunSummary.FirstFailure
+	if failure == nil {
+		t.Fatal("expected the first failure to be reported")
+	}
+	if failure.HTTPStatus != http.StatusInternalServerError || failure.ResponseBody != "something broke" ||
+		failure.ResponseHeaders.Get("X-Debug") != "broken" {
+		t.Errorf("expected the failed response to be captured, got %+v", failure)
+	}
+	if failure.Method != http.MethodPost || failure.EndpointURL != ep.URL || failure.URL != testSrv.URL+"/failing" || failure.RequestBody != "payload" || failure.RequestHeaders["X-Test"] != "first-failure" {
+		t.Errorf("expected the failed request to be captured as it was sent, got %+v", failure)
+	}
+	if len(rh.Results.RunSummary.Warnings) == 0 {
+		t.Error("expected a warning that the run was stopped by the first failure")
+	}
+}
+
+// TestStopOnFirstConnectionFailure verifies the run also ends when a request fails
+// because its connection was closed before it got a response
+func TestStopOnFirstConnectionFailure(t *testing.T) {
+	failOn := int64(5)
+	firstFailure := NewFirstFailureTracker()
+	var rqsts, rqstsAfterStop int64
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if firstFailure.stopped() {
+			atomic.AddInt64(&rqstsAfterStop, 1)
+		}
+		if atomic.AddInt64(&rqsts, 1) == failOn {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer testSrv.Close()
+
+	concurrency := 4
+	ep := api.Endpoint{URL: testSrv.URL + "/closing", Method: http.MethodPost, RqstPercent: 100, RqstBody: "payload"}
+	rh := runUntilFirstFailure(t, firstFailure, ep, concurrency)
+	if after := atomic.LoadInt64(&rqstsAfterStop); after > int64(concurrency-1) {
+		t.Errorf("expected at most %d requests after the failure was recorded, got %d", concurrency-1, after)
+	}
+	failure := rh.Results.RunSummary.FirstFailure
+	if failure == nil || failure.ErrCategory != api.ErrCategoryConnection || failure.Error == "" || failure.RequestBody != "payload" {
+		t.Fatalf("expected the connection failure to be reported as the first failure, got %+v", failure)
+	}
+	categories := rh.Results.RunSummary.ErrorCategories
+	if len(categories) != 1 || categories[api.ErrCategoryConnection] != 1 {
+		t.Errorf("expected only the connection failure to be reported, got %v", categories)
+	}
+}
+
+// runUntilFirstFailure runs 'concurrency' requestors sending requests to 'ep' until
+// 'firstFailure' stops the run, and returns the run's ResponseHandler. It fails the
+// test unless the run stops promptly.
+func runUntilFirstFailure(t *testing.T, firstFailure *FirstFailureTracker, ep api.Endpoint, concurrency int) *ResponseHandler {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	rqstr := Requestor{
+		Ctx:          ctx,
+		ResponseC:    make(chan Response, concurrency),
+		Client:       http.Client{},
+		Cancel:       cancel,
+		FirstFailure: firstFailure,
+	}
+	scheduler, err := NewScheduler(concurrency, 0, 0, 10000, []api.Endpoint{ep}, rqstr)
+	if err != nil {
+		t.Fatalf("unexpected error creating scheduler: %s", err)
+	}
+	rh := &ResponseHandler{
+		OutputType:   JSON,
+		ResponseC:    rqstr.ResponseC,
+		DoneC:        make(chan interface{}),
+		FirstFailure: firstFailure,
+		Output:       &bytes.Buffer{},
+	}
+	go rh.Start()
+	start := time.Now()
+	go scheduler.Start()
+
+	select {
+	case <-rh.DoneC:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the run to stop after the first failure")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the run to stop promptly, it took %s", elapsed)
+	}
+	return rh
+}
+
+// TestStopOnFirstFailureDisabled verifies failures don't end the run unless it's
+// configured to stop on the first failure
+func TestStopOnFirstFailureDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rqstr := Requestor{Ctx: ctx, Cancel: cancel}
+	resp := Response{Endpoint: api.Endpoint{URL: "http://someurl", Method: http.MethodGet}, HTTPStatus: http.StatusBadGateway}
+	if rqstr.stopOnFailure(resp, nil) || ctx.Err() != nil {
+		t.Error("expected the run not to be stopped")
+	}
+
+	rqstr.FirstFailure = NewFirstFailureTracker()
+	if rqstr.stopOnFailure(Response{HTTPStatus: http.StatusOK}, nil) || ctx.Err() != nil {
+		t.Error("expected a successful response not to stop the run")
+	}
+	if !rqstr.stopOnFailure(resp, nil) || ctx.Err() == nil {
+		t.Error("expected a failed response to stop the run")
+	}
+	if rqstr.stopOnFailure(Response{HTTPStatus: http.StatusNotFound}, nil); rqstr.FirstFailure.Failure().HTTPStatus != http.StatusBadGateway {
+		t.Errorf("expected only the first failure to be recorded, got %+v", rqstr.FirstFailure.Failure())
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// gcPauseP99Fraction is the fraction of the run's P99 latency that the longest GC
+// pause must exceed for a warning to be reported
+const gcPauseP99Fraction = 0.1
+
+// gcTracker tracks heyyall's garbage collections during the run. The GC pauses are
+// sampled periodically since the runtime only keeps the most recent pauses. It's only
+// used by the ResponseHandler's goroutine.
+type gcTracker struct {
+	// stats is reused by each sample
+	stats      debug.GCStats
+	startNumGC int64
+	startPause time.Duration
+	lastNumGC  int64
+	maxPause   time.Duration
+}
+
+// newGCTracker returns a gcTracker tracking the GCs from now on
+func newGCTracker() *gcTracker {
+	t := &gcTracker{}
+	debug.ReadGCStats(&t.stats)
+	t.startNumGC, t.lastNumGC, t.startPause = t.stats.NumGC, t.stats.NumGC, t.stats.PauseTotal
+	return t
+}
+
+// sample records the pauses of the GCs since the previous sample
+func (t *gcTracker) sample() {
+	debug.ReadGCStats(&t.stats)
+	// stats.Pause is the most recent first
+	for i := 0; i < int(t.stats.NumGC-t.lastNumGC) && i < len(t.stats.Pause); i++ {
+		if t.stats.Pause[i] > t.maxPause {
+			t.maxPause = t.stats.Pause[i]
+		}
+	}
+	t.lastNumGC = t.stats.NumGC
+}
+
+// gcStats returns the GCStats of the GCs tracked
+func (t *gcTracker) gcStats() api.GCStats {
+	t.sample()
+	return api.GCStats{
+		NumGC:           t.stats.NumGC - t.startNumGC,
+		TotalPauseNanos: t.stats.PauseTotal - t.startPause,
+		MaxPauseNanos:   t.maxPause,
+	}
+}
+
+// gcWarning returns a warning if the longest of 'gc's pauses is a significant fraction
+// of 'p99', the run's P99 latency, since the GC pauses may then be contributing to the
+// tail latency, or "" if it isn't
+func gcWarning(gc api.GCStats, p99 time.Duration) string {
+	if p99 <= 0 || float64(gc.MaxPauseNanos) <= gcPauseP99Fraction*float64(p99) {
+		return ""
+	}
+	return fmt.Sprintf("heyyall's longest GC pause, %s, is more than %.0f%% of the P99 latency, %s, heyyall's GC may be contributing to the tail latency, see -gc-percent and -gc-ballast",
+		gc.MaxPauseNanos, gcPauseP99Fraction*100, p99.Round(time.Microsecond))
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestGCTracker verifies only the GCs after the tracker is created are counted and
+// that their pauses are tracked
+func TestGCTracker(t *testing.T) {
+	runtime.GC()
+	tracker := newGCTracker()
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+		tracker.sample()
+	}
+	runtime.GC()
+
+	gc := tracker.gcStats()
+	// Other GCs may be triggered by the test's allocations
+	if gc.NumGC < 4 || gc.NumGC > 10 {
+		t.Errorf("expected about 4 GCs, got %d", gc.NumGC)
+	}
+	if gc.MaxPauseNanos <= 0 || gc.TotalPauseNanos < gc.MaxPauseNanos {
+		t.Errorf("expected a max pause greater than 0 and no more than the total pause, %s, got %s",
+			gc.TotalPauseNanos, gc.MaxPauseNanos)
+	}
+}
+
+// TestGCWarning verifies a warning is reported only when the longest GC pause is a
+// significant fraction of the P99 latency
+func TestGCWarning(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxPause      time.Duration
+		p99           time.Duration
+		expectWarning bool
+	}{
+		{name: "Short pause", maxPause: 100 * time.Microsecond, p99: 10 * time.Millisecond},
+		{name: "Long pause", maxPause: 5 * time.Millisecond, p99: 10 * time.Millisecond, expectWarning: true},
+		{name: "No requests", maxPause: 5 * time.Millisecond},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			warning := gcWarning(api.GCStats{NumGC: 1, MaxPauseNanos: tc.maxPause, TotalPauseNanos: tc.maxPause}, tc.p99)
+			if (warning != "") != tc.expectWarning {
+				t.Errorf("expected a warning: %t, got %q", tc.expectWarning, warning)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// The thresholds for warning that a GroupByHeader value is slower than the others.
+// Only values with at least minGroupRqsts successful requests are compared, and a
+// value's P95 must be more than slowGroupFactor times the median P95 of the others.
+const (
+	minGroupRqsts   = 20
+	slowGroupFactor = 2.0
+)
+
+// accumulateGroupByHeader adds 'resp' to the stats of its GroupByHeader value in
+// 'epDetail', if its endpoint has a GroupByHeader. Abandoned requests never
+// completed so, as elsewhere, they aren't included.
+func accumulateGroupByHeader(resp Response, epDetail *api.EndpointDetail) {
+	name := resp.Endpoint.GroupByHeader
+	if name == "" || resp.AbandonedSlow {
+		return
+	}
+	epDetail.GroupByHeader = name
+	if epDetail.ByHeaderValue == nil {
+		epDetail.ByHeaderValue = make(map[string]*api.HeaderValueStats)
+	}
+
+	value := resp.Header.Get(name)
+	if value == "" {
+		value = api.GroupByHeaderNone
+	}
+	addHeaderValueRqst(epDetail.ByHeaderValue, value, resp)
+}
+
+// addHeaderValueRqst adds 'resp' to the stats of 'value' in 'byValue'. Only the first
+// api.MaxGroupByHeaderValues values are broken down, 'resp' is added to the
+// api.GroupByHeaderOther stats if 'value' isn't one of them.
+func addHeaderValueRqst(byValue map[string]*api.HeaderValueStats, value string, resp Response) {
+	stats, ok := byValue[value]
+	if !ok {
+		if value != api.GroupByHeaderNone && headerValueCount(byValue) >= api.MaxGroupByHeaderValues {
+			value = api.GroupByHeaderOther
+			stats, ok = byValue[value]
+		}
+		if !ok {
+			stats = &api.HeaderValueStats{}
+			byValue[value] = stats
+		}
+	}
+
+	if resp.ErrCategory != "" {
+		stats.Errors++
+		return
+	}
+	if resp.HTTPStatus >= 500 {
+		stats.ServerErrors++
+	}
+	updateRqstStats(&stats.RqstStats, resp.RequestDuration)
+}
+
+// headerValueCount returns the number of header values 'byValue' is broken down by,
+// not including the GroupByHeaderNone and GroupByHeaderOther groups
+func headerValueCount(byValue map[string]*api.HeaderValueStats) int {
+	n := len(byValue)
+	for _, group := range []string{api.GroupByHeaderNone, api.GroupByHeaderOther} {
+		if _, ok := byValue[group]; ok {
+			n--
+		}
+	}
+	return n
+}
+
+// finishGroupByHeader calculates the averages of 'epDetail's ByHeaderValue stats. It
+// returns a warning for each value whose P95 dramatically exceeds that of the others,
+// e.g., because the server instance it identifies is overloaded.
+func finishGroupByHeader(epDetail *api.EndpointDetail) []string {
+	type groupP95 struct {
+		value string
+		p95   time.Duration
+	}
+	finishHeaderValueStats(epDetail.ByHeaderValue)
+	var p95s []groupP95
+	for value, stats := range epDetail.ByHeaderValue {
+		if stats.TotalRqsts >= minGroupRqsts {
+			p95 := calcPercentiles(95, stats.TimingResultsNanos)
+			p95s = append(p95s, groupP95{value: value, p95: p95})
+		}
+	}
+	if len(p95s) < 2 {
+		return nil
+	}
+	sort.Slice(p95s, func(i, j int) bool { return p95s[i].value < p95s[j].value })
+
+	var warnings []string
+	for i, group := range p95s {
+		others := make([]time.Duration, 0, len(p95s)-1)
+		for j, other := range p95s {
+			if j != i {
+				others = append(others, other.p95)
+			}
+		}
+		othersP95 := calcPercentiles(50, others)
+		if othersP95 <= 0 || float64(group.p95) <= slowGroupFactor*float64(othersP95) {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s: requests whose response had %s %s have a P95 of %s, %.1fx the median P95, %s, of the other %s values",
+			epDetail.URL, epDetail.GroupByHeader, group.value, group.p95.Round(time.Millisecond),
+			float64(group.p95)/float64(othersP95), othersP95.Round(time.Millisecond), epDetail.GroupByHeader))
+	}
+	return warnings
+}
+
+// finishHeaderValueStats calculates the averages of the stats in 'byValue'
+func finishHeaderValueStats(byValue map[string]*api.HeaderValueStats) {
+	for _, stats := range byValue {
+		if stats.TotalRqsts > 0 {
+			stats.AvgRqstDurationNanos = stats.TotalRequestDurationNanos / time.Duration(stats.TotalRqsts)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// groupByResponse returns a response to a request to 'url' whose X-Served-By header
+// is 'value', or has no X-Served-By header if 'value' is empty
+func groupByResponse(url, value string, status int, d time.Duration) Response {
+	header := http.Header{}
+	if value != "" {
+		header.Set("X-Served-By", value)
+	}
+	return Response{
+		Endpoint:        api.Endpoint{URL: url, Method: http.MethodGet, GroupByHeader: "X-Served-By"},
+		HTTPStatus:      status,
+		Header:          header,
+		RequestDuration: d,
+	}
+}
+
+// TestGroupByHeader verifies an endpoint's stats are broken down by its
+// GroupByHeader's values, and that a value with a dramatically higher P95 is warned of
+func TestGroupByHeader(t *testing.T) {
+	url := "http://somehost.com/pods"
+	var responses []Response
+	for i := 0; i < minGroupRqsts; i++ {
+		responses = append(responses,
+			groupByResponse(url, "pod-a", http.StatusOK, 10*time.Millisecond),
+			groupByResponse(url, "pod-b", http.StatusOK, 12*time.Millisecond),
+			groupByResponse(url, "pod-c", http.StatusOK, 100*time.Millisecond))
+	}
+	responses = append(responses,
+		groupByResponse(url, "pod-a", http.StatusServiceUnavailable, 10*time.Millisecond),
+		groupByResponse(url, "", http.StatusOK, 10*time.Millisecond),
+		Response{
+			Endpoint:        api.Endpoint{URL: url, Method: http.MethodGet, GroupByHeader: "X-Served-By"},
+			ErrCategory:     api.ErrCategoryConnection,
+			RequestDuration: time.Millisecond,
+		})
+
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	epDetail := runResults.EndpointDetails[url]
+	if epDetail.GroupByHeader != "X-Served-By" {
+		t.Errorf("expected the breakdown to be by X-Served-By, got %q", epDetail.GroupByHeader)
+	}
+	expected := map[string]api.HeaderValueStats{
+		"pod-a":               {ServerErrors: 1, RqstStats: api.RqstStats{TotalRqsts: minGroupRqsts + 1, AvgRqstDurationNanos: 10 * time.Millisecond}},
+		"pod-b":               {RqstStats: api.RqstStats{TotalRqsts: minGroupRqsts, AvgRqstDurationNanos: 12 * time.Millisecond}},
+		"pod-c":               {RqstStats: api.RqstStats{TotalRqsts: minGroupRqsts, AvgRqstDurationNanos: 100 * time.Millisecond}},
+		api.GroupByHeaderNone: {Errors: 1, RqstStats: api.RqstStats{TotalRqsts: 1, AvgRqstDurationNanos: 10 * time.Millisecond}},
+	}
+	if len(epDetail.ByHeaderValue) != len(expected) {
+		t.Errorf("expected the values %v, got %+v", expected, epDetail.ByHeaderValue)
+	}
+	for value, exp := range expected {
+		stats := epDetail.ByHeaderValue[value]
+		if stats == nil || stats.TotalRqsts != exp.TotalRqsts || stats.AvgRqstDurationNanos != exp.AvgRqstDurationNanos ||
+			stats.Errors != exp.Errors || stats.ServerErrors != exp.ServerErrors {
+			t.Errorf("expected %s's stats to be %+v, got %+v", value, exp, stats)
+		}
+	}
+
+	var warnings []string
+	for _, warning := range runResults.RunSummary.Warnings {
+		if strings.Contains(warning, "X-Served-By") {
+			warnings = append(warnings, warning)
+		}
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "X-Served-By pod-c have a P95 of 100ms") {
+		t.Errorf("expected a single warning that pod-c is slow, got %q", warnings)
+	}
+}
+
+// TestGroupByHeaderCardinality verifies that only the first MaxGroupByHeaderValues
+// values are broken down, the others are grouped together
+func TestGroupByHeaderCardinality(t *testing.T) {
+	url := "http://somehost.com/unique"
+	extra := 5
+	var responses []Response
+	for i := 0; i < api.MaxGroupByHeaderValues+extra; i++ {
+		responses = append(responses, groupByResponse(url, fmt.Sprintf("id-%d", i), http.StatusOK, time.Millisecond))
+	}
+	responses = append(responses, groupByResponse(url, "", http.StatusOK, time.Millisecond))
+
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	byValue := runResults.EndpointDetails[url].ByHeaderValue
+	if len(byValue) != api.MaxGroupByHeaderValues+2 {
+		t.Errorf("expected %d values plus %s and %s, got %d groups", api.MaxGroupByHeaderValues,
+			api.GroupByHeaderNone, api.GroupByHeaderOther, len(byValue))
+	}
+	if other := byValue[api.GroupByHeaderOther]; other == nil || other.TotalRqsts != int64(extra) {
+		t.Errorf("expected %d requests in %s, got %+v", extra, api.GroupByHeaderOther, other)
+	}
+	if none := byValue[api.GroupByHeaderNone]; none == nil || none.TotalRqsts != 1 {
+		t.Errorf("expected 1 request in %s, got %+v", api.GroupByHeaderNone, none)
+	}
+}
+
+// TestGroupByHeaderRequestor verifies the requestor passes an endpoint's
+// GroupByHeader on with its responses
+func TestGroupByHeaderRequestor(t *testing.T) {
+	var count int64
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Served-By", fmt.Sprintf("pod-%d", atomic.AddInt64(&count, 1)%2))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testSrv.Close()
+
+	numRqsts := 10
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}}
+	ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet, GroupByHeader: "x-served-by"}
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	byValue := runResults.EndpointDetails[ep.URL].ByHeaderValue
+	for _, value := range []string{"pod-0", "pod-1"} {
+		if stats := byValue[value]; stats == nil || stats.TotalRqsts != int64(numRqsts/2) {
+			t.Errorf("expected %d requests served by %s, got %+v", numRqsts/2, value, stats)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+const (
+	// handlerLagDepthInterval is how often the depth of the ResponseHandler's queue
+	// is sampled
+	handlerLagDepthInterval = 100 * time.Millisecond
+	// handlerLagTimingRate is how often the time spent processing a response is
+	// sampled, e.g., 16 times the processing of every 16th response
+	handlerLagTimingRate = 16
+	// saturatedQueueFraction is the fraction of its capacity at which the queue is
+	// considered saturated
+	saturatedQueueFraction = 0.9
+	// sustainedSaturation is how long the queue must be saturated before a warning
+	// is reported
+	sustainedSaturation = time.Second
+)
+
+// handlerLagTracker tracks how well the ResponseHandler keeps up with the responses.
+// It's only used by the ResponseHandler's goroutine.
+type handlerLagTracker struct {
+	capacity     int
+	depthSamples int64
+	depthTotal   int64
+	maxDepth     int
+	// saturatedSince is when the queue was first seen saturated during the current
+	// period of saturation, it's zero if the queue isn't saturated
+	saturatedSince    time.Time
+	longestSaturation time.Duration
+	// received is the number of responses received
+	received        int64
+	timed           int64
+	processingTotal time.Duration
+	processingMax   time.Duration
+}
+
+// newHandlerLagTracker returns a handlerLagTracker for a queue of 'capacity' responses
+func newHandlerLagTracker(capacity int) *handlerLagTracker {
+	return &handlerLagTracker{capacity: capacity}
+}
+
+// sampleDepth records that 'depth' responses were queued at 'now'
+func (t *handlerLagTracker) sampleDepth(depth int, now time.Time) {
+	t.depthSamples++
+	t.depthTotal += int64(depth)
+	if depth > t.maxDepth {
+		t.maxDepth = depth
+	}
+
+	if t.capacity == 0 || float64(depth) < saturatedQueueFraction*float64(t.capacity) {
+		t.saturatedSince = time.Time{}
+		return
+	}
+	if t.saturatedSince.IsZero() {
+		t.saturatedSince = now
+	}
+	if saturated := now.Sub(t.saturatedSince); saturated > t.longestSaturation {
+		t.longestSaturation = saturated
+	}
+}
+
+// receive records that a response was received. It returns true if the time spent
+// processing the response should be recorded with recordProcessing.
+func (t *handlerLagTracker) receive() bool {
+	t.received++
+	return t.received%handlerLagTimingRate == 1
+}
+
+// recordProcessing records that processing a response took 'd'
+func (t *handlerLagTracker) recordProcessing(d time.Duration) {
+	t.timed++
+	t.processingTotal += d
+	if d > t.processingMax {
+		t.processingMax = d
+	}
+}
+
+// lag returns the HandlerLag tracked, 'summarize' is how long the responses took
+// to summarize
+func (t *handlerLagTracker) lag(summarize time.Duration) api.HandlerLag {
+	lag := api.HandlerLag{
+		QueueCapacity:         t.capacity,
+		MaxQueueDepth:         t.maxDepth,
+		LongestSaturatedNanos: t.longestSaturation,
+		MaxProcessingNanos:    t.processingMax,
+		SummarizeNanos:        summarize,
+	}
+	if t.depthSamples > 0 {
+		lag.AvgQueueDepth = float64(t.depthTotal) / float64(t.depthSamples)
+	}
+	if t.timed > 0 {
+		lag.AvgProcessingNanos = t.processingTotal / time.Duration(t.timed)
+	}
+	return lag
+}
+
+// warning returns a warning if the queue was saturated for a sustained period, or
+// "" if it wasn't
+func (t *handlerLagTracker) warning() string {
+	if t.longestSaturation < sustainedSaturation {
+		return ""
+	}
+	return fmt.Sprintf("the response handler's queue was at least %.0f%% full for %s, heyyall didn't keep up with the responses so the latencies may include queuing in heyyall",
+		saturatedQueueFraction*100, t.longestSaturation.Round(time.Millisecond))
+}
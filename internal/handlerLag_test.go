@@ -0,0 +1,95 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestHandlerLagTracker verifies the queue depth and processing time samples are
+// summarized and a warning is reported only when the queue is saturated for a
+// sustained period
+func TestHandlerLagTracker(t *testing.T) {
+	tests := []struct {
+		name            string
+		depths          []int
+		expectedMax     int
+		expectedAvg     float64
+		expectedLongest time.Duration
+		expectWarning   bool
+	}{
+		{name: "Keeping up", depths: []int{0, 2, 1, 0}, expectedMax: 2, expectedAvg: 0.75},
+		{name: "Brief saturation", depths: []int{10, 10, 0, 9, 9, 9}, expectedMax: 10, expectedAvg: 47.0 / 6,
+			expectedLongest: 2 * handlerLagDepthInterval},
+		{name: "Sustained saturation", depths: []int{2, 9, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 3},
+			expectedMax: 10, expectedAvg: 134.0 / 15, expectedLongest: 12 * handlerLagDepthInterval, expectWarning: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := newHandlerLagTracker(10)
+			now := time.Now()
+			for _, depth := range tc.depths {
+				tracker.sampleDepth(depth, now)
+				now = now.Add(handlerLagDepthInterval)
+			}
+			for i := 0; i < 2*handlerLagTimingRate; i++ {
+				if tracker.receive() {
+					tracker.recordProcessing(time.Duration(i+1) * time.Microsecond)
+				}
+			}
+
+			lag := tracker.lag(time.Second)
+			expected := api.HandlerLag{
+				QueueCapacity:         10,
+				MaxQueueDepth:         tc.expectedMax,
+				AvgQueueDepth:         tc.expectedAvg,
+				LongestSaturatedNanos: tc.expectedLongest,
+				AvgProcessingNanos:    (1 + handlerLagTimingRate + 1) * time.Microsecond / 2,
+				MaxProcessingNanos:    (handlerLagTimingRate + 1) * time.Microsecond,
+				SummarizeNanos:        time.Second,
+			}
+			if lag != expected {
+				t.Errorf("expected handler lag %+v, got %+v", expected, lag)
+			}
+			if warning := tracker.warning(); (warning != "") != tc.expectWarning {
+				t.Errorf("expected a warning to be %t, got %q", tc.expectWarning, warning)
+			}
+		})
+	}
+}
+
+// TestGeneratorStats verifies the handler's lag is reported with the run's results
+func TestGeneratorStats(t *testing.T) {
+	var out bytes.Buffer
+	rh := ResponseHandler{
+		OutputType: JSON,
+		ResponseC:  make(chan Response, 8),
+		DoneC:      make(chan interface{}),
+		Output:     &out,
+	}
+	go rh.Start()
+	for i := 0; i < 5; i++ {
+		rh.ResponseC <- Response{HTTPStatus: http.StatusOK, RequestDuration: time.Millisecond,
+			Endpoint: api.Endpoint{URL: "http://someurl/1", Method: http.MethodGet}}
+	}
+	close(rh.ResponseC)
+	<-rh.DoneC
+
+	if rh.Results == nil || rh.Results.GeneratorStats == nil {
+		t.Fatalf("expected the results to include GeneratorStats")
+	}
+	if capacity := rh.Results.GeneratorStats.HandlerLag.QueueCapacity; capacity != 8 {
+		t.Errorf("expected a queue capacity of 8, got %d", capacity)
+	}
+	if !bytes.Contains(out.Bytes(), []byte(`"HandlerLag"`)) {
+		t.Errorf("expected the JSON output to include the HandlerLag, got %s", out.String())
+	}
+}
@@ -0,0 +1,380 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// harBodyLimit is the most of each request and response body included in a HAR entry.
+// Longer bodies are truncated, with a comment saying so, to keep the file a size
+// browser devtools can load.
+const harBodyLimit = 64 * 1024
+
+// harFailureSamples is the number of failed requests of each endpoint captured in
+// addition to the sampled requests, so the failures are represented even when
+// they're rare
+const harFailureSamples = 5
+
+// harLog is a HAR 1.2 document, see http://www.softwareishard.com/blog/har-12-spec/
+type harLog struct {
+	Log harLogBody `json:"log"`
+}
+
+type harLogBody struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	// Comment is the error of a failed request, if it has one
+	Comment string `json:"comment,omitempty"`
+	// Endpoint is the URL the request's results are reported under in the summary
+	Endpoint string `json:"_endpoint"`
+	// Failed is true if the request was counted as a failure in the summary
+	Failed bool `json:"_failed"`
+
+	// started orders the entries
+	started time.Time
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// harTimings are the phases of a request, in milliseconds. Phases that don't apply,
+// e.g., the DNS lookup of a request sent on a reused connection, are -1.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// harTimes are the times traced while sending a request, from which its harTimings
+// are derived. Times that weren't traced, or were traced for an earlier request,
+// i.e., before 'getConn', are ignored.
+type harTimes struct {
+	start, getConn, connWaitEnd, dnsStart, dnsDone, connectStart, tlsStart, tlsDone time.Time
+	gotConn, wroteRqst, gotResp, end                                                time.Time
+	reused                                                                          bool
+}
+
+// millis returns the time from 'from' to 'to' in milliseconds, or -1 if either
+// wasn't traced for the request, i.e., it's before 'getConn'
+func (t harTimes) millis(from, to time.Time) float64 {
+	if t.getConn.IsZero() || from.Before(t.getConn) || to.Before(from) {
+		return -1
+	}
+	return float64(to.Sub(from)) / float64(time.Millisecond)
+}
+
+// timings returns the HAR timings of the request. Send, Wait, and Receive are
+// required so they're 0, rather than -1, when they weren't traced.
+func (t harTimes) timings() harTimings {
+	nonNegative := func(ms float64) float64 {
+		if ms < 0 {
+			return 0
+		}
+		return ms
+	}
+	timings := harTimings{
+		Blocked: t.millis(t.getConn, t.connWaitEnd),
+		DNS:     t.millis(t.dnsStart, t.dnsDone),
+		Connect: -1,
+		SSL:     t.millis(t.tlsStart, t.tlsDone),
+		Send:    nonNegative(t.millis(t.gotConn, t.wroteRqst)),
+		Wait:    nonNegative(t.millis(t.wroteRqst, t.gotResp)),
+		Receive: nonNegative(t.millis(t.gotResp, t.end)),
+	}
+	if !t.reused {
+		// The connect time includes the TLS handshake, as the HAR spec requires
+		timings.Connect = t.millis(t.connectStart, t.gotConn)
+	}
+	return timings
+}
+
+// total returns the entry's time, the sum of the timings that apply. SSL is part of
+// Connect so it isn't added again.
+func (t harTimings) total() float64 {
+	total := 0.0
+	for _, ms := range []float64{t.Blocked, t.DNS, t.Connect, t.Send, t.Wait, t.Receive} {
+		if ms > 0 {
+			total += ms
+		}
+	}
+	return total
+}
+
+// harSamples are the entries sampled for an endpoint
+type harSamples struct {
+	// seen and failuresSeen are the number of successful and failed requests the
+	// entries were sampled from
+	seen, failuresSeen int64
+	entries, failures  []harEntry
+}
+
+// HARRecorder captures a sample of each endpoint's requests and responses, with their
+// timings, in HAR format, e.g., to be analyzed in browser devtools. Up to the sample
+// size of successful requests, and harFailureSamples failed requests, of each endpoint are
+// sampled uniformly from those of the run. It's shared by all requestors.
+type HARRecorder struct {
+	sample int
+
+	mux     sync.Mutex
+	rnd     *rand.Rand
+	samples map[string]*harSamples
+}
+
+// NewHARRecorder returns a HARRecorder sampling up to 'sample' successful requests of
+// each endpoint. 'seed' seeds the sampling.
+func NewHARRecorder(sample int, seed int64) (*HARRecorder, error) {
+	if sample < 1 {
+		return nil, fmt.Errorf("-har-sample %d is invalid, it must be at least 1", sample)
+	}
+	return &HARRecorder{sample: sample, rnd: rand.New(rand.NewSource(seed)), samples: make(map[string]*harSamples)}, nil
+}
+
+// reservoirIndex returns the index in a reservoir of 'n' of up to 'size' entries that
+// the 'seen'th candidate is sampled into, 'n' to add it, or -1 if it isn't sampled
+func (h *HARRecorder) reservoirIndex(n, size int, seen int64) int {
+	if n < size {
+		return n
+	}
+	if i := h.rnd.Int63n(seen); i < int64(size) {
+		return int(i)
+	}
+	return -1
+}
+
+// record samples 'resp', and its response body 'body', if it was retained. 'times'
+// are the times traced while sending it.
+func (h *HARRecorder) record(resp Response, body []byte, times harTimes) {
+	if h == nil || resp.Rqst == nil {
+		return
+	}
+	key := resp.Endpoint.Method + " " + resp.Endpoint.URL
+	failed := resp.failed()
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	s, ok := h.samples[key]
+	if !ok {
+		s = &harSamples{}
+		h.samples[key] = s
+	}
+	reservoir, size, seen := &s.entries, h.sample, &s.seen
+	if failed {
+		reservoir, size, seen = &s.failures, harFailureSamples, &s.failuresSeen
+	}
+	*seen++
+	i := h.reservoirIndex(len(*reservoir), size, *seen)
+	if i < 0 {
+		return
+	}
+	entry := newHAREntry(resp, body, times)
+	if i == len(*reservoir) {
+		*reservoir = append(*reservoir, entry)
+		return
+	}
+	(*reservoir)[i] = entry
+}
+
+// harHeaders returns 'header' as HAR name/value pairs, sorted by name
+func harHeaders(header http.Header) []harNameValue {
+	pairs := []harNameValue{}
+	for name, values := range header {
+		for _, v := range values {
+			pairs = append(pairs, harNameValue{Name: name, Value: v})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Name < pairs[j].Name })
+	return pairs
+}
+
+// harBody returns the first harBodyLimit bytes of 'body', whose full size is 'size',
+// base64 encoded if they aren't text. The comment notes if it was truncated.
+func harBody(body []byte, size int64) (text, encoding, comment string) {
+	if int64(len(body)) > size {
+		size = int64(len(body))
+	}
+	if len(body) > harBodyLimit {
+		body = body[:harBodyLimit]
+	}
+	if int64(len(body)) < size {
+		comment = fmt.Sprintf("body truncated to its first %d of %d bytes", len(body), size)
+	}
+	if !utf8.Valid(body) {
+		return base64.StdEncoding.EncodeToString(body), "base64", comment
+	}
+	return string(body), "", comment
+}
+
+// newHAREntry returns the HAR entry of 'resp', whose response body starts with 'body'
+func newHAREntry(resp Response, body []byte, times harTimes) harEntry {
+	rqst := resp.Rqst
+	proto := resp.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	rqstHeader := make(http.Header, len(rqst.Headers))
+	for name, v := range rqst.Headers {
+		rqstHeader.Add(name, v)
+	}
+	query := []harNameValue{}
+	if u, err := url.Parse(rqst.URL); err == nil {
+		for name, values := range u.Query() {
+			for _, v := range values {
+				query = append(query, harNameValue{Name: name, Value: v})
+			}
+		}
+		sort.SliceStable(query, func(i, j int) bool { return query[i].Name < query[j].Name })
+	}
+
+	timings := times.timings()
+	entry := harEntry{
+		StartedDateTime: times.start.Format(time.RFC3339Nano),
+		Time:            timings.total(),
+		Request: harRequest{
+			Method:      rqst.Method,
+			URL:         rqst.URL,
+			HTTPVersion: proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(rqstHeader),
+			QueryString: query,
+			HeadersSize: -1,
+			BodySize:    int64(len(rqst.Body)),
+		},
+		Response: harResponse{
+			Status:      resp.HTTPStatus,
+			StatusText:  http.StatusText(resp.HTTPStatus),
+			HTTPVersion: proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(resp.Header),
+			Content:     harContent{Size: resp.BytesReceived, MimeType: resp.Header.Get("Content-Type")},
+			RedirectURL: resp.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    resp.BytesReceived,
+		},
+		Timings:         timings,
+		ServerIPAddress: resp.RemoteIP,
+		Endpoint:        resp.Endpoint.URL,
+		Failed:          resp.failed(),
+		started:         times.start,
+	}
+	if rqst.Body != "" {
+		postData := &harPostData{MimeType: rqstHeader.Get("Content-Type")}
+		var encoding string
+		postData.Text, encoding, postData.Comment = harBody([]byte(rqst.Body), int64(len(rqst.Body)))
+		// postData doesn't have an encoding, so it's noted in the comment
+		if encoding != "" {
+			postData.Comment = strings.TrimPrefix(postData.Comment+", base64 encoded because it isn't text", ", ")
+		}
+		entry.Request.PostData = postData
+	}
+	if len(body) > 0 {
+		c := &entry.Response.Content
+		c.Text, c.Encoding, c.Comment = harBody(body, resp.BytesReceived)
+	}
+	if resp.Err != nil {
+		entry.Comment = resp.Err.Error()
+	}
+	return entry
+}
+
+// har returns the HAR document of the sampled entries, in the order they started
+func (h *HARRecorder) har() harLog {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	entries := []harEntry{}
+	for _, s := range h.samples {
+		entries = append(entries, s.entries...)
+		entries = append(entries, s.failures...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].started.Before(entries[j].started) })
+	return harLog{Log: harLogBody{
+		Version: "1.2",
+		Creator: harCreator{Name: "heyyall", Version: heyyallVersion()},
+		Entries: entries,
+	}}
+}
+
+// WriteFile writes the sampled entries to 'path' as a HAR document
+func (h *HARRecorder) WriteFile(path string) error {
+	b, err := json.MarshalIndent(h.har(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding the HAR: %w", err)
+	}
+	if err = ioutil.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing the HAR to %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestHARCapture verifies the sampled requests, and the failed requests, of each
+// endpoint are written as a HAR 1.2 document with the fields the HAR schema
+// requires, and that large bodies are truncated
+func TestHARCapture(t *testing.T) {
+	bigBody := strings.Repeat("0123456789abcdef", 16*1024)
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": 1}`))
+		case "/big":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(bigBody))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer testSrv.Close()
+
+	const sample, numRqsts = 3, 10
+	har, err := NewHARRecorder(sample, 1)
+	if err != nil {
+		t.Fatalf("unexpected error creating the HAR recorder: %s", err)
+	}
+	eps := []api.Endpoint{
+		{URL: testSrv.URL + "/users?active=true", Method: http.MethodPost, RqstBody: `{"name": "a"}`,
+			Headers: map[string]string{"Content-Type": "application/json"}},
+		{URL: testSrv.URL + "/big", Method: http.MethodGet},
+		{URL: testSrv.URL + "/fail", Method: http.MethodGet},
+	}
+	respC := make(chan Response, len(eps)*numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}, HAR: har}
+	for _, ep := range eps {
+		rqstr.ProcessRqst(ep, numRqsts, 0)
+	}
+
+	dir, err := ioutil.TempDir("", "har")
+	if err != nil {
+		t.Fatalf("unexpected error creating a temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run.har")
+	if err = har.WriteFile(path); err != nil {
+		t.Fatalf("unexpected error writing the HAR: %s", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading the HAR: %s", err)
+	}
+
+	// The document is checked generically, as a HAR consumer would read it, against
+	// the HAR 1.2 schema's required fields
+	var doc map[string]interface{}
+	if err = json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("unexpected error decoding the HAR: %s", err)
+	}
+	log, ok := doc["log"].(map[string]interface{})
+	if !ok || log["version"] != "1.2" {
+		t.Fatalf("expected a HAR 1.2 log, got %v", doc["log"])
+	}
+	if creator, ok := log["creator"].(map[string]interface{}); !ok || creator["name"] != "heyyall" || creator["version"] == nil {
+		t.Errorf("expected heyyall to be the creator, got %v", log["creator"])
+	}
+	entries, ok := log["entries"].([]interface{})
+	if !ok {
+		t.Fatalf("expected the log to have entries, got %v", log["entries"])
+	}
+
+	requireFields := func(name string, obj interface{}, fields ...string) map[string]interface{} {
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected %s to be an object, got %v", name, obj)
+		}
+		for _, f := range fields {
+			if _, ok := m[f]; !ok {
+				t.Errorf("expected %s to have the required field %s, got %v", name, f, m)
+			}
+		}
+		return m
+	}
+	counts := make(map[string]int)
+	var last time.Time
+	for _, e := range entries {
+		entry := requireFields("entry", e, "startedDateTime", "time", "request", "response", "cache", "timings")
+		started, err := time.Parse(time.RFC3339Nano, entry["startedDateTime"].(string))
+		if err != nil || started.Before(last) {
+			t.Errorf("expected the entries in the order they started, got %v after %s", entry["startedDateTime"], last)
+		}
+		last = started
+		rqst := requireFields("request", entry["request"], "method", "url", "httpVersion", "cookies", "headers",
+			"queryString", "headersSize", "bodySize")
+		resp := requireFields("response", entry["response"], "status", "statusText", "httpVersion", "cookies", "headers",
+			"content", "redirectURL", "headersSize", "bodySize")
+		content := requireFields("content", resp["content"], "size", "mimeType")
+		timings := requireFields("timings", entry["timings"], "send", "wait", "receive")
+		for _, phase := range []string{"send", "wait", "receive"} {
+			if ms, _ := timings[phase].(float64); ms < 0 {
+				t.Errorf("expected the %s timing to be at least 0, got %v", phase, timings[phase])
+			}
+		}
+		for _, phase := range []string{"blocked", "dns", "connect", "ssl"} {
+			if ms, ok := timings[phase].(float64); ok && ms < -1 {
+				t.Errorf("expected the %s timing to be at least -1, got %v", phase, ms)
+			}
+		}
+
+		rqstURL := rqst["url"].(string)
+		counts[rqstURL]++
+		switch rqstURL {
+		case eps[0].URL:
+			postData := requireFields("postData", rqst["postData"], "mimeType", "text")
+			if postData["text"] != eps[0].RqstBody || postData["mimeType"] != "application/json" {
+				t.Errorf("expected the request body to be captured, got %v", postData)
+			}
+			if query := rqst["queryString"].([]interface{}); len(query) != 1 {
+				t.Errorf("expected the query string to be captured, got %v", query)
+			}
+			if content["text"] != `{"id": 1}` || resp["status"] != float64(http.StatusOK) {
+				t.Errorf("expected the response body to be captured, got %v", content)
+			}
+		case eps[1].URL:
+			text, _ := content["text"].(string)
+			if len(text) != harBodyLimit || content["size"] != float64(len(bigBody)) ||
+				!strings.Contains(content["comment"].(string), "truncated") {
+				t.Errorf("expected the response body to be truncated to %d of %d bytes with a comment, got %d bytes, size %v, comment %v",
+					harBodyLimit, len(bigBody), len(text), content["size"], content["comment"])
+			}
+		case eps[2].URL:
+			if resp["status"] != float64(http.StatusInternalServerError) || entry["_failed"] != true {
+				t.Errorf("expected a failed response, got status %v", resp["status"])
+			}
+		}
+	}
+	expected := map[string]int{eps[0].URL: sample, eps[1].URL: sample, eps[2].URL: harFailureSamples}
+	for url, n := range expected {
+		if counts[url] != n {
+			t.Errorf("expected %d entries of %s, got %d", n, url, counts[url])
+		}
+	}
+}
+
+// TestHARSampling verifies the successful and failed responses of an endpoint are
+// sampled separately and that their samples are bounded
+func TestHARSampling(t *testing.T) {
+	if _, err := NewHARRecorder(0, 1); err == nil {
+		t.Errorf("expected an error for a sample of 0")
+	}
+	har, err := NewHARRecorder(2, 1)
+	if err != nil {
+		t.Fatalf("unexpected error creating the HAR recorder: %s", err)
+	}
+	ep := api.Endpoint{URL: "http://someservice.test/users", Method: http.MethodGet}
+	rqst := &sentRqst{URL: ep.URL, Method: ep.Method}
+	for i := 0; i < 100; i++ {
+		har.record(Response{Endpoint: ep, HTTPStatus: http.StatusOK, Rqst: rqst}, nil, harTimes{start: time.Now()})
+	}
+	har.record(Response{Endpoint: ep, HTTPStatus: http.StatusServiceUnavailable, Rqst: rqst}, nil, harTimes{start: time.Now()})
+	// Responses without their request can't be captured
+	har.record(Response{Endpoint: ep, HTTPStatus: http.StatusOK}, nil, harTimes{})
+
+	entries := har.har().Log.Entries
+	failed := 0
+	for _, e := range entries {
+		if e.Failed {
+			failed++
+		}
+	}
+	if len(entries) != 3 || failed != 1 {
+		t.Errorf("expected 2 sampled entries and 1 failed entry, got %d entries, %d failed", len(entries), failed)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"net/http"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// headerSizes is the number and size of the headers of a request and its response,
+// see api.HeaderStats
+type headerSizes struct {
+	sentCount     int64
+	sentBytes     int64
+	receivedCount int64
+	receivedBytes int64
+}
+
+// headerLineBytes returns the size of the lines of the header 'key' with 'values' in
+// an HTTP/1.1 message, e.g., 'Accept: */*\r\n'
+func headerLineBytes(key string, values []string) int64 {
+	var n int64
+	for _, v := range values {
+		n += int64(len(key) + len(": ") + len(v) + len("\r\n"))
+	}
+	return n
+}
+
+// wroteHeader adds the header 'key' with 'values', written with a request, to the
+// sent headers
+func (h *headerSizes) wroteHeader(key string, values []string) {
+	h.sentCount += int64(len(values))
+	h.sentBytes += headerLineBytes(key, values)
+}
+
+// received adds the headers of a response, 'header', to the received headers
+func (h *headerSizes) received(header http.Header) {
+	for key, values := range header {
+		h.receivedCount += int64(len(values))
+		h.receivedBytes += headerLineBytes(key, values)
+	}
+}
+
+// accumulateHeaderStats adds the header sizes of 'resp', a successful response, to
+// 'rs' if they were measured
+func accumulateHeaderStats(resp Response, rs *api.RunSummary) {
+	if resp.HeaderSizes == nil {
+		return
+	}
+	if rs.HeaderStats == nil {
+		rs.HeaderStats = &api.HeaderStats{}
+	}
+	stats := rs.HeaderStats
+	stats.Rqsts++
+	stats.TotalSentCount += resp.HeaderSizes.sentCount
+	stats.TotalSentBytes += resp.HeaderSizes.sentBytes
+	stats.TotalReceivedCount += resp.HeaderSizes.receivedCount
+	stats.TotalReceivedBytes += resp.HeaderSizes.receivedBytes
+}
+
+// finishHeaderStats calculates the averages of 'rs's HeaderStats
+func finishHeaderStats(rs *api.RunSummary) {
+	stats := rs.HeaderStats
+	if stats == nil {
+		return
+	}
+	rqsts := float64(stats.Rqsts)
+	stats.AvgSentCount = float64(stats.TotalSentCount) / rqsts
+	stats.AvgSentBytes = float64(stats.TotalSentBytes) / rqsts
+	stats.AvgReceivedCount = float64(stats.TotalReceivedCount) / rqsts
+	stats.AvgReceivedBytes = float64(stats.TotalReceivedBytes) / rqsts
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestHeaderStats verifies the averages of known request and response header sets
+func TestHeaderStats(t *testing.T) {
+	url := "http://somehost.com/headers"
+	ep := api.Endpoint{URL: url, Method: http.MethodGet}
+	// 'Accept: */*\r\n' is 13 bytes, 'X-Id: 1\r\n' 9, 'Set-Cookie: a=1\r\n' 17, and
+	// 'Set-Cookie: b=22\r\n' 18
+	var first, second headerSizes
+	first.wroteHeader("Accept", []string{"*/*"})
+	first.received(http.Header{"Set-Cookie": {"a=1", "b=22"}})
+	second.wroteHeader("Accept", []string{"*/*"})
+	second.wroteHeader("X-Id", []string{"1"})
+	second.received(http.Header{})
+
+	responses := []Response{
+		{Endpoint: ep, HTTPStatus: http.StatusOK, RequestDuration: time.Millisecond, HeaderSizes: &first},
+		{Endpoint: ep, HTTPStatus: http.StatusOK, RequestDuration: time.Millisecond, HeaderSizes: &second},
+		// Errors and responses whose headers weren't measured aren't included
+		{Endpoint: ep, ErrCategory: api.ErrCategoryConnection, RequestDuration: time.Millisecond},
+		{Endpoint: ep, HTTPStatus: http.StatusOK, RequestDuration: time.Millisecond},
+	}
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	expected := api.HeaderStats{
+		Rqsts:              2,
+		TotalSentCount:     3,
+		TotalSentBytes:     35,
+		TotalReceivedCount: 2,
+		TotalReceivedBytes: 35,
+		AvgSentCount:       1.5,
+		AvgSentBytes:       17.5,
+		AvgReceivedCount:   1,
+		AvgReceivedBytes:   17.5,
+	}
+	if stats := runResults.RunSummary.HeaderStats; stats == nil || *stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+}
+
+// TestHeaderStatsRequestor verifies the requestor measures the headers it sends and
+// receives only when HeaderStats is set
+func TestHeaderStatsRequestor(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Echo", r.Header.Get("X-Test"))
+		w.Write([]byte("OK"))
+	}))
+	defer testSrv.Close()
+
+	ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet, Headers: map[string]string{"X-Test": "value"}}
+	for _, enabled := range []bool{false, true} {
+		numRqsts := 3
+		respC := make(chan Response, numRqsts)
+		rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}, HeaderStats: enabled}
+		rqstr.ProcessRqst(ep, numRqsts, 0)
+		close(respC)
+
+		for resp := range respC {
+			if !enabled {
+				if resp.HeaderSizes != nil {
+					t.Errorf("expected no header sizes without HeaderStats, got %+v", resp.HeaderSizes)
+				}
+				continue
+			}
+			sizes := resp.HeaderSizes
+			// At least the Host, User-Agent, and X-Test headers are sent, and the
+			// X-Echo, Content-Length, Content-Type, and Date headers received
+			if sizes == nil || sizes.sentCount < 3 || sizes.sentBytes < headerLineBytes("X-Test", []string{"value"}) ||
+				sizes.receivedCount < 4 || sizes.receivedBytes < headerLineBytes("X-Echo", []string{"value"}) {
+				t.Errorf("expected the sent and received headers to be measured, got %+v", sizes)
+			}
+		}
+	}
+}
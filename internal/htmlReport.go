@@ -0,0 +1,331 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// Dimensions, in pixels, of the charts in the HTML report
+const (
+	chartWidth   = 640.0
+	chartHeight  = 240.0
+	chartPadding = 40.0
+)
+
+// svgBar is a single bar of a bar chart
+type svgBar struct {
+	X, Y, Width, Height float64
+	Label               string
+	Title               string
+	// Class, if set, is the CSS class of the bar, e.g., to color the status classes
+	// of a stacked bar chart
+	Class string
+}
+
+// svgMarker is a vertical line marking an event on a chart
+type svgMarker struct {
+	X     float64
+	Title string
+}
+
+// svgPoint is a single point of a line chart
+type svgPoint struct {
+	X, Y  float64
+	Title string
+}
+
+// svgChart contains the values needed to draw a chart as inline SVG
+type svgChart struct {
+	Width, Height float64
+	// Bottom is the y coordinate of the chart's x axis
+	Bottom float64
+	// MaxLabel labels the largest value on the y axis
+	MaxLabel string
+	Bars     []svgBar
+	Points   []svgPoint
+	Markers  []svgMarker
+}
+
+// Polyline returns the chart's points formatted for an SVG polyline
+func (c svgChart) Polyline() string {
+	points := make([]string, 0, len(c.Points))
+	for _, p := range c.Points {
+		points = append(points, fmt.Sprintf("%.1f,%.1f", p.X, p.Y))
+	}
+	return strings.Join(points, " ")
+}
+
+// statusFlapChart is the status class chart of an endpoint whose majority status
+// class flapped
+type statusFlapChart struct {
+	URL   string
+	Flaps int64
+	Chart svgChart
+}
+
+// htmlReport is the data used to execute htmlReportTmplt
+type htmlReport struct {
+	Results     api.RunResults
+	Histogram   svgChart
+	Throughput  svgChart
+	StatusFlaps []statusFlapChart
+}
+
+// histogramChart draws 'histogram', as generated by ResponseHandler.generateHistogram,
+// as a bar chart with a bar for each latency bin
+func histogramChart(histogram map[float64]int) svgChart {
+	chart := svgChart{Width: chartWidth, Height: chartHeight, Bottom: chartHeight - chartPadding}
+
+	keys := make([]float64, 0, len(histogram))
+	maxCount := 0
+	for k, cnt := range histogram {
+		keys = append(keys, k)
+		if cnt > maxCount {
+			maxCount = cnt
+		}
+	}
+	sort.Float64s(keys)
+	if len(keys) == 0 || maxCount == 0 {
+		return chart
+	}
+	chart.MaxLabel = fmt.Sprintf("%d", maxCount)
+
+	plotHeight := chart.Bottom - chartPadding/2
+	barWidth := (chartWidth - chartPadding) / float64(len(keys))
+	for i, k := range keys {
+		cnt := histogram[k]
+		height := plotHeight * float64(cnt) / float64(maxCount)
+		latency := formatSeconds(time.Duration(k))
+		chart.Bars = append(chart.Bars, svgBar{
+			X:      chartPadding + float64(i)*barWidth,
+			Y:      chart.Bottom - height,
+			Width:  barWidth - 2,
+			Height: height,
+			Label:  latency,
+			Title:  fmt.Sprintf("<= %ss: %d requests", latency, cnt),
+		})
+	}
+	return chart
+}
+
+// throughputChart draws the request rate of each sample in 'samples' as a line chart
+func throughputChart(samples []api.TimeSeriesSample) svgChart {
+	chart := svgChart{Width: chartWidth, Height: chartHeight, Bottom: chartHeight - chartPadding}
+
+	maxRate := 0.0
+	for _, s := range samples {
+		if s.RqstRatePerSec > maxRate {
+			maxRate = s.RqstRatePerSec
+		}
+	}
+	if len(samples) == 0 || maxRate == 0 {
+		return chart
+	}
+	chart.MaxLabel = formatFloat(maxRate)
+
+	plotHeight := chart.Bottom - chartPadding/2
+	xStep := chartWidth - chartPadding*1.5
+	if len(samples) > 1 {
+		xStep = xStep / float64(len(samples)-1)
+	}
+	for i, s := range samples {
+		chart.Points = append(chart.Points, svgPoint{
+			X:     chartPadding + float64(i)*xStep,
+			Y:     chart.Bottom - plotHeight*s.RqstRatePerSec/maxRate,
+			Title: fmt.Sprintf("%ss: %s rqsts/sec", formatSeconds(s.OffsetNanos), formatFloat(s.RqstRatePerSec)),
+		})
+	}
+	return chart
+}
+
+// statusClassCSS returns the CSS class used to color the status class 'class'
+func statusClassCSS(class string) string {
+	switch class {
+	case "2xx", "3xx", "4xx", "5xx":
+		return "status-" + class
+	}
+	return "status-other"
+}
+
+// statusChart draws each interval of 'epDetail's StatusTimeSeries as a bar stacking
+// the fractions of its responses in each status class. Each of the endpoint's
+// status flaps is marked.
+func statusChart(epDetail *api.EndpointDetail, interval time.Duration) svgChart {
+	chart := svgChart{Width: chartWidth, Height: chartHeight, Bottom: chartHeight - chartPadding}
+	samples := epDetail.StatusTimeSeries
+	if len(samples) == 0 || interval <= 0 {
+		return chart
+	}
+	chart.MaxLabel = "100%"
+
+	// The intervals without responses are left empty so the bars are placed by time
+	slots := int(samples[len(samples)-1].OffsetNanos/interval) + 1
+	plotHeight := chart.Bottom - chartPadding/2
+	barWidth := (chartWidth - chartPadding) / float64(slots)
+	x := func(offset time.Duration) float64 {
+		return chartPadding + float64(offset/interval)*barWidth
+	}
+	for _, s := range samples {
+		classes := make([]string, 0, len(s.StatusClasses))
+		var total int64
+		for class, cnt := range s.StatusClasses {
+			classes = append(classes, class)
+			total += cnt
+		}
+		sort.Strings(classes)
+
+		y := chart.Bottom
+		for _, class := range classes {
+			cnt := s.StatusClasses[class]
+			height := plotHeight * float64(cnt) / float64(total)
+			y -= height
+			chart.Bars = append(chart.Bars, svgBar{
+				X:      x(s.OffsetNanos),
+				Y:      y,
+				Width:  math.Max(barWidth-1, 1),
+				Height: height,
+				Title:  fmt.Sprintf("%ss: %s %d of %d", formatSeconds(s.OffsetNanos), class, cnt, total),
+				Class:  statusClassCSS(class),
+			})
+		}
+	}
+	for _, flap := range epDetail.StatusFlapEvents {
+		chart.Markers = append(chart.Markers, svgMarker{
+			X:     x(flap.OffsetNanos),
+			Title: fmt.Sprintf("%ss: %s -> %s", formatSeconds(flap.OffsetNanos), flap.From, flap.To),
+		})
+	}
+	return chart
+}
+
+// statusFlapCharts returns a status chart for each endpoint in 'runResults' whose
+// majority status class flapped, ordered by URL
+func statusFlapCharts(runResults api.RunResults) []statusFlapChart {
+	var charts []statusFlapChart
+	for url, epDetail := range runResults.EndpointDetails {
+		if epDetail.StatusFlaps == 0 {
+			continue
+		}
+		charts = append(charts, statusFlapChart{
+			URL:   url,
+			Flaps: epDetail.StatusFlaps,
+			Chart: statusChart(epDetail, runResults.RunSummary.TimeSeriesIntervalNanos),
+		})
+	}
+	sort.Slice(charts, func(i, j int) bool { return charts[i].URL < charts[j].URL })
+	return charts
+}
+
+var htmlReportTmplt = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>heyyall run report</title>
+<style>
+  body { font-family: sans-serif; margin: 2em; color: #222; }
+  table { border-collapse: collapse; margin-bottom: 2em; }
+  th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+  th:first-child, td:first-child { text-align: left; }
+  svg { margin-bottom: 2em; }
+  .bar { fill: #4a7ab5; }
+  .line { fill: none; stroke: #4a7ab5; stroke-width: 2; }
+  .point { fill: #4a7ab5; }
+  .axis { stroke: #888; }
+  .label { font-size: 10px; fill: #444; }
+  .status-2xx { fill: #4a9a5b; }
+  .status-3xx { fill: #4a7ab5; }
+  .status-4xx { fill: #d9a036; }
+  .status-5xx { fill: #c8453c; }
+  .status-other { fill: #888; }
+  .marker { stroke: #222; stroke-dasharray: 4 2; }
+</style>
+</head>
+<body>
+<h1>heyyall run report</h1>
+
+<h2>Run Summary</h2>
+<table>
+  <tr><td>Total Rqsts</td><td>{{ .Results.RunSummary.RqstStats.TotalRqsts }}</td></tr>
+  <tr><td>Rqsts/sec</td><td>{{ formatFloat .Results.RunSummary.RqstRatePerSec }}</td></tr>
+  <tr><td>Run Duration (secs)</td><td>{{ formatSeconds .Results.RunSummary.RunDurationNanos }}</td></tr>{{ if .Results.RunSummary.AbandonedSlow }}
+  <tr><td>Abandoned (slow)</td><td>{{ .Results.RunSummary.AbandonedSlow }}</td></tr>{{ end }}{{ range .Results.RunSummary.DisabledEndpoints }}
+  <tr><td>Skipped (disabled)</td><td>{{ . }}</td></tr>{{ end }}{{ range $category, $count := .Results.RunSummary.ErrorCategories }}
+  <tr><td>Errors: {{ $category }}</td><td>{{ $count }}</td></tr>{{ end }}
+</table>
+{{ with .Results.RunSummary.Warnings }}
+<h2>Warnings</h2>
+<ul>{{ range . }}
+  <li>{{ . }}</li>{{ end }}
+</ul>
+{{ end }}
+<h2>Request Latency (secs)</h2>
+{{ with .Results.RunSummary.RqstStats }}<table>
+  <tr><th>Min</th><th>Median</th><th>P75</th><th>P90</th><th>P95</th><th>P99</th></tr>
+  <tr><td>{{ formatPercentile 0 .TimingResultsNanos }}</td><td>{{ formatPercentile 50 .TimingResultsNanos }}</td><td>{{ formatPercentile 75 .TimingResultsNanos }}</td><td>{{ formatPercentile 90 .TimingResultsNanos }}</td><td>{{ formatPercentile 95 .TimingResultsNanos }}</td><td>{{ formatPercentile 99 .TimingResultsNanos }}</td></tr>
+</table>{{ end }}
+
+<h2>Request Latency Histogram (secs)</h2>
+{{ with .Histogram }}<svg width="{{ .Width }}" height="{{ .Height }}" xmlns="http://www.w3.org/2000/svg">
+  <line class="axis" x1="40" y1="{{ .Bottom }}" x2="{{ .Width }}" y2="{{ .Bottom }}"/>
+  <text class="label" x="0" y="24">{{ .MaxLabel }}</text>{{ range .Bars }}
+  <rect class="bar" x="{{ .X }}" y="{{ .Y }}" width="{{ .Width }}" height="{{ .Height }}"><title>{{ .Title }}</title></rect>
+  <text class="label" x="{{ .X }}" y="{{ $.Histogram.Bottom }}" dy="14">{{ .Label }}</text>{{ end }}
+</svg>{{ end }}
+
+<h2>Throughput (rqsts/sec)</h2>
+{{ with .Throughput }}<svg width="{{ .Width }}" height="{{ .Height }}" xmlns="http://www.w3.org/2000/svg">
+  <line class="axis" x1="40" y1="{{ .Bottom }}" x2="{{ .Width }}" y2="{{ .Bottom }}"/>
+  <text class="label" x="0" y="24">{{ .MaxLabel }}</text>
+  <polyline class="line" points="{{ .Polyline }}"/>{{ range .Points }}
+  <circle class="point" cx="{{ .X }}" cy="{{ .Y }}" r="3"><title>{{ .Title }}</title></circle>{{ end }}
+</svg>{{ end }}
+{{ range .StatusFlaps }}
+<h2>Status Flaps: {{ .URL }} ({{ .Flaps }})</h2>
+{{ with .Chart }}<svg width="{{ .Width }}" height="{{ .Height }}" xmlns="http://www.w3.org/2000/svg">
+  <line class="axis" x1="40" y1="{{ .Bottom }}" x2="{{ .Width }}" y2="{{ .Bottom }}"/>
+  <text class="label" x="0" y="24">{{ .MaxLabel }}</text>{{ range .Bars }}
+  <rect class="{{ .Class }}" x="{{ .X }}" y="{{ .Y }}" width="{{ .Width }}" height="{{ .Height }}"><title>{{ .Title }}</title></rect>{{ end }}{{ $bottom := .Bottom }}{{ range .Markers }}
+  <line class="marker" x1="{{ .X }}" y1="20" x2="{{ .X }}" y2="{{ $bottom }}"><title>{{ .Title }}</title></line>{{ end }}
+</svg>{{ end }}
+{{ end }}
+<h2>Endpoint Details (secs)</h2>
+<table>
+  <tr><th>Endpoint</th><th>Method</th><th>Requests</th><th>Min</th><th>Median</th><th>P75</th><th>P90</th><th>P95</th><th>P99</th></tr>{{ range $url, $epDetail := .Results.EndpointDetails }}{{ range $method, $stats := $epDetail.HTTPMethodRqstStats }}
+  <tr><td>{{ $url }}{{ if $epDetail.NegativeTest }} (negative test, expects {{ $epDetail.NegativeTest }}){{ end }}{{ if $epDetail.Description }}<br>{{ $epDetail.Description }}{{ end }}</td><td>{{ $method }}</td><td>{{ $stats.TotalRqsts }}</td><td>{{ formatPercentile 0 $stats.TimingResultsNanos }}</td><td>{{ formatPercentile 50 $stats.TimingResultsNanos }}</td><td>{{ formatPercentile 75 $stats.TimingResultsNanos }}</td><td>{{ formatPercentile 90 $stats.TimingResultsNanos }}</td><td>{{ formatPercentile 95 $stats.TimingResultsNanos }}</td><td>{{ formatPercentile 99 $stats.TimingResultsNanos }}</td></tr>{{ end }}{{ end }}
+</table>
+</body>
+</html>
+`
+
+// writeHTMLReport writes a self-contained HTML report of 'runResults' to 'w'. The
+// latency histogram, as generated by ResponseHandler.generateHistogram, and the
+// throughput time series are drawn as inline SVG charts so the report can be shared
+// as a single file.
+func writeHTMLReport(w io.Writer, runResults api.RunResults, histogram map[float64]int) error {
+	tmplt, err := template.New("htmlReport").Funcs(template.FuncMap(tmpltFuncs)).Parse(htmlReportTmplt)
+	if err != nil {
+		return fmt.Errorf("error parsing HTML report template: %w", err)
+	}
+
+	report := htmlReport{
+		Results:     runResults,
+		Histogram:   histogramChart(histogram),
+		Throughput:  throughputChart(runResults.RunSummary.TimeSeries),
+		StatusFlaps: statusFlapCharts(runResults),
+	}
+	if err = tmplt.Execute(w, report); err != nil {
+		return fmt.Errorf("error executing HTML report template: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+	"github.com/youngkin/heyyall/internal/stats"
+)
+
+// TestHTMLReport verifies that the HTML report contains the run's summary stats, a bar
+// for each latency histogram bin, and a point for each throughput sample
+func TestHTMLReport(t *testing.T) {
+	url1 := "http://someurl/1"
+	runResults := api.RunResults{
+		RunSummary:      api.RunSummary{RqstStats: api.RqstStats{MinRqstDurationNanos: math.MaxInt64}},
+		EndpointSummary: make(map[string]map[string]int),
+	}
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	start := time.Now()
+	rh := ResponseHandler{OutputType: HTML, start: start}
+	acc := stats.NewAccumulator(stats.Config{Start: start})
+
+	// 3 requests complete in the first second, 1 in the second
+	completed := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 900 * time.Millisecond, 1500 * time.Millisecond}
+	for i, c := range completed {
+		rh.accumulateResponseStats(Response{
+			HTTPStatus:      http.StatusOK,
+			Endpoint:        api.Endpoint{URL: url1, Method: http.MethodGet},
+			RequestDuration: time.Duration(i+1) * 10 * time.Millisecond,
+			Completed:       start.Add(c),
+		}, acc, &runResults, epRunSummary)
+	}
+	if err := rh.finalizeResponseStats(acc, &runResults, epRunSummary); err != nil {
+		t.Fatalf("unexpected error finalizing response stats: %s", err)
+	}
+	rh.generateHistogram(&runResults)
+
+	if len(runResults.RunSummary.TimeSeries) != 2 {
+		t.Fatalf("expected 2 time series samples, got %+v", runResults.RunSummary.TimeSeries)
+	}
+	if runResults.RunSummary.TimeSeries[0].RqstRatePerSec != 3 || runResults.RunSummary.TimeSeries[1].RqstRatePerSec != 1 {
+		t.Errorf("expected rates of 3 and 1 rqsts/sec, got %+v", runResults.RunSummary.TimeSeries)
+	}
+
+	var buf bytes.Buffer
+	if err := writeHTMLReport(&buf, runResults, rh.histogram); err != nil {
+		t.Fatalf("unexpected error writing HTML report: %s", err)
+	}
+	report := buf.String()
+
+	expected := []string{
+		"<td>Total Rqsts</td><td>4</td>",
+		"0.0000s: 3.0000 rqsts/sec",
+		"1.0000s: 1.0000 rqsts/sec",
+		"<td>" + url1 + "</td><td>GET</td><td>4</td><td>0.0100</td>",
+	}
+	for _, e := range expected {
+		if !strings.Contains(report, e) {
+			t.Errorf("expected HTML report to contain %q", e)
+		}
+	}
+	if numBars := strings.Count(report, `<rect class="bar"`); numBars != len(rh.histogram) {
+		t.Errorf("expected %d histogram bars, got %d", len(rh.histogram), numBars)
+	}
+	if numPoints := strings.Count(report, `<circle class="point"`); numPoints != 2 {
+		t.Errorf("expected 2 throughput points, got %d", numPoints)
+	}
+	if !strings.Contains(report, "&lt;= 0.0400s: ") {
+		t.Errorf("expected a histogram bin for the slowest request, got %s", report)
+	}
+}
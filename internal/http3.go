@@ -0,0 +1,107 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build http3
+// +build http3
+
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// HTTP3Available reports whether HTTP/3 support was compiled into the binary
+const HTTP3Available = true
+
+// h3Transport wraps quic-go's HTTP/3 RoundTripper. The RoundTripper doesn't report
+// connection setup using httptrace, so h3Transport calls the request's ClientTrace
+// hooks itself. This allows HTTP/3 requests to be timed the same way as HTTP/1.1
+// and HTTP/2 requests, with the QUIC handshake reported as the TLS handshake.
+type h3Transport struct {
+	rt         *http3.RoundTripper
+	enable0RTT bool
+	// hosts records the hosts that have an established QUIC connection
+	hosts sync.Map
+}
+
+// NewHTTP3Transport returns an http.RoundTripper that sends requests using HTTP/3.
+// If 'enable0RTT' is true GET requests are sent as 0-RTT requests when the server
+// supports session resumption. HTTP/3 support requires building heyyall with the
+// 'http3' build tag, e.g., using 'make build-http3'.
+func NewHTTP3Transport(tlsConfig *tls.Config, enable0RTT bool) (http.RoundTripper, error) {
+	tlsConfig = tlsConfig.Clone()
+	if enable0RTT && tlsConfig.ClientSessionCache == nil {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	t := &h3Transport{enable0RTT: enable0RTT}
+	t.rt = &http3.RoundTripper{
+		TLSClientConfig: tlsConfig,
+		QuicConfig:      &quic.Config{},
+		Dial:            t.dial,
+	}
+	return t, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *h3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	if trace != nil && trace.GetConn != nil {
+		trace.GetConn(req.URL.Host)
+	}
+	// New connections are reported by dial
+	if _, ok := t.hosts.Load(req.URL.Host); ok && trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Reused: true})
+	}
+
+	if t.enable0RTT && req.Method == http.MethodGet {
+		req = req.Clone(req.Context())
+		req.Method = http3.MethodGet0RTT
+	}
+
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		t.hosts.Delete(req.URL.Host)
+		return nil, err
+	}
+	t.hosts.Store(req.URL.Host, struct{}{})
+
+	if trace != nil && trace.GotFirstResponseByte != nil {
+		trace.GotFirstResponseByte()
+	}
+	return resp, nil
+}
+
+// dial establishes a new QUIC connection to 'addr', reporting the QUIC handshake as
+// the TLS handshake
+func (t *h3Transport) dial(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+
+	conn, err := quic.DialAddrEarly(ctx, addr, tlsCfg, cfg)
+
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		var state tls.ConnectionState
+		if err == nil {
+			state = conn.ConnectionState().TLS
+		}
+		trace.TLSHandshakeDone(state, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{})
+	}
+	return conn, nil
+}
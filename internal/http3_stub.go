@@ -0,0 +1,23 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !http3
+// +build !http3
+
+package internal
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+// HTTP3Available reports whether HTTP/3 support was compiled into the binary
+const HTTP3Available = false
+
+// NewHTTP3Transport would return an HTTP/3 http.RoundTripper but HTTP/3 support
+// wasn't compiled into the binary, so it always returns an error. See http3.go.
+func NewHTTP3Transport(tlsConfig *tls.Config, enable0RTT bool) (http.RoundTripper, error) {
+	return nil, errors.New("HTTP/3 support isn't compiled into this binary, rebuild heyyall using 'make build-http3'")
+}
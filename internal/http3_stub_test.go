@@ -0,0 +1,27 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !http3
+// +build !http3
+
+package internal
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+)
+
+func TestHTTP3NotCompiledIn(t *testing.T) {
+	if HTTP3Available {
+		t.Fatal("expected HTTP/3 to be unavailable without the 'http3' build tag")
+	}
+	rt, err := NewHTTP3Transport(&tls.Config{}, false)
+	if err == nil || rt != nil {
+		t.Fatalf("expected an error and no RoundTripper, got %v and %v", err, rt)
+	}
+	if !strings.Contains(err.Error(), "make build-http3") {
+		t.Errorf("expected the error to explain how to enable HTTP/3, got %s", err)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import "github.com/youngkin/heyyall/api"
+
+// accumulateInstanceDist tallies the server instance, identified by the response
+// header 'header', that served 'resp' in 'rs', if 'header' is set. Responses without
+// the header are counted under api.GroupByHeaderNone, and those from instances beyond
+// the first api.MaxGroupByHeaderValues under api.GroupByHeaderOther.
+func accumulateInstanceDist(resp Response, header string, rs *api.RunSummary) {
+	if header == "" {
+		return
+	}
+	if rs.InstanceDist == nil {
+		rs.InstanceDist = make(map[string]int64)
+	}
+
+	instance := resp.Header.Get(header)
+	if instance == "" {
+		instance = api.GroupByHeaderNone
+	}
+	if _, ok := rs.InstanceDist[instance]; !ok && instance != api.GroupByHeaderNone &&
+		instanceCount(rs.InstanceDist) >= api.MaxGroupByHeaderValues {
+		instance = api.GroupByHeaderOther
+	}
+	rs.InstanceDist[instance]++
+}
+
+// instanceCount returns the number of instances in 'dist', not including the
+// GroupByHeaderNone and GroupByHeaderOther groups
+func instanceCount(dist map[string]int64) int {
+	n := len(dist)
+	for _, group := range []string{api.GroupByHeaderNone, api.GroupByHeaderOther} {
+		if _, ok := dist[group]; ok {
+			n--
+		}
+	}
+	return n
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestInstanceDist verifies the responses are tallied by the instance identified by
+// their InstanceHeader
+func TestInstanceDist(t *testing.T) {
+	var rqsts int64
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The responses are served round robin by 3 instances, every 4th without the header
+		n := atomic.AddInt64(&rqsts, 1)
+		if n%4 != 0 {
+			w.Header().Set("X-Served-By", fmt.Sprintf("backend-%d", n%3))
+		}
+	}))
+	defer testSrv.Close()
+
+	numRqsts := 12
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}}
+	rqstr.ProcessRqst(api.Endpoint{URL: testSrv.URL, Method: http.MethodGet}, numRqsts, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	rh := &ResponseHandler{InstanceHeader: "x-served-by", start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	expected := map[string]int64{"backend-0": 3, "backend-1": 3, "backend-2": 3, api.GroupByHeaderNone: 3}
+	if !reflect.DeepEqual(runResults.RunSummary.InstanceDist, expected) {
+		t.Errorf("expected the instance distribution %v, got %v", expected, runResults.RunSummary.InstanceDist)
+	}
+
+	rh.InstanceHeader = ""
+	if runResults, _ := rh.summarize(responses, rh.start); runResults.RunSummary.InstanceDist != nil {
+		t.Errorf("expected no instance distribution without an InstanceHeader, got %v", runResults.RunSummary.InstanceDist)
+	}
+}
+
+// TestInstanceDistBounds verifies the number of instances reported is bounded
+func TestInstanceDistBounds(t *testing.T) {
+	var rs api.RunSummary
+	for i := 0; i < api.MaxGroupByHeaderValues+5; i++ {
+		header := http.Header{}
+		header.Set("Server", fmt.Sprintf("instance-%d", i))
+		accumulateInstanceDist(Response{Header: header}, "Server", &rs)
+	}
+	accumulateInstanceDist(Response{}, "Server", &rs)
+
+	if len(rs.InstanceDist) != api.MaxGroupByHeaderValues+2 || rs.InstanceDist[api.GroupByHeaderOther] != 5 ||
+		rs.InstanceDist[api.GroupByHeaderNone] != 1 {
+		t.Errorf("expected %d instances, 5 others, and 1 without the header, got %v", api.MaxGroupByHeaderValues, rs.InstanceDist)
+	}
+}
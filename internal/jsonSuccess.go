@@ -0,0 +1,77 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// maxSuccessJSONBodySize is the largest response body, in bytes, read to check an
+// endpoint's SuccessJSONPath or SuccessExpr. Larger bodies are truncated, and so fail
+// the check.
+const maxSuccessJSONBodySize = 1 << 20
+
+// lookupJSONPath returns the value at 'path' in the JSON document 'body'. 'path' is a
+// '.' separated list of object keys and array indexes, e.g., 'results.0.status'.
+func lookupJSONPath(body []byte, path string) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("response body isn't valid JSON: %w", err)
+	}
+
+	for _, segment := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[segment]; !ok {
+				return nil, fmt.Errorf("%s not found in the response body, there's no key %q", path, segment)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("%s not found in the response body, %q isn't an index of an array of length %d",
+					path, segment, len(v))
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("%s not found in the response body, %q can't be looked up in a %T", path, segment, value)
+		}
+	}
+	return value, nil
+}
+
+// formatJSONValue formats 'value', as returned by lookupJSONPath, for comparison to an
+// endpoint's SuccessJSONValue. Strings are unquoted, other values are formatted as JSON.
+func formatJSONValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(b)
+}
+
+// checkSuccessJSON returns an error if the value at 'ep.SuccessJSONPath' in the
+// response body 'body' isn't 'ep.SuccessJSONValue'
+func checkSuccessJSON(ep api.Endpoint, body []byte) error {
+	value, err := lookupJSONPath(body, ep.SuccessJSONPath)
+	if err != nil {
+		return err
+	}
+	if actual := formatJSONValue(value); actual != ep.SuccessJSONValue {
+		return fmt.Errorf("%s is %q, expected %q", ep.SuccessJSONPath, actual, ep.SuccessJSONValue)
+	}
+	return nil
+}
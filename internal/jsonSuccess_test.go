@@ -0,0 +1,109 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestSuccessJSON verifies that a 200 response whose JSON body indicates an error is
+// counted as a failure.
+func TestSuccessJSON(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if r.URL.Path == "/error" {
+			w.Write([]byte(`{"status": "error", "message": "database unavailable"}`))
+			return
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name             string
+		path             string
+		expectedCategory string
+	}{
+		{name: "Success", path: "/ok"},
+		{name: "Failure", path: "/error", expectedCategory: api.ErrCategoryUnsuccessfulJSON},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			numRqsts := 3
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    http.Client{},
+			}
+			ep := api.Endpoint{URL: testSrv.URL + tc.path, Method: http.MethodGet, RqstPercent: 100,
+				SuccessJSONPath: "status", SuccessJSONValue: "ok"}
+			rqstr.ProcessRqst(ep, numRqsts, 0)
+			close(respC)
+
+			var responses []Response
+			for resp := range respC {
+				if resp.ErrCategory != tc.expectedCategory {
+					t.Errorf("expected error category %q, got %q: %v", tc.expectedCategory, resp.ErrCategory, resp.Err)
+				}
+				responses = append(responses, resp)
+			}
+
+			rh := ResponseHandler{start: time.Now()}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			expectedFailures := int64(0)
+			if tc.expectedCategory != "" {
+				expectedFailures = int64(numRqsts)
+			}
+			if runResults.RunSummary.ErrorCategories[api.ErrCategoryUnsuccessfulJSON] != expectedFailures {
+				t.Errorf("expected %d failures, got %+v", expectedFailures, runResults.RunSummary.ErrorCategories)
+			}
+			if runResults.RunSummary.RqstStats.TotalRqsts != int64(numRqsts)-expectedFailures {
+				t.Errorf("expected %d successful requests, got %d", int64(numRqsts)-expectedFailures,
+					runResults.RunSummary.RqstStats.TotalRqsts)
+			}
+		})
+	}
+}
+
+// TestCheckSuccessJSON verifies the lookup and comparison of JSON values.
+func TestCheckSuccessJSON(t *testing.T) {
+	body := []byte(`{"ok": true, "code": 0, "results": [{"status": "done"}, {"status": "failed"}]}`)
+	tests := []struct {
+		path      string
+		value     string
+		expectErr bool
+	}{
+		{path: "ok", value: "true"},
+		{path: "code", value: "0"},
+		{path: "results.0.status", value: "done"},
+		{path: "results.1.status", value: "done", expectErr: true},
+		{path: "results.2.status", value: "done", expectErr: true},
+		{path: "missing", value: "true", expectErr: true},
+		{path: "ok.nested", value: "true", expectErr: true},
+	}
+
+	for _, tc := range tests {
+		err := checkSuccessJSON(api.Endpoint{SuccessJSONPath: tc.path, SuccessJSONValue: tc.value}, body)
+		if (err != nil) != tc.expectErr {
+			t.Errorf("path %s, value %s: expected an error to be %t, got %v", tc.path, tc.value, tc.expectErr, err)
+		}
+	}
+
+	if err := checkSuccessJSON(api.Endpoint{SuccessJSONPath: "ok", SuccessJSONValue: "true"}, []byte("<html>")); err == nil {
+		t.Errorf("expected an error for a body that isn't JSON")
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// KeepAliveOutcome is the outcome of probing a single idle connection
+type KeepAliveOutcome struct {
+	// IdleGap is how long the connection was left idle
+	IdleGap time.Duration
+	// Reused indicates the request after the gap was sent on the same connection
+	// as the request before it
+	Reused bool
+}
+
+// validateKeepAliveProbe verifies that 'ep's KeepAliveProbe, if it has one, can be run
+func validateKeepAliveProbe(ep api.Endpoint) error {
+	probe := ep.KeepAliveProbe
+	if probe == nil {
+		return nil
+	}
+	if ep.RqstPercent != 0 {
+		return fmt.Errorf("endpoint %s %s has a KeepAliveProbe and a RqstPercent of %d, it must be 0",
+			ep.Method, ep.URL, ep.RqstPercent)
+	}
+	if ep.PipelineDepth > 1 || ep.UnixSocket != "" || len(ep.Variants) > 0 {
+		return fmt.Errorf("endpoint %s %s has a KeepAliveProbe, it can't also have a PipelineDepth, UnixSocket, or Variants",
+			ep.Method, ep.URL)
+	}
+	if probe.Connections < 1 {
+		return fmt.Errorf("endpoint %s %s has a KeepAliveProbe with %d Connections, it must be at least 1",
+			ep.Method, ep.URL, probe.Connections)
+	}
+	if len(probe.IdleGaps) == 0 {
+		return fmt.Errorf("endpoint %s %s has a KeepAliveProbe without any IdleGaps", ep.Method, ep.URL)
+	}
+	_, err := parseIdleGaps(probe.IdleGaps)
+	if err != nil {
+		return fmt.Errorf("endpoint %s %s: %w", ep.Method, ep.URL, err)
+	}
+	return nil
+}
+
+// parseIdleGaps parses a KeepAliveProbe's IdleGaps
+func parseIdleGaps(idleGaps []string) ([]time.Duration, error) {
+	gaps := make([]time.Duration, 0, len(idleGaps))
+	for _, g := range idleGaps {
+		gap, err := time.ParseDuration(g)
+		if err != nil {
+			return nil, fmt.Errorf("KeepAliveProbe IdleGap %q is invalid: %w", g, err)
+		}
+		if gap <= 0 {
+			return nil, fmt.Errorf("KeepAliveProbe IdleGap %q must be greater than 0", g)
+		}
+		gaps = append(gaps, gap)
+	}
+	return gaps, nil
+}
+
+// processKeepAliveProbe runs 'ep's KeepAliveProbe. Each of its connections, for each
+// idle gap, is probed concurrently and reported as a Response with a KeepAliveOutcome.
+func (r Requestor) processKeepAliveProbe(ep api.Endpoint) {
+	gaps, err := parseIdleGaps(ep.KeepAliveProbe.IdleGaps)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor unable to run endpoint %s keep-alive probe", ep.URL)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, gap := range gaps {
+		for i := 0; i < ep.KeepAliveProbe.Connections; i++ {
+			wg.Add(1)
+			go func(gap time.Duration) {
+				defer wg.Done()
+				response, ok := r.probeConn(ep, gap)
+				if !ok {
+					return
+				}
+				r.sendResponse(response)
+			}(gap)
+		}
+	}
+	wg.Wait()
+}
+
+// probeConn sends a request to 'ep' on a new connection, leaves the connection idle
+// for 'gap', and then sends another request to see whether the connection is reused.
+// It returns false if the run ended before the probe completed.
+func (r Requestor) probeConn(ep api.Endpoint, gap time.Duration) (Response, bool) {
+	// A transport of its own, limited to a single connection, ensures the second
+	// request can only reuse the connection used by the first. The transport
+	// mustn't close the idle connection itself.
+	t, ok := r.Client.Transport.(*http.Transport)
+	if !ok || t == nil {
+		t = http.DefaultTransport.(*http.Transport)
+	}
+	t = t.Clone()
+	t.MaxConnsPerHost = 1
+	t.MaxIdleConnsPerHost = 1
+	t.IdleConnTimeout = 0
+	defer t.CloseIdleConnections()
+
+	client := r.Client
+	client.Transport = t
+	if ep.CertFile != "" {
+		client = certClient(client, ep.URL, ep.CertFile, ep.KeyFile)
+	}
+
+	var reused bool
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+	}
+	ctx := httptrace.WithClientTrace(r.Ctx, trace)
+
+	response := Response{
+		Endpoint:       api.Endpoint{URL: ep.URL, Method: ep.Method, Description: ep.Description},
+		KeepAliveProbe: &KeepAliveOutcome{IdleGap: gap},
+	}
+	for i := 0; i < 2; i++ {
+		if i == 1 {
+			select {
+			case <-r.Ctx.Done():
+				return Response{}, false
+			case <-time.After(gap):
+			}
+		}
+
+		attempt, err := r.sendRqst(client, ctx, ep)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Requestor unable to create a keep-alive probe request for endpoint %s", ep.URL)
+			return Response{}, false
+		}
+		runDone := r.Ctx.Err() != nil
+		if runDone {
+			return Response{}, false
+		}
+		if attempt.err != nil || attempt.bodyErr != nil {
+			response.ErrCategory = errCategory(attempt, runDone)
+			if response.ErrCategory == "" {
+				response.ErrCategory = api.ErrCategoryConnection
+			}
+			response.Err = attempt.err
+			if response.Err == nil {
+				response.Err = attempt.bodyErr
+			}
+			return response, true
+		}
+		response.HTTPStatus = attempt.resp.StatusCode
+	}
+	response.KeepAliveProbe.Reused = reused
+	return response, true
+}
+
+// accumulateKeepAliveProbe records the outcome of the keep-alive probe 'resp'
+func accumulateKeepAliveProbe(resp Response, epDetail *api.EndpointDetail) {
+	var result *api.KeepAliveProbeResult
+	for _, r := range epDetail.KeepAliveProbeResults {
+		if r.IdleGapNanos == resp.KeepAliveProbe.IdleGap {
+			result = r
+			break
+		}
+	}
+	if result == nil {
+		result = &api.KeepAliveProbeResult{IdleGapNanos: resp.KeepAliveProbe.IdleGap}
+		epDetail.KeepAliveProbeResults = append(epDetail.KeepAliveProbeResults, result)
+	}
+
+	result.Connections++
+	switch {
+	case resp.ErrCategory != "":
+		result.Failed++
+		if result.ErrorCategories == nil {
+			result.ErrorCategories = make(map[string]int64)
+		}
+		result.ErrorCategories[resp.ErrCategory]++
+	case resp.KeepAliveProbe.Reused:
+		result.Reused++
+	default:
+		result.TornDown++
+	}
+}
+
+// finishKeepAliveProbes orders 'epDetail's keep-alive probe results by idle gap and
+// calculates their reuse rates
+func finishKeepAliveProbes(epDetail *api.EndpointDetail) {
+	results := epDetail.KeepAliveProbeResults
+	sort.Slice(results, func(i, j int) bool { return results[i].IdleGapNanos < results[j].IdleGapNanos })
+	for _, result := range results {
+		result.ReuseRate = float64(result.Reused) / float64(result.Connections)
+	}
+}
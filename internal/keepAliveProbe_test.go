@@ -0,0 +1,119 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestKeepAliveProbe verifies connections left idle for less than the server's idle
+// timeout are reused and those left idle for longer are torn down
+func TestKeepAliveProbe(t *testing.T) {
+	testSrv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	testSrv.Config.IdleTimeout = 300 * time.Millisecond
+	testSrv.Start()
+	defer testSrv.Close()
+
+	ep := api.Endpoint{
+		URL:    testSrv.URL,
+		Method: http.MethodGet,
+		KeepAliveProbe: &api.KeepAliveProbe{
+			Connections: 2,
+			IdleGaps:    []string{"1s", "50ms"},
+		},
+	}
+	if err := validateConfig(1, 0, 0, 0, []api.Endpoint{ep}); err != nil {
+		t.Fatalf("unexpected error validating the keep-alive probe: %s", err)
+	}
+
+	respC := make(chan Response, 4)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    http.Client{},
+	}
+	rqstr.ProcessRqst(ep, 0, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	rh := ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	if runResults.RunSummary.RqstStats.TotalRqsts != 0 {
+		t.Errorf("expected keep-alive probes to be excluded from the request stats, got %d requests",
+			runResults.RunSummary.RqstStats.TotalRqsts)
+	}
+	epDetail := runResults.EndpointDetails[ep.URL]
+	if epDetail == nil || len(epDetail.KeepAliveProbeResults) != 2 {
+		t.Fatalf("expected keep-alive probe results for 2 idle gaps, got %+v", epDetail)
+	}
+	expected := []api.KeepAliveProbeResult{
+		{IdleGapNanos: 50 * time.Millisecond, Connections: 2, Reused: 2, ReuseRate: 1},
+		{IdleGapNanos: time.Second, Connections: 2, TornDown: 2},
+	}
+	for i, result := range epDetail.KeepAliveProbeResults {
+		if !reflect.DeepEqual(*result, expected[i]) {
+			t.Errorf("expected keep-alive probe result %+v, got %+v", expected[i], *result)
+		}
+	}
+}
+
+// TestValidateKeepAliveProbe verifies invalid keep-alive probes are rejected
+func TestValidateKeepAliveProbe(t *testing.T) {
+	tests := []struct {
+		name  string
+		ep    api.Endpoint
+		valid bool
+	}{
+		{
+			name:  "Valid",
+			ep:    api.Endpoint{KeepAliveProbe: &api.KeepAliveProbe{Connections: 1, IdleGaps: []string{"10s"}}},
+			valid: true,
+		},
+		{
+			name: "RqstPercent",
+			ep:   api.Endpoint{RqstPercent: 10, KeepAliveProbe: &api.KeepAliveProbe{Connections: 1, IdleGaps: []string{"10s"}}},
+		},
+		{
+			name: "NoConnections",
+			ep:   api.Endpoint{KeepAliveProbe: &api.KeepAliveProbe{IdleGaps: []string{"10s"}}},
+		},
+		{
+			name: "NoIdleGaps",
+			ep:   api.Endpoint{KeepAliveProbe: &api.KeepAliveProbe{Connections: 1}},
+		},
+		{
+			name: "InvalidIdleGap",
+			ep:   api.Endpoint{KeepAliveProbe: &api.KeepAliveProbe{Connections: 1, IdleGaps: []string{"10"}}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateKeepAliveProbe(tc.ep)
+			if tc.valid && err != nil {
+				t.Errorf("expected a valid probe, got %s", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("expected an invalid probe")
+			}
+		})
+	}
+}
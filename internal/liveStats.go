@@ -0,0 +1,223 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+	"github.com/youngkin/heyyall/internal/stats"
+)
+
+// liveStats accumulates the latencies, rates, and statuses of the run's responses as
+// they're received. The rolling, dashboard, phase, and degradation summaries are
+// snapshots of its accumulators rather than summaries of the responses received so
+// far. Responses received during the WarmupDuration aren't accumulated. It isn't
+// safe for concurrent use.
+type liveStats struct {
+	keyer summaryKeyer
+	// config is the time series Origin and Interval of all of the accumulators
+	config stats.Config
+	// start is the start of the run, or of the period being summarized
+	start     time.Time
+	warmupEnd time.Time
+	adaptive  *AdaptiveConcurrency
+	// received is the number of responses received, including those that weren't
+	// accumulated
+	received int
+
+	// run accumulates the responses of the entire run. It's created when the first
+	// response after the warmup is received, see accumulator.
+	run *stats.Accumulator
+	// rolling, if rollingInterval is set, accumulates the responses received since
+	// rollingFrom, the start of the current rolling summary interval. It's created
+	// when the interval's first response is received.
+	rollingInterval bool
+	rolling         *stats.Accumulator
+	rollingFrom     time.Time
+	// dashboard accumulates the responses received during each dashboardInterval,
+	// keyed by the interval's index from the start of the run. The intervals older
+	// than the dashboardWindow are dropped when the Dashboard is updated.
+	dashboard map[int]*stats.Accumulator
+	// phases accumulate the responses of each phase, keyed by name
+	phases map[string]*stats.Accumulator
+	// clean and degraded accumulate the responses received before, and after, the
+	// AdaptiveConcurrency first reduced the concurrency
+	clean, degraded *stats.Accumulator
+	// aggregate, if set, also accumulates every response, see
+	// ResponseHandler.aggregate
+	aggregate *stats.Accumulator
+}
+
+// newLiveStats returns the liveStats of the responses received from 'start'
+func (rh *ResponseHandler) newLiveStats(start time.Time) *liveStats {
+	l := liveStats{
+		keyer:       rh.summaryKeyer(),
+		config:      stats.Config{Origin: rh.start, Interval: rh.TimeSeriesInterval},
+		start:       start,
+		warmupEnd:   start,
+		adaptive:    rh.AdaptiveConcurrency,
+		rollingFrom: start,
+		aggregate:   rh.aggregate,
+	}
+	l.rollingInterval = rh.RollingSummaryInterval > 0 && rh.RollingSummaryMode == api.RollingSummaryInterval
+	if rh.WarmupDuration > 0 {
+		l.warmupEnd = rh.start.Add(rh.WarmupDuration)
+	}
+	if rh.Dashboard != nil {
+		l.dashboard = make(map[int]*stats.Accumulator)
+	}
+	if rh.Phases != nil {
+		l.phases = make(map[string]*stats.Accumulator)
+	}
+	return &l
+}
+
+// add accumulates 'resp'. Keep-alive probes and SSE streams that are only summarized
+// aren't included in the request stats.
+func (l *liveStats) add(resp Response) {
+	l.received++
+	if resp.KeepAliveProbe != nil || (resp.SSEStream != nil && resp.SSEStream.SummaryOnly) {
+		return
+	}
+	if !resp.Completed.IsZero() && resp.Completed.Before(l.warmupEnd) {
+		return
+	}
+	resp.Endpoint.URL = l.keyer.key(resp.Endpoint.URL)
+	sr := statsResponse(resp)
+
+	l.accumulator().Add(sr)
+	if l.rollingInterval {
+		if l.rolling == nil {
+			l.rolling = l.newAccumulator(l.summaryStart(l.rollingFrom))
+		}
+		l.rolling.Add(sr)
+	}
+	if l.aggregate != nil {
+		l.aggregate.Add(sr)
+	}
+	if l.dashboard != nil && !resp.Completed.IsZero() {
+		i := int(resp.Completed.Sub(l.config.Origin) / dashboardInterval)
+		acc, ok := l.dashboard[i]
+		if !ok {
+			acc = l.newAccumulator(l.config.Origin.Add(time.Duration(i) * dashboardInterval))
+			l.dashboard[i] = acc
+		}
+		acc.Add(sr)
+	}
+	if l.phases != nil && resp.Phase != "" {
+		acc, ok := l.phases[resp.Phase]
+		if !ok {
+			acc = l.newAccumulator(resp.Completed)
+			l.phases[resp.Phase] = acc
+		}
+		acc.Add(sr)
+	}
+	if l.adaptive != nil {
+		if degradedAt := l.adaptive.degradedAt(); !degradedAt.IsZero() && !resp.Completed.Before(degradedAt) {
+			if l.degraded == nil {
+				l.degraded = l.newAccumulator(degradedAt)
+			}
+			l.degraded.Add(sr)
+		} else {
+			if l.clean == nil {
+				l.clean = l.newAccumulator(l.start)
+			}
+			l.clean.Add(sr)
+		}
+	}
+}
+
+// newAccumulator returns an accumulator of the responses received from 'start'
+func (l *liveStats) newAccumulator(start time.Time) *stats.Accumulator {
+	config := l.config
+	config.Start = start
+	return stats.NewAccumulator(config)
+}
+
+// summaryStart returns the start of the period starting at 'from' that's summarized,
+// the end of the warmup if it started during the warmup. It's now if the warmup
+// hasn't ended yet.
+func (l *liveStats) summaryStart(from time.Time) time.Time {
+	if from.Before(l.warmupEnd) {
+		from = l.warmupEnd
+	}
+	if now := time.Now(); from.After(now) {
+		return now
+	}
+	return from
+}
+
+// accumulator returns the accumulator of the entire run. It's created once the warmup
+// has ended, so its Start is the end of the warmup. Until then an empty accumulator
+// starting now is returned.
+func (l *liveStats) accumulator() *stats.Accumulator {
+	if l.run != nil {
+		return l.run
+	}
+	start := l.summaryStart(l.start)
+	if start.Before(l.warmupEnd) {
+		return l.newAccumulator(start)
+	}
+	l.run = l.newAccumulator(start)
+	return l.run
+}
+
+// startRollingInterval starts a new rolling summary interval at 'now'. It returns the
+// stats of the interval that ended, and its start.
+func (l *liveStats) startRollingInterval(now time.Time) (stats.Summary, time.Time) {
+	ended, from := l.rolling, l.rollingFrom
+	if ended == nil {
+		ended = l.newAccumulator(l.summaryStart(from))
+	}
+	l.rolling, l.rollingFrom = nil, now
+	return ended.Snapshot(), from
+}
+
+// dashboardStats returns the stats of the responses received during the
+// dashboardWindow before 'now', merging those of each of its intervals
+func (l *liveStats) dashboardStats(now time.Time) stats.Summary {
+	// The window starts at the start of an interval so the stats of each interval
+	// it includes are either all, or none, of the interval's responses
+	first := int(now.Sub(l.config.Origin)/dashboardInterval) - int(dashboardWindow/dashboardInterval) + 1
+	if first < 0 {
+		first = 0
+	}
+	window := l.newAccumulator(l.summaryStart(l.config.Origin.Add(time.Duration(first) * dashboardInterval)))
+	for i, acc := range l.dashboard {
+		if i < first {
+			delete(l.dashboard, i)
+			continue
+		}
+		window.Merge(acc)
+	}
+	return window.Snapshot()
+}
+
+// span returns the stats of 'acc', the responses of part of the run, or empty stats
+// if the part didn't have any
+func (l *liveStats) span(acc *stats.Accumulator) stats.Summary {
+	if acc == nil {
+		acc = l.newAccumulator(time.Now())
+	}
+	return acc.Snapshot()
+}
+
+// accumulatedResults returns the results of part of the run that has the stats
+// 'summary'. Only the details accumulated by a stats.Accumulator, the latencies,
+// rates, statuses, and errors, are included.
+func (rh *ResponseHandler) accumulatedResults(summary stats.Summary) api.RunResults {
+	var runResults api.RunResults
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	applyStatsSummary(summary, &runResults, epRunSummary)
+	runResults.EndpointDetails = epRunSummary
+	concurrencyEfficiency(rh.Concurrency, summary.InFlightNanos, &runResults.RunSummary)
+	if rh.OutlierPolicy != nil {
+		runResults.RunSummary.OutlierExcluded = excludeOutliers(*rh.OutlierPolicy, runResults.RunSummary.RqstStats)
+	}
+	checkStatusDist(rh.ExpectedStatusDist, &runResults.RunSummary, epRunSummary)
+	checkMaxP99(rh.MaxP99, &runResults.RunSummary)
+	return runResults
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestLiveStats verifies the responses are accumulated for the run, the current
+// rolling summary interval, and the Dashboard's window as they're received, excluding
+// the warmup, and that only the Dashboard's most recent intervals are kept
+func TestLiveStats(t *testing.T) {
+	// The run started 20.5s ago so the dashboard's window ends now
+	start := time.Now().Add(-20500 * time.Millisecond)
+	rh := &ResponseHandler{
+		start:                  start,
+		WarmupDuration:         2 * time.Second,
+		Dashboard:              &Dashboard{},
+		RollingSummaryInterval: time.Second,
+		RollingSummaryMode:     api.RollingSummaryInterval,
+	}
+	live := rh.newLiveStats(start)
+	ep := api.Endpoint{URL: "http://someurl/items", Method: http.MethodGet}
+	// A response every second for 21 seconds, the first 2 during the warmup
+	for i := 0; i <= 20; i++ {
+		live.add(Response{Endpoint: ep, HTTPStatus: http.StatusOK, RequestDuration: time.Millisecond,
+			Completed: start.Add(time.Duration(i)*time.Second + time.Millisecond)})
+	}
+	if live.received != 21 {
+		t.Errorf("expected 21 responses to be received, got %d", live.received)
+	}
+	if rqsts := live.accumulator().Snapshot().RqstStats.TotalRqsts; rqsts != 19 {
+		t.Errorf("expected the 19 responses after the warmup to be accumulated for the run, got %d", rqsts)
+	}
+
+	now := start.Add(20*time.Second + 500*time.Millisecond)
+	window := live.dashboardStats(now)
+	if window.RqstStats.TotalRqsts != 10 {
+		t.Errorf("expected the dashboard to show the 10 responses of the last 10 seconds, got %d", window.RqstStats.TotalRqsts)
+	}
+	if window.Duration < 9*time.Second || window.Duration > 11*time.Second {
+		t.Errorf("expected the dashboard's window to be about 10 seconds, got %s", window.Duration)
+	}
+	if len(live.dashboard) != 10 {
+		t.Errorf("expected the intervals older than the dashboard's window to be dropped, got %d intervals", len(live.dashboard))
+	}
+
+	interval, from := live.startRollingInterval(now)
+	if interval.RqstStats.TotalRqsts != 19 || !from.Equal(start) {
+		t.Errorf("expected the first rolling interval to have 19 responses from the start of the run, got %d from %s",
+			interval.RqstStats.TotalRqsts, from.Sub(start))
+	}
+	live.add(Response{Endpoint: ep, HTTPStatus: http.StatusOK, RequestDuration: time.Millisecond, Completed: now.Add(time.Millisecond)})
+	if interval, from = live.startRollingInterval(now.Add(time.Second)); interval.RqstStats.TotalRqsts != 1 || !from.Equal(now) {
+		t.Errorf("expected the next rolling interval to only have its own response, got %d from %s",
+			interval.RqstStats.TotalRqsts, from.Sub(start))
+	}
+}
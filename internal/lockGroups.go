@@ -0,0 +1,71 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// LockGroups limits the number of in-flight requests across all endpoints sharing a
+// lock group, e.g., endpoints that mutate the same test fixture. It's shared by all
+// requestors.
+type LockGroups struct {
+	// groups is a semaphore for each lock group keyed by name. It's only modified
+	// by NewLockGroups so it doesn't need to be protected by a mutex.
+	groups map[string]chan struct{}
+}
+
+// NewLockGroups returns the LockGroups used by 'eps'. 'widths' is the maximum number
+// of in-flight requests of each lock group keyed by name, lock groups without a width
+// allow a single request.
+func NewLockGroups(widths map[string]int, eps []api.Endpoint) (*LockGroups, error) {
+	l := LockGroups{groups: make(map[string]chan struct{})}
+	for name, width := range widths {
+		if width < 1 {
+			return nil, fmt.Errorf("lock group %s has a width of %d, it must be at least 1", name, width)
+		}
+	}
+	for _, ep := range eps {
+		if ep.LockGroup == "" {
+			continue
+		}
+		if _, ok := l.groups[ep.LockGroup]; ok {
+			continue
+		}
+		width, ok := widths[ep.LockGroup]
+		if !ok {
+			width = 1
+		}
+		l.groups[ep.LockGroup] = make(chan struct{}, width)
+	}
+	return &l, nil
+}
+
+// acquire waits until a request can be made to an endpoint in the lock group 'name'. It
+// returns how long it waited and false if 'ctx' was done before it could.
+func (l *LockGroups) acquire(ctx context.Context, name string) (time.Duration, bool) {
+	sem, ok := l.groups[name]
+	if !ok {
+		return 0, true
+	}
+	start := time.Now()
+	select {
+	case <-ctx.Done():
+		return time.Since(start), false
+	case sem <- struct{}{}:
+		return time.Since(start), true
+	}
+}
+
+// release ends a request made after acquiring the lock group 'name'
+func (l *LockGroups) release(name string) {
+	if sem, ok := l.groups[name]; ok {
+		<-sem
+	}
+}
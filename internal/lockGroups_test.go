@@ -0,0 +1,103 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestLockGroups verifies that requests to endpoints sharing a lock group never exceed
+// the group's width, as observed by the server, and that the time spent waiting for
+// the group is reported as queue wait rather than request duration.
+func TestLockGroups(t *testing.T) {
+	var inFlight, maxInFlight int32
+	handlerDelay := 20 * time.Millisecond
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(handlerDelay)
+		atomic.AddInt32(&inFlight, -1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name        string
+		widths      map[string]int
+		maxInFlight int32
+	}{
+		{name: "DefaultWidth", maxInFlight: 1},
+		{name: "ConfiguredWidth", widths: map[string]int{"fixture": 2}, maxInFlight: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			atomic.StoreInt32(&maxInFlight, 0)
+			eps := []api.Endpoint{
+				{URL: testSrv.URL + "/create", Method: http.MethodPost, RqstPercent: 50, LockGroup: "fixture"},
+				{URL: testSrv.URL + "/delete", Method: http.MethodDelete, RqstPercent: 50, LockGroup: "fixture"},
+			}
+			lockGroups, err := NewLockGroups(tc.widths, eps)
+			if err != nil {
+				t.Fatalf("unexpected error creating the lock groups: %s", err)
+			}
+
+			// Each endpoint is requested by 2 goroutines
+			numRqsts := 3
+			respC := make(chan Response, 4*numRqsts)
+			rqstr := Requestor{
+				Ctx:        context.Background(),
+				ResponseC:  respC,
+				Client:     http.Client{},
+				LockGroups: lockGroups,
+			}
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func(ep api.Endpoint) {
+					rqstr.ProcessRqst(ep, numRqsts, 0)
+					wg.Done()
+				}(eps[i%2])
+			}
+			wg.Wait()
+			close(respC)
+
+			if max := atomic.LoadInt32(&maxInFlight); max != tc.maxInFlight {
+				t.Errorf("expected at most %d requests in flight, got %d", tc.maxInFlight, max)
+			}
+
+			var queueWait time.Duration
+			for resp := range respC {
+				queueWait += resp.QueueWait
+				if resp.RequestDuration > handlerDelay*3 {
+					t.Errorf("expected the request duration, %s, to not include the queue wait", resp.RequestDuration)
+				}
+			}
+			if queueWait == 0 {
+				t.Errorf("expected requests to wait for the lock group")
+			}
+		})
+	}
+}
+
+// TestLockGroupWidth verifies that lock group widths must be positive.
+func TestLockGroupWidth(t *testing.T) {
+	if _, err := NewLockGroups(map[string]int{"fixture": 0}, nil); err == nil {
+		t.Errorf("expected an error for a lock group width of 0")
+	}
+}
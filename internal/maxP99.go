@@ -0,0 +1,32 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// checkMaxP99 sets 'rs.MaxP99Failed', and describes the failure in
+// 'rs.MaxP99Violation', if the run-wide P99 latency of the successful requests is
+// greater than 'maxP99'. A run without any successful requests also fails since its
+// latency can't be checked.
+func checkMaxP99(maxP99 time.Duration, rs *api.RunSummary) {
+	if maxP99 <= 0 {
+		return
+	}
+	if len(rs.RqstStats.TimingResultsNanos) == 0 {
+		rs.MaxP99Failed = true
+		rs.MaxP99Violation = "there were no successful requests to check against MaxP99"
+		return
+	}
+	p99 := calcPercentiles(99, rs.RqstStats.TimingResultsNanos)
+	if p99 > maxP99 {
+		rs.MaxP99Failed = true
+		rs.MaxP99Violation = fmt.Sprintf("P99 latency %ss is greater than MaxP99 %ss", formatSeconds(p99), formatSeconds(maxP99))
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestMaxP99 verifies the run fails when its P99 latency is greater than MaxP99
+func TestMaxP99(t *testing.T) {
+	// newResponses returns 100 responses, 'slow' of which take a second and the
+	// rest 10ms
+	newResponses := func(slow int) []Response {
+		var responses []Response
+		for i := 0; i < 100; i++ {
+			d := 10 * time.Millisecond
+			if i < slow {
+				d = time.Second
+			}
+			responses = append(responses, Response{HTTPStatus: 200, RequestDuration: d,
+				Endpoint: api.Endpoint{URL: "http://someservice.test/", Method: "GET"}})
+		}
+		return responses
+	}
+
+	tests := []struct {
+		name           string
+		maxP99         time.Duration
+		responses      []Response
+		expectedFailed bool
+	}{
+		{name: "Met", maxP99: 100 * time.Millisecond, responses: newResponses(0)},
+		{name: "Exceeded", maxP99: 100 * time.Millisecond, responses: newResponses(1), expectedFailed: true},
+		{name: "Disabled", responses: newResponses(100)},
+		{name: "No successful requests", maxP99: time.Second, expectedFailed: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rh := ResponseHandler{MaxP99: tc.maxP99, start: time.Now()}
+			runResults, err := rh.summarize(tc.responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			rs := runResults.RunSummary
+			if rs.MaxP99Failed != tc.expectedFailed {
+				t.Errorf("expected MaxP99Failed to be %t, got %t: %s", tc.expectedFailed, rs.MaxP99Failed, rs.MaxP99Violation)
+			}
+			if tc.expectedFailed && rs.MaxP99Violation == "" {
+				t.Errorf("expected a MaxP99Violation describing the failure")
+			}
+			if !tc.expectedFailed {
+				return
+			}
+
+			var out bytes.Buffer
+			printRunSummary(&out, rs)
+			if !strings.Contains(out.String(), "FAILED MaxP99: "+rs.MaxP99Violation) {
+				t.Errorf("expected the report to include the MaxP99 failure, got:\n%s", out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// OptimisticUpdateResult describes the attempts of a single OptimisticUpdate
+type OptimisticUpdateResult struct {
+	// Attempts is the number of update requests sent, including retries
+	Attempts int64
+	// Conflicts is the number of attempts that conflicted, with a 412
+	Conflicts int64
+	// Exhausted indicates the update still conflicted after its MaxConflictRetries
+	Exhausted bool
+}
+
+// validateOptimisticUpdate verifies that 'ep's OptimisticUpdate, if it has one, is
+// valid and can be used with its other settings
+func validateOptimisticUpdate(ep api.Endpoint) error {
+	if ep.OptimisticUpdate == nil {
+		return nil
+	}
+	if ep.PipelineDepth > 1 || ep.KeepAliveProbe != nil || ep.Mode != "" || len(ep.Variants) > 0 || ep.Crawl != nil {
+		return fmt.Errorf("endpoint %s %s has an OptimisticUpdate, it can't also have a PipelineDepth, a KeepAliveProbe, a Mode, Variants, or a Crawl",
+			ep.Method, ep.URL)
+	}
+	if ep.OptimisticUpdate.MaxConflictRetries < 0 {
+		return fmt.Errorf("endpoint %s %s has an OptimisticUpdate MaxConflictRetries of %d, it must not be negative",
+			ep.Method, ep.URL, ep.OptimisticUpdate.MaxConflictRetries)
+	}
+	return nil
+}
+
+// processOptimisticUpdates is the OptimisticUpdate counterpart to ProcessRqst. Each
+// of the 'numRqsts' requests is an update, see api.OptimisticUpdate, reported as a
+// single Response.
+func (r Requestor) processOptimisticUpdates(ep api.Endpoint, numRqsts int, rqstRate float64) {
+	funcs := rqstTmpltFuncs(r.UniqueInts, clockOffset(r.ClockSkew))
+	tmplt, err := newRqstTemplate(ep, funcs)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor unable to parse endpoint %s templates", ep.URL)
+		return
+	}
+	getEP := api.Endpoint{URL: ep.URL, Method: http.MethodGet}
+	if ep.OptimisticUpdate.GetURL != "" {
+		getEP.URL = ep.OptimisticUpdate.GetURL
+	}
+	getTmplt, err := newRqstTemplate(getEP, funcs)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor unable to parse endpoint %s OptimisticUpdate GetURL template", ep.URL)
+		return
+	}
+	maxRetries := ep.OptimisticUpdate.MaxConflictRetries
+	if maxRetries == 0 {
+		maxRetries = api.DefaultOptimisticUpdateMaxConflictRetries
+	}
+	client := r.endpointClient(ep)
+
+	if numRqsts == 0 {
+		log.Debug().Msgf("processOptimisticUpdates: EP: %s, numRqsts was 0, setting to %d", ep.URL, api.MaxRqsts)
+		numRqsts = api.MaxRqsts
+	}
+	for i := 0; i < numRqsts; i++ {
+		if r.Budget.spent() {
+			log.Debug().Msg("Requestor: the run's Budget was reached, exiting")
+			return
+		}
+		if r.FirstFailure.stopped() {
+			log.Debug().Msg("Requestor: the run was stopped by the first failure, exiting")
+			return
+		}
+		rqstEP, rqstGetEP := ep, getEP
+		if tmplt != nil || getTmplt != nil {
+			var data interface{}
+			if r.Data != nil {
+				_, data = r.Data.nextRow()
+			}
+			if tmplt != nil {
+				rqstEP, err = tmplt.render(ep, data)
+			}
+			if err == nil && getTmplt != nil {
+				rqstGetEP, err = getTmplt.render(getEP, data)
+			}
+			if err != nil {
+				r.handleRenderErr(ep, err)
+				return
+			}
+		}
+		rqstGetEP.Headers = rqstEP.Headers
+
+		start := time.Now()
+		response, ok := r.optimisticUpdate(client, rqstGetEP, rqstEP, maxRetries)
+		if !ok {
+			log.Debug().Msg("Requestor cancelled or the run duration expired, exiting")
+			return
+		}
+		if !r.sendResponse(response) {
+			log.Debug().Msg("Requestor cancelled or the run duration expired, exiting")
+			return
+		}
+		if r.stopOnFailure(response, nil) || r.recordEarlyFail(ep, response) {
+			return
+		}
+
+		// Zero request rate is completely unthrottled
+		if rqstRate == 0 {
+			continue
+		}
+		delta := rqstInterval(rqstRate) - time.Since(start)
+		if delta > 0 {
+			time.Sleep(delta)
+		}
+	}
+}
+
+// optimisticUpdate reads 'getEP' for its ETag and sends 'ep's request with an
+// If-Match of it, reading it again and retrying, up to 'maxRetries' times, if the
+// request conflicts. It returns the Response reporting the update, the response to
+// its last request, or false if the run ended before the update finished.
+func (r Requestor) optimisticUpdate(client http.Client, getEP, ep api.Endpoint, maxRetries int) (Response, bool) {
+	result := &OptimisticUpdateResult{}
+	response := Response{Endpoint: api.Endpoint{URL: summaryURL(ep, ep.URL), Method: ep.Method, Description: ep.Description}, OptimisticUpdate: result}
+	start := time.Now()
+	done := func(ok bool) (Response, bool) {
+		response.RequestDuration = time.Since(start)
+		return response, ok
+	}
+
+	for retries := 0; ; retries++ {
+		getResp, err := r.sendOptimisticRqst(client, getEP, "", &response)
+		if err != nil {
+			return done(r.failOptimisticUpdate(&response, err))
+		}
+		if getResp.StatusCode >= http.StatusBadRequest {
+			// The status fails the response
+			return done(true)
+		}
+		etag := getResp.Header.Get("ETag")
+		if etag == "" {
+			response.Err = fmt.Errorf("the response to GET %s didn't have an ETag", getEP.URL)
+			response.ErrCategory = api.ErrCategoryMissingETag
+			return done(true)
+		}
+
+		result.Attempts++
+		resp, err := r.sendOptimisticRqst(client, ep, etag, &response)
+		if err != nil {
+			return done(r.failOptimisticUpdate(&response, err))
+		}
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			return done(true)
+		}
+		result.Conflicts++
+		if retries >= maxRetries {
+			result.Exhausted = true
+			response.Err = fmt.Errorf("the update still conflicted after %d retries", maxRetries)
+			response.ErrCategory = api.ErrCategoryConflictRetriesExhausted
+			return done(true)
+		}
+	}
+}
+
+// sendOptimisticRqst sends 'ep's request, with an If-Match of 'etag' if it's set, and
+// reads its response. The response's status, protocol, headers, and body size are
+// recorded in 'response'.
+func (r Requestor) sendOptimisticRqst(client http.Client, ep api.Endpoint, etag string, response *Response) (*http.Response, error) {
+	req, err := newRqst(r.Ctx, ep)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(ioutil.Discard, resp.Body)
+	response.BytesReceived += n
+	response.HTTPStatus, response.Proto, response.Header = resp.StatusCode, resp.Proto, resp.Header
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// failOptimisticUpdate records the failure of the update reported by 'response'
+// because of 'err'. It returns false if the update failed because the run ended.
+func (r Requestor) failOptimisticUpdate(response *Response, err error) bool {
+	if r.Ctx.Err() != nil {
+		return false
+	}
+	response.Err = err
+	response.ErrCategory = errCategory(rqstAttempt{err: err}, false)
+	if response.ErrCategory == "" {
+		response.ErrCategory = api.ErrCategoryConnection
+	}
+	return true
+}
+
+// summarizeOptimisticUpdates summarizes the OptimisticUpdates in 'responses' in the
+// EndpointDetail of their endpoint, keyed by 'keyer'
+func summarizeOptimisticUpdates(responses []Response, keyer summaryKeyer, epDetails map[string]*api.EndpointDetail) {
+	retries := make(map[*api.OptimisticUpdateStats]int64)
+	for _, resp := range responses {
+		result := resp.OptimisticUpdate
+		if result == nil {
+			continue
+		}
+		epDetail := getEPDetail(keyer.key(resp.Endpoint.URL), epDetails)
+		if epDetail.OptimisticUpdates == nil {
+			epDetail.OptimisticUpdates = &api.OptimisticUpdateStats{}
+		}
+		stats := epDetail.OptimisticUpdates
+		stats.Updates++
+		stats.Attempts += result.Attempts
+		stats.Conflicts += result.Conflicts
+		if result.Attempts > 1 {
+			retries[stats] += result.Attempts - 1
+		}
+		if result.Exhausted {
+			stats.Exhausted++
+		}
+	}
+	for _, epDetail := range epDetails {
+		stats := epDetail.OptimisticUpdates
+		if stats == nil {
+			continue
+		}
+		stats.AvgRetries = float64(retries[stats]) / float64(stats.Updates)
+		if stats.Attempts > 0 {
+			stats.ConflictRate = float64(stats.Conflicts) / float64(stats.Attempts)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestValidateOptimisticUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ep      api.Endpoint
+		wantErr bool
+	}{
+		{name: "none", ep: api.Endpoint{}},
+		{name: "defaults", ep: api.Endpoint{OptimisticUpdate: &api.OptimisticUpdate{}}},
+		{name: "GET URL", ep: api.Endpoint{OptimisticUpdate: &api.OptimisticUpdate{GetURL: "http://someurl/items/1", MaxConflictRetries: 5}}},
+		{name: "negative retries", ep: api.Endpoint{OptimisticUpdate: &api.OptimisticUpdate{MaxConflictRetries: -1}}, wantErr: true},
+		{name: "pipelined", ep: api.Endpoint{OptimisticUpdate: &api.OptimisticUpdate{}, PipelineDepth: 2}, wantErr: true},
+		{name: "SSE", ep: api.Endpoint{OptimisticUpdate: &api.OptimisticUpdate{}, Mode: api.EndpointModeSSE}, wantErr: true},
+		{name: "crawl", ep: api.Endpoint{OptimisticUpdate: &api.OptimisticUpdate{}, Crawl: &api.Crawl{Depth: 1}}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.ep.URL, tc.ep.Method = "http://someurl/items/1", http.MethodPatch
+			if err := validateOptimisticUpdate(tc.ep); (err != nil) != tc.wantErr {
+				t.Errorf("expected an error to be %t, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// versionedResource is a resource updated with optimistic concurrency. Each PATCH
+// in 'conflicts' is preceded by a concurrent update, so it conflicts.
+type versionedResource struct {
+	mux       sync.Mutex
+	version   int
+	patches   int
+	conflicts map[int]bool
+	noETag    bool
+}
+
+func (v *versionedResource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		if !v.noETag {
+			w.Header().Set("ETag", strconv.Quote(strconv.Itoa(v.version)))
+		}
+		w.Write([]byte(`{"name": "widget"}`))
+	case http.MethodPatch:
+		v.patches++
+		if v.conflicts[v.patches] {
+			v.version++
+		}
+		if r.Header.Get("If-Match") != strconv.Quote(strconv.Itoa(v.version)) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		v.version++
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func runOptimisticUpdates(t *testing.T, resource *versionedResource, update *api.OptimisticUpdate, numRqsts int) ([]Response, api.RunResults) {
+	testSrv := httptest.NewServer(resource)
+	defer testSrv.Close()
+
+	ep := api.Endpoint{
+		URL:              testSrv.URL + "/items/1",
+		Method:           http.MethodPatch,
+		RqstBody:         `{"name": "gadget"}`,
+		OptimisticUpdate: update,
+	}
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}}
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	if len(responses) != numRqsts {
+		t.Fatalf("expected %d responses, got %d", numRqsts, len(responses))
+	}
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	return responses, runResults
+}
+
+// TestOptimisticUpdate verifies conflicting updates are retried with a fresh ETag and
+// their conflicts are reported separately from errors
+func TestOptimisticUpdate(t *testing.T) {
+	// The first update conflicts twice before it succeeds, the second succeeds at once
+	resource := &versionedResource{conflicts: map[int]bool{1: true, 2: true}}
+	responses, runResults := runOptimisticUpdates(t, resource, &api.OptimisticUpdate{}, 2)
+
+	for _, resp := range responses {
+		if resp.ErrCategory != "" || resp.HTTPStatus != http.StatusNoContent {
+			t.Errorf("expected a successful update, got %d, %s: %v", resp.HTTPStatus, resp.ErrCategory, resp.Err)
+		}
+	}
+	if resource.version != 4 {
+		t.Errorf("expected 2 updates and 2 concurrent updates, got version %d", resource.version)
+	}
+	for url, epDetail := range runResults.EndpointDetails {
+		expected := api.OptimisticUpdateStats{Updates: 2, Attempts: 4, Conflicts: 2, ConflictRate: 0.5, AvgRetries: 1}
+		if epDetail.OptimisticUpdates == nil || *epDetail.OptimisticUpdates != expected {
+			t.Errorf("expected %+v, got %+v", expected, epDetail.OptimisticUpdates)
+		}
+		if len(epDetail.ErrorCategories) > 0 {
+			t.Errorf("expected the conflicts of %s not to be errors, got %v", url, epDetail.ErrorCategories)
+		}
+	}
+}
+
+// TestOptimisticUpdateFailures verifies updates fail once they've exhausted their
+// retries, or if there's no ETag to update with
+func TestOptimisticUpdateFailures(t *testing.T) {
+	resource := &versionedResource{conflicts: map[int]bool{1: true, 2: true, 3: true}}
+	responses, runResults := runOptimisticUpdates(t, resource, &api.OptimisticUpdate{MaxConflictRetries: 1}, 2)
+
+	if responses[0].ErrCategory != api.ErrCategoryConflictRetriesExhausted || responses[0].HTTPStatus != http.StatusPreconditionFailed {
+		t.Errorf("expected the first update to exhaust its retries, got %d, %s", responses[0].HTTPStatus, responses[0].ErrCategory)
+	}
+	if responses[1].ErrCategory != "" || responses[1].HTTPStatus != http.StatusNoContent {
+		t.Errorf("expected the second update to succeed after a retry, got %d, %s", responses[1].HTTPStatus, responses[1].ErrCategory)
+	}
+	for _, epDetail := range runResults.EndpointDetails {
+		expected := api.OptimisticUpdateStats{Updates: 2, Attempts: 4, Conflicts: 3, ConflictRate: 0.75, AvgRetries: 1, Exhausted: 1}
+		if epDetail.OptimisticUpdates == nil || *epDetail.OptimisticUpdates != expected {
+			t.Errorf("expected %+v, got %+v", expected, epDetail.OptimisticUpdates)
+		}
+	}
+
+	resource = &versionedResource{noETag: true}
+	responses, _ = runOptimisticUpdates(t, resource, &api.OptimisticUpdate{}, 1)
+	if responses[0].ErrCategory != api.ErrCategoryMissingETag || resource.patches != 0 {
+		t.Errorf("expected the update to fail without being sent, got %s after %d PATCHes", responses[0].ErrCategory, resource.patches)
+	}
+}
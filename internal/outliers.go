@@ -0,0 +1,163 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// ValidateOutlierPolicy verifies that 'policy' is valid. A nil policy, meaning outliers
+// aren't excluded, is valid.
+func ValidateOutlierPolicy(policy *api.OutlierPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	switch policy.Method {
+	case api.OutlierIQR:
+		if policy.Multiplier < 0 {
+			return fmt.Errorf("OutlierPolicy: Multiplier, %f, must not be negative", policy.Multiplier)
+		}
+	case api.OutlierCutoff:
+		cutoff, err := time.ParseDuration(policy.Cutoff)
+		if err != nil || cutoff <= 0 {
+			return fmt.Errorf("OutlierPolicy: Cutoff, '%s', must be a positive duration of the form 'xs' or 'xms' where 'x' is an integer",
+				policy.Cutoff)
+		}
+	default:
+		return fmt.Errorf("OutlierPolicy: Method must be one of '%s' or '%s', not '%s'", api.OutlierIQR, api.OutlierCutoff, policy.Method)
+	}
+	return nil
+}
+
+// outlierPercentiles are the percentiles of the requests that weren't excluded that
+// are reported
+var outlierPercentiles = []int{0, 50, 75, 90, 95, 99}
+
+// latencyHistogramSubBuckets is the number of buckets each power of 2 of the
+// latencies is divided into, so a latency's bucket is within 1/64th of it
+const latencyHistogramSubBuckets = 64
+
+// latencyHistogram counts latencies in log-linear buckets so their percentiles can be
+// estimated in a fixed amount of memory, however many requests there are. The
+// estimates are within 1/64th, about 1.6%, of the actual latencies, and never less
+// than the lowest, or more than the highest, latency counted.
+type latencyHistogram struct {
+	counts   [(64 - 6) * latencyHistogramSubBuckets]int64
+	total    int64
+	min, max time.Duration
+}
+
+// latencyBucket returns the index of the bucket 'd' is counted in. Latencies below
+// latencyHistogramSubBuckets nanoseconds each have their own bucket.
+func latencyBucket(d time.Duration) int {
+	if d < latencyHistogramSubBuckets {
+		if d < 0 {
+			return 0
+		}
+		return int(d)
+	}
+	shift := bits.Len64(uint64(d)) - 7
+	return (shift+1)*latencyHistogramSubBuckets + int(d>>uint(shift)) - latencyHistogramSubBuckets
+}
+
+// latencyBucketStart returns the lowest latency counted in the bucket 'i'
+func latencyBucketStart(i int) time.Duration {
+	if i < latencyHistogramSubBuckets {
+		return time.Duration(i)
+	}
+	shift := i/latencyHistogramSubBuckets - 1
+	return time.Duration(i%latencyHistogramSubBuckets+latencyHistogramSubBuckets) << uint(shift)
+}
+
+// add counts 'd'
+func (h *latencyHistogram) add(d time.Duration) {
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	if h.total == 0 || d > h.max {
+		h.max = d
+	}
+	h.counts[latencyBucket(d)]++
+	h.total++
+}
+
+// percentile returns an estimate of the latency at 'percentile', using the same rank
+// as calcPercentiles
+func (h *latencyHistogram) percentile(percentile int) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	if percentile == 0 {
+		return h.min
+	}
+	rank := int64(math.Ceil(math.Ceil(float64((h.total-1)*int64(percentile))) / 100))
+	var seen int64
+	for i, n := range h.counts {
+		if seen += n; seen > rank {
+			d := latencyBucketStart(i)
+			if d < h.min {
+				return h.min
+			}
+			if d > h.max {
+				return h.max
+			}
+			return d
+		}
+	}
+	return h.max
+}
+
+// outlierCutoff returns the latency beyond which the latencies in 'timings' are outliers
+// according to 'policy'. 'policy' must be valid.
+func outlierCutoff(policy api.OutlierPolicy, timings []time.Duration) time.Duration {
+	if policy.Method == api.OutlierCutoff {
+		cutoff, _ := time.ParseDuration(policy.Cutoff)
+		return cutoff
+	}
+
+	multiplier := policy.Multiplier
+	if multiplier == 0 {
+		multiplier = api.DefaultOutlierIQRMultiplier
+	}
+	var h latencyHistogram
+	for _, d := range timings {
+		h.add(d)
+	}
+	q1, q3 := h.percentile(25), h.percentile(75)
+	return q3 + time.Duration(multiplier*float64(q3-q1))
+}
+
+// excludeOutliers returns the stats of the requests in 'stats' that aren't outliers
+// according to 'policy', along with the number and total duration of the outliers.
+// The percentiles of the requests that aren't outliers are estimated by a
+// latencyHistogram rather than keeping a copy of their latencies.
+func excludeOutliers(policy api.OutlierPolicy, stats api.RqstStats) *api.OutlierStats {
+	outlierStats := &api.OutlierStats{CutoffNanos: outlierCutoff(policy, stats.TimingResultsNanos)}
+	var h latencyHistogram
+	for _, d := range stats.TimingResultsNanos {
+		if d > outlierStats.CutoffNanos {
+			outlierStats.ExcludedRqsts++
+			outlierStats.ExcludedDurationNanos += d
+			continue
+		}
+		h.add(d)
+		outlierStats.RqstStats.TotalRequestDurationNanos += d
+	}
+	outlierStats.RqstStats.TotalRqsts = h.total
+	if h.total > 0 {
+		outlierStats.RqstStats.MinRqstDurationNanos, outlierStats.RqstStats.MaxRqstDurationNanos = h.min, h.max
+		outlierStats.RqstStats.AvgRqstDurationNanos = outlierStats.RqstStats.TotalRequestDurationNanos / time.Duration(h.total)
+	}
+	outlierStats.LatencyNanos = make(map[string]time.Duration, len(outlierPercentiles))
+	for _, p := range outlierPercentiles {
+		outlierStats.LatencyNanos[formatExemplarPercentile(p)] = h.percentile(p)
+	}
+	return outlierStats
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+	"github.com/youngkin/heyyall/internal/stats"
+)
+
+// TestOutlierExclusion verifies that outliers are excluded from a parallel set of
+// stats, that the raw stats are unchanged, and that the policy is echoed in Meta
+func TestOutlierExclusion(t *testing.T) {
+	tests := []struct {
+		name             string
+		policy           *api.OutlierPolicy
+		expectedCutoff   time.Duration
+		expectedExcluded int64
+	}{
+		{name: "None"},
+		{
+			name:   "IQR",
+			policy: &api.OutlierPolicy{Method: api.OutlierIQR},
+			// Q1 is 27ms and Q3 is 77ms, so the cutoff is 77ms + 1.5 * 50ms, less
+			// the latencyHistogram's error
+			expectedCutoff:   152 * time.Millisecond,
+			expectedExcluded: 2,
+		},
+		{
+			name:             "Cutoff",
+			policy:           &api.OutlierPolicy{Method: api.OutlierCutoff, Cutoff: "50ms"},
+			expectedCutoff:   50 * time.Millisecond,
+			expectedExcluded: 52,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			url1 := "http://someurl/1"
+			runResults := api.RunResults{
+				RunSummary:      api.RunSummary{RqstStats: api.RqstStats{MinRqstDurationNanos: math.MaxInt64}},
+				EndpointSummary: make(map[string]map[string]int),
+			}
+			epRunSummary := make(map[string]*api.EndpointDetail)
+			rh := ResponseHandler{OutputType: JSON, OutlierPolicy: tc.policy}
+			acc := stats.NewAccumulator(stats.Config{Start: time.Now()})
+
+			// 100 requests taking 1ms to 100ms, and 2 GC pauses of 10s
+			durations := []time.Duration{10 * time.Second, 10 * time.Second}
+			for i := 1; i <= 100; i++ {
+				durations = append(durations, time.Duration(i)*time.Millisecond)
+			}
+			for _, d := range durations {
+				rh.accumulateResponseStats(Response{
+					HTTPStatus:      http.StatusOK,
+					Endpoint:        api.Endpoint{URL: url1, Method: http.MethodGet},
+					RequestDuration: d,
+				}, acc, &runResults, epRunSummary)
+			}
+			if err := rh.finalizeResponseStats(acc, &runResults, epRunSummary); err != nil {
+				t.Fatalf("unexpected error finalizing response stats: %s", err)
+			}
+
+			raw := runResults.RunSummary.RqstStats
+			if raw.TotalRqsts != int64(len(durations)) || raw.MaxRqstDurationNanos != 10*time.Second {
+				t.Errorf("expected the raw stats to include every request, got %d requests with a max of %s",
+					raw.TotalRqsts, raw.MaxRqstDurationNanos)
+			}
+			if runResults.Meta.OutlierPolicy != tc.policy {
+				t.Errorf("expected the outlier policy %+v to be echoed in Meta, got %+v", tc.policy, runResults.Meta.OutlierPolicy)
+			}
+
+			excluded := runResults.RunSummary.OutlierExcluded
+			if tc.policy == nil {
+				if excluded != nil {
+					t.Errorf("expected no outlier stats without a policy, got %+v", excluded)
+				}
+				return
+			}
+			if diff := excluded.CutoffNanos - tc.expectedCutoff; diff > 0 || diff < -tc.expectedCutoff/50 {
+				t.Errorf("expected a cutoff of %s, got %s", tc.expectedCutoff, excluded.CutoffNanos)
+			}
+			if excluded.ExcludedRqsts != tc.expectedExcluded {
+				t.Errorf("expected %d excluded requests, got %d", tc.expectedExcluded, excluded.ExcludedRqsts)
+			}
+			if excluded.ExcludedRqsts+excluded.RqstStats.TotalRqsts != raw.TotalRqsts ||
+				excluded.ExcludedDurationNanos+excluded.RqstStats.TotalRequestDurationNanos != raw.TotalRequestDurationNanos {
+				t.Errorf("expected the excluded and remaining requests to account for every request, got %+v", excluded)
+			}
+			if excluded.RqstStats.MaxRqstDurationNanos > excluded.CutoffNanos {
+				t.Errorf("expected the max duration excluding outliers to be at most %s, got %s", excluded.CutoffNanos,
+					excluded.RqstStats.MaxRqstDurationNanos)
+			}
+			if excluded.RqstStats.TimingResultsNanos != nil || excluded.LatencyNanos["Min"] != time.Millisecond ||
+				excluded.LatencyNanos["P99"] < excluded.RqstStats.MaxRqstDurationNanos*63/64 {
+				t.Errorf("expected only the percentiles of the requests excluding outliers to be kept, got %+v", excluded)
+			}
+			if raw.TimingResultsNanos[0] != 10*time.Second || raw.TimingResultsNanos[2] != time.Millisecond {
+				t.Errorf("expected the raw latencies to stay in the order the requests completed")
+			}
+		})
+	}
+}
+
+// TestLatencyHistogram verifies the latencyHistogram's percentiles are within its
+// error of the actual percentiles
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	var durations []time.Duration
+	for i := 1; i <= 10000; i++ {
+		d := time.Duration(i*i) * time.Microsecond
+		h.add(d)
+		durations = append(durations, d)
+	}
+	for _, p := range []int{0, 25, 50, 75, 90, 95, 99, 100} {
+		actual := calcPercentiles(p, durations)
+		if p == 50 {
+			// calcPMedian averages the middle 2 latencies
+			actual = durations[4999]
+		}
+		if estimate := h.percentile(p); estimate > actual || estimate < actual-actual/latencyHistogramSubBuckets {
+			t.Errorf("expected P%d to be within 1/%d of %s, got %s", p, latencyHistogramSubBuckets, actual, estimate)
+		}
+	}
+	for _, d := range []time.Duration{0, 1, 63, 64, 65, 127, 128, time.Hour, math.MaxInt64} {
+		if start := latencyBucketStart(latencyBucket(d)); start > d || start < d-d/latencyHistogramSubBuckets {
+			t.Errorf("expected %d's bucket to start within 1/%d below it, got %d", d, latencyHistogramSubBuckets, start)
+		}
+	}
+}
+
+// TestCalcPercentilesUnsorted verifies that calcPercentiles doesn't reorder the
+// caller's latencies
+func TestCalcPercentilesUnsorted(t *testing.T) {
+	durations := []time.Duration{3, 1, 2}
+	for _, p := range []int{0, 50, 99} {
+		calcPercentiles(p, durations)
+		if durations[0] != 3 || durations[1] != 1 || durations[2] != 2 {
+			t.Errorf("expected calcPercentiles(%d) to leave the latencies unsorted, got %v", p, durations)
+		}
+	}
+}
+
+func TestOutlierPolicyValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     *api.OutlierPolicy
+		shouldFail bool
+	}{
+		{name: "None"},
+		{name: "IQR", policy: &api.OutlierPolicy{Method: api.OutlierIQR, Multiplier: 3}},
+		{name: "Cutoff", policy: &api.OutlierPolicy{Method: api.OutlierCutoff, Cutoff: "2s"}},
+		{name: "NegativeMultiplier", policy: &api.OutlierPolicy{Method: api.OutlierIQR, Multiplier: -1}, shouldFail: true},
+		{name: "MissingCutoff", policy: &api.OutlierPolicy{Method: api.OutlierCutoff}, shouldFail: true},
+		{name: "UnknownMethod", policy: &api.OutlierPolicy{Method: "zscore"}, shouldFail: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateOutlierPolicy(tc.policy)
+			if (err != nil) != tc.shouldFail {
+				t.Errorf("expected failure to be %t, got error %v", tc.shouldFail, err)
+			}
+		})
+	}
+}
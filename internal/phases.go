@@ -0,0 +1,225 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// phaseTiming records when a phase ran
+type phaseTiming struct {
+	name  string
+	start time.Time
+	// end is zero while the phase is running
+	end time.Time
+}
+
+// PhaseTracker records when each phase of a run starts and ends so the ResponseHandler
+// can summarize each phase. It's shared by the PhaseScheduler and ResponseHandler.
+type PhaseTracker struct {
+	mux    sync.Mutex
+	phases []phaseTiming
+}
+
+// NewPhaseTracker returns a PhaseTracker
+func NewPhaseTracker() *PhaseTracker {
+	return &PhaseTracker{}
+}
+
+// started records that the phase 'name' started
+func (t *PhaseTracker) started(name string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.phases = append(t.phases, phaseTiming{name: name, start: time.Now()})
+}
+
+// ended records that the most recently started phase ended
+func (t *PhaseTracker) ended() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if len(t.phases) > 0 {
+		t.phases[len(t.phases)-1].end = time.Now()
+	}
+}
+
+// timings returns the timings of the phases that have started, in the order they started
+func (t *PhaseTracker) timings() []phaseTiming {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return append([]phaseTiming(nil), t.phases...)
+}
+
+// RequestorFactory returns an IRequestor that makes requests until 'ctx' is done and
+// sends their responses to 'responseC'
+type RequestorFactory func(ctx context.Context, responseC chan Response) IRequestor
+
+// scheduledPhase is a validated api.Phase
+type scheduledPhase struct {
+	name      string
+	runDur    time.Duration
+	numRqsts  int
+	endpoints []api.Endpoint
+}
+
+// PhaseScheduler runs the phases of a run sequentially, scheduling the requests of
+// each phase like Scheduler does for an entire run. The responses of each phase are
+// labelled with the phase's name.
+type PhaseScheduler struct {
+	ctx         context.Context
+	concurrency int
+	rqstRate    float64
+	// pause is how long to pause between phases
+	pause     time.Duration
+	phases    []scheduledPhase
+	responseC chan Response
+	newRqstr  RequestorFactory
+	tracker   *PhaseTracker
+}
+
+// NewPhaseScheduler returns a valid PhaseScheduler for the phases in 'config'. The
+// responses of every phase are sent to 'responseC', which is closed after the last
+// phase. The run ends early if 'ctx' is done.
+func NewPhaseScheduler(ctx context.Context, config api.LoadTestConfig, responseC chan Response,
+	newRqstr RequestorFactory, tracker *PhaseTracker) (*PhaseScheduler, error) {
+
+	pause, err := time.ParseDuration(config.PhasePause)
+	if config.PhasePause != "" && err != nil {
+		return nil, fmt.Errorf("PhasePause, %s, is invalid: %w", config.PhasePause, err)
+	}
+
+	phases, err := resolvePhases(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := PhaseScheduler{
+		ctx:         ctx,
+		concurrency: config.MaxConcurrentRqsts,
+		rqstRate:    float64(config.RqstRate),
+		pause:       pause,
+		phases:      phases,
+		responseC:   responseC,
+		newRqstr:    newRqstr,
+		tracker:     tracker,
+	}
+	return &s, nil
+}
+
+// resolvePhases validates the phases in 'config', resolving the names of their
+// endpoints to the endpoints themselves
+func resolvePhases(config api.LoadTestConfig) ([]scheduledPhase, error) {
+	eps := make(map[string]api.Endpoint, len(config.Endpoints))
+	for _, ep := range config.Endpoints {
+		if ep.Name == "" {
+			continue
+		}
+		if _, ok := eps[ep.Name]; ok {
+			return nil, fmt.Errorf("there is more than one endpoint named %s", ep.Name)
+		}
+		eps[ep.Name] = ep
+	}
+
+	phases := make([]scheduledPhase, 0, len(config.Phases))
+	names := make(map[string]bool, len(config.Phases))
+	for _, p := range config.Phases {
+		if p.Name == "" {
+			return nil, fmt.Errorf("every phase must have a Name")
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("there is more than one phase named %s", p.Name)
+		}
+		names[p.Name] = true
+
+		phase := scheduledPhase{name: p.Name, numRqsts: p.NumRequests}
+		if p.RunDuration != "" {
+			var err error
+			if phase.runDur, err = time.ParseDuration(p.RunDuration); err != nil {
+				return nil, fmt.Errorf("phase %s: RunDuration, %s, is invalid: %w", p.Name, p.RunDuration, err)
+			}
+		}
+		for _, phaseEP := range p.Endpoints {
+			ep, ok := eps[phaseEP.Name]
+			if !ok {
+				return nil, fmt.Errorf("phase %s: there is no endpoint named %s", p.Name, phaseEP.Name)
+			}
+			if phaseEP.RqstPercent > 0 {
+				ep.RqstPercent = phaseEP.RqstPercent
+			}
+			phase.endpoints = append(phase.endpoints, ep)
+		}
+		if err := validateConfig(config.MaxConcurrentRqsts, float64(config.RqstRate), phase.runDur, phase.numRqsts, phase.endpoints); err != nil {
+			return nil, fmt.Errorf("phase %s: %w", p.Name, err)
+		}
+		phases = append(phases, phase)
+	}
+	return phases, nil
+}
+
+// Start runs the phases in order
+func (s PhaseScheduler) Start() error {
+	defer close(s.responseC)
+
+	for i, phase := range s.phases {
+		if i > 0 && s.pause > 0 {
+			select {
+			case <-s.ctx.Done():
+			case <-time.After(s.pause):
+			}
+		}
+		if s.ctx.Err() != nil {
+			log.Debug().Msgf("PhaseScheduler: run ended before phase %s", phase.name)
+			return nil
+		}
+		s.runPhase(phase)
+	}
+
+	return nil
+}
+
+// runPhase makes the requests of 'phase', returning once all of its responses have
+// been sent to s.responseC
+func (s PhaseScheduler) runPhase(phase scheduledPhase) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if phase.runDur > 0 {
+		ctx, cancel = context.WithTimeout(s.ctx, phase.runDur)
+	} else {
+		ctx, cancel = context.WithCancel(s.ctx)
+	}
+	defer cancel()
+
+	// The phase's responses are labelled with its name as they're forwarded
+	phaseC := make(chan Response, cap(s.responseC))
+	forwarded := make(chan struct{})
+	go func() {
+		for resp := range phaseC {
+			resp.Phase = phase.name
+			s.responseC <- resp
+		}
+		close(forwarded)
+	}()
+
+	log.Debug().Msgf("PhaseScheduler: starting phase %s", phase.name)
+	s.tracker.started(phase.name)
+	schedlr := Scheduler{
+		concurrency: s.concurrency,
+		rqstRate:    s.rqstRate,
+		runDur:      phase.runDur,
+		numRqsts:    phase.numRqsts,
+		endpoints:   phase.endpoints,
+		rqstr:       s.newRqstr(ctx, phaseC),
+	}
+	schedlr.Start()
+	<-forwarded
+	s.tracker.ended()
+}
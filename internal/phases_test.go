@@ -0,0 +1,139 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestPhases verifies that phases run sequentially, each making requests only to its
+// own endpoints, and that each phase is summarized separately.
+func TestPhases(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testSrv.Close()
+
+	writeURL, readURL := testSrv.URL+"/write", testSrv.URL+"/read"
+	config := api.LoadTestConfig{
+		MaxConcurrentRqsts: 2,
+		PhasePause:         "10ms",
+		Endpoints: []api.Endpoint{
+			{Name: "write", URL: writeURL, Method: http.MethodPost, RqstPercent: 100},
+			{Name: "read", URL: readURL, Method: http.MethodGet, RqstPercent: 100},
+		},
+		Phases: []api.Phase{
+			{Name: "load", NumRequests: 10, Endpoints: []api.PhaseEndpoint{{Name: "write"}}},
+			{Name: "measure", NumRequests: 20, Endpoints: []api.PhaseEndpoint{
+				{Name: "read", RqstPercent: 50},
+				{Name: "write", RqstPercent: 50},
+			}},
+		},
+	}
+
+	responseC := make(chan Response, config.MaxConcurrentRqsts)
+	tracker := NewPhaseTracker()
+	rh := ResponseHandler{
+		OutputType: JSON,
+		ResponseC:  responseC,
+		DoneC:      make(chan interface{}),
+		Phases:     tracker,
+		Output:     ioutil.Discard,
+	}
+	go rh.Start()
+
+	newRqstr := func(ctx context.Context, responseC chan Response) IRequestor {
+		return Requestor{Ctx: ctx, ResponseC: responseC, Client: http.Client{}}
+	}
+	s, err := NewPhaseScheduler(context.Background(), config, responseC, newRqstr, tracker)
+	if err != nil {
+		t.Fatalf("unexpected error creating the phase scheduler: %s", err)
+	}
+	s.Start()
+	<-rh.DoneC
+
+	if rh.Results == nil {
+		t.Fatalf("expected results")
+	}
+	if rh.Results.RunSummary.RqstStats.TotalRqsts != 30 {
+		t.Errorf("expected 30 requests in the run, got %d", rh.Results.RunSummary.RqstStats.TotalRqsts)
+	}
+
+	phases := rh.Results.Phases
+	if len(phases) != 2 || phases[0].Name != "load" || phases[1].Name != "measure" {
+		t.Fatalf("expected summaries of the load and measure phases, got %+v", phases)
+	}
+	if phases[0].EndOffsetNanos > phases[1].StartOffsetNanos {
+		t.Errorf("expected the load phase, ending at %s, to end before the measure phase started at %s",
+			phases[0].EndOffsetNanos, phases[1].StartOffsetNanos)
+	}
+
+	expected := []map[string]int64{
+		{writeURL: 10},
+		{writeURL: 10, readURL: 10},
+	}
+	for i, phase := range phases {
+		if phase.RunSummary.RqstStats.TotalRqsts != int64(len(expected[i])*10) {
+			t.Errorf("expected %d requests in phase %s, got %d", len(expected[i])*10, phase.Name,
+				phase.RunSummary.RqstStats.TotalRqsts)
+		}
+		if len(phase.EndpointDetails) != len(expected[i]) {
+			t.Errorf("expected %d endpoints in phase %s, got %d", len(expected[i]), phase.Name, len(phase.EndpointDetails))
+		}
+		for url, numRqsts := range expected[i] {
+			epDetail := phase.EndpointDetails[url]
+			if epDetail == nil {
+				t.Errorf("expected phase %s to have requests to %s", phase.Name, url)
+				continue
+			}
+			var actual int64
+			for _, stats := range epDetail.HTTPMethodRqstStats {
+				actual += stats.TotalRqsts
+			}
+			if actual != numRqsts {
+				t.Errorf("expected %d requests to %s in phase %s, got %d", numRqsts, url, phase.Name, actual)
+			}
+		}
+	}
+
+	if len(rh.Results.RunSummary.TimeSeries) == 0 || rh.Results.RunSummary.TimeSeries[0].PhaseStarted == "" {
+		t.Errorf("expected the time series to record when the phases started, got %+v", rh.Results.RunSummary.TimeSeries)
+	}
+}
+
+// TestPhaseConfigErrors verifies that invalid phases are rejected.
+func TestPhaseConfigErrors(t *testing.T) {
+	eps := []api.Endpoint{
+		{Name: "read", URL: "http://someurl/1", Method: http.MethodGet, RqstPercent: 100},
+	}
+	tests := []struct {
+		name   string
+		phases []api.Phase
+	}{
+		{name: "UnknownEndpoint", phases: []api.Phase{{Name: "p1", NumRequests: 10, Endpoints: []api.PhaseEndpoint{{Name: "write"}}}}},
+		{name: "MissingName", phases: []api.Phase{{NumRequests: 10, Endpoints: []api.PhaseEndpoint{{Name: "read"}}}}},
+		{name: "DuplicateName", phases: []api.Phase{
+			{Name: "p1", NumRequests: 10, Endpoints: []api.PhaseEndpoint{{Name: "read"}}},
+			{Name: "p1", NumRequests: 10, Endpoints: []api.PhaseEndpoint{{Name: "read"}}},
+		}},
+		{name: "RqstPercent", phases: []api.Phase{{Name: "p1", NumRequests: 10, Endpoints: []api.PhaseEndpoint{{Name: "read", RqstPercent: 50}}}}},
+		{name: "NoBudget", phases: []api.Phase{{Name: "p1", Endpoints: []api.PhaseEndpoint{{Name: "read"}}}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := api.LoadTestConfig{MaxConcurrentRqsts: 1, Endpoints: eps, Phases: tc.phases}
+			if _, err := NewPhaseScheduler(context.Background(), config, make(chan Response), nil, NewPhaseTracker()); err == nil {
+				t.Errorf("expected an error for phases %+v", tc.phases)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// isPipelineable reports whether requests using 'method' may be pipelined. Only
+// idempotent methods without a request body are safe to pipeline since a server
+// may close the connection before responding to every request written to it.
+func isPipelineable(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// maxEmptyPipelineBatches is the most consecutive batches whose connection closed,
+// or timed out, before any of their responses were read. The endpoint's remaining
+// requests are dropped after that rather than being resent forever.
+const maxEmptyPipelineBatches = 3
+
+// pipelineDeadlinePast is the deadline set on a pipelined connection to unblock its
+// reads and writes once the run ends
+var pipelineDeadlinePast = time.Unix(1, 0)
+
+// processPipelinedRqsts is the experimental HTTP/1.1 pipelining counterpart to
+// ProcessRqst. http.Transport doesn't support pipelining so the requests are
+// written directly to a connection owned by this goroutine. Requests are sent in
+// batches of ep.PipelineDepth and the responses are read back in the same order
+// the requests were written, as required by RFC 7230. If the server closes the
+// connection part way through a batch the unanswered requests are resent on a
+// new connection. Each read and write is limited by the Client's Timeout, if it has
+// one, and is interrupted when the run ends.
+func (r Requestor) processPipelinedRqsts(ep api.Endpoint, numRqsts int, rqstRate float64) {
+	if !isPipelineable(ep.Method) || len(ep.RqstBody) > 0 {
+		log.Warn().Msgf("Requestor - endpoint %s %s can't be pipelined, only GET and HEAD requests without a body are supported",
+			ep.Method, ep.URL)
+		return
+	}
+
+	u, err := url.Parse(ep.URL)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor unable to parse URL %s", ep.URL)
+		return
+	}
+
+	req, err := http.NewRequest(ep.Method, ep.URL, nil)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor unable to create http request")
+		return
+	}
+	for headerName, headerValue := range ep.Headers {
+		req.Header.Add(headerName, headerValue)
+	}
+
+	if numRqsts == 0 {
+		log.Debug().Msgf("processPipelinedRqsts: EP: %s, numRqsts was 0, setting to %d", ep.URL, api.MaxRqsts)
+		numRqsts = api.MaxRqsts
+	}
+
+	var (
+		conn net.Conn
+		br   *bufio.Reader
+		bw   *bufio.Writer
+		// connResps is the number of responses read from conn
+		connResps int
+		// stopInterrupt stops interrupting conn when the run ends
+		stopInterrupt func() bool
+		// emptyBatches is the number of consecutive batches without any responses
+		emptyBatches int
+	)
+	closeConn := func() {
+		stopInterrupt()
+		conn.Close()
+		conn = nil
+	}
+	defer func() {
+		if conn != nil {
+			closeConn()
+		}
+	}()
+
+	for completed := 0; completed < numRqsts; {
+		if r.Ctx.Err() != nil {
+			log.Debug().Msg("Requestor cancelled or the run duration expired, exiting")
+			return
+		}
+		if emptyBatches >= maxEmptyPipelineBatches {
+			log.Warn().Msgf("Requestor: %d pipelined connections to %s closed without any responses, dropping %d remaining requests",
+				emptyBatches, u.Host, numRqsts-completed)
+			return
+		}
+		if r.Budget.spent() {
+			log.Debug().Msg("Requestor: the run's Budget was reached, exiting")
+			return
+		}
+		if r.FirstFailure.stopped() {
+			log.Debug().Msg("Requestor: the run was stopped by the first failure, exiting")
+			return
+		}
+		if conn == nil {
+			connStart := time.Now()
+			conn, err = r.dialPipelineConn(u, ep.UnixSocket)
+			if err != nil {
+				log.Warn().Err(err).Msgf("Requestor: error connecting to %s, dropping %d remaining requests", u.Host, numRqsts-completed)
+				return
+			}
+			log.Debug().Msgf("Requestor: pipelined connection to %s established in %s", u.Host, time.Since(connStart))
+			br = bufio.NewReader(conn)
+			bw = bufio.NewWriter(conn)
+			connResps = 0
+			c := conn
+			stopInterrupt = context.AfterFunc(r.Ctx, func() { c.SetDeadline(pipelineDeadlinePast) })
+		}
+
+		batchSize := ep.PipelineDepth
+		if remaining := numRqsts - completed; remaining < batchSize {
+			batchSize = remaining
+		}
+
+		start := time.Now()
+		if !r.setPipelineDeadline(conn.SetWriteDeadline) {
+			return
+		}
+		for i := 0; i < batchSize; i++ {
+			if err = req.Write(bw); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err != nil {
+			if r.Ctx.Err() == nil {
+				log.Warn().Err(err).Msgf("Requestor: error writing pipelined requests to %s, dropping %d remaining requests", u.Host, numRqsts-completed)
+			}
+			return
+		}
+
+		emptyBatches++
+		for i := 0; i < batchSize; i++ {
+			if !r.setPipelineDeadline(conn.SetReadDeadline) {
+				return
+			}
+			resp, err := http.ReadResponse(br, req)
+			ttfb := time.Since(start)
+			if err != nil {
+				if r.Ctx.Err() != nil {
+					log.Debug().Msg("Requestor cancelled or the run duration expired, exiting")
+					return
+				}
+				// The server closed the connection, or didn't respond in time, before
+				// answering every request in the batch. The unanswered requests will be
+				// resent on a new connection.
+				log.Debug().Err(err).Msgf("Requestor: pipelined connection to %s closed after %d of %d responses", u.Host, i, batchSize)
+				closeConn()
+				break
+			}
+			emptyBatches = 0
+			bytesReceived, _ := io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			completed++
+			connResps++
+
+			response := Response{
+				HTTPStatus:       resp.StatusCode,
+				Endpoint:         api.Endpoint{URL: summaryURL(ep, ep.URL), Method: ep.Method, GroupByHeader: ep.GroupByHeader, Description: ep.Description},
+				Header:           resp.Header,
+				RequestDuration:  time.Since(start),
+				TTFB:             ttfb,
+				ServerClosedConn: resp.Close,
+				Proto:            resp.Proto,
+				ConnReused:       connResps > 1,
+				Host:             u.Hostname(),
+				RemoteIP:         remoteIP(conn.RemoteAddr()),
+				BytesReceived:    bytesReceived,
+				RecordedHeaders:  r.recordHeaders(resp.Header),
+				TraceID:          traceID(r.TraceIDHeader, resp.Header, ep.Headers),
+			}
+			if !r.sendResponse(response) {
+				log.Debug().Msg("Requestor cancelled or the run duration expired, exiting")
+				return
+			}
+			if r.stopOnFailure(response, nil) || r.recordEarlyFail(ep, response) {
+				return
+			}
+
+			if resp.Close {
+				closeConn()
+				break
+			}
+		}
+
+		// Zero request rate is completely unthrottled
+		if rqstRate == 0 {
+			continue
+		}
+		delta := time.Duration(batchSize)*rqstInterval(rqstRate) - time.Since(start)
+		if delta > 0 {
+			time.Sleep(delta)
+		}
+	}
+}
+
+// setPipelineDeadline sets the deadline of a pipelined connection's next read or
+// write, using 'set', to the Client's Timeout from now, or clears it if the Client
+// doesn't have one. It returns false if the run has ended, in which case the
+// deadline set when it ended mustn't be replaced.
+func (r Requestor) setPipelineDeadline(set func(time.Time) error) bool {
+	var deadline time.Time
+	if r.Client.Timeout > 0 {
+		deadline = time.Now().Add(r.Client.Timeout)
+	}
+	set(deadline)
+	return r.Ctx.Err() == nil
+}
+
+// dialPipelineConn opens the connection used to pipeline requests to the host in 'u',
+// or to the Unix domain socket 'socket' if it's set. HTTPS connections use the TLS
+// configuration of the Requestor's Client.Transport when one is available.
+func (r Requestor) dialPipelineConn(u *url.URL, socket string) (net.Conn, error) {
+	network, host := "tcp", u.Host
+	if socket != "" {
+		network, host = "unix", socket
+	} else if u.Port() == "" {
+		switch u.Scheme {
+		case "http":
+			host = net.JoinHostPort(u.Hostname(), "80")
+		case "https":
+			host = net.JoinHostPort(u.Hostname(), "443")
+		default:
+			return nil, fmt.Errorf("unsupported URL scheme %s", u.Scheme)
+		}
+	}
+
+	dialer := net.Dialer{Timeout: r.Client.Timeout}
+	conn, err := dialer.DialContext(r.Ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return conn, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if t, ok := r.Client.Transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+		tlsConfig = t.TLSClientConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
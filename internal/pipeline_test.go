@@ -0,0 +1,226 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// latentConn simulates network latency between the client and the server by
+// delaying every read from the underlying connection.
+type latentConn struct {
+	net.Conn
+	latency time.Duration
+}
+
+func (c latentConn) Read(b []byte) (int, error) {
+	time.Sleep(c.latency)
+	return c.Conn.Read(b)
+}
+
+// startPipeliningServer starts a minimal HTTP/1.1 server that supports pipelining.
+// Requests are answered in order and responses are only flushed once there are no
+// more buffered requests. If 'maxRqstsPerConn' is greater than 0 the server will
+// close the connection after answering that many requests.
+func startPipeliningServer(t *testing.T, latency time.Duration, maxRqstsPerConn int) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start pipelining server: %s", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				br := bufio.NewReader(latentConn{Conn: conn, latency: latency})
+				bw := bufio.NewWriter(conn)
+				for numRqsts := 1; ; numRqsts++ {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+					io.Copy(ioutil.Discard, req.Body)
+
+					closeConn := maxRqstsPerConn > 0 && numRqsts == maxRqstsPerConn
+					connHeader := "keep-alive"
+					if closeConn {
+						connHeader = "close"
+					}
+					fmt.Fprintf(bw, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: %s\r\n\r\nok", connHeader)
+					if closeConn {
+						bw.Flush()
+						return
+					}
+					if br.Buffered() == 0 {
+						bw.Flush()
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	return l
+}
+
+func runPipelineRqstr(t *testing.T, ep api.Endpoint, numRqsts int) time.Duration {
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    http.Client{},
+	}
+
+	start := time.Now()
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	elapsed := time.Since(start)
+	close(respC)
+
+	numResps := 0
+	for resp := range respC {
+		if resp.HTTPStatus != http.StatusOK {
+			t.Errorf("expected HTTP status %d, got %d", http.StatusOK, resp.HTTPStatus)
+		}
+		numResps++
+	}
+	if numResps != numRqsts {
+		t.Errorf("expected %d responses, got %d", numRqsts, numResps)
+	}
+
+	return elapsed
+}
+
+// TestPipeliningThroughput verifies that pipelining requests over a connection with
+// significant latency completes the same number of requests in less time than sending
+// them one at a time.
+func TestPipeliningThroughput(t *testing.T) {
+	l := startPipeliningServer(t, 10*time.Millisecond, 0)
+	defer l.Close()
+
+	numRqsts := 20
+	ep := api.Endpoint{
+		URL:         "http://" + l.Addr().String() + "/pipeline",
+		Method:      http.MethodGet,
+		RqstPercent: 100,
+	}
+	sequential := runPipelineRqstr(t, ep, numRqsts)
+
+	ep.PipelineDepth = 10
+	pipelined := runPipelineRqstr(t, ep, numRqsts)
+
+	t.Logf("sequential: %s, pipelined: %s", sequential, pipelined)
+	if pipelined >= sequential/2 {
+		t.Errorf("expected pipelined requests to take less than half of %s, took %s", sequential, pipelined)
+	}
+}
+
+// TestPipeliningServerClose verifies that requests left unanswered when the server
+// closes a pipelined connection are resent on a new connection.
+func TestPipeliningServerClose(t *testing.T) {
+	l := startPipeliningServer(t, 0, 3)
+	defer l.Close()
+
+	ep := api.Endpoint{
+		URL:           "http://" + l.Addr().String() + "/pipeline",
+		Method:        http.MethodGet,
+		RqstPercent:   100,
+		PipelineDepth: 5,
+	}
+	runPipelineRqstr(t, ep, 10)
+}
+
+// startStalledServer starts a server that accepts connections and reads their
+// requests but never responds. If 'closeConns' is true it closes each connection
+// as soon as it's accepted instead.
+func startStalledServer(t *testing.T, closeConns bool) (net.Listener, *int64) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start the stalled server: %s", err)
+	}
+	var accepted int64
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&accepted, 1)
+			if closeConns {
+				conn.Close()
+				continue
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(ioutil.Discard, conn)
+			}()
+		}
+	}()
+	return l, &accepted
+}
+
+// TestPipeliningStalledServer verifies pipelined requests to a server that never
+// responds are abandoned when the run ends or the Client's Timeout expires, and that
+// a server that closes every connection before responding isn't redialed forever
+func TestPipeliningStalledServer(t *testing.T) {
+	stalled, _ := startStalledServer(t, false)
+	defer stalled.Close()
+	closing, accepted := startStalledServer(t, true)
+	defer closing.Close()
+
+	tests := []struct {
+		name    string
+		addr    string
+		runDur  time.Duration
+		timeout time.Duration
+	}{
+		{name: "RunEnds", addr: stalled.Addr().String(), runDur: 200 * time.Millisecond},
+		{name: "ClientTimeout", addr: stalled.Addr().String(), timeout: 50 * time.Millisecond},
+		{name: "ServerCloses", addr: closing.Addr().String()},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.runDur > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.runDur)
+				defer cancel()
+			}
+			respC := make(chan Response, 10)
+			rqstr := Requestor{Ctx: ctx, ResponseC: respC, Client: http.Client{Timeout: tc.timeout}}
+			ep := api.Endpoint{URL: "http://" + tc.addr + "/pipeline", Method: http.MethodGet, PipelineDepth: 5}
+
+			done := make(chan struct{})
+			go func() {
+				rqstr.ProcessRqst(ep, 10, 0)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("expected the pipelined requests to be abandoned")
+			}
+			if len(respC) != 0 {
+				t.Errorf("expected no responses, got %d", len(respC))
+			}
+		})
+	}
+	if n := atomic.LoadInt64(accepted); n > maxEmptyPipelineBatches {
+		t.Errorf("expected at most %d connections to the closing server, got %d", maxEmptyPipelineBatches, n)
+	}
+}
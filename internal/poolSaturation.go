@@ -0,0 +1,53 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// minPoolWait is the shortest wait for a connection counted as waiting for the pool.
+// Getting an idle connection, or starting to dial a new one, takes far less, so
+// waits this long were spent blocked on the pool's connections being in use.
+const minPoolWait = time.Millisecond
+
+// connPoolLimited returns true if 'client's transport limits its connections per host
+func connPoolLimited(client http.Client) bool {
+	t, ok := client.Transport.(*http.Transport)
+	return ok && t.MaxConnsPerHost > 0
+}
+
+// poolWait returns the time from 'getConn', when a connection was requested, to
+// 'waitEnd', when one was obtained or started to be set up, if the request waited on
+// a pool limited by MaxConnsPerHost. It's 0 if 'limited' is false, either time wasn't
+// recorded, or the wait was shorter than minPoolWait.
+func poolWait(limited bool, getConn, waitEnd time.Time) time.Duration {
+	if !limited || getConn.IsZero() || waitEnd.IsZero() {
+		return 0
+	}
+	if wait := waitEnd.Sub(getConn); wait >= minPoolWait {
+		return wait
+	}
+	return 0
+}
+
+// accumulatePoolWait adds the pool wait of 'resp', if it waited, to 'rs's PoolSaturation
+func accumulatePoolWait(resp Response, rs *api.RunSummary) {
+	if resp.PoolWait <= 0 {
+		return
+	}
+	if rs.PoolSaturation == nil {
+		rs.PoolSaturation = &api.PoolSaturation{}
+	}
+	ps := rs.PoolSaturation
+	ps.WaitedRqsts++
+	ps.TotalWaitNanos += resp.PoolWait
+	if resp.PoolWait > ps.MaxWaitNanos {
+		ps.MaxWaitNanos = resp.PoolWait
+	}
+}
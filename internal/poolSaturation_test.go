@@ -0,0 +1,108 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestPoolSaturation verifies that requests waiting for a connection from a pool
+// limited by MaxConnsPerHost are counted, along with their wait, and that requests
+// to a client without a limit aren't
+func TestPoolSaturation(t *testing.T) {
+	const handlerDelay = 20 * time.Millisecond
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(handlerDelay)
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name            string
+		maxConnsPerHost int
+		expectWaits     bool
+	}{
+		{name: "TinyPool", maxConnsPerHost: 1, expectWaits: true},
+		{name: "Unlimited", maxConnsPerHost: 0, expectWaits: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			const concurrency, rqstsPerWorker = 4, 2
+			respC := make(chan Response, concurrency*rqstsPerWorker)
+			client := http.Client{Transport: &http.Transport{MaxConnsPerHost: tc.maxConnsPerHost}}
+			rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: client}
+			ep := api.Endpoint{URL: testSrv.URL + "/users", Method: http.MethodGet}
+
+			var wg sync.WaitGroup
+			for i := 0; i < concurrency; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rqstr.ProcessRqst(ep, rqstsPerWorker, 0)
+				}()
+			}
+			wg.Wait()
+			close(respC)
+			var responses []Response
+			for resp := range respC {
+				responses = append(responses, resp)
+			}
+
+			rh := &ResponseHandler{start: time.Now()}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			ps := runResults.RunSummary.PoolSaturation
+			if !tc.expectWaits {
+				if ps != nil {
+					t.Errorf("expected no pool waits without a MaxConnsPerHost, got %+v", ps)
+				}
+				return
+			}
+			// With a single connection all but the first request wait, at least
+			// until the request ahead of them has been handled
+			if ps == nil || ps.WaitedRqsts < concurrency-1 || ps.MaxWaitNanos < handlerDelay*8/10 ||
+				ps.TotalWaitNanos < ps.MaxWaitNanos {
+				t.Errorf("expected at least %d requests to wait for the pool, got %+v", concurrency-1, ps)
+			}
+		})
+	}
+}
+
+func TestAccumulatePoolWait(t *testing.T) {
+	var rs api.RunSummary
+	for _, resp := range []Response{
+		{PoolWait: 2 * time.Millisecond},
+		{},
+		{PoolWait: 5 * time.Millisecond},
+	} {
+		accumulatePoolWait(resp, &rs)
+	}
+	expected := api.PoolSaturation{WaitedRqsts: 2, TotalWaitNanos: 7 * time.Millisecond, MaxWaitNanos: 5 * time.Millisecond}
+	if rs.PoolSaturation == nil || *rs.PoolSaturation != expected {
+		t.Errorf("expected %+v, got %+v", expected, rs.PoolSaturation)
+	}
+
+	if wait := poolWait(true, time.Time{}, time.Now()); wait != 0 {
+		t.Errorf("expected no wait without a GetConn, got %s", wait)
+	}
+	getConn := time.Now()
+	if wait := poolWait(false, getConn, getConn.Add(time.Second)); wait != 0 {
+		t.Errorf("expected no wait for an unlimited pool, got %s", wait)
+	}
+	if wait := poolWait(true, getConn, getConn.Add(minPoolWait/2)); wait != 0 {
+		t.Errorf("expected a wait shorter than %s not to be counted, got %s", minPoolWait, wait)
+	}
+	if wait := poolWait(true, getConn, getConn.Add(time.Second)); wait != time.Second {
+		t.Errorf("expected a wait of 1s, got %s", wait)
+	}
+}
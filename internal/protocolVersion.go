@@ -0,0 +1,128 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// validateProtocolVersion verifies that 'ep's ProtocolVersion, if it has one, is
+// supported and can be used with its other settings
+func validateProtocolVersion(ep api.Endpoint) error {
+	switch ep.ProtocolVersion {
+	case "", api.ProtocolVersionHTTP11:
+		return nil
+	case api.ProtocolVersionHTTP10:
+	default:
+		return fmt.Errorf("endpoint %s %s has a ProtocolVersion of %q, it must be %s or %s", ep.Method, ep.URL,
+			ep.ProtocolVersion, api.ProtocolVersionHTTP10, api.ProtocolVersionHTTP11)
+	}
+	if ep.PipelineDepth > 1 {
+		return fmt.Errorf("endpoint %s %s has a ProtocolVersion of %s and a PipelineDepth of %d, only HTTP/1.1 requests can be pipelined",
+			ep.Method, ep.URL, ep.ProtocolVersion, ep.PipelineDepth)
+	}
+	return nil
+}
+
+// http10Client returns a copy of 'client' that sends its requests to the endpoint at
+// 'url' as HTTP/1.0. http.Transport always writes HTTP/1.1 requests, so the copy's
+// Transport doesn't keep connections alive, making each connection carry a single
+// request, and rewrites the request line written to each connection. Request bodies
+// are always sent with a Content-Length, never chunked.
+func http10Client(client http.Client, url string) http.Client {
+	t, ok := client.Transport.(*http.Transport)
+	if !ok {
+		if client.Transport != nil {
+			log.Fatal().Msgf("Endpoint: %s, Endpoint.ProtocolVersion %s is only supported for HTTP/1.1 and HTTP/2",
+				url, api.ProtocolVersionHTTP10)
+		}
+		t = http.DefaultTransport.(*http.Transport)
+	}
+	t = t.Clone()
+	t.DisableKeepAlives = true
+	t.ForceAttemptHTTP2 = false
+	t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+
+	dial := t.DialContext
+	if dial == nil {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &http10Conn{Conn: conn}, nil
+	}
+	// The request line has to be rewritten before it's encrypted so the TLS handshake
+	// is done here rather than by the Transport. Its trace callbacks are called here
+	// too, as the Transport would, so the handshake is still timed.
+	tlsConfig := t.TLSClientConfig
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		config := &tls.Config{}
+		if tlsConfig != nil {
+			config = tlsConfig.Clone()
+		}
+		if config.ServerName == "" {
+			if config.ServerName, _, err = net.SplitHostPort(addr); err != nil {
+				config.ServerName = addr
+			}
+		}
+		tlsConn := tls.Client(conn, config)
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.TLSHandshakeStart != nil {
+			trace.TLSHandshakeStart()
+		}
+		err = tlsConn.HandshakeContext(ctx)
+		if trace != nil && trace.TLSHandshakeDone != nil {
+			trace.TLSHandshakeDone(tlsConn.ConnectionState(), err)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &http10Conn{Conn: tlsConn}, nil
+	}
+	client.Transport = t
+	return client
+}
+
+// http10Conn is a connection carrying a single HTTP/1.0 request. It rewrites the
+// request line written by http.Transport from HTTP/1.1 to HTTP/1.0.
+type http10Conn struct {
+	net.Conn
+	// written is true once the request line has been written
+	written bool
+}
+
+// Write implements net.Conn. The request line is the first line of the first write.
+func (c *http10Conn) Write(b []byte) (int, error) {
+	if c.written {
+		return c.Conn.Write(b)
+	}
+	c.written = true
+	proto11 := []byte(" " + api.ProtocolVersionHTTP11 + "\r\n")
+	i := bytes.Index(b, []byte("\r\n"))
+	if i < 0 || !bytes.HasSuffix(b[:i+2], proto11) {
+		return c.Conn.Write(b)
+	}
+	rewritten := append([]byte(nil), b...)
+	copy(rewritten[i+2-len(proto11):], " "+api.ProtocolVersionHTTP10+"\r\n")
+	return c.Conn.Write(rewritten)
+}
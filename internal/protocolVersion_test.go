@@ -0,0 +1,140 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestHTTP10Requests verifies requests to an endpoint with a ProtocolVersion of
+// HTTP/1.0 are sent as HTTP/1.0, without chunked bodies or keep-alive, over both
+// HTTP and HTTPS, and that the TLS handshakes of HTTPS requests are timed
+func TestHTTP10Requests(t *testing.T) {
+	tests := []struct {
+		name   string
+		tls    bool
+		client http.Client
+	}{
+		{name: "HTTP", client: http.Client{Transport: &http.Transport{}}},
+		{name: "HTTPS", tls: true, client: http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				protos   []string
+				chunked  bool
+				newConns int64
+			)
+			testSrv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				mu.Lock()
+				protos = append(protos, r.Proto)
+				chunked = chunked || len(r.TransferEncoding) > 0 || r.ContentLength != int64(len(body))
+				mu.Unlock()
+				w.Write([]byte("OK"))
+			}))
+			testSrv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					atomic.AddInt64(&newConns, 1)
+				}
+			}
+			if tc.tls {
+				testSrv.StartTLS()
+			} else {
+				testSrv.Start()
+			}
+			defer testSrv.Close()
+
+			numRqsts := 3
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    tc.client,
+			}
+			ep := api.Endpoint{URL: testSrv.URL + "/legacy", Method: http.MethodPost, RqstBody: `{"id": 1}`,
+				ProtocolVersion: api.ProtocolVersionHTTP10}
+			rqstr.ProcessRqst(ep, numRqsts, 0)
+			close(respC)
+
+			var responses []Response
+			for resp := range respC {
+				if resp.Err != nil {
+					t.Fatalf("unexpected error sending a request: %s", resp.Err)
+				}
+				if resp.ConnReused {
+					t.Errorf("expected every HTTP/1.0 request to use a new connection")
+				}
+				if tc.tls != (resp.TLSHandshakeDuration > 0) {
+					t.Errorf("expected the TLS handshake to be timed only for HTTPS requests, got %s", resp.TLSHandshakeDuration)
+				}
+				responses = append(responses, resp)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(protos) != numRqsts {
+				t.Fatalf("expected the server to receive %d requests, got %d", numRqsts, len(protos))
+			}
+			for _, proto := range protos {
+				if proto != api.ProtocolVersionHTTP10 {
+					t.Errorf("expected the server to receive HTTP/1.0 requests, got %s", proto)
+				}
+			}
+			if chunked {
+				t.Errorf("expected the request bodies to be sent with a Content-Length, not chunked")
+			}
+			if n := atomic.LoadInt64(&newConns); n != int64(numRqsts) {
+				t.Errorf("expected a connection per request, %d, got %d", numRqsts, n)
+			}
+
+			rh := ResponseHandler{start: time.Now()}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			if n := runResults.RunSummary.RqstProtocols[api.ProtocolVersionHTTP10]; n != int64(numRqsts) {
+				t.Errorf("expected %d requests recorded as sent with HTTP/1.0, got %v", numRqsts, runResults.RunSummary.RqstProtocols)
+			}
+		})
+	}
+}
+
+// TestValidateProtocolVersion verifies only the supported protocol versions are
+// accepted and that HTTP/1.0 requests can't be pipelined
+func TestValidateProtocolVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		ep        api.Endpoint
+		expectErr bool
+	}{
+		{name: "Default", ep: api.Endpoint{}},
+		{name: "HTTP/1.1", ep: api.Endpoint{ProtocolVersion: api.ProtocolVersionHTTP11, PipelineDepth: 4}},
+		{name: "HTTP/1.0", ep: api.Endpoint{ProtocolVersion: api.ProtocolVersionHTTP10}},
+		{name: "Unsupported", ep: api.Endpoint{ProtocolVersion: "HTTP/2.0"}, expectErr: true},
+		{name: "Pipelined HTTP/1.0", ep: api.Endpoint{ProtocolVersion: api.ProtocolVersionHTTP10, PipelineDepth: 4}, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateProtocolVersion(tc.ep); (err != nil) != tc.expectErr {
+				t.Errorf("expected an error: %t, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// tokenBucket is a token bucket holding at most one token, so the requests it allows
+// are evenly spaced. Rather than tracking the tokens it tracks when the next one will
+// be available.
+type tokenBucket struct {
+	mux sync.Mutex
+	// interval is how often a token is added to the bucket
+	interval time.Duration
+	// next is when the next token is available
+	next time.Time
+}
+
+// take waits for a token. It returns false if 'ctx' was done before one was available.
+func (b *tokenBucket) take(ctx context.Context) bool {
+	b.mux.Lock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	at := b.next
+	b.next = b.next.Add(b.interval)
+	b.mux.Unlock()
+
+	wait := time.Until(at)
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// RateLimits limits the rate of requests to each endpoint with an Endpoint.RateLimit,
+// independently of the other endpoints. It's shared by all requestors.
+type RateLimits struct {
+	// buckets is the token bucket of each rate limited endpoint keyed by
+	// earlyFailKey. It's only modified by NewRateLimits so it doesn't need to be
+	// protected by a mutex.
+	buckets map[string]*tokenBucket
+}
+
+// NewRateLimits returns the RateLimits of 'eps', or nil if none of them are rate limited
+func NewRateLimits(eps []api.Endpoint) (*RateLimits, error) {
+	l := RateLimits{buckets: make(map[string]*tokenBucket)}
+	for _, ep := range eps {
+		if ep.RateLimit < 0 {
+			return nil, fmt.Errorf("endpoint %s %s has a RateLimit of %v, it must not be negative", ep.Method, ep.URL, ep.RateLimit)
+		}
+		if ep.RateLimit == 0 {
+			continue
+		}
+		l.buckets[earlyFailKey(ep)] = &tokenBucket{interval: rqstInterval(float64(ep.RateLimit))}
+	}
+	if len(l.buckets) == 0 {
+		return nil, nil
+	}
+	return &l, nil
+}
+
+// wait waits until a request can be made to 'ep'. It returns false if 'ctx' was done
+// before one could.
+func (l *RateLimits) wait(ctx context.Context, ep api.Endpoint) bool {
+	bucket, ok := l.buckets[earlyFailKey(ep)]
+	if !ok {
+		return true
+	}
+	return bucket.take(ctx)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestRateLimits verifies each endpoint's realized request rate stays within its own
+// RateLimit while the run is otherwise unthrottled
+func TestRateLimits(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testSrv.Close()
+
+	eps := []api.Endpoint{
+		{URL: testSrv.URL + "/a", Method: http.MethodGet, RqstPercent: 40, RateLimit: 50},
+		{URL: testSrv.URL + "/b", Method: http.MethodGet, RqstPercent: 40, RateLimit: 200},
+		{URL: testSrv.URL + "/c", Method: http.MethodGet, RqstPercent: 20},
+	}
+	rateLimits, err := NewRateLimits(eps)
+	if err != nil {
+		t.Fatalf("unexpected error creating rate limits: %s", err)
+	}
+
+	runDur := time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), runDur)
+	defer cancel()
+	respC := make(chan Response, 100)
+	rqstr := Requestor{
+		Ctx:        ctx,
+		ResponseC:  respC,
+		Client:     http.Client{},
+		RateLimits: rateLimits,
+	}
+	s, err := NewScheduler(10, 0, runDur, 0, eps, rqstr)
+	if err != nil {
+		t.Fatalf("unexpected error creating the scheduler: %s", err)
+	}
+
+	start := time.Now()
+	go s.Start()
+	counts := make(map[string]int)
+	for resp := range respC {
+		counts[resp.Endpoint.URL]++
+	}
+	elapsed := time.Since(start).Seconds()
+
+	for _, ep := range eps[:2] {
+		rate := float64(counts[ep.URL]) / elapsed
+		// The first request is allowed immediately
+		if limit := float64(ep.RateLimit) + 1/elapsed; rate > limit {
+			t.Errorf("expected the rate of %s to be at most %v rqsts/sec, got %f", ep.URL, ep.RateLimit, rate)
+		}
+		if rate < 0.8*float64(ep.RateLimit) {
+			t.Errorf("expected the rate of %s to be close to its limit of %v rqsts/sec, got %f", ep.URL, ep.RateLimit, rate)
+		}
+	}
+	if unlimited := counts[eps[2].URL]; unlimited <= counts[eps[1].URL] {
+		t.Errorf("expected more requests to the endpoint without a rate limit, got %d", unlimited)
+	}
+}
+
+// TestRateUnits verifies a rate can be given per second, minute, or hour and that it's
+// converted to the rate of the endpoint's limiter and of the scheduler's workers
+func TestRateUnits(t *testing.T) {
+	tests := []struct {
+		rate     string
+		interval time.Duration
+		wantErr  bool
+	}{
+		{rate: `20`, interval: 50 * time.Millisecond},
+		{rate: `0.5`, interval: 2 * time.Second},
+		{rate: `"500/s"`, interval: 2 * time.Millisecond},
+		{rate: `"10/m"`, interval: 6 * time.Second},
+		{rate: `"30/h"`, interval: 2 * time.Minute},
+		{rate: `" 90 / h "`, interval: 40 * time.Second},
+		{rate: `"40"`, interval: 25 * time.Millisecond},
+		{rate: `"30/d"`, wantErr: true},
+		{rate: `"many/h"`, wantErr: true},
+		{rate: `true`, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.rate, func(t *testing.T) {
+			var config api.LoadTestConfig
+			err := json.Unmarshal([]byte(`{"RqstRate": `+tc.rate+`, "Endpoints": [{"URL": "http://someurl/items", "Method": "GET", "RqstPercent": 100, "RateLimit": `+tc.rate+`}]}`), &config)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected an error to be %t, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			rateLimits, err := NewRateLimits(config.Endpoints)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if interval := rateLimits.buckets[earlyFailKey(config.Endpoints[0])].interval; interval != tc.interval {
+				t.Errorf("expected the limiter to allow a request every %s, got %s", tc.interval, interval)
+			}
+
+			s := Scheduler{concurrency: 1, rqstRate: float64(config.RqstRate), numRqsts: 10}
+			_, _, goroutineRqstRate := s.calcEPConfig(config.Endpoints[0])
+			if interval := rqstInterval(goroutineRqstRate); math.Abs(float64(interval-tc.interval)) > float64(time.Microsecond) {
+				t.Errorf("expected the worker to make a request every %s, got %s", tc.interval, interval)
+			}
+		})
+	}
+}
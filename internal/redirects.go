@@ -0,0 +1,115 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// maxRedirects is the number of redirects followed before a request fails, the same
+// as http.Client's default
+const maxRedirects = 10
+
+// crossHostRedirectError is returned when a request is redirected to another host and
+// Requestor.ForbidCrossHostRedirects is set
+type crossHostRedirectError struct {
+	from, to string
+}
+
+func (e crossHostRedirectError) Error() string {
+	return fmt.Sprintf("redirect from %s to another host, %s, is forbidden", e.from, e.to)
+}
+
+// forbidCrossHostRedirects is an http.Client CheckRedirect function that, in addition
+// to the default policy of following up to maxRedirects redirects, fails requests
+// redirected to a host other than the original request's host
+func forbidCrossHostRedirects(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		// The same message as http.Client's default policy, see protocolErrCategory
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if from := via[0].URL; req.URL.Hostname() != from.Hostname() {
+		return crossHostRedirectError{from: from.String(), to: req.URL.String()}
+	}
+	return nil
+}
+
+// isCrossHostRedirectErr reports whether 'err' is due to a forbidden cross-host redirect
+func isCrossHostRedirectErr(err error) bool {
+	var crossHostErr crossHostRedirectError
+	return errors.As(err, &crossHostErr)
+}
+
+// redirectChain returns the URLs of the requests, the original request first, that
+// were redirected to get 'resp', or nil if it wasn't redirected
+func redirectChain(resp *http.Response) []string {
+	if resp.Request == nil || resp.Request.Response == nil {
+		return nil
+	}
+	var chain []string
+	for req := resp.Request; req != nil && len(chain) <= maxRedirects; {
+		chain = append(chain, req.URL.String())
+		if req.Response == nil {
+			break
+		}
+		req = req.Response.Request
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// accumulateRedirects records the final host reached by the successful response
+// 'resp' and whether it was redirected to a different host than the one requested
+func accumulateRedirects(resp Response, rs *api.RunSummary, epDetail *api.EndpointDetail) {
+	finalHost := resp.Host
+	if n := len(resp.RedirectChain); n > 0 {
+		finalHost = urlHost(resp.RedirectChain[n-1])
+		epDetail.RedirectedRqsts++
+	}
+	if finalHost == "" {
+		return
+	}
+	if epDetail.FinalHosts == nil {
+		epDetail.FinalHosts = make(map[string]int64)
+	}
+	epDetail.FinalHosts[finalHost]++
+
+	if finalHost != resp.Host {
+		rs.CrossHostRedirects++
+		epDetail.CrossHostRedirects++
+	}
+}
+
+// finishRedirects omits the final hosts of endpoints that were never redirected and
+// warns of each endpoint that was redirected to another host
+func finishRedirects(rs *api.RunSummary, epDetails map[string]*api.EndpointDetail) {
+	var warnings []string
+	for url, epDetail := range epDetails {
+		if epDetail.RedirectedRqsts == 0 {
+			epDetail.FinalHosts = nil
+			continue
+		}
+		if epDetail.CrossHostRedirects == 0 {
+			continue
+		}
+		hosts := make([]string, 0, len(epDetail.FinalHosts))
+		for host := range epDetail.FinalHosts {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		warnings = append(warnings, fmt.Sprintf("%s: %d requests were redirected to another host, the hosts reached were %s",
+			url, epDetail.CrossHostRedirects, strings.Join(hosts, ", ")))
+	}
+	sort.Strings(warnings)
+	rs.Warnings = append(rs.Warnings, warnings...)
+}
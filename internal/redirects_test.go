@@ -0,0 +1,125 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestRedirects verifies redirect chains are recorded, the final hosts reached are
+// reported, and cross-host redirects are flagged or, if forbidden, fail
+func TestRedirects(t *testing.T) {
+	// prodSrv is reached by redirects to 'localhost' rather than '127.0.0.1', a
+	// different host
+	prodSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer prodSrv.Close()
+	prodURL := strings.Replace(prodSrv.URL, "127.0.0.1", "localhost", 1)
+
+	stagingSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		case "/cross":
+			http.Redirect(w, r, prodURL+"/ok", http.StatusMovedPermanently)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer stagingSrv.Close()
+	sameURL, crossURL := stagingSrv.URL+"/same", stagingSrv.URL+"/cross"
+
+	numRqsts := 3
+	tests := []struct {
+		name                 string
+		forbid               bool
+		expectedCrossHost    int64
+		expectedCrossErrs    int64
+		expectedFinalHosts   map[string]int64
+		expectedCrossWarning bool
+	}{
+		{
+			name:                 "Followed",
+			expectedCrossHost:    int64(numRqsts),
+			expectedFinalHosts:   map[string]int64{"localhost": int64(numRqsts)},
+			expectedCrossWarning: true,
+		},
+		{
+			name:              "Forbidden",
+			forbid:            true,
+			expectedCrossErrs: int64(numRqsts),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			respC := make(chan Response, 2*numRqsts)
+			rqstr := Requestor{
+				Ctx:                      context.Background(),
+				ResponseC:                respC,
+				Client:                   http.Client{},
+				ForbidCrossHostRedirects: tc.forbid,
+			}
+			rqstr.ProcessRqst(api.Endpoint{URL: sameURL, Method: http.MethodGet}, numRqsts, 0)
+			rqstr.ProcessRqst(api.Endpoint{URL: crossURL, Method: http.MethodGet}, numRqsts, 0)
+			close(respC)
+
+			var responses []Response
+			for resp := range respC {
+				if resp.Endpoint.URL == sameURL {
+					expectedChain := []string{sameURL, stagingSrv.URL + "/ok"}
+					if !reflect.DeepEqual(resp.RedirectChain, expectedChain) {
+						t.Errorf("expected redirect chain %v, got %v", expectedChain, resp.RedirectChain)
+					}
+				}
+				if resp.Endpoint.URL == crossURL && !tc.forbid {
+					expectedChain := []string{crossURL, prodURL + "/ok"}
+					if !reflect.DeepEqual(resp.RedirectChain, expectedChain) {
+						t.Errorf("expected redirect chain %v, got %v", expectedChain, resp.RedirectChain)
+					}
+				}
+				responses = append(responses, resp)
+			}
+
+			rh := ResponseHandler{start: time.Now()}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			rs := runResults.RunSummary
+			if rs.CrossHostRedirects != tc.expectedCrossHost {
+				t.Errorf("expected %d cross-host redirects, got %d", tc.expectedCrossHost, rs.CrossHostRedirects)
+			}
+			if errs := rs.ErrorCategories[api.ErrCategoryCrossHostRedirect]; errs != tc.expectedCrossErrs {
+				t.Errorf("expected %d %s errors, got %d", tc.expectedCrossErrs, api.ErrCategoryCrossHostRedirect, errs)
+			}
+
+			sameDetail := runResults.EndpointDetails[sameURL]
+			if expected := map[string]int64{"127.0.0.1": int64(numRqsts)}; !reflect.DeepEqual(sameDetail.FinalHosts, expected) {
+				t.Errorf("expected final hosts %v for %s, got %v", expected, sameURL, sameDetail.FinalHosts)
+			}
+			if sameDetail.CrossHostRedirects != 0 {
+				t.Errorf("expected no cross-host redirects for %s, got %d", sameURL, sameDetail.CrossHostRedirects)
+			}
+			if crossDetail := runResults.EndpointDetails[crossURL]; !reflect.DeepEqual(crossDetail.FinalHosts, tc.expectedFinalHosts) {
+				t.Errorf("expected final hosts %v for %s, got %v", tc.expectedFinalHosts, crossURL, crossDetail.FinalHosts)
+			}
+
+			warned := len(rs.Warnings) == 1 && strings.Contains(rs.Warnings[0], "redirected to another host")
+			if warned != tc.expectedCrossWarning {
+				t.Errorf("expected a cross-host redirect warning to be %t, got %v", tc.expectedCrossWarning, rs.Warnings)
+			}
+		})
+	}
+}
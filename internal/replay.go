@@ -0,0 +1,143 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// maxReplayEntrySize is the largest replay log entry, in bytes, that can be read
+const maxReplayEntrySize = 1 << 20
+
+// ReadReplayLog reads a replay log, a JSON encoded api.ReplayEntry per line, from
+// 'r'. The entries must be in Timestamp order.
+func ReadReplayLog(r io.Reader) ([]api.ReplayEntry, error) {
+	var entries []api.ReplayEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxReplayEntrySize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := api.ReplayEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error unmarshaling replay log line %d: %w", line, err)
+		}
+		if entry.Method == "" || entry.URL == "" {
+			return nil, fmt.Errorf("replay log line %d must have a Method and URL", line)
+		}
+		if len(entries) > 0 && entry.Timestamp.Before(entries[len(entries)-1].Timestamp) {
+			return nil, fmt.Errorf("replay log line %d, timestamp %s, is earlier than the line before it",
+				line, entry.Timestamp.Format(time.RFC3339Nano))
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading replay log: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("replay log is empty")
+	}
+	return entries, nil
+}
+
+// ReplayScheduler makes the requests recorded in a replay log, reproducing the gaps
+// between their timestamps so the recorded traffic's burstiness is preserved rather
+// than being flattened to a fixed rate
+type ReplayScheduler struct {
+	ctx context.Context
+	// concurrency is the maximum number of simultaneously running requests. If
+	// it's reached the replay falls behind the recorded timing.
+	concurrency int
+	// speed scales the recorded gaps, e.g., 2 replays the log twice as fast as it
+	// was recorded
+	speed   float64
+	entries []api.ReplayEntry
+	rqstr   IRequestor
+}
+
+// NewReplayScheduler returns a valid ReplayScheduler instance. The replay ends early
+// if 'ctx' is done.
+func NewReplayScheduler(ctx context.Context, concurrency int, speed float64, entries []api.ReplayEntry,
+	rqstr IRequestor) (*ReplayScheduler, error) {
+
+	if concurrency < 1 {
+		return nil, fmt.Errorf("MaxConcurrentRqsts must be at least 1 to replay requests, it's %d", concurrency)
+	}
+	if speed < 0 {
+		return nil, fmt.Errorf("ReplaySpeed must not be negative, it's %f", speed)
+	}
+	if speed == 0 {
+		speed = 1
+	}
+
+	return &ReplayScheduler{
+		ctx:         ctx,
+		concurrency: concurrency,
+		speed:       speed,
+		entries:     entries,
+		rqstr:       rqstr,
+	}, nil
+}
+
+// replayOffset returns when 'entry' is to be sent relative to the start of the replay
+func (s ReplayScheduler) replayOffset(entry api.ReplayEntry) time.Duration {
+	return time.Duration(float64(entry.Timestamp.Sub(s.entries[0].Timestamp)) / s.speed)
+}
+
+// Start begins replaying the requests
+func (s ReplayScheduler) Start() error {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, s.concurrency)
+	start := time.Now()
+
+replay:
+	for i, entry := range s.entries {
+		if wait := time.Until(start.Add(s.replayOffset(entry))); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-s.ctx.Done():
+				timer.Stop()
+				log.Debug().Msgf("ReplayScheduler: cancelled after %d of %d requests", i, len(s.entries))
+				break replay
+			case <-timer.C:
+			}
+		}
+
+		select {
+		case <-s.ctx.Done():
+			break replay
+		case sem <- struct{}{}:
+		}
+
+		ep := api.Endpoint{
+			URL:         entry.URL,
+			Method:      entry.Method,
+			RqstBody:    entry.RqstBody,
+			Headers:     entry.Headers,
+			RqstPercent: 100,
+		}
+		wg.Add(1)
+		go func() {
+			s.rqstr.ProcessRqst(ep, 1, 0)
+			<-sem
+			wg.Done()
+		}()
+	}
+
+	wg.Wait()
+	close(s.rqstr.ResponseChan())
+
+	return nil
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// replayBodyLimit is the most bytes of a replayed request's response body printed
+const replayBodyLimit = 64 * 1024
+
+// sentRqst is a request as it was sent, after its templates were rendered and its
+// variant applied. It's recorded in the results log for failed requests with enough
+// detail to send the identical request again.
+type sentRqst struct {
+	// EndpointURL is the URL of the configured endpoint, before its templates were
+	// rendered, identifying the endpoint along with Method
+	EndpointURL string
+	// Variant is the name of the endpoint's variant the request was sent to, if any
+	Variant string `json:",omitempty"`
+	URL     string
+	Method  string
+	Headers map[string]string `json:",omitempty"`
+	Body    string            `json:",omitempty"`
+	// DataRow is the index, in the DataFile's data rows, of the row used to render
+	// the request's templates, if any
+	DataRow *int `json:",omitempty"`
+}
+
+// ReplayFailures sends each of the failed requests recorded in 'resultsLog', a log
+// written by ResultsLog, again, one at a time in the order they're recorded, and
+// writes each request and its response to 'w'. The requests are sent using 'client'
+// configured, e.g., with a CertFile or UnixSocket, by their endpoint in 'config'.
+// It returns the number of requests replayed.
+func ReplayFailures(ctx context.Context, client http.Client, config api.LoadTestConfig, resultsLog io.Reader, w io.Writer) (int, error) {
+	records, err := readFailedRecords(resultsLog)
+	if err != nil {
+		return 0, err
+	}
+
+	rqstr := Requestor{Ctx: ctx, Client: client}
+	for i, record := range records {
+		if ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+		rqst := record.Request
+		fmt.Fprintf(w, "\nFailure %d of %d: %s %s, originally %s", i+1, len(records), rqst.Method, record.URL,
+			originalFailure(record))
+		if rqst.DataRow != nil {
+			fmt.Fprintf(w, ", data row %d", *rqst.DataRow)
+		}
+		fmt.Fprintln(w, "")
+		replayRqst(ctx, rqstr, config, *rqst, w)
+	}
+	return len(records), nil
+}
+
+// readFailedRecords returns the records of the failed requests in 'resultsLog'
+func readFailedRecords(resultsLog io.Reader) ([]resultRecord, error) {
+	var records []resultRecord
+	scanner := bufio.NewScanner(resultsLog)
+	// The recorded requests include their bodies
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if line == 1 {
+			var header struct{ Header *resultsLogHeader }
+			if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Header == nil {
+				return nil, fmt.Errorf("the results log doesn't start with a results log header")
+			}
+			continue
+		}
+		// A results log appended to by later runs has a header line for each of them
+		var header struct{ Header *resultsLogHeader }
+		if err := json.Unmarshal(scanner.Bytes(), &header); err == nil && header.Header != nil {
+			continue
+		}
+		var record resultRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("error decoding line %d of the results log: %w", line, err)
+		}
+		if record.Request != nil {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading the results log: %w", err)
+	}
+	return records, nil
+}
+
+// originalFailure describes how 'record's request failed when it was recorded
+func originalFailure(record resultRecord) string {
+	var parts []string
+	if record.HTTPStatus != 0 {
+		parts = append(parts, fmt.Sprintf("status %d", record.HTTPStatus))
+	}
+	if record.ErrCategory != "" {
+		parts = append(parts, record.ErrCategory)
+	}
+	if record.AbandonedSlow {
+		parts = append(parts, "abandoned slow")
+	}
+	if record.Err != "" {
+		parts = append(parts, record.Err)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// replayRqst sends 'rqst' using the client of its endpoint in 'config', and writes
+// the request and its response to 'w'
+func replayRqst(ctx context.Context, rqstr Requestor, config api.LoadTestConfig, rqst sentRqst, w io.Writer) {
+	client := rqstr.Client
+	ep, ok := findEndpoint(config, rqst)
+	if ok {
+		for _, variant := range newRqstVariants(ep, rqstr.endpointClient(ep)) {
+			if variant.Name == rqst.Variant {
+				client = variant.client
+			}
+		}
+	} else {
+		fmt.Fprintf(w, "  endpoint %s %s isn't in the config, the request is sent without its settings, e.g., its CertFile\n",
+			rqst.Method, rqst.EndpointURL)
+	}
+
+	req, err := newRqst(ctx, api.Endpoint{URL: rqst.URL, Method: rqst.Method, Headers: rqst.Headers, RqstBody: rqst.Body})
+	if err != nil {
+		fmt.Fprintf(w, "  unable to create the request: %s\n", err)
+		return
+	}
+	if dump, err := httputil.DumpRequestOut(req, true); err == nil {
+		writePrefixed(w, "> ", dump)
+	}
+	// DumpRequestOut consumed the body
+	if req, err = newRqst(ctx, api.Endpoint{URL: rqst.URL, Method: rqst.Method, Headers: rqst.Headers, RqstBody: rqst.Body}); err != nil {
+		fmt.Fprintf(w, "  unable to create the request: %s\n", err)
+		return
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(w, "  request failed after %s: %s\n", time.Since(start).Round(time.Microsecond), err)
+		return
+	}
+	defer resp.Body.Close()
+	fmt.Fprintf(w, "  response received in %s\n", time.Since(start).Round(time.Microsecond))
+	if dump, err := httputil.DumpResponse(resp, false); err == nil {
+		writePrefixed(w, "< ", dump)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, replayBodyLimit+1))
+	if len(body) > replayBodyLimit {
+		body = append(body[:replayBodyLimit], []byte("\n... (truncated)")...)
+	}
+	if len(body) > 0 {
+		fmt.Fprintln(w, "< ")
+	}
+	writePrefixed(w, "< ", body)
+	if err != nil {
+		fmt.Fprintf(w, "  error reading the response body: %s\n", err)
+	}
+}
+
+// findEndpoint returns the endpoint of 'config' that 'rqst' was sent to
+func findEndpoint(config api.LoadTestConfig, rqst sentRqst) (api.Endpoint, bool) {
+	for _, ep := range config.Endpoints {
+		if ep.Method == rqst.Method && ep.URL == rqst.EndpointURL {
+			return ep, true
+		}
+	}
+	return api.Endpoint{}, false
+}
+
+// writePrefixed writes each line of 'b' to 'w' preceded by 'prefix'
+func writePrefixed(w io.Writer, prefix string, b []byte) {
+	text := strings.TrimRight(strings.Replace(string(b), "\r\n", "\n", -1), "\n")
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(w, "%s%s\n", prefix, line)
+	}
+}
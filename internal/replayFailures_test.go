@@ -0,0 +1,123 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestReplayFailures verifies only the failed requests recorded in a results log are
+// replayed, identically to how they were originally sent, and that each request and
+// its response are printed
+func TestReplayFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replayFailures")
+	if err != nil {
+		t.Fatalf("unable to create a temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, r.Method+" "+r.URL.Path+" "+r.Header.Get("X-User")+" "+string(body))
+		mu.Unlock()
+		if r.URL.Path == "/users/2" {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("username taken"))
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer testSrv.Close()
+
+	data, err := LoadDataSet(writeDataFile(t, dir, "id,username\n1,alice\n2,bob\n3,carol\n"), "")
+	if err != nil {
+		t.Fatalf("unexpected error loading the data file: %s", err)
+	}
+	ep := api.Endpoint{
+		URL:      testSrv.URL + "/users/{{ .id }}",
+		Method:   http.MethodPut,
+		RqstBody: `{"username": "{{ .username }}"}`,
+		Headers:  map[string]string{"X-User": "{{ .username }}"},
+	}
+	respC := make(chan Response, 3)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    http.Client{},
+		Data:      data,
+	}
+	rqstr.ProcessRqst(ep, 3, 0)
+	close(respC)
+
+	var logBuf bytes.Buffer
+	resultsLog, err := NewResultsLog(&logBuf, 1, 1)
+	if err != nil {
+		t.Fatalf("unexpected error creating the results log: %s", err)
+	}
+	for resp := range respC {
+		if err = resultsLog.Write(resp); err != nil {
+			t.Fatalf("unexpected error writing the results log: %s", err)
+		}
+	}
+	if err = resultsLog.Flush(); err != nil {
+		t.Fatalf("unexpected error flushing the results log: %s", err)
+	}
+
+	mu.Lock()
+	got = nil
+	mu.Unlock()
+	var out bytes.Buffer
+	config := api.LoadTestConfig{Endpoints: []api.Endpoint{ep}}
+	n, err := ReplayFailures(context.Background(), http.Client{}, config, &logBuf, &out)
+	if err != nil {
+		t.Fatalf("unexpected error replaying the failures: %s", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 failed request to be replayed, got %d", n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := `PUT /users/2 bob {"username": "bob"}`
+	if len(got) != 1 || got[0] != expected {
+		t.Errorf("expected the replayed requests to be [%s], got %q", expected, got)
+	}
+	report := out.String()
+	for _, expected := range []string{
+		"Failure 1 of 1: PUT " + testSrv.URL + "/users/2, originally status 409, data row 1",
+		"> PUT /users/2 HTTP/1.1",
+		"> X-User: bob",
+		`> {"username": "bob"}`,
+		"< HTTP/1.1 409 Conflict",
+		"< username taken",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected the replay to print %q, got:\n%s", expected, report)
+		}
+	}
+}
+
+// TestReadFailedRecordsRequiresHeader verifies a file that isn't a results log is
+// rejected
+func TestReadFailedRecordsRequiresHeader(t *testing.T) {
+	if _, err := readFailedRecords(strings.NewReader(`{"URL": "http://someservice.test/"}` + "\n")); err == nil {
+		t.Errorf("expected an error reading a log without a header")
+	}
+}
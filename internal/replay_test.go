@@ -0,0 +1,102 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// replayRequestor records when each replayed request is made
+type replayRequestor struct {
+	responseC chan Response
+	mux       sync.Mutex
+	rqstTimes map[string]time.Time
+}
+
+func (r *replayRequestor) ProcessRqst(ep api.Endpoint, numRqsts int, rqstRate float64) {
+	r.mux.Lock()
+	r.rqstTimes[ep.URL] = time.Now()
+	r.mux.Unlock()
+}
+
+func (r *replayRequestor) ResponseChan() chan Response {
+	return r.responseC
+}
+
+// TestReplayTiming verifies that replayed requests are made with the gaps recorded in
+// the replay log, scaled by the replay speed.
+func TestReplayTiming(t *testing.T) {
+	replayLog := `{"Timestamp": "2020-06-01T12:00:00Z", "Method": "GET", "URL": "http://someurl/1"}
+{"Timestamp": "2020-06-01T12:00:00.200Z", "Method": "GET", "URL": "http://someurl/2"}
+
+{"Timestamp": "2020-06-01T12:00:00.220Z", "Method": "POST", "URL": "http://someurl/3", "RqstBody": "{}"}
+{"Timestamp": "2020-06-01T12:00:00.600Z", "Method": "GET", "URL": "http://someurl/4"}
+`
+	entries, err := ReadReplayLog(strings.NewReader(replayLog))
+	if err != nil {
+		t.Fatalf("unexpected error reading the replay log: %s", err)
+	}
+
+	tests := []struct {
+		name  string
+		speed float64
+	}{
+		{name: "RecordedSpeed", speed: 1},
+		{name: "DoubleSpeed", speed: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rqstr := &replayRequestor{responseC: make(chan Response), rqstTimes: make(map[string]time.Time)}
+			s, err := NewReplayScheduler(context.Background(), 10, tc.speed, entries, rqstr)
+			if err != nil {
+				t.Fatalf("unexpected error creating the replay scheduler: %s", err)
+			}
+			start := time.Now()
+			s.Start()
+
+			if len(rqstr.rqstTimes) != len(entries) {
+				t.Fatalf("expected %d requests, got %d", len(entries), len(rqstr.rqstTimes))
+			}
+			tolerance := 30 * time.Millisecond
+			for _, entry := range entries {
+				expected := time.Duration(float64(entry.Timestamp.Sub(entries[0].Timestamp)) / tc.speed)
+				actual := rqstr.rqstTimes[entry.URL].Sub(start)
+				if actual < expected || actual > expected+tolerance {
+					t.Errorf("expected %s to be requested %s after the start, within %s, got %s", entry.URL, expected,
+						tolerance, actual)
+				}
+			}
+		})
+	}
+}
+
+// TestReplayLogErrors verifies that invalid replay logs are rejected.
+func TestReplayLogErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		replayLog string
+	}{
+		{name: "Empty", replayLog: "\n"},
+		{name: "InvalidJSON", replayLog: `{"Timestamp": "2020-06-01T12:00:00Z", "Method": "GET"`},
+		{name: "MissingURL", replayLog: `{"Timestamp": "2020-06-01T12:00:00Z", "Method": "GET"}`},
+		{name: "OutOfOrder", replayLog: `{"Timestamp": "2020-06-01T12:00:01Z", "Method": "GET", "URL": "http://someurl/1"}
+{"Timestamp": "2020-06-01T12:00:00Z", "Method": "GET", "URL": "http://someurl/2"}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ReadReplayLog(strings.NewReader(tc.replayLog)); err == nil {
+				t.Errorf("expected an error reading the replay log")
+			}
+		})
+	}
+}
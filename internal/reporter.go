@@ -6,9 +6,10 @@ package internal
 
 import (
 	"fmt"
+	"io"
 	"math"
-	"os"
 	"sort"
+	"strings"
 	"text/template"
 	"time"
 
@@ -17,9 +18,11 @@ import (
 )
 
 // OutputType specifies the output formate of the final report. There are
-// 2 values, 'text' and 'json'. 'text' will present a human readable form.
-// 'json' will present the JSON structures that capture the detailed run
-// stats.
+// 5 values, 'text', 'json', 'html', 'oneline', and 'kv'. 'text' will present a human
+// readable form. 'json' will present the JSON structures that capture the detailed run
+// stats. 'html' will present a self-contained HTML page, including charts,
+// suitable for sharing. 'oneline' and 'kv' present the run summary as a single line
+// suitable for scripting.
 type OutputType int
 
 const (
@@ -27,20 +30,59 @@ const (
 	Text OutputType = iota
 	// JSON indicates detailed reporting stats will be produced
 	JSON
+	// HTML indicates a self-contained HTML report will be produced
+	HTML
+	// OneLine indicates a single line, human readable, summary will be produced
+	OneLine
+	// KV indicates a single line summary of key=value pairs will be produced
+	KV
 )
 
 var tmpltFuncs = template.FuncMap{
-	"formatFloat":      formatFloat,
-	"formatSeconds":    formatSeconds,
-	"formatPercentile": formatPercentile,
-	"formatMethod":     formatMethod,
-	"format100Million": format100Million,
+	"formatFloat":              formatFloat,
+	"formatSeconds":            formatSeconds,
+	"formatPercentile":         formatPercentile,
+	"formatMethod":             formatMethod,
+	"format100Million":         format100Million,
+	"formatSizeClass":          formatSizeClass,
+	"formatPercent":            formatPercent,
+	"formatExemplarPercentile": formatExemplarPercentile,
+	"formatConnSetupBucket":    formatConnSetupBucket,
+	"formatBody":               formatBody,
+	"formatDeltaSeconds":       formatDeltaSeconds,
+	"formatDeltaPercent":       formatDeltaPercent,
+	"formatLatencies":          formatLatencies,
+	"formatContentTypes":       formatContentTypes,
+}
+
+// formatBody indents the lines of 'body', after the first, to line up under the
+// first, which is preceded by 'marker', e.g., '<' for a response body
+func formatBody(marker, body string) string {
+	lines := strings.Split(strings.TrimRight(body, "\r\n"), "\n")
+	return strings.Join(lines, "\n\t                     "+marker+" ")
 }
 
 func formatFloat(f float64) string {
 	return fmt.Sprintf("%4.4f", f)
 }
 
+// formatExemplarPercentile formats the percentile 'p' the way it's labeled in the
+// request latency table
+func formatExemplarPercentile(p int) string {
+	switch p {
+	case 0:
+		return "Min"
+	case 50:
+		return "Median"
+	}
+	return fmt.Sprintf("P%d", p)
+}
+
+// formatPercent formats the fraction 'f' as a percentage
+func formatPercent(f float64) string {
+	return fmt.Sprintf("%.2f%%", f*100)
+}
+
 func formatSeconds(d time.Duration) string {
 	return fmt.Sprintf("%04.4f", d.Seconds())
 }
@@ -66,16 +108,87 @@ func format100Million(i int64) string {
 	return fmt.Sprintf("%9v", i)
 }
 
+// formatBytes formats 'b' using the largest of B, KB, or MB that represents it exactly
+func formatBytes(b int64) string {
+	switch {
+	case b != 0 && b%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", b>>20)
+	case b != 0 && b%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", b>>10)
+	default:
+		return fmt.Sprintf("%dB", b)
+	}
+}
+
+func formatSizeClass(c string) string {
+	return fmt.Sprintf("%10s", c)
+}
+
 var runSummTmplt = `
 Run Summary:
 	        Total Rqsts: {{ .RqstStats.TotalRqsts }}
 	          Rqsts/sec: {{ formatFloat .RqstRatePerSec }}
-	Run Duration (secs): {{ formatSeconds .RunDurationNanos }}
+	Run Duration (secs): {{ formatSeconds .RunDurationNanos }}{{ if .WarmupDiscarded }}
+	   Warmup Discarded: {{ .WarmupDiscarded }} (not included in the totals){{ end }}{{ if .DisabledEndpoints }}
+	 Skipped (disabled): {{ range $i, $ep := .DisabledEndpoints }}{{ if $i }}, {{ end }}{{ $ep }}{{ end }}{{ end }}{{ if .AbandonedSlow }}
+	   Abandoned (slow): {{ .AbandonedSlow }}{{ end }}{{ if .ServerClosedConnections }}
+	Server Closed Conns: {{ .ServerClosedConnections }} ({{ formatPercent .ServerClosedConnectionRatio }}){{ end }}{{ if .ConcurrencyEfficiency }}
+	        Concurrency: {{ printf "%.1f" .EffectiveConcurrency }} effective ({{ formatPercent .ConcurrencyEfficiency }} of configured){{ end }}{{ if .EffectiveParallelism }}
+	        Parallelism: {{ printf "%.1f" .EffectiveParallelism }} (request secs / run secs){{ end }}{{ if .Protocols }}
+	        Connections: {{ .NewConnections }} new, {{ .ReusedConnections }} reused{{ if .WarmupConnections }}, {{ .WarmupConnections }} warmed up ({{ .WarmupReusableConnections }} reusable, {{ .WarmConnectionsUsed }} used by {{ .WarmConnectionRqsts }} requests){{ end }}
+	          Protocols:{{ range $proto, $count := .Protocols }} {{ $proto }}: {{ $count }}{{ end }}{{ end }}{{ if .RqstProtocols }}
+	     Sent Protocols:{{ range $proto, $count := .RqstProtocols }} {{ $proto }}: {{ $count }}{{ end }}{{ end }}{{ if .TLSVersionDist }}
+	       TLS Versions:{{ range $version, $count := .TLSVersionDist }} {{ $version }}: {{ $count }}{{ end }}{{ end }}{{ if .CipherSuiteDist }}
+	      Cipher Suites:{{ range $suite, $count := .CipherSuiteDist }}
+	                     {{ $suite }}: {{ $count }}{{ end }}{{ end }}{{ if .InstanceDist }}
+	          Instances:{{ range $instance, $count := .InstanceDist }}
+	                     {{ $instance }}: {{ $count }}{{ end }}{{ end }}{{ if .ErrorCategories }}
+	             Errors:{{ range $category, $count := .ErrorCategories }}
+	                     {{ $category }}: {{ $count }}{{ end }}{{ end }}{{ if .TotalQueueWaitNanos }}
+	  Queue Wait (secs): {{ formatSeconds .TotalQueueWaitNanos }}{{ end }}{{ with .ResponseChannelBackpressure }}
+	       Backpressure: {{ .BlockedSends }} blocked sends, {{ formatSeconds .TotalBlockedNanos }}s total, {{ formatSeconds .MaxBlockedNanos }}s max{{ end }}{{ with .PoolSaturation }}
+	    Conn Pool Waits: {{ .WaitedRqsts }} rqsts, {{ formatSeconds .TotalWaitNanos }}s total, {{ formatSeconds .MaxWaitNanos }}s max{{ end }}{{ with .HeaderStats }}
+	      Headers (avg): sent {{ printf "%.1f" .AvgSentCount }} ({{ printf "%.0f" .AvgSentBytes }} bytes), received {{ printf "%.1f" .AvgReceivedCount }} ({{ printf "%.0f" .AvgReceivedBytes }} bytes){{ end }}{{ with .ResponseHookStats }}
+	      Response Hook: {{ .Invocations }} invocations, {{ .Rejected }} rejected, {{ .Failures }} failed, {{ .Skipped }} skipped, {{ formatSeconds .AvgNanos }}s avg, {{ formatSeconds .MaxNanos }}s max{{ end }}{{ if .TruncatedResponseBytes }}
+	    Truncated Bytes: {{ .TruncatedResponseBytes }}{{ end }}{{ if .BodyLimitedResponses }}
+	 Body Limited Rqsts: {{ .BodyLimitedResponses }}{{ end }}{{ if .CrossHostRedirects }}
+	  Cross-Host Redirs: {{ .CrossHostRedirects }}{{ end }}{{ if .TopErrorMessages }}
+	         Top Errors:{{ range .TopErrorMessages }}
+	                     {{ .Count }}: {{ .Message }}{{ end }}{{ end }}{{ if .DNSChanges }}
+	        DNS Changes:{{ range .DNSChanges }}
+	                     {{ formatSeconds .OffsetNanos }}s {{ .Host }}: {{ .OldIPs }} -> {{ .NewIPs }}{{ end }}{{ end }}{{ if .WeightedEndpointP95 }}
+	    P95 by Endpoint:{{ range .WeightedEndpointP95 }}
+	                     {{ .URL }}: {{ formatPercent .RqstShare }} of rqsts, P95 {{ formatSeconds .P95Nanos }}s, {{ formatPercent .TailShare }} of tail{{ end }}{{ end }}{{ if .Warnings }}
+	           Warnings:{{ range .Warnings }}
+	                     {{ . }}{{ end }}{{ end }}{{ with .FirstFailure }}
+	      First Failure: {{ .Method }} {{ .URL }}{{ if .HTTPStatus }}, status {{ .HTTPStatus }}{{ end }}{{ if .Error }}, {{ .Error }}{{ end }}{{ range $name, $value := .RequestHeaders }}
+	                     > {{ $name }}: {{ $value }}{{ end }}{{ if .RequestBody }}
+	                     > {{ formatBody ">" .RequestBody }}{{ end }}{{ range $name, $values := .ResponseHeaders }}{{ range $values }}
+	                     < {{ $name }}: {{ . }}{{ end }}{{ end }}{{ if .ResponseBody }}
+	                     < {{ formatBody "<" .ResponseBody }}{{ end }}{{ end }}{{ with .BudgetExceeded }}
+	    Budget Exceeded: {{ .Limit }} of {{ .Max }} reached after {{ formatSeconds .AfterNanos }} secs, overshot by {{ .Overshoot }} ({{ .TotalRequests }} rqsts, {{ .TotalBytes }} bytes){{ end }}{{ if .StatusDistFailed }}
+	    FAILED Statuses:{{ range .StatusDistViolations }}
+	                     {{ . }}{{ end }}{{ end }}{{ if .MaxP99Failed }}
+	      FAILED MaxP99: {{ .MaxP99Violation }}{{ end }}{{ if .UniqueIntFailed }}
+	   FAILED uniqueInt: {{ .UniqueIntFailure }}{{ end }}{{ if .SLOResults }}
+	               SLOs:{{ range .SLOResults }}{{ $percentile := .Percentile }}
+	                     {{ if .Passed }}PASSED{{ else }}FAILED{{ end }} {{ .Name }}: P{{ .Percentile }} {{ formatSeconds .LatencyNanos }}s{{ if .WindowNanos }}, {{ .ViolatedWindows }} of {{ .CheckedWindows }} windows violated{{ with .WorstWindow }}, worst P{{ $percentile }} {{ formatSeconds .LatencyNanos }}s at {{ formatSeconds .StartNanos }}s{{ end }}{{ end }}{{ if .Violation }}
+	                       {{ .Violation }}{{ end }}{{ end }}{{ end }}{{ with .ClientCache }}
+	       Client Cache: {{ formatPercent .HitRatio }} hits ({{ .Hits }} hits, {{ .Revalidations }} revalidations, {{ .NotModified }} not modified, {{ .FullFetches }} full fetches){{ end }}{{ with .Apdex }}
+	              Apdex: {{ printf "%.2f" .Score }} (T {{ formatSeconds .TargetNanos }}s: {{ .Satisfied }} satisfied, {{ .Tolerating }} tolerating, {{ .Frustrated }} frustrated){{ end }}
 `
 
 var rqstLatencyTmplt = `
 Request Latency (secs): Min      Median   P75      P90      P95      P99
-	                    {{ formatPercentile 0 .TimingResultsNanos }}   {{  formatPercentile 50 .TimingResultsNanos }}   {{  formatPercentile 75 .TimingResultsNanos }}   {{  formatPercentile 90 .TimingResultsNanos }}   {{  formatPercentile 95 .TimingResultsNanos }}   {{  formatPercentile 99 .TimingResultsNanos }}
+	                    {{ formatPercentile 0 .TimingResultsNanos }}   {{  formatPercentile 50 .TimingResultsNanos }}   {{  formatPercentile 75 .TimingResultsNanos }}   {{  formatPercentile 90 .TimingResultsNanos }}   {{  formatPercentile 95 .TimingResultsNanos }}   {{  formatPercentile 99 .TimingResultsNanos }}{{ if .Exemplars }}
+Exemplars:{{ range .Exemplars }}
+	{{ formatExemplarPercentile .Percentile }}: {{ formatSeconds .DurationNanos }}s {{ .TraceID }}{{ end }}{{ end }}
+`
+
+var outlierExcludedTmplt = `
+Excluding Outliers:     Min      Median   P75      P90      P95      P99
+	                    {{ formatSeconds (index .LatencyNanos "Min") }}   {{ formatSeconds (index .LatencyNanos "Median") }}   {{ formatSeconds (index .LatencyNanos "P75") }}   {{ formatSeconds (index .LatencyNanos "P90") }}   {{ formatSeconds (index .LatencyNanos "P95") }}   {{ formatSeconds (index .LatencyNanos "P99") }}
+	 Outliers (> {{ formatSeconds .CutoffNanos }}): {{ .ExcludedRqsts }} requests, {{ formatSeconds .ExcludedDurationNanos }} secs total
 `
 
 var netDetailsTmplt = `
@@ -84,62 +197,108 @@ Network Details (secs):
 	    DNS Lookup: {{ formatPercentile 0 .DNSLookupNanos }}   {{ formatPercentile 50 .DNSLookupNanos }}   {{ formatPercentile 75 .DNSLookupNanos }}   {{ formatPercentile 90 .DNSLookupNanos }}   {{ formatPercentile 95 .DNSLookupNanos }}   {{ formatPercentile 99 .DNSLookupNanos }}       
 	TCP Conn Setup: {{ formatPercentile 0 .TCPConnSetupNanos }}   {{ formatPercentile 50 .TCPConnSetupNanos }}   {{ formatPercentile 75 .TCPConnSetupNanos }}   {{ formatPercentile 90 .TCPConnSetupNanos }}   {{ formatPercentile 95 .TCPConnSetupNanos }}   {{ formatPercentile 99 .TCPConnSetupNanos }}                  
 	 TLS Handshake: {{ formatPercentile 0 .TLSHandshakeNanos }}   {{ formatPercentile 50 .TLSHandshakeNanos }}   {{ formatPercentile 75 .TLSHandshakeNanos }}   {{ formatPercentile 90 .TLSHandshakeNanos }}   {{ formatPercentile 95 .TLSHandshakeNanos }}   {{ formatPercentile 99 .TLSHandshakeNanos }}        
-	Rqst Roundtrip: {{ formatPercentile 0 .RqstRoundTripNanos }}   {{ formatPercentile 50 .RqstRoundTripNanos }}   {{ formatPercentile 75 .RqstRoundTripNanos }}   {{ formatPercentile 90 .RqstRoundTripNanos }}   {{ formatPercentile 95 .RqstRoundTripNanos }}   {{ formatPercentile 99 .RqstRoundTripNanos }}        
+	Rqst Roundtrip: {{ formatPercentile 0 .RqstRoundTripNanos }}   {{ formatPercentile 50 .RqstRoundTripNanos }}   {{ formatPercentile 75 .RqstRoundTripNanos }}   {{ formatPercentile 90 .RqstRoundTripNanos }}   {{ formatPercentile 95 .RqstRoundTripNanos }}   {{ formatPercentile 99 .RqstRoundTripNanos }}        {{ if .ConnSetupDist }}
+New Connection Setup (TCP+TLS):{{ range .ConnSetupDist }}
+	{{ printf "%-9s" (formatConnSetupBucket .) }} {{ .Count }}{{ end }}{{ end }}
 `
 
 // Pass in a EndpointDetails keyed by URL and range over EndpointDetail
 // HTTPMethodRqstStats (map[string]*RqstStats keyed by Method)
 var endpointDetailsTmplt = `
 Endpoint Details(secs): {{ range $url, $epDetails := . }}    
-  {{ $url }}:
+  {{ $url }}:{{ if .NegativeTest }} (negative test, expects {{ .NegativeTest }}){{ end }}{{ if .TemplatedURLs }} ({{ .TemplatedURLs }} distinct URLs, e.g., {{ index .TemplatedURLSamples 0 }}){{ end }}{{ if .Description }}
+	  {{ .Description }}{{ end }}
 	            Requests   Min        Median     P75        P90        P95        P99 {{ range $method, $epDetail := .HTTPMethodRqstStats }}
-	  {{ formatMethod $method }}:  {{ format100Million .TotalRqsts }}   {{ formatPercentile 0 .TimingResultsNanos }}     {{  formatPercentile 50 .TimingResultsNanos }}     {{  formatPercentile 75 .TimingResultsNanos }}     {{  formatPercentile 90 .TimingResultsNanos }}     {{  formatPercentile 95 .TimingResultsNanos }}     {{  formatPercentile 99 .TimingResultsNanos }} {{ end }}
+	  {{ formatMethod $method }}:  {{ format100Million .TotalRqsts }}   {{ formatPercentile 0 .TimingResultsNanos }}     {{  formatPercentile 50 .TimingResultsNanos }}     {{  formatPercentile 75 .TimingResultsNanos }}     {{  formatPercentile 90 .TimingResultsNanos }}     {{  formatPercentile 95 .TimingResultsNanos }}     {{  formatPercentile 99 .TimingResultsNanos }} {{ end }}{{ with .ServerProcessingStats }}
+	  Server processing, request written to first response byte:
+	{{ formatSizeClass "all" }}:{{ format100Million .TotalRqsts }}   {{ formatPercentile 0 .TimingResultsNanos }}     {{  formatPercentile 50 .TimingResultsNanos }}     {{  formatPercentile 75 .TimingResultsNanos }}     {{  formatPercentile 90 .TimingResultsNanos }}     {{  formatPercentile 95 .TimingResultsNanos }}     {{  formatPercentile 99 .TimingResultsNanos }}  avg {{ formatSeconds .AvgRqstDurationNanos }}{{ end }}{{ if .LatencyBySizeClass }}
+	  Latency by request body size: {{ range .LatencyBySizeClass }}
+	{{ formatSizeClass .SizeClass }}:{{ format100Million .TotalRqsts }}   {{ formatPercentile 0 .TimingResultsNanos }}     {{  formatPercentile 50 .TimingResultsNanos }}     {{  formatPercentile 75 .TimingResultsNanos }}     {{  formatPercentile 90 .TimingResultsNanos }}     {{  formatPercentile 95 .TimingResultsNanos }}     {{  formatPercentile 99 .TimingResultsNanos }} {{ end }}{{ end }}{{ if .ByHeaderValue }}
+	  Latency by {{ .GroupByHeader }} (errors, 5xx): {{ range $value, $stats := .ByHeaderValue }}
+	{{ formatSizeClass $value }}:{{ format100Million .TotalRqsts }}   {{ formatPercentile 0 .TimingResultsNanos }}     {{  formatPercentile 50 .TimingResultsNanos }}     {{  formatPercentile 75 .TimingResultsNanos }}     {{  formatPercentile 90 .TimingResultsNanos }}     {{  formatPercentile 95 .TimingResultsNanos }}     {{  formatPercentile 99 .TimingResultsNanos }}  ({{ .Errors }}, {{ .ServerErrors }}){{ end }}{{ end }}{{ range $name, $byValue := .ByRotatingHeader }}
+	  Latency by {{ $name }} sent (errors, 5xx): {{ range $value, $stats := $byValue }}
+	{{ formatSizeClass $value }}:{{ format100Million .TotalRqsts }}   {{ formatPercentile 0 .TimingResultsNanos }}     {{  formatPercentile 50 .TimingResultsNanos }}     {{  formatPercentile 75 .TimingResultsNanos }}     {{  formatPercentile 90 .TimingResultsNanos }}     {{  formatPercentile 95 .TimingResultsNanos }}     {{  formatPercentile 99 .TimingResultsNanos }}  ({{ .Errors }}, {{ .ServerErrors }}){{ end }}{{ end }}{{ if .CacheHits }}
+	  Cache Hit Ratio: {{ formatPercent .CacheHitRatio }}{{ end }}{{ if .NotModifiedCount }}
+	  Not Modified: {{ .NotModifiedCount }}{{ end }}{{ with .ClientCache }}
+	  Client Cache: {{ formatPercent .HitRatio }} hits ({{ .Hits }} hits, {{ .Revalidations }} revalidations, {{ .NotModified }} not modified, {{ .FullFetches }} full fetches){{ end }}{{ if .EffectiveParallelism }}
+	  Parallelism: {{ printf "%.1f" .EffectiveParallelism }}{{ end }}{{ if or .ContentTypeMismatches (gt (len .ContentTypes) 1) }}
+	  Content Types: {{ formatContentTypes .ContentTypes }}{{ if .ContentTypeMismatches }} ({{ .ContentTypeMismatches }} unexpected){{ end }}{{ end }}{{ if .TotalBytes }}
+	  Bytes Received: min {{ .MinBytes }}, max {{ .MaxBytes }}, avg {{ .AvgBytes }}{{ end }}{{ if .ServerTiming }}
+	  Server Timing (avg secs, share of latency):{{ range $name, $phase := .ServerTiming }} {{ $name }} {{ formatSeconds .AvgNanos }} ({{ printf "%.1f" .RqstPercent }}%){{ end }}{{ end }}{{ if .RequestIDsNotEchoed }}
+	  Request IDs Not Echoed: {{ .RequestIDsNotEchoed }}{{ end }}{{ with .ByteTiming }}
+	  Time to First/Last Byte (avg secs): {{ formatSeconds .AvgTTFBNanos }} / {{ formatSeconds .AvgTTLBNanos }}{{ end }}{{ with .Upload }}
+	  Upload (MB/s): min {{ printf "%.2f" .MinMBPerSec }}, avg {{ printf "%.2f" .AvgMBPerSec }}, max {{ printf "%.2f" .MaxMBPerSec }}, request write avg {{ formatSeconds .AvgWriteNanos }} secs, max {{ formatSeconds .MaxWriteNanos }} secs{{ end }}{{ with .Apdex }}
+	  Apdex: {{ printf "%.2f" .Score }} ({{ .Satisfied }} satisfied, {{ .Tolerating }} tolerating, {{ .Frustrated }} frustrated){{ end }}{{ if .StatusFlaps }}
+	  Status Flaps: {{ .StatusFlaps }}{{ end }}{{ if .CircuitOpens }}
+	  Circuit Open: {{ .CircuitOpens }} times, {{ formatSeconds .CircuitOpenNanos }} secs{{ end }}{{ with .Crawl }}
+	  Crawl: {{ .URLs }} URLs, {{ .MaxDepth }} links deep, {{ .NotFollowed }} links over MaxURLs not followed{{ if .OtherHosts }}, {{ .OtherHosts }} links to other hosts not followed{{ end }}{{ end }}{{ with .OptimisticUpdates }}
+	  Optimistic Updates: {{ .Updates }}, {{ .Conflicts }} conflicts ({{ formatPercent .ConflictRate }} of attempts), {{ printf "%.2f" .AvgRetries }} retries per update, {{ .Exhausted }} exhausted retries{{ end }}{{ if .UnexpectedOutcomes }}
+	  Unexpected Outcomes:{{ range $outcome, $count := .UnexpectedOutcomes }} {{ $outcome }}: {{ $count }}{{ end }}{{ end }}{{ with .SSE }}
+	  Event Streams: {{ .Streams }} ({{ .FailedStreams }} failed), {{ .Events }} events, {{ .Disconnects }} disconnects, {{ .Reconnects }} reconnects{{ if .TimeToFirstEventNanos }}
+	    Time to First Event: {{ formatLatencies .TimeToFirstEventNanos }}{{ end }}{{ if .InterEventNanos }}
+	    Inter-Event:         {{ formatLatencies .InterEventNanos }}{{ end }}{{ end }}{{ if .KeepAliveProbeResults }}
+	  Keep-alive probe:
+	    Idle Gap   Conns   Reused   Torn Down   Failed   Reuse Rate {{ range .KeepAliveProbeResults }}
+	    {{ printf "%-9s" (.IdleGapNanos.String) }}  {{ printf "%-6d" .Connections }}  {{ printf "%-7d" .Reused }}  {{ printf "%-10d" .TornDown }}  {{ printf "%-7d" .Failed }}  {{ formatPercent .ReuseRate }}{{ range $category, $count := .ErrorCategories }}
+	      {{ $category }}: {{ $count }}{{ end }}{{ end }}{{ end }}
 	{{ end }}
 `
 
-func printRunSummary(rs api.RunSummary) {
+func printRunSummary(w io.Writer, rs api.RunSummary) {
 	tmplt, err := template.New("runSummary").Funcs(tmpltFuncs).Parse(runSummTmplt)
 	if err != nil {
 		log.Error().Err(err).Msg("error parsing runResults template")
 	}
 
-	err = tmplt.Execute(os.Stdout, rs)
+	err = tmplt.Execute(w, rs)
 	if err != nil {
 		log.Error().Err(err).Msg("error executing runResults template")
 	}
 }
 
-func printRqstLatency(rs api.RqstStats) {
+func printRqstLatency(w io.Writer, rs api.RqstStats) {
 	tmplt, err := template.New("rqstLatency").Funcs(tmpltFuncs).Parse(rqstLatencyTmplt)
 	if err != nil {
 		log.Error().Err(err).Msg("error parsing rqstLatency template")
 	}
 
-	err = tmplt.Execute(os.Stdout, rs)
+	err = tmplt.Execute(w, rs)
 	if err != nil {
 		log.Error().Err(err).Msg("error executing rqstLatency template")
 	}
 }
 
-func printNetworkDetails(rs api.RunSummary) {
+func printOutlierExcluded(w io.Writer, outlierStats api.OutlierStats) {
+	tmplt, err := template.New("outlierExcluded").Funcs(tmpltFuncs).Parse(outlierExcludedTmplt)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing outlierExcluded template")
+	}
+
+	err = tmplt.Execute(w, outlierStats)
+	if err != nil {
+		log.Error().Err(err).Msg("error executing outlierExcluded template")
+	}
+}
+
+func printNetworkDetails(w io.Writer, rs api.RunSummary) {
 	tmplt, err := template.New("networkDetails").Funcs(tmpltFuncs).Parse(netDetailsTmplt)
 	if err != nil {
 		log.Error().Err(err).Msg("error parsing networkDetails template")
 	}
 
-	err = tmplt.Execute(os.Stdout, rs)
+	err = tmplt.Execute(w, rs)
 	if err != nil {
 		log.Error().Err(err).Msg("error executing networkDetails template")
 	}
 }
 
-func printEndpointDetails(epd map[string]*api.EndpointDetail) {
+func printEndpointDetails(w io.Writer, epd map[string]*api.EndpointDetail) {
 	tmplt, err := template.New("endpointDetail").Funcs(tmpltFuncs).Parse(endpointDetailsTmplt)
 	if err != nil {
 		log.Error().Err(err).Msg("error parsing endpoint detail template")
 	}
 
-	err = tmplt.Execute(os.Stdout, epd)
+	err = tmplt.Execute(w, epd)
 	if err != nil {
 		log.Error().Err(err).Msg("error executing endpoint detail template")
 	}
@@ -158,7 +317,7 @@ func calcPercentiles(percentile int, results []time.Duration) time.Duration {
 		return calcPMedian(results)
 	}
 
-	sort.Slice(results, func(i, j int) bool { return results[i] < results[j] })
+	results = sortedDurations(results)
 
 	// applying math.Ceil to the results of math.Ceil is required to round up
 	// to the next results cell when len(results) is a small number, e.g., like
@@ -167,12 +326,24 @@ func calcPercentiles(percentile int, results []time.Duration) time.Duration {
 	return results[int(p)]
 }
 
+// sortedDurations returns 'results' sorted. If they aren't already sorted a sorted
+// copy is returned, so the caller's latencies stay in the order the requests
+// completed.
+func sortedDurations(results []time.Duration) []time.Duration {
+	less := func(i, j int) bool { return results[i] < results[j] }
+	if sort.SliceIsSorted(results, less) {
+		return results
+	}
+	results = append([]time.Duration(nil), results...)
+	sort.Slice(results, func(i, j int) bool { return results[i] < results[j] })
+	return results
+}
+
 func calcPMin(results []time.Duration) time.Duration {
 	if len(results) == 0 {
 		return 0
 	}
-	sort.Slice(results, func(i, j int) bool { return results[i] < results[j] })
-	return results[0]
+	return sortedDurations(results)[0]
 }
 
 func calcPMedian(results []time.Duration) time.Duration {
@@ -180,7 +351,7 @@ func calcPMedian(results []time.Duration) time.Duration {
 		return 0
 	}
 
-	sort.Slice(results, func(i, j int) bool { return results[i] < results[j] })
+	results = sortedDurations(results)
 
 	isEven := len(results)%2 == 0
 	mNumber := len(results) / 2
@@ -0,0 +1,48 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// newRequestID returns a random request ID, 32 hex digits, or an empty string if
+// one can't be generated
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// withRequestID returns a copy of 'headers' with the 'name' header set to a new
+// request ID, and the ID. 'headers' is returned unchanged, with an empty ID, if
+// 'name' isn't set.
+func withRequestID(headers map[string]string, name string) (map[string]string, string) {
+	if name == "" {
+		return headers, ""
+	}
+	id := newRequestID()
+	withID := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		if http.CanonicalHeaderKey(k) != http.CanonicalHeaderKey(name) {
+			withID[k] = v
+		}
+	}
+	withID[name] = id
+	return withID, id
+}
+
+// traceIDHeader returns the name of the header with each request's trace ID, the
+// TraceIDHeader or, if it isn't set, the RequestIDHeader
+func (r Requestor) traceIDHeader() string {
+	if r.TraceIDHeader != "" {
+		return r.TraceIDHeader
+	}
+	return r.RequestIDHeader
+}
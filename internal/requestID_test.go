@@ -0,0 +1,78 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestRequestID verifies each request has a unique request ID, that it's used as the
+// request's trace ID, and that responses that don't echo it back are counted
+func TestRequestID(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		mu.Lock()
+		received = append(received, id)
+		echo := len(received)%2 == 0
+		mu.Unlock()
+		if echo {
+			w.Header().Set("X-Request-ID", id)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testSrv.Close()
+
+	numRqsts := 10
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}, RequestIDHeader: "X-Request-ID"}
+	// The endpoint's own request ID header is replaced
+	ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet, Headers: map[string]string{"x-request-id": "fixed"}}
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+
+	wellFormed := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	ids := make(map[string]bool)
+	for i, id := range received {
+		if !wellFormed.MatchString(id) {
+			t.Errorf("expected a well-formed request ID, got %q", id)
+		}
+		ids[id] = true
+		if responses[i].TraceID != id {
+			t.Errorf("expected the trace ID to be the request ID %q, got %q", id, responses[i].TraceID)
+		}
+	}
+	if len(ids) != numRqsts {
+		t.Errorf("expected %d unique request IDs, got %d", numRqsts, len(ids))
+	}
+	if ep.Headers["x-request-id"] != "fixed" {
+		t.Errorf("expected the endpoint's headers to be unchanged, got %v", ep.Headers)
+	}
+
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	if notEchoed := runResults.EndpointDetails[ep.URL].RequestIDsNotEchoed; notEchoed != int64(numRqsts/2) {
+		t.Errorf("expected %d request IDs not echoed, got %d", numRqsts/2, notEchoed)
+	}
+}
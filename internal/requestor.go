@@ -7,9 +7,15 @@ package internal
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
@@ -28,6 +34,129 @@ type Requestor struct {
 	ResponseC chan Response
 	// Client is the target of the test run
 	Client http.Client
+	// Cancel, if set, is used to end the entire test run early. For example
+	// when a 'uniqueInt' range configured to fail the run is exhausted.
+	Cancel context.CancelFunc
+	// UniqueInts are the counters backing the 'uniqueInt' template function
+	UniqueInts *UniqueIntCounters
+	// Data, if set, provides the rows whose columns populate the endpoint templates.
+	// Each templated request uses the next row.
+	Data *DataSet
+	// SoftDeadline, if greater than 0, is how long a request may take before it's
+	// abandoned by the client and reported as AbandonedSlow
+	SoftDeadline time.Duration
+	// Retry is the default policy for retrying failed requests. It can be overridden
+	// by Endpoint.Retry.
+	Retry api.RetryPolicy
+	// FailOnMalformedURL ends the run, using Cancel, when a request's URL is malformed
+	// rather than skipping the request
+	FailOnMalformedURL bool
+	// ReadIdleTimeout, if greater than 0, is the read idle timeout used by the
+	// Client's transport. It's needed to apply the same timeout to connections
+	// to Endpoint.UnixSocket. See WithReadIdleTimeout.
+	ReadIdleTimeout time.Duration
+	// EarlyFail, if set, stops requests to endpoints whose first responses are all
+	// errors. It's shared by all requestors.
+	EarlyFail *EarlyFailTracker
+	// LockGroups, if set, limits the in-flight requests to endpoints sharing a lock
+	// group. It's shared by all requestors.
+	LockGroups *LockGroups
+	// RateLimits, if set, limits the rate of requests to endpoints with a RateLimit.
+	// It's shared by all requestors.
+	RateLimits *RateLimits
+	// AdaptiveConcurrency, if set, reduces the requests in flight when they exhaust
+	// heyyall's file descriptors or ports. It's shared by all requestors.
+	AdaptiveConcurrency *AdaptiveConcurrency
+	// RecordResponseHeaders are the names of the response headers copied into
+	// Response.RecordedHeaders
+	RecordResponseHeaders []string
+	// MaxResponseBodyBytes, if greater than 0, is the most of each response body
+	// that's read, the rest is discarded without being read
+	MaxResponseBodyBytes int64
+	// TraceIDHeader, if set, is the name of the header with each request's trace ID,
+	// see api.LoadTestConfig.TraceIDHeader
+	TraceIDHeader string
+	// RequestIDHeader, if set, is the name of the header set to a unique ID on each
+	// request, see api.LoadTestConfig.RequestIDHeader
+	RequestIDHeader string
+	// ClockSkew, if greater than 0, is the most the clock used by the time template
+	// functions of each call to ProcessRqst is offset from the actual time
+	ClockSkew time.Duration
+	// Tracer, if set, starts a client span for each request and injects its W3C
+	// traceparent header. It isn't applied to pipelined endpoints.
+	Tracer Tracer
+	// ForbidCrossHostRedirects fails requests redirected to a different host than
+	// the one requested rather than following the redirect
+	ForbidCrossHostRedirects bool
+	// WorkerTime, if set, accounts for where the requestor spent its time
+	WorkerTime *WorkerTimeAccounting
+	// FirstFailure, if set, ends the run, using Cancel, when any request fails and
+	// records the failed request and its response. No more requests are sent once
+	// it's recorded.
+	FirstFailure *FirstFailureTracker
+	// Canary, if set, splits each endpoint's requests between the two builds of a
+	// canary run
+	Canary *api.CanaryBaseURLs
+	// Backpressure, if set, records how long the requestor was blocked sending
+	// responses to a full ResponseC
+	Backpressure *BackpressureTracker
+	// HeaderStats measures the number and size of the headers of each request and
+	// its response, see Response.HeaderSizes
+	HeaderStats bool
+	// Crawlers, if set, discovers the URLs requested by endpoints with a Crawl. It's
+	// shared by all requestors.
+	Crawlers *Crawlers
+	// HeaderRotators, if set, chooses the values of the endpoints' RotatingHeaders.
+	// It's shared by all requestors.
+	HeaderRotators *HeaderRotators
+	// WarmPool, if set, are the connections opened before the run started. The
+	// requests sent on them are recorded.
+	WarmPool *WarmPool
+	// ResponseHook, if set, validates a sample of the successful responses. It's
+	// shared by all requestors.
+	ResponseHook *ResponseHookRunner
+	// CircuitBreakers, if set, pauses requests to endpoints with a CircuitBreaker
+	// while their recent responses are mostly errors. It's shared by all requestors.
+	CircuitBreakers *CircuitBreakers
+	// Budget, if set, counts the requestor's requests and bytes against the run's
+	// budget. No more requests are sent once it's reached. It's shared by all
+	// requestors.
+	Budget *BudgetTracker
+	// FailureInjector, if set, fails a fraction of the requests before they're sent.
+	// It's shared by all requestors.
+	FailureInjector *FailureInjector
+	// ClientCaches, if set, has each call to ProcessRqst for a GET endpoint cache
+	// its responses the way a browser does, see api.LoadTestConfig.SimulateClientCache.
+	// Requests served from the cache aren't sent or reported as Responses, they're
+	// only counted. It's shared by all requestors. Pipelined, SSE, optimistic update,
+	// and keep-alive probe requests aren't cached.
+	ClientCaches *ClientCaches
+	// ConditionalRequests has each call to ProcessRqst for a GET or HEAD endpoint
+	// send the ETag of the last response from a URL as the If-None-Match header of
+	// its next request to it, see api.LoadTestConfig.ConditionalRequests. It isn't
+	// needed with ClientCaches, which already revalidates its cached responses.
+	ConditionalRequests bool
+	// HAR, if set, captures a sample of the requests and their responses in HAR
+	// format. It's shared by all requestors. Pipelined, SSE, optimistic update, and
+	// keep-alive probe requests aren't captured.
+	HAR *HARRecorder
+}
+
+// recordHeaders returns the RecordResponseHeaders present in 'header', or nil if
+// there are none
+func (r Requestor) recordHeaders(header http.Header) http.Header {
+	var recorded http.Header
+	for _, name := range r.RecordResponseHeaders {
+		values := header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		if recorded == nil {
+			recorded = make(http.Header, len(r.RecordResponseHeaders))
+		}
+		recorded[http.CanonicalHeaderKey(name)] = values
+	}
+	return recorded
 }
 
 // ResponseChan returns a chan Response
@@ -37,99 +166,395 @@ func (r Requestor) ResponseChan() chan Response {
 
 // ProcessRqst runs the requests configured by 'ep' at the requested rate for either
 // 'numRqsts' times or the configured run duration (set in Requestor.Ctx)
-func (r Requestor) ProcessRqst(ep api.Endpoint, numRqsts int, rqstRate int) {
+func (r Requestor) ProcessRqst(ep api.Endpoint, numRqsts int, rqstRate float64) {
 	if len(ep.URL) == 0 || len(ep.Method) == 0 {
 		log.Warn().Msgf("Requestor - request contains an invalid endpoint %+v, URL or Method is empty", ep)
 		return
 	}
 
-	req, err := http.NewRequestWithContext(r.Ctx, ep.Method, ep.URL, bytes.NewBuffer([]byte(ep.RqstBody)))
-	if err != nil {
-		log.Warn().Err(err).Msgf("Requestor unable to create http request")
+	if ep.PipelineDepth > 1 {
+		r.processPipelinedRqsts(ep, numRqsts, rqstRate)
 		return
 	}
-	if ep.Headers != nil {
-		for headerName, headerValue := range ep.Headers {
-			req.Header.Add(headerName, headerValue)
-		}
+	if ep.KeepAliveProbe != nil {
+		r.processKeepAliveProbe(ep)
+		return
+	}
+	if ep.Mode == api.EndpointModeSSE {
+		r.processSSE(ep, numRqsts, rqstRate)
+		return
+	}
+	if ep.OptimisticUpdate != nil {
+		r.processOptimisticUpdates(ep, numRqsts, rqstRate)
+		return
 	}
 
-	var dnsStart, dnsDone, connStart, connDone, gotResp, tlsStart, tlsDone time.Time
+	wt := &workerTime{start: time.Now()}
+	defer r.WorkerTime.add(wt)
 
-	trace := &httptrace.ClientTrace{
-		DNSStart:             func(_ httptrace.DNSStartInfo) { dnsStart = time.Now() },
-		DNSDone:              func(_ httptrace.DNSDoneInfo) { dnsDone = time.Now() },
-		GetConn:              func(_ string) { connStart = time.Now() },
-		GotConn:              func(_ httptrace.GotConnInfo) { connDone = time.Now() },
-		GotFirstResponseByte: func() { gotResp = time.Now() },
-		TLSHandshakeStart:    func() { tlsStart = time.Now() },
-		TLSHandshakeDone:     func(_ tls.ConnectionState, _ error) { tlsDone = time.Now() },
+	// Each call models a single client, and so a single clock
+	tmplt, err := newRqstTemplate(ep, rqstTmpltFuncs(r.UniqueInts, clockOffset(r.ClockSkew)))
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor unable to parse endpoint %s templates", ep.URL)
+		return
+	}
+	var successExpr *successExpr
+	if ep.SuccessExpr != "" {
+		if successExpr, err = compileSuccessExpr(ep.SuccessExpr); err != nil {
+			log.Warn().Err(err).Msgf("Requestor unable to compile endpoint %s SuccessExpr", ep.URL)
+			return
+		}
 	}
 
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	rt := &rqstTrace{warmPool: r.WarmPool, headerStats: r.HeaderStats}
+	traceCtx := httptrace.WithClientTrace(r.Ctx, rt.clientTrace())
 
 	if numRqsts == 0 {
 		log.Debug().Msgf("ProcessRqst: EP: %s, numRqsts was 0, setting to %d", ep.URL, api.MaxRqsts)
 		numRqsts = api.MaxRqsts
 	}
 
-	client := r.Client
-	if ep.CertFile != "" {
-		if ep.KeyFile == "" {
-			log.Fatal().Msgf("Endpoint: %s, Endpoint.CertFile specified: %s, Endpoint.KeyFile is not", ep.URL, ep.CertFile)
+	variants := newRqstVariants(ep, r.endpointClient(ep))
+	// Each call models a single client, and so a single cache
+	cache := r.ClientCaches.newCache(ep.Method)
+	var etags *etagCache
+	if cache == nil {
+		etags = newETagCache(r.ConditionalRequests, ep.Method)
+	}
+
+	retryPolicy := r.Retry
+	if ep.Retry != nil {
+		retryPolicy = *ep.Retry
+	}
+
+	for i := 0; i < numRqsts; i++ {
+		if r.EarlyFail != nil && r.EarlyFail.Failed(ep) {
+			log.Debug().Msgf("Requestor: endpoint %s %s failed early, exiting", ep.Method, ep.URL)
+			return
 		}
-		log.Debug().Msgf("Endpoint %s is overriding SSL certificate using certificate file %s", ep.URL, ep.CertFile)
-		cert, err := tls.LoadX509KeyPair(ep.CertFile, ep.KeyFile)
-		if err != nil {
-			log.Fatal().Err(err).Msg("Error creating x509 keypair")
+		if r.Budget.spent() {
+			log.Debug().Msg("Requestor: the run's Budget was reached, exiting")
+			return
 		}
-		t1, ok := r.Client.Transport.(*http.Transport)
-		if !ok {
-			log.Fatal().Msg("Requestor.ProcessRqst(): Could not cast Client.Transport to *http.Transport")
+		if r.FirstFailure.stopped() {
+			log.Debug().Msg("Requestor: the run was stopped by the first failure, exiting")
+			return
 		}
-		t2 := &http.Transport{
-			MaxIdleConnsPerHost: t1.MaxConnsPerHost,
-			DisableCompression:  t1.DisableCompression,
-			DisableKeepAlives:   t1.DisableKeepAlives,
-			TLSClientConfig: &tls.Config{
-				Certificates: []tls.Certificate{cert},
-			},
+		if !r.CircuitBreakers.wait(r.Ctx, ep) {
+			log.Debug().Msg("Requestor cancelled or the run duration expired while the endpoint's circuit was open, exiting")
+			return
 		}
-		client.Transport = t2
-	}
 
-	for i := 0; i < numRqsts; i++ {
-		start := time.Now()
-		resp, err := client.Do(req)
+		rqstEP := ep
+		var dataRow *int
+		if tmplt != nil {
+			var data interface{}
+			if r.Data != nil {
+				row, values := r.Data.nextRow()
+				data, dataRow = values, &row
+			}
+			rqstEP, err = tmplt.render(ep, data)
+			if err != nil {
+				r.handleRenderErr(ep, err)
+				return
+			}
+		}
+		var crawled crawlURL
+		if ep.Crawl != nil {
+			crawled = r.Crawlers.nextURL(ep)
+			rqstEP.URL = crawled.url
+		}
+		if err := validateRqstURL(rqstEP.URL); err != nil {
+			if !r.reportMalformedURL(ep, err) {
+				return
+			}
+			continue
+		}
+
+		variant := variants[i%len(variants)]
+		rqstURL := rqstEP.URL
+		rqstEP.URL, err = variant.apply(rqstURL)
 		if err != nil {
-			switch e := err.(type) {
-			case *url.Error:
-				if e.Timeout() {
+			if !r.reportMalformedURL(ep, err) {
+				return
+			}
+			continue
+		}
+		var canary string
+		if canary, rqstEP.URL, err = canaryRqst(r.Canary, i, rqstEP.URL); err != nil {
+			if !r.reportMalformedURL(ep, err) {
+				return
+			}
+			continue
+		}
+		var rqstID string
+		rqstEP.Headers, rqstID = withRequestID(rqstEP.Headers, r.RequestIDHeader)
+		var rotated map[string]string
+		rqstEP.Headers, rotated = r.HeaderRotators.rotate(ep, rqstEP.Headers)
+		var revalidating bool
+		if cache != nil {
+			now := time.Now()
+			entry, fresh := cache.lookup(rqstEP.URL, now)
+			if fresh {
+				r.ClientCaches.record(variant.label(rqstURL), clientCacheHit)
+				if r.Ctx.Err() != nil {
 					return
 				}
-			default:
-				log.Warn().Err(err).Msgf("Requestor: error %s sending request, dropping %d remaining requests", err, numRqsts-(i+1))
+				if delta := rqstInterval(rqstRate) - time.Since(now); rqstRate > 0 && delta > 0 {
+					time.Sleep(delta)
+					wt.pacing += delta
+				}
+				continue
+			}
+			if entry != nil {
+				rqstEP.Headers, revalidating = entry.conditionalHeaders(rqstEP.Headers), true
+			}
+		}
+		if etags != nil {
+			rqstEP.Headers = etags.conditionalHeaders(rqstEP.URL, rqstEP.Headers)
+		}
+		client := variant.client
+		sent := &sentRqst{
+			EndpointURL: ep.URL,
+			Variant:     variant.Name,
+			URL:         rqstEP.URL,
+			Method:      ep.Method,
+			Headers:     rqstEP.Headers,
+			Body:        rqstEP.RqstBody,
+			DataRow:     dataRow,
+		}
+
+		var (
+			attempt   rqstAttempt
+			retries   int
+			queueWait time.Duration
+			// connFailed is true if the request couldn't be sent, in which case
+			// the requestor's remaining requests are dropped
+			connFailed bool
+		)
+		start := time.Now()
+		if r.RateLimits != nil {
+			ok := r.RateLimits.wait(r.Ctx, ep)
+			wt.rateLimitWait += time.Since(start)
+			if !ok {
+				log.Debug().Msg("Requestor cancelled or the run duration expired while waiting for the rate limit, exiting")
+				return
+			}
+		}
+		if r.LockGroups != nil && ep.LockGroup != "" {
+			var ok bool
+			queueWait, ok = r.LockGroups.acquire(r.Ctx, ep.LockGroup)
+			wt.lockGroupWait += queueWait
+			if !ok {
+				log.Debug().Msg("Requestor cancelled or the run duration expired while waiting for a lock group, exiting")
 				return
 			}
 		}
+		if !r.AdaptiveConcurrency.acquire(r.Ctx) {
+			if r.LockGroups != nil && ep.LockGroup != "" {
+				r.LockGroups.release(ep.LockGroup)
+			}
+			log.Debug().Msg("Requestor cancelled or the run duration expired while waiting for the concurrency to be restored, exiting")
+			return
+		}
+		// The first failure may have been recorded while waiting to send the request
+		if r.FirstFailure.stopped() {
+			r.AdaptiveConcurrency.release()
+			if r.LockGroups != nil && ep.LockGroup != "" {
+				r.LockGroups.release(ep.LockGroup)
+			}
+			return
+		}
+		sendStart := time.Now()
+		rt.startRqst()
+		for {
+			attempt, err = r.sendRqst(client, traceCtx, rqstEP)
+			if err != nil {
+				log.Warn().Err(err).Msgf("Requestor unable to create http request")
+				r.AdaptiveConcurrency.release()
+				if r.LockGroups != nil && ep.LockGroup != "" {
+					r.LockGroups.release(ep.LockGroup)
+				}
+				return
+			}
+			if retries >= retryPolicy.MaxRetries || r.Ctx.Err() != nil || r.FirstFailure.stopped() || !shouldRetry(retryPolicy, ep.Method, attempt) ||
+				(ep.ExpectedOutcome != nil && attemptOutcome(attempt) == *ep.ExpectedOutcome) {
+				break
+			}
+			retries++
+			log.Debug().Msgf("Requestor: retrying %s %s, retry %d of %d", ep.Method, rqstEP.URL, retries, retryPolicy.MaxRetries)
+		}
+		wt.inFlight += time.Since(sendStart)
+		r.AdaptiveConcurrency.release()
+		if r.LockGroups != nil && ep.LockGroup != "" {
+			r.LockGroups.release(ep.LockGroup)
+		}
+		resp := attempt.resp
+		tt := rt.snapshot()
 
-		io.Copy(ioutil.Discard, resp.Body)
-		resp.Body.Close()
+		var response Response
+		if attempt.abandoned {
+			response = Response{
+				Endpoint:        api.Endpoint{URL: variant.label(rqstURL), Method: ep.Method},
+				RequestDuration: attempt.duration,
+				AbandonedSlow:   true,
+				Retries:         retries,
+			}
+			if resp != nil {
+				response.HTTPStatus = resp.StatusCode
+				response.Header = resp.Header
+				response.RecordedHeaders = r.recordHeaders(resp.Header)
+			}
+		} else if ep.ExpectedOutcome != nil {
+			// Requests cancelled because the run ended didn't fail in any expected way
+			if attempt.err != nil && r.Ctx.Err() != nil {
+				return
+			}
+			response = negativeTestResponse(ep, variant.label(rqstURL), attempt, retries)
+			response.RecordedHeaders = r.recordHeaders(response.Header)
+		} else if category := errCategory(attempt, r.Ctx.Err() != nil); category != "" {
+			err := attempt.err
+			if err == nil {
+				err = attempt.bodyErr
+			}
+			response = Response{
+				Endpoint:        api.Endpoint{URL: variant.label(rqstURL), Method: ep.Method},
+				RequestDuration: attempt.duration,
+				Retries:         retries,
+				ErrCategory:     category,
+				Err:             err,
+				BytesReceived:   attempt.bodyBytes,
+			}
+		} else if attempt.err != nil {
+			// Requests cancelled because the run ended didn't fail
+			if isTimeout(attempt.err) || r.Ctx.Err() != nil {
+				return
+			}
+			log.Warn().Err(attempt.err).Msgf("Requestor: error %s sending request, dropping %d remaining requests", attempt.err, numRqsts-(i+1))
+			response = Response{
+				Endpoint:        api.Endpoint{URL: variant.label(rqstURL), Method: ep.Method},
+				RequestDuration: attempt.duration,
+				Retries:         retries,
+				ErrCategory:     api.ErrCategoryConnection,
+				Err:             attempt.err,
+			}
+			connFailed = true
+		} else {
+			response = Response{
+				HTTPStatus:           resp.StatusCode,
+				Endpoint:             api.Endpoint{URL: variant.label(rqstURL), Method: ep.Method},
+				Header:               resp.Header,
+				RequestDuration:      attempt.duration,
+				TTFB:                 attempt.ttfb,
+				DNSLookupDuration:    tt.dnsDone.Sub(tt.dnsStart),
+				TCPConnDuration:      tt.gotConn.Sub(tt.getConn),
+				RoundTripDuration:    tt.gotResp.Sub(tt.gotConn),
+				TLSHandshakeDuration: tt.tlsDone.Sub(tt.tlsStart),
+				ConnSetupDuration:    tt.connSetup,
+				PoolWait:             poolWait(connPoolLimited(client), tt.getConn, tt.connWaitEnd),
+				BytesSent:            int64(len(rqstEP.RqstBody)),
+				ServerClosedConn:     resp.Close,
+				Retries:              retries,
+				Proto:                resp.Proto,
+				RqstProto:            ep.ProtocolVersion,
+				ConnReused:           tt.reused,
+				WarmConn:             tt.warm,
+				Host:                 urlHost(rqstEP.URL),
+				RemoteIP:             tt.ip,
+				BytesReceived:        attempt.bodyBytes,
+				BodyLimited:          attempt.bodyLimited,
+				TraceID:              traceID(r.traceIDHeader(), resp.Header, rqstEP.Headers),
+				RequestIDNotEchoed:   rqstID != "" && resp.Header.Get(r.RequestIDHeader) != rqstID,
+				RedirectChain:        redirectChain(resp),
+				RecordedHeaders:      r.recordHeaders(resp.Header),
+				ContentType:          responseContentType(resp.Header),
+			}
+			if response.TraceID == "" {
+				response.TraceID = traceparentTraceID(attempt.traceparent)
+			}
+			response.ServerProcessingDuration = serverProcessing(tt.wroteRqst, tt.gotResp)
+			response.RequestWriteDuration = requestWrite(tt.gotConn, tt.wroteRqst)
+			response.BytesUploaded = attempt.uploaded
+			response.UploadBytesPerSec = uploadThroughput(attempt.uploaded, response.RequestWriteDuration)
+			// The HTTP/3 transport doesn't trace the headers it writes
+			if r.HeaderStats && resp.ProtoMajor != 3 {
+				sizes := tt.headers
+				sizes.received(resp.Header)
+				response.HeaderSizes = &sizes
+			}
+			response.TLSVersion, response.CipherSuite = tlsInfo(resp.TLS)
+			if ep.SuccessJSONPath != "" {
+				if err := checkSuccessJSON(ep, attempt.body); err != nil {
+					response.ErrCategory = api.ErrCategoryUnsuccessfulJSON
+					response.Err = err
+				}
+			}
+			if successExpr != nil && response.ErrCategory == "" {
+				env := successExprEnv{
+					status:     resp.StatusCode,
+					durationMs: float64(attempt.duration) / float64(time.Millisecond),
+					bytes:      attempt.bodyBytes,
+					body:       attempt.body,
+				}
+				success, err := successExpr.eval(env)
+				switch {
+				case err != nil:
+					response.ErrCategory = api.ErrCategorySuccessExprError
+					response.Err = err
+				case !success:
+					response.ErrCategory = api.ErrCategoryUnsuccessfulExpr
+					response.Err = fmt.Errorf("SuccessExpr %q was false", ep.SuccessExpr)
+				}
+			}
+			if ep.ExpectedSHA256 != "" {
+				response.BodySHA256 = attempt.bodySHA256
+				if err := checkSHA256(ep, attempt); err != nil && response.ErrCategory == "" {
+					response.ErrCategory = api.ErrCategoryChecksumMismatch
+					response.Err = err
+				}
+			}
+			if ep.ExpectedContentType != "" && response.ErrCategory == "" && resp.StatusCode < 400 {
+				if err := checkContentType(ep, response.ContentType, attempt.bodyBytes); err != nil {
+					response.ErrCategory = api.ErrCategoryUnexpectedContentType
+					response.Err = err
+				}
+			}
+			if response.ErrCategory == "" {
+				if err := r.ResponseHook.validate(r.Ctx, rqstEP.URL, ep.Method, resp, attempt.body, attempt.duration); err != nil {
+					response.ErrCategory = api.ErrCategoryHookRejected
+					response.Err = err
+				}
+			}
+			if response.ErrCategory == "" && resp.StatusCode < 400 {
+				r.Crawlers.discover(ep, crawled, resp.Header, attempt.body)
+			}
+		}
+
+		if cache != nil {
+			r.recordClientCache(cache, rqstEP.URL, variant.label(rqstURL), revalidating, attempt)
+		}
+		if etags != nil && resp != nil && !attempt.abandoned && attempt.err == nil {
+			etags.capture(rqstEP.URL, resp.StatusCode, resp.Header)
+		}
 
-		select {
-		case <-r.Ctx.Done():
+		response.Endpoint.GroupByHeader = ep.GroupByHeader
+		response.Endpoint.Description = ep.Description
+		response.RotatedHeaders = rotated
+		response.QueueWait = queueWait
+		response.Rqst = sent
+		response.Canary = canary
+		r.HAR.record(response, attempt.body, harTimes{
+			start: attempt.start, getConn: tt.getConn, connWaitEnd: tt.connWaitEnd, dnsStart: tt.dnsStart, dnsDone: tt.dnsDone,
+			connectStart: tt.connectStart, tlsStart: tt.tlsStart, tlsDone: tt.tlsDone, gotConn: tt.gotConn, wroteRqst: tt.wroteRqst,
+			gotResp: tt.gotResp, end: attempt.start.Add(attempt.duration), reused: tt.reused,
+		})
+		r.AdaptiveConcurrency.record(response)
+		if !r.sendResponse(response) {
 			log.Debug().Msg("Requestor cancelled or the run duration expired, exiting")
 			return
-		case r.ResponseC <- Response{
-			HTTPStatus:           resp.StatusCode,
-			Endpoint:             api.Endpoint{URL: ep.URL, Method: ep.Method},
-			Header:               resp.Header,
-			RequestDuration:      time.Since(start),
-			DNSLookupDuration:    dnsDone.Sub(dnsStart),
-			TCPConnDuration:      connDone.Sub(connStart),
-			RoundTripDuration:    gotResp.Sub(connDone),
-			TLSHandshakeDuration: tlsDone.Sub(tlsStart),
-		}:
+		}
+		if r.stopOnFailure(response, attempt.body) || r.recordEarlyFail(ep, response) || connFailed {
+			return
 		}
 
 		// Zero request rate is completely unthrottled
@@ -137,11 +562,452 @@ func (r Requestor) ProcessRqst(ep api.Endpoint, numRqsts int, rqstRate int) {
 			continue
 		}
 		since := time.Since(start)
-		delta := (time.Second / time.Duration(rqstRate)) - since
+		delta := rqstInterval(rqstRate) - since
 		if delta < 0 {
 			continue
 		}
 		time.Sleep(delta)
+		wt.pacing += delta
+
+	}
+}
 
+// recordClientCache caches the response of 'attempt', a request to 'rqstURL' reported
+// as 'label', in 'cache' and counts how it was served
+func (r Requestor) recordClientCache(cache *clientCache, rqstURL, label string, revalidating bool, attempt rqstAttempt) {
+	served := clientCacheFullFetch
+	if revalidating {
+		served = clientCacheRevalidated
+	}
+	if resp := attempt.resp; resp != nil && !attempt.abandoned && attempt.err == nil {
+		switch {
+		case revalidating && resp.StatusCode == http.StatusNotModified:
+			served = clientCacheNotModified
+			cache.store(rqstURL, resp.Header, time.Now())
+		case resp.StatusCode == http.StatusOK:
+			cache.store(rqstURL, resp.Header, time.Now())
+		}
+	}
+	r.ClientCaches.record(label, served)
+}
+
+// endpointClient returns the client used to send requests to 'ep', a copy of
+// r.Client configured with 'ep's CertFile, UnixSocket, and ProtocolVersion
+func (r Requestor) endpointClient(ep api.Endpoint) http.Client {
+	client := r.Client
+	if ep.CertFile != "" {
+		client = certClient(client, ep.URL, ep.CertFile, ep.KeyFile)
+	}
+	if r.ForbidCrossHostRedirects {
+		client.CheckRedirect = forbidCrossHostRedirects
+	}
+
+	if ep.UnixSocket != "" {
+		t, ok := client.Transport.(*http.Transport)
+		if !ok {
+			if client.Transport != nil {
+				log.Fatal().Msgf("Endpoint: %s, Endpoint.UnixSocket is only supported for HTTP/1.1 and HTTP/2", ep.URL)
+			}
+			t = http.DefaultTransport.(*http.Transport)
+		}
+		client.Transport = unixSocketTransport(t, ep.UnixSocket, r.ReadIdleTimeout)
+	}
+	if ep.ProtocolVersion == api.ProtocolVersionHTTP10 {
+		client = http10Client(client, ep.URL)
+	}
+	return client
+}
+
+// certClient returns a copy of 'client' that presents the client certificate in
+// 'certFile' and 'keyFile' to the endpoint at 'url'
+func certClient(client http.Client, url, certFile, keyFile string) http.Client {
+	if keyFile == "" {
+		log.Fatal().Msgf("Endpoint: %s, Endpoint.CertFile specified: %s, Endpoint.KeyFile is not", url, certFile)
+	}
+	log.Debug().Msgf("Endpoint %s is overriding SSL certificate using certificate file %s", url, certFile)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error creating x509 keypair")
+	}
+	t1, ok := client.Transport.(*http.Transport)
+	if !ok {
+		log.Fatal().Msgf("Endpoint: %s, Endpoint.CertFile is only supported for HTTP/1.1 and HTTP/2", url)
+	}
+	t2 := &http.Transport{
+		MaxIdleConnsPerHost:   t1.MaxConnsPerHost,
+		MaxConnsPerHost:       t1.MaxConnsPerHost,
+		DisableCompression:    t1.DisableCompression,
+		DisableKeepAlives:     t1.DisableKeepAlives,
+		ForceAttemptHTTP2:     t1.ForceAttemptHTTP2,
+		DialContext:           t1.DialContext,
+		ResponseHeaderTimeout: t1.ResponseHeaderTimeout,
+		TLSClientConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		},
+	}
+	client.Transport = t2
+	return client
+}
+
+// rqstVariant is an api.EndpointVariant along with the client used to send its requests
+type rqstVariant struct {
+	api.EndpointVariant
+	client http.Client
+	// summaryLabel is the endpoint's SummaryLabel
+	summaryLabel string
+}
+
+// newRqstVariants returns the variants of 'ep' that requests rotate through. An
+// endpoint without variants has a single, unnamed, variant that leaves its requests
+// unchanged. Requests for each variant are sent using 'client' unless the variant
+// overrides the client certificate.
+func newRqstVariants(ep api.Endpoint, client http.Client) []rqstVariant {
+	if len(ep.Variants) == 0 {
+		return []rqstVariant{{client: client, summaryLabel: ep.SummaryLabel}}
+	}
+
+	variants := make([]rqstVariant, 0, len(ep.Variants))
+	for _, v := range ep.Variants {
+		variant := rqstVariant{EndpointVariant: v, client: client, summaryLabel: ep.SummaryLabel}
+		if v.CertFile != "" {
+			variant.client = certClient(client, ep.URL+"#"+v.Name, v.CertFile, v.KeyFile)
+		}
+		variants = append(variants, variant)
+	}
+	return variants
+}
+
+// apply returns 'rqstURL' with the variant's scheme, host, and port overrides applied
+func (v rqstVariant) apply(rqstURL string) (string, error) {
+	if v.Scheme == "" && v.Host == "" && v.Port == "" {
+		return rqstURL, nil
+	}
+	u, err := url.Parse(rqstURL)
+	if err != nil {
+		return "", err
+	}
+	if v.Scheme != "" {
+		u.Scheme = v.Scheme
+	}
+	host, port := u.Hostname(), u.Port()
+	if v.Host != "" {
+		host = v.Host
+	}
+	if v.Port != "" {
+		port = v.Port
+	}
+	u.Host = host
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	}
+	return u.String(), nil
+}
+
+// label returns the URL used to report the results of the variant's requests to
+// 'rqstURL', i.e., 'rqstURL#variantName'. Labelling the results with the endpoint's
+// URL, rather than the URL the variant sent the request to, keeps the results of an
+// endpoint's variants together in the reports. The URL of an endpoint with a
+// SummaryLabel is 'rqstURL#SummaryLabel' before the variant's name is appended.
+func (v rqstVariant) label(rqstURL string) string {
+	if v.summaryLabel != "" {
+		rqstURL += "#" + v.summaryLabel
+	}
+	if v.Name == "" {
+		return rqstURL
+	}
+	return rqstURL + "#" + v.Name
+}
+
+// recordEarlyFail records the outcome of 'response' to 'ep' with the Requestor's
+// EarlyFailTracker, and its CircuitBreakers. It returns true if the endpoint has failed
+// and no more requests should be made to it. The entire run is ended if the tracker is
+// configured to abort it.
+func (r Requestor) recordEarlyFail(ep api.Endpoint, response Response) bool {
+	success := response.ErrCategory == "" && !response.AbandonedSlow &&
+		(response.HTTPStatus < http.StatusBadRequest || ep.ExpectedOutcome != nil)
+	r.CircuitBreakers.record(ep, success)
+	if r.EarlyFail == nil {
+		return false
+	}
+	if !r.EarlyFail.Record(ep, success) {
+		return false
+	}
+
+	if r.EarlyFail.AbortRun && r.Cancel != nil {
+		log.Error().Msgf("Requestor: the first %d responses from endpoint %s %s were all errors, ending the run",
+			earlyFailThreshold(ep), ep.Method, ep.URL)
+		r.Cancel()
+		return true
+	}
+	log.Debug().Msgf("Requestor: the first %d responses from endpoint %s %s were all errors, exiting",
+		earlyFailThreshold(ep), ep.Method, ep.URL)
+	return true
+}
+
+// unixSocketTransport returns a copy of 't' that connects to the Unix domain socket
+// 'socket' regardless of the host in the request URL. 'readIdleTimeout' is applied to
+// the connections as described by WithReadIdleTimeout.
+func unixSocketTransport(t *http.Transport, socket string, readIdleTimeout time.Duration) *http.Transport {
+	t = t.Clone()
+	var dialer net.Dialer
+	dial := WithReadIdleTimeout(dialer.DialContext, readIdleTimeout)
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dial(ctx, "unix", socket)
+	}
+	return t
+}
+
+// rqstAttempt describes the outcome of a single attempt at sending a request
+type rqstAttempt struct {
+	// resp is the response, it's nil if 'err' is set
+	resp *http.Response
+	// err is the error returned sending the request
+	err error
+	// start is when the request was sent
+	start time.Time
+	// bodyErr is the error returned reading the response body
+	bodyErr error
+	// uploaded is the number of bytes of the request body written
+	uploaded int64
+	// bodyBytes is the number of bytes of the response body that were read
+	bodyBytes int64
+	// body is the response body. It's only retained if the endpoint has a
+	// SuccessJSONPath, a SuccessExpr, or a Crawl LinkJSONPath, the run has a
+	// ResponseHook, or the run stops on its first failure.
+	body []byte
+	// bodyLimited indicates the response body was larger than
+	// Requestor.MaxResponseBodyBytes so only part of it was read
+	bodyLimited bool
+	// traceparent is the traceparent header injected by Requestor.Tracer, if any
+	traceparent string
+	// bodySHA256 is the hex encoded SHA-256 digest of the part of the response body
+	// that was read. It's only calculated if the endpoint has an ExpectedSHA256.
+	bodySHA256 string
+	// abandoned indicates the request exceeded the soft deadline
+	abandoned bool
+	// ttfb is how long it took to receive the response's headers
+	ttfb time.Duration
+	// duration is how long the attempt took, including reading the response body
+	duration time.Duration
+}
+
+// retainedBodyLimit returns the most bytes of a response body from 'ep' that are
+// retained, 0 if none are. Bodies that are validated, i.e., 'ep' has a SuccessJSONPath,
+// a SuccessExpr, or a Crawl LinkJSONPath, or the Requestor has a ResponseHook, are
+// retained up to maxSuccessJSONBodySize. Otherwise they're only retained up to the
+// size recorded by the HAR, or as the FirstFailure, if it hasn't been recorded yet.
+func (r Requestor) retainedBodyLimit(ep api.Endpoint) int64 {
+	if ep.SuccessJSONPath != "" || ep.SuccessExpr != "" || crawlsJSON(ep) || r.ResponseHook != nil {
+		return maxSuccessJSONBodySize
+	}
+	var limit int64
+	if r.HAR != nil {
+		limit = harBodyLimit
+	}
+	if r.FirstFailure != nil && r.FirstFailure.Failure() == nil && limit < replayBodyLimit {
+		limit = replayBodyLimit
+	}
+	return limit
+}
+
+// sendRqst makes a single attempt at sending the request described by 'ep', reading and
+// discarding the response body. The start of the body is retained, see
+// retainedBodyLimit, so it can be validated or reported. An error is only returned if
+// the request couldn't be created, errors sending the request are reported in the
+// returned rqstAttempt.
+func (r Requestor) sendRqst(client http.Client, ctx context.Context, ep api.Endpoint) (rqstAttempt, error) {
+	rqstCtx, rqstCancel := ctx, context.CancelFunc(func() {})
+	if r.SoftDeadline > 0 {
+		rqstCtx, rqstCancel = context.WithTimeout(ctx, r.SoftDeadline)
+	}
+	defer rqstCancel()
+
+	req, err := newRqst(rqstCtx, ep)
+	if err != nil {
+		return rqstAttempt{}, err
+	}
+	if r.FailureInjector.fail() {
+		return rqstAttempt{err: errInjectedFailure}, nil
+	}
+
+	var uploaded *countingBody
+	if req.Body != nil && req.Body != http.NoBody {
+		uploaded = &countingBody{ReadCloser: req.Body}
+		req.Body = uploaded
+	}
+
+	var attempt rqstAttempt
+	traceparent, endSpan := r.startSpan(req)
+	attempt.traceparent = traceparent
+	start := time.Now()
+	attempt.start = start
+	attempt.resp, attempt.err = client.Do(req)
+	if attempt.err == nil {
+		attempt.ttfb = time.Since(start)
+		// One byte more than the limit is read to detect bodies exceeding it
+		body := io.Reader(attempt.resp.Body)
+		if r.MaxResponseBodyBytes > 0 {
+			body = io.LimitReader(body, r.MaxResponseBodyBytes+1)
+		}
+		var digest hash.Hash
+		if ep.ExpectedSHA256 != "" {
+			digest = sha256.New()
+			body = io.TeeReader(body, digest)
+		}
+		if limit := r.retainedBodyLimit(ep); limit > 0 {
+			attempt.body, attempt.bodyErr = ioutil.ReadAll(io.LimitReader(body, limit))
+			attempt.bodyBytes = int64(len(attempt.body))
+		}
+		if attempt.bodyErr == nil {
+			var n int64
+			n, attempt.bodyErr = io.Copy(ioutil.Discard, body)
+			attempt.bodyBytes += n
+		}
+		attempt.resp.Body.Close()
+		if digest != nil {
+			attempt.bodySHA256 = hex.EncodeToString(digest.Sum(nil))
+		}
+		if r.MaxResponseBodyBytes > 0 && attempt.bodyBytes > r.MaxResponseBodyBytes {
+			attempt.bodyLimited = true
+			attempt.bodyBytes = r.MaxResponseBodyBytes
+			if int64(len(attempt.body)) > r.MaxResponseBodyBytes {
+				attempt.body = attempt.body[:r.MaxResponseBodyBytes]
+			}
+		}
+	}
+	attempt.duration = time.Since(start)
+	endSpan(attempt.resp, attempt.err)
+	if uploaded != nil {
+		attempt.uploaded = uploaded.count()
+	}
+
+	// The soft deadline expiring, as opposed to the run ending, means the request was
+	// abandoned by the client rather than timing out.
+	attempt.abandoned = (attempt.err != nil || attempt.bodyErr != nil) &&
+		rqstCtx.Err() == context.DeadlineExceeded && r.Ctx.Err() == nil
+
+	return attempt, nil
+}
+
+// isTimeout reports whether 'err' is the result of a request timing out
+func isTimeout(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && urlErr.Timeout()
+}
+
+// isIdempotent reports whether 'method' is idempotent per RFC 7231
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// shouldRetry reports whether 'attempt' should be retried according to 'policy'.
+// Requests abandoned at the soft deadline are never retried since abandoning them
+// was a deliberate choice. Non-idempotent requests are only retried if the policy
+// explicitly allows it.
+func shouldRetry(policy api.RetryPolicy, method string, attempt rqstAttempt) bool {
+	if attempt.abandoned {
+		return false
+	}
+	if !isIdempotent(method) && !policy.RetryNonIdempotent {
+		return false
+	}
+	if attempt.err != nil {
+		if isTimeout(attempt.err) {
+			return policy.OnTimeout
+		}
+		return policy.OnConnError
+	}
+	for _, status := range policy.OnStatus {
+		if attempt.resp.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRqstURL verifies that 'rqstURL' is a valid absolute URL. The returned error
+// is a *url.Error containing the offending URL.
+func validateRqstURL(rqstURL string) error {
+	u, err := url.Parse(rqstURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return &url.Error{Op: "parse", URL: rqstURL, Err: errors.New("URL must include a scheme and host")}
+	}
+	return nil
+}
+
+// reportMalformedURL reports a request to 'ep' that wasn't sent because its rendered
+// URL is malformed. It returns false if the requestor should stop making requests,
+// either because the run has ended or because malformed URLs are configured to end it.
+func (r Requestor) reportMalformedURL(ep api.Endpoint, err error) bool {
+	log.Warn().Err(err).Msgf("Requestor: skipping request to endpoint %s, malformed URL", ep.URL)
+	response := Response{
+		Endpoint:    api.Endpoint{URL: summaryURL(ep, ep.URL), Method: ep.Method, Description: ep.Description},
+		ErrCategory: api.ErrCategoryMalformedURL,
+		Err:         err,
+	}
+	if !r.sendResponse(response) {
+		return false
+	}
+	if r.stopOnFailure(response, nil) || r.recordEarlyFail(ep, response) {
+		return false
+	}
+
+	if !r.FailOnMalformedURL {
+		return true
+	}
+	log.Error().Err(err).Msgf("Requestor: endpoint %s has a malformed URL, ending the run", ep.URL)
+	if r.Cancel != nil {
+		r.Cancel()
+	}
+	return false
+}
+
+// newRqst creates the request described by 'ep'. A new request is created for every
+// request sent so that request bodies are never shared between requests. A body
+// without an explicit Content-Type header is sent with the Content-Type it looks like,
+// if it's JSON, XML, or a URL encoded form.
+func newRqst(ctx context.Context, ep api.Endpoint) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, ep.Method, ep.URL, bytes.NewReader([]byte(ep.RqstBody)))
+	if err != nil {
+		return nil, err
+	}
+	for headerName, headerValue := range ep.Headers {
+		req.Header.Add(headerName, headerValue)
+	}
+	if _, ok := req.Header["Content-Type"]; !ok {
+		if contentType := sniffRqstContentType(ep.RqstBody); contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+	}
+	return req, nil
+}
+
+// handleRenderErr applies the appropriate policy when an endpoint's templates can't
+// be rendered. The requestor goroutine always stops making requests, exhausting a
+// 'uniqueInt' range configured to fail the run will also end the run.
+func (r Requestor) handleRenderErr(ep api.Endpoint, err error) {
+	var exhausted uniqueIntExhaustedError
+	if !errors.As(err, &exhausted) {
+		log.Warn().Err(err).Msgf("Requestor unable to render endpoint %s templates", ep.URL)
+		return
+	}
+
+	if exhausted.policy != api.UniqueIntFail {
+		log.Info().Msgf("Requestor: %s, stopping requests to endpoint %s", exhausted, ep.URL)
+		return
+	}
+	log.Error().Msgf("Requestor: %s, ending the run", exhausted)
+	if r.UniqueInts != nil {
+		r.UniqueInts.fail(exhausted)
+	}
+	if r.Cancel != nil {
+		r.Cancel()
 	}
 }
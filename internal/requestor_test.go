@@ -6,9 +6,19 @@ package internal
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -117,6 +127,25 @@ func TestCtxCancel(t *testing.T) {
 	wg.Wait()
 }
 
+// TestCancelledRqstsNotReported verifies the requests in flight when the run ends
+// aren't reported as failed
+func TestCancelledRqstsNotReported(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer testSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	respC := make(chan Response, 1)
+	rqstr := Requestor{Ctx: ctx, ResponseC: respC, Client: http.Client{}, Cancel: cancel}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	rqstr.ProcessRqst(api.Endpoint{URL: testSrv.URL + "/slow", Method: http.MethodGet, RqstPercent: 100}, 10, 0)
+	close(respC)
+	for resp := range respC {
+		t.Errorf("expected the cancelled request not to be reported, got %s %v", resp.ErrCategory, resp.Err)
+	}
+}
+
 func TestTimeout(t *testing.T) {
 	ep := api.Endpoint{
 		Method:      "GET",
@@ -150,3 +179,557 @@ func TestTimeout(t *testing.T) {
 
 	wg.Wait()
 }
+
+// TestSoftDeadline verifies that requests exceeding the soft deadline are abandoned
+// and reported as such rather than being treated as timeouts.
+func TestSoftDeadline(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testSrv.Close()
+
+	ep := api.Endpoint{
+		URL:         testSrv.URL + "/slow",
+		Method:      "GET",
+		RqstPercent: 100,
+	}
+
+	numRqsts := 3
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{
+		Ctx:          context.Background(),
+		ResponseC:    respC,
+		Client:       http.Client{},
+		SoftDeadline: 20 * time.Millisecond,
+	}
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	close(respC)
+
+	numAbandoned := 0
+	for resp := range respC {
+		if !resp.AbandonedSlow {
+			t.Errorf("expected response to be abandoned, got %+v", resp)
+		}
+		if resp.RequestDuration >= 100*time.Millisecond {
+			t.Errorf("expected request to be abandoned at the soft deadline, took %s", resp.RequestDuration)
+		}
+		numAbandoned++
+	}
+	if numAbandoned != numRqsts {
+		t.Errorf("expected %d abandoned requests, got %d", numRqsts, numAbandoned)
+	}
+}
+
+// TestServerClosedConn verifies that responses after which the server closes the
+// connection are identified.
+func TestServerClosedConn(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("close") == "true" {
+			w.Header().Set("Connection", "close")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedClosed bool
+	}{
+		{name: "KeepAlive", url: testSrv.URL + "/keepalive", expectedClosed: false},
+		{name: "ConnectionClose", url: testSrv.URL + "/close?close=true", expectedClosed: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			numRqsts := 3
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    http.Client{},
+			}
+			rqstr.ProcessRqst(api.Endpoint{URL: tc.url, Method: "GET", RqstPercent: 100}, numRqsts, 0)
+			close(respC)
+
+			numResps := 0
+			for resp := range respC {
+				numResps++
+				if resp.ServerClosedConn != tc.expectedClosed {
+					t.Errorf("expected ServerClosedConn to be %t, got %t", tc.expectedClosed, resp.ServerClosedConn)
+				}
+			}
+			if numResps != numRqsts {
+				t.Errorf("expected %d responses, got %d", numRqsts, numResps)
+			}
+		})
+	}
+}
+
+// TestRetryPolicy verifies that timeouts, connection errors, and HTTP statuses are
+// retried independently of each other, and only for idempotent requests unless
+// retrying non-idempotent requests is explicitly allowed.
+func TestRetryPolicy(t *testing.T) {
+	var hits int64
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name            string
+		path            string
+		method          string
+		policy          api.RetryPolicy
+		expectedHits    int64
+		expectedRetries int
+	}{
+		{
+			name:         "TimeoutNotRetriedWhenOff",
+			path:         "/slow",
+			method:       http.MethodGet,
+			policy:       api.RetryPolicy{MaxRetries: 2, OnConnError: true, OnStatus: []int{503}},
+			expectedHits: 1,
+		},
+		{
+			name:         "TimeoutRetriedWhenOn",
+			path:         "/slow",
+			method:       http.MethodGet,
+			policy:       api.RetryPolicy{MaxRetries: 2, OnTimeout: true},
+			expectedHits: 3,
+		},
+		{
+			name:         "NonIdempotentTimeoutNotRetried",
+			path:         "/slow",
+			method:       http.MethodPost,
+			policy:       api.RetryPolicy{MaxRetries: 2, OnTimeout: true},
+			expectedHits: 1,
+		},
+		{
+			name:         "NonIdempotentTimeoutRetriedWhenAllowed",
+			path:         "/slow",
+			method:       http.MethodPost,
+			policy:       api.RetryPolicy{MaxRetries: 2, OnTimeout: true, RetryNonIdempotent: true},
+			expectedHits: 3,
+		},
+		{
+			name:            "StatusRetried",
+			path:            "/unavailable",
+			method:          http.MethodGet,
+			policy:          api.RetryPolicy{MaxRetries: 2, OnStatus: []int{503}},
+			expectedHits:    3,
+			expectedRetries: 2,
+		},
+		{
+			name:         "StatusNotRetriedWhenOnlyTimeoutsAre",
+			path:         "/unavailable",
+			method:       http.MethodGet,
+			policy:       api.RetryPolicy{MaxRetries: 2, OnTimeout: true},
+			expectedHits: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			atomic.StoreInt64(&hits, 0)
+			respC := make(chan Response, 1)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    http.Client{Timeout: 20 * time.Millisecond},
+				Retry:     api.RetryPolicy{MaxRetries: 5, OnStatus: []int{503}},
+			}
+			ep := api.Endpoint{
+				URL:         testSrv.URL + tc.path,
+				Method:      tc.method,
+				RqstPercent: 100,
+				Retry:       &tc.policy,
+			}
+			rqstr.ProcessRqst(ep, 1, 0)
+			close(respC)
+
+			if actual := atomic.LoadInt64(&hits); actual != tc.expectedHits {
+				t.Errorf("expected %d requests to reach the server, got %d", tc.expectedHits, actual)
+			}
+			for resp := range respC {
+				if resp.Retries != tc.expectedRetries {
+					t.Errorf("expected %d retries, got %d", tc.expectedRetries, resp.Retries)
+				}
+			}
+		})
+	}
+}
+
+// TestMalformedURL verifies that requests whose rendered URL is malformed are reported
+// as MalformedURL errors and that the requestor moves on to the next request, unless
+// it's configured to end the run.
+func TestMalformedURL(t *testing.T) {
+	srvHandler := srvHandler{HTTPStatus: 200}
+	testSrv := httptest.NewServer(http.HandlerFunc(srvHandler.ServeHTTP))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name               string
+		failOnMalformedURL bool
+		expectedMalformed  int
+		expectedOK         int
+		expectedCancelled  bool
+	}{
+		{name: "WarnAndContinue", expectedMalformed: 2, expectedOK: 2},
+		{name: "FailRun", failOnMalformedURL: true, expectedMalformed: 1, expectedCancelled: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			uniqueInts, err := NewUniqueIntCounters(nil)
+			if err != nil {
+				t.Fatalf("unexpected error creating UniqueIntCounters: %s", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			respC := make(chan Response, 4)
+			rqstr := Requestor{
+				Ctx:                ctx,
+				ResponseC:          respC,
+				Client:             http.Client{},
+				Cancel:             cancel,
+				UniqueInts:         uniqueInts,
+				FailOnMalformedURL: tc.failOnMalformedURL,
+			}
+			// The first 2 rendered URLs contain a space in the host name
+			ep := api.Endpoint{
+				URL:         `{{ if lt (uniqueInt "rqsts") 2 }}http://bad host{{ else }}` + testSrv.URL + `{{ end }}/items`,
+				Method:      http.MethodGet,
+				RqstPercent: 100,
+			}
+			rqstr.ProcessRqst(ep, 4, 0)
+			close(respC)
+
+			numMalformed, numOK := 0, 0
+			for resp := range respC {
+				switch {
+				case resp.ErrCategory == api.ErrCategoryMalformedURL:
+					numMalformed++
+					if resp.Endpoint.URL != ep.URL {
+						t.Errorf("expected malformed URL to be reported for endpoint %s, got %s", ep.URL, resp.Endpoint.URL)
+					}
+				case resp.HTTPStatus == http.StatusOK:
+					numOK++
+				default:
+					t.Errorf("unexpected response %+v", resp)
+				}
+			}
+			if numMalformed != tc.expectedMalformed || numOK != tc.expectedOK {
+				t.Errorf("expected %d malformed and %d OK responses, got %d and %d", tc.expectedMalformed, tc.expectedOK,
+					numMalformed, numOK)
+			}
+			if (ctx.Err() != nil) != tc.expectedCancelled {
+				t.Errorf("expected run cancelled to be %t, got %t", tc.expectedCancelled, ctx.Err() != nil)
+			}
+		})
+	}
+}
+
+// TestProtocolAndConnReuse verifies that the negotiated protocol and whether the
+// connection was reused are recorded for HTTP/1.1 and HTTP/2 requests
+func TestProtocolAndConnReuse(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h1Srv := httptest.NewServer(handler)
+	defer h1Srv.Close()
+	h2Srv := httptest.NewUnstartedServer(handler)
+	h2Srv.EnableHTTP2 = true
+	h2Srv.StartTLS()
+	defer h2Srv.Close()
+
+	tests := []struct {
+		name          string
+		url           string
+		client        *http.Client
+		expectedProto string
+	}{
+		{name: "HTTP1", url: h1Srv.URL, client: &http.Client{}, expectedProto: "HTTP/1.1"},
+		{name: "HTTP2", url: h2Srv.URL, client: h2Srv.Client(), expectedProto: "HTTP/2.0"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			numRqsts := 3
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    *tc.client,
+			}
+			rqstr.ProcessRqst(api.Endpoint{URL: tc.url, Method: "GET", RqstPercent: 100}, numRqsts, 0)
+			close(respC)
+
+			i := 0
+			for resp := range respC {
+				if resp.Proto != tc.expectedProto {
+					t.Errorf("expected protocol %s, got %s", tc.expectedProto, resp.Proto)
+				}
+				// The requests are sequential so only the first needs a new connection
+				if resp.ConnReused != (i > 0) {
+					t.Errorf("expected ConnReused to be %t for request %d, got %t", i > 0, i, resp.ConnReused)
+				}
+				i++
+			}
+			if i != numRqsts {
+				t.Errorf("expected %d responses, got %d", numRqsts, i)
+			}
+		})
+	}
+}
+
+// TestUnixSocket verifies that requests to an endpoint configured with a UnixSocket
+// are sent to the server listening on the socket, using the path from the URL
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heyyall")
+	if err != nil {
+		t.Fatalf("unable to create socket directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := net.Listen("unix", filepath.Join(dir, "heyyall.sock"))
+	if err != nil {
+		t.Fatalf("unable to listen on unix socket: %s", err)
+	}
+	testSrv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	testSrv.Listener.Close()
+	testSrv.Listener = l
+	testSrv.Start()
+	defer testSrv.Close()
+
+	tests := []struct {
+		name          string
+		pipelineDepth int
+	}{
+		{name: "Default"},
+		{name: "Pipelined", pipelineDepth: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			numRqsts := 4
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    http.Client{},
+			}
+			ep := api.Endpoint{
+				URL:           "http://localhost/users",
+				Method:        http.MethodGet,
+				RqstPercent:   100,
+				UnixSocket:    l.Addr().String(),
+				PipelineDepth: tc.pipelineDepth,
+			}
+			rqstr.ProcessRqst(ep, numRqsts, 0)
+			close(respC)
+
+			numResps := 0
+			for resp := range respC {
+				numResps++
+				if resp.HTTPStatus != http.StatusOK {
+					t.Errorf("expected status %d, got %d", http.StatusOK, resp.HTTPStatus)
+				}
+			}
+			if numResps != numRqsts {
+				t.Errorf("expected %d responses, got %d", numRqsts, numResps)
+			}
+		})
+	}
+}
+
+// TestVariants verifies that requests rotate through an endpoint's variants, that each
+// variant's overrides are applied, and that the results of each variant are reported
+// separately
+func TestVariants(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("X-Scheme", "https")
+		} else {
+			w.Header().Set("X-Scheme", "http")
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	plainSrv := httptest.NewServer(handler)
+	defer plainSrv.Close()
+	tlsSrv := httptest.NewTLSServer(handler)
+	defer tlsSrv.Close()
+
+	tlsURL, err := url.Parse(tlsSrv.URL)
+	if err != nil {
+		t.Fatalf("unable to parse URL %s: %s", tlsSrv.URL, err)
+	}
+	ep := api.Endpoint{
+		URL:         plainSrv.URL + "/users",
+		Method:      http.MethodGet,
+		RqstPercent: 100,
+		Variants: []api.EndpointVariant{
+			{Name: "plain"},
+			{Name: "tls", Scheme: "https", Port: tlsURL.Port()},
+		},
+	}
+
+	numRqsts := 6
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    *tlsSrv.Client(),
+	}
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	close(respC)
+
+	schemes := make(map[string]map[string]int)
+	for resp := range respC {
+		if resp.HTTPStatus != http.StatusOK {
+			t.Errorf("expected status %d, got %d", http.StatusOK, resp.HTTPStatus)
+		}
+		if schemes[resp.Endpoint.URL] == nil {
+			schemes[resp.Endpoint.URL] = make(map[string]int)
+		}
+		schemes[resp.Endpoint.URL][resp.Header.Get("X-Scheme")]++
+	}
+
+	expected := map[string]map[string]int{
+		ep.URL + "#plain": {"http": 3},
+		ep.URL + "#tls":   {"https": 3},
+	}
+	if !reflect.DeepEqual(schemes, expected) {
+		t.Errorf("expected responses by variant %v, got %v", expected, schemes)
+	}
+}
+
+// TestRecordResponseHeaders verifies only the configured response headers are recorded
+func TestRecordResponseHeaders(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", "abc123")
+		w.Header().Set("Server", "test-server")
+		w.Header().Set("X-Other", "not recorded")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testSrv.Close()
+
+	respC := make(chan Response, 1)
+	rqstr := Requestor{
+		Ctx:                   context.Background(),
+		ResponseC:             respC,
+		Client:                http.Client{},
+		RecordResponseHeaders: []string{"x-request-id", "Server", "X-Missing"},
+	}
+	ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet, RqstPercent: 100}
+	rqstr.ProcessRqst(ep, 1, 0)
+	close(respC)
+
+	resp := <-respC
+	expected := http.Header{
+		"X-Request-Id": {"abc123"},
+		"Server":       {"test-server"},
+	}
+	if !reflect.DeepEqual(resp.RecordedHeaders, expected) {
+		t.Errorf("expected recorded headers %v, got %v", expected, resp.RecordedHeaders)
+	}
+}
+
+// TestMaxResponseBodyBytes verifies only the configured maximum of a huge response body
+// is read, keeping memory use bounded
+func TestMaxResponseBodyBytes(t *testing.T) {
+	const bodySize, maxBodyBytes = 1 << 30, 1 << 20
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(bodySize))
+		w.WriteHeader(http.StatusOK)
+		chunk := make([]byte, 64*1024)
+		for written := 0; written < bodySize; written += len(chunk) {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer testSrv.Close()
+
+	for _, retainBody := range []bool{false, true} {
+		t.Run(fmt.Sprintf("RetainBody=%t", retainBody), func(t *testing.T) {
+			respC := make(chan Response, 1)
+			rqstr := Requestor{
+				Ctx:                  context.Background(),
+				ResponseC:            respC,
+				Client:               http.Client{},
+				MaxResponseBodyBytes: maxBodyBytes,
+			}
+			ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet, RqstPercent: 100}
+			if retainBody {
+				ep.SuccessExpr = "status == 200"
+			}
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			rqstr.ProcessRqst(ep, 1, 0)
+			runtime.ReadMemStats(&after)
+			close(respC)
+
+			resp := <-respC
+			if resp.ErrCategory != "" {
+				t.Fatalf("unexpected error category %s: %v", resp.ErrCategory, resp.Err)
+			}
+			if !resp.BodyLimited {
+				t.Errorf("expected the response to be body limited")
+			}
+			if resp.BytesReceived != maxBodyBytes {
+				t.Errorf("expected %d bytes received, got %d", maxBodyBytes, resp.BytesReceived)
+			}
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 32*maxBodyBytes {
+				t.Errorf("expected memory use to be bounded by the body limit, %d bytes were allocated", allocated)
+			}
+		})
+	}
+}
+
+// TestRetainedBodyLimit verifies that response bodies only retained so they can be
+// recorded, by the HAR or as the first failure, are only retained up to the size
+// recorded, and only until the first failure is recorded
+func TestRetainedBodyLimit(t *testing.T) {
+	ep := api.Endpoint{URL: "http://someservice.test/users", Method: http.MethodGet}
+	firstFailure := NewFirstFailureTracker()
+	har, err := NewHARRecorder(1, 1)
+	if err != nil {
+		t.Fatalf("unexpected error creating the HAR recorder: %s", err)
+	}
+	tests := []struct {
+		name     string
+		r        Requestor
+		ep       api.Endpoint
+		expected int64
+	}{
+		{name: "NotRetained", ep: ep},
+		{name: "Validated", r: Requestor{FirstFailure: firstFailure}, ep: api.Endpoint{SuccessJSONPath: "$.ok"},
+			expected: maxSuccessJSONBodySize},
+		{name: "HAR", r: Requestor{HAR: har}, ep: ep, expected: harBodyLimit},
+		{name: "FirstFailure", r: Requestor{FirstFailure: firstFailure}, ep: ep, expected: replayBodyLimit},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if limit := tc.r.retainedBodyLimit(tc.ep); limit != tc.expected {
+				t.Errorf("expected %d bytes to be retained, got %d", tc.expected, limit)
+			}
+		})
+	}
+
+	firstFailure.record(Response{Endpoint: ep, HTTPStatus: http.StatusInternalServerError}, nil)
+	if limit := (Requestor{FirstFailure: firstFailure}).retainedBodyLimit(ep); limit != 0 {
+		t.Errorf("expected bodies not to be retained once the first failure was recorded, got %d", limit)
+	}
+}
@@ -5,31 +5,157 @@
 package internal
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
+	"net/url"
+	"os"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/youngkin/heyyall/api"
+	"github.com/youngkin/heyyall/internal/stats"
 )
 
 // Response contains information describing the results
 // of a request to a specific endpoint
 type Response struct {
-	HTTPStatus           int
-	Endpoint             api.Endpoint
-	Header               http.Header
+	HTTPStatus int
+	Endpoint   api.Endpoint
+	Header     http.Header
+	// RecordedHeaders are the response headers configured to be recorded in the
+	// results log, see Requestor.RecordResponseHeaders
+	RecordedHeaders      http.Header
 	RequestDuration      time.Duration
 	DNSLookupDuration    time.Duration
 	TCPConnDuration      time.Duration
 	RoundTripDuration    time.Duration
 	TLSHandshakeDuration time.Duration
+	// TTFB, the time to first byte, is how long it took to receive the response's
+	// headers. RequestDuration, which includes reading the body, is the time to last
+	// byte. It's 0 if it wasn't measured.
+	TTFB time.Duration
+	// ServerProcessingDuration is the time from the last byte of the request being
+	// written to the first byte of the response, a proxy for the server's processing
+	// time that excludes uploading the request body and downloading the response
+	// body. It's 0 if it wasn't measured, e.g., for HTTP/3 requests.
+	ServerProcessingDuration time.Duration
+	// RequestWriteDuration is the time from the request's connection being obtained
+	// to the last byte of its body being written, the upload phase of the request.
+	// It's 0 if it wasn't measured, e.g., for HTTP/3 requests.
+	RequestWriteDuration time.Duration
+	// BytesUploaded is the number of bytes of the request body the transport wrote
+	BytesUploaded int64
+	// UploadBytesPerSec is the rate BytesUploaded were written at over the
+	// RequestWriteDuration, 0 if the request didn't have a body or its write wasn't
+	// measured
+	UploadBytesPerSec float64
+	// HeaderSizes, if the Requestor's HeaderStats is set, is the number and size of
+	// the headers of the request and its response
+	HeaderSizes *headerSizes
+	// ConnSetupDuration is how long it took to set up the request's connection, TCP
+	// and TLS. It's 0 if the request reused a connection.
+	ConnSetupDuration time.Duration
+	// AbandonedSlow indicates the request was cancelled by the client because it
+	// exceeded the configured soft deadline
+	AbandonedSlow bool
+	// BytesSent is the size of the request body
+	BytesSent int64
+	// ServerClosedConn indicates the server closed the connection after the
+	// response, e.g., by returning 'Connection: close', so it can't be reused
+	ServerClosedConn bool
+	// Retries is the number of times the request was retried before this,
+	// its final, attempt
+	Retries int
+	// Proto is the protocol negotiated with the server, e.g., HTTP/1.1 or HTTP/2.0
+	Proto string
+	// RqstProto is the protocol version the request was sent with, if the endpoint
+	// has a ProtocolVersion
+	RqstProto string
+	// Canary is the build, api.CanaryA or api.CanaryB, the request was sent to if
+	// the run is a canary run, see api.CanaryBaseURLs
+	Canary string
+	// TLSVersion and CipherSuite are the TLS version and cipher suite negotiated for
+	// the request's connection, if it used TLS
+	TLSVersion  string
+	CipherSuite string
+	// SSEStream, if set, summarizes a Server-Sent Events stream, see
+	// api.EndpointModeSSE
+	SSEStream *SSEStream
+	// OptimisticUpdate, if set, describes the attempts of an update, see
+	// api.OptimisticUpdate
+	OptimisticUpdate *OptimisticUpdateResult
+	// Rqst is the request as it was sent, recorded in the results log for failed
+	// requests so they can be replayed
+	Rqst *sentRqst
+	// ConnReused indicates the request was sent on a previously established
+	// connection, TCP or QUIC, rather than a new one
+	ConnReused bool
+	// WarmConn indicates the request was sent on one of the connections opened by
+	// the Requestor's WarmPool before the run started
+	WarmConn bool
+	// QueueWait is how long the request waited for its endpoint's lock group
+	// before being sent. It isn't included in RequestDuration.
+	QueueWait time.Duration
+	// PoolWait is how long the request waited for a connection because its
+	// client's MaxConnsPerHost connections to the host were all in use. It's 0 if
+	// the client doesn't limit them or the request didn't wait. It's included in
+	// RequestDuration.
+	PoolWait time.Duration
+	// BytesReceived is the number of response body bytes received, including
+	// those received before a failure, e.g., in a truncated response
+	BytesReceived int64
+	// BodyLimited indicates the response body was larger than the configured
+	// maximum so only BytesReceived bytes of it were read
+	BodyLimited bool
+	// BodySHA256 is the hex encoded SHA-256 digest of the response body. It's only
+	// calculated for endpoints with an ExpectedSHA256.
+	BodySHA256 string
+	// ContentType is the media type of the response's Content-Type header, without
+	// its parameters, api.GroupByHeaderNone if it didn't have one, or
+	// api.ContentTypeInvalid if it couldn't be parsed
+	ContentType string
+	// RotatedHeaders are the values of the endpoint's RotatingHeaders the request was
+	// sent with, keyed by header name
+	RotatedHeaders map[string]string
+	// Outcome describes the outcome, e.g., 'status 200', of a request to a negative
+	// test endpoint that didn't have the endpoint's ExpectedOutcome
+	Outcome string
+	// RedirectChain are the URLs of the requests, the original request first, that
+	// were redirected to get the response. It's nil if the request wasn't redirected.
+	RedirectChain []string
+	// TraceID is the request's trace ID, see Requestor.TraceIDHeader
+	TraceID string
+	// RequestIDNotEchoed indicates the response didn't echo back the request's ID,
+	// see Requestor.RequestIDHeader
+	RequestIDNotEchoed bool
+	// Phase is the name of the phase, if any, the request was made during
+	Phase string
+	// Host is the host the request was sent to
+	Host string
+	// RemoteIP is the IP address of the connection the request was sent on
+	RemoteIP string
+	// KeepAliveProbe, if set, is the outcome of a keep-alive probe, see
+	// api.KeepAliveProbe. Probes are only reported in the endpoint's
+	// KeepAliveProbeResults.
+	KeepAliveProbe *KeepAliveOutcome
+	// Completed is when the response was received by the ResponseHandler
+	Completed time.Time
+	// ErrCategory, if set, indicates the request failed and classifies the failure.
+	// It's one of the api.ErrCategory... constants.
+	ErrCategory string
+	// Err is the error that caused the request to fail
+	Err error
 }
 
+// maxMalformedURLSamples is the number of malformed URLs reported per endpoint
+const maxMalformedURLSamples = 5
+
 // ResponseHandler is responsible for accepting, summarizing, and reporting
 // on the overall load test results.
 type ResponseHandler struct {
@@ -39,171 +165,927 @@ type ResponseHandler struct {
 	DoneC      chan interface{}
 	NumRqsts   int
 	NormFactor int
+	// UniqueInts, if set, are the counters backing the 'uniqueInt' template function.
+	// Their usage is reported in the RunSummary.
+	UniqueInts *UniqueIntCounters
+	// EarlyFail, if set, is the EarlyFailTracker shared by the requestors. Endpoints
+	// that failed early are reported in the EndpointDetails and RunSummary.Warnings.
+	EarlyFail *EarlyFailTracker
+	// CircuitBreakers, if set, are the CircuitBreakers shared by the requestors. How
+	// often, and for how long, each endpoint's circuit was open is reported in its
+	// EndpointDetails and the RunSummary.Warnings.
+	CircuitBreakers *CircuitBreakers
+	// FirstFailure, if set, is the FirstFailureTracker shared by the requestors. The
+	// failure that ended the run, if any, is reported in the RunSummary.
+	FirstFailure *FirstFailureTracker
+	// Budget, if set, is the BudgetTracker shared by the requestors. The limit that
+	// stopped the run, if any, is reported in the RunSummary.
+	Budget *BudgetTracker
+	// Canary, if set, is the canary run's base URLs. The results of its two builds
+	// are compared in the RunResults' Canary.
+	Canary *api.CanaryBaseURLs
+	// OutlierPolicy, if set, is used to report request stats excluding outlier
+	// latencies in addition to the raw stats
+	OutlierPolicy *api.OutlierPolicy
+	// ExpectedStatusDist, if specified, are the bounds on the response status
+	// distribution that must be met for the run to succeed
+	ExpectedStatusDist []api.StatusExpectation
+	// MaxP99, if greater than 0, is the highest run-wide P99 latency for the run to
+	// succeed
+	MaxP99 time.Duration
+	// ApdexTarget, if greater than 0, is the target latency the Apdex scores of the
+	// run and each endpoint are computed for, see api.LoadTestConfig.ApdexTarget
+	ApdexTarget time.Duration
+	// SLOs, if set, are the latency objectives the run's successful requests are
+	// checked against, see api.LoadTestConfig.SLOs
+	SLOs []api.SLORule
+	// SummaryKey is how responses are grouped into endpoints, one of the
+	// api.SummaryKey... constants. api.SummaryKeyFull is used if it isn't set.
+	SummaryKey string
+	// URLPathTemplates are the path templates, e.g., '/users/{id}', responses are
+	// grouped under, before applying the SummaryKey. See ValidateURLPathTemplates.
+	URLPathTemplates []string
+	// Concurrency is the configured number of concurrent requests, used to report the
+	// run's ConcurrencyEfficiency
+	Concurrency int
+	// WarmPool, if set, is shared with the Requestor. The connections it opened before
+	// the run started, and how many of the run's requests used them, are reported in
+	// the RunSummary.
+	WarmPool *WarmPool
+	// ScheduledStart, if set, is the time the run was scheduled to start. It's
+	// reported in the Meta of the results.
+	ScheduledStart time.Time
+	// EndpointFilter, if set, describes how the configured endpoints were filtered
+	// by the -only and -skip flags. It's reported in the Meta of the results.
+	EndpointFilter *api.EndpointFilter
+	// DisabledEndpoints identify the configured endpoints that were Disabled. They're
+	// reported in the RunSummary.
+	DisabledEndpoints []string
+	// SizeClasses are the request body size class boundaries used to report latency
+	// by request size. api.DefaultBodySizeClasses is used if it's empty.
+	SizeClasses []int64
+	// DisableSummary skips the aggregation and reporting of responses entirely. It's
+	// intended for users who only consume the responses returned by Responses() and
+	// want to minimize the handler's overhead.
+	DisableSummary bool
+	// WarmupDuration, if greater than 0, is how long at the start of the run the
+	// responses are discarded rather than summarized
+	WarmupDuration time.Duration
+	// DurationUnit is the unit the durations of the JSON reports, including the run's
+	// summary in the ResultsDir, are written in. They're written in nanoseconds by
+	// default.
+	DurationUnit DurationUnit
+	// TimeSeriesInterval is the length of the intervals used to report the run's
+	// throughput over time. It defaults to 1 second.
+	TimeSeriesInterval time.Duration
+	// Results is the summary of the run. It's set before DoneC is closed and is nil
+	// if DisableSummary is true.
+	Results *api.RunResults
+	// teeC receives a copy of every response, see Responses()
+	teeC chan Response
+	// teeDropped is the number of responses that couldn't be sent to teeC. It's only
+	// accessed atomically.
+	teeDropped int64
+	// errMsgs counts the error messages of failed requests
+	errMsgs errMsgCounter
+	// ResultsLog, if set, records the individual responses
+	ResultsLog *ResultsLog
+	// Phases, if set, records the phases of the run. Each phase is summarized
+	// separately in addition to the run as a whole.
+	Phases *PhaseTracker
+	// AdaptiveConcurrency, if set, is the run's AdaptiveConcurrency. If it reduced the
+	// concurrency the run before and after it did is summarized separately.
+	AdaptiveConcurrency *AdaptiveConcurrency
+	// CacheHitHeaders are the response headers identifying responses served from a
+	// cache. api.DefaultCacheHitHeaders is used if it's empty.
+	CacheHitHeaders []api.CacheHitHeader
+	// InstanceHeader, if set, is the response header identifying the server instance
+	// that served the response
+	InstanceHeader string
+	// RollingSummaryInterval, if greater than 0, is how often a summary of the run is
+	// written while the run is in progress. Rolling summaries aren't written for the
+	// HTML OutputType.
+	RollingSummaryInterval time.Duration
+	// RollingSummaryMode is either api.RollingSummaryCumulative, the default, or
+	// api.RollingSummaryInterval
+	RollingSummaryMode string
+	// Output is where the reports are written, os.Stdout if it isn't set
+	Output io.Writer
+	// EchoConfig, if set, is the run's resolved config. It's included, with its
+	// secrets redacted, in the final RunResults' Meta so the results describe how
+	// they were produced.
+	EchoConfig *api.LoadTestConfig
+	// ResultsDir, if set, is the directory the run's summary, as JSON, and its HTML
+	// report are also written to, whatever the OutputType. See NewResultsDir.
+	ResultsDir string
+	// GCPercent and GCBallastBytes, if set, are the GOGC and the size of the heap
+	// ballast heyyall was run with. They're reported in the run's GeneratorStats.
+	GCPercent      int
+	GCBallastBytes int64
+	// WorkerTime, if set, is shared with the Requestor. Where its workers spent their
+	// time is reported in the run's GeneratorStats.
+	WorkerTime *WorkerTimeAccounting
+	// Backpressure, if set, is shared with the Requestor. How long its workers were
+	// blocked sending responses to ResponseC is reported in the RunSummary.
+	Backpressure *BackpressureTracker
+	// ResponseHook, if set, is shared with the Requestor. Its invocations are
+	// reported in the RunSummary.
+	ResponseHook *ResponseHookRunner
+	// Crawlers, if set, is shared with the Requestor. The URLs each endpoint's Crawl
+	// discovered are reported in its EndpointDetail.
+	Crawlers *Crawlers
+	// ClientCaches, if set, is shared with the Requestor. How each endpoint's
+	// requests were served by the workers' caches is reported in its EndpointDetail.
+	ClientCaches *ClientCaches
+	// Dashboard, if set, is redrawn every second while the run is in progress.
+	// Rolling summaries aren't written while it's shown.
+	Dashboard *Dashboard
+	// aggregate, if set, also accumulates the latencies, rates, and statuses of the
+	// responses, e.g., those of each of a suite's scenarios
+	aggregate *stats.Accumulator
+	// dnsChanges detects changes in the IPs of the hosts the requests were sent to
+	dnsChanges dnsChangeTracker
+	// start is when the ResponseHandler started accepting responses
+	start time.Time
 	// histogram contains a count of observations that are <= to the value of the key.
 	// The key is a number that represents response duration.
 	histogram map[float64]int
 }
 
+// Responses returns a channel that receives a copy of every response, concurrently
+// with the built-in summary. It must be called before Start. The channel has a buffer
+// of 'bufSize' responses. If the consumer falls behind and the buffer is full the
+// response is dropped from the channel, never blocking the requestors, and counted
+// in DroppedResponses(). The built-in summary always includes every response. The
+// channel is closed when the run ends, before DoneC is closed.
+func (rh *ResponseHandler) Responses(bufSize int) <-chan Response {
+	if rh.teeC == nil {
+		rh.teeC = make(chan Response, bufSize)
+	}
+	return rh.teeC
+}
+
+// DroppedResponses returns the number of responses that couldn't be sent to the
+// channel returned by Responses() because its buffer was full
+func (rh *ResponseHandler) DroppedResponses() int64 {
+	return atomic.LoadInt64(&rh.teeDropped)
+}
+
 // Start begins the process of accepting responses. It expects to be run as a goroutine.
 func (rh *ResponseHandler) Start() {
 	log.Debug().Msg("ResponseHandler starting")
 
-	epRunSummary := make(map[string]*api.EndpointDetail)
-	runSummary := api.RunSummary{RqstStats: api.RqstStats{MaxRqstDurationNanos: time.Duration(-1), MinRqstDurationNanos: time.Duration(math.MaxInt64)}}
-	runResults := api.RunResults{RunSummary: runSummary}
-	runResults.EndpointSummary = make(map[string]map[string]int)
-
 	start := time.Now()
-	var totalRunTime time.Duration
+	rh.start = start
 	responses := make([]Response, 0, 10)
+	live := rh.newLiveStats(start)
+
+	var rollingC, dashboardC <-chan time.Time
+	if rh.RollingSummaryInterval > 0 && !rh.DisableSummary && rh.OutputType != HTML && rh.Dashboard == nil {
+		ticker := time.NewTicker(rh.RollingSummaryInterval)
+		defer ticker.Stop()
+		rollingC = ticker.C
+	}
+	if rh.Dashboard != nil && !rh.DisableSummary {
+		ticker := time.NewTicker(dashboardInterval)
+		defer ticker.Stop()
+		dashboardC = ticker.C
+	}
+
+	lag := newHandlerLagTracker(cap(rh.ResponseC))
+	gc := newGCTracker()
+	depthTicker := time.NewTicker(handlerLagDepthInterval)
+	defer depthTicker.Stop()
 
 	for {
 		select {
+		case now := <-depthTicker.C:
+			lag.sampleDepth(len(rh.ResponseC), now)
+			gc.sample()
+		case <-rollingC:
+			now := time.Now()
+			if live.rollingInterval {
+				summary, from := live.startRollingInterval(now)
+				rh.printRollingSummary(summary, from, now)
+			} else {
+				rh.printRollingSummary(live.accumulator().Snapshot(), start, now)
+			}
+		case now := <-dashboardC:
+			rh.Dashboard.update(now.Sub(rh.start), live.received, rh.accumulatedResults(live.dashboardStats(now)))
 		case resp, ok := <-rh.ResponseC:
 			if !ok {
 				defer close(rh.DoneC)
-				log.Debug().Msg("ResponseHandler: Summarizing results and exiting")
-
-				for _, r := range responses {
-					rh.accumulateResponseStats(r, &totalRunTime, &runResults, epRunSummary)
-					runResults.RunSummary.DNSLookupNanos = append(runResults.RunSummary.DNSLookupNanos, r.DNSLookupDuration)
-					runResults.RunSummary.TCPConnSetupNanos = append(runResults.RunSummary.TCPConnSetupNanos, r.TCPConnDuration)
-					runResults.RunSummary.RqstRoundTripNanos = append(runResults.RunSummary.RqstRoundTripNanos, r.RoundTripDuration)
-					runResults.RunSummary.TLSHandshakeNanos = append(runResults.RunSummary.TLSHandshakeNanos, r.TLSHandshakeDuration)
+				if rh.Dashboard != nil {
+					rh.Dashboard.Close()
+				}
+				if warning := lag.warning(); warning != "" {
+					log.Warn().Msg(warning)
+				}
+				if rh.teeC != nil {
+					close(rh.teeC)
 				}
+				if rh.ResultsLog != nil {
+					if err := rh.ResultsLog.Flush(); err != nil {
+						log.Error().Err(err).Msg("error writing the results log")
+					}
+				}
+				if rh.DisableSummary {
+					log.Debug().Msg("ResponseHandler: summary disabled, exiting")
+					return
+				}
+				log.Debug().Msg("ResponseHandler: Summarizing results and exiting")
 
-				err := rh.finalizeResponseStats(start, &totalRunTime, &runResults, epRunSummary)
+				summarizeStart := time.Now()
+				runResults, err := rh.summarizeLive(live, responses, start)
 				if err != nil {
 					log.Error().Err(err)
 					return
 				}
+				runResults.GeneratorStats = &api.GeneratorStats{HandlerLag: lag.lag(time.Since(summarizeStart)), GC: gc.gcStats()}
+				runResults.GeneratorStats.GC.GCPercent, runResults.GeneratorStats.GC.BallastBytes = rh.GCPercent, rh.GCBallastBytes
+				// The workers have all finished, and been accounted for, by the time
+				// ResponseC is closed
+				runResults.GeneratorStats.WorkerTime = rh.WorkerTime.summary()
+				runResults.RunSummary.ResponseChannelBackpressure = rh.Backpressure.summary()
+				runResults.RunSummary.ResponseHookStats = rh.ResponseHook.summary()
+				if warning := responseHookWarning(runResults.RunSummary.ResponseHookStats); warning != "" {
+					runResults.RunSummary.Warnings = append(runResults.RunSummary.Warnings, warning)
+				}
+				if warning := lag.warning(); warning != "" {
+					runResults.RunSummary.Warnings = append(runResults.RunSummary.Warnings, warning)
+				}
+				if warning := gcWarning(runResults.GeneratorStats.GC, calcPercentiles(99, runResults.RunSummary.RqstStats.TimingResultsNanos)); warning != "" {
+					log.Warn().Msg(warning)
+					runResults.RunSummary.Warnings = append(runResults.RunSummary.Warnings, warning)
+				}
+				if rh.EchoConfig != nil {
+					config := redactConfig(*rh.EchoConfig)
+					runResults.Meta.Config = &config
+				}
+				rh.Results = &runResults
+				if rh.ResultsDir != "" {
+					if err := rh.writeResultsDir(runResults); err != nil {
+						log.Error().Err(err).Msgf("unable to write the run's results to %s", rh.ResultsDir)
+					}
+				}
+				out := rh.output()
+
+				if rh.OutputType == HTML {
+					rh.generateHistogram(&runResults)
+					if err := writeHTMLReport(out, runResults, rh.histogram); err != nil {
+						log.Error().Err(err).Msg("error generating HTML report")
+					}
+					return
+				}
+
+				if line, ok := summaryLine(rh.OutputType, runResults.RunSummary); ok {
+					fmt.Fprintln(out, line)
+					return
+				}
 
 				if rh.OutputType == Text {
-					fmt.Println("")
-					printRunSummary(runResults.RunSummary)
+					fmt.Fprintln(out, "")
+					printRunSummary(out, runResults.RunSummary)
 
-					fmt.Println("")
-					printRqstLatency(runResults.RunSummary.RqstStats)
+					fmt.Fprintln(out, "")
+					printRqstLatency(out, runResults.RunSummary.RqstStats)
+					if runResults.RunSummary.OutlierExcluded != nil {
+						printOutlierExcluded(out, *runResults.RunSummary.OutlierExcluded)
+					}
 
 					min, max := rh.generateHistogram(&runResults)
-					fmt.Printf("\nRequest Latency Histogram (secs):\n")
-					fmt.Println(rh.generateHistogramString(min, max))
+					fmt.Fprintf(out, "\nRequest Latency Histogram (secs):\n")
+					fmt.Fprintln(out, rh.generateHistogramString(min, max))
+
+					fmt.Fprintln(out, "")
+					printEndpointDetails(out, runResults.EndpointDetails)
+
+					if runResults.Canary != nil {
+						fmt.Fprintln(out, "")
+						printCanaryComparison(out, *runResults.Canary)
+					}
 
-					fmt.Println("")
-					printEndpointDetails(runResults.EndpointDetails)
+					fmt.Fprintln(out, "")
+					printNetworkDetails(out, runResults.RunSummary)
 
-					fmt.Println("")
-					printNetworkDetails(runResults.RunSummary)
+					for _, phase := range runResults.Phases {
+						fmt.Fprintf(out, "\nPhase %s (%s - %s):\n", phase.Name, phase.StartOffsetNanos.Round(time.Second),
+							phase.EndOffsetNanos.Round(time.Second))
+						printRunSummary(out, phase.RunSummary)
+						printRqstLatency(out, phase.RunSummary.RqstStats)
+					}
+					if runResults.Degradation != nil {
+						printDegradation(out, *runResults.Degradation)
+					}
 
 					return
 				}
 
-				rsjson, err := json.MarshalIndent(runResults, "    ", "  ")
+				rsjson, err := rh.DurationUnit.marshalIndent(runResults, "    ", "  ")
 				if err != nil {
 					log.Error().Err(err).Msgf("error marshaling RunSummary into string: %+v.\n", runResults)
 					return
 				}
-				fmt.Printf("%s\n", string(rsjson[2:len(rsjson)-1]))
+				fmt.Fprintf(out, "%s\n", string(rsjson[2:len(rsjson)-1]))
 
 				return
 			}
 
-			responses = append(responses, resp)
+			resp.Completed = time.Now()
+			timed := lag.receive()
+			if rh.ResultsLog != nil {
+				if err := rh.ResultsLog.Write(resp); err != nil {
+					log.Error().Err(err).Msg("error writing the results log, no more responses will be recorded")
+					rh.ResultsLog = nil
+				}
+			}
+			if rh.teeC != nil {
+				select {
+				case rh.teeC <- resp:
+				default:
+					atomic.AddInt64(&rh.teeDropped, 1)
+				}
+			}
+			if !rh.DisableSummary {
+				responses = append(responses, resp)
+				live.add(resp)
+			}
+			if timed {
+				lag.recordProcessing(time.Since(resp.Completed))
+			}
 			// If rh.NumRqsts > 0 then the load test is being limited by total number of requests sent, not time.
 			// In this case each received request represents progress that must be recorded.
-			if rh.NumRqsts > 0 {
+			if rh.NumRqsts > 0 && resp.KeepAliveProbe == nil && rh.ProgressC != nil {
 				rh.ProgressC <- struct{}{}
 			}
 		}
 	}
 }
 
-func (rh *ResponseHandler) finalizeResponseStats(start time.Time, totalRunTime *time.Duration,
-	runResults *api.RunResults, epRunSummary map[string]*api.EndpointDetail) error {
+// output returns the writer the reports are written to
+func (rh *ResponseHandler) output() io.Writer {
+	if rh.Output == nil {
+		return os.Stdout
+	}
+	return rh.Output
+}
+
+// summarize returns the results of the run summarizing 'responses'. 'start' is the
+// start of the period covered by 'responses'.
+func (rh *ResponseHandler) summarize(responses []Response, start time.Time) (api.RunResults, error) {
+	live := rh.newLiveStats(start)
+	for _, r := range responses {
+		live.add(r)
+	}
+	return rh.summarizeLive(live, responses, start)
+}
+
+// summarizeLive returns the results of the run summarizing 'responses', whose
+// latencies, rates, and statuses were accumulated by 'live' as they were received.
+// 'start' is the start of the period covered by 'responses'.
+func (rh *ResponseHandler) summarizeLive(live *liveStats, responses []Response, start time.Time) (api.RunResults, error) {
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	var runResults api.RunResults
+	responses, _, runResults.RunSummary.WarmupDiscarded = rh.discardWarmup(responses, start)
+
+	keyer := live.keyer
+	templated := make(templatedURLs)
+	for _, r := range responses {
+		rawURL := r.Endpoint.URL
+		r.Endpoint.URL = keyer.key(rawURL)
+		if keyer.templated(rawURL) {
+			templated.add(r.Endpoint.URL, rawURL)
+		}
+		if r.KeepAliveProbe != nil {
+			accumulateKeepAliveProbe(r, getEPDetail(r.Endpoint.URL, epRunSummary))
+			continue
+		}
+		if r.SSEStream != nil && r.SSEStream.SummaryOnly {
+			continue
+		}
+		rh.accumulateResponseStats(r, nil, &runResults, epRunSummary)
+		if !r.Completed.IsZero() {
+			interval, intervalLen := rh.timeSeriesInterval(r.Completed)
+			accumulateStatusTimeSeries(r, interval, intervalLen, getEPDetail(r.Endpoint.URL, epRunSummary))
+		}
+		if r.AbandonedSlow || r.ErrCategory != "" {
+			continue
+		}
+		runResults.RunSummary.DNSLookupNanos = append(runResults.RunSummary.DNSLookupNanos, r.DNSLookupDuration)
+		runResults.RunSummary.TCPConnSetupNanos = append(runResults.RunSummary.TCPConnSetupNanos, r.TCPConnDuration)
+		runResults.RunSummary.RqstRoundTripNanos = append(runResults.RunSummary.RqstRoundTripNanos, r.RoundTripDuration)
+		runResults.RunSummary.TLSHandshakeNanos = append(runResults.RunSummary.TLSHandshakeNanos, r.TLSHandshakeDuration)
+		if r.ConnSetupDuration > 0 {
+			runResults.RunSummary.ConnSetupNanos = append(runResults.RunSummary.ConnSetupNanos, r.ConnSetupDuration)
+		}
+	}
+
+	err := rh.finalizeResponseStats(live.accumulator(), &runResults, epRunSummary)
+	if err != nil {
+		return runResults, err
+	}
+	templated.finish(runResults.EndpointDetails)
+	checkSLOs(rh.SLOs, responses, rh.start, time.Now(), &runResults.RunSummary)
+	attachExemplars(responses, keyer, &runResults)
+	summarizeSSE(responses, keyer, runResults.EndpointDetails)
+	summarizeOptimisticUpdates(responses, keyer, runResults.EndpointDetails)
+	if rh.Canary != nil {
+		runResults.Canary = compareCanary(*rh.Canary, responses, keyer)
+	}
+	if rh.Phases != nil {
+		runResults.Phases = rh.summarizePhases(live, &runResults)
+	}
+	if rh.AdaptiveConcurrency != nil {
+		runResults.Degradation = rh.summarizeDegradation(live)
+	}
+	return runResults, nil
+}
+
+// summarizePhases returns a summary of each phase recorded by rh.Phases from the stats
+// 'live' accumulated for it. The interval in which each phase started is marked in
+// runResults' time series.
+func (rh *ResponseHandler) summarizePhases(live *liveStats, runResults *api.RunResults) []api.PhaseSummary {
+	var summaries []api.PhaseSummary
+	for _, timing := range rh.Phases.timings() {
+		phaseSummary := rh.summarizeSpan(timing.name, live.span(live.phases[timing.name]), timing.start, timing.end)
+		summaries = append(summaries, phaseSummary)
+
+		if interval := runResults.RunSummary.TimeSeriesIntervalNanos; interval > 0 {
+			i := int(phaseSummary.StartOffsetNanos / interval)
+			if i < len(runResults.RunSummary.TimeSeries) {
+				runResults.RunSummary.TimeSeries[i].PhaseStarted = timing.name
+			}
+		}
+	}
+	return summaries
+}
+
+// summarizeDegradation returns a description of the reductions of the concurrency
+// made by rh.AdaptiveConcurrency, summarizing the responses 'live' accumulated before
+// and after the first of them separately, or nil if it wasn't reduced
+func (rh *ResponseHandler) summarizeDegradation(live *liveStats) *api.ConcurrencyDegradation {
+	adjustments, degradedAt := rh.AdaptiveConcurrency.degradation(rh.start)
+	if len(adjustments) == 0 {
+		return nil
+	}
+	return &api.ConcurrencyDegradation{
+		Adjustments: adjustments,
+		Clean:       rh.summarizeSpan("clean", live.span(live.clean), rh.start, degradedAt),
+		Degraded:    rh.summarizeSpan("degraded", live.span(live.degraded), degradedAt, time.Time{}),
+	}
+}
+
+// summarizeSpan summarizes the part of the run called 'name', between 'start' and
+// 'end', whose responses have the stats 'summary'. A zero 'end' is now.
+func (rh *ResponseHandler) summarizeSpan(name string, summary stats.Summary, start, end time.Time) api.PhaseSummary {
+	if end.IsZero() {
+		end = time.Now()
+	}
+	results := rh.accumulatedResults(summary)
+	span := api.PhaseSummary{
+		Name:             name,
+		StartOffsetNanos: start.Sub(rh.start),
+		EndOffsetNanos:   end.Sub(rh.start),
+		RunSummary:       results.RunSummary,
+		EndpointDetails:  results.EndpointDetails,
+	}
+	span.RunSummary.RunDurationNanos = end.Sub(start)
+	span.RunSummary.RqstRatePerSec = 0
+	if span.RunSummary.RunDurationNanos > 0 {
+		span.RunSummary.RqstRatePerSec = float64(span.RunSummary.RqstStats.TotalRqsts) /
+			span.RunSummary.RunDurationNanos.Seconds()
+	}
+	return span
+}
+
+// printRollingSummary writes a summary of the responses received between 'from' and
+// 'to', which have the stats 'summary', while the run is in progress
+func (rh *ResponseHandler) printRollingSummary(summary stats.Summary, from, to time.Time) {
+	runResults := rh.accumulatedResults(summary)
+
+	mode := rh.RollingSummaryMode
+	if mode == "" {
+		mode = api.RollingSummaryCumulative
+	}
+	rolling := api.RollingSummary{
+		Mode:       mode,
+		FromNanos:  from.Sub(rh.start),
+		ToNanos:    to.Sub(rh.start),
+		RunSummary: runResults.RunSummary,
+	}
+	out := rh.output()
+
+	if line, ok := summaryLine(rh.OutputType, rolling.RunSummary); ok {
+		fmt.Fprintf(out, "rolling %s\n", line)
+		return
+	}
+
+	if rh.OutputType == Text {
+		fmt.Fprintf(out, "\nRolling Summary (%s, %s - %s):\n", rolling.Mode, rolling.FromNanos.Round(time.Second),
+			rolling.ToNanos.Round(time.Second))
+		printRunSummary(out, rolling.RunSummary)
+		printRqstLatency(out, rolling.RunSummary.RqstStats)
+		return
+	}
+
+	rsjson, err := rh.DurationUnit.marshal(struct{ RollingSummary api.RollingSummary }{rolling})
+	if err != nil {
+		log.Error().Err(err).Msg("error marshaling rolling summary")
+		return
+	}
+	fmt.Fprintf(out, "%s\n", rsjson)
+}
+
+// statsResponse returns 'resp' as it's accumulated by a stats.Accumulator
+func statsResponse(resp Response) stats.Response {
+	return stats.Response{
+		URL:           resp.Endpoint.URL,
+		Method:        resp.Endpoint.Method,
+		Status:        resp.HTTPStatus,
+		Duration:      resp.RequestDuration,
+		BytesReceived: resp.BytesReceived,
+		ErrCategory:   resp.ErrCategory,
+		Abandoned:     resp.AbandonedSlow,
+		Completed:     resp.Completed,
+	}
+}
+
+// applyStatsSummary records the request latencies, rates, and status distributions
+// of 'summary' in 'runResults' and the endpoint details 'epRunSummary'
+func applyStatsSummary(summary stats.Summary, runResults *api.RunResults, epRunSummary map[string]*api.EndpointDetail) {
+	runResults.RunSummary.RunDurationNanos = summary.Duration
+	runResults.RunSummary.RqstStats = summary.RqstStats
+	runResults.RunSummary.RqstRatePerSec = summary.RqstRatePerSec
+	runResults.RunSummary.ErrorCategories = summary.ErrorCategories
+	runResults.RunSummary.TimeSeriesIntervalNanos = summary.TimeSeriesIntervalNanos
+	runResults.RunSummary.TimeSeries = summary.TimeSeries
+
+	runResults.EndpointSummary = make(map[string]map[string]int)
+	for url, ep := range summary.Endpoints {
+		epDetail := getEPDetail(url, epRunSummary)
+		for method, methodStats := range ep.Methods {
+			epDetail.HTTPMethodRqstStats[method] = methodStats
+			if runResults.EndpointSummary[url] == nil {
+				runResults.EndpointSummary[url] = make(map[string]int)
+			}
+			runResults.EndpointSummary[url][method] = int(methodStats.TotalRqsts)
+		}
+		epDetail.HTTPMethodStatusDist = ep.StatusDist
+		epDetail.NotModifiedCount = ep.NotModifiedCount
+		epDetail.ErrorCategories = ep.ErrorCategories
+		epDetail.MinBytes, epDetail.MaxBytes = ep.MinBytes, ep.MaxBytes
+		epDetail.TotalBytes, epDetail.AvgBytes = ep.TotalBytes, ep.AvgBytes
+	}
+}
+
+func (rh *ResponseHandler) finalizeResponseStats(acc *stats.Accumulator, runResults *api.RunResults,
+	epRunSummary map[string]*api.EndpointDetail) error {
+	summary := acc.Snapshot()
+	applyStatsSummary(summary, runResults, epRunSummary)
+	concurrencyEfficiency(rh.Concurrency, summary.InFlightNanos, &runResults.RunSummary)
+	if warning := effectiveParallelism(rh.Concurrency, &runResults.RunSummary, epRunSummary); warning != "" {
+		runResults.RunSummary.Warnings = append(runResults.RunSummary.Warnings, warning)
+	}
+
+	runResults.RunSummary.ConnSetupDist = connSetupDist(runResults.RunSummary.ConnSetupNanos)
+	rh.WarmPool.summarize(&runResults.RunSummary)
 
-	runResults.RunSummary.RunDurationNanos = time.Since(start)
-	runResults.RunSummary.RqstStats.AvgRqstDurationNanos = time.Duration(0)
 	if runResults.RunSummary.RqstStats.TotalRqsts > 0 {
-		runResults.RunSummary.RqstStats.AvgRqstDurationNanos = *totalRunTime / time.Duration(runResults.RunSummary.RqstStats.TotalRqsts)
+		runResults.RunSummary.ServerClosedConnectionRatio = float64(runResults.RunSummary.ServerClosedConnections) /
+			float64(runResults.RunSummary.RqstStats.TotalRqsts)
 	}
 
-	runResults.RunSummary.RqstRatePerSec = (float64(runResults.RunSummary.RqstStats.TotalRqsts) / float64(runResults.RunSummary.RunDurationNanos)) * float64(time.Second)
+	runResults.Meta.OutlierPolicy = rh.OutlierPolicy
+	runResults.Meta.SummaryKey = rh.summaryKeyMode()
+	runResults.Meta.EndpointFilter = rh.EndpointFilter
+	runResults.Meta.StartedAt = rh.start
+	if !rh.ScheduledStart.IsZero() {
+		scheduledStart := rh.ScheduledStart
+		runResults.Meta.ScheduledStart = &scheduledStart
+	}
+	if rh.OutlierPolicy != nil {
+		runResults.RunSummary.OutlierExcluded = excludeOutliers(*rh.OutlierPolicy, runResults.RunSummary.RqstStats)
+	}
 
 	runResults.EndpointDetails = epRunSummary
+	runResults.RunSummary.DisabledEndpoints = rh.DisabledEndpoints
+
+	if rh.UniqueInts != nil {
+		runResults.RunSummary.UniqueIntRanges = rh.UniqueInts.Usage()
+		if failure := rh.UniqueInts.Failure(); failure != "" {
+			runResults.RunSummary.UniqueIntFailed, runResults.RunSummary.UniqueIntFailure = true, failure
+		}
+	}
+
+	runResults.RunSummary.TopErrorMessages = rh.errMsgs.top(numTopErrMsgs)
+	if len(runResults.RunSummary.TopErrorMessages) == 0 {
+		runResults.RunSummary.TopErrorMessages = nil
+	}
+
+	keyer := rh.summaryKeyer()
+	if rh.EarlyFail != nil {
+		for _, url := range rh.EarlyFail.FailedURLs() {
+			getEPDetail(keyer.key(url), epRunSummary).EarlyFailed = true
+		}
+		runResults.RunSummary.Warnings = append(runResults.RunSummary.Warnings, rh.EarlyFail.Warnings()...)
+	}
+	for _, result := range rh.CircuitBreakers.results(time.Now()) {
+		epDetail := getEPDetail(keyer.key(result.url), epRunSummary)
+		epDetail.CircuitOpens += result.opens
+		epDetail.CircuitOpenNanos += result.open
+		runResults.RunSummary.Warnings = append(runResults.RunSummary.Warnings, result.warning())
+	}
+	for _, result := range rh.Crawlers.results() {
+		stats := result.stats
+		getEPDetail(keyer.key(result.url), epRunSummary).Crawl = &stats
+	}
+	for _, result := range rh.ClientCaches.results() {
+		epDetail := getEPDetail(keyer.key(result.url), epRunSummary)
+		if epDetail.ClientCache == nil {
+			epDetail.ClientCache = &api.ClientCacheStats{}
+		}
+		addClientCacheStats(epDetail.ClientCache, result.stats)
+		if runResults.RunSummary.ClientCache == nil {
+			runResults.RunSummary.ClientCache = &api.ClientCacheStats{}
+		}
+		addClientCacheStats(runResults.RunSummary.ClientCache, result.stats)
+	}
+	finishClientCacheStats(runResults.RunSummary.ClientCache)
+	if warning := rh.FirstFailure.warning(); warning != "" {
+		runResults.RunSummary.FirstFailure = rh.FirstFailure.Failure()
+		runResults.RunSummary.Warnings = append(runResults.RunSummary.Warnings, warning)
+	}
+	if exceeded := rh.Budget.exceeded(rh.start); exceeded != nil {
+		runResults.RunSummary.BudgetExceeded = exceeded
+		runResults.RunSummary.Warnings = append(runResults.RunSummary.Warnings, budgetWarning(exceeded))
+	}
 
+	rh.dnsChanges.finish(&runResults.RunSummary)
+	finishHeaderStats(&runResults.RunSummary)
+	finishRedirects(&runResults.RunSummary, epRunSummary)
+	runResults.RunSummary.Warnings = append(runResults.RunSummary.Warnings, contentTypeWarnings(epRunSummary)...)
+	checkStatusDist(rh.ExpectedStatusDist, &runResults.RunSummary, epRunSummary)
+	checkMaxP99(rh.MaxP99, &runResults.RunSummary)
+	finishApdex(runResults.RunSummary.Apdex)
+
+	var groupWarnings []string
 	for _, epDetail := range epRunSummary {
+		finishStatusFlaps(epDetail)
+		finishApdex(epDetail.Apdex)
+		finishClientCacheStats(epDetail.ClientCache)
+		log.Debug().Msgf("EndpointSummary: %+v", epDetail)
+
+		var epRqsts int64
 		for _, methodRqstStats := range epDetail.HTTPMethodRqstStats {
-			if methodRqstStats.TotalRqsts > 0 {
-				methodRqstStats.AvgRqstDurationNanos = (methodRqstStats.TotalRequestDurationNanos / time.Duration(methodRqstStats.TotalRqsts))
+			epRqsts += methodRqstStats.TotalRqsts
+		}
+		if epRqsts > 0 {
+			epDetail.CacheHitRatio = float64(epDetail.CacheHits) / float64(epRqsts)
+		}
+
+		sizeClasses := epDetail.LatencyBySizeClass[:0]
+		for _, classStats := range epDetail.LatencyBySizeClass {
+			if classStats.TotalRqsts == 0 {
+				continue
 			}
-			log.Debug().Msgf("EndpointSummary: %+v", epDetail)
+			classStats.AvgRqstDurationNanos = classStats.TotalRequestDurationNanos / time.Duration(classStats.TotalRqsts)
+			sizeClasses = append(sizeClasses, classStats)
 		}
+		epDetail.LatencyBySizeClass = sizeClasses
+		finishKeepAliveProbes(epDetail)
+		finishServerTiming(epDetail)
+		finishByteTiming(epDetail)
+		finishServerProcessing(epDetail)
+		finishUpload(epDetail)
+		groupWarnings = append(groupWarnings, finishGroupByHeader(epDetail)...)
+		finishRotatingHeaders(epDetail)
 	}
+	sort.Strings(groupWarnings)
+	runResults.RunSummary.Warnings = append(runResults.RunSummary.Warnings, groupWarnings...)
+	weightedEndpointP95(&runResults.RunSummary, epRunSummary)
 
 	return nil
 }
 
-func (rh *ResponseHandler) accumulateResponseStats(resp Response, totalRunTime *time.Duration,
-	runResults *api.RunResults, epRunSummary map[string]*api.EndpointDetail) {
+// getEPDetail returns the EndpointDetail for 'url', creating it if needed
+func getEPDetail(url string, epRunSummary map[string]*api.EndpointDetail) *api.EndpointDetail {
+	epDetail, ok := epRunSummary[url]
+	if !ok {
+		epDetail = &api.EndpointDetail{
+			URL:                  url,
+			HTTPMethodStatusDist: make(map[string]map[int]int),
+			HTTPMethodRqstStats:  make(map[string]*api.RqstStats),
+		}
+		epRunSummary[url] = epDetail
+	}
+	return epDetail
+}
 
-	runResults.RunSummary.RqstStats.TimingResultsNanos = append(runResults.RunSummary.RqstStats.TimingResultsNanos, resp.RequestDuration)
-	runResults.RunSummary.RqstStats.TotalRqsts++
-	runResults.RunSummary.RqstStats.TotalRequestDurationNanos += resp.RequestDuration
-	*totalRunTime = *totalRunTime + resp.RequestDuration
+// accumulateResponseStats adds 'resp' to 'acc', which accumulates its latency, rate,
+// and status, and records the rest of its details in 'runResults' and 'epRunSummary'.
+// 'acc' is nil if they were accumulated when the response was received, see liveStats.
+func (rh *ResponseHandler) accumulateResponseStats(resp Response, acc *stats.Accumulator,
+	runResults *api.RunResults, epRunSummary map[string]*api.EndpointDetail) {
+	if acc != nil {
+		acc.Add(statsResponse(resp))
+	}
+	runResults.RunSummary.Retries += int64(resp.Retries)
+	rh.accumulateApdex(resp, &runResults.RunSummary, getEPDetail(resp.Endpoint.URL, epRunSummary))
 
-	if resp.RequestDuration > runResults.RunSummary.RqstStats.MaxRqstDurationNanos {
-		runResults.RunSummary.RqstStats.MaxRqstDurationNanos = resp.RequestDuration
+	if resp.QueueWait > 0 {
+		runResults.RunSummary.TotalQueueWaitNanos += resp.QueueWait
+		getEPDetail(resp.Endpoint.URL, epRunSummary).TotalQueueWaitNanos += resp.QueueWait
 	}
-	if resp.RequestDuration < runResults.RunSummary.RqstStats.MinRqstDurationNanos {
-		runResults.RunSummary.RqstStats.MinRqstDurationNanos = resp.RequestDuration
+	accumulatePoolWait(resp, &runResults.RunSummary)
+
+	accumulateNegativeTest(resp, getEPDetail(resp.Endpoint.URL, epRunSummary))
+	accumulateDescription(resp, getEPDetail(resp.Endpoint.URL, epRunSummary))
+	accumulateGroupByHeader(resp, getEPDetail(resp.Endpoint.URL, epRunSummary))
+	accumulateRotatingHeaders(resp, getEPDetail(resp.Endpoint.URL, epRunSummary))
+	accumulateContentType(resp, getEPDetail(resp.Endpoint.URL, epRunSummary))
+	if resp.ErrCategory != "" {
+		rh.accumulateErrStats(resp, runResults, getEPDetail(resp.Endpoint.URL, epRunSummary))
+		return
 	}
 
-	var epStatusCount map[string]int
-	epStatusCount, ok := runResults.EndpointSummary[resp.Endpoint.URL]
-	if !ok {
-		runResults.EndpointSummary[resp.Endpoint.URL] = make(map[string]int)
-		epStatusCount = runResults.EndpointSummary[resp.Endpoint.URL]
+	// Requests abandoned at the soft deadline never completed, so they're only
+	// counted. Including them would understate the latency of slow requests.
+	if resp.AbandonedSlow {
+		runResults.RunSummary.AbandonedSlow++
+		getEPDetail(resp.Endpoint.URL, epRunSummary).AbandonedSlow++
+		return
 	}
-	epStatusCount[resp.Endpoint.Method]++
 
-	var epDetail *api.EndpointDetail
-	epDetail, ok = epRunSummary[resp.Endpoint.URL]
-	if !ok {
-		epDetail = &api.EndpointDetail{
-			URL:                  resp.Endpoint.URL,
-			HTTPMethodStatusDist: make(map[string]map[int]int),
-			HTTPMethodRqstStats:  make(map[string]*api.RqstStats),
+	if resp.ServerClosedConn {
+		runResults.RunSummary.ServerClosedConnections++
+	}
+	if resp.BodyLimited {
+		runResults.RunSummary.BodyLimitedResponses++
+		getEPDetail(resp.Endpoint.URL, epRunSummary).BodyLimitedResponses++
+	}
+	accumulateRedirects(resp, &runResults.RunSummary, getEPDetail(resp.Endpoint.URL, epRunSummary))
+	accumulateTLSInfo(resp, &runResults.RunSummary)
+	accumulateInstanceDist(resp, rh.InstanceHeader, &runResults.RunSummary)
+	if resp.RqstProto != "" {
+		if runResults.RunSummary.RqstProtocols == nil {
+			runResults.RunSummary.RqstProtocols = make(map[string]int64)
+		}
+		runResults.RunSummary.RqstProtocols[resp.RqstProto]++
+	}
+	if resp.Proto != "" {
+		if runResults.RunSummary.Protocols == nil {
+			runResults.RunSummary.Protocols = make(map[string]int64)
+		}
+		runResults.RunSummary.Protocols[resp.Proto]++
+		if resp.ConnReused {
+			runResults.RunSummary.ReusedConnections++
+			if resp.WarmConn {
+				runResults.RunSummary.WarmConnectionRqsts++
+			}
+		} else {
+			runResults.RunSummary.NewConnections++
 		}
-		epRunSummary[resp.Endpoint.URL] = epDetail
 	}
 
-	methodRqstStats, ok := epDetail.HTTPMethodRqstStats[resp.Endpoint.Method]
-	if !ok {
-		epDetail.HTTPMethodRqstStats[resp.Endpoint.Method] = &api.RqstStats{
-			MaxRqstDurationNanos: -1,
-			MinRqstDurationNanos: time.Duration(math.MaxInt64),
+	epDetail := getEPDetail(resp.Endpoint.URL, epRunSummary)
+	if rh.isCacheHit(resp.Header) {
+		epDetail.CacheHits++
+	}
+	if resp.RequestIDNotEchoed {
+		epDetail.RequestIDsNotEchoed++
+	}
+	accumulateServerTiming(resp, epDetail)
+	accumulateByteTiming(resp, epDetail)
+	accumulateServerProcessing(resp, epDetail)
+	accumulateUpload(resp, epDetail)
+	accumulateHeaderStats(resp, &runResults.RunSummary)
+
+	if resp.BytesSent > 0 {
+		updateRqstStats(&rh.sizeClassStats(epDetail, resp.BytesSent).RqstStats, resp.RequestDuration)
+	}
+
+	if !resp.Completed.IsZero() && resp.RemoteIP != "" {
+		interval, intervalLen := rh.timeSeriesInterval(resp.Completed)
+		rh.dnsChanges.add(resp.Host, resp.RemoteIP, interval, intervalLen, &runResults.RunSummary)
+	}
+}
+
+// isCacheHit reports whether a response with the headers 'header' was served from a
+// cache according to rh.CacheHitHeaders
+func (rh *ResponseHandler) isCacheHit(header http.Header) bool {
+	cacheHitHeaders := rh.CacheHitHeaders
+	if len(cacheHitHeaders) == 0 {
+		cacheHitHeaders = api.DefaultCacheHitHeaders
+	}
+	for _, h := range cacheHitHeaders {
+		values, ok := header[http.CanonicalHeaderKey(h.Name)]
+		if !ok {
+			continue
+		}
+		if h.Value == "" {
+			return true
+		}
+		for _, v := range values {
+			if strings.Contains(strings.ToUpper(v), strings.ToUpper(h.Value)) {
+				return true
+			}
 		}
-		methodRqstStats = epDetail.HTTPMethodRqstStats[resp.Endpoint.Method]
 	}
+	return false
+}
 
-	methodRqstStats.TotalRqsts++
-	methodRqstStats.TotalRequestDurationNanos = methodRqstStats.TotalRequestDurationNanos + resp.RequestDuration
+// accumulateErrStats records a failed request. Failed requests are only counted by
+// error category, by the stats.Accumulator, they aren't included in the latency stats.
+func (rh *ResponseHandler) accumulateErrStats(resp Response, runResults *api.RunResults, epDetail *api.EndpointDetail) {
+	if resp.Err != nil {
+		rh.errMsgs.add(normalizeErrMsg(resp.Err.Error()))
+	}
 
-	if resp.RequestDuration > methodRqstStats.MaxRqstDurationNanos {
-		methodRqstStats.MaxRqstDurationNanos = resp.RequestDuration
+	if resp.ErrCategory == api.ErrCategoryTruncatedResponse {
+		runResults.RunSummary.TruncatedResponseBytes += resp.BytesReceived
+		epDetail.TruncatedResponseBytes += resp.BytesReceived
 	}
-	if resp.RequestDuration < methodRqstStats.MinRqstDurationNanos {
-		methodRqstStats.MinRqstDurationNanos = resp.RequestDuration
+
+	var urlErr *url.Error
+	if resp.ErrCategory == api.ErrCategoryMalformedURL && len(epDetail.MalformedURLSamples) < maxMalformedURLSamples &&
+		errors.As(resp.Err, &urlErr) {
+		epDetail.MalformedURLSamples = append(epDetail.MalformedURLSamples, urlErr.URL)
 	}
-	methodRqstStats.TimingResultsNanos = append(methodRqstStats.TimingResultsNanos, resp.RequestDuration)
+	if resp.ErrCategory == api.ErrCategoryChecksumMismatch {
+		sampleChecksumMismatch(resp, epDetail)
+	}
+}
 
-	_, ok = epDetail.HTTPMethodStatusDist[resp.Endpoint.Method]
-	if !ok {
-		epDetail.HTTPMethodStatusDist[resp.Endpoint.Method] = make(map[int]int)
-		epDetail.HTTPMethodStatusDist[resp.Endpoint.Method][resp.HTTPStatus] = 0 // This is correct. It'll be incremented below
+// timeSeriesInterval returns the index of the time series interval in which
+// 'completed' falls, and the length of the intervals
+func (rh *ResponseHandler) timeSeriesInterval(completed time.Time) (int, time.Duration) {
+	interval := rh.TimeSeriesInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	i := int(completed.Sub(rh.start) / interval)
+	if i < 0 {
+		i = 0
+	}
+	return i, interval
+}
+
+// updateRqstStats adds a request of duration 'd' to 'stats'
+func updateRqstStats(stats *api.RqstStats, d time.Duration) {
+	if stats.TotalRqsts == 0 {
+		stats.MinRqstDurationNanos = d
+		stats.MaxRqstDurationNanos = d
+	}
+	stats.TotalRqsts++
+	stats.TotalRequestDurationNanos += d
+	if d > stats.MaxRqstDurationNanos {
+		stats.MaxRqstDurationNanos = d
+	}
+	if d < stats.MinRqstDurationNanos {
+		stats.MinRqstDurationNanos = d
+	}
+	stats.TimingResultsNanos = append(stats.TimingResultsNanos, d)
+}
+
+// sizeClassStats returns the stats of the request body size class 'bytes' falls
+// into, creating the endpoint's size classes on first use
+func (rh *ResponseHandler) sizeClassStats(epDetail *api.EndpointDetail, bytes int64) *api.SizeClassStats {
+	classes := rh.SizeClasses
+	if len(classes) == 0 {
+		classes = api.DefaultBodySizeClasses
+	}
+
+	if epDetail.LatencyBySizeClass == nil {
+		var lower int64
+		for _, upper := range classes {
+			label := fmt.Sprintf("%s-%s", formatBytes(lower), formatBytes(upper))
+			if lower == 0 {
+				label = "<" + formatBytes(upper)
+			}
+			epDetail.LatencyBySizeClass = append(epDetail.LatencyBySizeClass,
+				&api.SizeClassStats{SizeClass: label, MinBytes: lower, MaxBytes: upper})
+			lower = upper
+		}
+		epDetail.LatencyBySizeClass = append(epDetail.LatencyBySizeClass,
+			&api.SizeClassStats{SizeClass: ">=" + formatBytes(lower), MinBytes: lower})
 	}
-	epDetail.HTTPMethodStatusDist[resp.Endpoint.Method][resp.HTTPStatus]++
 
+	return epDetail.LatencyBySizeClass[sort.Search(len(classes), func(i int) bool { return bytes < classes[i] })]
+}
+
+// ValidateBodySizeClasses verifies that the request body size class boundaries are
+// positive and in ascending order
+func ValidateBodySizeClasses(classes []int64) error {
+	for i, upper := range classes {
+		if upper <= 0 {
+			return fmt.Errorf("BodySizeClasses must be greater than 0, BodySizeClasses[%d] is %d", i, upper)
+		}
+		if i > 0 && upper <= classes[i-1] {
+			return fmt.Errorf("BodySizeClasses must be in ascending order, BodySizeClasses[%d], %d, is not greater than %d",
+				i, upper, classes[i-1])
+		}
+	}
+	return nil
 }
 
 // generateHistogram populates the histogram map, a map keyed by a float64 that's
@@ -5,6 +5,8 @@
 package internal
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -12,12 +14,18 @@ import (
 	"math"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/youngkin/heyyall/api"
+	"github.com/youngkin/heyyall/internal/stats"
 )
 
 var (
@@ -110,21 +118,21 @@ func TestResponseStats(t *testing.T) {
 		Endpoint:        api.Endpoint{URL: url1, Method: http.MethodGet},
 		RequestDuration: time.Millisecond * 100,
 	}
-	totalRunTime := time.Second * 0
-	rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	acc := stats.NewAccumulator(stats.Config{Start: start})
+	rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
 
 	resp = Response{
 		HTTPStatus:      http.StatusAccepted,
 		Endpoint:        api.Endpoint{URL: url1, Method: http.MethodPut},
 		RequestDuration: time.Millisecond * 1000,
 	}
-	rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
 	resp = Response{
 		HTTPStatus:      http.StatusAccepted,
 		Endpoint:        api.Endpoint{URL: url1, Method: http.MethodPut},
 		RequestDuration: time.Millisecond * 500,
 	}
-	rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
 
 	// URL2
 	resp = Response{
@@ -132,7 +140,7 @@ func TestResponseStats(t *testing.T) {
 		Endpoint:        api.Endpoint{URL: url2, Method: http.MethodPost},
 		RequestDuration: time.Millisecond * 250,
 	}
-	rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
 
 	// URL3 - POST
 	resp = Response{
@@ -140,58 +148,58 @@ func TestResponseStats(t *testing.T) {
 		Endpoint:        api.Endpoint{URL: url3, Method: http.MethodPost},
 		RequestDuration: time.Millisecond * 250,
 	}
-	rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
 	// GET
 	resp = Response{
 		HTTPStatus:      http.StatusOK,
 		Endpoint:        api.Endpoint{URL: url3, Method: http.MethodGet},
 		RequestDuration: time.Millisecond * 250,
 	}
-	rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
 	resp = Response{
 		HTTPStatus:      http.StatusOK,
 		Endpoint:        api.Endpoint{URL: url3, Method: http.MethodGet},
 		RequestDuration: time.Millisecond * 750,
 	}
-	rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
 	// PUT
 	resp = Response{
 		HTTPStatus:      http.StatusOK,
 		Endpoint:        api.Endpoint{URL: url3, Method: http.MethodPut},
 		RequestDuration: time.Millisecond * 250,
 	}
-	rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
 	resp = Response{
 		HTTPStatus:      http.StatusOK,
 		Endpoint:        api.Endpoint{URL: url3, Method: http.MethodPut},
 		RequestDuration: time.Millisecond * 750,
 	}
-	rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
 	resp = Response{
 		HTTPStatus:      http.StatusOK,
 		Endpoint:        api.Endpoint{URL: url3, Method: http.MethodPut},
 		RequestDuration: time.Millisecond * 1250,
 	}
-	rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
 	resp = Response{
 		HTTPStatus:      http.StatusOK,
 		Endpoint:        api.Endpoint{URL: url3, Method: http.MethodPut},
 		RequestDuration: time.Millisecond * 1750,
 	}
-	rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
 	// DELETE
 	resp = Response{
 		HTTPStatus:      http.StatusOK,
 		Endpoint:        api.Endpoint{URL: url3, Method: http.MethodDelete},
 		RequestDuration: time.Millisecond * 900,
 	}
-	rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
 
 	// TODO: Add read from DONE channel to verify that ResponseHandler closes
 	// out all resources as expected
 
 	// FINALIZE
-	err := rh.finalizeResponseStats(start, &totalRunTime, &runResults, epRunSummary)
+	err := rh.finalizeResponseStats(acc, &runResults, epRunSummary)
 	if err != nil {
 		t.Errorf("unexpected error finalizing response stats: %s", err)
 	}
@@ -567,3 +575,532 @@ func compareRqstStats(x, y api.RqstStats) bool {
 
 	return false
 }
+
+// TestAbandonedSlowStats verifies that requests abandoned at the soft deadline are
+// counted but not included in the latency stats.
+func TestAbandonedSlowStats(t *testing.T) {
+	url1 := "http://someurl/1"
+	runResults := api.RunResults{
+		RunSummary: api.RunSummary{
+			RqstStats: api.RqstStats{
+				MinRqstDurationNanos: math.MaxInt64,
+			},
+		},
+		EndpointSummary: make(map[string]map[string]int),
+	}
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	rh := ResponseHandler{OutputType: JSON}
+	acc := stats.NewAccumulator(stats.Config{Start: time.Now()})
+
+	resps := []Response{
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: 10 * time.Millisecond},
+		{Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: 500 * time.Millisecond, AbandonedSlow: true},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: 20 * time.Millisecond},
+		{Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: 500 * time.Millisecond, AbandonedSlow: true},
+	}
+	for _, resp := range resps {
+		rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
+	}
+	if err := rh.finalizeResponseStats(acc, &runResults, epRunSummary); err != nil {
+		t.Fatalf("unexpected error finalizing response stats: %s", err)
+	}
+
+	if runResults.RunSummary.AbandonedSlow != 2 {
+		t.Errorf("expected 2 abandoned requests, got %d", runResults.RunSummary.AbandonedSlow)
+	}
+	if epRunSummary[url1].AbandonedSlow != 2 {
+		t.Errorf("expected 2 abandoned requests for %s, got %d", url1, epRunSummary[url1].AbandonedSlow)
+	}
+	if runResults.RunSummary.RqstStats.TotalRqsts != 2 || len(runResults.RunSummary.RqstStats.TimingResultsNanos) != 2 {
+		t.Errorf("expected 2 requests in the latency stats, got %d", runResults.RunSummary.RqstStats.TotalRqsts)
+	}
+	if runResults.RunSummary.RqstStats.MaxRqstDurationNanos != 20*time.Millisecond {
+		t.Errorf("expected max latency of %s, got %s", 20*time.Millisecond, runResults.RunSummary.RqstStats.MaxRqstDurationNanos)
+	}
+	if calcPercentiles(99, runResults.RunSummary.RqstStats.TimingResultsNanos) != 20*time.Millisecond {
+		t.Errorf("expected abandoned requests to be excluded from the percentiles")
+	}
+}
+
+// TestLatencyBySizeClass verifies that request latency is reported by request body
+// size class and that requests without a body aren't included.
+func TestLatencyBySizeClass(t *testing.T) {
+	url1 := "http://someurl/1"
+	tests := []struct {
+		name          string
+		sizeClasses   []int64
+		expectedStats []api.SizeClassStats
+	}{
+		{
+			name: "DefaultSizeClasses",
+			expectedStats: []api.SizeClassStats{
+				{SizeClass: "<1KB", MinBytes: 0, MaxBytes: 1 << 10, RqstStats: api.RqstStats{TotalRqsts: 2, AvgRqstDurationNanos: 15 * time.Millisecond}},
+				{SizeClass: "10KB-100KB", MinBytes: 10 << 10, MaxBytes: 100 << 10, RqstStats: api.RqstStats{TotalRqsts: 1, AvgRqstDurationNanos: 50 * time.Millisecond}},
+				{SizeClass: ">=100KB", MinBytes: 100 << 10, RqstStats: api.RqstStats{TotalRqsts: 2, AvgRqstDurationNanos: 300 * time.Millisecond}},
+			},
+		},
+		{
+			name:        "ConfiguredSizeClasses",
+			sizeClasses: []int64{500, 1 << 20},
+			expectedStats: []api.SizeClassStats{
+				{SizeClass: "<500B", MinBytes: 0, MaxBytes: 500, RqstStats: api.RqstStats{TotalRqsts: 1, AvgRqstDurationNanos: 10 * time.Millisecond}},
+				{SizeClass: "500B-1MB", MinBytes: 500, MaxBytes: 1 << 20, RqstStats: api.RqstStats{TotalRqsts: 3, AvgRqstDurationNanos: 90 * time.Millisecond}},
+				{SizeClass: ">=1MB", MinBytes: 1 << 20, RqstStats: api.RqstStats{TotalRqsts: 1, AvgRqstDurationNanos: 400 * time.Millisecond}},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			runResults := api.RunResults{
+				RunSummary:      api.RunSummary{RqstStats: api.RqstStats{MinRqstDurationNanos: math.MaxInt64}},
+				EndpointSummary: make(map[string]map[string]int),
+			}
+			epRunSummary := make(map[string]*api.EndpointDetail)
+			rh := ResponseHandler{OutputType: JSON, SizeClasses: tc.sizeClasses}
+			acc := stats.NewAccumulator(stats.Config{Start: time.Now()})
+
+			resps := []Response{
+				{Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: 1 * time.Millisecond},
+				{Endpoint: api.Endpoint{URL: url1, Method: http.MethodPost}, RequestDuration: 10 * time.Millisecond, BytesSent: 100},
+				{Endpoint: api.Endpoint{URL: url1, Method: http.MethodPost}, RequestDuration: 20 * time.Millisecond, BytesSent: 1000},
+				{Endpoint: api.Endpoint{URL: url1, Method: http.MethodPost}, RequestDuration: 50 * time.Millisecond, BytesSent: 50 << 10},
+				{Endpoint: api.Endpoint{URL: url1, Method: http.MethodPost}, RequestDuration: 200 * time.Millisecond, BytesSent: 500 << 10},
+				{Endpoint: api.Endpoint{URL: url1, Method: http.MethodPost}, RequestDuration: 400 * time.Millisecond, BytesSent: 2 << 20},
+			}
+			for _, resp := range resps {
+				rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
+			}
+			err := rh.finalizeResponseStats(acc, &runResults, epRunSummary)
+			if err != nil {
+				t.Fatalf("unexpected error finalizing response stats: %s", err)
+			}
+
+			actual := runResults.EndpointDetails[url1].LatencyBySizeClass
+			if len(actual) != len(tc.expectedStats) {
+				t.Fatalf("expected %d size classes, got %d", len(tc.expectedStats), len(actual))
+			}
+			for i, expected := range tc.expectedStats {
+				if actual[i].SizeClass != expected.SizeClass || actual[i].MinBytes != expected.MinBytes ||
+					actual[i].MaxBytes != expected.MaxBytes || actual[i].TotalRqsts != expected.TotalRqsts ||
+					actual[i].AvgRqstDurationNanos != expected.AvgRqstDurationNanos {
+					t.Errorf("expected size class %+v, got %+v", expected, *actual[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateBodySizeClasses(t *testing.T) {
+	if err := ValidateBodySizeClasses([]int64{1, 2, 3}); err != nil {
+		t.Errorf("unexpected error for ascending size classes: %s", err)
+	}
+	if err := ValidateBodySizeClasses([]int64{1, 3, 2}); err == nil {
+		t.Errorf("expected error for size classes not in ascending order")
+	}
+	if err := ValidateBodySizeClasses([]int64{0, 3}); err == nil {
+		t.Errorf("expected error for a size class of 0")
+	}
+}
+
+func TestServerClosedConnectionStats(t *testing.T) {
+	url1 := "http://someurl/1"
+	runResults := api.RunResults{
+		RunSummary:      api.RunSummary{RqstStats: api.RqstStats{MinRqstDurationNanos: math.MaxInt64}},
+		EndpointSummary: make(map[string]map[string]int),
+	}
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	rh := ResponseHandler{OutputType: JSON}
+	acc := stats.NewAccumulator(stats.Config{Start: time.Now()})
+
+	for i := 0; i < 4; i++ {
+		resp := Response{
+			HTTPStatus:       http.StatusOK,
+			Endpoint:         api.Endpoint{URL: url1, Method: http.MethodGet},
+			RequestDuration:  time.Millisecond,
+			ServerClosedConn: i == 0,
+		}
+		rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
+	}
+	if err := rh.finalizeResponseStats(acc, &runResults, epRunSummary); err != nil {
+		t.Fatalf("unexpected error finalizing response stats: %s", err)
+	}
+
+	if runResults.RunSummary.ServerClosedConnections != 1 {
+		t.Errorf("expected 1 server closed connection, got %d", runResults.RunSummary.ServerClosedConnections)
+	}
+	if runResults.RunSummary.ServerClosedConnectionRatio != 0.25 {
+		t.Errorf("expected a server closed connection ratio of 0.25, got %f", runResults.RunSummary.ServerClosedConnectionRatio)
+	}
+}
+
+// TestProtocolStats verifies that requests are counted by protocol and by whether they
+// used a new or reused connection
+func TestProtocolStats(t *testing.T) {
+	url1 := "http://someurl/1"
+	runResults := api.RunResults{
+		RunSummary:      api.RunSummary{RqstStats: api.RqstStats{MinRqstDurationNanos: math.MaxInt64}},
+		EndpointSummary: make(map[string]map[string]int),
+	}
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	rh := ResponseHandler{OutputType: JSON}
+	acc := stats.NewAccumulator(stats.Config{Start: time.Now()})
+
+	resps := []Response{
+		{Proto: "HTTP/2.0"},
+		{Proto: "HTTP/2.0", ConnReused: true},
+		{Proto: "HTTP/3.0"},
+		{Proto: "HTTP/3.0", ConnReused: true},
+		{Proto: "HTTP/3.0", ConnReused: true},
+		{Proto: "HTTP/3.0", AbandonedSlow: true},
+	}
+	for _, resp := range resps {
+		resp.HTTPStatus = http.StatusOK
+		resp.Endpoint = api.Endpoint{URL: url1, Method: http.MethodGet}
+		resp.RequestDuration = time.Millisecond
+		rh.accumulateResponseStats(resp, acc, &runResults, epRunSummary)
+	}
+
+	expectedProtos := map[string]int64{"HTTP/2.0": 2, "HTTP/3.0": 3}
+	if !reflect.DeepEqual(runResults.RunSummary.Protocols, expectedProtos) {
+		t.Errorf("expected protocols %v, got %v", expectedProtos, runResults.RunSummary.Protocols)
+	}
+	if runResults.RunSummary.NewConnections != 2 || runResults.RunSummary.ReusedConnections != 3 {
+		t.Errorf("expected 2 new and 3 reused connections, got %d and %d", runResults.RunSummary.NewConnections,
+			runResults.RunSummary.ReusedConnections)
+	}
+}
+
+// TestResponsesTee verifies that responses are delivered to the channel returned by
+// Responses() and to the built-in summary, and that the channel is closed when the
+// run ends. It also verifies that the built-in summary can be disabled.
+func TestResponsesTee(t *testing.T) {
+	tests := []struct {
+		name           string
+		disableSummary bool
+	}{
+		{name: "WithSummary", disableSummary: false},
+		{name: "SummaryDisabled", disableSummary: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			numResps := 10
+			rh := ResponseHandler{
+				OutputType:     JSON,
+				ResponseC:      make(chan Response),
+				DoneC:          make(chan interface{}),
+				DisableSummary: tc.disableSummary,
+			}
+			teeC := rh.Responses(numResps)
+			go rh.Start()
+
+			teeDone := make(chan int)
+			go func() {
+				numTeeResps := 0
+				for range teeC {
+					numTeeResps++
+				}
+				teeDone <- numTeeResps
+			}()
+
+			for i := 0; i < numResps; i++ {
+				rh.ResponseC <- Response{
+					HTTPStatus:      http.StatusOK,
+					Endpoint:        api.Endpoint{URL: "http://someurl/1", Method: http.MethodGet},
+					RequestDuration: time.Millisecond,
+				}
+			}
+			close(rh.ResponseC)
+			<-rh.DoneC
+
+			if numTeeResps := <-teeDone; numTeeResps != numResps {
+				t.Errorf("expected %d teed responses, got %d", numResps, numTeeResps)
+			}
+			if rh.DroppedResponses() != 0 {
+				t.Errorf("expected no dropped responses, got %d", rh.DroppedResponses())
+			}
+
+			if tc.disableSummary {
+				if rh.Results != nil {
+					t.Errorf("expected no results when the summary is disabled, got %+v", rh.Results)
+				}
+				return
+			}
+			if rh.Results == nil || rh.Results.RunSummary.RqstStats.TotalRqsts != int64(numResps) {
+				t.Errorf("expected a summary of %d requests, got %+v", numResps, rh.Results)
+			}
+		})
+	}
+}
+
+// TestResponsesTeeDrop verifies that responses are dropped from the channel returned by
+// Responses(), rather than blocking the handler, when the consumer falls behind.
+func TestResponsesTeeDrop(t *testing.T) {
+	rh := ResponseHandler{
+		OutputType:     JSON,
+		ResponseC:      make(chan Response),
+		DoneC:          make(chan interface{}),
+		DisableSummary: true,
+	}
+	teeC := rh.Responses(1)
+	go rh.Start()
+
+	for i := 0; i < 5; i++ {
+		rh.ResponseC <- Response{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: "http://someurl/1", Method: http.MethodGet}}
+	}
+	close(rh.ResponseC)
+	<-rh.DoneC
+
+	if rh.DroppedResponses() != 4 {
+		t.Errorf("expected 4 dropped responses, got %d", rh.DroppedResponses())
+	}
+	numTeeResps := 0
+	for range teeC {
+		numTeeResps++
+	}
+	if numTeeResps != 1 {
+		t.Errorf("expected 1 teed response, got %d", numTeeResps)
+	}
+}
+
+// TestMalformedURLStats verifies that malformed URLs are counted by error category,
+// excluded from the latency stats, and that only the first few are sampled.
+func TestMalformedURLStats(t *testing.T) {
+	url1 := "http://someurl/{{ .id }}"
+	runResults := api.RunResults{
+		RunSummary:      api.RunSummary{RqstStats: api.RqstStats{MinRqstDurationNanos: math.MaxInt64}},
+		EndpointSummary: make(map[string]map[string]int),
+	}
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	rh := ResponseHandler{OutputType: JSON}
+	acc := stats.NewAccumulator(stats.Config{Start: time.Now()})
+
+	for i := 0; i < maxMalformedURLSamples+2; i++ {
+		rendered := fmt.Sprintf("http://bad host/%d", i)
+		rh.accumulateResponseStats(Response{
+			Endpoint:    api.Endpoint{URL: url1, Method: http.MethodGet},
+			ErrCategory: api.ErrCategoryMalformedURL,
+			Err:         validateRqstURL(rendered),
+		}, acc, &runResults, epRunSummary)
+	}
+	rh.accumulateResponseStats(Response{
+		HTTPStatus:      http.StatusOK,
+		Endpoint:        api.Endpoint{URL: url1, Method: http.MethodGet},
+		RequestDuration: time.Millisecond,
+	}, acc, &runResults, epRunSummary)
+	if err := rh.finalizeResponseStats(acc, &runResults, epRunSummary); err != nil {
+		t.Fatalf("unexpected error finalizing response stats: %s", err)
+	}
+
+	if runResults.RunSummary.ErrorCategories[api.ErrCategoryMalformedURL] != int64(maxMalformedURLSamples+2) {
+		t.Errorf("expected %d malformed URLs, got %d", maxMalformedURLSamples+2,
+			runResults.RunSummary.ErrorCategories[api.ErrCategoryMalformedURL])
+	}
+	epDetail := epRunSummary[url1]
+	if epDetail.ErrorCategories[api.ErrCategoryMalformedURL] != int64(maxMalformedURLSamples+2) {
+		t.Errorf("expected %d malformed URLs for %s, got %d", maxMalformedURLSamples+2, url1,
+			epDetail.ErrorCategories[api.ErrCategoryMalformedURL])
+	}
+	if len(epDetail.MalformedURLSamples) != maxMalformedURLSamples || epDetail.MalformedURLSamples[0] != "http://bad host/0" {
+		t.Errorf("expected the first %d malformed URLs to be sampled, got %v", maxMalformedURLSamples, epDetail.MalformedURLSamples)
+	}
+	if runResults.RunSummary.RqstStats.TotalRqsts != 1 {
+		t.Errorf("expected 1 request in the latency stats, got %d", runResults.RunSummary.RqstStats.TotalRqsts)
+	}
+}
+
+// TestRollingSummaries verifies that summaries are written periodically while responses
+// are being received, and that the interval mode summarizes only the latest interval.
+func TestRollingSummaries(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+	}{
+		{name: "Cumulative", mode: api.RollingSummaryCumulative},
+		{name: "Interval", mode: api.RollingSummaryInterval},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := bytes.Buffer{}
+			rh := ResponseHandler{
+				OutputType:             JSON,
+				ResponseC:              make(chan Response),
+				DoneC:                  make(chan interface{}),
+				RollingSummaryInterval: 20 * time.Millisecond,
+				RollingSummaryMode:     tc.mode,
+				Output:                 &out,
+			}
+			go rh.Start()
+
+			numResps := 20
+			for i := 0; i < numResps; i++ {
+				rh.ResponseC <- Response{
+					HTTPStatus:      http.StatusOK,
+					Endpoint:        api.Endpoint{URL: "http://someurl/1", Method: http.MethodGet},
+					RequestDuration: time.Millisecond,
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			close(rh.ResponseC)
+			<-rh.DoneC
+
+			var summaries []api.RollingSummary
+			for _, line := range strings.Split(out.String(), "\n") {
+				if !strings.HasPrefix(line, `{"RollingSummary"`) {
+					continue
+				}
+				rs := struct{ RollingSummary api.RollingSummary }{}
+				if err := json.Unmarshal([]byte(line), &rs); err != nil {
+					t.Fatalf("unable to unmarshal rolling summary %q: %s", line, err)
+				}
+				summaries = append(summaries, rs.RollingSummary)
+			}
+			if len(summaries) < 2 {
+				t.Fatalf("expected multiple rolling summaries, got %d: %s", len(summaries), out.String())
+			}
+
+			var total int64
+			for i, s := range summaries {
+				if s.Mode != tc.mode {
+					t.Errorf("expected mode %s, got %s", tc.mode, s.Mode)
+				}
+				if s.ToNanos <= s.FromNanos {
+					t.Errorf("expected the summary to end after %s, got %s", s.FromNanos, s.ToNanos)
+				}
+				if tc.mode == api.RollingSummaryCumulative {
+					if s.FromNanos != 0 {
+						t.Errorf("expected cumulative summaries to start at 0, got %s", s.FromNanos)
+					}
+					if i > 0 && s.RunSummary.RqstStats.TotalRqsts < summaries[i-1].RunSummary.RqstStats.TotalRqsts {
+						t.Errorf("expected cumulative request counts to not decrease, got %d after %d",
+							s.RunSummary.RqstStats.TotalRqsts, summaries[i-1].RunSummary.RqstStats.TotalRqsts)
+					}
+				}
+				total += s.RunSummary.RqstStats.TotalRqsts
+			}
+			if tc.mode == api.RollingSummaryInterval && total > int64(numResps) {
+				t.Errorf("expected interval summaries to total at most %d requests, got %d", numResps, total)
+			}
+			if rh.Results == nil || rh.Results.RunSummary.RqstStats.TotalRqsts != int64(numResps) {
+				t.Errorf("expected a final summary of %d requests, got %+v", numResps, rh.Results)
+			}
+		})
+	}
+}
+
+// TestCacheHitRatio verifies that responses served from a cache, as identified by the
+// configured cache hit headers, are reported by endpoint.
+func TestCacheHitRatio(t *testing.T) {
+	var rqsts int32
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every other response is a cache hit
+		if atomic.AddInt32(&rqsts, 1)%2 == 0 {
+			w.Header().Set("X-Cache", "Hit from cloudfront")
+			w.Header().Set("CDN-Cache-Status", "cached")
+		} else {
+			w.Header().Set("X-Cache", "Miss from cloudfront")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name            string
+		cacheHitHeaders []api.CacheHitHeader
+		expectedRatio   float64
+	}{
+		{name: "DefaultHeaders", expectedRatio: 0.5},
+		{name: "ConfiguredHeader", cacheHitHeaders: []api.CacheHitHeader{{Name: "cdn-cache-status"}}, expectedRatio: 0.5},
+		{name: "NoMatchingHeader", cacheHitHeaders: []api.CacheHitHeader{{Name: "X-Cache", Value: "STALE"}}, expectedRatio: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			numRqsts := 10
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    http.Client{},
+			}
+			rqstr.ProcessRqst(api.Endpoint{URL: testSrv.URL, Method: http.MethodGet, RqstPercent: 100}, numRqsts, 0)
+			close(respC)
+
+			var responses []Response
+			for resp := range respC {
+				responses = append(responses, resp)
+			}
+			rh := ResponseHandler{CacheHitHeaders: tc.cacheHitHeaders, start: time.Now()}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+
+			epDetail := runResults.EndpointDetails[testSrv.URL]
+			if epDetail == nil {
+				t.Fatalf("expected endpoint details for %s, got %+v", testSrv.URL, runResults.EndpointDetails)
+			}
+			if epDetail.CacheHitRatio != tc.expectedRatio {
+				t.Errorf("expected a cache hit ratio of %f, got %f", tc.expectedRatio, epDetail.CacheHitRatio)
+			}
+		})
+	}
+}
+
+// TestBytesReceivedStats verifies the min, max, and average response body sizes are
+// reported by endpoint.
+func TestBytesReceivedStats(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		w.WriteHeader(http.StatusOK)
+		w.Write(bytes.Repeat([]byte("x"), size))
+	}))
+	defer testSrv.Close()
+
+	smallURL, largeURL := testSrv.URL+"/small", testSrv.URL+"/large"
+	sizes := map[string][]int{
+		smallURL: {0, 10, 20},
+		largeURL: {1000, 5000, 3000},
+	}
+
+	var responses []Response
+	for url, epSizes := range sizes {
+		respC := make(chan Response, len(epSizes))
+		rqstr := Requestor{
+			Ctx:       context.Background(),
+			ResponseC: respC,
+			Client:    http.Client{},
+		}
+		for _, size := range epSizes {
+			ep := api.Endpoint{URL: fmt.Sprintf("%s?size=%d", url, size), Method: http.MethodGet, RqstPercent: 100}
+			rqstr.ProcessRqst(ep, 1, 0)
+		}
+		close(respC)
+		for resp := range respC {
+			resp.Endpoint.URL = url
+			responses = append(responses, resp)
+		}
+	}
+
+	rh := ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	expected := map[string][3]int64{
+		smallURL: {0, 20, 10},
+		largeURL: {1000, 5000, 3000},
+	}
+	for url, minMaxAvg := range expected {
+		epDetail := runResults.EndpointDetails[url]
+		if epDetail == nil {
+			t.Fatalf("expected endpoint details for %s", url)
+		}
+		if actual := [3]int64{epDetail.MinBytes, epDetail.MaxBytes, epDetail.AvgBytes}; actual != minMaxAvg {
+			t.Errorf("expected min/max/avg bytes of %v for %s, got %v", minMaxAvg, url, actual)
+		}
+	}
+}
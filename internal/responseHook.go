@@ -0,0 +1,222 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// maxHookMessageLen is the most of a ResponseHook command's output used as the error
+// message of a response it rejected
+const maxHookMessageLen = 200
+
+// ResponseHookRunner runs the LoadTestConfig's ResponseHook for a sample of the
+// successful responses. It's shared by all requestors.
+type ResponseHookRunner struct {
+	command    []string
+	sampleRate float64
+	timeout    time.Duration
+	// slots has a value for each command that's running
+	slots chan struct{}
+
+	mu          sync.Mutex
+	seen        int64
+	invocations int64
+	rejected    int64
+	failures    int64
+	skipped     int64
+	total       time.Duration
+	max         time.Duration
+}
+
+// NewResponseHookRunner returns the ResponseHookRunner of 'hook', or nil if it's nil
+func NewResponseHookRunner(hook *api.ResponseHook) (*ResponseHookRunner, error) {
+	if hook == nil {
+		return nil, nil
+	}
+	if len(hook.Command) == 0 {
+		return nil, errors.New("ResponseHook must have a Command")
+	}
+	if _, err := exec.LookPath(hook.Command[0]); err != nil {
+		return nil, fmt.Errorf("ResponseHook Command %q can't be run: %w", hook.Command[0], err)
+	}
+
+	sampleRate := hook.SampleRate
+	if sampleRate == 0 {
+		sampleRate = api.DefaultResponseHookSampleRate
+	}
+	if sampleRate < 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("ResponseHook SampleRate, %v, must be greater than 0 and at most 1", hook.SampleRate)
+	}
+	timeout := hook.Timeout
+	if timeout == "" {
+		timeout = api.DefaultResponseHookTimeout
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, fmt.Errorf("ResponseHook Timeout %q is invalid: %w", hook.Timeout, err)
+	}
+	if d <= 0 {
+		return nil, fmt.Errorf("ResponseHook Timeout, %s, must be greater than 0", hook.Timeout)
+	}
+	maxConcurrent := hook.MaxConcurrent
+	if maxConcurrent == 0 {
+		maxConcurrent = api.DefaultResponseHookMaxConcurrent
+	}
+	if maxConcurrent < 0 {
+		return nil, fmt.Errorf("ResponseHook MaxConcurrent, %d, must not be negative", hook.MaxConcurrent)
+	}
+
+	return &ResponseHookRunner{
+		command:    hook.Command,
+		sampleRate: sampleRate,
+		timeout:    d,
+		slots:      make(chan struct{}, maxConcurrent),
+	}, nil
+}
+
+// sampled returns true if the next response is to be validated. Responses are
+// sampled evenly, e.g., every other one at a SampleRate of 0.5.
+func (h *ResponseHookRunner) sampled() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seen++
+	return int64(float64(h.seen)*h.sampleRate) > int64(float64(h.seen-1)*h.sampleRate)
+}
+
+// validate runs the hook for the successful response 'resp' to a request to 'url',
+// if it's sampled. 'body' is the response body and 'd' how long the request took.
+// It returns an error if the hook rejected the response. 'h' may be nil.
+func (h *ResponseHookRunner) validate(ctx context.Context, url, method string, resp *http.Response, body []byte, d time.Duration) error {
+	if h == nil || !h.sampled() {
+		return nil
+	}
+	select {
+	case h.slots <- struct{}{}:
+		defer func() { <-h.slots }()
+	default:
+		h.mu.Lock()
+		h.skipped++
+		h.mu.Unlock()
+		return nil
+	}
+
+	input, err := json.Marshal(api.HookResponse{
+		URL:           url,
+		Method:        method,
+		Status:        resp.StatusCode,
+		Header:        resp.Header,
+		Body:          string(body),
+		DurationNanos: d,
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("ResponseHook: unable to encode the response")
+		return nil
+	}
+
+	hookCtx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(hookCtx, h.command[0], h.command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	start := time.Now()
+	err = cmd.Start()
+	if err == nil {
+		// The command is killed when it times out, but Wait also waits for any
+		// processes it started that are still writing to its output, so it isn't
+		// waited for after the timeout
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		select {
+		case err = <-done:
+		case <-hookCtx.Done():
+			err = hookCtx.Err()
+		}
+	}
+	elapsed := time.Since(start)
+	// Hooks killed because the run ended weren't failures of the hook
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	var exitErr *exec.ExitError
+	rejected := err != nil && hookCtx.Err() == nil && errors.As(err, &exitErr)
+	h.mu.Lock()
+	h.invocations++
+	h.total += elapsed
+	if elapsed > h.max {
+		h.max = elapsed
+	}
+	switch {
+	case rejected:
+		h.rejected++
+	case err != nil:
+		h.failures++
+	}
+	h.mu.Unlock()
+
+	if err != nil && !rejected {
+		if hookCtx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", h.timeout)
+		}
+		log.Debug().Err(err).Msgf("ResponseHook: unable to validate the response from %s", url)
+		return nil
+	}
+	if !rejected {
+		return nil
+	}
+	msg := strings.TrimSpace(output.String())
+	if len(msg) > maxHookMessageLen {
+		msg = msg[:maxHookMessageLen] + "..."
+	}
+	if msg == "" {
+		msg = exitErr.Error()
+	}
+	return fmt.Errorf("ResponseHook rejected the response: %s", msg)
+}
+
+// summary returns the hook's invocations, or nil if 'h' is nil
+func (h *ResponseHookRunner) summary() *api.ResponseHookStats {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := api.ResponseHookStats{
+		Invocations: h.invocations,
+		Rejected:    h.rejected,
+		Failures:    h.failures,
+		Skipped:     h.skipped,
+		TotalNanos:  h.total,
+		MaxNanos:    h.max,
+	}
+	if stats.Invocations > 0 {
+		stats.AvgNanos = stats.TotalNanos / time.Duration(stats.Invocations)
+	}
+	return &stats
+}
+
+// responseHookWarning returns a warning if some of the responses sampled by the
+// ResponseHook couldn't be validated, or an empty string if they all were
+func responseHookWarning(stats *api.ResponseHookStats) string {
+	if stats == nil || stats.Failures+stats.Skipped == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d sampled responses weren't validated by the ResponseHook, it failed or timed out %d times and was skipped %d times because MaxConcurrent hooks were running, see ResponseHookStats",
+		stats.Failures+stats.Skipped, stats.Failures, stats.Skipped)
+}
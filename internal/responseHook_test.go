@@ -0,0 +1,167 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestNewResponseHookRunner(t *testing.T) {
+	tests := []struct {
+		name      string
+		hook      *api.ResponseHook
+		expectNil bool
+		expectErr bool
+	}{
+		{name: "none", expectNil: true},
+		{name: "defaults", hook: &api.ResponseHook{Command: []string{"sh", "-c", "exit 0"}}},
+		{name: "all", hook: &api.ResponseHook{Command: []string{"sh"}, SampleRate: 0.1, Timeout: "100ms", MaxConcurrent: 1}},
+		{name: "no command", hook: &api.ResponseHook{}, expectErr: true},
+		{name: "missing command", hook: &api.ResponseHook{Command: []string{"no-such-heyyall-hook"}}, expectErr: true},
+		{name: "sample rate", hook: &api.ResponseHook{Command: []string{"sh"}, SampleRate: 1.5}, expectErr: true},
+		{name: "timeout", hook: &api.ResponseHook{Command: []string{"sh"}, Timeout: "soon"}, expectErr: true},
+		{name: "zero timeout", hook: &api.ResponseHook{Command: []string{"sh"}, Timeout: "0s"}, expectErr: true},
+		{name: "max concurrent", hook: &api.ResponseHook{Command: []string{"sh"}, MaxConcurrent: -1}, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			runner, err := NewResponseHookRunner(tc.hook)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			if !tc.expectErr && (runner == nil) != tc.expectNil {
+				t.Errorf("expected a nil runner %t, got %+v", tc.expectNil, runner)
+			}
+		})
+	}
+}
+
+// TestResponseHookSampling verifies responses are sampled evenly at the SampleRate
+func TestResponseHookSampling(t *testing.T) {
+	runner, err := NewResponseHookRunner(&api.ResponseHook{Command: []string{"sh"}, SampleRate: 0.25})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var sampled []int
+	for i := 0; i < 12; i++ {
+		if runner.sampled() {
+			sampled = append(sampled, i)
+		}
+	}
+	if len(sampled) != 3 || sampled[0] != 3 || sampled[1] != 7 || sampled[2] != 11 {
+		t.Errorf("expected every 4th response to be sampled, got %v", sampled)
+	}
+}
+
+// TestResponseHook verifies the hook's verdicts are counted as validation failures,
+// and that a hook that times out, or is skipped, doesn't fail the response
+func TestResponseHook(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/")))
+	}))
+	defer testSrv.Close()
+
+	// The hook rejects responses whose body isn't 'valid'
+	verify := []string{"sh", "-c", `grep -q '"Body":"valid"' || { echo "invalid signature"; exit 1; }`}
+	tests := []struct {
+		name           string
+		hook           api.ResponseHook
+		path           string
+		expectCategory string
+		expected       api.ResponseHookStats
+	}{
+		{
+			name:     "accepted",
+			hook:     api.ResponseHook{Command: verify},
+			path:     "/valid",
+			expected: api.ResponseHookStats{Invocations: 4},
+		},
+		{
+			name:           "rejected",
+			hook:           api.ResponseHook{Command: verify},
+			path:           "/forged",
+			expectCategory: api.ErrCategoryHookRejected,
+			expected:       api.ResponseHookStats{Invocations: 4, Rejected: 4},
+		},
+		{
+			name:           "sampled",
+			hook:           api.ResponseHook{Command: verify, SampleRate: 0.5},
+			path:           "/forged",
+			expectCategory: api.ErrCategoryHookRejected,
+			expected:       api.ResponseHookStats{Invocations: 2, Rejected: 2},
+		},
+		{
+			name:     "timed out",
+			hook:     api.ResponseHook{Command: []string{"sh", "-c", "sleep 5"}, Timeout: "50ms"},
+			path:     "/forged",
+			expected: api.ResponseHookStats{Invocations: 4, Failures: 4},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			runner, err := NewResponseHookRunner(&tc.hook)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			numRqsts := 4
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}, ResponseHook: runner}
+			rqstr.ProcessRqst(api.Endpoint{URL: testSrv.URL + tc.path, Method: http.MethodGet}, numRqsts, 0)
+			close(respC)
+
+			rejected := 0
+			for resp := range respC {
+				switch resp.ErrCategory {
+				case "":
+				case tc.expectCategory:
+					rejected++
+					if !strings.Contains(resp.Err.Error(), "invalid signature") {
+						t.Errorf("expected the hook's output to be the error, got %s", resp.Err)
+					}
+				default:
+					t.Errorf("expected the response to succeed or be %s, got %s", tc.expectCategory, resp.ErrCategory)
+				}
+			}
+			stats := runner.summary()
+			if rejected != int(tc.expected.Rejected) || stats.Invocations != tc.expected.Invocations ||
+				stats.Rejected != tc.expected.Rejected || stats.Failures != tc.expected.Failures || stats.Skipped != 0 {
+				t.Errorf("expected %d rejected responses and %+v, got %d and %+v", tc.expected.Rejected, tc.expected, rejected, stats)
+			}
+			if stats.AvgNanos <= 0 || stats.MaxNanos < stats.AvgNanos || stats.TotalNanos < stats.MaxNanos {
+				t.Errorf("expected the time spent in the hook to be reported, got %+v", stats)
+			}
+		})
+	}
+}
+
+// TestResponseHookSkipped verifies sampled responses aren't validated while
+// MaxConcurrent hooks are running
+func TestResponseHookSkipped(t *testing.T) {
+	runner, err := NewResponseHookRunner(&api.ResponseHook{Command: []string{"sh", "-c", "exit 1"}, MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	runner.slots <- struct{}{}
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	if err := runner.validate(context.Background(), "http://somehost.com", http.MethodGet, resp, nil, time.Millisecond); err != nil {
+		t.Errorf("expected a skipped response not to be rejected, got %s", err)
+	}
+	stats := runner.summary()
+	if stats.Skipped != 1 || stats.Invocations != 0 {
+		t.Errorf("expected the response to be skipped, got %+v", stats)
+	}
+	if warning := responseHookWarning(stats); !strings.Contains(warning, "skipped 1 times") {
+		t.Errorf("expected a warning that the response wasn't validated, got %q", warning)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// The artifacts written to a run's results directory
+const (
+	ResultsDirConfig     = "config.json"
+	ResultsDirSummary    = "summary.json"
+	ResultsDirReport     = "report.html"
+	ResultsDirResultsLog = "results.ndjson"
+	ResultsDirMeta       = "meta.txt"
+)
+
+// resultsDirTimeFormat names a run's results directory after the time it was created.
+// It avoids ':' so the name is valid on every OS.
+const resultsDirTimeFormat = "2006-01-02T15-04-05"
+
+// redacted replaces the values of the config's secrets, e.g., Authorization headers
+const redacted = "REDACTED"
+
+// unsafeLabelChars are the characters of a label that aren't used in a results
+// directory name
+var unsafeLabelChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sensitiveHeaderParts identify, case-insensitively, the request headers whose values
+// are redacted from the saved config
+var sensitiveHeaderParts = []string{"auth", "cookie", "token", "secret", "key", "password", "session"}
+
+// NewResultsDir creates, in 'parent', the directory a run's artifacts are written to
+// and returns its path. It's named after 'now', typically when the run is set up, and
+// 'label', e.g., '2020-06-01T12-03-05_users'. If a directory of that name already
+// exists, e.g., another run was set up in the same second, a suffix is appended,
+// e.g., '2020-06-01T12-03-05_users-2'.
+func NewResultsDir(parent, label string, now time.Time) (string, error) {
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", fmt.Errorf("unable to create the results directory %s: %w", parent, err)
+	}
+	name := now.Format(resultsDirTimeFormat)
+	if label = strings.Trim(unsafeLabelChars.ReplaceAllString(label, "-"), "-."); label != "" {
+		name += "_" + label
+	}
+	for i := 1; ; i++ {
+		dir := filepath.Join(parent, name)
+		if i > 1 {
+			dir = fmt.Sprintf("%s-%d", dir, i)
+		}
+		err := os.Mkdir(dir, 0755)
+		if err == nil {
+			return dir, nil
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("unable to create the results directory %s: %w", dir, err)
+		}
+	}
+}
+
+// WriteResultsConfig writes 'config', the run's config after it's been resolved,
+// e.g., filtered by -only, to 'dir' with its secrets redacted
+func WriteResultsConfig(dir string, config api.LoadTestConfig) error {
+	b, err := json.MarshalIndent(redactConfig(config), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding the config: %w", err)
+	}
+	return writeResultsFile(dir, ResultsDirConfig, append(b, '\n'))
+}
+
+// heyyallVersion returns the version of the heyyall module heyyall was built from, or
+// 'unknown' if it isn't known
+func heyyallVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// WriteResultsMeta writes a description of the heyyall build and the host running it,
+// and 'args', heyyall's command line, to 'dir'
+func WriteResultsMeta(dir string, args []string, now time.Time) error {
+	version := heyyallVersion()
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "heyyall version: %s\n", version)
+	fmt.Fprintf(&b, "go version: %s\n", runtime.Version())
+	fmt.Fprintf(&b, "os/arch: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "cpus: %d\n", runtime.NumCPU())
+	fmt.Fprintf(&b, "host: %s\n", host)
+	fmt.Fprintf(&b, "created: %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "command: %s\n", strings.Join(args, " "))
+	return writeResultsFile(dir, ResultsDirMeta, []byte(b.String()))
+}
+
+// writeResultsDir writes the run's summary, as JSON, and the HTML report to
+// 'rh.ResultsDir', whatever the OutputType
+func (rh *ResponseHandler) writeResultsDir(runResults api.RunResults) error {
+	b, err := rh.DurationUnit.marshalIndent(runResults, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding the run's summary: %w", err)
+	}
+	if err = writeResultsFile(rh.ResultsDir, ResultsDirSummary, append(b, '\n')); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(rh.ResultsDir, ResultsDirReport))
+	if err != nil {
+		return fmt.Errorf("unable to create the HTML report: %w", err)
+	}
+	rh.generateHistogram(&runResults)
+	if err = writeHTMLReport(f, runResults, rh.histogram); err != nil {
+		f.Close()
+		return fmt.Errorf("error generating the HTML report: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("error writing the HTML report: %w", err)
+	}
+	return nil
+}
+
+// writeResultsFile writes 'b' to the file 'name' in 'dir'
+func writeResultsFile(dir, name string, b []byte) error {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+		return fmt.Errorf("error writing %s to the results directory: %w", name, err)
+	}
+	return nil
+}
+
+// redactConfig returns a copy of 'config' with the values of its sensitive request
+// headers, including RotatingHeaders, and the passwords in its URLs replaced by
+// 'REDACTED'
+func redactConfig(config api.LoadTestConfig) api.LoadTestConfig {
+	eps := make([]api.Endpoint, len(config.Endpoints))
+	for i, ep := range config.Endpoints {
+		ep.URL = redactURL(ep.URL)
+		ep.Headers = redactHeaders(ep.Headers)
+		ep.RotatingHeaders = redactRotatingHeaders(ep.RotatingHeaders)
+		if ep.OptimisticUpdate != nil {
+			update := *ep.OptimisticUpdate
+			update.GetURL = redactURL(update.GetURL)
+			ep.OptimisticUpdate = &update
+		}
+		eps[i] = ep
+	}
+	config.Endpoints = eps
+	if config.CanaryBaseURLs != nil {
+		canary := *config.CanaryBaseURLs
+		canary.A, canary.B = redactURL(canary.A), redactURL(canary.B)
+		config.CanaryBaseURLs = &canary
+	}
+	return config
+}
+
+// isSensitiveHeader returns true if the values of the request header 'name' are
+// secrets, e.g., an Authorization header
+func isSensitiveHeader(name string) bool {
+	lower := strings.ToLower(name)
+	for _, part := range sensitiveHeaderParts {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactRotatingHeaders returns a copy of 'headers' with the Values of the sensitive
+// headers replaced. Their File isn't read so it doesn't need to be redacted.
+func redactRotatingHeaders(headers map[string]api.RotatingHeader) map[string]api.RotatingHeader {
+	if headers == nil {
+		return nil
+	}
+	redactedHeaders := make(map[string]api.RotatingHeader, len(headers))
+	for name, h := range headers {
+		if isSensitiveHeader(name) && len(h.Values) > 0 {
+			values := make([]string, len(h.Values))
+			for i := range values {
+				values[i] = redacted
+			}
+			h.Values = values
+		}
+		redactedHeaders[name] = h
+	}
+	return redactedHeaders
+}
+
+// redactHeaders returns a copy of 'headers' with the values of the sensitive headers
+// replaced
+func redactHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	redactedHeaders := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if isSensitiveHeader(name) {
+			value = redacted
+		}
+		redactedHeaders[name] = value
+	}
+	return redactedHeaders
+}
+
+// redactURL returns 'rawURL' with the password of its user info, if any, replaced
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); !ok {
+		return rawURL
+	}
+	u.User = url.UserPassword(u.User.Username(), redacted)
+	return u.String()
+}
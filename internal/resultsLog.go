@@ -0,0 +1,212 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// resultsLogHeader is the first line of a results log
+type resultsLogHeader struct {
+	// SampleRate is the fraction of successful responses recorded in the log.
+	// Counts of successful responses derived from the log should be divided by it.
+	SampleRate float64
+	// Seed is the seed used to choose which successful responses are recorded
+	Seed int64
+}
+
+// resultRecord is a single response recorded in a results log
+type resultRecord struct {
+	URL             string
+	Method          string
+	Phase           string   `json:",omitempty"`
+	TraceID         string   `json:",omitempty"`
+	RedirectChain   []string `json:",omitempty"`
+	HTTPStatus      int
+	RequestDuration time.Duration
+	// RequestWriteDuration and UploadBytesPerSec describe the upload of the request's
+	// body, see Response
+	RequestWriteDuration time.Duration `json:",omitempty"`
+	UploadBytesPerSec    float64       `json:",omitempty"`
+	// CompletedOffset is when the response was received relative to the start of
+	// the run
+	CompletedOffset time.Duration
+	Retries         int    `json:",omitempty"`
+	AbandonedSlow   bool   `json:",omitempty"`
+	ErrCategory     string `json:",omitempty"`
+	Err             string `json:",omitempty"`
+	// Headers are the response headers configured to be recorded
+	Headers http.Header `json:",omitempty"`
+	// Request is the request as it was sent. It's only recorded for failed responses,
+	// which can be replayed by 'heyyall replay-failures'.
+	Request *sentRqst `json:",omitempty"`
+}
+
+// ResultsLog writes a record of each response, one JSON object per line (NDJSON),
+// following a header line describing the log. Successful responses can be sampled to
+// bound the size of the log for long runs, failed responses are always recorded. It's
+// only used by the ResponseHandler's goroutine so the sampling decisions are made
+// without coordinating with the requestors.
+type ResultsLog struct {
+	w          *bufio.Writer
+	enc        *json.Encoder
+	sampleRate float64
+	rnd        *rand.Rand
+	start      time.Time
+}
+
+// NewResultsLog returns a ResultsLog writing to 'w'. A uniform random 'sampleRate'
+// fraction of the successful responses is recorded, 1 records every response. 'seed'
+// seeds the sampling so it's reproducible.
+func NewResultsLog(w io.Writer, sampleRate float64, seed int64) (*ResultsLog, error) {
+	if sampleRate <= 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("the results sample rate, %f, must be greater than 0 and no more than 1", sampleRate)
+	}
+
+	bw := bufio.NewWriter(w)
+	l := ResultsLog{
+		w:          bw,
+		enc:        json.NewEncoder(bw),
+		sampleRate: sampleRate,
+		rnd:        rand.New(rand.NewSource(seed)),
+		start:      time.Now(),
+	}
+	if err := l.enc.Encode(struct{ Header resultsLogHeader }{resultsLogHeader{SampleRate: sampleRate, Seed: seed}}); err != nil {
+		return nil, fmt.Errorf("error writing the results log header: %w", err)
+	}
+	return &l, nil
+}
+
+// OpenResultsFile opens the results log file at 'path' for a run's results log. The
+// file is truncated unless 'appendTo' is set, in which case the run's header line and
+// records follow the existing ones, e.g., to keep the results of a soak test split
+// across restarts in a single file. A non-empty file being appended to must start
+// with a results log header. If its last record is incomplete, e.g., because the run
+// writing it was killed, it's removed so the file stays valid NDJSON.
+func OpenResultsFile(path string, appendTo bool) (*os.File, error) {
+	if !appendTo {
+		return os.Create(path)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err = prepareAppend(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to append to %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// prepareAppend verifies 'f' is a results log, if it isn't empty, and removes its
+// last line if it's incomplete
+func prepareAppend(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil
+	}
+
+	first, err := bufio.NewReader(io.NewSectionReader(f, 0, size)).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var header struct{ Header *resultsLogHeader }
+	if err := json.Unmarshal(first, &header); err != nil || header.Header == nil {
+		return errors.New("the file isn't a results log, it doesn't start with a results log header")
+	}
+
+	end, err := lastLineEnd(f, size)
+	if err != nil {
+		return err
+	}
+	if end < size {
+		log.Warn().Msgf("removing the incomplete last record, %d bytes, of the results log %s before appending to it",
+			size-end, f.Name())
+		return f.Truncate(end)
+	}
+	return nil
+}
+
+// lastLineEnd returns the offset just past the last newline in the first 'size'
+// bytes of 'f', or 0 if there isn't one
+func lastLineEnd(f *os.File, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// failed reports whether 'resp' is a failure, and so always recorded
+func (resp Response) failed() bool {
+	return resp.ErrCategory != "" || resp.AbandonedSlow ||
+		(resp.HTTPStatus >= http.StatusBadRequest && resp.Endpoint.ExpectedOutcome == nil)
+}
+
+// Write records 'resp' if it failed or it's chosen by the sampling
+func (l *ResultsLog) Write(resp Response) error {
+	if !resp.failed() && l.sampleRate < 1 && l.rnd.Float64() >= l.sampleRate {
+		return nil
+	}
+
+	record := resultRecord{
+		URL:             resp.Endpoint.URL,
+		Method:          resp.Endpoint.Method,
+		Phase:           resp.Phase,
+		TraceID:         resp.TraceID,
+		RedirectChain:   resp.RedirectChain,
+		HTTPStatus:      resp.HTTPStatus,
+		RequestDuration: resp.RequestDuration,
+		Retries:         resp.Retries,
+		AbandonedSlow:   resp.AbandonedSlow,
+		ErrCategory:     resp.ErrCategory,
+		Headers:         resp.RecordedHeaders,
+	}
+	if !resp.Completed.IsZero() {
+		record.CompletedOffset = resp.Completed.Sub(l.start)
+	}
+	// The upload is only recorded for requests with a body
+	if resp.BytesUploaded > 0 {
+		record.RequestWriteDuration, record.UploadBytesPerSec = resp.RequestWriteDuration, resp.UploadBytesPerSec
+	}
+	if resp.Err != nil {
+		record.Err = resp.Err.Error()
+	}
+	if resp.failed() {
+		record.Request = resp.Rqst
+	}
+	return l.enc.Encode(record)
+}
+
+// Flush writes any buffered records
+func (l *ResultsLog) Flush() error {
+	return l.w.Flush()
+}
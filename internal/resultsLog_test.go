@@ -0,0 +1,186 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestResultsLogSampling verifies that a seeded, uniform sample of the successful
+// responses is recorded, that failed responses are always recorded, and that the
+// sample rate is recorded in the header line.
+func TestResultsLogSampling(t *testing.T) {
+	numSuccesses, numFailures, sampleRate := 2000, 20, 0.1
+
+	writeLog := func(seed int64) []byte {
+		out := bytes.Buffer{}
+		l, err := NewResultsLog(&out, sampleRate, seed)
+		if err != nil {
+			t.Fatalf("unexpected error creating the results log: %s", err)
+		}
+		for i := 0; i < numSuccesses+numFailures; i++ {
+			resp := Response{
+				HTTPStatus:      http.StatusOK,
+				Endpoint:        api.Endpoint{URL: "http://someurl/1", Method: http.MethodGet},
+				RequestDuration: time.Millisecond,
+			}
+			if i%((numSuccesses+numFailures)/numFailures) == 0 {
+				resp.HTTPStatus = http.StatusInternalServerError
+			}
+			if err := l.Write(resp); err != nil {
+				t.Fatalf("unexpected error writing the results log: %s", err)
+			}
+		}
+		if err := l.Flush(); err != nil {
+			t.Fatalf("unexpected error flushing the results log: %s", err)
+		}
+		return out.Bytes()
+	}
+
+	results := writeLog(42)
+	scanner := bufio.NewScanner(bytes.NewReader(results))
+	if !scanner.Scan() {
+		t.Fatalf("expected a header line")
+	}
+	header := struct{ Header resultsLogHeader }{}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatalf("unable to unmarshal the header %q: %s", scanner.Text(), err)
+	}
+	if header.Header.SampleRate != sampleRate || header.Header.Seed != 42 {
+		t.Errorf("expected a header with a sample rate of %f and a seed of 42, got %+v", sampleRate, header.Header)
+	}
+
+	successes, failures := 0, 0
+	for scanner.Scan() {
+		record := resultRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("unable to unmarshal the record %q: %s", scanner.Text(), err)
+		}
+		if record.HTTPStatus == http.StatusOK {
+			successes++
+		} else {
+			failures++
+		}
+	}
+	if failures != numFailures {
+		t.Errorf("expected all %d failures to be recorded, got %d", numFailures, failures)
+	}
+	expected := int(float64(numSuccesses) * sampleRate)
+	if successes < expected/2 || successes > expected*3/2 {
+		t.Errorf("expected about %d sampled successes, got %d", expected, successes)
+	}
+
+	if !bytes.Equal(results, writeLog(42)) {
+		t.Errorf("expected the same seed to record the same responses")
+	}
+}
+
+// TestResultsLogSampleRate verifies that invalid sample rates are rejected.
+func TestResultsLogSampleRate(t *testing.T) {
+	for _, rate := range []float64{0, -0.5, 1.5} {
+		if _, err := NewResultsLog(&bytes.Buffer{}, rate, 1); err == nil {
+			t.Errorf("expected an error for a sample rate of %f", rate)
+		}
+	}
+}
+
+// writeResultsRun writes a run's results log, of a success and a failure to 'url', to
+// the results file at 'path'
+func writeResultsRun(t *testing.T, path string, appendTo bool, url string) {
+	f, err := OpenResultsFile(path, appendTo)
+	if err != nil {
+		t.Fatalf("unexpected error opening %s: %s", path, err)
+	}
+	defer f.Close()
+	l, err := NewResultsLog(f, 1, 1)
+	if err != nil {
+		t.Fatalf("unexpected error creating the results log: %s", err)
+	}
+	ep := api.Endpoint{URL: url, Method: http.MethodGet}
+	for _, resp := range []Response{
+		{Endpoint: ep, HTTPStatus: http.StatusOK, RequestDuration: time.Millisecond},
+		{Endpoint: ep, ErrCategory: api.ErrCategoryConnection, Err: errors.New("connection refused"),
+			Rqst: &sentRqst{EndpointURL: url, URL: url, Method: http.MethodGet}},
+	} {
+		if err := l.Write(resp); err != nil {
+			t.Fatalf("unexpected error writing the results log: %s", err)
+		}
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatalf("unexpected error flushing the results log: %s", err)
+	}
+}
+
+// TestResultsFileAppend verifies a run appending to a results file preserves the
+// records of the earlier runs, after removing an incomplete last record, and adds its
+// own header and records after them
+func TestResultsFileAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resultsLog")
+	if err != nil {
+		t.Fatalf("unable to create a temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.ndjson")
+
+	writeResultsRun(t, path, true, "http://someurl/first")
+	first, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", path, err)
+	}
+	// The first run was killed while writing a record
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("unable to open %s: %s", path, err)
+	}
+	f.WriteString(`{"URL":"http://someurl/first","Met`)
+	f.Close()
+
+	writeResultsRun(t, path, true, "http://someurl/second")
+	appended, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", path, err)
+	}
+	if !bytes.HasPrefix(appended, first) {
+		t.Fatalf("expected the first run's records to be preserved, got\n%s", appended)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(appended[len(first):]), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], `{"Header":`) || !strings.Contains(lines[1], "http://someurl/second") {
+		t.Errorf("expected the second run's header and 2 records to be appended, got\n%s", strings.Join(lines, "\n"))
+	}
+	records, err := readFailedRecords(bytes.NewReader(appended))
+	if err != nil {
+		t.Fatalf("unexpected error reading the failed records: %s", err)
+	}
+	if len(records) != 2 || records[0].URL != "http://someurl/first" || records[1].URL != "http://someurl/second" {
+		t.Errorf("expected the failures of both runs, got %+v", records)
+	}
+
+	// Without appending the file is replaced
+	writeResultsRun(t, path, false, "http://someurl/third")
+	if replaced, _ := ioutil.ReadFile(path); bytes.Contains(replaced, []byte("http://someurl/first")) {
+		t.Errorf("expected the results file to be replaced, got\n%s", replaced)
+	}
+
+	other := filepath.Join(dir, "other.json")
+	ioutil.WriteFile(other, []byte(`{"Endpoints": []}`+"\n"), 0644)
+	if _, err := OpenResultsFile(other, true); err == nil {
+		t.Errorf("expected an error appending to a file that isn't a results log")
+	}
+	if contents, _ := ioutil.ReadFile(other); string(contents) != `{"Endpoints": []}`+"\n" {
+		t.Errorf("expected the other file to be unchanged, got %q", contents)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// userAgents are the values of the api.RotatingHeaderPresetUserAgents preset
+var userAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+	"Mozilla/5.0 (iPad; CPU OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+	"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+	"Mozilla/5.0 (Linux; Android 13; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+	"curl/8.4.0",
+	"python-requests/2.31.0",
+	"Go-http-client/1.1",
+}
+
+// validateRotatingHeaders verifies that 'ep's RotatingHeaders, if it has any, are
+// valid and can be used with its other settings
+func validateRotatingHeaders(ep api.Endpoint) error {
+	if len(ep.RotatingHeaders) == 0 {
+		return nil
+	}
+	if ep.PipelineDepth > 1 || ep.KeepAliveProbe != nil || ep.Mode != "" || ep.OptimisticUpdate != nil {
+		return fmt.Errorf("endpoint %s %s has RotatingHeaders, it can't also have a PipelineDepth, a KeepAliveProbe, a Mode, or an OptimisticUpdate",
+			ep.Method, ep.URL)
+	}
+	for name, h := range ep.RotatingHeaders {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("endpoint %s %s has a RotatingHeader without a name", ep.Method, ep.URL)
+		}
+		if h.Order != "" && h.Order != api.RotationRoundRobin && h.Order != api.RotationRandom {
+			return fmt.Errorf("endpoint %s %s RotatingHeader %s has an Order of %q, it must be %s or %s",
+				ep.Method, ep.URL, name, h.Order, api.RotationRoundRobin, api.RotationRandom)
+		}
+		if h.Preset != "" && h.Preset != api.RotatingHeaderPresetUserAgents {
+			return fmt.Errorf("endpoint %s %s RotatingHeader %s has a Preset of %q, the only Preset is %s",
+				ep.Method, ep.URL, name, h.Preset, api.RotatingHeaderPresetUserAgents)
+		}
+		if len(h.Values) == 0 && h.File == "" && h.Preset == "" {
+			return fmt.Errorf("endpoint %s %s RotatingHeader %s doesn't have any Values, a File, or a Preset",
+				ep.Method, ep.URL, name)
+		}
+	}
+	return nil
+}
+
+// headerRotator chooses the values of one of an endpoint's RotatingHeaders
+type headerRotator struct {
+	name   string
+	values []string
+
+	mux sync.Mutex
+	// next is the index of the next api.RotationRoundRobin value
+	next int
+	// rnd, if set, chooses api.RotationRandom values
+	rnd *rand.Rand
+}
+
+// value returns the header value of the next request
+func (h *headerRotator) value() string {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.rnd != nil {
+		return h.values[h.rnd.Intn(len(h.values))]
+	}
+	v := h.values[h.next]
+	h.next = (h.next + 1) % len(h.values)
+	return v
+}
+
+// HeaderRotators chooses the values of the endpoints' RotatingHeaders. It's shared
+// by all requestors.
+type HeaderRotators struct {
+	// rotators are the rotators of each endpoint's RotatingHeaders, sorted by name,
+	// keyed by earlyFailKey. It's only modified by NewHeaderRotators so it doesn't
+	// need to be protected by a mutex.
+	rotators map[string][]*headerRotator
+}
+
+// NewHeaderRotators returns the HeaderRotators of 'eps', or nil if none of them have
+// RotatingHeaders. 'seed' seeds the api.RotationRandom headers without a Seed.
+func NewHeaderRotators(eps []api.Endpoint, seed int64) (*HeaderRotators, error) {
+	r := HeaderRotators{rotators: make(map[string][]*headerRotator)}
+	n := 0
+	for _, ep := range eps {
+		if err := validateRotatingHeaders(ep); err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(ep.RotatingHeaders))
+		for name := range ep.RotatingHeaders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var rotators []*headerRotator
+		for _, name := range names {
+			h := ep.RotatingHeaders[name]
+			values, err := rotatingHeaderValues(h)
+			if err != nil {
+				return nil, fmt.Errorf("endpoint %s %s RotatingHeader %s: %w", ep.Method, ep.URL, name, err)
+			}
+			rotator := &headerRotator{name: name, values: values}
+			n++
+			if h.Order == api.RotationRandom {
+				s := h.Seed
+				if s == 0 {
+					// Each header gets its own sequence of choices
+					s = seed + int64(n)
+				}
+				rotator.rnd = rand.New(rand.NewSource(s))
+			}
+			rotators = append(rotators, rotator)
+		}
+		if len(rotators) > 0 {
+			r.rotators[earlyFailKey(ep)] = rotators
+		}
+	}
+	if len(r.rotators) == 0 {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+// rotatingHeaderValues returns the values of 'h', its Values followed by those of
+// its File and its Preset
+func rotatingHeaderValues(h api.RotatingHeader) ([]string, error) {
+	values := append([]string(nil), h.Values...)
+	if h.File != "" {
+		b, err := ioutil.ReadFile(h.File)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the values file: %w", err)
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				values = append(values, line)
+			}
+		}
+	}
+	if h.Preset == api.RotatingHeaderPresetUserAgents {
+		values = append(values, userAgents...)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("there are no values to rotate through")
+	}
+	return values, nil
+}
+
+// rotate returns 'headers' with the next value of each of 'ep's RotatingHeaders,
+// replacing any header of the same name, along with the values chosen keyed by
+// header name. 'headers' isn't modified. 'r' may be nil.
+func (r *HeaderRotators) rotate(ep api.Endpoint, headers map[string]string) (map[string]string, map[string]string) {
+	if r == nil {
+		return headers, nil
+	}
+	rotators, ok := r.rotators[earlyFailKey(ep)]
+	if !ok {
+		return headers, nil
+	}
+	chosen := make(map[string]string, len(rotators))
+	for _, rotator := range rotators {
+		chosen[rotator.name] = rotator.value()
+	}
+	rotated := make(map[string]string, len(headers)+len(chosen))
+	for k, v := range headers {
+		if !rotatingHeaderNamed(chosen, k) {
+			rotated[k] = v
+		}
+	}
+	for name, v := range chosen {
+		rotated[name] = v
+	}
+	return rotated, chosen
+}
+
+// rotatingHeaderNamed returns true if one of the 'chosen' headers has the name 'k',
+// ignoring case
+func rotatingHeaderNamed(chosen map[string]string, k string) bool {
+	for name := range chosen {
+		if http.CanonicalHeaderKey(name) == http.CanonicalHeaderKey(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// accumulateRotatingHeaders adds 'resp' to the stats of each RotatingHeaders value
+// it was sent with in 'epDetail'. Abandoned requests never completed so, as
+// elsewhere, they aren't included.
+func accumulateRotatingHeaders(resp Response, epDetail *api.EndpointDetail) {
+	if len(resp.RotatedHeaders) == 0 || resp.AbandonedSlow {
+		return
+	}
+	if epDetail.ByRotatingHeader == nil {
+		epDetail.ByRotatingHeader = make(map[string]map[string]*api.HeaderValueStats)
+	}
+	for name, value := range resp.RotatedHeaders {
+		byValue, ok := epDetail.ByRotatingHeader[name]
+		if !ok {
+			byValue = make(map[string]*api.HeaderValueStats)
+			epDetail.ByRotatingHeader[name] = byValue
+		}
+		addHeaderValueRqst(byValue, value, resp)
+	}
+}
+
+// finishRotatingHeaders calculates the averages of 'epDetail's ByRotatingHeader stats
+func finishRotatingHeaders(epDetail *api.EndpointDetail) {
+	for _, byValue := range epDetail.ByRotatingHeader {
+		finishHeaderValueStats(byValue)
+	}
+}
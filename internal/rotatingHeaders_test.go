@@ -0,0 +1,183 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestValidateRotatingHeaders(t *testing.T) {
+	values := api.RotatingHeader{Values: []string{"control", "variantA"}}
+	tests := []struct {
+		name    string
+		ep      api.Endpoint
+		wantErr bool
+	}{
+		{name: "none", ep: api.Endpoint{}},
+		{name: "values", ep: api.Endpoint{RotatingHeaders: map[string]api.RotatingHeader{"X-Experiment": values}}},
+		{name: "preset", ep: api.Endpoint{RotatingHeaders: map[string]api.RotatingHeader{
+			"User-Agent": {Preset: api.RotatingHeaderPresetUserAgents, Order: api.RotationRandom}}}},
+		{name: "no values", ep: api.Endpoint{RotatingHeaders: map[string]api.RotatingHeader{"X-Experiment": {}}}, wantErr: true},
+		{name: "no name", ep: api.Endpoint{RotatingHeaders: map[string]api.RotatingHeader{" ": values}}, wantErr: true},
+		{name: "unknown order", ep: api.Endpoint{RotatingHeaders: map[string]api.RotatingHeader{
+			"X-Experiment": {Values: values.Values, Order: "Shuffled"}}}, wantErr: true},
+		{name: "unknown preset", ep: api.Endpoint{RotatingHeaders: map[string]api.RotatingHeader{
+			"User-Agent": {Preset: "Bots"}}}, wantErr: true},
+		{name: "pipelined", ep: api.Endpoint{RotatingHeaders: map[string]api.RotatingHeader{"X-Experiment": values}, PipelineDepth: 2}, wantErr: true},
+		{name: "SSE", ep: api.Endpoint{RotatingHeaders: map[string]api.RotatingHeader{"X-Experiment": values}, Mode: api.EndpointModeSSE}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.ep.URL, tc.ep.Method = "http://someurl/items", http.MethodGet
+			if err := validateRotatingHeaders(tc.ep); (err != nil) != tc.wantErr {
+				t.Errorf("expected an error to be %t, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestHeaderRotators verifies the values of a RotatingHeader are chosen in turn, or
+// repeatably at random, from its Values, File, and Preset, and that they replace the
+// endpoint's header of the same name
+func TestHeaderRotators(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotatingHeaders")
+	if err != nil {
+		t.Fatalf("unable to create a temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "experiments.txt")
+	if err := ioutil.WriteFile(file, []byte("variantA\n\n  variantB  \n"), 0644); err != nil {
+		t.Fatalf("unable to write %s: %s", file, err)
+	}
+
+	ep := api.Endpoint{
+		URL:     "http://someurl/items",
+		Method:  http.MethodGet,
+		Headers: map[string]string{"x-experiment": "ignored", "Accept": "application/json"},
+		RotatingHeaders: map[string]api.RotatingHeader{
+			"X-Experiment": {Values: []string{"control"}, File: file},
+			"User-Agent":   {Preset: api.RotatingHeaderPresetUserAgents, Order: api.RotationRandom, Seed: 7},
+		},
+	}
+	rotators, err := NewHeaderRotators([]api.Endpoint{ep, {URL: "http://someurl/health", Method: http.MethodGet}}, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var experiments, agents []string
+	for i := 0; i < 4; i++ {
+		headers, chosen := rotators.rotate(ep, ep.Headers)
+		expected := map[string]string{"Accept": "application/json", "X-Experiment": chosen["X-Experiment"], "User-Agent": chosen["User-Agent"]}
+		if !reflect.DeepEqual(headers, expected) {
+			t.Errorf("expected the headers %v, got %v", expected, headers)
+		}
+		experiments = append(experiments, chosen["X-Experiment"])
+		agents = append(agents, chosen["User-Agent"])
+	}
+	if expected := []string{"control", "variantA", "variantB", "control"}; !reflect.DeepEqual(experiments, expected) {
+		t.Errorf("expected the values %v in turn, got %v", expected, experiments)
+	}
+	if len(ep.Headers) != 2 || ep.Headers["x-experiment"] != "ignored" {
+		t.Errorf("expected the endpoint's headers to be unchanged, got %v", ep.Headers)
+	}
+
+	// The same Seed chooses the same values
+	again, err := NewHeaderRotators([]api.Endpoint{ep}, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i, agent := range agents {
+		if _, chosen := again.rotate(ep, nil); chosen["User-Agent"] != agent {
+			t.Errorf("expected User-Agent %d to be %q again, got %q", i, agent, chosen["User-Agent"])
+		}
+	}
+
+	if headers, chosen := rotators.rotate(api.Endpoint{URL: "http://someurl/health", Method: http.MethodGet}, ep.Headers); chosen != nil ||
+		!reflect.DeepEqual(headers, ep.Headers) {
+		t.Errorf("expected an endpoint without RotatingHeaders to be unchanged, got %v, %v", headers, chosen)
+	}
+	if none, err := NewHeaderRotators([]api.Endpoint{{URL: "http://someurl/health"}}, 1); none != nil || err != nil {
+		t.Errorf("expected no HeaderRotators without RotatingHeaders, got %v, %v", none, err)
+	}
+	ep.RotatingHeaders = map[string]api.RotatingHeader{"X-Experiment": {File: filepath.Join(dir, "missing.txt")}}
+	if _, err := NewHeaderRotators([]api.Endpoint{ep}, 1); err == nil {
+		t.Errorf("expected an error for a missing values file")
+	}
+}
+
+// TestRotatingHeaderStats verifies an endpoint's stats are broken down by the value
+// of its RotatingHeaders each request was sent with, up to MaxGroupByHeaderValues
+// values
+func TestRotatingHeaderStats(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Experiment") == "variantB" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer testSrv.Close()
+
+	var ids []string
+	for i := 0; i < api.MaxGroupByHeaderValues+5; i++ {
+		ids = append(ids, strconv.Itoa(i))
+	}
+	ep := api.Endpoint{
+		URL:    testSrv.URL,
+		Method: http.MethodGet,
+		RotatingHeaders: map[string]api.RotatingHeader{
+			"X-Experiment": {Values: []string{"control", "variantA", "variantB"}},
+			"X-Client-ID":  {Values: ids},
+		},
+	}
+	rotators, err := NewHeaderRotators([]api.Endpoint{ep}, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	numRqsts := 30
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}, HeaderRotators: rotators}
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	byExperiment := runResults.EndpointDetails[ep.URL].ByRotatingHeader["X-Experiment"]
+	if len(byExperiment) != 3 {
+		t.Fatalf("expected 3 X-Experiment values, got %v", byExperiment)
+	}
+	for _, value := range []string{"control", "variantA", "variantB"} {
+		stats := byExperiment[value]
+		if stats == nil || stats.TotalRqsts != 10 || stats.AvgRqstDurationNanos <= 0 {
+			t.Errorf("expected 10 requests with an average latency for %s, got %+v", value, stats)
+			continue
+		}
+		if expected := map[bool]int64{true: 10}[value == "variantB"]; stats.ServerErrors != expected {
+			t.Errorf("expected %d 5xx responses for %s, got %d", expected, value, stats.ServerErrors)
+		}
+	}
+
+	byClientID := runResults.EndpointDetails[ep.URL].ByRotatingHeader["X-Client-ID"]
+	if len(byClientID) != api.MaxGroupByHeaderValues+1 || byClientID[api.GroupByHeaderOther].TotalRqsts != 5 {
+		t.Errorf("expected %d X-Client-ID values and 5 other requests, got %d values", api.MaxGroupByHeaderValues, len(byClientID))
+	}
+}
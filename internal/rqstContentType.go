@@ -0,0 +1,52 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// The Content-Types set on requests whose body is detected by sniffRqstContentType
+const (
+	rqstContentTypeJSON = "application/json"
+	rqstContentTypeXML  = "application/xml"
+	rqstContentTypeForm = "application/x-www-form-urlencoded"
+)
+
+// sniffRqstContentType returns the Content-Type of the request body 'body', JSON, XML,
+// or a URL encoded form, or an empty string if it's none of them
+func sniffRqstContentType(body string) string {
+	trimmed := strings.TrimSpace(body)
+	switch {
+	case trimmed == "":
+		return ""
+	case (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid([]byte(trimmed)):
+		return rqstContentTypeJSON
+	case trimmed[0] == '<' && trimmed[len(trimmed)-1] == '>':
+		return rqstContentTypeXML
+	case isFormBody(trimmed):
+		return rqstContentTypeForm
+	}
+	return ""
+}
+
+// isFormBody returns true if 'body' is a URL encoded form, e.g., 'name=Brian&role=1'
+func isFormBody(body string) bool {
+	if strings.ContainsAny(body, " \t\r\n") {
+		return false
+	}
+	values, err := url.ParseQuery(body)
+	if err != nil || len(values) == 0 {
+		return false
+	}
+	for _, pair := range strings.Split(body, "&") {
+		if !strings.Contains(pair, "=") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestSniffRqstContentType(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{body: "", expected: ""},
+		{body: `{"accountid":1,"name":"Brian Wilson"}`, expected: rqstContentTypeJSON},
+		{body: ` [1, 2, 3] `, expected: rqstContentTypeJSON},
+		{body: `<?xml version="1.0"?><account id="1"/>`, expected: rqstContentTypeXML},
+		{body: "name=Brian+Wilson&role=1", expected: rqstContentTypeForm},
+		{body: "token=", expected: rqstContentTypeForm},
+		{body: `{"unterminated": `, expected: ""},
+		{body: "just some text", expected: ""},
+		{body: "a=1&b", expected: ""},
+	}
+	for _, tc := range tests {
+		if contentType := sniffRqstContentType(tc.body); contentType != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.body, tc.expected, contentType)
+		}
+	}
+}
+
+// TestRqstContentType verifies requests with a body are sent with the Content-Type it
+// looks like unless the endpoint sets one
+func TestRqstContentType(t *testing.T) {
+	received := make(chan []string, 1)
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Values("Content-Type")
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name     string
+		body     string
+		headers  map[string]string
+		expected []string
+	}{
+		{name: "JSON", body: `{"name":"Brian Wilson"}`, expected: []string{rqstContentTypeJSON}},
+		{name: "form", body: "name=Brian+Wilson&role=1", expected: []string{rqstContentTypeForm}},
+		{name: "override", body: `{"name":"Brian Wilson"}`, headers: map[string]string{"content-type": "application/vnd.api+json"},
+			expected: []string{"application/vnd.api+json"}},
+		{name: "no body"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodPost, RqstBody: tc.body, Headers: tc.headers}
+			respC := make(chan Response, 1)
+			rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}}
+			rqstr.ProcessRqst(ep, 1, 0)
+
+			contentType := <-received
+			if len(contentType) != len(tc.expected) || (len(contentType) > 0 && contentType[0] != tc.expected[0]) {
+				t.Errorf("expected the Content-Type %v, got %v", tc.expected, contentType)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// traceTimes are the timings and connection details of a request recorded by a
+// rqstTrace
+type traceTimes struct {
+	dnsStart, dnsDone, getConn, connectStart, gotConn time.Time
+	wroteRqst, gotResp, tlsStart, tlsDone             time.Time
+	// connWaitEnd is when the request stopped waiting for the pool, i.e., when it
+	// started to set up a connection or got one
+	connWaitEnd time.Time
+	// connSetup is the time it took to set up a new connection, 0 if the request
+	// reused one
+	connSetup time.Duration
+	reused    bool
+	// warm is true if the connection was opened by the WarmPool
+	warm bool
+	ip   string
+	// headers are the sizes of the headers written
+	headers headerSizes
+}
+
+// endConnWait records 'at' as the end of the wait for the pool, unless it already
+// ended
+func (t *traceTimes) endConnWait(at time.Time) {
+	if t.connWaitEnd.IsZero() {
+		t.connWaitEnd = at
+	}
+}
+
+// rqstTrace records the traceTimes of each of a worker's requests. Its httptrace
+// callbacks run on the transport's goroutines, e.g., the dial's callbacks run on the
+// dialing goroutine while GotConn runs on the request's, and a dial may still be
+// running after the request got another connection. So the times are guarded by mu
+// and only read through snapshot.
+type rqstTrace struct {
+	warmPool    *WarmPool
+	headerStats bool
+
+	mu    sync.Mutex
+	times traceTimes
+}
+
+// clientTrace returns the httptrace callbacks recording the requests' times
+func (rt *rqstTrace) clientTrace() *httptrace.ClientTrace {
+	setNow := func(t *time.Time) {
+		rt.mu.Lock()
+		*t = time.Now()
+		rt.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(_ httptrace.DNSStartInfo) {
+			rt.mu.Lock()
+			defer rt.mu.Unlock()
+			rt.times.dnsStart = time.Now()
+			rt.times.endConnWait(rt.times.dnsStart)
+		},
+		DNSDone: func(_ httptrace.DNSDoneInfo) { setNow(&rt.times.dnsDone) },
+		GetConn: func(_ string) {
+			rt.mu.Lock()
+			defer rt.mu.Unlock()
+			t := &rt.times
+			t.getConn, t.connectStart, t.connSetup, t.connWaitEnd = time.Now(), time.Time{}, 0, time.Time{}
+		},
+		// There may be several connection attempts, e.g., to each of a host's IPs,
+		// the setup time includes all of them
+		ConnectStart: func(_, _ string) {
+			rt.mu.Lock()
+			defer rt.mu.Unlock()
+			if rt.times.connectStart.IsZero() {
+				rt.times.connectStart = time.Now()
+				rt.times.endConnWait(rt.times.connectStart)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			warm := rt.warmPool.used(info.Conn)
+			var ip string
+			if info.Conn != nil {
+				ip = remoteIP(info.Conn.RemoteAddr())
+			}
+
+			rt.mu.Lock()
+			defer rt.mu.Unlock()
+			t := &rt.times
+			t.gotConn, t.reused, t.warm, t.ip = time.Now(), info.Reused, warm, ip
+			t.endConnWait(t.gotConn)
+			if !info.Reused && !t.connectStart.IsZero() {
+				t.connSetup = t.gotConn.Sub(t.connectStart)
+			}
+		},
+		WroteHeaderField: func(key string, values []string) {
+			if !rt.headerStats {
+				return
+			}
+			rt.mu.Lock()
+			rt.times.headers.wroteHeader(key, values)
+			rt.mu.Unlock()
+		},
+		WroteRequest:         func(_ httptrace.WroteRequestInfo) { setNow(&rt.times.wroteRqst) },
+		GotFirstResponseByte: func() { setNow(&rt.times.gotResp) },
+		TLSHandshakeStart:    func() { setNow(&rt.times.tlsStart) },
+		TLSHandshakeDone:     func(_ tls.ConnectionState, _ error) { setNow(&rt.times.tlsDone) },
+	}
+}
+
+// startRqst resets the times that are only recorded for the request about to be
+// sent, rather than carried over from the last request on the same connection
+func (rt *rqstTrace) startRqst() {
+	rt.mu.Lock()
+	rt.times.headers = headerSizes{}
+	rt.mu.Unlock()
+}
+
+// snapshot returns the times recorded so far
+func (rt *rqstTrace) snapshot() traceTimes {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.times
+}
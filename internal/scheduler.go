@@ -16,7 +16,7 @@ import (
 
 // IRequestor declares the functionality needed to make requests to an endpoint
 type IRequestor interface {
-	ProcessRqst(ep api.Endpoint, numRqsts int, rqstRate int)
+	ProcessRqst(ep api.Endpoint, numRqsts int, rqstRate float64)
 	ResponseChan() chan Response
 }
 
@@ -27,7 +27,7 @@ type Scheduler struct {
 	// running requests
 	concurrency int
 	// rqstRate is the desired overall requests per second
-	rqstRate int
+	rqstRate float64
 	// runDur is how long the test will run. It can be expressed
 	// in seconds or minutes as xs or xm where x is an integer (e.g.,
 	// 10s for 10 seconds, 5m for 5 minutes). If both numRqsts and
@@ -45,10 +45,13 @@ type Scheduler struct {
 	endpoints []api.Endpoint
 	// rqstr is responsible for making client requests to endpoints
 	rqstr IRequestor
+	// workerBudget, if greater than 0, is the total number of requests split
+	// equally between the workers, see ShareBudget
+	workerBudget int64
 }
 
 // NewScheduler returns a valid Scheduler instance
-func NewScheduler(concurrency int, rate int, runDur time.Duration, numRqsts int,
+func NewScheduler(concurrency int, rate float64, runDur time.Duration, numRqsts int,
 	eps []api.Endpoint, rqstr IRequestor) (*Scheduler, error) {
 
 	err := validateConfig(concurrency, rate, runDur, numRqsts, eps)
@@ -69,18 +72,79 @@ func NewScheduler(concurrency int, rate int, runDur time.Duration, numRqsts int,
 	return &schedlr, nil
 }
 
+// ShareBudget splits 'budget's MaxTotalRequests equally between the workers, the
+// goroutines making each endpoint's requests, if it's configured PerWorker. Otherwise a
+// fast worker could make most of the budget's requests, biasing which requests
+// complete. 'budget' may be nil.
+func (s *Scheduler) ShareBudget(budget *api.Budget) {
+	if budget != nil && budget.PerWorker {
+		s.workerBudget = budget.MaxTotalRequests
+	}
+}
+
+// workerShare returns the number of requests of the workerBudget made by 'worker',
+// one of 'numWorkers' workers. The budget's remainder is made by the first workers.
+func (s Scheduler) workerShare(worker, numWorkers int) int {
+	share := s.workerBudget / int64(numWorkers)
+	if int64(worker) < s.workerBudget%int64(numWorkers) {
+		share++
+	}
+	return int(share)
+}
+
+// epConfig is the configuration of the workers making an endpoint's requests, see
+// calcEPConfig
+type epConfig struct {
+	numRqstsPerGoroutine int
+	epConcurrency        int
+	goroutineRqstRate    float64
+}
+
 // Start begins the scheduling process
 func (s Scheduler) Start() error {
 	var wg sync.WaitGroup
 
-	for _, ep := range s.endpoints {
+	configs := make([]epConfig, len(s.endpoints))
+	numWorkers := 0
+	for i, ep := range s.endpoints {
+		if ep.KeepAliveProbe != nil {
+			continue
+		}
+		c := &configs[i]
+		c.numRqstsPerGoroutine, c.epConcurrency, c.goroutineRqstRate = s.calcEPConfig(ep)
+		numWorkers += c.epConcurrency
+	}
+
+	worker := 0
+	for epIdx, ep := range s.endpoints {
 		ep := ep
-		numRqstsPerGoroutine, epConcurrency, goroutineRqstRate := s.calcEPConfig(ep)
+		if ep.KeepAliveProbe != nil {
+			wg.Add(1)
+			go func() {
+				s.rqstr.ProcessRqst(ep, 0, 0)
+				wg.Done()
+			}()
+			continue
+		}
+		epConcurrency, goroutineRqstRate := configs[epIdx].epConcurrency, configs[epIdx].goroutineRqstRate
 		for i := 0; i < epConcurrency; i++ {
+			numRqstsPerGoroutine := configs[epIdx].numRqstsPerGoroutine
+			if s.workerBudget > 0 {
+				// ProcessRqst treats 0 requests as no limit, so a worker without a share
+				// isn't started
+				share := s.workerShare(worker, numWorkers)
+				worker++
+				if share == 0 {
+					continue
+				}
+				if numRqstsPerGoroutine == 0 || numRqstsPerGoroutine > share {
+					numRqstsPerGoroutine = share
+				}
+			}
 			wg.Add(1)
 			go func() {
 
-				log.Debug().Msgf("Starting Endpoint Goroutine for EP: %s numRqsts: %d, runDur: %d, and rqstRate: %d", ep.URL,
+				log.Debug().Msgf("Starting Endpoint Goroutine for EP: %s numRqsts: %d, runDur: %d, and rqstRate: %v", ep.URL,
 					numRqstsPerGoroutine, s.runDur/time.Second, goroutineRqstRate)
 
 				s.rqstr.ProcessRqst(ep, numRqstsPerGoroutine, goroutineRqstRate)
@@ -95,7 +159,7 @@ func (s Scheduler) Start() error {
 	return nil
 }
 
-func (s Scheduler) calcEPConfig(ep api.Endpoint) (numRqstsPerGoroutine int, numEPGoroutines int, epGoroutineRqstRate int) {
+func (s Scheduler) calcEPConfig(ep api.Endpoint) (numRqstsPerGoroutine int, numEPGoroutines int, epGoroutineRqstRate float64) {
 	numEPGoroutines = int(math.Ceil(float64(s.concurrency) * (float64(ep.RqstPercent) / float64(100))))
 	if numEPGoroutines != int(float64(s.concurrency)*(float64(ep.RqstPercent)/float64(100))) {
 		log.Warn().Msgf("EP: %s: epConcurrency, %d, was rounded up. The calcuation result was %f", ep.URL, numEPGoroutines,
@@ -114,21 +178,80 @@ func (s Scheduler) calcEPConfig(ep api.Endpoint) (numRqstsPerGoroutine int, numE
 			(float64(numEPRqsts) / float64(numEPGoroutines)))
 	}
 
-	epRqstRate := int(math.Ceil(float64(s.rqstRate) * (float64(ep.RqstPercent) / float64(100))))
-	if epRqstRate != int(float64(s.rqstRate)*(float64(ep.RqstPercent)/float64(100))) {
-		log.Warn().Msgf("EP: %s: epRqstRate, %d, was rounded up. The calculation result was %f", ep.URL, epRqstRate,
-			float64(s.concurrency)*(float64(ep.RqstPercent)/float64(100)))
+	epRqstRate := roundUpRate(s.rqstRate * (float64(ep.RqstPercent) / float64(100)))
+	if epRqstRate != s.rqstRate*(float64(ep.RqstPercent)/float64(100)) {
+		log.Warn().Msgf("EP: %s: epRqstRate, %v, was rounded up. The calculation result was %f", ep.URL, epRqstRate,
+			s.rqstRate*(float64(ep.RqstPercent)/float64(100)))
 	}
 
-	epGoroutineRqstRate = int(math.Ceil((float64(epRqstRate) / float64(numEPGoroutines))))
-	if epGoroutineRqstRate != int((float64(epRqstRate) / float64(numEPGoroutines))) {
-		log.Warn().Msgf("EP: %s: epGoroutineRqstRate, %d, was rounded up. The calculation result was %f", ep.URL,
-			epGoroutineRqstRate, (float64(epRqstRate) / float64(numEPGoroutines)))
+	epGoroutineRqstRate = roundUpRate(epRqstRate / float64(numEPGoroutines))
+	if epGoroutineRqstRate != epRqstRate/float64(numEPGoroutines) {
+		log.Warn().Msgf("EP: %s: epGoroutineRqstRate, %v, was rounded up. The calculation result was %f", ep.URL,
+			epGoroutineRqstRate, epRqstRate/float64(numEPGoroutines))
 	}
 	return numRqstsPerGoroutine, numEPGoroutines, epGoroutineRqstRate
 }
 
-func validateConfig(concurrency int, rate int, runDur time.Duration, numRqsts int, eps []api.Endpoint) error {
+// roundUpRate rounds 'rate', in requests per second, up to a whole number of requests
+// per second. Rates below 1 request per second, e.g., those given per hour, aren't
+// rounded since that would multiply them.
+func roundUpRate(rate float64) float64 {
+	if rate < 1 {
+		return rate
+	}
+	return math.Ceil(rate)
+}
+
+// rqstInterval returns the time between the requests of a worker making 'rqstRate'
+// requests per second
+func rqstInterval(rqstRate float64) time.Duration {
+	return time.Duration(float64(time.Second) / rqstRate)
+}
+
+// validateVariants verifies that 'ep's variants are named uniquely and can be used
+// with its other settings
+func validateVariants(ep api.Endpoint) error {
+	if len(ep.Variants) == 0 {
+		return nil
+	}
+	if ep.PipelineDepth > 1 {
+		return fmt.Errorf("endpoint %s %s has Variants and a PipelineDepth of %d, pipelined endpoints can't have variants",
+			ep.Method, ep.URL, ep.PipelineDepth)
+	}
+	names := make(map[string]bool, len(ep.Variants))
+	for _, v := range ep.Variants {
+		if v.Name == "" {
+			return fmt.Errorf("endpoint %s %s has a variant without a Name", ep.Method, ep.URL)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("endpoint %s %s has more than one variant named %s", ep.Method, ep.URL, v.Name)
+		}
+		names[v.Name] = true
+	}
+	return nil
+}
+
+func validateConfig(concurrency int, rate float64, runDur time.Duration, numRqsts int, eps []api.Endpoint) error {
+	// Keep-alive probes aren't part of the load, so the load is validated without them
+	var probes []api.Endpoint
+	loadEPs := make([]api.Endpoint, 0, len(eps))
+	for _, ep := range eps {
+		if ep.KeepAliveProbe != nil {
+			probes = append(probes, ep)
+			continue
+		}
+		loadEPs = append(loadEPs, ep)
+	}
+	for _, ep := range probes {
+		if err := validateKeepAliveProbe(ep); err != nil {
+			return err
+		}
+	}
+	if len(loadEPs) == 0 && len(probes) > 0 {
+		return nil
+	}
+	eps = loadEPs
+
 	if numRqsts > 0 && runDur > 0 {
 		return fmt.Errorf("number of requests is %d and requested duration is %s, one must be zero",
 			numRqsts, runDur)
@@ -146,6 +269,71 @@ func validateConfig(concurrency int, rate int, runDur time.Duration, numRqsts in
 	rqstPct := 0
 	for _, ep := range eps {
 		rqstPct += ep.RqstPercent
+		if ep.EarlyFailThreshold != nil && *ep.EarlyFailThreshold < 0 {
+			return fmt.Errorf("endpoint %s %s has an EarlyFailThreshold of %d, it must not be negative",
+				ep.Method, ep.URL, *ep.EarlyFailThreshold)
+		}
+		if err := validateVariants(ep); err != nil {
+			return err
+		}
+		if ep.PipelineDepth > 1 && ep.LockGroup != "" {
+			return fmt.Errorf("endpoint %s %s has a PipelineDepth of %d and a LockGroup, pipelined endpoints can't be in a lock group",
+				ep.Method, ep.URL, ep.PipelineDepth)
+		}
+		if ep.PipelineDepth > 1 && ep.SuccessJSONPath != "" {
+			return fmt.Errorf("endpoint %s %s has a PipelineDepth of %d and a SuccessJSONPath, pipelined responses can't be checked",
+				ep.Method, ep.URL, ep.PipelineDepth)
+		}
+		if ep.PipelineDepth > 1 && ep.RateLimit > 0 {
+			return fmt.Errorf("endpoint %s %s has a PipelineDepth of %d and a RateLimit, pipelined endpoints can't be rate limited",
+				ep.Method, ep.URL, ep.PipelineDepth)
+		}
+		if ep.PipelineDepth > 1 && ep.SuccessExpr != "" {
+			return fmt.Errorf("endpoint %s %s has a PipelineDepth of %d and a SuccessExpr, pipelined responses can't be checked",
+				ep.Method, ep.URL, ep.PipelineDepth)
+		}
+		if ep.PipelineDepth > 1 && ep.ExpectedSHA256 != "" {
+			return fmt.Errorf("endpoint %s %s has a PipelineDepth of %d and an ExpectedSHA256, pipelined responses can't be checked",
+				ep.Method, ep.URL, ep.PipelineDepth)
+		}
+		if ep.PipelineDepth > 1 && ep.ExpectedContentType != "" {
+			return fmt.Errorf("endpoint %s %s has a PipelineDepth of %d and an ExpectedContentType, pipelined responses can't be checked",
+				ep.Method, ep.URL, ep.PipelineDepth)
+		}
+		if ep.PipelineDepth > 1 && ep.ExpectedOutcome != nil {
+			return fmt.Errorf("endpoint %s %s has a PipelineDepth of %d and an ExpectedOutcome, pipelined responses can't be checked",
+				ep.Method, ep.URL, ep.PipelineDepth)
+		}
+		if err := validateExpectedSHA256(ep); err != nil {
+			return err
+		}
+		if err := validateExpectedOutcome(ep); err != nil {
+			return err
+		}
+		if err := validateExpectedContentType(ep); err != nil {
+			return err
+		}
+		if err := validateProtocolVersion(ep); err != nil {
+			return err
+		}
+		if err := validateSSE(ep); err != nil {
+			return err
+		}
+		if err := validateOptimisticUpdate(ep); err != nil {
+			return err
+		}
+		if err := validateRotatingHeaders(ep); err != nil {
+			return err
+		}
+		if ep.SuccessExpr != "" {
+			if _, err := compileSuccessExpr(ep.SuccessExpr); err != nil {
+				return fmt.Errorf("endpoint %s %s: %w", ep.Method, ep.URL, err)
+			}
+		}
+		if ep.PipelineDepth > 1 && (!isPipelineable(ep.Method) || len(ep.RqstBody) > 0) {
+			return fmt.Errorf("endpoint %s %s has a PipelineDepth of %d, only GET and HEAD requests without a body can be pipelined",
+				ep.Method, ep.URL, ep.PipelineDepth)
+		}
 	}
 	if rqstPct != 100 {
 		return fmt.Errorf("endpoint.RqstPercents must add up to 100 not %d", rqstPct)
@@ -7,6 +7,7 @@ package internal
 import (
 	"flag"
 	"os"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -25,7 +26,7 @@ type MockRequestor struct {
 	mux               *sync.Mutex
 }
 
-func (r *MockRequestor) ProcessRqst(ep api.Endpoint, numRqsts int, rqstRate int) {
+func (r *MockRequestor) ProcessRqst(ep api.Endpoint, numRqsts int, rqstRate float64) {
 	r.mux.Lock()
 	r.actualNumRqstrs += numRqsts
 	r.mux.Unlock()
@@ -38,7 +39,7 @@ func (r *MockRequestor) ResponseChan() chan Response {
 type expectedEPCalcs struct {
 	xnumRqstsPerGoroutine int
 	xepConcurrecy         int
-	xgoroutineRqstRate    int
+	xgoroutineRqstRate    float64
 }
 
 func TestCalcEPConfig(t *testing.T) {
@@ -46,7 +47,7 @@ func TestCalcEPConfig(t *testing.T) {
 		name             string
 		eps              []api.Endpoint
 		schedConcurrency int
-		schedRqstRate    int
+		schedRqstRate    float64
 		schedNumRqsts    int
 		xEPCalcs         []expectedEPCalcs
 	}{
@@ -225,7 +226,7 @@ func TestCalcEPConfig(t *testing.T) {
 				if numRqstsPerGoroutine != tc.xEPCalcs[i].xnumRqstsPerGoroutine ||
 					epConcurrency != tc.xEPCalcs[i].xepConcurrecy ||
 					goroutineRqstRate != tc.xEPCalcs[i].xgoroutineRqstRate {
-					t.Errorf("expected %d, %d, and %d, got %d, %d, and %d",
+					t.Errorf("expected %d, %d, and %v, got %d, %d, and %v",
 						tc.xEPCalcs[i].xnumRqstsPerGoroutine, tc.xEPCalcs[i].xepConcurrecy, tc.xEPCalcs[i].xgoroutineRqstRate,
 						numRqstsPerGoroutine, epConcurrency, goroutineRqstRate)
 				}
@@ -238,14 +239,14 @@ func TestValidation(t *testing.T) {
 	zerolog.SetGlobalLevel(zerolog.Level(*debugLevel))
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
 
-	goFastRate := 100
+	goFastRate := 100.0
 	// goSlowRate := 1
 	url1 := "http://somewhere.com/1"
 	url2 := "http://somewhere.com/2"
 
 	tests := []struct {
 		name        string
-		rqstRate    int
+		rqstRate    float64
 		runDur      string
 		numRqsts    int
 		concurrency int
@@ -457,6 +458,51 @@ func TestValidation(t *testing.T) {
 			},
 			shouldFail: true,
 		},
+		{
+			name:        "SuccessPath - EP Variants with unique names",
+			numRqsts:    100,
+			concurrency: 10,
+			runDur:      "0s",
+			eps: []api.Endpoint{
+				{
+					URL:         url1,
+					Method:      "GET",
+					RqstPercent: 100,
+					Variants:    []api.EndpointVariant{{Name: "http"}, {Name: "https", Scheme: "https"}},
+				},
+			},
+			shouldFail: false,
+		},
+		{
+			name:        "FailPath - EP Variant names must be unique",
+			numRqsts:    100,
+			concurrency: 10,
+			runDur:      "0s",
+			eps: []api.Endpoint{
+				{
+					URL:         url1,
+					Method:      "GET",
+					RqstPercent: 100,
+					Variants:    []api.EndpointVariant{{Name: "tls", Port: "443"}, {Name: "tls", Port: "8443"}},
+				},
+			},
+			shouldFail: true,
+		},
+		{
+			name:        "FailPath - EP Variants must be named",
+			numRqsts:    100,
+			concurrency: 10,
+			runDur:      "0s",
+			eps: []api.Endpoint{
+				{
+					URL:         url1,
+					Method:      "GET",
+					RqstPercent: 100,
+					Variants:    []api.EndpointVariant{{Port: "8080"}},
+				},
+			},
+			shouldFail: true,
+		},
 	}
 
 	for _, tc := range tests {
@@ -516,3 +562,91 @@ func TestRqstrInteractions(t *testing.T) {
 		t.Errorf("expected %d requests, got %d", rqstr.expectedNumRqstrs, rqstr.actualNumRqstrs)
 	}
 }
+
+// recordingRequestor records the number of requests of each call to ProcessRqst
+type recordingRequestor struct {
+	responseC chan Response
+	mux       sync.Mutex
+	numRqsts  map[string][]int
+}
+
+func (r *recordingRequestor) ProcessRqst(ep api.Endpoint, numRqsts int, rqstRate float64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.numRqsts[ep.URL] = append(r.numRqsts[ep.URL], numRqsts)
+}
+
+func (r *recordingRequestor) ResponseChan() chan Response {
+	return r.responseC
+}
+
+// TestShareBudget verifies a Budget split PerWorker gives each worker an equal share of
+// its MaxTotalRequests
+func TestShareBudget(t *testing.T) {
+	eps := []api.Endpoint{
+		{URL: "fast", RqstPercent: 50},
+		{URL: "slow", RqstPercent: 50},
+	}
+	tests := []struct {
+		name     string
+		budget   *api.Budget
+		numRqsts int
+		expected map[string][]int
+	}{
+		{
+			name:     "no budget",
+			numRqsts: 40,
+			expected: map[string][]int{"fast": {10, 10}, "slow": {10, 10}},
+		},
+		{
+			name:     "shared budget",
+			budget:   &api.Budget{MaxTotalRequests: 10},
+			numRqsts: 40,
+			expected: map[string][]int{"fast": {10, 10}, "slow": {10, 10}},
+		},
+		{
+			name:     "remainder",
+			budget:   &api.Budget{MaxTotalRequests: 10, PerWorker: true},
+			numRqsts: 40,
+			expected: map[string][]int{"fast": {3, 3}, "slow": {2, 2}},
+		},
+		{
+			name:     "unlimited requests",
+			budget:   &api.Budget{MaxTotalRequests: 8, PerWorker: true},
+			expected: map[string][]int{"fast": {2, 2}, "slow": {2, 2}},
+		},
+		{
+			name:     "fewer requests than the share",
+			budget:   &api.Budget{MaxTotalRequests: 400, PerWorker: true},
+			numRqsts: 40,
+			expected: map[string][]int{"fast": {10, 10}, "slow": {10, 10}},
+		},
+		{
+			name:     "less than a request per worker",
+			budget:   &api.Budget{MaxTotalRequests: 3, PerWorker: true},
+			numRqsts: 40,
+			expected: map[string][]int{"fast": {1, 1}, "slow": {1}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rqstr := &recordingRequestor{responseC: make(chan Response), numRqsts: make(map[string][]int)}
+			// The run has either a number of requests or a duration
+			var runDur time.Duration
+			if tc.numRqsts == 0 {
+				runDur = time.Second
+			}
+			s, err := NewScheduler(4, 0, runDur, tc.numRqsts, eps, rqstr)
+			if err != nil {
+				t.Fatalf("unexpected error calling NewScheduler(): %s", err)
+			}
+			s.ShareBudget(tc.budget)
+			s.Start()
+
+			if !reflect.DeepEqual(rqstr.numRqsts, tc.expected) {
+				t.Errorf("expected the workers' requests to be %v, got %v", tc.expected, rqstr.numRqsts)
+			}
+		})
+	}
+}
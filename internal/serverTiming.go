@@ -0,0 +1,105 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// serverTimingHeader is the response header with the server's phases, see
+// https://www.w3.org/TR/server-timing/
+const serverTimingHeader = "Server-Timing"
+
+// serverTimingMetric is a Server-Timing metric with a duration
+type serverTimingMetric struct {
+	name string
+	dur  time.Duration
+}
+
+// parseServerTiming returns the metrics, with a duration, in the Server-Timing headers
+// in 'header'. Metrics without a duration, or with a malformed one, are ignored.
+func parseServerTiming(header http.Header) []serverTimingMetric {
+	var metrics []serverTimingMetric
+	for _, value := range header.Values(serverTimingHeader) {
+		for _, entry := range splitUnquoted(value, ',') {
+			params := splitUnquoted(entry, ';')
+			name := strings.TrimSpace(params[0])
+			if name == "" {
+				continue
+			}
+			for _, param := range params[1:] {
+				key, val := param, ""
+				if i := strings.IndexByte(param, '='); i >= 0 {
+					key, val = param[:i], strings.Trim(strings.TrimSpace(param[i+1:]), `"`)
+				}
+				if !strings.EqualFold(strings.TrimSpace(key), "dur") {
+					continue
+				}
+				ms, err := strconv.ParseFloat(val, 64)
+				if err != nil || ms < 0 {
+					break
+				}
+				metrics = append(metrics, serverTimingMetric{name: name, dur: time.Duration(ms * float64(time.Millisecond))})
+				break
+			}
+		}
+	}
+	return metrics
+}
+
+// splitUnquoted splits 's' at each 'sep' that isn't within a quoted string, e.g., a
+// Server-Timing 'desc' parameter
+func splitUnquoted(s string, sep byte) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// accumulateServerTiming adds the Server-Timing phases of 'resp', a successful
+// response, to 'epDetail'
+func accumulateServerTiming(resp Response, epDetail *api.EndpointDetail) {
+	for _, metric := range parseServerTiming(resp.Header) {
+		if epDetail.ServerTiming == nil {
+			epDetail.ServerTiming = make(map[string]*api.ServerTimingPhase)
+		}
+		phase, ok := epDetail.ServerTiming[metric.name]
+		if !ok {
+			phase = &api.ServerTimingPhase{}
+			epDetail.ServerTiming[metric.name] = phase
+		}
+		phase.Count++
+		phase.TotalNanos += metric.dur
+		phase.TotalRqstNanos += resp.RequestDuration
+	}
+}
+
+// finishServerTiming calculates the averages of 'epDetail's Server-Timing phases
+func finishServerTiming(epDetail *api.EndpointDetail) {
+	for _, phase := range epDetail.ServerTiming {
+		phase.AvgNanos = phase.TotalNanos / time.Duration(phase.Count)
+		if phase.TotalRqstNanos > 0 {
+			phase.RqstPercent = 100 * float64(phase.TotalNanos) / float64(phase.TotalRqstNanos)
+		}
+	}
+}
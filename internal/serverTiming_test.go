@@ -0,0 +1,117 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestParseServerTiming(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []string
+		expected []serverTimingMetric
+	}{
+		{name: "none"},
+		{
+			name:     "single",
+			values:   []string{"db;dur=53.2"},
+			expected: []serverTimingMetric{{name: "db", dur: 53200 * time.Microsecond}},
+		},
+		{
+			name:   "multiple",
+			values: []string{`cache;desc="Cache Read";dur=23.2, db; dur=53,app;dur="47.2"`, "origin;dur=100"},
+			expected: []serverTimingMetric{
+				{name: "cache", dur: 23200 * time.Microsecond},
+				{name: "db", dur: 53 * time.Millisecond},
+				{name: "app", dur: 47200 * time.Microsecond},
+				{name: "origin", dur: 100 * time.Millisecond},
+			},
+		},
+		{
+			name:     "quoted separators",
+			values:   []string{`db;desc="select a, b; from \"t\"";dur=5`},
+			expected: []serverTimingMetric{{name: "db", dur: 5 * time.Millisecond}},
+		},
+		{
+			name:     "no duration",
+			values:   []string{"miss, cdn;desc=edge, db;dur=bad, app;dur=-1, origin;dur=7"},
+			expected: []serverTimingMetric{{name: "origin", dur: 7 * time.Millisecond}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, v := range tc.values {
+				header.Add(serverTimingHeader, v)
+			}
+			metrics := parseServerTiming(header)
+			if !reflect.DeepEqual(metrics, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, metrics)
+			}
+		})
+	}
+}
+
+// TestServerTiming verifies the Server-Timing phases of an endpoint's responses are
+// parsed and averaged
+func TestServerTiming(t *testing.T) {
+	var count int64
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Alternate responses have a 'db' phase of 10ms and 30ms, all have a 20ms
+		// 'app' phase
+		db := "10"
+		if atomic.AddInt64(&count, 1)%2 == 0 {
+			db = "30"
+		}
+		w.Header().Add(serverTimingHeader, `db;desc="primary, replica";dur=`+db)
+		w.Header().Add(serverTimingHeader, "app;dur=20, miss")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testSrv.Close()
+
+	numRqsts := 10
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}}
+	ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet}
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	phases := runResults.EndpointDetails[ep.URL].ServerTiming
+	if len(phases) != 2 {
+		t.Fatalf("expected the db and app phases, got %+v", phases)
+	}
+	expected := map[string]time.Duration{"db": 20 * time.Millisecond, "app": 20 * time.Millisecond}
+	for name, avg := range expected {
+		phase := phases[name]
+		if phase == nil || phase.Count != int64(numRqsts) || phase.AvgNanos != avg {
+			t.Errorf("expected %d %s phases averaging %s, got %+v", numRqsts, name, avg, phase)
+			continue
+		}
+		// The phases are reported by the server, they don't reflect its actual latency
+		if phase.TotalRqstNanos <= 0 || phase.RqstPercent <= 0 {
+			t.Errorf("expected the %s phase's share of the request latency, got %+v", name, phase)
+		}
+	}
+}
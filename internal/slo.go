@@ -0,0 +1,191 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// ValidateSLOs verifies that each of 'rules' is valid
+func ValidateSLOs(rules []api.SLORule) error {
+	for i, rule := range rules {
+		if rule.Percentile < 1 || rule.Percentile > 100 {
+			return fmt.Errorf("SLOs[%d]: Percentile, %d, must be from 1 to 100", i, rule.Percentile)
+		}
+		if d, err := time.ParseDuration(rule.MaxLatency); err != nil || d <= 0 {
+			return fmt.Errorf("SLOs[%d]: MaxLatency, '%s', must be a positive duration of the form 'xs' or 'xms' where 'x' is an integer",
+				i, rule.MaxLatency)
+		}
+		if rule.Window != "" {
+			if d, err := time.ParseDuration(rule.Window); err != nil || d <= 0 {
+				return fmt.Errorf("SLOs[%d]: Window, '%s', must be a positive duration of the form 'xm' or 'xs' where 'x' is an integer",
+					i, rule.Window)
+			}
+		}
+		if rule.MaxViolationFraction < 0 || rule.MaxViolationFraction > 1 {
+			return fmt.Errorf("SLOs[%d]: MaxViolationFraction, %f, must be from 0 to 1", i, rule.MaxViolationFraction)
+		}
+	}
+	return nil
+}
+
+// sloLatency is the latency of a request and when it completed. The latencies of
+// requests that failed, or were abandoned, aren't checked, but the windows they
+// completed in are.
+type sloLatency struct {
+	completed time.Time
+	d         time.Duration
+	failed    bool
+}
+
+// sloWindow is the requests that completed in one of a rule's windows
+type sloWindow struct {
+	latencies []time.Duration
+	failed    int64
+}
+
+// checkSLOs sets 'rs.SLOResults' to the results of checking the successful requests
+// of 'responses' against each of 'rules', and sets 'rs.SLOFailed' if any of them
+// weren't met. The windows of windowed rules start at 'start', the start of the run,
+// and a window still open at 'end', the end of the run, is partial. 'rules' must be
+// valid.
+func checkSLOs(rules []api.SLORule, responses []Response, start, end time.Time, rs *api.RunSummary) {
+	if len(rules) == 0 {
+		return
+	}
+	var latencies []sloLatency
+	for _, resp := range responses {
+		if resp.KeepAliveProbe != nil || (resp.SSEStream != nil && resp.SSEStream.SummaryOnly) {
+			continue
+		}
+		latencies = append(latencies, sloLatency{completed: resp.Completed, d: resp.RequestDuration,
+			failed: resp.AbandonedSlow || resp.ErrCategory != ""})
+	}
+
+	rs.SLOResults = make([]api.SLOResult, 0, len(rules))
+	for _, rule := range rules {
+		result := checkSLO(rule, latencies, start, end)
+		if !result.Passed {
+			rs.SLOFailed = true
+		}
+		rs.SLOResults = append(rs.SLOResults, result)
+	}
+}
+
+// checkSLO returns the result of checking 'latencies' against 'rule'
+func checkSLO(rule api.SLORule, latencies []sloLatency, start, end time.Time) api.SLOResult {
+	maxLatency, _ := time.ParseDuration(rule.MaxLatency)
+	var window time.Duration
+	if rule.Window != "" {
+		window, _ = time.ParseDuration(rule.Window)
+	}
+	result := api.SLOResult{
+		Name:                 rule.Name,
+		Percentile:           rule.Percentile,
+		MaxLatencyNanos:      maxLatency,
+		WindowNanos:          window,
+		MaxViolationFraction: rule.MaxViolationFraction,
+	}
+	if result.Name == "" {
+		result.Name = fmt.Sprintf("P%d <= %s", rule.Percentile, maxLatency)
+		if window > 0 {
+			result.Name += fmt.Sprintf(" over every %s window", window)
+		}
+	}
+
+	all := make([]time.Duration, 0, len(latencies))
+	windows := make(map[int]*sloWindow)
+	for _, l := range latencies {
+		if !l.failed {
+			all = append(all, l.d)
+		}
+		// Requests without a completion time can't be placed in a window
+		if window > 0 && !l.completed.IsZero() {
+			i := int(l.completed.Sub(start) / window)
+			if i < 0 {
+				i = 0
+			}
+			w, ok := windows[i]
+			if !ok {
+				w = &sloWindow{}
+				windows[i] = w
+			}
+			if l.failed {
+				w.failed++
+			} else {
+				w.latencies = append(w.latencies, l.d)
+			}
+		}
+	}
+	if len(all) == 0 {
+		result.Violation = "there were no successful requests to check"
+		return result
+	}
+	result.LatencyNanos = calcPercentiles(rule.Percentile, all)
+	if window <= 0 {
+		result.Passed = result.LatencyNanos <= maxLatency
+		if !result.Passed {
+			result.Violation = fmt.Sprintf("P%d latency %ss is greater than %ss", rule.Percentile,
+				formatSeconds(result.LatencyNanos), formatSeconds(maxLatency))
+		}
+		return result
+	}
+
+	indexes := make([]int, 0, len(windows))
+	for i := range windows {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	worst := -1
+	for n, i := range indexes {
+		w := api.SLOWindow{
+			StartNanos: time.Duration(i) * window,
+			Rqsts:      int64(len(windows[i].latencies)),
+			Failed:     windows[i].failed,
+		}
+		// The run ended part way through the last window, so it isn't checked,
+		// unless it's the only one
+		w.Partial = n == len(indexes)-1 && n > 0 && start.Add(w.StartNanos+window).After(end)
+		if w.Rqsts > 0 {
+			w.LatencyNanos = calcPercentiles(rule.Percentile, windows[i].latencies)
+		}
+		if !w.Partial {
+			// A window without successful requests, because all of them failed or
+			// were abandoned, violates the rule
+			w.Violated = w.Rqsts == 0 || w.LatencyNanos > maxLatency
+			result.CheckedWindows++
+			if w.Violated {
+				result.ViolatedWindows++
+			}
+			if w.Rqsts > 0 && (worst < 0 || w.LatencyNanos > result.Windows[worst].LatencyNanos) {
+				worst = len(result.Windows)
+			}
+		}
+		result.Windows = append(result.Windows, w)
+	}
+	if result.CheckedWindows == 0 {
+		result.Violation = "there were no successful requests with a completion time to place in a window"
+		return result
+	}
+	result.ViolationFraction = float64(result.ViolatedWindows) / float64(result.CheckedWindows)
+	result.Passed = result.ViolationFraction <= rule.MaxViolationFraction
+	if worst >= 0 {
+		worstWindow := result.Windows[worst]
+		result.WorstWindow = &worstWindow
+	}
+	if !result.Passed {
+		result.Violation = fmt.Sprintf("%d of %d windows violated it, more than the %.2f tolerated", result.ViolatedWindows,
+			result.CheckedWindows, rule.MaxViolationFraction)
+		if result.WorstWindow != nil {
+			result.Violation += fmt.Sprintf(", the worst, starting at %ss, had a P%d latency of %ss",
+				formatSeconds(result.WorstWindow.StartNanos), rule.Percentile, formatSeconds(result.WorstWindow.LatencyNanos))
+		}
+	}
+	return result
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestSLOs verifies that a windowed SLO catches a window of high latency that a
+// run-wide check averages away, and that the tolerated fraction of violated windows
+// decides whether it passes
+func TestSLOs(t *testing.T) {
+	start := time.Now().Add(-10*time.Minute - 20*time.Second)
+	ep := api.Endpoint{URL: "http://someservice.test/users", Method: "GET"}
+	var responses []Response
+	// 10 one minute windows of 60 requests each, except the 4th, which only has 20
+	// slow requests, too few to affect the run-wide P95
+	for w := 0; w < 10; w++ {
+		d, n := 50*time.Millisecond, 60
+		if w == 3 {
+			d, n = 500*time.Millisecond, 20
+		}
+		for i := 0; i < n; i++ {
+			completed := start.Add(time.Duration(w)*time.Minute + time.Duration(i)*time.Second)
+			responses = append(responses, Response{HTTPStatus: 200, RequestDuration: d, Endpoint: ep, Completed: completed})
+		}
+	}
+	// Failed requests aren't checked
+	responses = append(responses, Response{ErrCategory: "timeout", RequestDuration: time.Hour, Endpoint: ep, Completed: start})
+
+	rules := []api.SLORule{
+		{Percentile: 95, MaxLatency: "300ms"},
+		{Name: "strict", Percentile: 95, MaxLatency: "300ms", Window: "1m"},
+		{Name: "tolerant", Percentile: 95, MaxLatency: "300ms", Window: "1m", MaxViolationFraction: 0.1},
+	}
+	if err := ValidateSLOs(rules); err != nil {
+		t.Fatalf("unexpected error validating the SLOs: %s", err)
+	}
+	rh := ResponseHandler{SLOs: rules, start: start}
+	runResults, err := rh.summarize(responses, start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	rs := runResults.RunSummary
+	if len(rs.SLOResults) != len(rules) {
+		t.Fatalf("expected %d SLO results, got %d", len(rules), len(rs.SLOResults))
+	}
+	if !rs.SLOFailed {
+		t.Errorf("expected the run to fail its SLOs")
+	}
+
+	runWide, strict, tolerant := rs.SLOResults[0], rs.SLOResults[1], rs.SLOResults[2]
+	if !runWide.Passed || runWide.Name != "P95 <= 300ms" || runWide.LatencyNanos != 50*time.Millisecond || runWide.Windows != nil {
+		t.Errorf("expected the run-wide P95 of 50ms to pass, got %+v", runWide)
+	}
+	for _, result := range []api.SLOResult{strict, tolerant} {
+		if len(result.Windows) != 10 || result.CheckedWindows != 10 || result.ViolatedWindows != 1 || result.ViolationFraction != 0.1 {
+			t.Errorf("expected %s to have 1 of 10 windows violated, got %d of %d", result.Name, result.ViolatedWindows, len(result.Windows))
+			continue
+		}
+		worst := api.SLOWindow{StartNanos: 3 * time.Minute, Rqsts: 20, LatencyNanos: 500 * time.Millisecond, Violated: true}
+		if result.WorstWindow == nil || *result.WorstWindow != worst || result.Windows[3] != worst {
+			t.Errorf("expected %s's worst window to be %+v, got %+v", result.Name, worst, result.WorstWindow)
+		}
+	}
+	if strict.Passed || !strings.Contains(strict.Violation, "1 of 10 windows violated it") {
+		t.Errorf("expected the strict SLO to fail, got %+v", strict)
+	}
+	if !tolerant.Passed || tolerant.Violation != "" {
+		t.Errorf("expected the tolerant SLO to pass, got %+v", tolerant)
+	}
+	if reason := runFailure(rs); reason == "" {
+		t.Errorf("expected the run to be reported as failed")
+	}
+
+	var b bytes.Buffer
+	printRunSummary(&b, rs)
+	for _, expected := range []string{
+		"PASSED P95 <= 300ms: P95 0.0500s",
+		"FAILED strict: P95 0.0500s, 1 of 10 windows violated, worst P95 0.5000s at 180.0000s",
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("expected the run summary to contain %q, got %s", expected, b.String())
+		}
+	}
+}
+
+// TestSLOWindows verifies that a window whose requests all failed, or were abandoned,
+// violates a windowed SLO, and that the partial window the run ended in is reported
+// but not checked unless it's the only window
+func TestSLOWindows(t *testing.T) {
+	end := time.Now()
+	start := end.Add(-3*time.Minute - 30*time.Second)
+	ep := api.Endpoint{URL: "http://someservice.test/users", Method: "GET"}
+	rule := api.SLORule{Percentile: 95, MaxLatency: "300ms", Window: "1m", MaxViolationFraction: 0.5}
+	var latencies []sloLatency
+	// The 1st and 3rd windows are fast, the 2nd only has failed requests, and the run
+	// ended 30s into the slow 4th
+	for w, d := range []time.Duration{50 * time.Millisecond, 0, 50 * time.Millisecond, time.Second} {
+		for i := 0; i < 10; i++ {
+			completed := start.Add(time.Duration(w)*time.Minute + time.Duration(i)*time.Second)
+			latencies = append(latencies, sloLatency{completed: completed, d: d, failed: d == 0})
+		}
+	}
+
+	result := checkSLO(rule, latencies, start, end)
+	if len(result.Windows) != 4 || result.CheckedWindows != 3 || result.ViolatedWindows != 1 {
+		t.Fatalf("expected 1 of 3 checked windows to be violated, got %d of %d, %+v", result.ViolatedWindows, result.CheckedWindows, result.Windows)
+	}
+	if failed := result.Windows[1]; !failed.Violated || failed.Rqsts != 0 || failed.Failed != 10 {
+		t.Errorf("expected the window without successful requests to be violated, got %+v", failed)
+	}
+	if partial := result.Windows[3]; !partial.Partial || partial.Violated || partial.LatencyNanos != time.Second {
+		t.Errorf("expected the last window to be partial and not checked, got %+v", partial)
+	}
+	if !result.Passed || result.WorstWindow == nil || result.WorstWindow.LatencyNanos != 50*time.Millisecond {
+		t.Errorf("expected the SLO to pass with a worst checked window of 50ms, got %+v", result)
+	}
+
+	rule.MaxViolationFraction = 0
+	if result = checkSLO(rule, latencies, start, end); result.Passed ||
+		!strings.Contains(result.Violation, "1 of 3 windows violated it") {
+		t.Errorf("expected the window without successful requests to fail the SLO, got %+v", result)
+	}
+
+	// A run shorter than a window only has a partial window, which is checked
+	rule.Window = "10m"
+	if result = checkSLO(rule, latencies, start, end); len(result.Windows) != 1 || result.Windows[0].Partial ||
+		result.CheckedWindows != 1 || result.Passed {
+		t.Errorf("expected the run's only window to be checked and violated, got %+v", result)
+	}
+
+	responses := []Response{{Endpoint: ep, ErrCategory: "timeout", Completed: start}, {Endpoint: ep, AbandonedSlow: true, Completed: start}}
+	var rs api.RunSummary
+	checkSLOs([]api.SLORule{rule}, responses, start, end, &rs)
+	if !rs.SLOFailed || rs.SLOResults[0].Violation != "there were no successful requests to check" {
+		t.Errorf("expected a run without successful requests to fail its SLOs, got %+v", rs.SLOResults)
+	}
+}
+
+func TestValidateSLOs(t *testing.T) {
+	tests := []struct {
+		name string
+		rule api.SLORule
+	}{
+		{name: "NoPercentile", rule: api.SLORule{MaxLatency: "300ms"}},
+		{name: "PercentileTooHigh", rule: api.SLORule{Percentile: 101, MaxLatency: "300ms"}},
+		{name: "BadMaxLatency", rule: api.SLORule{Percentile: 95, MaxLatency: "300"}},
+		{name: "BadWindow", rule: api.SLORule{Percentile: 95, MaxLatency: "300ms", Window: "-1m"}},
+		{name: "BadViolationFraction", rule: api.SLORule{Percentile: 95, MaxLatency: "300ms", Window: "1m", MaxViolationFraction: 1.5}},
+	}
+	for _, tc := range tests {
+		if err := ValidateSLOs([]api.SLORule{tc.rule}); err == nil {
+			t.Errorf("%s: expected an error for %+v", tc.name, tc.rule)
+		}
+	}
+}
@@ -0,0 +1,493 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// defaultFirstEventTimeout is how long to wait for a stream's first event if its
+// endpoint's SSEConfig doesn't have a FirstEventTimeout
+const defaultFirstEventTimeout = 10 * time.Second
+
+// SSEStream summarizes reading a single Server-Sent Events stream, including any
+// reconnects
+type SSEStream struct {
+	// TimeToFirstEvent is how long after the stream was opened its first event was
+	// received. It's 0 if there were no events.
+	TimeToFirstEvent time.Duration
+	// InterEvent are the times between the stream's consecutive events
+	InterEvent []time.Duration
+	// Events is the number of events received
+	Events int64
+	// Disconnects is the number of times the stream was disconnected before its
+	// configured Events or Duration
+	Disconnects int64
+	// Reconnects is the number of times the stream was reconnected
+	Reconnects int64
+	// SummaryOnly indicates the stream's events were reported as Responses of their
+	// own, see api.SSEReportEvent, so the stream's Response is only included in its
+	// endpoint's SSE results
+	SummaryOnly bool
+}
+
+// sseSettings are an api.SSEConfig with its durations parsed
+type sseSettings struct {
+	events            int64
+	duration          time.Duration
+	firstEventTimeout time.Duration
+	reconnectDelay    time.Duration
+	maxReconnects     int
+	report            string
+}
+
+// parseSSEConfig returns the settings configured by 'c'
+func parseSSEConfig(c api.SSEConfig) (sseSettings, error) {
+	s := sseSettings{
+		events:            int64(c.Events),
+		firstEventTimeout: defaultFirstEventTimeout,
+		maxReconnects:     c.MaxReconnects,
+		report:            c.Report,
+	}
+	if s.report == "" {
+		s.report = api.SSEReportEvent
+	}
+	if s.report != api.SSEReportEvent && s.report != api.SSEReportConnection {
+		return s, fmt.Errorf("SSE Report %q is invalid, it must be %q or %q", c.Report, api.SSEReportEvent, api.SSEReportConnection)
+	}
+	if c.Events < 0 || c.MaxReconnects < 0 {
+		return s, fmt.Errorf("SSE Events, %d, and MaxReconnects, %d, must not be negative", c.Events, c.MaxReconnects)
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{name: "Duration", value: c.Duration, dst: &s.duration},
+		{name: "FirstEventTimeout", value: c.FirstEventTimeout, dst: &s.firstEventTimeout},
+		{name: "ReconnectDelay", value: c.ReconnectDelay, dst: &s.reconnectDelay},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return s, fmt.Errorf("SSE %s %q is invalid: %w", d.name, d.value, err)
+		}
+		if parsed <= 0 {
+			return s, fmt.Errorf("SSE %s %q must be greater than 0", d.name, d.value)
+		}
+		*d.dst = parsed
+	}
+	if s.events == 0 && s.duration == 0 {
+		return s, errors.New("SSE must have Events, Duration, or both")
+	}
+	return s, nil
+}
+
+// validateSSE verifies that 'ep's Mode, and its SSEConfig if it's an SSE endpoint,
+// are valid
+func validateSSE(ep api.Endpoint) error {
+	switch ep.Mode {
+	case "":
+		if ep.SSE != nil {
+			return fmt.Errorf("endpoint %s %s has an SSE config, its Mode must be %q", ep.Method, ep.URL, api.EndpointModeSSE)
+		}
+		return nil
+	case api.EndpointModeSSE:
+	default:
+		return fmt.Errorf("endpoint %s %s has a Mode of %q, it must be %q or not set", ep.Method, ep.URL, ep.Mode, api.EndpointModeSSE)
+	}
+	if ep.SSE == nil {
+		return fmt.Errorf("endpoint %s %s has a Mode of %q, it must have an SSE config", ep.Method, ep.URL, ep.Mode)
+	}
+	if ep.PipelineDepth > 1 || ep.KeepAliveProbe != nil || len(ep.Variants) > 0 {
+		return fmt.Errorf("endpoint %s %s has a Mode of %q, it can't also have a PipelineDepth, KeepAliveProbe, or Variants",
+			ep.Method, ep.URL, ep.Mode)
+	}
+	if _, err := parseSSEConfig(*ep.SSE); err != nil {
+		return fmt.Errorf("endpoint %s %s: %w", ep.Method, ep.URL, err)
+	}
+	return nil
+}
+
+// sseEvent is a single event of a Server-Sent Events stream
+type sseEvent struct {
+	data string
+}
+
+// sseReader reads the events of a Server-Sent Events stream, see
+// https://html.spec.whatwg.org/multipage/server-sent-events.html
+type sseReader struct {
+	r *bufio.Reader
+	// lastID is the ID of the last event, it's sent as the Last-Event-ID when the
+	// stream is reconnected
+	lastID string
+	// retry, if set, is the reconnection delay sent by the server
+	retry time.Duration
+}
+
+// newSSEReader returns an sseReader reading 'body'. 'lastID' is the stream's last
+// event ID, if it's being reconnected.
+func newSSEReader(body io.Reader, lastID string) *sseReader {
+	return &sseReader{r: bufio.NewReader(body), lastID: lastID}
+}
+
+// next returns the stream's next event. Events without any data aren't returned.
+func (s *sseReader) next() (sseEvent, error) {
+	var data []string
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			// An event that isn't ended by a blank line is discarded
+			return sseEvent{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(data) > 0 {
+				return sseEvent{data: strings.Join(data, "\n")}, nil
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				s.lastID = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				s.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// processSSE is the Server-Sent Events counterpart to ProcessRqst. Each of the
+// 'numRqsts' requests opens a stream and reads its events according to 'ep's SSE
+// config. The stream, and, unless the endpoint reports by connection, each of its
+// events, are reported as Responses.
+func (r Requestor) processSSE(ep api.Endpoint, numRqsts int, rqstRate float64) {
+	settings, err := parseSSEConfig(*ep.SSE)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor unable to read endpoint %s event streams", ep.URL)
+		return
+	}
+	tmplt, err := newRqstTemplate(ep, rqstTmpltFuncs(r.UniqueInts, clockOffset(r.ClockSkew)))
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor unable to parse endpoint %s templates", ep.URL)
+		return
+	}
+	// A stream is read for its Duration, or until it has its Events, so the
+	// client's overall request timeout doesn't apply
+	client := r.endpointClient(ep)
+	client.Timeout = 0
+
+	if numRqsts == 0 {
+		log.Debug().Msgf("processSSE: EP: %s, numRqsts was 0, setting to %d", ep.URL, api.MaxRqsts)
+		numRqsts = api.MaxRqsts
+	}
+	for i := 0; i < numRqsts; i++ {
+		if r.Budget.spent() {
+			log.Debug().Msg("Requestor: the run's Budget was reached, exiting")
+			return
+		}
+		if r.FirstFailure.stopped() {
+			log.Debug().Msg("Requestor: the run was stopped by the first failure, exiting")
+			return
+		}
+		rqstEP := ep
+		if tmplt != nil {
+			var data interface{}
+			if r.Data != nil {
+				_, data = r.Data.nextRow()
+			}
+			if rqstEP, err = tmplt.render(ep, data); err != nil {
+				r.handleRenderErr(ep, err)
+				return
+			}
+		}
+
+		start := time.Now()
+		response, ok := r.readSSEStream(client, rqstEP, settings)
+		if !ok {
+			log.Debug().Msg("Requestor cancelled or the run duration expired, exiting")
+			return
+		}
+		if !r.sendResponse(response) {
+			log.Debug().Msg("Requestor cancelled or the run duration expired, exiting")
+			return
+		}
+		if r.stopOnFailure(response, nil) || r.recordEarlyFail(ep, response) {
+			return
+		}
+
+		// Zero request rate is completely unthrottled
+		if rqstRate == 0 {
+			continue
+		}
+		delta := rqstInterval(rqstRate) - time.Since(start)
+		if delta > 0 {
+			time.Sleep(delta)
+		}
+	}
+}
+
+// readSSEStream opens a stream to 'ep' and reads its events, reconnecting if it's
+// disconnected, according to 's'. It returns the Response summarizing the stream,
+// which failed if the stream didn't have any events. It returns false if the run
+// ended before the stream had any events.
+func (r Requestor) readSSEStream(client http.Client, ep api.Endpoint, s sseSettings) (Response, bool) {
+	ctx := r.Ctx
+	if s.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(r.Ctx, s.duration)
+		defer cancel()
+	}
+
+	stream := &SSEStream{}
+	response := Response{Endpoint: api.Endpoint{URL: summaryURL(ep, ep.URL), Method: ep.Method, Description: ep.Description}, SSEStream: stream}
+	start := time.Now()
+	var (
+		lastEvent time.Time
+		lastID    string
+	)
+	delay := s.reconnectDelay
+	for connects := 0; ; connects++ {
+		if connects > 0 {
+			if int(stream.Reconnects) >= s.maxReconnects {
+				break
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			stream.Reconnects++
+		}
+
+		reader, err := r.readSSEConn(ctx, client, ep, s, lastID, &response, func(ev sseEvent) bool {
+			now := time.Now()
+			var latency time.Duration
+			if stream.Events == 0 {
+				latency = now.Sub(start)
+				stream.TimeToFirstEvent, response.RequestDuration = latency, latency
+			} else {
+				latency = now.Sub(lastEvent)
+				stream.InterEvent = append(stream.InterEvent, latency)
+			}
+			lastEvent = now
+			stream.Events++
+			if s.report == api.SSEReportEvent {
+				event := Response{
+					Endpoint:        api.Endpoint{URL: summaryURL(ep, ep.URL), Method: ep.Method, Description: ep.Description},
+					HTTPStatus:      response.HTTPStatus,
+					Proto:           response.Proto,
+					RequestDuration: latency,
+					BytesReceived:   int64(len(ev.data)),
+				}
+				if !r.sendResponse(event) {
+					return false
+				}
+			}
+			return s.events == 0 || stream.Events < s.events
+		})
+		if reader != nil {
+			lastID = reader.lastID
+			if reader.retry > 0 {
+				delay = reader.retry
+			}
+		}
+
+		switch {
+		case s.events > 0 && stream.Events >= s.events:
+		case r.Ctx.Err() != nil && stream.Events == 0:
+			return Response{}, false
+		case ctx.Err() != nil && stream.Events == 0:
+			// The stream's Duration passed before its first event
+			r.failSSEStream(&response, errFirstEventTimeout)
+			return response, true
+		case ctx.Err() != nil:
+			// The stream's Duration has passed, or the run has ended
+		case stream.Events == 0:
+			r.failSSEStream(&response, err)
+			return response, true
+		default:
+			stream.Disconnects++
+			continue
+		}
+		break
+	}
+
+	// A stream without any events has failed, so only streams with events get here
+	stream.SummaryOnly = s.report == api.SSEReportEvent
+	return response, true
+}
+
+// errFirstEventTimeout is returned by readSSEConn if the connection's first event
+// wasn't received within the SSEConfig's FirstEventTimeout
+var errFirstEventTimeout = errors.New("timed out waiting for the first event")
+
+// readSSEConn opens a single connection to the stream 'ep', using 'ctx', sending
+// 'lastID', if set, as the Last-Event-ID, and calls 'onEvent' for each of its
+// events until 'onEvent' returns false or the connection ends. The response's
+// status and protocol are recorded in 'response' if it hasn't already recorded a
+// connection's. It returns the reader of the connection's events, if it got that
+// far, along with why the connection ended.
+func (r Requestor) readSSEConn(ctx context.Context, client http.Client, ep api.Endpoint, s sseSettings, lastID string,
+	response *Response, onEvent func(sseEvent) bool) (*sseReader, error) {
+	connCtx, connCancel := context.WithCancel(ctx)
+	defer connCancel()
+	var timedOut int32
+	timer := time.AfterFunc(s.firstEventTimeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		connCancel()
+	})
+	defer timer.Stop()
+	timeoutErr := func(err error) error {
+		if atomic.LoadInt32(&timedOut) == 1 {
+			return errFirstEventTimeout
+		}
+		return err
+	}
+
+	req, err := newRqst(connCtx, ep)
+	if err != nil {
+		return nil, err
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, timeoutErr(err)
+	}
+	defer resp.Body.Close()
+	if response.HTTPStatus == 0 {
+		response.HTTPStatus, response.Proto, response.Header = resp.StatusCode, resp.Proto, resp.Header
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("the stream's status was %d", resp.StatusCode)
+	}
+
+	reader := newSSEReader(resp.Body, lastID)
+	for first := true; ; first = false {
+		ev, err := reader.next()
+		if err != nil {
+			return reader, timeoutErr(err)
+		}
+		if first {
+			timer.Stop()
+		}
+		if !onEvent(ev) {
+			return reader, nil
+		}
+	}
+}
+
+// failSSEStream records the failure of the stream reported by 'response', which had
+// no events, because of 'err'
+func (r Requestor) failSSEStream(response *Response, err error) {
+	response.Err = err
+	switch {
+	case errors.Is(err, errFirstEventTimeout):
+		response.ErrCategory = api.ErrCategoryFirstEventTimeout
+	case response.HTTPStatus >= http.StatusBadRequest:
+		// The status fails the response
+	case response.HTTPStatus != 0:
+		// The server ended the stream before its first event
+		response.ErrCategory = api.ErrCategoryTruncatedResponse
+	default:
+		response.ErrCategory = errCategory(rqstAttempt{err: err}, r.Ctx.Err() != nil)
+		if response.ErrCategory == "" {
+			response.ErrCategory = api.ErrCategoryConnection
+		}
+	}
+}
+
+// summarizeSSE summarizes the Server-Sent Events streams in 'responses' in the
+// EndpointDetail of their endpoint, keyed by 'keyer'
+func summarizeSSE(responses []Response, keyer summaryKeyer, epDetails map[string]*api.EndpointDetail) {
+	firstEvents := make(map[string][]time.Duration)
+	interEvents := make(map[string][]time.Duration)
+	for _, resp := range responses {
+		stream := resp.SSEStream
+		if stream == nil {
+			continue
+		}
+		url := keyer.key(resp.Endpoint.URL)
+		epDetail := getEPDetail(url, epDetails)
+		if epDetail.SSE == nil {
+			epDetail.SSE = &api.SSEResults{}
+		}
+		results := epDetail.SSE
+		results.Streams++
+		if stream.Events == 0 {
+			results.FailedStreams++
+		} else {
+			firstEvents[url] = append(firstEvents[url], stream.TimeToFirstEvent)
+		}
+		results.Events += stream.Events
+		results.Disconnects += stream.Disconnects
+		results.Reconnects += stream.Reconnects
+		interEvents[url] = append(interEvents[url], stream.InterEvent...)
+	}
+	for url, durations := range firstEvents {
+		epDetails[url].SSE.TimeToFirstEventNanos = latencyPercentiles(durations)
+	}
+	for url, durations := range interEvents {
+		if len(durations) > 0 {
+			epDetails[url].SSE.InterEventNanos = latencyPercentiles(durations)
+		}
+	}
+}
+
+// latencyPercentiles returns the reported percentiles of 'durations' keyed by the
+// way they're labeled in the request latency table, e.g., 'Median' or 'P95'
+func latencyPercentiles(durations []time.Duration) map[string]time.Duration {
+	percentiles := make(map[string]time.Duration, len(reportedPercentiles))
+	for _, p := range reportedPercentiles {
+		percentiles[formatExemplarPercentile(p)] = calcPercentiles(p, durations)
+	}
+	return percentiles
+}
+
+// formatLatencies formats 'percentiles', as returned by latencyPercentiles, in order
+func formatLatencies(percentiles map[string]time.Duration) string {
+	var b strings.Builder
+	for i, p := range reportedPercentiles {
+		if i > 0 {
+			b.WriteString("  ")
+		}
+		label := formatExemplarPercentile(p)
+		fmt.Fprintf(&b, "%s %s", label, formatSeconds(percentiles[label]))
+	}
+	return b.String()
+}
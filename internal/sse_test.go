@@ -0,0 +1,230 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// newSSEServer returns a server streaming events, 'id: n' and 'data: event n', every
+// 'interval'. Each connection sends up to 'perConn' events, continuing from the
+// request's Last-Event-ID, and then closes the stream. The Last-Event-IDs requested
+// are recorded in 'lastIDs'.
+func newSSEServer(interval time.Duration, perConn int, lastIDs *[]string) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastID := r.Header.Get("Last-Event-ID")
+		mu.Lock()
+		*lastIDs = append(*lastIDs, lastID)
+		mu.Unlock()
+		next, _ := strconv.Atoi(lastID)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for i := 0; i < perConn; i++ {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(interval):
+			}
+			next++
+			fmt.Fprintf(w, ": a comment\nid: %d\ndata: event %d\n\n", next, next)
+			w.(http.Flusher).Flush()
+		}
+	}))
+}
+
+func TestSSE(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        api.SSEConfig
+		perConn       int
+		expectedResps int
+		expected      api.SSEResults
+		expectedIDs   []string
+	}{
+		{
+			name:          "per event",
+			config:        api.SSEConfig{Events: 5},
+			perConn:       5,
+			expectedResps: 2 * 5,
+			expected:      api.SSEResults{Streams: 2, Events: 10},
+			expectedIDs:   []string{"", ""},
+		},
+		{
+			name:          "per connection",
+			config:        api.SSEConfig{Events: 5, Report: api.SSEReportConnection},
+			perConn:       5,
+			expectedResps: 2,
+			expected:      api.SSEResults{Streams: 2, Events: 10},
+			expectedIDs:   []string{"", ""},
+		},
+		{
+			name:          "reconnect",
+			config:        api.SSEConfig{Events: 5, MaxReconnects: 2},
+			perConn:       3,
+			expectedResps: 2 * 5,
+			expected:      api.SSEResults{Streams: 2, Events: 10, Disconnects: 2, Reconnects: 2},
+			expectedIDs:   []string{"", "", "3", "3"},
+		},
+		{
+			name:          "disconnect",
+			config:        api.SSEConfig{Events: 5},
+			perConn:       3,
+			expectedResps: 2 * 3,
+			expected:      api.SSEResults{Streams: 2, Events: 6, Disconnects: 2},
+			expectedIDs:   []string{"", ""},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var lastIDs []string
+			testSrv := newSSEServer(5*time.Millisecond, tc.perConn, &lastIDs)
+			defer testSrv.Close()
+
+			ep := api.Endpoint{URL: testSrv.URL + "/events", Method: http.MethodGet, Mode: api.EndpointModeSSE, SSE: &tc.config}
+			if err := validateSSE(ep); err != nil {
+				t.Fatalf("unexpected error validating the endpoint: %s", err)
+			}
+			numStreams := 2
+			respC := make(chan Response, 100)
+			rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{Timeout: time.Millisecond}}
+			var wg sync.WaitGroup
+			for i := 0; i < numStreams; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rqstr.ProcessRqst(ep, 1, 0)
+				}()
+			}
+			wg.Wait()
+			close(respC)
+
+			var responses []Response
+			for resp := range respC {
+				responses = append(responses, resp)
+			}
+			rh := &ResponseHandler{start: time.Now()}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+
+			epDetail := runResults.EndpointDetails[ep.URL]
+			if epDetail == nil || epDetail.SSE == nil {
+				t.Fatalf("expected the endpoint's event streams to be summarized, got %+v", runResults.EndpointDetails)
+			}
+			sse := *epDetail.SSE
+			if sse.TimeToFirstEventNanos["Min"] < 5*time.Millisecond || sse.InterEventNanos["Median"] < 5*time.Millisecond {
+				t.Errorf("expected event latencies of at least 5ms, got %v and %v", sse.TimeToFirstEventNanos, sse.InterEventNanos)
+			}
+			sse.TimeToFirstEventNanos, sse.InterEventNanos = nil, nil
+			if !reflect.DeepEqual(sse, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, sse)
+			}
+			if rs := runResults.RunSummary.RqstStats; rs.TotalRqsts != int64(tc.expectedResps) {
+				t.Errorf("expected %d responses, got %d", tc.expectedResps, rs.TotalRqsts)
+			}
+			if len(lastIDs) != len(tc.expectedIDs) || strings.Join(lastIDs, ",") != strings.Join(tc.expectedIDs, ",") {
+				t.Errorf("expected the streams to be requested with Last-Event-IDs %q, got %q", tc.expectedIDs, lastIDs)
+			}
+		})
+	}
+}
+
+// TestSSEFirstEventTimeout verifies a stream whose first event doesn't arrive in time
+// fails with the FirstEventTimeout category
+func TestSSEFirstEventTimeout(t *testing.T) {
+	var lastIDs []string
+	testSrv := newSSEServer(time.Second, 1, &lastIDs)
+	defer testSrv.Close()
+
+	ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet, Mode: api.EndpointModeSSE,
+		SSE: &api.SSEConfig{Events: 1, FirstEventTimeout: "20ms", MaxReconnects: 3}}
+	respC := make(chan Response, 10)
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}}
+	start := time.Now()
+	rqstr.ProcessRqst(ep, 1, 0)
+	close(respC)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the stream to time out after 20ms, it took %s", elapsed)
+	}
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	if len(responses) != 1 {
+		t.Fatalf("expected a single response, got %d", len(responses))
+	}
+	resp := responses[0]
+	if resp.ErrCategory != api.ErrCategoryFirstEventTimeout || resp.SSEStream == nil || resp.SSEStream.SummaryOnly {
+		t.Errorf("expected a failed stream with the %s category, got %+v", api.ErrCategoryFirstEventTimeout, resp)
+	}
+}
+
+func TestSSEReader(t *testing.T) {
+	stream := ": comment\r\nretry: 250\nid: 1\ndata: first\ndata: line\n\nevent: ping\n\nid: 2\ndata:second\n\ndata: unterminated"
+	reader := newSSEReader(strings.NewReader(stream), "")
+
+	var events []string
+	for {
+		ev, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error reading events: %s", err)
+		}
+		events = append(events, ev.data)
+	}
+	if strings.Join(events, "|") != "first\nline|second" {
+		t.Errorf("expected the events 'first\\nline' and 'second', got %q", events)
+	}
+	if reader.lastID != "2" || reader.retry != 250*time.Millisecond {
+		t.Errorf("expected the last ID 2 and a retry of 250ms, got %q and %s", reader.lastID, reader.retry)
+	}
+}
+
+func TestValidateSSE(t *testing.T) {
+	tests := []struct {
+		name  string
+		ep    api.Endpoint
+		valid bool
+	}{
+		{name: "not SSE", ep: api.Endpoint{}, valid: true},
+		{name: "events", ep: api.Endpoint{Mode: api.EndpointModeSSE, SSE: &api.SSEConfig{Events: 10}}, valid: true},
+		{name: "duration", ep: api.Endpoint{Mode: api.EndpointModeSSE, SSE: &api.SSEConfig{Duration: "10s", FirstEventTimeout: "1s"}}, valid: true},
+		{name: "unknown mode", ep: api.Endpoint{Mode: "websocket"}},
+		{name: "no config", ep: api.Endpoint{Mode: api.EndpointModeSSE}},
+		{name: "config without mode", ep: api.Endpoint{SSE: &api.SSEConfig{Events: 10}}},
+		{name: "unbounded", ep: api.Endpoint{Mode: api.EndpointModeSSE, SSE: &api.SSEConfig{}}},
+		{name: "bad duration", ep: api.Endpoint{Mode: api.EndpointModeSSE, SSE: &api.SSEConfig{Duration: "soon"}}},
+		{name: "bad report", ep: api.Endpoint{Mode: api.EndpointModeSSE, SSE: &api.SSEConfig{Events: 1, Report: "batch"}}},
+		{name: "pipelined", ep: api.Endpoint{Mode: api.EndpointModeSSE, SSE: &api.SSEConfig{Events: 1}, PipelineDepth: 2}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.ep.URL, tc.ep.Method = "http://someurl", http.MethodGet
+			if err := validateSSE(tc.ep); (err == nil) != tc.valid {
+				t.Errorf("expected valid to be %t, got error %v", tc.valid, err)
+			}
+		})
+	}
+}
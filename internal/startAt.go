@@ -0,0 +1,52 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"time"
+)
+
+// startAtTolerance is how far in the past a scheduled start can be and still be
+// started, e.g., because of small differences between the clocks of the machines
+// being coordinated
+const startAtTolerance = 500 * time.Millisecond
+
+// ParseStartAt parses 'value', the -start-at flag, an RFC 3339 time. It returns the
+// zero time if 'value' is empty. It's an error if the time is already in the past,
+// as of 'now', by more than a small tolerance.
+func ParseStartAt(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	startAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("-start-at, %q, must be an RFC 3339 time, e.g., '2020-06-01T15:04:05Z': %w", value, err)
+	}
+	if err = checkStartAt(startAt, now); err != nil {
+		return time.Time{}, err
+	}
+	return startAt, nil
+}
+
+// checkStartAt returns an error if 'startAt' is in the past, as of 'now', by more
+// than startAtTolerance
+func checkStartAt(startAt, now time.Time) error {
+	if late := now.Sub(startAt); late > startAtTolerance {
+		return fmt.Errorf("-start-at %s is %s in the past", startAt.Format(time.RFC3339Nano), late.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// WaitForStart sleeps until 'startAt' and returns the time it woke up. It's an error
+// if 'startAt' has already passed by more than a small tolerance, e.g., because the
+// run's setup took longer than expected.
+func WaitForStart(startAt time.Time) (time.Time, error) {
+	if err := checkStartAt(startAt, time.Now()); err != nil {
+		return time.Time{}, err
+	}
+	time.Sleep(time.Until(startAt))
+	return time.Now(), nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStartAt(t *testing.T) {
+	now := time.Date(2020, 6, 1, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "NotSet", value: ""},
+		{name: "Future", value: "2020-06-01T15:05:00Z", want: time.Date(2020, 6, 1, 15, 5, 0, 0, time.UTC)},
+		{name: "FractionalSeconds", value: "2020-06-01T15:04:05.250Z", want: now.Add(250 * time.Millisecond)},
+		{name: "OtherZone", value: "2020-06-01T09:05:00-06:00", want: time.Date(2020, 6, 1, 15, 5, 0, 0, time.UTC)},
+		{name: "WithinTolerance", value: "2020-06-01T15:04:04.8Z", want: now.Add(-200 * time.Millisecond)},
+		{name: "Past", value: "2020-06-01T15:04:00Z", wantErr: true},
+		{name: "Malformed", value: "15:05", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseStartAt(tc.value, now)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %t, got %v", tc.wantErr, err)
+			}
+			if !got.Equal(tc.want) {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWaitForStart(t *testing.T) {
+	startAt := time.Now().Add(50 * time.Millisecond)
+	started, err := WaitForStart(startAt)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if started.Before(startAt) {
+		t.Errorf("expected to start at or after %s, started at %s", startAt, started)
+	}
+
+	if _, err = WaitForStart(time.Now().Add(-time.Second)); err == nil {
+		t.Errorf("expected an error for a start time that's already passed")
+	}
+}
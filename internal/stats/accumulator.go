@@ -0,0 +1,411 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package stats accumulates the responses of a run, or part of one, into a summary
+// of their latencies, rates, and status distributions. Summaries can be taken while
+// responses are still being added, and the responses of several runs, or workers,
+// can be merged into a single summary.
+package stats
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// Response is the outcome of a single request
+type Response struct {
+	// URL and Method are the endpoint the request was made to
+	URL    string
+	Method string
+	// Status is the response's HTTP status
+	Status int
+	// Duration is how long the request took
+	Duration time.Duration
+	// BytesReceived is the size of the response body
+	BytesReceived int64
+	// ErrCategory, if set, is why the request failed. Failed requests are only
+	// counted by category.
+	ErrCategory string
+	// Abandoned requests never completed, they're only included in the time spent
+	// in flight
+	Abandoned bool
+	// Completed is when the response was received. Responses without it aren't
+	// included in the time series.
+	Completed time.Time
+}
+
+// Config configures an Accumulator
+type Config struct {
+	// Start is the start of the period the responses are summarized over
+	Start time.Time
+	// Origin is the time the time series intervals are relative to, e.g., the start
+	// of the run when the period is only part of it. Start is used if it isn't set.
+	Origin time.Time
+	// Interval is the length of the time series intervals, a second if it isn't set
+	Interval time.Duration
+}
+
+// Summary is a summary of the responses added to an Accumulator. Its slices and
+// maps aren't shared with the Accumulator.
+type Summary struct {
+	// Duration is the length of the period summarized, from the Config's Start to
+	// when the Summary was taken
+	Duration time.Duration
+	// RqstStats are the stats of the successful requests. As in api.RunSummary, its
+	// Min is math.MaxInt64 and its Max is -1 if there weren't any.
+	RqstStats api.RqstStats
+	// RqstRatePerSec is the rate at which successful requests were made over the
+	// Duration
+	RqstRatePerSec float64
+	// InFlightNanos is the total time requests, including failed and abandoned
+	// ones, were in flight
+	InFlightNanos time.Duration
+	// ErrorCategories are the number of failed requests by category, nil if there
+	// weren't any
+	ErrorCategories map[string]int64
+	// Endpoints are the summaries of each endpoint, keyed by URL
+	Endpoints map[string]*EndpointSummary
+	// TimeSeriesIntervalNanos is the length of the time series intervals, it's only
+	// set if there are any
+	TimeSeriesIntervalNanos time.Duration
+	// TimeSeries are the successful requests completed during each interval
+	TimeSeries []api.TimeSeriesSample
+}
+
+// EndpointSummary is a summary of the responses from a single endpoint
+type EndpointSummary struct {
+	// Methods are the stats of the successful requests, keyed by method
+	Methods map[string]*api.RqstStats
+	// StatusDist is the number of successful requests with each status, keyed by
+	// method
+	StatusDist map[string]map[int]int
+	// NotModifiedCount is the number of successful requests whose response was a
+	// 304 Not Modified
+	NotModifiedCount int64
+	// ErrorCategories are the number of failed requests by category, nil if there
+	// weren't any
+	ErrorCategories map[string]int64
+	// MinBytes, MaxBytes, TotalBytes, and AvgBytes are the sizes of the successful
+	// requests' response bodies
+	MinBytes   int64
+	MaxBytes   int64
+	TotalBytes int64
+	AvgBytes   int64
+}
+
+// Accumulator accumulates responses into a Summary. It's safe for concurrent use.
+type Accumulator struct {
+	start    time.Time
+	origin   time.Time
+	interval time.Duration
+	// now returns the current time, it's replaced by tests
+	now func() time.Time
+
+	mu         sync.Mutex
+	rqsts      api.RqstStats
+	inFlight   time.Duration
+	errors     map[string]int64
+	endpoints  map[string]*EndpointSummary
+	timeSeries []api.TimeSeriesSample
+}
+
+// NewAccumulator returns an Accumulator without any responses
+func NewAccumulator(config Config) *Accumulator {
+	a := Accumulator{
+		start:    config.Start,
+		origin:   config.Origin,
+		interval: config.Interval,
+		now:      time.Now,
+		rqsts: api.RqstStats{
+			MaxRqstDurationNanos: -1,
+			MinRqstDurationNanos: time.Duration(math.MaxInt64),
+		},
+		endpoints: make(map[string]*EndpointSummary),
+	}
+	if a.origin.IsZero() {
+		a.origin = a.start
+	}
+	if a.interval <= 0 {
+		a.interval = time.Second
+	}
+	return &a
+}
+
+// Add adds 'resp' to the Accumulator
+func (a *Accumulator) Add(resp Response) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.inFlight += resp.Duration
+	ep := a.endpoint(resp.URL)
+	if resp.ErrCategory != "" {
+		if a.errors == nil {
+			a.errors = make(map[string]int64)
+		}
+		a.errors[resp.ErrCategory]++
+		if ep.ErrorCategories == nil {
+			ep.ErrorCategories = make(map[string]int64)
+		}
+		ep.ErrorCategories[resp.ErrCategory]++
+		return
+	}
+	if resp.Abandoned {
+		return
+	}
+
+	addRqst(&a.rqsts, resp.Duration)
+	methodStats, ok := ep.Methods[resp.Method]
+	if !ok {
+		methodStats = &api.RqstStats{}
+		ep.Methods[resp.Method] = methodStats
+	}
+	addRqst(methodStats, resp.Duration)
+	statuses, ok := ep.StatusDist[resp.Method]
+	if !ok {
+		statuses = make(map[int]int)
+		ep.StatusDist[resp.Method] = statuses
+	}
+	statuses[resp.Status]++
+	if resp.Status == http.StatusNotModified {
+		ep.NotModifiedCount++
+	}
+
+	if rqsts(ep) == 1 || resp.BytesReceived < ep.MinBytes {
+		ep.MinBytes = resp.BytesReceived
+	}
+	if resp.BytesReceived > ep.MaxBytes {
+		ep.MaxBytes = resp.BytesReceived
+	}
+	ep.TotalBytes += resp.BytesReceived
+
+	if !resp.Completed.IsZero() {
+		i := int(resp.Completed.Sub(a.origin) / a.interval)
+		if i < 0 {
+			i = 0
+		}
+		a.growTimeSeries(i + 1)
+		a.timeSeries[i].TotalRqsts++
+		a.timeSeries[i].TotalRequestDurationNanos += resp.Duration
+	}
+}
+
+// Merge adds the responses added to 'other' to the Accumulator. The Accumulators
+// must have the same time series Origin and Interval.
+func (a *Accumulator) Merge(other *Accumulator) {
+	if a == other {
+		return
+	}
+	// 'other' is copied first so the Accumulators are never both locked
+	other.mu.Lock()
+	start := other.start
+	o := other.snapshot()
+	other.mu.Unlock()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if start.Before(a.start) {
+		a.start = start
+	}
+	mergeRqsts(&a.rqsts, o.RqstStats)
+	a.inFlight += o.InFlightNanos
+	if o.ErrorCategories != nil && a.errors == nil {
+		a.errors = make(map[string]int64)
+	}
+	for category, count := range o.ErrorCategories {
+		a.errors[category] += count
+	}
+
+	for url, otherEP := range o.Endpoints {
+		ep := a.endpoint(url)
+		hadRqsts := rqsts(ep) > 0
+		for method, stats := range otherEP.Methods {
+			methodStats, ok := ep.Methods[method]
+			if !ok {
+				methodStats = &api.RqstStats{}
+				ep.Methods[method] = methodStats
+			}
+			mergeRqsts(methodStats, *stats)
+		}
+		for method, otherStatuses := range otherEP.StatusDist {
+			statuses, ok := ep.StatusDist[method]
+			if !ok {
+				statuses = make(map[int]int)
+				ep.StatusDist[method] = statuses
+			}
+			for status, count := range otherStatuses {
+				statuses[status] += count
+			}
+		}
+		ep.NotModifiedCount += otherEP.NotModifiedCount
+		if otherEP.ErrorCategories != nil && ep.ErrorCategories == nil {
+			ep.ErrorCategories = make(map[string]int64)
+		}
+		for category, count := range otherEP.ErrorCategories {
+			ep.ErrorCategories[category] += count
+		}
+		if rqsts(otherEP) > 0 {
+			if !hadRqsts || otherEP.MinBytes < ep.MinBytes {
+				ep.MinBytes = otherEP.MinBytes
+			}
+			if otherEP.MaxBytes > ep.MaxBytes {
+				ep.MaxBytes = otherEP.MaxBytes
+			}
+			ep.TotalBytes += otherEP.TotalBytes
+		}
+	}
+
+	a.growTimeSeries(len(o.TimeSeries))
+	for i, sample := range o.TimeSeries {
+		a.timeSeries[i].TotalRqsts += sample.TotalRqsts
+		a.timeSeries[i].TotalRequestDurationNanos += sample.TotalRequestDurationNanos
+	}
+}
+
+// Snapshot returns a summary of the responses added so far. Responses added after
+// it's taken don't affect it.
+func (a *Accumulator) Snapshot() Summary {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.snapshot()
+}
+
+// snapshot returns a summary of the responses added so far. The caller must hold
+// a.mu.
+func (a *Accumulator) snapshot() Summary {
+	summary := Summary{
+		Duration:      a.now().Sub(a.start),
+		RqstStats:     snapshotRqsts(a.rqsts),
+		InFlightNanos: a.inFlight,
+		Endpoints:     make(map[string]*EndpointSummary, len(a.endpoints)),
+	}
+	if summary.Duration > 0 {
+		summary.RqstRatePerSec = float64(summary.RqstStats.TotalRqsts) / summary.Duration.Seconds()
+	}
+	if a.errors != nil {
+		summary.ErrorCategories = copyCounts(a.errors)
+	}
+
+	for url, ep := range a.endpoints {
+		epSummary := EndpointSummary{
+			Methods:          make(map[string]*api.RqstStats, len(ep.Methods)),
+			StatusDist:       make(map[string]map[int]int, len(ep.StatusDist)),
+			NotModifiedCount: ep.NotModifiedCount,
+			MinBytes:         ep.MinBytes,
+			MaxBytes:         ep.MaxBytes,
+			TotalBytes:       ep.TotalBytes,
+		}
+		for method, stats := range ep.Methods {
+			methodStats := snapshotRqsts(*stats)
+			epSummary.Methods[method] = &methodStats
+		}
+		for method, statuses := range ep.StatusDist {
+			epSummary.StatusDist[method] = make(map[int]int, len(statuses))
+			for status, count := range statuses {
+				epSummary.StatusDist[method][status] = count
+			}
+		}
+		if ep.ErrorCategories != nil {
+			epSummary.ErrorCategories = copyCounts(ep.ErrorCategories)
+		}
+		if n := rqsts(ep); n > 0 {
+			epSummary.AvgBytes = ep.TotalBytes / n
+		}
+		summary.Endpoints[url] = &epSummary
+	}
+
+	if len(a.timeSeries) > 0 {
+		summary.TimeSeriesIntervalNanos = a.interval
+		summary.TimeSeries = append([]api.TimeSeriesSample(nil), a.timeSeries...)
+		for i := range summary.TimeSeries {
+			sample := &summary.TimeSeries[i]
+			if sample.TotalRqsts > 0 {
+				sample.AvgRqstDurationNanos = sample.TotalRequestDurationNanos / time.Duration(sample.TotalRqsts)
+			}
+			sample.RqstRatePerSec = float64(sample.TotalRqsts) / a.interval.Seconds()
+		}
+	}
+	return summary
+}
+
+// endpoint returns the summary of 'url', creating it if needed
+func (a *Accumulator) endpoint(url string) *EndpointSummary {
+	ep, ok := a.endpoints[url]
+	if !ok {
+		ep = &EndpointSummary{
+			Methods:    make(map[string]*api.RqstStats),
+			StatusDist: make(map[string]map[int]int),
+		}
+		a.endpoints[url] = ep
+	}
+	return ep
+}
+
+// growTimeSeries extends the time series to at least 'n' intervals
+func (a *Accumulator) growTimeSeries(n int) {
+	for len(a.timeSeries) < n {
+		a.timeSeries = append(a.timeSeries,
+			api.TimeSeriesSample{OffsetNanos: time.Duration(len(a.timeSeries)) * a.interval})
+	}
+}
+
+// rqsts returns the number of successful requests to 'ep'
+func rqsts(ep *EndpointSummary) int64 {
+	var n int64
+	for _, stats := range ep.Methods {
+		n += stats.TotalRqsts
+	}
+	return n
+}
+
+// addRqst adds a request of duration 'd' to 'stats'
+func addRqst(stats *api.RqstStats, d time.Duration) {
+	if stats.TotalRqsts == 0 || d > stats.MaxRqstDurationNanos {
+		stats.MaxRqstDurationNanos = d
+	}
+	if stats.TotalRqsts == 0 || d < stats.MinRqstDurationNanos {
+		stats.MinRqstDurationNanos = d
+	}
+	stats.TotalRqsts++
+	stats.TotalRequestDurationNanos += d
+	stats.TimingResultsNanos = append(stats.TimingResultsNanos, d)
+}
+
+// mergeRqsts adds the requests of 'other' to 'stats'
+func mergeRqsts(stats *api.RqstStats, other api.RqstStats) {
+	if other.TotalRqsts == 0 {
+		return
+	}
+	if stats.TotalRqsts == 0 || other.MaxRqstDurationNanos > stats.MaxRqstDurationNanos {
+		stats.MaxRqstDurationNanos = other.MaxRqstDurationNanos
+	}
+	if stats.TotalRqsts == 0 || other.MinRqstDurationNanos < stats.MinRqstDurationNanos {
+		stats.MinRqstDurationNanos = other.MinRqstDurationNanos
+	}
+	stats.TotalRqsts += other.TotalRqsts
+	stats.TotalRequestDurationNanos += other.TotalRequestDurationNanos
+	stats.TimingResultsNanos = append(stats.TimingResultsNanos, other.TimingResultsNanos...)
+}
+
+// snapshotRqsts returns a copy of 'stats' with its average calculated
+func snapshotRqsts(stats api.RqstStats) api.RqstStats {
+	snapshot := stats
+	snapshot.TimingResultsNanos = append([]time.Duration(nil), stats.TimingResultsNanos...)
+	if snapshot.TotalRqsts > 0 {
+		snapshot.AvgRqstDurationNanos = snapshot.TotalRequestDurationNanos / time.Duration(snapshot.TotalRqsts)
+	}
+	return snapshot
+}
+
+// copyCounts returns a copy of 'counts'
+func copyCounts(counts map[string]int64) map[string]int64 {
+	c := make(map[string]int64, len(counts))
+	for k, v := range counts {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package stats
+
+import (
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+var start = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestAccumulator returns an Accumulator whose Snapshots are taken 'elapsed'
+// after 'start'
+func newTestAccumulator(elapsed time.Duration) *Accumulator {
+	a := NewAccumulator(Config{Start: start})
+	a.now = func() time.Time { return start.Add(elapsed) }
+	return a
+}
+
+// testResponses are successful, failed, and abandoned responses from two endpoints
+// over 3 seconds
+var testResponses = []Response{
+	{URL: "/a", Method: "GET", Status: 200, Duration: 10 * time.Millisecond, BytesReceived: 100, Completed: start.Add(100 * time.Millisecond)},
+	{URL: "/a", Method: "GET", Status: 404, Duration: 30 * time.Millisecond, BytesReceived: 20, Completed: start.Add(1500 * time.Millisecond)},
+	{URL: "/a", Method: "PUT", Status: 201, Duration: 20 * time.Millisecond, BytesReceived: 0, Completed: start.Add(2500 * time.Millisecond)},
+	{URL: "/a", Method: "GET", Duration: 5 * time.Millisecond, ErrCategory: api.ErrCategoryConnection},
+	{URL: "/b", Method: "GET", Status: 200, Duration: 40 * time.Millisecond, BytesReceived: 50},
+	{URL: "/b", Method: "GET", Duration: 50 * time.Millisecond, Abandoned: true},
+}
+
+func TestAccumulator(t *testing.T) {
+	a := newTestAccumulator(2 * time.Second)
+	for _, resp := range testResponses {
+		a.Add(resp)
+	}
+	summary := a.Snapshot()
+
+	expectedRqsts := api.RqstStats{
+		TimingResultsNanos:        []time.Duration{10 * time.Millisecond, 30 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond},
+		TotalRqsts:                4,
+		TotalRequestDurationNanos: 100 * time.Millisecond,
+		MinRqstDurationNanos:      10 * time.Millisecond,
+		MaxRqstDurationNanos:      40 * time.Millisecond,
+		AvgRqstDurationNanos:      25 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(summary.RqstStats, expectedRqsts) {
+		t.Errorf("expected %+v, got %+v", expectedRqsts, summary.RqstStats)
+	}
+	if summary.Duration != 2*time.Second || summary.RqstRatePerSec != 2 {
+		t.Errorf("expected 2 requests/sec over 2 secs, got %v over %s", summary.RqstRatePerSec, summary.Duration)
+	}
+	if summary.InFlightNanos != 155*time.Millisecond {
+		t.Errorf("expected failed and abandoned requests to be in flight, got %s", summary.InFlightNanos)
+	}
+	if !reflect.DeepEqual(summary.ErrorCategories, map[string]int64{api.ErrCategoryConnection: 1}) {
+		t.Errorf("expected a connection error, got %v", summary.ErrorCategories)
+	}
+
+	a1 := summary.Endpoints["/a"]
+	if a1 == nil || len(summary.Endpoints) != 2 {
+		t.Fatalf("expected 2 endpoints, got %v", summary.Endpoints)
+	}
+	if get := a1.Methods["GET"]; get.TotalRqsts != 2 || get.MinRqstDurationNanos != 10*time.Millisecond ||
+		get.MaxRqstDurationNanos != 30*time.Millisecond || get.AvgRqstDurationNanos != 20*time.Millisecond {
+		t.Errorf("expected 2 GETs of /a averaging 20ms, got %+v", get)
+	}
+	expectedDist := map[string]map[int]int{"GET": {200: 1, 404: 1}, "PUT": {201: 1}}
+	if !reflect.DeepEqual(a1.StatusDist, expectedDist) {
+		t.Errorf("expected the status distribution %v, got %v", expectedDist, a1.StatusDist)
+	}
+	if a1.MinBytes != 0 || a1.MaxBytes != 100 || a1.TotalBytes != 120 || a1.AvgBytes != 40 {
+		t.Errorf("expected the bytes received from /a to be 0 - 100, avg 40, got %+v", a1)
+	}
+	if b := summary.Endpoints["/b"]; b.ErrorCategories != nil || b.MinBytes != 50 || b.Methods["GET"].TotalRqsts != 1 {
+		t.Errorf("expected an abandoned request to /b not to be counted, got %+v", b)
+	}
+
+	expectedSeries := []api.TimeSeriesSample{
+		{OffsetNanos: 0, TotalRqsts: 1, TotalRequestDurationNanos: 10 * time.Millisecond, AvgRqstDurationNanos: 10 * time.Millisecond, RqstRatePerSec: 1},
+		{OffsetNanos: time.Second, TotalRqsts: 1, TotalRequestDurationNanos: 30 * time.Millisecond, AvgRqstDurationNanos: 30 * time.Millisecond, RqstRatePerSec: 1},
+		{OffsetNanos: 2 * time.Second, TotalRqsts: 1, TotalRequestDurationNanos: 20 * time.Millisecond, AvgRqstDurationNanos: 20 * time.Millisecond, RqstRatePerSec: 1},
+	}
+	if summary.TimeSeriesIntervalNanos != time.Second || !reflect.DeepEqual(summary.TimeSeries, expectedSeries) {
+		t.Errorf("expected the time series %+v, got %s %+v", expectedSeries, summary.TimeSeriesIntervalNanos, summary.TimeSeries)
+	}
+}
+
+// TestAccumulatorEmpty verifies the summary of no responses matches that of a run
+// without any
+func TestAccumulatorEmpty(t *testing.T) {
+	summary := newTestAccumulator(time.Second).Snapshot()
+	if summary.RqstStats.TotalRqsts != 0 || summary.RqstStats.MinRqstDurationNanos != time.Duration(math.MaxInt64) ||
+		summary.RqstStats.MaxRqstDurationNanos != -1 || summary.RqstStats.TimingResultsNanos != nil {
+		t.Errorf("expected empty request stats, got %+v", summary.RqstStats)
+	}
+	if summary.RqstRatePerSec != 0 || summary.ErrorCategories != nil || len(summary.Endpoints) != 0 ||
+		summary.TimeSeries != nil || summary.TimeSeriesIntervalNanos != 0 {
+		t.Errorf("expected an empty summary, got %+v", summary)
+	}
+}
+
+// TestAccumulatorSnapshot verifies a snapshot isn't affected by the responses added
+// after it's taken
+func TestAccumulatorSnapshot(t *testing.T) {
+	a := newTestAccumulator(time.Second)
+	a.Add(testResponses[0])
+	first := a.Snapshot()
+	for _, resp := range testResponses[1:] {
+		a.Add(resp)
+	}
+	second := a.Snapshot()
+
+	if first.RqstStats.TotalRqsts != 1 || len(first.RqstStats.TimingResultsNanos) != 1 ||
+		first.Endpoints["/a"].Methods["GET"].TotalRqsts != 1 || len(first.Endpoints["/a"].StatusDist["GET"]) != 1 ||
+		len(first.Endpoints) != 1 || first.ErrorCategories != nil || len(first.TimeSeries) != 1 {
+		t.Errorf("expected the first snapshot to only include the first response, got %+v", first)
+	}
+	if second.RqstStats.TotalRqsts != 4 || len(second.Endpoints) != 2 {
+		t.Errorf("expected the second snapshot to include all of the responses, got %+v", second)
+	}
+}
+
+// TestAccumulatorMerge verifies merging accumulators is the same as adding all of
+// their responses to one
+func TestAccumulatorMerge(t *testing.T) {
+	all := newTestAccumulator(3 * time.Second)
+	for _, resp := range testResponses {
+		all.Add(resp)
+	}
+
+	merged := newTestAccumulator(3 * time.Second)
+	// The accumulators are the responses of different workers, and one without any
+	empty := newTestAccumulator(3 * time.Second)
+	workers := []*Accumulator{newTestAccumulator(3 * time.Second), newTestAccumulator(3 * time.Second)}
+	for i, resp := range testResponses {
+		workers[i%2].Add(resp)
+	}
+	merged.Merge(empty)
+	merged.Merge(workers[1])
+	merged.Merge(workers[0])
+	merged.Merge(merged)
+
+	expected, actual := all.Snapshot(), merged.Snapshot()
+	// The requests are merged in a different order than they were added
+	if len(actual.RqstStats.TimingResultsNanos) != len(expected.RqstStats.TimingResultsNanos) {
+		t.Errorf("expected %d request timings, got %d", len(expected.RqstStats.TimingResultsNanos), len(actual.RqstStats.TimingResultsNanos))
+	}
+	expected.RqstStats.TimingResultsNanos, actual.RqstStats.TimingResultsNanos = nil, nil
+	for _, summary := range []Summary{expected, actual} {
+		for _, ep := range summary.Endpoints {
+			for _, stats := range ep.Methods {
+				stats.TimingResultsNanos = nil
+			}
+		}
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected the merged summary\n%+v\ngot\n%+v", expected, actual)
+	}
+}
+
+// TestAccumulatorConcurrent verifies responses can be added, and summaries taken and
+// merged, concurrently
+func TestAccumulatorConcurrent(t *testing.T) {
+	a := newTestAccumulator(time.Second)
+	other := newTestAccumulator(time.Second)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				a.Add(testResponses[j%len(testResponses)])
+				other.Add(testResponses[0])
+				a.Snapshot()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		other.Merge(a)
+	}()
+	wg.Wait()
+	a.Merge(other)
+
+	if n := a.Snapshot().Endpoints["/a"].Methods["GET"].TotalRqsts; n < 400+4*34 {
+		t.Errorf("expected at least %d GETs of /a, got %d", 400+4*34, n)
+	}
+}
+
+func BenchmarkAccumulatorAdd(b *testing.B) {
+	a := NewAccumulator(Config{Start: start})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		a.Add(testResponses[i%len(testResponses)])
+	}
+}
+
+func BenchmarkAccumulatorSnapshot(b *testing.B) {
+	a := NewAccumulator(Config{Start: start})
+	for i := 0; i < 10000; i++ {
+		a.Add(testResponses[i%len(testResponses)])
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.Snapshot()
+	}
+}
+
+func BenchmarkAccumulatorMerge(b *testing.B) {
+	other := NewAccumulator(Config{Start: start})
+	for i := 0; i < 1000; i++ {
+		other.Add(testResponses[i%len(testResponses)])
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewAccumulator(Config{Start: start}).Merge(other)
+	}
+}
+
+// TestAccumulatorNotModified verifies the successful 304 responses of each endpoint
+// are counted, including when accumulators are merged
+func TestAccumulatorNotModified(t *testing.T) {
+	a, b := newTestAccumulator(time.Second), newTestAccumulator(time.Second)
+	a.Add(Response{URL: "/a", Method: "GET", Status: 200, Duration: time.Millisecond})
+	a.Add(Response{URL: "/a", Method: "GET", Status: 304, Duration: time.Millisecond})
+	b.Add(Response{URL: "/a", Method: "GET", Status: 304, Duration: time.Millisecond})
+	b.Add(Response{URL: "/a", Method: "GET", Status: 304, Duration: time.Millisecond, ErrCategory: api.ErrCategoryConnection})
+	if n := a.Snapshot().Endpoints["/a"].NotModifiedCount; n != 1 {
+		t.Errorf("expected 1 304, got %d", n)
+	}
+	a.Merge(b)
+	if n := a.Snapshot().Endpoints["/a"].NotModifiedCount; n != 2 {
+		t.Errorf("expected 2 304s once merged, got %d", n)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// ValidateStatusExpectations verifies that each of 'expectations', from
+// LoadTestConfig.ExpectedStatusDistribution, is valid
+func ValidateStatusExpectations(expectations []api.StatusExpectation) error {
+	for _, e := range expectations {
+		if _, _, err := statusRange(e.Status); err != nil {
+			return err
+		}
+		if e.MinFraction < 0 || e.MinFraction > 1 {
+			return fmt.Errorf("ExpectedStatusDistribution: %s MinFraction, %f, must be from 0 to 1", e.Status, e.MinFraction)
+		}
+		if e.MaxFraction != nil && (*e.MaxFraction < e.MinFraction || *e.MaxFraction > 1) {
+			return fmt.Errorf("ExpectedStatusDistribution: %s MaxFraction, %f, must be from MinFraction to 1", e.Status, *e.MaxFraction)
+		}
+	}
+	return nil
+}
+
+// statusRange returns the inclusive range of HTTP statuses described by 'status',
+// either a single status, e.g., '200', or a class of statuses, e.g., '5xx'
+func statusRange(status string) (int, int, error) {
+	if len(status) == 3 && strings.HasSuffix(strings.ToLower(status), "xx") {
+		class := int(status[0] - '0')
+		if class >= 1 && class <= 5 {
+			return class * 100, class*100 + 99, nil
+		}
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 100 || code > 599 {
+		return 0, 0, fmt.Errorf("ExpectedStatusDistribution: Status, '%s', must be an HTTP status, e.g., '200', or class of statuses, e.g., '5xx'",
+			status)
+	}
+	return code, code, nil
+}
+
+// checkStatusDist sets 'rs.StatusDistFailed', and describes the violations in
+// 'rs.StatusDistViolations', if the statuses in 'epDetails' don't meet
+// 'expectations'. Fractions are of all the responses, so those that failed without a
+// status count against MinFractions.
+func checkStatusDist(expectations []api.StatusExpectation, rs *api.RunSummary, epDetails map[string]*api.EndpointDetail) {
+	if len(expectations) == 0 {
+		return
+	}
+
+	statuses := make(map[int]int64)
+	total := rs.AbandonedSlow
+	for _, count := range rs.ErrorCategories {
+		total += count
+	}
+	for _, epDetail := range epDetails {
+		for _, statusDist := range epDetail.HTTPMethodStatusDist {
+			for status, count := range statusDist {
+				statuses[status] += int64(count)
+				total += int64(count)
+			}
+		}
+	}
+	if total == 0 {
+		rs.StatusDistFailed = true
+		rs.StatusDistViolations = append(rs.StatusDistViolations, "there were no responses to check against ExpectedStatusDistribution")
+		return
+	}
+
+	for _, e := range expectations {
+		// Expectations are validated before the run
+		low, high, _ := statusRange(e.Status)
+		var count int64
+		for status, n := range statuses {
+			if status >= low && status <= high {
+				count += n
+			}
+		}
+
+		fraction := float64(count) / float64(total)
+		switch {
+		case fraction < e.MinFraction:
+			rs.StatusDistViolations = append(rs.StatusDistViolations,
+				fmt.Sprintf("%s: %d of %d responses (%s), expected at least %s", e.Status, count, total,
+					formatPercent(fraction), formatPercent(e.MinFraction)))
+		case e.MaxFraction != nil && fraction > *e.MaxFraction:
+			rs.StatusDistViolations = append(rs.StatusDistViolations,
+				fmt.Sprintf("%s: %d of %d responses (%s), expected at most %s", e.Status, count, total,
+					formatPercent(fraction), formatPercent(*e.MaxFraction)))
+		}
+	}
+	rs.StatusDistFailed = len(rs.StatusDistViolations) > 0
+}
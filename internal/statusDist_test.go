@@ -0,0 +1,105 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestStatusDist verifies the run fails when the response statuses don't meet the
+// ExpectedStatusDistribution
+func TestStatusDist(t *testing.T) {
+	zero := 0.0
+	expectations := []api.StatusExpectation{
+		{Status: "200", MinFraction: 0.99},
+		{Status: "5xx", MaxFraction: &zero},
+	}
+	if err := ValidateStatusExpectations(expectations); err != nil {
+		t.Fatalf("unexpected error validating expectations: %s", err)
+	}
+
+	newResponses := func(statuses map[int]int, connErrs int) []Response {
+		var responses []Response
+		for status, n := range statuses {
+			for i := 0; i < n; i++ {
+				responses = append(responses, Response{HTTPStatus: status, RequestDuration: time.Millisecond,
+					Endpoint: api.Endpoint{URL: "http://someservice.test/", Method: "GET"}})
+			}
+		}
+		for i := 0; i < connErrs; i++ {
+			responses = append(responses, Response{ErrCategory: api.ErrCategoryConnection,
+				Endpoint: api.Endpoint{URL: "http://someservice.test/", Method: "GET"}})
+		}
+		return responses
+	}
+
+	tests := []struct {
+		name               string
+		responses          []Response
+		expectedFailed     bool
+		expectedViolations int
+	}{
+		{name: "Met", responses: newResponses(map[int]int{200: 995, 404: 5}, 0)},
+		{name: "Too few 200s", responses: newResponses(map[int]int{200: 980, 404: 20}, 0),
+			expectedFailed: true, expectedViolations: 1},
+		{name: "Any 5xx", responses: newResponses(map[int]int{200: 999, 503: 1}, 0),
+			expectedFailed: true, expectedViolations: 1},
+		{name: "Both", responses: newResponses(map[int]int{200: 90, 500: 10}, 0),
+			expectedFailed: true, expectedViolations: 2},
+		{name: "Connection errors", responses: newResponses(map[int]int{200: 95}, 5),
+			expectedFailed: true, expectedViolations: 1},
+		{name: "No responses", expectedFailed: true, expectedViolations: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rh := ResponseHandler{ExpectedStatusDist: expectations, start: time.Now()}
+			runResults, err := rh.summarize(tc.responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			rs := runResults.RunSummary
+			if rs.StatusDistFailed != tc.expectedFailed {
+				t.Errorf("expected StatusDistFailed to be %t, got %t: %v", tc.expectedFailed, rs.StatusDistFailed,
+					rs.StatusDistViolations)
+			}
+			if len(rs.StatusDistViolations) != tc.expectedViolations {
+				t.Errorf("expected %d violations, got %v", tc.expectedViolations, rs.StatusDistViolations)
+			}
+		})
+	}
+}
+
+// TestValidateStatusExpectations verifies invalid expectations are rejected
+func TestValidateStatusExpectations(t *testing.T) {
+	half := 0.5
+	tests := []struct {
+		name        string
+		expectation api.StatusExpectation
+		valid       bool
+	}{
+		{name: "Status", expectation: api.StatusExpectation{Status: "204", MinFraction: 0.5}, valid: true},
+		{name: "Class", expectation: api.StatusExpectation{Status: "4XX", MaxFraction: &half}, valid: true},
+		{name: "Invalid status", expectation: api.StatusExpectation{Status: "ok"}},
+		{name: "Invalid class", expectation: api.StatusExpectation{Status: "9xx"}},
+		{name: "MinFraction too large", expectation: api.StatusExpectation{Status: "200", MinFraction: 99}},
+		{name: "MaxFraction below MinFraction", expectation: api.StatusExpectation{Status: "200", MinFraction: 0.9, MaxFraction: &half}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateStatusExpectations([]api.StatusExpectation{tc.expectation})
+			if tc.valid && err != nil {
+				t.Errorf("expected a valid expectation, got %s", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("expected an invalid expectation")
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+const (
+	// maxStatusFlapEvents bounds the number of flaps reported in
+	// EndpointDetail.StatusFlapEvents. All of them are counted.
+	maxStatusFlapEvents = 100
+	// statusClassError is the status class of failed requests without a status
+	statusClassError = "error"
+)
+
+// statusClass returns the status class, e.g., '2xx', of 'resp'
+func statusClass(resp Response) string {
+	if resp.HTTPStatus < 100 || resp.HTTPStatus > 599 {
+		return statusClassError
+	}
+	return fmt.Sprintf("%dxx", resp.HTTPStatus/100)
+}
+
+// majorityClass returns the status class with the most responses in 'classes'. Ties
+// go to 'prev', the previous majority class, if it's one of them, otherwise to the
+// lowest class, so a tie alone isn't a flap.
+func majorityClass(classes map[string]int64, prev string) string {
+	var majority string
+	var most int64
+	for class, cnt := range classes {
+		switch {
+		case cnt > most:
+			majority, most = class, cnt
+		case cnt == most && (class == prev || (majority != prev && class < majority)):
+			majority = class
+		}
+	}
+	return majority
+}
+
+// accumulateStatusTimeSeries adds 'resp', which completed in the time series interval
+// 'interval' of length 'intervalLen', to 'epDetail's StatusTimeSeries. Responses must
+// be added in the order they completed.
+func accumulateStatusTimeSeries(resp Response, interval int, intervalLen time.Duration, epDetail *api.EndpointDetail) {
+	offset := time.Duration(interval) * intervalLen
+	samples := epDetail.StatusTimeSeries
+	if len(samples) == 0 || samples[len(samples)-1].OffsetNanos != offset {
+		epDetail.StatusTimeSeries = append(samples, api.StatusClassSample{
+			OffsetNanos:   offset,
+			StatusClasses: make(map[string]int64),
+		})
+	}
+	epDetail.StatusTimeSeries[len(epDetail.StatusTimeSeries)-1].StatusClasses[statusClass(resp)]++
+}
+
+// finishStatusFlaps sets the majority status class of each of 'epDetail's
+// StatusTimeSeries samples and counts the number of times it changed
+func finishStatusFlaps(epDetail *api.EndpointDetail) {
+	var prev string
+	for i := range epDetail.StatusTimeSeries {
+		sample := &epDetail.StatusTimeSeries[i]
+		sample.MajorityClass = majorityClass(sample.StatusClasses, prev)
+		if i > 0 && sample.MajorityClass != prev {
+			epDetail.StatusFlaps++
+			if len(epDetail.StatusFlapEvents) < maxStatusFlapEvents {
+				epDetail.StatusFlapEvents = append(epDetail.StatusFlapEvents, api.StatusFlap{
+					OffsetNanos: sample.OffsetNanos,
+					From:        prev,
+					To:          sample.MajorityClass,
+				})
+			}
+		}
+		prev = sample.MajorityClass
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestStatusFlaps verifies an endpoint's status classes are counted per time series
+// interval and that changes of its majority status class are reported as flaps
+func TestStatusFlaps(t *testing.T) {
+	start := time.Now()
+	url := "http://someservice.test/"
+	newResponse := func(interval, status int) Response {
+		resp := Response{
+			HTTPStatus:      status,
+			RequestDuration: time.Millisecond,
+			Endpoint:        api.Endpoint{URL: url, Method: "GET"},
+			Completed:       start.Add(time.Duration(interval)*time.Second + time.Millisecond),
+		}
+		if status == 0 {
+			resp.ErrCategory = api.ErrCategoryConnection
+		}
+		return resp
+	}
+
+	// Each interval's responses, by status. Interval 3 has no responses, interval 5
+	// ties so it doesn't change the majority.
+	intervals := []map[int]int{
+		{200: 9, 503: 1},
+		{200: 2, 503: 8},
+		{200: 10},
+		{},
+		{200: 1, 0: 3},
+		{200: 2, 0: 2},
+	}
+	var responses []Response
+	for i, statuses := range intervals {
+		for status, n := range statuses {
+			for j := 0; j < n; j++ {
+				responses = append(responses, newResponse(i, status))
+			}
+		}
+	}
+
+	rh := ResponseHandler{start: start}
+	runResults, err := rh.summarize(responses, start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	epDetail := runResults.EndpointDetails[url]
+
+	var majorities []string
+	for _, s := range epDetail.StatusTimeSeries {
+		majorities = append(majorities, s.MajorityClass)
+	}
+	expectedMajorities := []string{"2xx", "5xx", "2xx", "error", "error"}
+	if !reflect.DeepEqual(majorities, expectedMajorities) {
+		t.Errorf("expected majority status classes %v, got %v", expectedMajorities, majorities)
+	}
+	if classes := epDetail.StatusTimeSeries[3].StatusClasses; !reflect.DeepEqual(classes, map[string]int64{"2xx": 1, "error": 3}) {
+		t.Errorf("expected interval 4's status classes to be 1 2xx and 3 error, got %v", classes)
+	}
+
+	expectedFlaps := []api.StatusFlap{
+		{OffsetNanos: time.Second, From: "2xx", To: "5xx"},
+		{OffsetNanos: 2 * time.Second, From: "5xx", To: "2xx"},
+		{OffsetNanos: 4 * time.Second, From: "2xx", To: "error"},
+	}
+	if epDetail.StatusFlaps != int64(len(expectedFlaps)) {
+		t.Errorf("expected %d status flaps, got %d", len(expectedFlaps), epDetail.StatusFlaps)
+	}
+	if !reflect.DeepEqual(epDetail.StatusFlapEvents, expectedFlaps) {
+		t.Errorf("expected status flaps %+v, got %+v", expectedFlaps, epDetail.StatusFlapEvents)
+	}
+
+	var out bytes.Buffer
+	if err := writeHTMLReport(&out, runResults, nil); err != nil {
+		t.Fatalf("unexpected error writing the HTML report: %s", err)
+	}
+	for _, expected := range []string{"Status Flaps: " + url + " (3)", `class="status-5xx"`, `class="marker"`, "2xx -&gt; 5xx"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected the HTML report to contain %q", expected)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// successExprVars are the variables available to an endpoint's SuccessExpr
+var successExprVars = map[string]bool{
+	"status":     true,
+	"durationMs": true,
+	"bytes":      true,
+	"body":       true,
+}
+
+// successExprEnv are the values of the successExprVars for a single response
+type successExprEnv struct {
+	status     int
+	durationMs float64
+	bytes      int64
+	body       []byte
+}
+
+// lookup returns the value of the variable 'name'
+func (env successExprEnv) lookup(name string) interface{} {
+	switch name {
+	case "status":
+		return float64(env.status)
+	case "durationMs":
+		return env.durationMs
+	case "bytes":
+		return float64(env.bytes)
+	default:
+		return string(env.body)
+	}
+}
+
+// exprNode is a node of a compiled SuccessExpr. Values are float64, string, or bool.
+type exprNode interface {
+	eval(env successExprEnv) (interface{}, error)
+}
+
+type literalNode struct{ value interface{} }
+
+func (n literalNode) eval(_ successExprEnv) (interface{}, error) { return n.value, nil }
+
+type varNode struct{ name string }
+
+func (n varNode) eval(env successExprEnv) (interface{}, error) { return env.lookup(n.name), nil }
+
+type notNode struct{ operand exprNode }
+
+func (n notNode) eval(env successExprEnv) (interface{}, error) {
+	b, err := evalBool(n.operand, env, "!")
+	if err != nil {
+		return nil, err
+	}
+	return !b, nil
+}
+
+// logicalNode is '&&' or '||', the right operand is only evaluated if needed
+type logicalNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n logicalNode) eval(env successExprEnv) (interface{}, error) {
+	left, err := evalBool(n.left, env, n.op)
+	if err != nil {
+		return nil, err
+	}
+	if (n.op == "&&" && !left) || (n.op == "||" && left) {
+		return left, nil
+	}
+	return evalBool(n.right, env, n.op)
+}
+
+type compareNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n compareNode) eval(env successExprEnv) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch l := left.(type) {
+	case float64:
+		if r, ok := right.(float64); ok {
+			return compareOrdered(n.op, l < r, l == r), nil
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			return compareOrdered(n.op, l < r, l == r), nil
+		}
+	case bool:
+		if r, ok := right.(bool); ok && (n.op == "==" || n.op == "!=") {
+			return (l == r) == (n.op == "=="), nil
+		}
+	}
+	return nil, fmt.Errorf("can't apply %s to %T and %T", n.op, left, right)
+}
+
+// compareOrdered returns the result of the comparison 'op' given whether its left
+// operand is less than, or equal to, its right operand
+func compareOrdered(op string, less, equal bool) bool {
+	switch op {
+	case "==":
+		return equal
+	case "!=":
+		return !equal
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	default:
+		return !less
+	}
+}
+
+// containsNode is the 'contains(s, substr)' function
+type containsNode struct{ s, substr exprNode }
+
+func (n containsNode) eval(env successExprEnv) (interface{}, error) {
+	s, err := evalString(n.s, env, "contains")
+	if err != nil {
+		return nil, err
+	}
+	substr, err := evalString(n.substr, env, "contains")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Contains(s, substr), nil
+}
+
+// matchesNode is the 'matches(s, regexp)' function. The regexp must be a string
+// literal so it's compiled with the expression.
+type matchesNode struct {
+	s  exprNode
+	re *regexp.Regexp
+}
+
+func (n matchesNode) eval(env successExprEnv) (interface{}, error) {
+	s, err := evalString(n.s, env, "matches")
+	if err != nil {
+		return nil, err
+	}
+	return n.re.MatchString(s), nil
+}
+
+func evalBool(n exprNode, env successExprEnv, op string) (bool, error) {
+	v, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s requires a boolean, not %T", op, v)
+	}
+	return b, nil
+}
+
+func evalString(n exprNode, env successExprEnv, fn string) (string, error) {
+	v, err := n.eval(env)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s requires string arguments, not %T", fn, v)
+	}
+	return s, nil
+}
+
+// successExpr is a compiled Endpoint.SuccessExpr
+type successExpr struct {
+	src  string
+	root exprNode
+}
+
+// compileSuccessExpr compiles 'src', an Endpoint.SuccessExpr, e.g.,
+// 'status == 200 && durationMs < 300 && contains(body, "\"state\":\"ready\"")'.
+// Expressions combine the successExprVars, number and double quoted string
+// literals, the comparison operators ==, !=, <, <=, >, and >=, the logical
+// operators &&, ||, and !, parentheses, and the functions 'contains(s, substr)'
+// and 'matches(s, regexp)'.
+func compileSuccessExpr(src string) (*successExpr, error) {
+	tokens, err := lexSuccessExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("SuccessExpr %q is invalid: %w", src, err)
+	}
+	p := exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("SuccessExpr %q is invalid: %w", src, err)
+	}
+	return &successExpr{src: src, root: root}, nil
+}
+
+// eval reports whether the response described by 'env' is successful. An error is
+// returned if the expression can't be evaluated, e.g., it compares a number to a string.
+func (e *successExpr) eval(env successExprEnv) (bool, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, fmt.Errorf("error evaluating SuccessExpr %q: %w", e.src, err)
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("SuccessExpr %q evaluated to %v, not a boolean", e.src, v)
+	}
+	return b, nil
+}
+
+type exprTokenKind int
+
+const (
+	tokNumber exprTokenKind = iota
+	tokString
+	tokIdent
+	tokOp
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+	// value is the value of a number or string literal
+	value interface{}
+}
+
+// lexSuccessExpr splits 'src' into tokens
+func lexSuccessExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			f, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", src[i:j])
+			}
+			tokens = append(tokens, exprToken{kind: tokNumber, text: src[i:j], value: f})
+			i = j
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string starting at %d", i)
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s: %w", src[i:j+1], err)
+			}
+			tokens = append(tokens, exprToken{kind: tokString, text: src[i : j+1], value: s})
+			i = j + 1
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: tokIdent, text: src[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			tokens = append(tokens, exprToken{kind: tokOp, text: op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// exprParser is a recursive descent parser of SuccessExpr tokens. From lowest to
+// highest precedence the grammar is:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = primary [ ("==" | "!=" | "<" | "<=" | ">" | ">=") primary ]
+//	primary = number | string | variable | function "(" args ")" | "(" or ")"
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+// accept consumes the next token and returns true if it's the operator 'op'
+func (p *exprParser) accept(op string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokOp && p.tokens[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if p.accept(op) {
+		return nil
+	}
+	if p.pos < len(p.tokens) {
+		return fmt.Errorf("expected %q, found %q", op, p.tokens[p.pos].text)
+	}
+	return fmt.Errorf("expected %q at the end of the expression", op)
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right exprNode
+		right, err = p.parseAnd()
+		left = logicalNode{op: "||", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.accept("&&") {
+		var right exprNode
+		right, err = p.parseUnary()
+		left = logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		return notNode{operand: operand}, err
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.parsePrimary()
+			return compareNode{op: op, left: left, right: right}, err
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of the expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+
+	switch tok.kind {
+	case tokNumber, tokString:
+		return literalNode{value: tok.value}, nil
+	case tokIdent:
+		if p.accept("(") {
+			return p.parseCall(tok.text)
+		}
+		if !successExprVars[tok.text] {
+			return nil, fmt.Errorf("unknown variable %q, expected one of status, durationMs, bytes, or body", tok.text)
+		}
+		return varNode{name: tok.text}, nil
+	}
+
+	if tok.text == "(" {
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	}
+	return nil, fmt.Errorf("unexpected %q", tok.text)
+}
+
+// parseCall parses the arguments of a call to the function 'fn'
+func (p *exprParser) parseCall(fn string) (exprNode, error) {
+	if fn != "contains" && fn != "matches" {
+		return nil, fmt.Errorf("unknown function %q, expected contains or matches", fn)
+	}
+	first, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(","); err != nil {
+		return nil, err
+	}
+	second, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	if fn == "contains" {
+		return containsNode{s: first, substr: second}, nil
+	}
+	lit, ok := second.(literalNode)
+	if !ok {
+		return nil, fmt.Errorf("the regexp passed to matches must be a string literal")
+	}
+	pattern, ok := lit.value.(string)
+	if !ok {
+		return nil, fmt.Errorf("the regexp passed to matches must be a string literal")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regexp passed to matches: %w", err)
+	}
+	return matchesNode{s: first, re: re}, nil
+}
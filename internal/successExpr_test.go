@@ -0,0 +1,112 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestSuccessExprEval verifies expressions are compiled and evaluated against a response
+func TestSuccessExprEval(t *testing.T) {
+	env := successExprEnv{status: 200, durationMs: 120, bytes: 18, body: []byte(`{"state":"ready"}`)}
+
+	tests := []struct {
+		name       string
+		expr       string
+		expected   bool
+		compileErr bool
+		evalErr    bool
+	}{
+		{name: "AllTrue", expr: `status == 200 && durationMs < 300 && contains(body, "\"state\":\"ready\"")`, expected: true},
+		{name: "SlowResponse", expr: `status == 200 && durationMs < 100`, expected: false},
+		{name: "OrNot", expr: `!(status >= 400) || bytes > 1000`, expected: true},
+		{name: "Matches", expr: `matches(body, "state\":\"(ready|done)")`, expected: true},
+		{name: "StringCompare", expr: `body != ""`, expected: true},
+		{name: "UnknownVariable", expr: `latency < 300`, compileErr: true},
+		{name: "UnknownFunction", expr: `startsWith(body, "{")`, compileErr: true},
+		{name: "InvalidRegexp", expr: `matches(body, "(")`, compileErr: true},
+		{name: "NonLiteralRegexp", expr: `matches(body, body)`, compileErr: true},
+		{name: "Unbalanced", expr: `(status == 200`, compileErr: true},
+		{name: "TrailingTokens", expr: `status == 200 200`, compileErr: true},
+		{name: "BadCharacter", expr: `status = 200`, compileErr: true},
+		{name: "TypeMismatch", expr: `status == "200"`, evalErr: true},
+		{name: "NotBoolean", expr: `status`, evalErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			expr, err := compileSuccessExpr(tc.expr)
+			if tc.compileErr {
+				if err == nil {
+					t.Errorf("expected a compile error for %q", tc.expr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected compile error: %s", err)
+			}
+
+			actual, err := expr.eval(env)
+			if tc.evalErr {
+				if err == nil {
+					t.Errorf("expected an evaluation error for %q", tc.expr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected evaluation error: %s", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected %q to be %t, got %t", tc.expr, tc.expected, actual)
+			}
+		})
+	}
+}
+
+// TestSuccessExprResponses verifies responses are categorized by their endpoint's SuccessExpr
+func TestSuccessExprResponses(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"state":"` + r.URL.Query().Get("state") + `"}`))
+	}))
+	defer testSrv.Close()
+
+	tests := []struct {
+		name             string
+		state            string
+		expr             string
+		expectedCategory string
+	}{
+		{name: "Success", state: "ready", expr: `status == 200 && contains(body, "\"state\":\"ready\"")`},
+		{name: "Failure", state: "pending", expr: `status == 200 && contains(body, "\"state\":\"ready\"")`,
+			expectedCategory: api.ErrCategoryUnsuccessfulExpr},
+		{name: "EvalError", state: "ready", expr: `body > 10`, expectedCategory: api.ErrCategorySuccessExprError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			respC := make(chan Response, 1)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    http.Client{},
+			}
+			ep := api.Endpoint{URL: testSrv.URL + "?state=" + tc.state, Method: http.MethodGet, RqstPercent: 100,
+				SuccessExpr: tc.expr}
+			rqstr.ProcessRqst(ep, 1, 0)
+			close(respC)
+
+			resp := <-respC
+			if resp.ErrCategory != tc.expectedCategory {
+				t.Errorf("expected error category %q, got %q: %v", tc.expectedCategory, resp.ErrCategory, resp.Err)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+	"github.com/youngkin/heyyall/internal/stats"
+)
+
+// Scenario is one of the runs of a suite
+type Scenario struct {
+	// Name identifies the scenario in the suite's report, e.g., the name of its config file
+	Name string
+	// Config is the scenario's config
+	Config api.LoadTestConfig
+}
+
+// RunSuite runs 'scenarios' one after the other, sending their requests using
+// 'client', and returns the results of each of them along with an aggregate of all
+// of their responses. Each scenario's responses are summarized by a ResponseHandler,
+// the same way as a single run's, which also accumulates their latencies, rates, and
+// statuses for the aggregate. The suite stops at the first scenario that can't be
+// run, e.g., because its config is invalid, or if 'ctx' is canceled.
+func RunSuite(ctx context.Context, client http.Client, scenarios []Scenario) (api.SuiteResults, error) {
+	var (
+		results     api.SuiteResults
+		concurrency int
+	)
+	start := time.Now()
+	aggregate := stats.NewAccumulator(stats.Config{Start: start})
+	for _, s := range scenarios {
+		if ctx.Err() != nil {
+			return results, fmt.Errorf("the suite was canceled before scenario %s: %w", s.Name, ctx.Err())
+		}
+		log.Info().Msgf("suite: running scenario %s", s.Name)
+		runResults, err := runScenario(ctx, client, s, aggregate)
+		if err != nil {
+			return results, fmt.Errorf("unable to run scenario %s: %w", s.Name, err)
+		}
+		results.Scenarios = append(results.Scenarios, api.ScenarioResults{Name: s.Name, Results: runResults})
+		if s.Config.MaxConcurrentRqsts > concurrency {
+			concurrency = s.Config.MaxConcurrentRqsts
+		}
+	}
+
+	rh := ResponseHandler{start: start, Concurrency: concurrency}
+	results.Aggregate = rh.accumulatedResults(aggregate.Snapshot())
+	return results, nil
+}
+
+// runScenario runs 's' and returns its results. Its responses are also accumulated by
+// 'aggregate'. Scenarios don't support Phases or a ReplayLog.
+func runScenario(ctx context.Context, client http.Client, s Scenario, aggregate *stats.Accumulator) (api.RunResults, error) {
+	config := s.Config
+	if len(config.Phases) > 0 || config.ReplayLog != "" {
+		return api.RunResults{}, fmt.Errorf("a suite's scenarios can't have Phases or a ReplayLog")
+	}
+	config, disabledEndpoints, err := RemoveDisabledEndpoints(config)
+	if err != nil {
+		return api.RunResults{}, err
+	}
+	var dur time.Duration
+	if config.RunDuration != "" {
+		var err error
+		if dur, err = time.ParseDuration(config.RunDuration); err != nil {
+			return api.RunResults{}, fmt.Errorf("invalid RunDuration %s: %w", config.RunDuration, err)
+		}
+	}
+	uniqueInts, err := NewUniqueIntCounters(config.UniqueIntRanges)
+	if err != nil {
+		return api.RunResults{}, err
+	}
+	lockGroups, err := NewLockGroups(config.LockGroups, config.Endpoints)
+	if err != nil {
+		return api.RunResults{}, err
+	}
+	rateLimits, err := NewRateLimits(config.Endpoints)
+	if err != nil {
+		return api.RunResults{}, err
+	}
+	circuitBreakers, err := NewCircuitBreakers(config.Endpoints)
+	if err != nil {
+		return api.RunResults{}, err
+	}
+	budget, err := NewBudgetTracker(config.Budget)
+	if err != nil {
+		return api.RunResults{}, err
+	}
+	responseHook, err := NewResponseHookRunner(config.ResponseHook)
+	if err != nil {
+		return api.RunResults{}, err
+	}
+	crawlers, err := NewCrawlers(config.Endpoints)
+	if err != nil {
+		return api.RunResults{}, err
+	}
+	headerRotators, err := NewHeaderRotators(config.Endpoints, time.Now().UnixNano())
+	if err != nil {
+		return api.RunResults{}, err
+	}
+	clientCaches := NewClientCaches(config.SimulateClientCache)
+	if err := ValidateURLPathTemplates(config.URLPathTemplates); err != nil {
+		return api.RunResults{}, err
+	}
+
+	var cancel context.CancelFunc
+	if dur > 0 {
+		ctx, cancel = context.WithTimeout(ctx, dur)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	rqstC := make(chan Response, config.MaxConcurrentRqsts)
+	rh := &ResponseHandler{
+		OutputType:         JSON,
+		ResponseC:          rqstC,
+		DoneC:              make(chan interface{}),
+		NumRqsts:           config.NumRequests,
+		Concurrency:        config.MaxConcurrentRqsts,
+		OutlierPolicy:      config.OutlierPolicy,
+		ExpectedStatusDist: config.ExpectedStatusDistribution,
+		SizeClasses:        config.BodySizeClasses,
+		CacheHitHeaders:    config.CacheHitHeaders,
+		InstanceHeader:     config.InstanceHeader,
+		SummaryKey:         config.SummaryKey,
+		URLPathTemplates:   config.URLPathTemplates,
+		CircuitBreakers:    circuitBreakers,
+		Budget:             budget,
+		ResponseHook:       responseHook,
+		Crawlers:           crawlers,
+		ClientCaches:       clientCaches,
+		DisabledEndpoints:  disabledEndpoints,
+		// The suite writes its own report
+		Output:    ioutil.Discard,
+		aggregate: aggregate,
+	}
+	rqstr := Requestor{
+		Ctx:                   ctx,
+		ResponseC:             rqstC,
+		Client:                client,
+		Cancel:                cancel,
+		UniqueInts:            uniqueInts,
+		Retry:                 config.Retry,
+		FailOnMalformedURL:    config.FailOnMalformedURL,
+		EarlyFail:             NewEarlyFailTracker(false),
+		LockGroups:            lockGroups,
+		RateLimits:            rateLimits,
+		CircuitBreakers:       circuitBreakers,
+		Budget:                budget,
+		RecordResponseHeaders: config.RecordResponseHeaders,
+		MaxResponseBodyBytes:  config.MaxResponseBodyBytes,
+		TraceIDHeader:         config.TraceIDHeader,
+		RequestIDHeader:       config.RequestIDHeader,
+		HeaderStats:           config.HeaderStats,
+		ResponseHook:          responseHook,
+		Crawlers:              crawlers,
+		HeaderRotators:        headerRotators,
+		ClientCaches:          clientCaches,
+		ConditionalRequests:   config.ConditionalRequests,
+	}
+	scheduler, err := NewScheduler(config.MaxConcurrentRqsts, float64(config.RqstRate), dur, config.NumRequests, config.Endpoints, rqstr)
+	if err != nil {
+		return api.RunResults{}, err
+	}
+	scheduler.ShareBudget(config.Budget)
+
+	go rh.Start()
+	go scheduler.Start()
+	<-rh.DoneC
+
+	if rh.Results == nil {
+		return api.RunResults{}, fmt.Errorf("the scenario's responses couldn't be summarized")
+	}
+	return *rh.Results, nil
+}
+
+// WriteSuiteReport writes 'results' to 'w' as text, if 'outputType' is Text, and
+// otherwise as JSON with its durations in 'unit'
+func WriteSuiteReport(w io.Writer, outputType OutputType, unit DurationUnit, results api.SuiteResults) error {
+	if outputType != Text {
+		b, err := unit.marshalIndent(results, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding the suite's results: %w", err)
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+
+	for _, s := range results.Scenarios {
+		fmt.Fprintf(w, "\nScenario %s:\n", s.Name)
+		printRunSummary(w, s.Results.RunSummary)
+		printRqstLatency(w, s.Results.RunSummary.RqstStats)
+	}
+	fmt.Fprintf(w, "\nSuite (%d scenarios):\n", len(results.Scenarios))
+	printRunSummary(w, results.Aggregate.RunSummary)
+	printRqstLatency(w, results.Aggregate.RunSummary.RqstStats)
+	fmt.Fprintln(w, "")
+	printEndpointDetails(w, results.Aggregate.EndpointDetails)
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestRunSuite verifies each of a suite's scenarios is run and summarized, in order,
+// and that the aggregate summarizes all of their responses
+func TestRunSuite(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer testSrv.Close()
+
+	scenarios := []Scenario{
+		{
+			Name: "users",
+			Config: api.LoadTestConfig{MaxConcurrentRqsts: 2, NumRequests: 10, Endpoints: []api.Endpoint{
+				{URL: testSrv.URL + "/users", Method: http.MethodGet, RqstPercent: 100},
+			}},
+		},
+		{
+			Name: "missing",
+			Config: api.LoadTestConfig{MaxConcurrentRqsts: 4, NumRequests: 20, Endpoints: []api.Endpoint{
+				{URL: testSrv.URL + "/missing", Method: http.MethodGet, RqstPercent: 100},
+			}},
+		},
+	}
+	results, err := RunSuite(context.Background(), http.Client{}, scenarios)
+	if err != nil {
+		t.Fatalf("unexpected error running the suite: %s", err)
+	}
+
+	if len(results.Scenarios) != 2 {
+		t.Fatalf("expected 2 scenario summaries, got %d", len(results.Scenarios))
+	}
+	for i, expected := range []struct {
+		name       string
+		totalRqsts int64
+	}{{name: "users", totalRqsts: 10}, {name: "missing", totalRqsts: 20}} {
+		s := results.Scenarios[i]
+		if s.Name != expected.name || s.Results.RunSummary.RqstStats.TotalRqsts != expected.totalRqsts {
+			t.Errorf("expected scenario %d to be %s with %d requests, got %s with %d", i+1, expected.name,
+				expected.totalRqsts, s.Name, s.Results.RunSummary.RqstStats.TotalRqsts)
+		}
+	}
+	if _, ok := results.Scenarios[0].Results.EndpointDetails[testSrv.URL+"/missing"]; ok {
+		t.Errorf("expected the users scenario not to include the missing scenario's endpoint")
+	}
+
+	aggregate := results.Aggregate
+	if aggregate.RunSummary.RqstStats.TotalRqsts != 30 {
+		t.Errorf("expected the aggregate to summarize 30 requests, got %d", aggregate.RunSummary.RqstStats.TotalRqsts)
+	}
+	if len(aggregate.EndpointDetails) != 2 {
+		t.Errorf("expected the aggregate to include both scenarios' endpoints, got %d endpoints", len(aggregate.EndpointDetails))
+	}
+
+	var b bytes.Buffer
+	if err = WriteSuiteReport(&b, Text, DurationUnit{}, results); err != nil {
+		t.Fatalf("unexpected error writing the suite's report: %s", err)
+	}
+	report := b.String()
+	for _, expected := range []string{"Scenario users:", "Scenario missing:", "Suite (2 scenarios):"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected the report to contain %q, got %s", expected, report)
+		}
+	}
+	if strings.Index(report, "Scenario users:") > strings.Index(report, "Scenario missing:") {
+		t.Errorf("expected the scenarios to be reported in the order they ran, got %s", report)
+	}
+}
+
+// TestRunSuiteInvalidScenario verifies the suite stops at a scenario that can't be run
+func TestRunSuiteInvalidScenario(t *testing.T) {
+	scenarios := []Scenario{{
+		Name:   "phased",
+		Config: api.LoadTestConfig{Phases: []api.Phase{{Name: "ramp"}}},
+	}}
+	if _, err := RunSuite(context.Background(), http.Client{}, scenarios); err == nil {
+		t.Errorf("expected an error running a scenario with Phases")
+	}
+}
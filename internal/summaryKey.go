@@ -0,0 +1,195 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// summaryKeyMode returns how responses are grouped into endpoints
+func (rh *ResponseHandler) summaryKeyMode() string {
+	if rh.SummaryKey == "" {
+		return api.SummaryKeyFull
+	}
+	return rh.SummaryKey
+}
+
+// summaryKey returns the key 'rawURL' is summarized under according to 'mode', one
+// of the api.SummaryKey... constants. URLs that can't be parsed are their own key.
+func summaryKey(mode string, rawURL string) string {
+	if mode == api.SummaryKeyFull {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+
+	if mode == api.SummaryKeyPath {
+		u.RawQuery = ""
+		return u.String()
+	}
+
+	names := make([]string, 0, len(u.Query()))
+	for name := range u.Query() {
+		names = append(names, url.QueryEscape(name)+"=*")
+	}
+	sort.Strings(names)
+	// RawQuery is used as is, so the '*'s aren't escaped
+	u.RawQuery = strings.Join(names, "&")
+	return u.String()
+}
+
+// maxTemplatedURLSamples is the most distinct URLs reported for an endpoint grouped
+// under a URLPathTemplate
+const maxTemplatedURLSamples = 5
+
+// ValidateURLPathTemplates returns an error if any of 'templates' isn't a path, e.g.,
+// '/users/{id}/orders', whose '{name}' segments are entire segments
+func ValidateURLPathTemplates(templates []string) error {
+	for _, tmpl := range templates {
+		if !strings.HasPrefix(tmpl, "/") {
+			return fmt.Errorf("URLPathTemplate %q must start with '/'", tmpl)
+		}
+		for _, seg := range strings.Split(tmpl, "/") {
+			if isTemplateSegment(seg) {
+				continue
+			}
+			if strings.ContainsAny(seg, "{}") {
+				return fmt.Errorf("URLPathTemplate %q has the segment %q, a '{name}' must be an entire segment", tmpl, seg)
+			}
+		}
+	}
+	return nil
+}
+
+// isTemplateSegment returns true if 'seg' is a URLPathTemplate's '{name}' segment
+func isTemplateSegment(seg string) bool {
+	return len(seg) > 2 && strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") &&
+		!strings.ContainsAny(seg[1:len(seg)-1], "{}")
+}
+
+// summaryKeyer groups response URLs into endpoints by their URLPathTemplate, if any,
+// and then their SummaryKey
+type summaryKeyer struct {
+	mode string
+	// templates are the segments of each URLPathTemplate
+	templates [][]string
+}
+
+// summaryKeyer returns the summaryKeyer of 'rh's SummaryKey and URLPathTemplates
+func (rh *ResponseHandler) summaryKeyer() summaryKeyer {
+	k := summaryKeyer{mode: rh.summaryKeyMode()}
+	for _, tmpl := range rh.URLPathTemplates {
+		k.templates = append(k.templates, strings.Split(tmpl, "/"))
+	}
+	return k
+}
+
+// key returns the key 'rawURL' is summarized under
+func (k summaryKeyer) key(rawURL string) string {
+	key := summaryKey(k.mode, rawURL)
+	tmpl, ok := k.template(rawURL)
+	if !ok {
+		return key
+	}
+	// The query and fragment are kept as is, and the template is appended as is so
+	// its '{name}'s aren't escaped
+	var query, fragment string
+	if i := strings.IndexByte(key, '#'); i >= 0 {
+		key, fragment = key[:i], key[i:]
+	}
+	if i := strings.IndexByte(key, '?'); i >= 0 {
+		key, query = key[:i], key[i:]
+	}
+	u, err := url.Parse(key)
+	if err != nil {
+		return key + query + fragment
+	}
+	base := url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host}
+	return base.String() + tmpl + query + fragment
+}
+
+// templated returns true if 'rawURL' is grouped under a URLPathTemplate
+func (k summaryKeyer) templated(rawURL string) bool {
+	_, ok := k.template(rawURL)
+	return ok
+}
+
+// template returns the first URLPathTemplate 'rawURL's path matches, and true, or
+// false if it doesn't match any of them
+func (k summaryKeyer) template(rawURL string) (string, bool) {
+	if len(k.templates) == 0 {
+		return "", false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	segs := strings.Split(u.EscapedPath(), "/")
+	for _, tmpl := range k.templates {
+		if matchTemplate(tmpl, segs) {
+			return strings.Join(tmpl, "/"), true
+		}
+	}
+	return "", false
+}
+
+// matchTemplate returns true if the path segments 'segs' match those of a
+// URLPathTemplate, 'tmpl'. A '{name}' segment matches any non-empty segment.
+func matchTemplate(tmpl, segs []string) bool {
+	if len(tmpl) != len(segs) {
+		return false
+	}
+	for i, seg := range tmpl {
+		if isTemplateSegment(seg) {
+			if segs[i] == "" {
+				return false
+			}
+			continue
+		}
+		if seg != segs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// templatedURLs are the distinct URLs grouped under each endpoint's URLPathTemplate,
+// keyed by the endpoint's summary key
+type templatedURLs map[string]map[string]bool
+
+// add records that 'rawURL' was grouped under the endpoint 'key'
+func (t templatedURLs) add(key, rawURL string) {
+	urls, ok := t[key]
+	if !ok {
+		urls = make(map[string]bool)
+		t[key] = urls
+	}
+	urls[rawURL] = true
+}
+
+// finish reports the distinct URLs grouped under each templated endpoint in its
+// EndpointDetail
+func (t templatedURLs) finish(epDetails map[string]*api.EndpointDetail) {
+	for key, urls := range t {
+		samples := make([]string, 0, len(urls))
+		for u := range urls {
+			samples = append(samples, u)
+		}
+		sort.Strings(samples)
+		if len(samples) > maxTemplatedURLSamples {
+			samples = samples[:maxTemplatedURLSamples]
+		}
+		detail := getEPDetail(key, epDetails)
+		detail.TemplatedURLs = int64(len(urls))
+		detail.TemplatedURLSamples = samples
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestSummaryKey verifies responses are grouped into endpoints according to the
+// SummaryKey mode
+func TestSummaryKey(t *testing.T) {
+	urls := []string{
+		"http://someservice.test/search?q=a",
+		"http://someservice.test/search?q=b",
+		"http://someservice.test/search?page=2&q=a",
+		"http://someservice.test/search?q=a#tls",
+		"http://someservice.test/users",
+	}
+	var responses []Response
+	for _, url := range urls {
+		responses = append(responses, Response{HTTPStatus: 200, RequestDuration: time.Millisecond,
+			Endpoint: api.Endpoint{URL: url, Method: "GET"}})
+	}
+
+	tests := []struct {
+		mode         string
+		expectedMode string
+		expected     map[string]int64
+	}{
+		{
+			mode:         "",
+			expectedMode: api.SummaryKeyFull,
+			expected: map[string]int64{
+				"http://someservice.test/search?q=a":        1,
+				"http://someservice.test/search?q=b":        1,
+				"http://someservice.test/search?page=2&q=a": 1,
+				"http://someservice.test/search?q=a#tls":    1,
+				"http://someservice.test/users":             1,
+			},
+		},
+		{
+			mode:         api.SummaryKeyPath,
+			expectedMode: api.SummaryKeyPath,
+			expected: map[string]int64{
+				"http://someservice.test/search":     3,
+				"http://someservice.test/search#tls": 1,
+				"http://someservice.test/users":      1,
+			},
+		},
+		{
+			mode:         api.SummaryKeyPathAndQueryKeys,
+			expectedMode: api.SummaryKeyPathAndQueryKeys,
+			expected: map[string]int64{
+				"http://someservice.test/search?q=*":        2,
+				"http://someservice.test/search?page=*&q=*": 1,
+				"http://someservice.test/search?q=*#tls":    1,
+				"http://someservice.test/users":             1,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.expectedMode, func(t *testing.T) {
+			rh := ResponseHandler{SummaryKey: tc.mode, start: time.Now()}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+
+			if runResults.Meta.SummaryKey != tc.expectedMode {
+				t.Errorf("expected Meta.SummaryKey %q, got %q", tc.expectedMode, runResults.Meta.SummaryKey)
+			}
+			actual := make(map[string]int64)
+			for key, epDetail := range runResults.EndpointDetails {
+				actual[key] = epDetail.HTTPMethodRqstStats["GET"].TotalRqsts
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected endpoint details %v, got %v", tc.expected, actual)
+			}
+			if len(runResults.EndpointSummary) != len(tc.expected) {
+				t.Errorf("expected %d endpoints in the endpoint summary, got %v", len(tc.expected), runResults.EndpointSummary)
+			}
+		})
+	}
+}
+
+func TestValidateURLPathTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates []string
+		expectErr bool
+	}{
+		{name: "none"},
+		{name: "templates", templates: []string{"/users/{id}", "/users/{id}/orders/{orderID}", "/health"}},
+		{name: "relative", templates: []string{"users/{id}"}, expectErr: true},
+		{name: "partial segment", templates: []string{"/users/id-{id}"}, expectErr: true},
+		{name: "unclosed", templates: []string{"/users/{id"}, expectErr: true},
+		{name: "empty name", templates: []string{"/users/{}"}, expectErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ValidateURLPathTemplates(tc.templates); (err != nil) != tc.expectErr {
+				t.Errorf("expected an error to be %t, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+// TestURLPathTemplates verifies URLs with distinct IDs are grouped under the path
+// template they match, and that the distinct URLs are reported
+func TestURLPathTemplates(t *testing.T) {
+	var responses []Response
+	for i := 0; i < 50; i++ {
+		for _, path := range []string{"/users/%d", "/users/%d/orders", "/orders/%d?verbose=true"} {
+			url := "http://someservice.test" + fmt.Sprintf(path, i)
+			responses = append(responses, Response{HTTPStatus: 200, RequestDuration: time.Millisecond,
+				Endpoint: api.Endpoint{URL: url, Method: "GET"}})
+		}
+	}
+	responses = append(responses, Response{HTTPStatus: 200, RequestDuration: time.Millisecond,
+		Endpoint: api.Endpoint{URL: "http://someservice.test/users/", Method: "GET"}})
+
+	rh := ResponseHandler{
+		SummaryKey:       api.SummaryKeyPath,
+		URLPathTemplates: []string{"/users/{id}", "/orders/{id}"},
+		start:            time.Now(),
+	}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+
+	// '/users/{id}/orders' isn't a template, and an empty segment doesn't match '{id}'
+	if len(runResults.EndpointDetails) != 53 {
+		t.Errorf("expected 53 endpoints, got %d", len(runResults.EndpointDetails))
+	}
+	for _, key := range []string{"http://someservice.test/users/{id}", "http://someservice.test/orders/{id}"} {
+		epDetail, ok := runResults.EndpointDetails[key]
+		if !ok {
+			t.Fatalf("expected the endpoint %s, got %v", key, runResults.EndpointSummary)
+		}
+		if n := epDetail.HTTPMethodRqstStats["GET"].TotalRqsts; n != 50 {
+			t.Errorf("expected 50 requests grouped under %s, got %d", key, n)
+		}
+		if n := runResults.EndpointSummary[key]["GET"]; n != 50 {
+			t.Errorf("expected 50 requests to %s in the endpoint summary, got %d", key, n)
+		}
+		if epDetail.TemplatedURLs != 50 || len(epDetail.TemplatedURLSamples) != maxTemplatedURLSamples {
+			t.Errorf("expected 50 distinct URLs and %d samples for %s, got %d and %v", maxTemplatedURLSamples, key,
+				epDetail.TemplatedURLs, epDetail.TemplatedURLSamples)
+		}
+	}
+	expected := "http://someservice.test/orders/0?verbose=true"
+	if sample := runResults.EndpointDetails["http://someservice.test/orders/{id}"].TemplatedURLSamples[0]; sample != expected {
+		t.Errorf("expected the raw URL %s to be reported, got %s", expected, sample)
+	}
+	if epDetail := runResults.EndpointDetails["http://someservice.test/users/7/orders"]; epDetail == nil || epDetail.TemplatedURLs != 0 {
+		t.Errorf("expected a URL not matching a template to be its own endpoint, got %+v", epDetail)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// summaryLineStats are the values reported by the OneLine and KV output types
+type summaryLineStats struct {
+	rqsts    int64
+	duration time.Duration
+	rate     float64
+	avg      time.Duration
+	p95      time.Duration
+	// errors is the number of failed requests, i.e., those counted in
+	// RunSummary.ErrorCategories
+	errors int64
+	// errorRatio is the fraction of all requests, successful and failed, that failed
+	errorRatio float64
+}
+
+// newSummaryLineStats extracts the values reported by the OneLine and KV output
+// types from 'rs'
+func newSummaryLineStats(rs api.RunSummary) summaryLineStats {
+	stats := summaryLineStats{
+		rqsts:    rs.RqstStats.TotalRqsts,
+		duration: rs.RunDurationNanos,
+		rate:     rs.RqstRatePerSec,
+		avg:      rs.RqstStats.AvgRqstDurationNanos,
+		p95:      calcPercentiles(95, rs.RqstStats.TimingResultsNanos),
+	}
+	for _, count := range rs.ErrorCategories {
+		stats.errors += count
+	}
+	if total := stats.rqsts + stats.errors; total > 0 {
+		stats.errorRatio = float64(stats.errors) / float64(total)
+	}
+	return stats
+}
+
+// formatMillis formats 'd' in milliseconds
+func formatMillis(d time.Duration) string {
+	return fmt.Sprintf("%.1f", float64(d)/float64(time.Millisecond))
+}
+
+// formatOneLine formats 'rs' as a single human readable line, e.g.,
+//
+//	heyyall: 12000 rqsts in 30.0s, 400.0 rps, avg 12.3ms, p95 45.6ms, errors 0.20%
+//
+// The order of the fields is fixed so the line can be reliably parsed by scripts.
+func formatOneLine(rs api.RunSummary) string {
+	stats := newSummaryLineStats(rs)
+	return fmt.Sprintf("heyyall: %d rqsts in %.1fs, %.1f rps, avg %sms, p95 %sms, errors %s",
+		stats.rqsts, stats.duration.Seconds(), stats.rate, formatMillis(stats.avg), formatMillis(stats.p95),
+		formatPercent(stats.errorRatio))
+}
+
+// formatKV formats 'rs' as a single line of space separated key=value pairs, e.g.,
+//
+//	rqsts=12000 duration_secs=30.0 rps=400.0 avg_ms=12.3 p95_ms=45.6 errors=24 error_pct=0.20
+//
+// The order of the keys is fixed. New keys are only ever appended.
+func formatKV(rs api.RunSummary) string {
+	stats := newSummaryLineStats(rs)
+	return fmt.Sprintf("rqsts=%d duration_secs=%.1f rps=%.1f avg_ms=%s p95_ms=%s errors=%d error_pct=%.2f",
+		stats.rqsts, stats.duration.Seconds(), stats.rate, formatMillis(stats.avg), formatMillis(stats.p95),
+		stats.errors, stats.errorRatio*100)
+}
+
+// summaryLine returns 'rs' formatted for 'outputType' if it's one of the single line
+// output types, OneLine or KV. Otherwise it returns false.
+func summaryLine(outputType OutputType, rs api.RunSummary) (string, bool) {
+	switch outputType {
+	case OneLine:
+		return formatOneLine(rs), true
+	case KV:
+		return formatKV(rs), true
+	}
+	return "", false
+}
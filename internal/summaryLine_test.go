@@ -0,0 +1,63 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestSummaryLine verifies the single line output types against golden files so that
+// the line formats, which scripts depend on, don't change unintentionally.
+func TestSummaryLine(t *testing.T) {
+	timings := make([]time.Duration, 0, 100)
+	var total time.Duration
+	for i := 1; i <= 100; i++ {
+		d := time.Duration(i) * 500 * time.Microsecond
+		timings = append(timings, d)
+		total += d
+	}
+	rs := api.RunSummary{
+		RqstStats: api.RqstStats{
+			TimingResultsNanos:        timings,
+			TotalRqsts:                int64(len(timings)),
+			TotalRequestDurationNanos: total,
+			AvgRqstDurationNanos:      total / time.Duration(len(timings)),
+		},
+		RqstRatePerSec:   33.3333,
+		RunDurationNanos: 3 * time.Second,
+		ErrorCategories:  map[string]int64{api.ErrCategoryConnection: 3, api.ErrCategoryMalformedURL: 1},
+	}
+
+	tests := []struct {
+		testName   string
+		outputType OutputType
+	}{
+		{testName: "SummaryLineOneLine", outputType: OneLine},
+		{testName: "SummaryLineKV", outputType: KV},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			actual, ok := summaryLine(tc.outputType, rs)
+			if !ok {
+				t.Fatalf("expected %d to be a single line output type", tc.outputType)
+			}
+			if *update {
+				updateGoldenFile(t, tc.testName, actual+"\n")
+			}
+			expected := readGoldenFile(t, tc.testName)
+			if actual+"\n" != string(expected) {
+				t.Errorf("expected %q, got %q", expected, actual+"\n")
+			}
+		})
+	}
+
+	if _, ok := summaryLine(Text, rs); ok {
+		t.Errorf("expected Text to not be a single line output type")
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// uniqueIntExhaustedError is returned by the 'uniqueInt' template function when
+// the named range has no values left and the range isn't configured to wrap.
+type uniqueIntExhaustedError struct {
+	name   string
+	policy string
+}
+
+func (e uniqueIntExhaustedError) Error() string {
+	return fmt.Sprintf("uniqueInt range %q exhausted", e.name)
+}
+
+type uniqueIntCounter struct {
+	rng api.UniqueIntRange
+	// size is the number of values in the range, 0 if it's unbounded
+	size int64
+	// issued is the number of values requested from the counter. It's only
+	// accessed atomically.
+	issued int64
+}
+
+// UniqueIntCounters is the set of named counters backing the 'uniqueInt' template
+// function. A single instance is shared by all requestor goroutines, which guarantees
+// that every value is handed out exactly once during a test run.
+type UniqueIntCounters struct {
+	mux      sync.RWMutex
+	counters map[string]*uniqueIntCounter
+	// failure is the exhaustion of the first range configured to fail the run,
+	// nil if none have. It's protected by mux.
+	failure *uniqueIntExhaustedError
+}
+
+// NewUniqueIntCounters returns a UniqueIntCounters configured with 'ranges'
+func NewUniqueIntCounters(ranges map[string]api.UniqueIntRange) (*UniqueIntCounters, error) {
+	c := UniqueIntCounters{counters: make(map[string]*uniqueIntCounter)}
+	for name, rng := range ranges {
+		if rng.End != nil && *rng.End < rng.Start {
+			return nil, fmt.Errorf("UniqueIntRanges %q: End, %d, must not be less than Start, %d", name, *rng.End, rng.Start)
+		}
+		switch rng.OnExhausted {
+		case "":
+			rng.OnExhausted = api.UniqueIntStop
+		case api.UniqueIntWrap, api.UniqueIntStop, api.UniqueIntFail:
+		default:
+			return nil, fmt.Errorf("UniqueIntRanges %q: OnExhausted must be one of '%s', '%s', or '%s', not '%s'",
+				name, api.UniqueIntWrap, api.UniqueIntStop, api.UniqueIntFail, rng.OnExhausted)
+		}
+		ctr := uniqueIntCounter{rng: rng}
+		if rng.End != nil {
+			ctr.size = *rng.End - rng.Start + 1
+		}
+		c.counters[name] = &ctr
+	}
+	return &c, nil
+}
+
+// Next returns the next unused value from the counter named 'name'. Counters that
+// weren't configured are created on first use, starting at 0 with no upper bound.
+func (c *UniqueIntCounters) Next(name string) (int64, error) {
+	c.mux.RLock()
+	ctr, ok := c.counters[name]
+	c.mux.RUnlock()
+	if !ok {
+		c.mux.Lock()
+		ctr, ok = c.counters[name]
+		if !ok {
+			ctr = &uniqueIntCounter{rng: api.UniqueIntRange{OnExhausted: api.UniqueIntStop}}
+			c.counters[name] = ctr
+		}
+		c.mux.Unlock()
+	}
+
+	n := atomic.AddInt64(&ctr.issued, 1) - 1
+	if ctr.size == 0 || n < ctr.size {
+		return ctr.rng.Start + n, nil
+	}
+	if ctr.rng.OnExhausted == api.UniqueIntWrap {
+		return ctr.rng.Start + n%ctr.size, nil
+	}
+	return 0, uniqueIntExhaustedError{name: name, policy: ctr.rng.OnExhausted}
+}
+
+// Usage reports how much of each counter's range has been consumed
+func (c *UniqueIntCounters) Usage() map[string]api.UniqueIntRangeUsage {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	usage := make(map[string]api.UniqueIntRangeUsage, len(c.counters))
+	for name, ctr := range c.counters {
+		u := api.UniqueIntRangeUsage{
+			Start:    ctr.rng.Start,
+			End:      ctr.rng.End,
+			Consumed: atomic.LoadInt64(&ctr.issued),
+		}
+		if ctr.size > 0 {
+			u.Exhausted = u.Consumed >= ctr.size
+			if u.Consumed > ctr.size && ctr.rng.OnExhausted != api.UniqueIntWrap {
+				u.Consumed = ctr.size
+			}
+		}
+		usage[name] = u
+	}
+	return usage
+}
+
+// fail records that exhausting a range, described by 'exhausted', ended the run. Only
+// the first is recorded.
+func (c *UniqueIntCounters) fail(exhausted uniqueIntExhaustedError) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.failure == nil {
+		c.failure = &exhausted
+	}
+}
+
+// Failure returns why exhausting a range ended the run, or "" if it wasn't ended
+func (c *UniqueIntCounters) Failure() string {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	if c.failure == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s, its OnExhausted is '%s'", c.failure, api.UniqueIntFail)
+}
+
+// clockOffset returns a random offset, from -'skew' to 'skew', modeling the skew of a
+// single client's clock
+func clockOffset(skew time.Duration) time.Duration {
+	if skew <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(2*skew)+1)) - skew
+}
+
+// rqstTmpltFuncs returns the functions available to endpoint URL, body, and header
+// templates. The time functions, 'now', 'unixTime', and 'httpDate', report the
+// current time offset by 'offset'.
+func rqstTmpltFuncs(uniqueInts *UniqueIntCounters, offset time.Duration) template.FuncMap {
+	now := func() time.Time { return time.Now().Add(offset) }
+	return template.FuncMap{
+		"uniqueInt": func(name string) (int64, error) {
+			if uniqueInts == nil {
+				return 0, fmt.Errorf("uniqueInt %q used but no UniqueIntCounters were provided", name)
+			}
+			return uniqueInts.Next(name)
+		},
+		"now":      now,
+		"unixTime": func() int64 { return now().Unix() },
+		"httpDate": func() string { return now().UTC().Format(http.TimeFormat) },
+	}
+}
+
+// rqstTemplate renders the templated parts of an endpoint, its URL, request body,
+// and header values, for each request.
+type rqstTemplate struct {
+	url     *template.Template
+	body    *template.Template
+	headers map[string]*template.Template
+}
+
+// newRqstTemplate parses the templated parts of 'ep'. It returns nil if 'ep' doesn't
+// contain any templates so that endpoints that don't use them pay no rendering cost.
+func newRqstTemplate(ep api.Endpoint, funcs template.FuncMap) (*rqstTemplate, error) {
+	parse := func(name, text string) (*template.Template, error) {
+		if !strings.Contains(text, "{{") {
+			return nil, nil
+		}
+		return template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	}
+
+	var (
+		t   rqstTemplate
+		err error
+	)
+	templated := false
+	if t.url, err = parse("URL", ep.URL); err != nil {
+		return nil, err
+	}
+	templated = templated || t.url != nil
+	if t.body, err = parse("RqstBody", ep.RqstBody); err != nil {
+		return nil, err
+	}
+	templated = templated || t.body != nil
+	for name, value := range ep.Headers {
+		ht, err := parse(name, value)
+		if err != nil {
+			return nil, err
+		}
+		if ht == nil {
+			continue
+		}
+		if t.headers == nil {
+			t.headers = make(map[string]*template.Template)
+		}
+		t.headers[name] = ht
+		templated = true
+	}
+
+	if !templated {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+// render returns a copy of 'ep' with its templated parts rendered using 'data'
+func (t *rqstTemplate) render(ep api.Endpoint, data interface{}) (api.Endpoint, error) {
+	var sb strings.Builder
+	exec := func(tmplt *template.Template, dflt string) (string, error) {
+		if tmplt == nil {
+			return dflt, nil
+		}
+		sb.Reset()
+		if err := tmplt.Execute(&sb, data); err != nil {
+			return "", err
+		}
+		return sb.String(), nil
+	}
+
+	var err error
+	if ep.URL, err = exec(t.url, ep.URL); err != nil {
+		return ep, err
+	}
+	if ep.RqstBody, err = exec(t.body, ep.RqstBody); err != nil {
+		return ep, err
+	}
+	if len(t.headers) > 0 {
+		headers := make(map[string]string, len(ep.Headers))
+		for name, value := range ep.Headers {
+			if headers[name], err = exec(t.headers[name], value); err != nil {
+				return ep, err
+			}
+		}
+		ep.Headers = headers
+	}
+	return ep, nil
+}
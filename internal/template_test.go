@@ -0,0 +1,320 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// idRecorder is a test HTTP handler that records the 'id' query parameter of every
+// request it receives.
+type idRecorder struct {
+	mux sync.Mutex
+	ids map[int64]int
+}
+
+func (s *idRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mux.Lock()
+	s.ids[id]++
+	s.mux.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// runUniqueIntRqstrs runs 'numRqstrs' concurrent requestors against 'ep', each making
+// 'numRqsts' requests, and returns the number of responses received.
+func runUniqueIntRqstrs(rqstr Requestor, ep api.Endpoint, numRqstrs, numRqsts int) int {
+	wg := sync.WaitGroup{}
+	for i := 0; i < numRqstrs; i++ {
+		wg.Add(1)
+		go func() {
+			rqstr.ProcessRqst(ep, numRqsts, 0)
+			wg.Done()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(rqstr.ResponseC)
+	}()
+
+	numResps := 0
+	for range rqstr.ResponseC {
+		numResps++
+	}
+	return numResps
+}
+
+// TestUniqueIntConcurrency verifies that every 'uniqueInt' value is used exactly once
+// when many requestors share the same counter.
+func TestUniqueIntConcurrency(t *testing.T) {
+	recorder := &idRecorder{ids: make(map[int64]int)}
+	testSrv := httptest.NewServer(recorder)
+	defer testSrv.Close()
+
+	uniqueInts, err := NewUniqueIntCounters(map[string]api.UniqueIntRange{"orders": {Start: 1000}})
+	if err != nil {
+		t.Fatalf("unexpected error creating UniqueIntCounters: %s", err)
+	}
+
+	ep := api.Endpoint{
+		URL:         testSrv.URL + `/orders?id={{ uniqueInt "orders" }}`,
+		Method:      http.MethodPost,
+		RqstBody:    `{"order": {{ uniqueInt "unconfigured" }}}`,
+		RqstPercent: 100,
+	}
+	rqstr := Requestor{
+		Ctx:        context.Background(),
+		ResponseC:  make(chan Response, 10),
+		Client:     http.Client{},
+		UniqueInts: uniqueInts,
+	}
+
+	numRqstrs, numRqsts := 8, 50
+	numResps := runUniqueIntRqstrs(rqstr, ep, numRqstrs, numRqsts)
+	if numResps != numRqstrs*numRqsts {
+		t.Errorf("expected %d responses, got %d", numRqstrs*numRqsts, numResps)
+	}
+
+	if len(recorder.ids) != numRqstrs*numRqsts {
+		t.Errorf("expected %d unique IDs, got %d", numRqstrs*numRqsts, len(recorder.ids))
+	}
+	for id, cnt := range recorder.ids {
+		if cnt != 1 {
+			t.Errorf("ID %d was used %d times", id, cnt)
+		}
+		if id < 1000 || id >= int64(1000+numRqstrs*numRqsts) {
+			t.Errorf("ID %d is outside of the expected range", id)
+		}
+	}
+
+	usage := uniqueInts.Usage()
+	if usage["orders"].Consumed != int64(numRqstrs*numRqsts) {
+		t.Errorf("expected %d 'orders' IDs consumed, got %d", numRqstrs*numRqsts, usage["orders"].Consumed)
+	}
+	if usage["unconfigured"].Consumed != int64(numRqstrs*numRqsts) {
+		t.Errorf("expected %d 'unconfigured' IDs consumed, got %d", numRqstrs*numRqsts, usage["unconfigured"].Consumed)
+	}
+}
+
+// TestUniqueIntExhausted verifies that each OnExhausted policy is applied once a
+// 'uniqueInt' range has been used up.
+func TestUniqueIntExhausted(t *testing.T) {
+	tests := []struct {
+		name              string
+		policy            string
+		expectedResps     int
+		expectedConsumed  int64
+		expectedCancelled bool
+	}{
+		{
+			name:             "Stop",
+			policy:           api.UniqueIntStop,
+			expectedResps:    10,
+			expectedConsumed: 10,
+		},
+		{
+			name:             "DefaultIsStop",
+			policy:           "",
+			expectedResps:    10,
+			expectedConsumed: 10,
+		},
+		{
+			name:             "Wrap",
+			policy:           api.UniqueIntWrap,
+			expectedResps:    30,
+			expectedConsumed: 30,
+		},
+		{
+			name:              "Fail",
+			policy:            api.UniqueIntFail,
+			expectedResps:     10,
+			expectedConsumed:  10,
+			expectedCancelled: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := &idRecorder{ids: make(map[int64]int)}
+			testSrv := httptest.NewServer(recorder)
+			defer testSrv.Close()
+
+			end := int64(10)
+			uniqueInts, err := NewUniqueIntCounters(map[string]api.UniqueIntRange{
+				"orders": {Start: 1, End: &end, OnExhausted: tc.policy},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error creating UniqueIntCounters: %s", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			rqstr := Requestor{
+				Ctx:        ctx,
+				ResponseC:  make(chan Response, 30),
+				Client:     http.Client{},
+				Cancel:     cancel,
+				UniqueInts: uniqueInts,
+			}
+			ep := api.Endpoint{
+				URL:         testSrv.URL + `/orders?id={{ uniqueInt "orders" }}`,
+				Method:      http.MethodGet,
+				RqstPercent: 100,
+			}
+
+			// A single requestor makes the requests so the number of responses received
+			// before the run is cancelled is deterministic.
+			numResps := runUniqueIntRqstrs(rqstr, ep, 1, 30)
+			if numResps != tc.expectedResps {
+				t.Errorf("expected %d responses, got %d", tc.expectedResps, numResps)
+			}
+			if (ctx.Err() != nil) != tc.expectedCancelled {
+				t.Errorf("expected run cancelled to be %t, got %t", tc.expectedCancelled, ctx.Err() != nil)
+			}
+
+			usage := uniqueInts.Usage()["orders"]
+			if usage.Consumed != tc.expectedConsumed || !usage.Exhausted {
+				t.Errorf("expected %d consumed and exhausted, got %+v", tc.expectedConsumed, usage)
+			}
+
+			// Only a range configured to fail the run fails it
+			rh := ResponseHandler{UniqueInts: uniqueInts, start: time.Now()}
+			runResults, err := rh.summarize(nil, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			rs := runResults.RunSummary
+			rs.RqstStats.TotalRqsts = 10
+			if rs.UniqueIntFailed != tc.expectedCancelled {
+				t.Errorf("expected the run's failure to be %t, got %t", tc.expectedCancelled, rs.UniqueIntFailed)
+			}
+			if tc.expectedCancelled && (rs.UniqueIntFailure != `uniqueInt range "orders" exhausted, its OnExhausted is 'fail'` ||
+				!strings.Contains(runFailure(rs), rs.UniqueIntFailure)) {
+				t.Errorf("expected the run to fail because the orders range was exhausted, got %q", rs.UniqueIntFailure)
+			}
+		})
+	}
+}
+
+func TestUniqueIntRangeValidation(t *testing.T) {
+	nine, ten := int64(9), int64(10)
+	tests := []struct {
+		name       string
+		rng        api.UniqueIntRange
+		shouldFail bool
+	}{
+		{name: "Unbounded", rng: api.UniqueIntRange{Start: 10}},
+		{name: "Bounded", rng: api.UniqueIntRange{Start: 10, End: &ten, OnExhausted: api.UniqueIntWrap}},
+		{name: "EndBeforeStart", rng: api.UniqueIntRange{Start: 10, End: &nine}, shouldFail: true},
+		{name: "UnknownPolicy", rng: api.UniqueIntRange{End: &nine, OnExhausted: "retry"}, shouldFail: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewUniqueIntCounters(map[string]api.UniqueIntRange{"ids": tc.rng})
+			if (err != nil) != tc.shouldFail {
+				t.Errorf("expected failure to be %t, got error %v", tc.shouldFail, err)
+			}
+		})
+	}
+}
+
+// TestUniqueIntZeroRange verifies a range ending at 0 is bounded rather than unbounded
+func TestUniqueIntZeroRange(t *testing.T) {
+	var zero int64
+	uniqueInts, err := NewUniqueIntCounters(map[string]api.UniqueIntRange{"ids": {End: &zero}})
+	if err != nil {
+		t.Fatalf("unexpected error creating UniqueIntCounters: %s", err)
+	}
+	if n, err := uniqueInts.Next("ids"); n != 0 || err != nil {
+		t.Errorf("expected the only value of the range to be 0, got %d, %v", n, err)
+	}
+	if _, err := uniqueInts.Next("ids"); err == nil {
+		t.Errorf("expected the range 0..0 to be exhausted after its only value")
+	}
+	if usage := uniqueInts.Usage()["ids"]; usage.End == nil || *usage.End != 0 || usage.Consumed != 1 || !usage.Exhausted {
+		t.Errorf("expected the range 0..0 to be reported as exhausted, got %+v", usage)
+	}
+}
+
+// TestClockSkew verifies the timestamps rendered by each requestor goroutine are
+// offset by their own clock skew, within the configured bound
+func TestClockSkew(t *testing.T) {
+	var mux sync.Mutex
+	// timestamps are the rendered timestamps keyed by worker
+	timestamps := make(map[string][]int64)
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts, err := strconv.ParseInt(r.Header.Get("X-Timestamp"), 10, 64)
+		if err != nil {
+			t.Errorf("expected a unix timestamp, got %q", r.Header.Get("X-Timestamp"))
+		}
+		if _, err = http.ParseTime(r.Header.Get("X-Date")); err != nil {
+			t.Errorf("expected an HTTP date, got %q", r.Header.Get("X-Date"))
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		worker := r.URL.Query().Get("worker")
+		timestamps[worker] = append(timestamps[worker], ts)
+	}))
+	defer testSrv.Close()
+
+	skew := time.Hour
+	numWorkers, numRqsts := 8, 3
+	before := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}, ClockSkew: skew}
+			ep := api.Endpoint{
+				URL:    testSrv.URL + "/?worker=" + strconv.Itoa(worker),
+				Method: http.MethodGet,
+				Headers: map[string]string{
+					"X-Timestamp": "{{ unixTime }}",
+					"X-Date":      "{{ httpDate }}",
+				},
+			}
+			rqstr.ProcessRqst(ep, numRqsts, 0)
+		}(i)
+	}
+	wg.Wait()
+	after := time.Now()
+
+	if len(timestamps) != numWorkers {
+		t.Fatalf("expected requests from %d workers, got %d", numWorkers, len(timestamps))
+	}
+	offsets := make(map[int64]bool)
+	for worker, tss := range timestamps {
+		for _, ts := range tss {
+			if ts < before.Add(-skew).Unix() || ts > after.Add(skew).Unix() {
+				t.Errorf("worker %s: expected a timestamp within %s of the actual time, got %s", worker, skew,
+					time.Unix(ts, 0))
+			}
+		}
+		// Each worker's clock has a single offset
+		if tss[len(tss)-1]-tss[0] > int64(after.Sub(before)/time.Second)+1 {
+			t.Errorf("worker %s: expected its timestamps to share an offset, got %v", worker, tss)
+		}
+		offsets[tss[0]-before.Unix()] = true
+	}
+	if len(offsets) < 2 {
+		t.Errorf("expected the workers' clocks to be skewed differently, got offsets %v", offsets)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// DialFunc is the signature of http.Transport.DialContext
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// readIdleTimeoutError is returned by a read from a readIdleTimeoutConn that didn't
+// receive any data within the read idle timeout
+type readIdleTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *readIdleTimeoutError) Error() string {
+	return fmt.Sprintf("no data received from the server for %s", e.timeout)
+}
+
+// Timeout implements net.Error
+func (e *readIdleTimeoutError) Timeout() bool { return true }
+
+// Temporary implements net.Error
+func (e *readIdleTimeoutError) Temporary() bool { return true }
+
+// readIdleTimeoutConn is a net.Conn whose reads fail if no data is received within
+// 'timeout'. Unlike a request timeout this detects a server that stalls part way
+// through sending a response, e.g., a slowloris-style server.
+type readIdleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *readIdleTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(b)
+	var netErr net.Error
+	if err != nil && errors.As(err, &netErr) && netErr.Timeout() {
+		return n, &readIdleTimeoutError{timeout: c.timeout}
+	}
+	return n, err
+}
+
+// WithReadIdleTimeout returns a DialFunc that dials using 'dial' and fails reads from
+// the connection that don't receive any data within 'timeout'. Idle connections are
+// also closed once they've been idle for 'timeout'. If 'dial' is nil a net.Dialer is
+// used. If 'timeout' isn't greater than 0 'dial' is returned unchanged.
+func WithReadIdleTimeout(dial DialFunc, timeout time.Duration) DialFunc {
+	if dial == nil {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+	if timeout <= 0 {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &readIdleTimeoutConn{Conn: conn, timeout: timeout}, nil
+	}
+}
+
+// timeoutCategory returns the error category of 'attempt' if it failed due to one of
+// the connection level timeouts, i.e., the http.Transport's ResponseHeaderTimeout or
+// the read idle timeout. It returns "" otherwise.
+func timeoutCategory(attempt rqstAttempt) string {
+	var idleErr *readIdleTimeoutError
+	switch {
+	case errors.As(attempt.err, &idleErr) || errors.As(attempt.bodyErr, &idleErr):
+		return api.ErrCategoryReadIdleTimeout
+	// The http.Transport doesn't export the error it returns
+	case attempt.err != nil && strings.Contains(attempt.err.Error(), "timeout awaiting response headers"):
+		return api.ErrCategoryResponseHeaderTimeout
+	}
+	return ""
+}
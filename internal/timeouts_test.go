@@ -0,0 +1,88 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestConnTimeouts verifies that servers that are slow to send the response headers,
+// or that stall part way through the response body, are detected and categorized
+// distinctly, and that the requestor continues making requests afterwards
+func TestConnTimeouts(t *testing.T) {
+	stopC := make(chan struct{})
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slowheaders":
+			select {
+			case <-time.After(500 * time.Millisecond):
+			case <-stopC:
+			}
+			w.WriteHeader(http.StatusOK)
+		case "/stalledbody":
+			w.Header().Set("Content-Length", "1000")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("partial body"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(500 * time.Millisecond):
+			case <-stopC:
+			}
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer testSrv.Close()
+	defer close(stopC)
+
+	tests := []struct {
+		name             string
+		path             string
+		expectedCategory string
+	}{
+		{name: "ResponseHeaderTimeout", path: "/slowheaders", expectedCategory: api.ErrCategoryResponseHeaderTimeout},
+		{name: "ReadIdleTimeout", path: "/stalledbody", expectedCategory: api.ErrCategoryReadIdleTimeout},
+		{name: "NoTimeout", path: "/ok"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			numRqsts := 2
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client: http.Client{
+					Transport: &http.Transport{
+						ResponseHeaderTimeout: 100 * time.Millisecond,
+						DialContext:           WithReadIdleTimeout(nil, 100*time.Millisecond),
+					},
+				},
+			}
+			rqstr.ProcessRqst(api.Endpoint{URL: testSrv.URL + tc.path, Method: http.MethodGet, RqstPercent: 100}, numRqsts, 0)
+			close(respC)
+
+			numResps := 0
+			for resp := range respC {
+				numResps++
+				if resp.ErrCategory != tc.expectedCategory {
+					t.Errorf("expected error category %q, got %q (%v)", tc.expectedCategory, resp.ErrCategory, resp.Err)
+				}
+				if tc.expectedCategory != "" && resp.Err == nil {
+					t.Error("expected the timeout error to be reported")
+				}
+			}
+			if numResps != numRqsts {
+				t.Errorf("expected %d responses, got %d", numRqsts, numResps)
+			}
+		})
+	}
+}
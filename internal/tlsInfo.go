@@ -0,0 +1,51 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// tlsVersionNames are the names of the TLS versions reported in RunSummary.TLSVersionDist
+var tlsVersionNames = map[uint16]string{
+	tls.VersionSSL30: "SSL 3.0",
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// tlsInfo returns the names of the TLS version and cipher suite negotiated for the
+// connection described by 'state', or empty strings if the connection didn't use TLS
+func tlsInfo(state *tls.ConnectionState) (version, cipherSuite string) {
+	if state == nil {
+		return "", ""
+	}
+	version, ok := tlsVersionNames[state.Version]
+	if !ok {
+		version = fmt.Sprintf("0x%04X", state.Version)
+	}
+	return version, tls.CipherSuiteName(state.CipherSuite)
+}
+
+// accumulateTLSInfo tallies the TLS version and cipher suite negotiated for 'resp', if
+// it used TLS, in 'rs'
+func accumulateTLSInfo(resp Response, rs *api.RunSummary) {
+	if resp.TLSVersion != "" {
+		if rs.TLSVersionDist == nil {
+			rs.TLSVersionDist = make(map[string]int64)
+		}
+		rs.TLSVersionDist[resp.TLSVersion]++
+	}
+	if resp.CipherSuite != "" {
+		if rs.CipherSuiteDist == nil {
+			rs.CipherSuiteDist = make(map[string]int64)
+		}
+		rs.CipherSuiteDist[resp.CipherSuite]++
+	}
+}
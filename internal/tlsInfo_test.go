@@ -0,0 +1,87 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestTLSInfo verifies the TLS version and cipher suite negotiated with a TLS server
+// are tallied in the run summary
+func TestTLSInfo(t *testing.T) {
+	tests := []struct {
+		name                string
+		maxVersion          uint16
+		cipherSuites        []uint16
+		expectedVersion     string
+		expectedCipherSuite string
+	}{
+		{name: "TLS 1.2", maxVersion: tls.VersionTLS12, cipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			expectedVersion: "TLS 1.2", expectedCipherSuite: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		{name: "TLS 1.3", maxVersion: tls.VersionTLS13, expectedVersion: "TLS 1.3"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			testSrv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("OK"))
+			}))
+			testSrv.TLS = &tls.Config{MaxVersion: tc.maxVersion, CipherSuites: tc.cipherSuites}
+			testSrv.StartTLS()
+			defer testSrv.Close()
+
+			numRqsts := 3
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}},
+			}
+			rqstr.ProcessRqst(api.Endpoint{URL: testSrv.URL, Method: http.MethodGet}, numRqsts, 0)
+			close(respC)
+
+			var responses []Response
+			for resp := range respC {
+				responses = append(responses, resp)
+			}
+			rh := ResponseHandler{start: time.Now()}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			rs := runResults.RunSummary
+			if rs.TLSVersionDist[tc.expectedVersion] != int64(numRqsts) || len(rs.TLSVersionDist) != 1 {
+				t.Errorf("expected %d requests using %s, got %v", numRqsts, tc.expectedVersion, rs.TLSVersionDist)
+			}
+			var suites int64
+			for suite, count := range rs.CipherSuiteDist {
+				suites += count
+				if tc.expectedCipherSuite != "" && suite != tc.expectedCipherSuite {
+					t.Errorf("expected cipher suite %s, got %s", tc.expectedCipherSuite, suite)
+				}
+			}
+			if suites != int64(numRqsts) {
+				t.Errorf("expected the cipher suites of %d requests, got %v", numRqsts, rs.CipherSuiteDist)
+			}
+		})
+	}
+}
+
+// TestTLSInfoWithoutTLS verifies nothing is reported for connections that didn't use TLS
+func TestTLSInfoWithoutTLS(t *testing.T) {
+	if version, suite := tlsInfo(nil); version != "" || suite != "" {
+		t.Errorf("expected no TLS version or cipher suite, got %q and %q", version, suite)
+	}
+	if version, _ := tlsInfo(&tls.ConnectionState{Version: tls.VersionTLS10}); version != "TLS 1.0" {
+		t.Errorf("expected TLS 1.0, got %s", version)
+	}
+}
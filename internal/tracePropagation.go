@@ -0,0 +1,96 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// traceparentHeader is the W3C Trace Context header identifying a request's trace
+// and its parent span
+const traceparentHeader = "traceparent"
+
+// Tracer starts a client span for each request sent so the requests can be correlated
+// with the backend's distributed traces. A Tracer backed by a tracing library, e.g.,
+// OpenTelemetry, can be used to also record the client spans.
+type Tracer interface {
+	// StartSpan starts a client span for 'req'. It returns the span's W3C traceparent
+	// header value, which is injected into 'req', and a function that ends the span
+	// with the outcome of the request. 'resp' is nil if the request failed with 'err'.
+	// An empty traceparent means the request isn't traced.
+	StartSpan(req *http.Request) (traceparent string, end func(resp *http.Response, err error))
+}
+
+// noopTracer is a Tracer that doesn't trace any requests
+type noopTracer struct{}
+
+// StartSpan implements Tracer
+func (noopTracer) StartSpan(_ *http.Request) (string, func(*http.Response, error)) {
+	return "", func(*http.Response, error) {}
+}
+
+// W3CTracer is a Tracer that starts a new, sampled, trace for each request. The spans
+// are only propagated, they aren't recorded.
+type W3CTracer struct{}
+
+// StartSpan implements Tracer
+func (W3CTracer) StartSpan(_ *http.Request) (string, func(*http.Response, error)) {
+	return newTraceparent(), func(*http.Response, error) {}
+}
+
+// newTraceparent returns a traceparent header value with a random trace ID and
+// parent span ID, and with the sampled flag set
+func newTraceparent() string {
+	// A trace ID or span ID of all zeros is invalid. The chance of generating one is
+	// negligible, but if it happens a new ID is generated.
+	ids := make([]byte, 24)
+	for {
+		if _, err := rand.Read(ids); err != nil {
+			return ""
+		}
+		if !allZero(ids[:16]) && !allZero(ids[16:]) {
+			break
+		}
+	}
+	return "00-" + hex.EncodeToString(ids[:16]) + "-" + hex.EncodeToString(ids[16:]) + "-01"
+}
+
+// allZero reports whether all of 'b' is zero
+func allZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// traceparentTraceID returns the trace ID from the traceparent header value
+// 'traceparent', or an empty string if it's malformed
+func traceparentTraceID(traceparent string) string {
+	fields := strings.Split(traceparent, "-")
+	if len(fields) < 4 || len(fields[1]) != 32 {
+		return ""
+	}
+	return fields[1]
+}
+
+// startSpan starts a span for 'req' using the Requestor's Tracer, injecting its
+// traceparent header unless 'req' already has one. It returns the injected
+// traceparent header value and the function ending the span.
+func (r Requestor) startSpan(req *http.Request) (string, func(*http.Response, error)) {
+	tracer := r.Tracer
+	if tracer == nil || req.Header.Get(traceparentHeader) != "" {
+		tracer = noopTracer{}
+	}
+	traceparent, end := tracer.StartSpan(req)
+	if traceparent != "" {
+		req.Header.Set(traceparentHeader, traceparent)
+	}
+	return traceparent, end
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// countingTracer is a Tracer, using W3CTracer, that counts the spans it starts and ends
+type countingTracer struct {
+	mux            sync.Mutex
+	started, ended int
+}
+
+func (t *countingTracer) StartSpan(req *http.Request) (string, func(*http.Response, error)) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.started++
+	traceparent, _ := W3CTracer{}.StartSpan(req)
+	return traceparent, func(*http.Response, error) {
+		t.mux.Lock()
+		defer t.mux.Unlock()
+		t.ended++
+	}
+}
+
+// TestTracePropagation verifies a well-formed traceparent header is injected into
+// each request when a Tracer is set, and that none is injected by default
+func TestTracePropagation(t *testing.T) {
+	wellFormed := regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-01$`)
+	numRqsts := 5
+
+	tests := []struct {
+		name string
+		// tracer is nil for the default, no tracing
+		tracer *countingTracer
+		// rqstTraceparent is a traceparent header configured on the endpoint
+		rqstTraceparent string
+	}{
+		{name: "Default"},
+		{name: "Enabled", tracer: &countingTracer{}},
+		{
+			name:            "EndpointHeaderKept",
+			tracer:          &countingTracer{},
+			rqstTraceparent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var mux sync.Mutex
+			var received []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mux.Lock()
+				received = append(received, r.Header.Get(traceparentHeader))
+				mux.Unlock()
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    http.Client{},
+			}
+			if tc.tracer != nil {
+				rqstr.Tracer = tc.tracer
+			}
+			ep := api.Endpoint{URL: srv.URL, Method: http.MethodGet}
+			if tc.rqstTraceparent != "" {
+				ep.Headers = map[string]string{traceparentHeader: tc.rqstTraceparent}
+			}
+			rqstr.ProcessRqst(ep, numRqsts, 0)
+			close(respC)
+
+			if len(received) != numRqsts {
+				t.Fatalf("expected %d requests, got %d", numRqsts, len(received))
+			}
+			traceIDs := make(map[string]bool)
+			for _, traceparent := range received {
+				switch {
+				case tc.rqstTraceparent != "":
+					if traceparent != tc.rqstTraceparent {
+						t.Errorf("expected the endpoint's traceparent %q, got %q", tc.rqstTraceparent, traceparent)
+					}
+				case tc.tracer == nil:
+					if traceparent != "" {
+						t.Errorf("expected no traceparent header, got %q", traceparent)
+					}
+				default:
+					match := wellFormed.FindStringSubmatch(traceparent)
+					if match == nil {
+						t.Errorf("expected a well-formed traceparent header, got %q", traceparent)
+						continue
+					}
+					if match[1] == "00000000000000000000000000000000" || match[2] == "0000000000000000" {
+						t.Errorf("expected non-zero trace and span IDs, got %q", traceparent)
+					}
+					traceIDs[match[1]] = true
+				}
+			}
+			if tc.tracer != nil && tc.rqstTraceparent == "" && len(traceIDs) != numRqsts {
+				t.Errorf("expected a new trace for each of the %d requests, got %d traces", numRqsts, len(traceIDs))
+			}
+
+			for resp := range respC {
+				if tc.tracer != nil && tc.rqstTraceparent == "" && !traceIDs[resp.TraceID] {
+					t.Errorf("expected the response's TraceID to be an injected trace ID, got %q", resp.TraceID)
+				}
+			}
+
+			if tc.tracer != nil {
+				expectedSpans := numRqsts
+				if tc.rqstTraceparent != "" {
+					expectedSpans = 0
+				}
+				if tc.tracer.started != expectedSpans || tc.tracer.ended != expectedSpans {
+					t.Errorf("expected %d spans started and ended, got %d started and %d ended", expectedSpans,
+						tc.tracer.started, tc.tracer.ended)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+var (
+	// h2StreamResetRE extracts the error code from an HTTP/2 stream error, e.g.,
+	// 'stream error: stream ID 3; REFUSED_STREAM'. The http2 error types bundled in
+	// net/http aren't exported so the code is taken from the error message.
+	h2StreamResetRE = regexp.MustCompile(`stream error: stream ID \d+; ([A-Z_0-9]+)`)
+	// h2GoAwayRE extracts the error code from an HTTP/2 GOAWAY error, e.g.,
+	// 'http2: server sent GOAWAY and closed the connection; LastStreamID=1, ErrCode=ENHANCE_YOUR_CALM'
+	h2GoAwayRE = regexp.MustCompile(`GOAWAY.*ErrCode=([A-Z_0-9]+)`)
+)
+
+// protocolErrCategory classifies 'err', an error returned by the http.Client or while
+// reading a response body, by the HTTP protocol failure it represents. It returns an
+// empty string if 'err' isn't a protocol failure. HTTP/2 stream resets and GOAWAYs are
+// categorized by their error code, e.g., 'HTTP2StreamReset:REFUSED_STREAM', since
+// the codes distinguish, e.g., an overloaded server from a server bug.
+func protocolErrCategory(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := err.Error()
+	if m := h2StreamResetRE.FindStringSubmatch(msg); m != nil {
+		return api.ErrCategoryHTTP2StreamReset + ":" + m[1]
+	}
+	if m := h2GoAwayRE.FindStringSubmatch(msg); m != nil {
+		return api.ErrCategoryHTTP2GoAway + ":" + m[1]
+	}
+
+	switch {
+	case isCrossHostRedirectErr(err):
+		return api.ErrCategoryCrossHostRedirect
+	case strings.Contains(msg, "malformed HTTP") || strings.Contains(msg, "malformed MIME"):
+		return api.ErrCategoryMalformedResponse
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return api.ErrCategoryUnexpectedEOF
+	// http.Client doesn't export the error returned by its default CheckRedirect
+	case strings.Contains(msg, "stopped after") && strings.Contains(msg, "redirects"):
+		return api.ErrCategoryTooManyRedirects
+	case errors.Is(err, context.Canceled) || strings.Contains(msg, "request canceled"):
+		return api.ErrCategoryRequestCanceled
+	}
+	return ""
+}
+
+// errCategory classifies a failed 'attempt'. It returns an empty string if the attempt
+// didn't fail or its failure isn't one that's categorized, e.g., a refused connection.
+// Cancellations aren't categorized if 'runDone' is true, i.e., when the requests
+// were cancelled because the run ended.
+func errCategory(attempt rqstAttempt, runDone bool) string {
+	if errors.Is(attempt.err, errInjectedFailure) {
+		return api.ErrCategoryInjectedFailure
+	}
+	if category := timeoutCategory(attempt); category != "" {
+		return category
+	}
+	err := attempt.err
+	if err == nil {
+		// The server closed the connection part way through the body
+		if errors.Is(attempt.bodyErr, io.ErrUnexpectedEOF) {
+			return api.ErrCategoryTruncatedResponse
+		}
+		err = attempt.bodyErr
+	}
+	if isResourceExhausted(attempt.err) {
+		return api.ErrCategoryResourceExhausted
+	}
+	category := protocolErrCategory(err)
+	if category == api.ErrCategoryRequestCanceled && runDone {
+		return ""
+	}
+	return category
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestProtocolErrCategories verifies that protocol failures returned by a server are
+// categorized individually, and that the requestor continues sending requests after them.
+func TestProtocolErrCategories(t *testing.T) {
+	// malformedSrv responds to every request with something that isn't HTTP
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4096)
+				conn.Read(buf)
+				conn.Write([]byte("this isn't HTTP\r\n\r\n"))
+			}()
+		}
+	}()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/truncated":
+			// The connection is closed before the entire body is sent
+			w.Header().Set("Content-Length", "100")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("short"))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		case "/redirect":
+			http.Redirect(w, r, "/redirect", http.StatusFound)
+		}
+	})
+	h1Srv := httptest.NewServer(handler)
+	defer h1Srv.Close()
+	h2Srv := httptest.NewUnstartedServer(handler)
+	h2Srv.EnableHTTP2 = true
+	h2Srv.StartTLS()
+	defer h2Srv.Close()
+
+	tests := []struct {
+		name             string
+		url              string
+		client           *http.Client
+		expectedCategory string
+	}{
+		{name: "MalformedResponse", url: "http://" + l.Addr().String() + "/", client: &http.Client{},
+			expectedCategory: api.ErrCategoryMalformedResponse},
+		{name: "TruncatedResponse", url: h1Srv.URL + "/truncated", client: &http.Client{},
+			expectedCategory: api.ErrCategoryTruncatedResponse},
+		{name: "TooManyRedirects", url: h1Srv.URL + "/redirect", client: &http.Client{},
+			expectedCategory: api.ErrCategoryTooManyRedirects},
+		{name: "HTTP2StreamReset", url: h2Srv.URL + "/truncated", client: h2Srv.Client(),
+			expectedCategory: api.ErrCategoryHTTP2StreamReset + ":INTERNAL_ERROR"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			numRqsts := 3
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    *tc.client,
+			}
+			rqstr.ProcessRqst(api.Endpoint{URL: tc.url, Method: http.MethodGet, RqstPercent: 100}, numRqsts, 0)
+			close(respC)
+
+			numResps := 0
+			for resp := range respC {
+				numResps++
+				if resp.ErrCategory != tc.expectedCategory {
+					t.Errorf("expected error category %s, got %s: %v", tc.expectedCategory, resp.ErrCategory, resp.Err)
+				}
+			}
+			if numResps != numRqsts {
+				t.Errorf("expected %d responses, got %d", numRqsts, numResps)
+			}
+		})
+	}
+}
+
+// TestHTTP2ErrCodes verifies that HTTP/2 stream reset and GOAWAY error codes are
+// extracted from the errors returned by the client.
+func TestHTTP2ErrCodes(t *testing.T) {
+	tests := []struct {
+		err              error
+		expectedCategory string
+	}{
+		{err: errors.New("stream error: stream ID 3; REFUSED_STREAM"), expectedCategory: "HTTP2StreamReset:REFUSED_STREAM"},
+		{err: fmt.Errorf("Get \"https://someurl/1\": %w", errors.New("stream error: stream ID 5; INTERNAL_ERROR; received from peer")),
+			expectedCategory: "HTTP2StreamReset:INTERNAL_ERROR"},
+		{err: errors.New("http2: server sent GOAWAY and closed the connection; LastStreamID=1, ErrCode=ENHANCE_YOUR_CALM, debug=\"\""),
+			expectedCategory: "HTTP2GoAway:ENHANCE_YOUR_CALM"},
+		{err: errors.New("dial tcp 127.0.0.1:1: connect: connection refused"), expectedCategory: ""},
+	}
+
+	for _, tc := range tests {
+		if category := protocolErrCategory(tc.err); category != tc.expectedCategory {
+			t.Errorf("expected %q to be categorized as %q, got %q", tc.err, tc.expectedCategory, category)
+		}
+	}
+}
+
+// TestTruncatedResponses verifies that responses whose body is shorter than their
+// Content-Length are counted as truncated, along with the bytes that were received.
+func TestTruncatedResponses(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer testSrv.Close()
+
+	numRqsts := 4
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    http.Client{},
+	}
+	rqstr.ProcessRqst(api.Endpoint{URL: testSrv.URL, Method: http.MethodGet, RqstPercent: 100}, numRqsts, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		if resp.ErrCategory != api.ErrCategoryTruncatedResponse {
+			t.Errorf("expected error category %s, got %q: %v", api.ErrCategoryTruncatedResponse, resp.ErrCategory, resp.Err)
+		}
+		if resp.BytesReceived != int64(len("partial")) {
+			t.Errorf("expected %d bytes received, got %d", len("partial"), resp.BytesReceived)
+		}
+		responses = append(responses, resp)
+	}
+
+	rh := ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	if runResults.RunSummary.ErrorCategories[api.ErrCategoryTruncatedResponse] != int64(numRqsts) {
+		t.Errorf("expected %d truncated responses, got %+v", numRqsts, runResults.RunSummary.ErrorCategories)
+	}
+	expectedBytes := int64(numRqsts * len("partial"))
+	if runResults.RunSummary.TruncatedResponseBytes != expectedBytes {
+		t.Errorf("expected %d truncated response bytes, got %d", expectedBytes, runResults.RunSummary.TruncatedResponseBytes)
+	}
+	if epDetail := runResults.EndpointDetails[testSrv.URL]; epDetail == nil || epDetail.TruncatedResponseBytes != expectedBytes {
+		t.Errorf("expected %d truncated response bytes for %s, got %+v", expectedBytes, testSrv.URL, epDetail)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// countingBody is a request body that counts the bytes read from it, i.e., the bytes
+// of the body the transport has written. The transport may write it from another
+// goroutine so it's counted atomically.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.n, int64(n))
+	return n, err
+}
+
+// count returns the number of bytes read from the body
+func (b *countingBody) count() int64 {
+	return atomic.LoadInt64(&b.n)
+}
+
+// requestWrite returns the time from 'gotConn', when the request's connection was
+// obtained and its headers started to be written, to 'wroteRqst', when the last byte
+// of its body was written, or 0 if either wasn't recorded. For a large upload it's
+// dominated by the time the server, or the network, took to accept the body.
+func requestWrite(gotConn, wroteRqst time.Time) time.Duration {
+	if gotConn.IsZero() || wroteRqst.Before(gotConn) {
+		return 0
+	}
+	return wroteRqst.Sub(gotConn)
+}
+
+// uploadThroughput returns the rate, in bytes per second, 'written' bytes of a request
+// body were written at over 'writeDuration', or 0 if either is 0
+func uploadThroughput(written int64, writeDuration time.Duration) float64 {
+	if written <= 0 || writeDuration <= 0 {
+		return 0
+	}
+	return float64(written) / writeDuration.Seconds()
+}
+
+// bytesPerMB converts bytes to the megabytes, 10^6 bytes, the upload throughput is
+// reported in
+const bytesPerMB = 1e6
+
+// accumulateUpload adds the request write duration and upload throughput of 'resp',
+// a successful response, to 'epDetail' if its request had a body whose write was
+// measured
+func accumulateUpload(resp Response, epDetail *api.EndpointDetail) {
+	if resp.UploadBytesPerSec <= 0 {
+		return
+	}
+	u := epDetail.Upload
+	if u == nil {
+		u = &api.UploadStats{MinMBPerSec: math.MaxFloat64}
+		epDetail.Upload = u
+	}
+	mbPerSec := resp.UploadBytesPerSec / bytesPerMB
+	u.Rqsts++
+	u.TotalBytes += resp.BytesUploaded
+	u.TotalWriteNanos += resp.RequestWriteDuration
+	if resp.RequestWriteDuration > u.MaxWriteNanos {
+		u.MaxWriteNanos = resp.RequestWriteDuration
+	}
+	if mbPerSec < u.MinMBPerSec {
+		u.MinMBPerSec = mbPerSec
+	}
+	if mbPerSec > u.MaxMBPerSec {
+		u.MaxMBPerSec = mbPerSec
+	}
+}
+
+// finishUpload calculates the averages of 'epDetail's Upload stats
+func finishUpload(epDetail *api.EndpointDetail) {
+	u := epDetail.Upload
+	if u == nil {
+		return
+	}
+	u.AvgWriteNanos = u.TotalWriteNanos / time.Duration(u.Rqsts)
+	u.AvgMBPerSec = float64(u.TotalBytes) / u.TotalWriteNanos.Seconds() / bytesPerMB
+}
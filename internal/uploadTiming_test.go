@@ -0,0 +1,98 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestUploadTiming verifies the time spent writing a large request body to a server
+// that's slow to read it is reported as the request's write duration, rather than as
+// server processing, along with its upload throughput
+func TestUploadTiming(t *testing.T) {
+	const readDelay = 300 * time.Millisecond
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body is larger than the connection's buffers so the client can't
+		// finish writing it until it's read
+		time.Sleep(readDelay)
+		io.Copy(ioutil.Discard, r.Body)
+	}))
+	defer testSrv.Close()
+
+	// 16MB
+	body := strings.Repeat("0123456789abcdef", 1<<20)
+	eps := []api.Endpoint{
+		{URL: testSrv.URL + "/upload", Method: http.MethodPost, RqstBody: body},
+		{URL: testSrv.URL + "/download", Method: http.MethodGet},
+	}
+	respC := make(chan Response, len(eps))
+	rqstr := Requestor{Ctx: context.Background(), ResponseC: respC, Client: http.Client{}}
+	for _, ep := range eps {
+		rqstr.ProcessRqst(ep, 1, 0)
+	}
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		responses = append(responses, resp)
+	}
+	upload := responses[0]
+	if upload.BytesUploaded != int64(len(body)) {
+		t.Errorf("expected %d bytes to be uploaded, got %d", len(body), upload.BytesUploaded)
+	}
+	if upload.RequestWriteDuration < readDelay*8/10 || upload.RequestWriteDuration <= upload.ServerProcessingDuration {
+		t.Errorf("expected the request write, %s, to dominate the server processing, %s", upload.RequestWriteDuration,
+			upload.ServerProcessingDuration)
+	}
+	if expected := float64(len(body)) / upload.RequestWriteDuration.Seconds(); math.Abs(upload.UploadBytesPerSec-expected) > 1 {
+		t.Errorf("expected an upload throughput of %f bytes/sec, got %f", expected, upload.UploadBytesPerSec)
+	}
+	if download := responses[1]; download.BytesUploaded != 0 || download.UploadBytesPerSec != 0 {
+		t.Errorf("expected no upload for a request without a body, got %d bytes at %f bytes/sec", download.BytesUploaded,
+			download.UploadBytesPerSec)
+	}
+
+	rh := &ResponseHandler{start: time.Now()}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	u := runResults.EndpointDetails[eps[0].URL].Upload
+	if u == nil || u.Rqsts != 1 || u.TotalBytes != int64(len(body)) || u.MaxWriteNanos != upload.RequestWriteDuration ||
+		math.Abs(u.AvgMBPerSec-upload.UploadBytesPerSec/bytesPerMB) > 1e-6 {
+		t.Errorf("expected the upload of 1 request to be summarized, got %+v", u)
+	}
+	if u := runResults.EndpointDetails[eps[1].URL].Upload; u != nil {
+		t.Errorf("expected no upload stats for an endpoint without a body, got %+v", u)
+	}
+}
+
+func TestUploadStats(t *testing.T) {
+	var epDetail api.EndpointDetail
+	for _, resp := range []Response{
+		{BytesUploaded: 4e6, RequestWriteDuration: 2 * time.Second, UploadBytesPerSec: 2e6},
+		{BytesUploaded: 6e6, RequestWriteDuration: time.Second, UploadBytesPerSec: 6e6},
+		{RequestWriteDuration: time.Millisecond},
+	} {
+		accumulateUpload(resp, &epDetail)
+	}
+	finishUpload(&epDetail)
+
+	expected := api.UploadStats{Rqsts: 2, TotalBytes: 10e6, TotalWriteNanos: 3 * time.Second, AvgWriteNanos: 1500 * time.Millisecond,
+		MaxWriteNanos: 2 * time.Second, MinMBPerSec: 2, AvgMBPerSec: 10.0 / 3, MaxMBPerSec: 6}
+	if epDetail.Upload == nil || *epDetail.Upload != expected {
+		t.Errorf("expected %+v, got %+v", expected, epDetail.Upload)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// warmupTimeout is how long the warmup requests to a host wait for each other to get
+// a connection before they're released, e.g., because some of them failed
+const warmupTimeout = 5 * time.Second
+
+// warmupHosts returns the URL of the root, '/', of each host of 'eps' whose
+// requests are sent on 'client's connections, in the order they're configured.
+// Endpoints that use their own connections, i.e., with a CertFile, UnixSocket, or
+// Variants, and endpoints whose host is templated, are skipped.
+func warmupHosts(eps []api.Endpoint) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, ep := range eps {
+		if ep.CertFile != "" || ep.UnixSocket != "" || len(ep.Variants) > 0 {
+			continue
+		}
+		u, err := url.Parse(ep.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.Contains(u.Host, "{{") {
+			log.Warn().Msgf("unable to warm up the connections to endpoint %s %s, its host can't be determined", ep.Method, ep.URL)
+			continue
+		}
+		root := u.Scheme + "://" + u.Host + "/"
+		if !seen[root] {
+			seen[root] = true
+			hosts = append(hosts, root)
+		}
+	}
+	return hosts
+}
+
+// WarmPool is the connections opened by WarmUp before the run started. It's shared by
+// all requestors, which record the connections the run's requests are sent on.
+type WarmPool struct {
+	mu     sync.Mutex
+	opened int64
+	// conns are the connections left open for the run, and whether any of the run's
+	// requests were sent on them
+	conns map[net.Conn]bool
+}
+
+// Opened returns the number of new connections opened. 'p' may be nil.
+func (p *WarmPool) Opened() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return int(p.opened)
+}
+
+// add adds 'conn', a connection that was left open by its warmup request, to the pool
+func (p *WarmPool) add(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conns[conn] = false
+}
+
+// used records that a request was sent on 'conn'. It returns true if 'conn' is one of
+// the pool's connections. 'p' may be nil.
+func (p *WarmPool) used(conn net.Conn) bool {
+	if p == nil || conn == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.conns[conn]; !ok {
+		return false
+	}
+	p.conns[conn] = true
+	return true
+}
+
+// summarize reports the pool's connections, and how many of them were used, in 'rs'.
+// 'p' may be nil.
+func (p *WarmPool) summarize(rs *api.RunSummary) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rs.WarmupConnections = p.opened
+	rs.WarmupReusableConnections = int64(len(p.conns))
+	for _, used := range p.conns {
+		if used {
+			rs.WarmConnectionsUsed++
+		}
+	}
+}
+
+// WarmUp opens 'n' connections to each of the hosts of 'eps' using 'client', before
+// the run starts, so that the run's requests don't include setting them up. Each
+// connection is opened by a 'method', HEAD if it's empty, or OPTIONS, request to the
+// host's root, '/', whose response is discarded. The connections are left idle in
+// 'client's Transport, which must keep at least 'n' idle connections per host. The
+// returned WarmPool has fewer than 'n' connections per host if, e.g., the host uses
+// HTTP/2 and so a single connection is shared by all its requests, or if a warmup
+// response closed its connection.
+func WarmUp(ctx context.Context, client http.Client, eps []api.Endpoint, n int, method string) (*WarmPool, error) {
+	switch method {
+	case "":
+		method = http.MethodHead
+	case http.MethodHead, http.MethodOptions:
+	default:
+		return nil, fmt.Errorf("WarmupMethod %q is invalid, it must be %s or %s", method, http.MethodHead, http.MethodOptions)
+	}
+	pool := &WarmPool{conns: make(map[net.Conn]bool)}
+	if n <= 0 {
+		return pool, nil
+	}
+	for _, host := range warmupHosts(eps) {
+		pool.opened += warmUpHost(ctx, client, host, n, method, pool)
+	}
+	return pool, nil
+}
+
+// warmUpHost opens 'n' connections to 'host' using 'client', adding those that are
+// left open to 'pool'. A warmup request that gets a connection holds on to it until
+// all 'n' requests have one, or until warmupTimeout expires, so that the requests
+// can't reuse each other's connections. It returns the number of new connections
+// opened.
+func warmUpHost(ctx context.Context, client http.Client, host string, n int, method string, pool *WarmPool) int64 {
+	var (
+		opened  int64
+		ready   sync.WaitGroup
+		done    sync.WaitGroup
+		release = make(chan struct{})
+	)
+	ready.Add(n)
+	done.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer done.Done()
+			var once sync.Once
+			gotConn := func() { once.Do(ready.Done) }
+			// A request that fails before getting a connection mustn't hold up the others
+			defer gotConn()
+
+			var conn net.Conn
+			trace := &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) {
+					if !info.Reused {
+						atomic.AddInt64(&opened, 1)
+						conn = info.Conn
+					}
+					gotConn()
+					<-release
+				},
+			}
+			req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), method, host, nil)
+			if err != nil {
+				log.Warn().Err(err).Msgf("unable to create a warmup request to %s", host)
+				return
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				log.Warn().Err(err).Msgf("warmup request to %s failed", host)
+				return
+			}
+			// The body must be read to the end for the connection to be reused
+			_, err = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			if conn != nil && err == nil && !resp.Close {
+				pool.add(conn)
+			}
+		}()
+	}
+
+	allReady := make(chan struct{})
+	go func() {
+		ready.Wait()
+		close(allReady)
+	}()
+	select {
+	case <-allReady:
+	case <-time.After(warmupTimeout):
+		log.Warn().Msgf("timed out waiting for %d warmup connections to %s", n, host)
+	case <-ctx.Done():
+	}
+	close(release)
+	done.Wait()
+
+	log.Debug().Msgf("opened %d warmup connections to %s", opened, host)
+	return opened
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import "time"
+
+// discardWarmup returns the 'responses' received after the run's WarmupDuration, the
+// start of the period they cover, 'start' moved to the end of the WarmupDuration if
+// it's during it, and the number of responses discarded. Responses that weren't
+// received by the ResponseHandler, so they have no Completed time, are kept.
+func (rh *ResponseHandler) discardWarmup(responses []Response, start time.Time) ([]Response, time.Time, int64) {
+	if rh.WarmupDuration <= 0 {
+		return responses, start, 0
+	}
+	warmupEnd := rh.start.Add(rh.WarmupDuration)
+	if start.Before(warmupEnd) {
+		start = warmupEnd
+		// The run, or the period, ended during the warmup
+		if now := time.Now(); start.After(now) {
+			start = now
+		}
+	}
+
+	kept := make([]Response, 0, len(responses))
+	var discarded int64
+	for _, resp := range responses {
+		if !resp.Completed.IsZero() && resp.Completed.Before(warmupEnd) {
+			discarded++
+			continue
+		}
+		kept = append(kept, resp)
+	}
+	return kept, start, discarded
+}
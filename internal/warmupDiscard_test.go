@@ -0,0 +1,63 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestWarmupDiscarded verifies the responses received during the WarmupDuration are
+// counted but not summarized, so the run's totals reconcile with the responses sent
+func TestWarmupDiscarded(t *testing.T) {
+	start := time.Now().Add(-2 * time.Second)
+	var responses []Response
+	for i := 0; i < 8; i++ {
+		completed := start.Add(500 * time.Millisecond)
+		if i >= 3 {
+			completed = start.Add(1500 * time.Millisecond)
+		}
+		responses = append(responses, Response{
+			Endpoint:        api.Endpoint{URL: "http://someurl", Method: http.MethodGet},
+			HTTPStatus:      http.StatusOK,
+			RequestDuration: 10 * time.Millisecond,
+			Completed:       completed,
+		})
+	}
+
+	tests := []struct {
+		name              string
+		warmup            time.Duration
+		expectedDiscarded int64
+		maxRunDuration    time.Duration
+	}{
+		{name: "warmup", warmup: time.Second, expectedDiscarded: 3, maxRunDuration: 1500 * time.Millisecond},
+		{name: "no warmup", expectedDiscarded: 0, maxRunDuration: 3 * time.Second},
+		{name: "run shorter than warmup", warmup: time.Minute, expectedDiscarded: 8, maxRunDuration: time.Millisecond},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rh := &ResponseHandler{start: start, WarmupDuration: tc.warmup}
+			runResults, err := rh.summarize(responses, rh.start)
+			if err != nil {
+				t.Fatalf("unexpected error summarizing responses: %s", err)
+			}
+			rs := runResults.RunSummary
+			if rs.WarmupDiscarded != tc.expectedDiscarded {
+				t.Errorf("expected %d responses to be discarded, got %d", tc.expectedDiscarded, rs.WarmupDiscarded)
+			}
+			if rs.RqstStats.TotalRqsts+rs.WarmupDiscarded != int64(len(responses)) {
+				t.Errorf("expected %d total requests and %d discarded to add up to the %d responses", rs.RqstStats.TotalRqsts,
+					rs.WarmupDiscarded, len(responses))
+			}
+			if rs.RunDurationNanos < 0 || rs.RunDurationNanos > tc.maxRunDuration {
+				t.Errorf("expected a run duration, not including the warmup, of at most %s, got %s", tc.maxRunDuration, rs.RunDurationNanos)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestWarmUp verifies the warmup connections are opened before the run and that
+// the run's requests reuse them from the first request
+func TestWarmUp(t *testing.T) {
+	var newConns int64
+	testSrv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	testSrv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&newConns, 1)
+		}
+	}
+	testSrv.Start()
+	defer testSrv.Close()
+
+	warmupConns := 4
+	client := http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: warmupConns}}
+	ep := api.Endpoint{URL: testSrv.URL + "/users/1", Method: http.MethodGet}
+	pool, err := WarmUp(context.Background(), client, []api.Endpoint{ep, ep}, warmupConns, "")
+	if err != nil {
+		t.Fatalf("unexpected error warming up: %s", err)
+	}
+	if opened := pool.Opened(); opened != warmupConns {
+		t.Errorf("expected %d warmup connections, got %d", warmupConns, opened)
+	}
+	if n := atomic.LoadInt64(&newConns); n != int64(warmupConns) {
+		t.Errorf("expected the server to accept %d connections, got %d", warmupConns, n)
+	}
+
+	numRqsts := 10
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    client,
+		WarmPool:  pool,
+	}
+	rqstr.ProcessRqst(ep, numRqsts, 0)
+	close(respC)
+
+	var responses []Response
+	for resp := range respC {
+		if !resp.ConnReused || !resp.WarmConn {
+			t.Errorf("expected every request to reuse a warmup connection, request %d didn't", len(responses)+1)
+		}
+		responses = append(responses, resp)
+	}
+	rh := ResponseHandler{start: time.Now(), WarmPool: pool}
+	runResults, err := rh.summarize(responses, rh.start)
+	if err != nil {
+		t.Fatalf("unexpected error summarizing responses: %s", err)
+	}
+	rs := runResults.RunSummary
+	if rs.NewConnections != 0 || rs.ReusedConnections != int64(numRqsts) || rs.WarmupConnections != int64(warmupConns) {
+		t.Errorf("expected 0 new, %d reused, and %d warmup connections, got %d, %d, and %d", numRqsts, warmupConns,
+			rs.NewConnections, rs.ReusedConnections, rs.WarmupConnections)
+	}
+	if rs.WarmupReusableConnections != int64(warmupConns) || rs.WarmConnectionRqsts != int64(numRqsts) ||
+		rs.WarmConnectionsUsed < 1 || rs.WarmConnectionsUsed > int64(warmupConns) {
+		t.Errorf("expected %d reusable warmup connections used by %d requests, got %+v", warmupConns, numRqsts, rs)
+	}
+}
+
+// TestWarmUpMethod verifies the connections are warmed up with the WarmupMethod, and
+// that connections closed by their warmup response aren't counted as reusable
+func TestWarmUpMethod(t *testing.T) {
+	var methods sync.Map
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods.Store(r.Method, true)
+	}))
+	defer testSrv.Close()
+	closingSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+	}))
+	defer closingSrv.Close()
+
+	tests := []struct {
+		name             string
+		method           string
+		url              string
+		expectReusable   int64
+		expectErr        bool
+		expectMethodSeen string
+	}{
+		{name: "head", url: testSrv.URL, expectReusable: 2, expectMethodSeen: http.MethodHead},
+		{name: "options", method: http.MethodOptions, url: testSrv.URL, expectReusable: 2, expectMethodSeen: http.MethodOptions},
+		{name: "closed", url: closingSrv.URL, expectReusable: 0, expectMethodSeen: http.MethodHead},
+		{name: "invalid", method: http.MethodGet, url: testSrv.URL, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 2}}
+			ep := api.Endpoint{URL: tc.url, Method: http.MethodGet}
+			pool, err := WarmUp(context.Background(), client, []api.Endpoint{ep}, 2, tc.method)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			if tc.expectErr {
+				return
+			}
+			var rs api.RunSummary
+			pool.summarize(&rs)
+			if rs.WarmupConnections != 2 || rs.WarmupReusableConnections != tc.expectReusable {
+				t.Errorf("expected 2 warmup connections, %d reusable, got %d and %d", tc.expectReusable,
+					rs.WarmupConnections, rs.WarmupReusableConnections)
+			}
+			if _, ok := methods.Load(tc.expectMethodSeen); !ok {
+				t.Errorf("expected the warmup requests to be %s requests", tc.expectMethodSeen)
+			}
+		})
+	}
+}
+
+// TestWarmupHosts verifies each host is warmed up once and that endpoints that don't
+// use the shared connections, or whose host can't be determined, are skipped
+func TestWarmupHosts(t *testing.T) {
+	eps := []api.Endpoint{
+		{URL: "http://accountd.kube/users/1"},
+		{URL: "http://accountd.kube:8080/users"},
+		{URL: "https://accountd.kube/users/2"},
+		{URL: "http://accountd.kube/users/2"},
+		{URL: "http://{{ .Host }}/users"},
+		{URL: "http://certs.kube/", CertFile: "cert.pem"},
+		{URL: "http://localhost/users", UnixSocket: "/tmp/accountd.sock"},
+		{URL: "http://variants.kube/", Variants: []api.EndpointVariant{{Name: "canary"}}},
+	}
+	expected := []string{"http://accountd.kube/", "http://accountd.kube:8080/", "https://accountd.kube/"}
+	if hosts := warmupHosts(eps); !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected %v, got %v", expected, hosts)
+	}
+}
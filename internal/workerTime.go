@@ -0,0 +1,81 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// WorkerTimeAccounting accounts for where the requestor goroutines, the workers,
+// spent their time, to explain why a run achieved less than its configured rate.
+// Each worker accumulates its own workerTime, without synchronization, and merges it
+// into the WorkerTimeAccounting when it's done.
+type WorkerTimeAccounting struct {
+	mu      sync.Mutex
+	workers int
+	total   time.Duration
+	sum     workerTime
+}
+
+// NewWorkerTimeAccounting returns an empty WorkerTimeAccounting
+func NewWorkerTimeAccounting() *WorkerTimeAccounting {
+	return &WorkerTimeAccounting{}
+}
+
+// workerTime is where a single worker spent its time, see api.WorkerTime
+type workerTime struct {
+	start         time.Time
+	inFlight      time.Duration
+	pacing        time.Duration
+	rateLimitWait time.Duration
+	lockGroupWait time.Duration
+}
+
+// add merges 'w', the time of a worker that's just finished, into 'a'. 'a' may be nil.
+func (a *WorkerTimeAccounting) add(w *workerTime) {
+	if a == nil {
+		return
+	}
+	total := time.Since(w.start)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.workers++
+	a.total += total
+	a.sum.inFlight += w.inFlight
+	a.sum.pacing += w.pacing
+	a.sum.rateLimitWait += w.rateLimitWait
+	a.sum.lockGroupWait += w.lockGroupWait
+}
+
+// summary returns the time of the workers that have finished, or nil if none have
+func (a *WorkerTimeAccounting) summary() *api.WorkerTime {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.workers == 0 {
+		return nil
+	}
+	share := func(d time.Duration) api.TimeShare {
+		return api.TimeShare{Nanos: d, Percent: 100 * float64(d) / float64(a.total)}
+	}
+	idle := a.total - a.sum.inFlight - a.sum.pacing - a.sum.rateLimitWait - a.sum.lockGroupWait
+	if idle < 0 {
+		idle = 0
+	}
+	return &api.WorkerTime{
+		Workers:       a.workers,
+		TotalNanos:    a.total,
+		InFlight:      share(a.sum.inFlight),
+		Pacing:        share(a.sum.pacing),
+		RateLimitWait: share(a.sum.rateLimitWait),
+		LockGroupWait: share(a.sum.lockGroupWait),
+		Idle:          share(idle),
+	}
+}
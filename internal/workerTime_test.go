@@ -0,0 +1,95 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// TestWorkerTime verifies the workers' time is dominated by waiting for responses
+// when the server is slow, and by pacing when the requests are throttled
+func TestWorkerTime(t *testing.T) {
+	slowSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("OK"))
+	}))
+	defer slowSrv.Close()
+	fastSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer fastSrv.Close()
+
+	tests := []struct {
+		name string
+		url  string
+		// rqstRate is each requestor's requests per second, 0 is unthrottled
+		rqstRate float64
+		// dominant returns the share expected to dominate
+		dominant func(wt *api.WorkerTime) api.TimeShare
+	}{
+		{name: "slow server", url: slowSrv.URL, dominant: func(wt *api.WorkerTime) api.TimeShare { return wt.InFlight }},
+		{name: "throttled", url: fastSrv.URL, rqstRate: 20, dominant: func(wt *api.WorkerTime) api.TimeShare { return wt.Pacing }},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			concurrency := 2
+			numRqsts := 5
+			accounting := NewWorkerTimeAccounting()
+			rqstr := Requestor{
+				Ctx:        context.Background(),
+				ResponseC:  make(chan Response, concurrency*numRqsts),
+				Client:     http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: concurrency}},
+				WorkerTime: accounting,
+			}
+			ep := api.Endpoint{URL: tc.url, Method: http.MethodGet}
+
+			var wg sync.WaitGroup
+			for i := 0; i < concurrency; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rqstr.ProcessRqst(ep, numRqsts, tc.rqstRate)
+				}()
+			}
+			wg.Wait()
+
+			wt := accounting.summary()
+			if wt == nil {
+				t.Fatal("expected the workers' time to be accounted for")
+			}
+			if wt.Workers != concurrency {
+				t.Errorf("expected %d workers, got %d", concurrency, wt.Workers)
+			}
+			if share := tc.dominant(wt); share.Percent < 80 {
+				t.Errorf("expected the dominant share to be at least 80%%, got %+v", wt)
+			}
+			total := wt.InFlight.Nanos + wt.Pacing.Nanos + wt.RateLimitWait.Nanos + wt.LockGroupWait.Nanos + wt.Idle.Nanos
+			if total != wt.TotalNanos {
+				t.Errorf("expected the shares to add up to the total, %s, got %s", wt.TotalNanos, total)
+			}
+		})
+	}
+}
+
+// TestWorkerTimeNil verifies a nil WorkerTimeAccounting, i.e., one that isn't
+// configured, is safe to use and isn't reported
+func TestWorkerTimeNil(t *testing.T) {
+	var accounting *WorkerTimeAccounting
+	accounting.add(&workerTime{start: time.Now()})
+	if wt := accounting.summary(); wt != nil {
+		t.Errorf("expected no worker time, got %+v", wt)
+	}
+	if wt := NewWorkerTimeAccounting().summary(); wt != nil {
+		t.Errorf("expected no worker time before any workers finish, got %+v", wt)
+	}
+}